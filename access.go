@@ -0,0 +1,52 @@
+package game
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// Access is the access control list of the peers that join without a
+// guest token. They are granted at most Permissions, which the service
+// decides rather than the client: the permissions a client asks for only
+// narrow them. Their Hotkeys are intercepted and mapped to actions.
+type Access struct {
+	Permissions Permissions
+	Hotkeys     []*Hotkey
+}
+
+func (cfg *Access) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Permissions string    `yaml:"permissions"`
+		Hotkeys     []*Hotkey `yaml:"hotkeys"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Permissions == "" {
+		raw.Permissions = "all"
+	}
+
+	perms, err := ParsePermissions(raw.Permissions)
+	if err != nil {
+		return err
+	}
+
+	cfg.Permissions = perms
+	cfg.Hotkeys = raw.Hotkeys
+
+	return nil
+}
+
+// defaultAccess grants everything, as before access was configurable.
+var defaultAccess = &Access{
+	Permissions: PermissionAll,
+}
+
+func (svc *service) access() *Access {
+	if a := svc.cfg.Access; a != nil {
+		return a
+	}
+
+	return defaultAccess
+}
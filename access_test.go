@@ -0,0 +1,36 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *Access
+	err := yaml.Unmarshal([]byte("{}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(PermissionAll, cfg.Permissions)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("permissions: gamepad,mouse"), &cfg)
+	assert.NoError(err)
+	assert.Equal(PermissionGamepad|PermissionMouse, cfg.Permissions)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("hotkeys: [{combo: 'pad:back+start', action: stats}]"), &cfg)
+	if assert.NoError(err) && assert.Len(cfg.Hotkeys, 1) {
+		assert.Equal(ButtonBack|ButtonStart, cfg.Hotkeys[0].Buttons)
+	}
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("permissions: root"), &cfg)
+	assert.Error(err)
+
+	// without an access list peers get what they ask for
+	svc := &service{cfg: new(Config)}
+	assert.Equal(PermissionAll, svc.access().Permissions)
+}
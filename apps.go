@@ -0,0 +1,77 @@
+package game
+
+import (
+	"context"
+	"errors"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+// App is an app of an NVStream host, which a launcher lists with its box
+// art.
+type App struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	HDRSupported bool   `json:"hdr_supported"`
+	Running      bool   `json:"running"` // streamed by the stream
+}
+
+// nvHost connects to the host of an NVStream stream, running or stopped.
+func (svc *service) nvHost(ctx context.Context, name string) (*Stream, nvstream.NvHTTP, error) {
+	stream, err := svc.FindStream(ctx, name)
+	if err != nil {
+		svc.RLock()
+		stopped, ok := svc.stopped[name]
+		svc.RUnlock()
+
+		if !ok {
+			return nil, nil, err
+		}
+
+		stream = stopped
+	}
+
+	if stream.Transport != TransportNV {
+		return nil, nil, errors.New("apps require an nvstream stream: " + name)
+	}
+
+	http, err := svc.newNvHTTP(stream.Address())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return stream, http, nil
+}
+
+func (svc *service) Apps(ctx context.Context, name string) ([]*App, error) {
+	stream, http, err := svc.nvHost(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := http.AppList()
+	if err != nil {
+		return nil, err
+	}
+
+	apps := make([]*App, 0, len(list))
+	for _, app := range list {
+		apps = append(apps, &App{
+			ID:           app.ID,
+			Name:         app.Name,
+			HDRSupported: app.IsHDRSupported(),
+			Running:      stream.conn != nil && stream.NVStream.App.ID == app.ID,
+		})
+	}
+
+	return apps, nil
+}
+
+func (svc *service) AppBoxArt(ctx context.Context, name string, appID int) ([]byte, error) {
+	_, http, err := svc.nvHost(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.AppBoxArt(appID)
+}
@@ -0,0 +1,282 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// ChannelHighWater is the buffered amount above which a data channel
+	// is considered congested. It is cleared below half of it.
+	ChannelHighWater uint64 = 1 << 20
+
+	// ChannelMaxBuffered is the buffered amount above which even messages
+	// that cannot be dropped are refused.
+	ChannelMaxBuffered uint64 = 8 << 20
+)
+
+var ErrChannelCongested = errors.New("data channel congested")
+
+// dataChannel is the part of *webrtc.DataChannel used for sending.
+type dataChannel interface {
+	Label() string
+	ReadyState() webrtc.DataChannelState
+	Send(data []byte) error
+	SendText(s string) error
+	BufferedAmount() uint64
+	SetBufferedAmountLowThreshold(th uint64)
+	OnBufferedAmountLow(f func())
+}
+
+type ChannelStats struct {
+	Label          string `json:"label"`
+	Received       uint64 `json:"received"`
+	ReceivedBytes  uint64 `json:"received_bytes"`
+	DecodeErrors   uint64 `json:"decode_errors"`
+	Discarded      uint64 `json:"discarded"`
+	Sent           uint64 `json:"sent"`
+	SentBytes      uint64 `json:"sent_bytes"`
+	Dropped        uint64 `json:"dropped"`
+	Refused        uint64 `json:"refused"`
+	Congestions    uint64 `json:"congestions"`
+	BufferedAmount uint64 `json:"buffered_amount"`
+	PeakBuffered   uint64 `json:"peak_buffered"`
+	Congested      bool   `json:"congested"`
+}
+
+// monitoredChannel tracks the SCTP send buffer of a data channel.
+// Droppable messages (progress, stats, echoes) are skipped while the
+// channel is congested; other messages are only refused once the buffer
+// exceeds ChannelMaxBuffered, so memory stays bounded on slow links. It
+// also counts the messages received, which metrics adds up by label.
+type monitoredChannel struct {
+	dc      dataChannel
+	log     *zap.Logger
+	metrics *labelMetrics
+
+	received      atomic.Uint64
+	receivedBytes atomic.Uint64
+	decodeErrors  atomic.Uint64
+	discarded     atomic.Uint64
+
+	congested   atomic.Bool
+	sent        atomic.Uint64
+	sentBytes   atomic.Uint64
+	dropped     atomic.Uint64
+	refused     atomic.Uint64
+	congestions atomic.Uint64
+	peak        atomic.Uint64
+
+	threshold uint64
+	onLow     []func()
+	onClose   []func()
+	sync.Mutex
+}
+
+func newMonitoredChannel(dc dataChannel, log *zap.Logger) *monitoredChannel {
+	m := &monitoredChannel{
+		dc: dc,
+		log: log.With(
+			zap.String("label", dc.Label()),
+		),
+		threshold: ChannelHighWater / 2,
+	}
+
+	dc.SetBufferedAmountLowThreshold(m.threshold)
+	dc.OnBufferedAmountLow(m.bufferedAmountLow)
+
+	return m
+}
+
+func (m *monitoredChannel) Label() string {
+	return m.dc.Label()
+}
+
+func (m *monitoredChannel) Open() bool {
+	return m.dc.ReadyState() == webrtc.DataChannelStateOpen
+}
+
+func (m *monitoredChannel) Congested() bool {
+	return m.congested.Load()
+}
+
+func (m *monitoredChannel) Send(data []byte) error {
+	if m.dc.BufferedAmount() > ChannelMaxBuffered {
+		m.refused.Add(1)
+		return ErrChannelCongested
+	}
+
+	if err := m.dc.Send(data); err != nil {
+		return err
+	}
+
+	m.sent.Add(1)
+	m.sentBytes.Add(uint64(len(data)))
+	m.metrics.send(len(data))
+	m.update()
+
+	return nil
+}
+
+func (m *monitoredChannel) SendText(s string) error {
+	if m.dc.BufferedAmount() > ChannelMaxBuffered {
+		m.refused.Add(1)
+		return ErrChannelCongested
+	}
+
+	if err := m.dc.SendText(s); err != nil {
+		return err
+	}
+
+	m.sent.Add(1)
+	m.sentBytes.Add(uint64(len(s)))
+	m.metrics.send(len(s))
+	m.update()
+
+	return nil
+}
+
+// TrySendText sends the message unless the channel is congested and
+// reports whether it was sent.
+func (m *monitoredChannel) TrySendText(s string) bool {
+	if m.Congested() {
+		m.dropped.Add(1)
+		m.metrics.drop()
+		return false
+	}
+
+	return m.SendText(s) == nil
+}
+
+func (m *monitoredChannel) SendJSON(v any) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return m.SendText(string(bs))
+}
+
+func (m *monitoredChannel) TrySendJSON(v any) bool {
+	if m.Congested() {
+		m.dropped.Add(1)
+		m.metrics.drop()
+		return false
+	}
+
+	return m.SendJSON(v) == nil
+}
+
+func (m *monitoredChannel) BufferedAmount() uint64 {
+	return m.dc.BufferedAmount()
+}
+
+// SetBufferedAmountLowThreshold lowers the shared threshold; callers that
+// need an earlier wakeup than the congestion watermark get it.
+func (m *monitoredChannel) SetBufferedAmountLowThreshold(th uint64) {
+	m.Lock()
+	defer m.Unlock()
+
+	if th < m.threshold {
+		m.threshold = th
+		m.dc.SetBufferedAmountLowThreshold(th)
+	}
+}
+
+// OnBufferedAmountLow adds a callback; the monitor owns the channel's
+// single callback slot and fans it out.
+func (m *monitoredChannel) OnBufferedAmountLow(f func()) {
+	m.Lock()
+	m.onLow = append(m.onLow, f)
+	m.Unlock()
+}
+
+// OnClose adds a callback run once the channel closes; the peer owns the
+// channel's single callback slot and calls closed.
+func (m *monitoredChannel) OnClose(f func()) {
+	m.Lock()
+	m.onClose = append(m.onClose, f)
+	m.Unlock()
+}
+
+func (m *monitoredChannel) closed() {
+	m.Lock()
+	handlers := m.onClose
+	m.onClose = nil
+	m.Unlock()
+
+	for _, f := range handlers {
+		f()
+	}
+}
+
+func (m *monitoredChannel) update() {
+	buffered := m.dc.BufferedAmount()
+
+	for {
+		peak := m.peak.Load()
+		if buffered <= peak || m.peak.CompareAndSwap(peak, buffered) {
+			break
+		}
+	}
+
+	if buffered > ChannelHighWater && m.congested.CompareAndSwap(false, true) {
+		m.congestions.Add(1)
+		m.log.Warn("data channel congested", zap.Uint64("buffered_amount", buffered))
+	}
+}
+
+func (m *monitoredChannel) bufferedAmountLow() {
+	if m.congested.CompareAndSwap(true, false) {
+		m.log.Info("data channel drained", zap.Uint64("dropped", m.dropped.Load()))
+	}
+
+	m.Lock()
+	handlers := m.onLow
+	m.Unlock()
+
+	for _, f := range handlers {
+		f()
+	}
+}
+
+// receive passes a received message to handle, counting it and whether it
+// was malformed or discarded.
+func (m *monitoredChannel) receive(msg webrtc.DataChannelMessage, handle MessageHandler) {
+	m.received.Add(1)
+	m.receivedBytes.Add(uint64(len(msg.Data)))
+	m.metrics.receive(len(msg.Data))
+
+	switch err := handle(msg); {
+	case errors.Is(err, ErrMalformedMessage):
+		m.decodeErrors.Add(1)
+		m.metrics.decodeError()
+
+	case errors.Is(err, ErrDiscardedMessage):
+		m.discarded.Add(1)
+		m.metrics.discard()
+	}
+}
+
+func (m *monitoredChannel) Stats() ChannelStats {
+	return ChannelStats{
+		Label:          m.dc.Label(),
+		Received:       m.received.Load(),
+		ReceivedBytes:  m.receivedBytes.Load(),
+		DecodeErrors:   m.decodeErrors.Load(),
+		Discarded:      m.discarded.Load(),
+		Sent:           m.sent.Load(),
+		SentBytes:      m.sentBytes.Load(),
+		Dropped:        m.dropped.Load(),
+		Refused:        m.refused.Load(),
+		Congestions:    m.congestions.Load(),
+		BufferedAmount: m.dc.BufferedAmount(),
+		PeakBuffered:   m.peak.Load(),
+		Congested:      m.congested.Load(),
+	}
+}
@@ -0,0 +1,80 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type fakeDataChannel struct {
+	buffered  uint64
+	threshold uint64
+	low       func()
+	texts     []string
+}
+
+func (dc *fakeDataChannel) Label() string { return "test" }
+
+func (dc *fakeDataChannel) ReadyState() webrtc.DataChannelState {
+	return webrtc.DataChannelStateOpen
+}
+
+func (dc *fakeDataChannel) Send(data []byte) error {
+	dc.buffered += uint64(len(data))
+	return nil
+}
+
+func (dc *fakeDataChannel) SendText(s string) error {
+	dc.texts = append(dc.texts, s)
+	dc.buffered += uint64(len(s))
+	return nil
+}
+
+func (dc *fakeDataChannel) BufferedAmount() uint64 { return dc.buffered }
+
+func (dc *fakeDataChannel) SetBufferedAmountLowThreshold(th uint64) { dc.threshold = th }
+
+func (dc *fakeDataChannel) OnBufferedAmountLow(f func()) { dc.low = f }
+
+func (dc *fakeDataChannel) drain() {
+	dc.buffered = 0
+	dc.low()
+}
+
+func TestMonitoredChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := new(fakeDataChannel)
+	mc := newMonitoredChannel(dc, zap.NewNop())
+
+	assert.Equal(ChannelHighWater/2, dc.threshold)
+	assert.True(mc.TrySendText("progress"))
+	assert.False(mc.Congested())
+
+	// a large write pushes the channel over the high-water mark
+	assert.NoError(mc.Send(make([]byte, ChannelHighWater+1)))
+	assert.True(mc.Congested())
+
+	assert.False(mc.TrySendText("progress"))
+	assert.NoError(mc.SendText("result"))
+
+	// essential messages are refused beyond the hard limit
+	dc.buffered = ChannelMaxBuffered + 1
+	assert.ErrorIs(mc.SendText("result"), ErrChannelCongested)
+
+	var woken bool
+	mc.OnBufferedAmountLow(func() { woken = true })
+
+	dc.drain()
+	assert.False(mc.Congested())
+	assert.True(woken)
+
+	stats := mc.Stats()
+	assert.Equal(uint64(3), stats.Sent)
+	assert.Equal(uint64(1), stats.Dropped)
+	assert.Equal(uint64(1), stats.Refused)
+	assert.Equal(uint64(1), stats.Congestions)
+	assert.Equal([]string{"progress", "result"}, dc.texts)
+}
@@ -0,0 +1,99 @@
+package game
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+	"gopkg.in/yaml.v3"
+)
+
+// Camera plays the video of peers with the camera permission on a virtual
+// camera of the host, e.g. a v4l2loopback device, or a softcam fed by an
+// ffmpeg output. Each peer's H.264, VP8 or AV1 track is decoded by its own
+// ffmpeg process, writing frames of PixelFormat to Device in the ffmpeg
+// output Format.
+type Camera struct {
+	Enabled     bool
+	FFmpeg      string
+	Format      string
+	Device      string
+	PixelFormat string
+}
+
+func (cfg *Camera) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Enabled     bool   `yaml:"enabled"`
+		FFmpeg      string `yaml:"ffmpeg"`
+		Format      string `yaml:"format"`
+		Device      string `yaml:"device"`
+		PixelFormat string `yaml:"pixelFormat"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Enabled && (raw.Format == "" || raw.Device == "") {
+		return errors.New("camera requires a format and a device")
+	}
+
+	if raw.FFmpeg == "" {
+		raw.FFmpeg = "ffmpeg"
+	}
+
+	if raw.PixelFormat == "" {
+		raw.PixelFormat = "yuv420p"
+	}
+
+	cfg.Enabled = raw.Enabled
+	cfg.FFmpeg = raw.FFmpeg
+	cfg.Format = raw.Format
+	cfg.Device = raw.Device
+	cfg.PixelFormat = raw.PixelFormat
+
+	return nil
+}
+
+// play decodes the video packets of track, of the MIME type, to the device
+// until the track ends or the ffmpeg process exits.
+func (cam *Camera) play(track rtpReader, mimeType string) error {
+	var (
+		input     string
+		newWriter func(io.Writer) (rtpWriter, error)
+	)
+
+	switch {
+	case strings.EqualFold(mimeType, CodecH264.MimeType()):
+		input = "h264"
+		newWriter = func(w io.Writer) (rtpWriter, error) {
+			return h264writer.NewWith(w), nil
+		}
+
+	case strings.EqualFold(mimeType, CodecVP8.MimeType()),
+		strings.EqualFold(mimeType, CodecAV1.MimeType()):
+		input = "ivf"
+		newWriter = func(w io.Writer) (rtpWriter, error) {
+			if strings.EqualFold(mimeType, CodecAV1.MimeType()) {
+				return ivfwriter.NewWith(w, ivfwriter.WithCodec(CodecAV1.MimeType()))
+			}
+
+			return ivfwriter.NewWith(w)
+		}
+
+	default:
+		return errors.New("camera codec unsupported: " + mimeType)
+	}
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-fflags", "nobuffer",
+		"-f", input, "-i", "pipe:0",
+		"-pix_fmt", cam.PixelFormat,
+		"-f", cam.Format, cam.Device,
+	}
+
+	return playTrack(cam.FFmpeg, args, track, newWriter)
+}
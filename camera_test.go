@@ -0,0 +1,96 @@
+package game
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCameraUnmarshalYAML(t *testing.T) {
+	assert := assert.New(t)
+
+	var cam Camera
+	err := yaml.Unmarshal([]byte("enabled: true\nformat: v4l2\ndevice: /dev/video10"), &cam)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(Camera{
+		Enabled:     true,
+		FFmpeg:      "ffmpeg",
+		Format:      "v4l2",
+		Device:      "/dev/video10",
+		PixelFormat: "yuv420p",
+	}, cam)
+
+	err = yaml.Unmarshal([]byte("enabled: true\ndevice: /dev/video10"), &cam)
+	assert.Error(err)
+}
+
+func TestCameraPlay(t *testing.T) {
+	assert := assert.New(t)
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "device")
+
+	// Takes the place of ffmpeg, writing its input to the device.
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\nfor last; do :; done\ncat > \"$last\"\n"
+	if err := os.WriteFile(ffmpeg, []byte(script), 0o755); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	cam := &Camera{Enabled: true, FFmpeg: ffmpeg, Format: "v4l2", Device: out, PixelFormat: "yuv420p"}
+
+	sps := []byte{0x67, 0x42, 0xC0, 0x1E}
+	track := &fakeRTPReader{packets: []*rtp.Packet{
+		{Header: rtp.Header{SequenceNumber: 1}, Payload: sps},
+		{Header: rtp.Header{SequenceNumber: 2, Marker: true}, Payload: []byte{0x65, 0x88, 0x84}},
+	}}
+
+	if err := cam.play(track, webrtc.MimeTypeH264); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	bs, err := os.ReadFile(out)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(bytes.HasPrefix(bs, append([]byte{0, 0, 0, 1}, sps...)))
+
+	track.packets = []*rtp.Packet{
+		{Payload: []byte{0x90}}, // skipped
+		{Header: rtp.Header{Marker: true}, Payload: []byte{0x10, 0x00, 0x9D, 0x01, 0x2A}},
+	}
+
+	if err := cam.play(track, webrtc.MimeTypeVP8); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	bs, err = os.ReadFile(out)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(bytes.HasPrefix(bs, []byte("DKIF")))
+
+	assert.ErrorContains(cam.play(track, webrtc.MimeTypeVP9), "unsupported")
+}
@@ -0,0 +1,128 @@
+package game
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// MaxDataChannels limits the data channels a peer may have open, so a
+// client cannot exhaust the server with channels.
+const MaxDataChannels = 16
+
+// Message handlers return ErrMalformedMessage for messages that fail to
+// decode and ErrDiscardedMessage for valid messages that were not delivered,
+// e.g. input of a peer not in control; both are counted per label.
+var (
+	ErrMalformedMessage = errors.New("malformed message")
+	ErrDiscardedMessage = errors.New("message discarded")
+)
+
+type MessageHandler func(msg webrtc.DataChannelMessage) error
+
+// ChannelOpenFunc sets up a data channel a peer opened and returns the
+// handler of its messages. An error rejects the channel, with the error as
+// the reason.
+type ChannelOpenFunc func(peer *Peer, dc *webrtc.DataChannel) (MessageHandler, error)
+
+// DataChannelRouter maps data channel labels to the subsystems serving
+// them. Labels without a route are rejected unless a wildcard route "*" is
+// registered.
+type DataChannelRouter struct {
+	routes map[string]ChannelOpenFunc
+	sync.RWMutex
+}
+
+func NewDataChannelRouter() *DataChannelRouter {
+	return &DataChannelRouter{
+		routes: make(map[string]ChannelOpenFunc),
+	}
+}
+
+// Handle registers open for the label, replacing any previous route.
+func (r *DataChannelRouter) Handle(label string, open ChannelOpenFunc) {
+	r.Lock()
+	r.routes[label] = open
+	r.Unlock()
+}
+
+// HandleMessages registers a label whose messages need no per-channel
+// state.
+func (r *DataChannelRouter) HandleMessages(label string, handle func(peer *Peer, data []byte) error) {
+	r.Handle(label, func(peer *Peer, dc *webrtc.DataChannel) (MessageHandler, error) {
+		return func(msg webrtc.DataChannelMessage) error {
+			return handle(peer, msg.Data)
+		}, nil
+	})
+}
+
+// Route returns the route of the label, falling back to the wildcard.
+func (r *DataChannelRouter) Route(label string) (ChannelOpenFunc, bool) {
+	_, open, ok := r.match(label)
+	return open, ok
+}
+
+// match also returns the label of the route, which is "*" for labels
+// served by the wildcard.
+func (r *DataChannelRouter) match(label string) (string, ChannelOpenFunc, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if open, ok := r.routes[label]; ok {
+		return label, open, true
+	}
+
+	open, ok := r.routes["*"]
+	return "*", open, ok
+}
+
+// defaultDataChannelRouter routes the channels of the client protocol.
+func defaultDataChannelRouter() *DataChannelRouter {
+	r := NewDataChannelRouter()
+
+	r.HandleMessages("gamepad", (*Peer).gamepadHandler)
+	r.HandleMessages("motion", (*Peer).motionHandler)
+	r.HandleMessages("keyboard", (*Peer).keyboardHandler)
+	r.HandleMessages("mouse", (*Peer).mouseHandler)
+	r.HandleMessages("text", (*Peer).textHandler)
+
+	r.Handle("control", func(peer *Peer, dc *webrtc.DataChannel) (MessageHandler, error) {
+		peer.Lock()
+		peer.control = peer.channels[dc.Label()]
+		peer.Unlock()
+
+		dc.OnOpen(func() {
+			peer.sendLastPath()
+			peer.sendLastLED()
+			peer.sendLastHDRMode()
+		})
+
+		return func(msg webrtc.DataChannelMessage) error {
+			return peer.controlHandler(msg.Data)
+		}, nil
+	})
+
+	r.Handle("files", func(peer *Peer, dc *webrtc.DataChannel) (MessageHandler, error) {
+		if peer.files == nil {
+			return nil, errors.New("file drop disabled")
+		}
+
+		peer.RLock()
+		mc := peer.channels[dc.Label()]
+		peer.RUnlock()
+
+		files := newFileReceiver(peer.files, mc, peer.log)
+		mc.OnClose(files.Close)
+
+		return func(msg webrtc.DataChannelMessage) error {
+			files.HandleMessage(msg)
+			return nil
+		}, nil
+	})
+
+	r.Handle("stills", openStills)
+	r.Handle("latency", openLatency)
+
+	return r
+}
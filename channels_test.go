@@ -0,0 +1,193 @@
+package game
+
+import (
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestDataChannelRouter(t *testing.T) {
+	assert := assert.New(t)
+
+	var got []string
+
+	r := NewDataChannelRouter()
+	r.HandleMessages("chat", func(peer *Peer, data []byte) error {
+		got = append(got, "chat:"+string(data))
+		return nil
+	})
+
+	_, ok := r.Route("unknown")
+	assert.False(ok)
+
+	open, ok := r.Route("chat")
+	if !assert.True(ok) {
+		return
+	}
+
+	handle, err := open(nil, nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	handle(webrtc.DataChannelMessage{Data: []byte("hello")})
+
+	r.HandleMessages("*", func(peer *Peer, data []byte) error {
+		got = append(got, "any:"+string(data))
+		return nil
+	})
+
+	open, ok = r.Route("unknown")
+	if !assert.True(ok) {
+		return
+	}
+
+	handle, _ = open(nil, nil)
+	handle(webrtc.DataChannelMessage{Data: []byte("world")})
+
+	assert.Equal([]string{"chat:hello", "any:world"}, got)
+}
+
+func TestDefaultDataChannelRouter(t *testing.T) {
+	assert := assert.New(t)
+
+	r := defaultDataChannelRouter()
+
+	for _, label := range []string{"gamepad", "motion", "keyboard", "mouse", "text", "control", "files"} {
+		_, ok := r.Route(label)
+		assert.True(ok, label)
+	}
+
+	_, ok := r.Route("chat")
+	assert.False(ok)
+}
+
+func TestPeerDataChannels(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer server.Close()
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer client.Close()
+
+	router := NewDataChannelRouter()
+	router.HandleMessages("*", func(peer *Peer, data []byte) error {
+		return nil
+	})
+
+	// rejections are only seen in the log
+	rejected := make(chan struct{}, 1)
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(io.Discard), zap.DebugLevel)
+	log := zap.New(core, zap.Hooks(func(entry zapcore.Entry) error {
+		if entry.Message == "data channel rejected" {
+			rejected <- struct{}{}
+		}
+
+		return nil
+	}))
+
+	peer := &Peer{
+		PeerConnection: server,
+		log:            log,
+		router:         router,
+		dtls:           new(dtlsHandshake),
+	}
+
+	peer.Init()
+
+	open := func(label string) *webrtc.DataChannel {
+		dc, err := client.CreateDataChannel(label, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return dc
+	}
+
+	channels := func() int {
+		peer.RLock()
+		defer peer.RUnlock()
+
+		return len(peer.channels)
+	}
+
+	// the channels are opened in-band once connected
+	first := open("chat")
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	gathered := webrtc.GatheringCompletePromise(client)
+	client.SetLocalDescription(offer)
+	<-gathered
+
+	server.SetRemoteDescription(*client.LocalDescription())
+
+	answer, err := server.CreateAnswer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	gathered = webrtc.GatheringCompletePromise(server)
+	server.SetLocalDescription(answer)
+	<-gathered
+
+	client.SetRemoteDescription(*server.LocalDescription())
+
+	assert.Eventually(func() bool { return channels() == 1 }, 10*time.Second, 10*time.Millisecond)
+
+	// a label already open is rejected
+	open("chat")
+
+	select {
+	case <-rejected:
+	case <-time.After(10 * time.Second):
+		assert.Fail("duplicate label not rejected")
+	}
+
+	assert.Equal(1, channels())
+
+	// channels that close no longer count
+	for i := range MaxDataChannels - 1 {
+		open("chat" + strconv.Itoa(i))
+	}
+
+	assert.Eventually(func() bool { return channels() == MaxDataChannels }, 10*time.Second, 10*time.Millisecond)
+
+	first.Close()
+	assert.Eventually(func() bool { return channels() == MaxDataChannels-1 }, 10*time.Second, 10*time.Millisecond)
+
+	open("chat")
+	assert.Eventually(func() bool { return channels() == MaxDataChannels }, 10*time.Second, 10*time.Millisecond)
+
+	// and no more than the limit are open
+	open("extra")
+
+	select {
+	case <-rejected:
+	case <-time.After(10 * time.Second):
+		assert.Fail("channel over the limit not rejected")
+	}
+
+	assert.Equal(MaxDataChannels, channels())
+}
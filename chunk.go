@@ -0,0 +1,233 @@
+package game
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Chunk frames are binary data channel messages with a 9-byte header:
+// transfer ID (4 bytes), sequence number (4 bytes) and flags (1 byte),
+// all big-endian, followed by the chunk data.
+const (
+	ChunkHeaderSize = 9
+	MaxChunkSize    = 16 * 1024
+
+	chunkFlagFinal uint8 = 0x01
+)
+
+var (
+	ErrInvalidChunk     = errors.New("invalid chunk")
+	ErrChunkOutOfOrder  = errors.New("chunk out of order")
+	ErrTransferTooLarge = errors.New("transfer too large")
+)
+
+type Chunk struct {
+	Transfer uint32
+	Seq      uint32
+	Final    bool
+	Data     []byte
+}
+
+func ParseChunk(frame []byte) (*Chunk, error) {
+	if len(frame) < ChunkHeaderSize {
+		return nil, ErrInvalidChunk
+	}
+
+	return &Chunk{
+		Transfer: binary.BigEndian.Uint32(frame[0:4]),
+		Seq:      binary.BigEndian.Uint32(frame[4:8]),
+		Final:    frame[8]&chunkFlagFinal != 0,
+		Data:     frame[ChunkHeaderSize:],
+	}, nil
+}
+
+func (c *Chunk) Marshal() []byte {
+	frame := make([]byte, ChunkHeaderSize, ChunkHeaderSize+len(c.Data))
+	binary.BigEndian.PutUint32(frame[0:4], c.Transfer)
+	binary.BigEndian.PutUint32(frame[4:8], c.Seq)
+
+	if c.Final {
+		frame[8] |= chunkFlagFinal
+	}
+
+	return append(frame, c.Data...)
+}
+
+// ChunkChannel is the part of *webrtc.DataChannel used for flow control.
+type ChunkChannel interface {
+	Send(data []byte) error
+	BufferedAmount() uint64
+	SetBufferedAmountLowThreshold(th uint64)
+	OnBufferedAmountLow(f func())
+}
+
+// NewChunkSender splits payloads into chunk frames and pauses while more
+// than highWater bytes are queued in the SCTP send buffer, resuming once
+// the buffered amount drops below half of it. It takes over the channel's
+// OnBufferedAmountLow callback.
+func NewChunkSender(dc ChunkChannel, highWater uint64) *ChunkSender {
+	s := &ChunkSender{
+		dc:        dc,
+		highWater: highWater,
+		low:       make(chan struct{}, 1),
+	}
+
+	dc.SetBufferedAmountLowThreshold(highWater / 2)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case s.low <- struct{}{}:
+		default:
+		}
+	})
+
+	return s
+}
+
+type ChunkSender struct {
+	dc        ChunkChannel
+	highWater uint64
+	low       chan struct{}
+	transfers atomic.Uint32
+	sync.Mutex
+}
+
+// Send transmits the payload as one transfer and returns its ID. Transfers
+// are not interleaved; concurrent calls are sent one after another.
+func (s *ChunkSender) Send(ctx context.Context, data []byte) (uint32, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	transfer := s.transfers.Add(1)
+
+	var seq uint32
+	for {
+		size := min(len(data), MaxChunkSize)
+
+		chunk := &Chunk{
+			Transfer: transfer,
+			Seq:      seq,
+			Final:    size == len(data),
+			Data:     data[:size],
+		}
+
+		if err := s.wait(ctx); err != nil {
+			return transfer, err
+		}
+
+		if err := s.dc.Send(chunk.Marshal()); err != nil {
+			return transfer, err
+		}
+
+		if chunk.Final {
+			return transfer, nil
+		}
+
+		data = data[size:]
+		seq++
+	}
+}
+
+func (s *ChunkSender) wait(ctx context.Context) error {
+	for s.dc.BufferedAmount() > s.highWater {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.low:
+		}
+	}
+
+	return nil
+}
+
+// ChunkReader checks that the chunks of each transfer arrive in order.
+// Transfers can either be consumed chunk by chunk with Read, or buffered
+// until complete with Reassemble.
+func NewChunkReader(maxTransferSize int) *ChunkReader {
+	return &ChunkReader{
+		max:       maxTransferSize,
+		transfers: make(map[uint32]*chunkTransfer),
+	}
+}
+
+type ChunkReader struct {
+	max       int
+	transfers map[uint32]*chunkTransfer
+	sync.Mutex
+}
+
+type chunkTransfer struct {
+	next uint32
+	size int
+	buf  []byte
+}
+
+// Read parses and validates a chunk frame. A transfer is forgotten after
+// its final chunk or any error.
+func (r *ChunkReader) Read(frame []byte) (*Chunk, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	_, chunk, err := r.read(frame, false)
+	return chunk, err
+}
+
+// Reassemble buffers chunks and returns the payload once the final chunk
+// of a transfer has arrived.
+func (r *ChunkReader) Reassemble(frame []byte) ([]byte, bool, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	t, chunk, err := r.read(frame, true)
+	if err != nil || !chunk.Final {
+		return nil, false, err
+	}
+
+	return t.buf, true, nil
+}
+
+func (r *ChunkReader) read(frame []byte, buffer bool) (*chunkTransfer, *Chunk, error) {
+	chunk, err := ParseChunk(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, ok := r.transfers[chunk.Transfer]
+	if !ok {
+		t = new(chunkTransfer)
+		r.transfers[chunk.Transfer] = t
+	}
+
+	if chunk.Seq != t.next {
+		delete(r.transfers, chunk.Transfer)
+		return nil, nil, ErrChunkOutOfOrder
+	}
+
+	t.next++
+	t.size += len(chunk.Data)
+
+	if r.max > 0 && t.size > r.max {
+		delete(r.transfers, chunk.Transfer)
+		return nil, nil, ErrTransferTooLarge
+	}
+
+	if buffer {
+		t.buf = append(t.buf, chunk.Data...)
+	}
+
+	if chunk.Final {
+		delete(r.transfers, chunk.Transfer)
+	}
+
+	return t, chunk, nil
+}
+
+// Reset forgets a transfer, e.g. when it was canceled.
+func (r *ChunkReader) Reset(transfer uint32) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.transfers, transfer)
+}
@@ -0,0 +1,90 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeChunkChannel struct {
+	frames   [][]byte
+	buffered uint64
+	low      func()
+}
+
+func (dc *fakeChunkChannel) Send(data []byte) error {
+	dc.frames = append(dc.frames, data)
+	dc.buffered += uint64(len(data))
+	return nil
+}
+
+func (dc *fakeChunkChannel) BufferedAmount() uint64 {
+	// the peer drains everything as soon as the sender waits
+	if dc.buffered > 64*1024 {
+		dc.buffered = 0
+		go dc.low()
+		return 64*1024 + 1
+	}
+
+	return dc.buffered
+}
+
+func (dc *fakeChunkChannel) SetBufferedAmountLowThreshold(th uint64) {}
+
+func (dc *fakeChunkChannel) OnBufferedAmountLow(f func()) {
+	dc.low = f
+}
+
+func TestChunkSender(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := new(fakeChunkChannel)
+	sender := NewChunkSender(dc, 64*1024)
+
+	payload := bytes.Repeat([]byte{0xAB}, 5*MaxChunkSize+10)
+
+	transfer, err := sender.Send(context.Background(), payload)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(uint32(1), transfer)
+	assert.Len(dc.frames, 6)
+
+	reader := NewChunkReader(0)
+
+	var (
+		data     []byte
+		complete bool
+	)
+
+	for _, frame := range dc.frames {
+		data, complete, err = reader.Reassemble(frame)
+		assert.NoError(err)
+	}
+
+	assert.True(complete)
+	assert.Equal(payload, data)
+}
+
+func TestChunkReader(t *testing.T) {
+	assert := assert.New(t)
+
+	reader := NewChunkReader(4)
+
+	_, err := reader.Read([]byte{1, 2})
+	assert.ErrorIs(err, ErrInvalidChunk)
+
+	_, err = reader.Read((&Chunk{Transfer: 1, Seq: 1}).Marshal())
+	assert.ErrorIs(err, ErrChunkOutOfOrder)
+
+	chunk, err := reader.Read((&Chunk{Transfer: 2, Seq: 0, Data: []byte{1, 2, 3}}).Marshal())
+	assert.NoError(err)
+	assert.Equal([]byte{1, 2, 3}, chunk.Data)
+
+	_, err = reader.Read((&Chunk{Transfer: 2, Seq: 1, Final: true, Data: []byte{4, 5}}).Marshal())
+	assert.ErrorIs(err, ErrTransferTooLarge)
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -13,8 +16,11 @@ import (
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
+	"github.com/pion/webrtc/v4"
 	"github.com/urfave/cli/v3"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"gopkg.in/yaml.v3"
 
 	"github.com/flarexio/game"
@@ -26,13 +32,11 @@ const (
 )
 
 func main() {
-	homeDir, err := os.UserHomeDir()
+	path, err := nvstream.DefaultPath()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
-	path := filepath.Join(homeDir, ".flarex", "game")
-
 	nvstreamCmd := &cli.Command{
 		Name:        "nvstream",
 		Description: "NVIDIA GameStream compatible client for game streaming.",
@@ -52,16 +56,75 @@ func main() {
 						Usage: "The hostname or IP address of the GameStream server.",
 						Value: "localhost",
 					},
+					&cli.BoolFlag{
+						Name:  "insecure",
+						Usage: "Skip the pinned server certificate check, e.g. to re-pair a reinstalled host.",
+					},
 				},
 				Action: pair,
 			},
 		},
 	}
 
+	gamepadCmd := &cli.Command{
+		Name:        "gamepad",
+		Description: "Virtual gamepad utilities.",
+		Commands: []*cli.Command{
+			{
+				Name:        "test",
+				Description: "Cycle the virtual gamepad through a known pattern and verify it through the OS input API.",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "How long to wait for each step to read back.",
+						Value: time.Second,
+					},
+					&cli.StringFlag{
+						Name:  "backend",
+						Usage: "The gamepad backend to test: vigem or vxbox.",
+						Value: string(game.GamepadBackendViGEm),
+					},
+				},
+				Action: gamepadTest,
+			},
+		},
+	}
+
+	doctorCmd := &cli.Command{
+		Name:        "doctor",
+		Description: "Check the host's prerequisites, such as the virtual gamepad driver.",
+		Action:      doctor,
+	}
+
+	netCmd := &cli.Command{
+		Name:        "net",
+		Description: "Network diagnostics.",
+		Commands: []*cli.Command{
+			{
+				Name:        "check",
+				Description: "Test NAT traversal against the configured ICE providers and tell whether peers will need TURN.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "path",
+						Usage:   "Specifies the working directory for the Game service.",
+						Sources: cli.EnvVars("GAME_PATH"),
+						Value:   path,
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "How long to wait for each server to answer.",
+						Value: 3 * time.Second,
+					},
+				},
+				Action: netCheck,
+			},
+		},
+	}
+
 	cmd := &cli.Command{
 		Name:        "game",
 		Description: "Edge Gaming services for real-time game streaming and remote game controller access to edge computer.",
-		Commands:    []*cli.Command{nvstreamCmd},
+		Commands:    []*cli.Command{nvstreamCmd, gamepadCmd, doctorCmd, netCmd},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "path",
@@ -74,6 +137,32 @@ func main() {
 				Sources: cli.EnvVars("NATS_URL"),
 				Value:   "wss://nats.flarex.io",
 			},
+			&cli.DurationFlag{
+				Name:    "request-timeout",
+				Usage:   "Bounds the handling of each request to the endpoints.",
+				Sources: cli.EnvVars("GAME_REQUEST_TIMEOUT"),
+				Value:   game.DefaultRequestTimeout,
+			},
+			&cli.StringFlag{
+				Name:    "health",
+				Usage:   "Serves /health, /ready and /metrics over HTTP on this address, e.g. :8080.",
+				Sources: cli.EnvVars("GAME_HEALTH_ADDR"),
+			},
+			&cli.StringFlag{
+				Name:    "grpc",
+				Usage:   "Serves the gRPC and Connect API on this address, e.g. :8443, over HTTP/2 without TLS for a service mesh to terminate.",
+				Sources: cli.EnvVars("GAME_GRPC_ADDR"),
+			},
+			&cli.StringFlag{
+				Name:    "grpc-token",
+				Usage:   "Bearer token the callers of the gRPC and Connect API present; required with --grpc.",
+				Sources: cli.EnvVars("GAME_GRPC_TOKEN"),
+			},
+			&cli.BoolFlag{
+				Name:    "demo",
+				Usage:   "Serves a synthetic test pattern and tone instead of the configured streams.",
+				Sources: cli.EnvVars("GAME_DEMO"),
+			},
 		},
 		Action: run,
 	}
@@ -84,37 +173,81 @@ func main() {
 	}
 }
 
+// loadConfig reads config.yaml. A demo replaces the configured streams with
+// the demo stream and needs no config.yaml, using Google's STUN servers.
+func loadConfig(path string, demo bool) (*game.Config, error) {
+	var cfg *game.Config
+
+	f, err := os.Open(filepath.Join(path, "config.yaml"))
+	switch {
+	case err == nil:
+		defer f.Close()
+
+		if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
+			return nil, err
+		}
+
+	case demo && errors.Is(err, fs.ErrNotExist):
+		cfg = &game.Config{
+			WebRTC: game.WebRTC{
+				ICEServers: []*game.ICEServer{{Provider: game.Google}},
+			},
+		}
+
+	default:
+		return nil, err
+	}
+
+	if demo {
+		cfg.Streams = []*game.Stream{game.DemoStream(game.DefaultStream)}
+
+		// The demo stream is not written to config.yaml.
+		if cfg.Management != nil {
+			cfg.Management.Persist = false
+		}
+	}
+
+	return cfg, nil
+}
+
 func run(ctx context.Context, cmd *cli.Command) error {
-	log, err := zap.NewDevelopment()
+	path := cmd.String("path")
+	demo := cmd.Bool("demo")
+
+	// Warnings of the config load go to a development logger.
+	bootstrap, err := zap.NewDevelopment()
 	if err != nil {
 		return err
 	}
-	defer log.Sync()
-
-	zap.ReplaceGlobals(log)
 
-	path := cmd.String("path")
+	zap.ReplaceGlobals(bootstrap)
 
-	f, err := os.Open(filepath.Join(path, "config.yaml"))
+	cfg, err := loadConfig(path, demo)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	var cfg *game.Config
-	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
+	log, err := game.NewLogger(cfg.Logging, path)
+	if err != nil {
 		return err
 	}
+	defer log.Sync()
+
+	zap.ReplaceGlobals(log)
 
 	cfg.Path = path
 
 	natsURL := cmd.String("nats")
 	natsCreds := filepath.Join(path, "user.creds")
 
-	nc, err := nats.Connect(natsURL,
-		nats.Name("game"),
-		nats.UserCredentials(natsCreds),
-	)
+	opts := []nats.Option{nats.Name("game")}
+
+	// A demo may run against a NATS server without credentials.
+	if _, err := os.Stat(natsCreds); !demo || err == nil {
+		opts = append(opts, nats.UserCredentials(natsCreds))
+	}
+
+	nc, err := nats.Connect(natsURL, opts...)
 	if err != nil {
 		return err
 	}
@@ -138,9 +271,58 @@ func run(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 
-	group := srv.AddGroup("peers")
-	group.AddEndpoint("iceservers", game.ICEServersHandler(svc))
-	group.AddEndpoint("negotiation", game.AcceptPeerHandler(svc))
+	timeout := cmd.Duration("request-timeout")
+
+	if err := game.AddEndpoints(srv, svc, timeout); err != nil {
+		return err
+	}
+
+	if addr := cmd.String("health"); addr != "" {
+		healthSrv := &http.Server{
+			Addr:    addr,
+			Handler: game.HealthHTTPHandler(svc, timeout),
+		}
+		defer healthSrv.Close()
+
+		go func() {
+			err := healthSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error(err.Error(), zap.String("action", "health"))
+			}
+		}()
+	}
+
+	if addr := cmd.String("grpc"); addr != "" {
+		token := cmd.String("grpc-token")
+		if token == "" {
+			return errors.New("grpc requires a token: set --grpc-token")
+		}
+
+		// Callers get what access grants, as NATS clients do.
+		perms := game.PermissionAll
+		if cfg.Access != nil {
+			perms = cfg.Access.Permissions
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(game.ConnectHandler(svc, nc, &game.ConnectAuth{
+			Token:       token,
+			Permissions: perms,
+		}))
+
+		grpcSrv := &http.Server{
+			Addr:    addr,
+			Handler: h2c.NewHandler(mux, &http2.Server{}),
+		}
+		defer grpcSrv.Close()
+
+		go func() {
+			err := grpcSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error(err.Error(), zap.String("action", "grpc"))
+			}
+		}()
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -155,7 +337,16 @@ func pair(ctx context.Context, cmd *cli.Command) error {
 	host := cmd.String("host")
 	path := cmd.String("path")
 
-	http, err := nvstream.NewHTTP("MyGameClient", host, path)
+	opts := []nvstream.HTTPOption{
+		nvstream.WithUniqueID("MyGameClient"),
+		nvstream.WithPath(path),
+	}
+
+	if cmd.Bool("insecure") {
+		opts = append(opts, nvstream.WithTLSVerification(nvstream.TLSSkipVerify))
+	}
+
+	http, err := nvstream.NewHTTP(host, opts...)
 	if err != nil {
 		return err
 	}
@@ -181,11 +372,22 @@ func pair(ctx context.Context, cmd *cli.Command) error {
 
 	fmt.Println("開始配對...")
 
+	// Ctrl+C 取消配對
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// 執行配對
-	state := client.Pair(pin)
+	state, err := client.Pair(ctx, pin, func(stage nvstream.PairStage) {
+		fmt.Printf("配對階段: %s\n", stage)
+	})
 
 	if state != nvstream.PairStatePaired {
-		fmt.Printf("配對失敗，狀態碼: %d\n", state)
+		fmt.Printf("配對失敗，狀態: %s (%v)\n", state, err)
+
+		if errors.Is(err, nvstream.ErrHostUnreachable) {
+			fmt.Println("無法連線到主機，請確認位址與 Sunshine 是否已啟動")
+		}
+
 		return nil
 	}
 
@@ -193,3 +395,161 @@ func pair(ctx context.Context, cmd *cli.Command) error {
 
 	return nil
 }
+
+func gamepadTest(ctx context.Context, cmd *cli.Command) error {
+	create := game.NewGamepad
+	switch backend := game.GamepadBackend(cmd.String("backend")); backend {
+	case game.GamepadBackendViGEm:
+	case game.GamepadBackendVXbox:
+		create = game.NewVXboxGamepad
+	default:
+		return errors.New("invalid gamepad backend: " + string(backend))
+	}
+
+	gamepad, err := create()
+	if err != nil {
+		return err
+	}
+
+	if err := gamepad.Connect(); err != nil {
+		return err
+	}
+	defer gamepad.Close()
+
+	probe, err := game.NewGamepadProbe(gamepad)
+	if err != nil {
+		return err
+	}
+	defer probe.Close()
+
+	// Give the OS time to enumerate the new controller
+	time.Sleep(time.Second)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	steps := game.GamepadTestPattern()
+
+	results, err := game.SelfTestGamepad(ctx, gamepad, probe, steps, cmd.Duration("timeout"))
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Passed {
+			fmt.Printf("PASS %-8s %s\n", result.Step.Name, formatReport(result.Step.Report))
+			continue
+		}
+
+		failed++
+		fmt.Printf("FAIL %-8s %s, got %s\n", result.Step.Name,
+			formatReport(result.Step.Report), formatReport(result.Received))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d steps failed", failed, len(results))
+	}
+
+	fmt.Println("gamepad ok")
+
+	return nil
+}
+
+func formatReport(r game.GamepadReport) string {
+	if r == nil {
+		return "nothing"
+	}
+
+	ls := r.LeftThumbStick()
+	rs := r.RightThumbStick()
+
+	return fmt.Sprintf("buttons=0x%04x lt=%d rt=%d ls=(%d,%d) rs=(%d,%d)",
+		r.Buttons(), r.LeftTrigger(), r.RightTrigger(), ls.X, ls.Y, rs.X, rs.Y)
+}
+
+func doctor(ctx context.Context, cmd *cli.Command) error {
+	status := game.CheckGamepad()
+
+	if driver := status.Driver; driver != nil {
+		fmt.Printf("gamepad driver: %s %s\n", driver.Name, driver.Version)
+	} else {
+		fmt.Println("gamepad driver: not found")
+	}
+
+	if !status.OK {
+		fmt.Printf("gamepad: FAIL %s\n", status.Error)
+		if status.Hint != "" {
+			fmt.Printf("  hint: %s\n", status.Hint)
+		}
+
+		return errors.New("doctor found problems")
+	}
+
+	fmt.Println("gamepad: ok")
+
+	return nil
+}
+
+func netCheck(ctx context.Context, cmd *cli.Command) error {
+	f, err := os.Open(filepath.Join(cmd.String("path"), "config.yaml"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var cfg *game.Config
+	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
+		return err
+	}
+
+	var servers []webrtc.ICEServer
+	for _, server := range cfg.WebRTC.ICEServers {
+		creds, err := game.FetchICEServers(ctx, server)
+		if err != nil {
+			fmt.Printf("provider %s: FAIL %s\n", server.Provider, err)
+			continue
+		}
+
+		servers = append(servers, creds.Servers...)
+	}
+
+	report, err := game.NetCheck(ctx, servers, cmd.Duration("timeout"))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range report.STUN {
+		if r.Error != "" {
+			fmt.Printf("stun %s: FAIL %s\n", r.Server, r.Error)
+			continue
+		}
+
+		fmt.Printf("stun %s: mapped %s in %s\n", r.Server, r.Mapped, r.RTT.Round(time.Millisecond))
+	}
+
+	for _, r := range report.TURN {
+		if !r.Reachable {
+			fmt.Printf("turn %s: FAIL %s\n", r.Server, r.Error)
+			continue
+		}
+
+		fmt.Printf("turn %s: reachable\n", r.Server)
+	}
+
+	fmt.Printf("udp: %t\n", report.UDP)
+	fmt.Printf("nat mapping: %s\n", report.Mapping)
+
+	if report.Hairpin != nil {
+		fmt.Printf("hairpinning: %t\n", *report.Hairpin)
+	}
+
+	fmt.Printf("turn required: %t\n", report.TURNRequired)
+	fmt.Printf("verdict: %s\n", report.Verdict)
+
+	if report.TURNRequired && !report.TURNReachable() {
+		return errors.New("turn required but unavailable")
+	}
+
+	return nil
+}
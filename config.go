@@ -0,0 +1,162 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigVersion is the layout of config.yaml this build reads. A config
+// without a version is of version 1, the layout before versioning.
+//
+// Version 2 lists the hosts of an NVStream stream under origins rather
+// than address, and states the permissions of peers in an access block.
+const ConfigVersion = 2
+
+// configMigration upgrades a config of version From to the next layout in
+// place, returning a warning for each deprecated key it rewrote.
+type configMigration struct {
+	From    int
+	Migrate func(root *yaml.Node) ([]string, error)
+}
+
+// configMigrations upgrade older layouts, in order of version.
+var configMigrations = []configMigration{
+	{From: 1, Migrate: migrateConfigV1},
+}
+
+// migrateConfigV1 moves the address of each stream to its origins, and
+// adds the access block granting all permissions, as version 1 did
+// without one. Streams persisted by the management API have already moved,
+// so it leaves them as they are.
+func migrateConfigV1(root *yaml.Node) ([]string, error) {
+	var warnings []string
+
+	if mappingValue(root, "access") == nil {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "access"},
+			&yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "permissions"},
+				{Kind: yaml.ScalarNode, Value: "all"},
+			}},
+		)
+
+		warnings = append(warnings, "access.permissions is all, as peers had without an access block")
+	}
+
+	streams := mappingValue(root, "streams")
+	if streams == nil || streams.Kind != yaml.SequenceNode {
+		return warnings, nil
+	}
+
+	for _, stream := range streams.Content {
+		if stream.Kind != yaml.MappingNode || mappingValue(stream, "origins") != nil {
+			continue
+		}
+
+		for i := 0; i+1 < len(stream.Content); i += 2 {
+			key, value := stream.Content[i], stream.Content[i+1]
+			if key.Value != "address" {
+				continue
+			}
+
+			key.Value = "origins"
+			stream.Content[i+1] = &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{value}}
+
+			name := "stream"
+			if n := mappingValue(stream, "name"); n != nil {
+				name = n.Value
+			}
+
+			warnings = append(warnings, "streams."+name+".address is origins")
+		}
+	}
+
+	return warnings, nil
+}
+
+// mappingValue returns the value of the key in a mapping, nil if absent.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Config) UnmarshalYAML(value *yaml.Node) error {
+	version, err := configVersion(value)
+	if err != nil {
+		return err
+	}
+
+	if version > ConfigVersion {
+		return fmt.Errorf("config version %d newer than supported %d", version, ConfigVersion)
+	}
+
+	warnings, err := migrateConfig(value, version)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range warnings {
+		zap.L().Warn("deprecated config",
+			zap.Int("version", version),
+			zap.String("warning", warning))
+	}
+
+	type plain Config
+	if err := value.Decode((*plain)(cfg)); err != nil {
+		return err
+	}
+
+	cfg.Version = ConfigVersion
+
+	return nil
+}
+
+// configVersion returns the version of a config mapping, 1 if it has none.
+func configVersion(root *yaml.Node) (int, error) {
+	if root.Kind != yaml.MappingNode {
+		return 0, errors.New("config not a mapping")
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "version" {
+			continue
+		}
+
+		version, err := strconv.Atoi(root.Content[i+1].Value)
+		if err != nil || version < 1 {
+			return 0, errors.New("invalid config version: " + root.Content[i+1].Value)
+		}
+
+		return version, nil
+	}
+
+	return 1, nil
+}
+
+// migrateConfig upgrades a config of the version to ConfigVersion.
+func migrateConfig(root *yaml.Node, version int) ([]string, error) {
+	var warnings []string
+	for _, m := range configMigrations {
+		if m.From < version {
+			continue
+		}
+
+		w, err := m.Migrate(root)
+		if err != nil {
+			return nil, fmt.Errorf("migrate config from version %d: %w", m.From, err)
+		}
+
+		warnings = append(warnings, w...)
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,160 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte("webrtc:\n  dtlsRole: auto\n"), &cfg); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(ConfigVersion, cfg.Version)
+
+	err := yaml.Unmarshal([]byte("version: 99\n"), &cfg)
+	assert.ErrorContains(err, "newer than supported")
+
+	err = yaml.Unmarshal([]byte("version: latest\n"), &cfg)
+	assert.ErrorContains(err, "invalid config version")
+}
+
+func TestMigrateConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	migrations := configMigrations
+	t.Cleanup(func() { configMigrations = migrations })
+
+	// A layout that named the files section uploads.
+	configMigrations = []configMigration{{
+		From: 1,
+		Migrate: func(root *yaml.Node) ([]string, error) {
+			for i := 0; i < len(root.Content); i += 2 {
+				if key := root.Content[i]; key.Value == "uploads" {
+					key.Value = "files"
+					return []string{"uploads is files"}, nil
+				}
+			}
+
+			return nil, nil
+		},
+	}}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte("uploads:\n  enabled: true\n"), &root); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	warnings, err := migrateConfig(root.Content[0], 1)
+	if assert.NoError(err) {
+		assert.Equal([]string{"uploads is files"}, warnings)
+	}
+
+	// Newer layouts skip it.
+	warnings, err = migrateConfig(root.Content[0], 2)
+	if assert.NoError(err) {
+		assert.Empty(warnings)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte("uploads:\n  enabled: true\n  path: saves\n"), &cfg); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if assert.NotNil(cfg.Files) {
+		assert.True(cfg.Files.Enabled)
+		assert.Equal("saves", cfg.Files.Path)
+	}
+}
+
+func TestMigrateConfigV1(t *testing.T) {
+	assert := assert.New(t)
+
+	// the layout of the example config before versioning
+	baseline := `
+webrtc:
+  iceServers:
+  - provider: google
+streams:
+- name: gamestream
+  transport: nvstream
+  address: https://localhost:47984
+  nvstream:
+    app: Steam
+    width: 1920
+    height: 1080
+    refreshRate: 60
+    launchRefreshRate: 60
+    clientRefreshRateX100: 6000
+    bitrate: 10000
+    sops: true
+    enableAdaptiveResolution: false
+    playLocalAudio: false
+    maxPacketSize: 1024
+    remote: auto
+    audioConfiguration: stereo
+    supportedVideoFormats: [ h264 ]
+    attachedGamepadMask: 0
+    encryptionFlags: none
+    colorRange: limited
+    colorSpace: rec709
+    persistGamepadAfterDisconnect: false
+  video:
+    codec: h264
+    fps: 60
+  audio:
+    codec: opus
+- name: stream
+  transport: raw
+  video:
+    codec: h264
+    address: unix:///tmp/stream/video.sock
+    fps: 60
+  audio:
+    codec: opus
+    address: unix:///tmp/stream/audio.sock
+`
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(baseline), &root); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	warnings, err := migrateConfig(root.Content[0], 1)
+	if assert.NoError(err) {
+		assert.Len(warnings, 2)
+		assert.Contains(warnings, "streams.gamestream.address is origins")
+	}
+
+	// migrated configs are left as they are
+	warnings, err = migrateConfig(root.Content[0], 1)
+	if assert.NoError(err) {
+		assert.Empty(warnings)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(baseline), &cfg); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(ConfigVersion, cfg.Version)
+
+	if assert.NotNil(cfg.Access) {
+		assert.Equal(PermissionAll, cfg.Access.Permissions)
+	}
+
+	if assert.Len(cfg.Streams, 2) {
+		assert.Equal("https://localhost:47984", cfg.Streams[0].Address().String())
+		assert.Equal("/tmp/stream/video.sock", cfg.Streams[1].Video.Address().Path)
+	}
+}
@@ -0,0 +1,112 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type ControlMessageType string
+
+const (
+	// client -> server
+	ControlTakeover    ControlMessageType = "controller.takeover"
+	ControlRelease     ControlMessageType = "controller.release"
+	ControlRendition   ControlMessageType = "video.rendition"
+	ControlQuality     ControlMessageType = "stream.quality"
+	ControlKeyframe    ControlMessageType = "video.keyframe"
+	ControlPause       ControlMessageType = "video.pause"
+	ControlResume      ControlMessageType = "video.resume"
+	ControlMute        ControlMessageType = "audio.mute"
+	ControlUnmute      ControlMessageType = "audio.unmute"
+	ControlICERestart  ControlMessageType = "ice.restart"
+	ControlMode        ControlMessageType = "peer.mode"
+	ControlAttach      ControlMessageType = "stream.attach"
+	ControlDetach      ControlMessageType = "stream.detach"
+	ControlTalk        ControlMessageType = "mic.talk"
+	ControlTalkRelease ControlMessageType = "mic.release"
+
+	// server -> client
+	ControlControllerChanged ControlMessageType = "controller.changed"
+	ControlStatsToggle       ControlMessageType = "stats.toggle"
+	ControlReplaySaved       ControlMessageType = "replay.saved"
+	ControlQualityDegraded   ControlMessageType = "quality.degraded"
+	ControlQualityRecovered  ControlMessageType = "quality.recovered"
+	ControlQualityDowngraded ControlMessageType = "quality.downgraded"
+	ControlAck               ControlMessageType = "ack"
+	ControlError             ControlMessageType = "error"
+)
+
+// ControlMessage is the JSON envelope of the control data channel.
+// Requests carrying an ID are answered with an ack or an error
+// message echoing the same ID.
+type ControlMessage struct {
+	ID      string             `json:"id,omitempty"`
+	Type    ControlMessageType `json:"type"`
+	Payload json.RawMessage    `json:"payload,omitempty"`
+}
+
+func NewControlMessage(typ ControlMessageType, payload any) (*ControlMessage, error) {
+	msg := &ControlMessage{
+		Type: typ,
+	}
+
+	if payload != nil {
+		bs, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		msg.Payload = bs
+	}
+
+	return msg, nil
+}
+
+type ControllerChanged struct {
+	Peer string `json:"peer"`
+}
+
+type ReplaySaved struct {
+	File string `json:"file"`
+}
+
+type ControlErrorCode string
+
+const (
+	ControlErrBadRequest       ControlErrorCode = "bad_request"
+	ControlErrPermissionDenied ControlErrorCode = "permission_denied"
+	ControlErrUnsupported      ControlErrorCode = "unsupported"
+	ControlErrUnavailable      ControlErrorCode = "unavailable"
+	ControlErrTooLarge         ControlErrorCode = "too_large"
+	ControlErrFailed           ControlErrorCode = "failed"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+type ControlErrorPayload struct {
+	Code    ControlErrorCode `json:"code"`
+	Message string           `json:"message"`
+}
+
+func NewControlError(code ControlErrorCode, message string) *ControlErrorPayload {
+	return &ControlErrorPayload{code, message}
+}
+
+func (e *ControlErrorPayload) Error() string {
+	return e.Message
+}
+
+// ControlErrorFrom converts an error returned by a control handler to
+// the error payload sent to the client.
+func ControlErrorFrom(err error) *ControlErrorPayload {
+	var ce *ControlErrorPayload
+	if errors.As(err, &ce) {
+		return ce
+	}
+
+	if errors.Is(err, ErrPermissionDenied) {
+		return NewControlError(ControlErrPermissionDenied, err.Error())
+	}
+
+	return NewControlError(ControlErrFailed, err.Error())
+}
@@ -0,0 +1,43 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlErrorFrom(t *testing.T) {
+	assert := assert.New(t)
+
+	e := ControlErrorFrom(ErrPermissionDenied)
+	assert.Equal(ControlErrPermissionDenied, e.Code)
+
+	e = ControlErrorFrom(NewControlError(ControlErrUnsupported, "unsupported"))
+	assert.Equal(ControlErrUnsupported, e.Code)
+
+	e = ControlErrorFrom(errors.New("boom"))
+	assert.Equal(ControlErrFailed, e.Code)
+	assert.Equal("boom", e.Message)
+}
+
+func TestControlMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	msg, err := NewControlMessage(ControlError, NewControlError(ControlErrBadRequest, "invalid"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	msg.ID = "42"
+
+	bs, err := json.Marshal(msg)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.JSONEq(`{"id":"42","type":"error","payload":{"code":"bad_request","message":"invalid"}}`, string(bs))
+}
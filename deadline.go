@@ -0,0 +1,93 @@
+package game
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrNegotiationTimeout = errors.New("negotiation timed out")
+
+// Deadlines bound the setup of a peer. Negotiation covers answering the
+// offer, including the gathering of the server's candidates; Connection
+// runs from the answer until the peer connects. A peer missing either is
+// closed and a peer.failed event published.
+type Deadlines struct {
+	Negotiation time.Duration
+	Connection  time.Duration
+}
+
+func (cfg *Deadlines) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Negotiation time.Duration `yaml:"negotiation"`
+		Connection  time.Duration `yaml:"connection"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Negotiation == 0 {
+		raw.Negotiation = defaultDeadlines.Negotiation
+	}
+
+	if raw.Connection == 0 {
+		raw.Connection = defaultDeadlines.Connection
+	}
+
+	if raw.Negotiation < 0 || raw.Connection < 0 {
+		return errors.New("deadlines must not be negative")
+	}
+
+	cfg.Negotiation = raw.Negotiation
+	cfg.Connection = raw.Connection
+
+	return nil
+}
+
+var defaultDeadlines = &Deadlines{
+	Negotiation: 10 * time.Second,
+	Connection:  30 * time.Second,
+}
+
+const (
+	PeerFailedNegotiationTimeout = "negotiation_timeout"
+	PeerFailedConnectionTimeout  = "connection_timeout"
+)
+
+// peerFailed publishes that the peer missed a deadline.
+func (peer *Peer) peerFailed(reason string, timeout time.Duration) {
+	peer.log.Warn("peer failed",
+		zap.String("reason", reason),
+		zap.Duration("timeout", timeout))
+
+	peer.events.Publish(EventPeerFailed, &PeerFailedEvent{
+		PeerEvent: *peer.event(),
+		Reason:    reason,
+		Timeout:   timeout,
+		RequestID: peer.RequestID(),
+	})
+}
+
+// expectConnection closes the peer unless it connected within timeout.
+func (peer *Peer) expectConnection(timeout time.Duration) {
+	time.AfterFunc(timeout, func() {
+		if peer.connected.Load() || peer.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			return
+		}
+
+		peer.peerFailed(PeerFailedConnectionTimeout, timeout)
+		peer.Close()
+	})
+}
+
+func (svc *service) deadlines() *Deadlines {
+	if d := svc.cfg.WebRTC.Deadlines; d != nil {
+		return d
+	}
+
+	return defaultDeadlines
+}
@@ -0,0 +1,61 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDeadlinesConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *Deadlines
+	err := yaml.Unmarshal([]byte("{}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(defaultDeadlines, cfg)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("{negotiation: 5s, connection: 1m}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(5*time.Second, cfg.Negotiation)
+	assert.Equal(time.Minute, cfg.Connection)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("connection: -1s"), &cfg)
+	assert.Error(err)
+}
+
+func TestExpectConnection(t *testing.T) {
+	assert := assert.New(t)
+
+	newPeer := func() *Peer {
+		pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &Peer{PeerConnection: pc, log: zap.NewNop()}
+	}
+
+	// a peer that never connects is closed
+	stale := newPeer()
+	stale.expectConnection(10 * time.Millisecond)
+
+	assert.Eventually(func() bool {
+		return stale.ConnectionState() == webrtc.PeerConnectionStateClosed
+	}, time.Second, 10*time.Millisecond)
+
+	// a connected one is kept
+	connected := newPeer()
+	defer connected.Close()
+
+	connected.connected.Store(true)
+	connected.expectConnection(10 * time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NotEqual(webrtc.PeerConnectionStateClosed, connected.ConnectionState())
+}
@@ -0,0 +1,311 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/core/model"
+)
+
+// The demo stream is a synthetic test pattern and tone, generated without
+// capture or a host, to validate the WebRTC and NATS path.
+const (
+	DemoWidth  = 320
+	DemoHeight = 240
+
+	defaultDemoFPS = 30
+
+	demoToneHz      = 1000
+	demoBeep        = 100 * time.Millisecond // at the start of each second
+	demoSampleRate  = 8000                   // of PCMU
+	demoAudioFrame  = 20 * time.Millisecond
+	demoKeyInterval = time.Second
+)
+
+// DemoStream returns a demo stream: H264 video of colour bars, a moving bar
+// and the time burnt in, with a PCMU beep every second.
+func DemoStream(name string) *Stream {
+	return &Stream{
+		Name:      name,
+		Transport: TransportDemo,
+		Video:     &VideoTrack{codec: CodecH264, fps: defaultDemoFPS},
+		Audio:     &AudioTrack{codec: CodecPCMU},
+	}
+}
+
+// buildDemo creates the demo stream's tracks and generates their samples
+// until ctx is done.
+func (svc *service) buildDemo(ctx context.Context, stream *Stream) error {
+	log, ok := ctx.Value(model.Logger).(*zap.Logger)
+	if !ok {
+		log = svc.log
+	}
+
+	log = log.With(zap.String("stream", stream.Name))
+
+	if video := stream.Video; video != nil {
+		if video.Codec() != CodecH264 {
+			return errors.New("demo video codec unsupported: " + string(video.Codec()))
+		}
+
+		track, err := webrtc.NewTrackLocalStaticSample(
+			webrtc.RTPCodecCapability{
+				MimeType: video.Codec().MimeType(),
+			}, stream.Name+"_video", stream.Name,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		video.track = track
+
+		go demoVideo(ctx, video, log.With(zap.String("track", "video")))
+	}
+
+	if audio := stream.Audio; audio != nil {
+		if audio.Codec() != CodecPCMU {
+			return errors.New("demo audio codec unsupported: " + string(audio.Codec()))
+		}
+
+		track, err := webrtc.NewTrackLocalStaticSample(
+			webrtc.RTPCodecCapability{
+				MimeType: audio.Codec().MimeType(),
+			}, stream.Name+"_audio", stream.Name,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		audio.track = track
+
+		go demoAudio(ctx, audio, log.With(zap.String("track", "audio")))
+	}
+
+	return nil
+}
+
+func demoVideo(ctx context.Context, video *VideoTrack, log *zap.Logger) {
+	fps := video.FPS()
+	if fps <= 0 {
+		fps = defaultDemoFPS
+	}
+
+	interval := time.Duration(float64(time.Second) / fps)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	encoder := newPCMEncoder(DemoWidth, DemoHeight)
+	frame := newYUVFrame(DemoWidth, DemoHeight)
+
+	log.Info("playing", zap.Float64("fps", fps))
+
+	var n int
+	var lastKey time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("done")
+			return
+
+		case now := <-ticker.C:
+			drawDemoFrame(frame, n, now)
+			n++
+
+			keyframe := now.Sub(lastKey) >= demoKeyInterval
+			if keyframe {
+				lastKey = now
+			}
+
+			video.WriteSample(media.Sample{
+				Data:     encoder.Encode(frame, keyframe),
+				Duration: interval,
+			})
+		}
+	}
+}
+
+func demoAudio(ctx context.Context, audio *AudioTrack, log *zap.Logger) {
+	ticker := time.NewTicker(demoAudioFrame)
+	defer ticker.Stop()
+
+	samples := int(demoAudioFrame * demoSampleRate / time.Second)
+
+	log.Info("playing", zap.Int("tone_hz", demoToneHz))
+
+	var pos int // samples played
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("done")
+			return
+
+		case <-ticker.C:
+			audio.WriteSample(media.Sample{
+				Data:     demoTone(pos, samples),
+				Duration: demoAudioFrame,
+			})
+
+			pos += samples
+		}
+	}
+}
+
+// demoTone returns n μ-law samples of the tone from sample pos on: a beep
+// at the start of each second, silence otherwise.
+func demoTone(pos, n int) []byte {
+	beep := int(demoBeep * demoSampleRate / time.Second)
+
+	frame := make([]byte, n)
+	for i := range frame {
+		s := pos + i
+
+		var v float64
+		if s%demoSampleRate < beep {
+			v = 0.3 * math.Sin(2*math.Pi*demoToneHz*float64(s)/demoSampleRate)
+		}
+
+		frame[i] = mulaw(int16(v * math.MaxInt16))
+	}
+
+	return frame
+}
+
+// mulaw encodes a linear sample in G.711 μ-law.
+func mulaw(sample int16) byte {
+	const (
+		bias = 0x84
+		clip = 32635
+	)
+
+	s := int(sample)
+
+	var sign int
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+
+	s = min(s, clip) + bias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+
+	mantissa := s >> (exponent + 3) & 0x0F
+
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+type yuv struct{ y, cb, cr byte }
+
+// 75% colour bars, BT.601 studio range.
+var demoBars = []yuv{
+	{180, 128, 128}, // white
+	{162, 44, 142},  // yellow
+	{131, 156, 44},  // cyan
+	{112, 72, 58},   // green
+	{84, 184, 198},  // magenta
+	{65, 100, 212},  // red
+	{35, 212, 114},  // blue
+}
+
+var (
+	demoBlack = yuv{16, 128, 128}
+	demoWhite = yuv{235, 128, 128}
+)
+
+const (
+	demoBarWidth = 16
+	demoBarSpeed = 4 // pixels per frame
+	demoBarsEnd  = DemoHeight * 2 / 3
+	demoTextY    = demoBarsEnd + 24
+	demoTextX    = 16
+	demoScale    = 3
+)
+
+// drawDemoFrame draws the nth picture of the demo: colour bars with a bar
+// moving across, above the time.
+func drawDemoFrame(f *yuvFrame, n int, now time.Time) {
+	barX := n * demoBarSpeed % f.width
+
+	for y := range f.height {
+		for x := range f.width {
+			c := demoBlack
+
+			switch {
+			case y < demoBarsEnd && x >= barX && x < barX+demoBarWidth:
+				c = demoWhite
+			case y < demoBarsEnd:
+				c = demoBars[x*len(demoBars)/f.width]
+			}
+
+			f.set(x, y, c)
+		}
+	}
+
+	x := demoTextX
+	for _, r := range now.Format("15:04:05.000") {
+		drawGlyph(f, x, demoTextY, r)
+		x += (glyphWidth + 1) * demoScale
+	}
+}
+
+func (f *yuvFrame) set(x, y int, c yuv) {
+	f.y[y*f.width+x] = c.y
+
+	// Chroma is subsampled; its top left pixel picks it.
+	if x%2 == 0 && y%2 == 0 {
+		i := y/2*f.width/2 + x/2
+		f.cb[i] = c.cb
+		f.cr[i] = c.cr
+	}
+}
+
+const (
+	glyphWidth  = 5
+	glyphHeight = 7
+)
+
+// glyphs are the rows of the characters of the burnt-in time, 5 bits each.
+var glyphs = map[rune][glyphHeight]byte{
+	'0': {0x0E, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0E},
+	'1': {0x04, 0x0C, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'2': {0x0E, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1F},
+	'3': {0x1F, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0E},
+	'4': {0x02, 0x06, 0x0A, 0x12, 0x1F, 0x02, 0x02},
+	'5': {0x1F, 0x10, 0x1E, 0x01, 0x01, 0x11, 0x0E},
+	'6': {0x06, 0x08, 0x10, 0x1E, 0x11, 0x11, 0x0E},
+	'7': {0x1F, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0E, 0x11, 0x11, 0x0E, 0x11, 0x11, 0x0E},
+	'9': {0x0E, 0x11, 0x11, 0x0F, 0x01, 0x02, 0x0C},
+	':': {0x00, 0x0C, 0x0C, 0x00, 0x0C, 0x0C, 0x00},
+	'.': {0x00, 0x00, 0x00, 0x00, 0x00, 0x0C, 0x0C},
+}
+
+func drawGlyph(f *yuvFrame, x0, y0 int, r rune) {
+	glyph := glyphs[r]
+
+	for row, bits := range glyph {
+		for col := range glyphWidth {
+			if bits>>(glyphWidth-1-col)&1 == 0 {
+				continue
+			}
+
+			for dy := range demoScale {
+				for dx := range demoScale {
+					f.set(x0+col*demoScale+dx, y0+row*demoScale+dy, demoWhite)
+				}
+			}
+		}
+	}
+}
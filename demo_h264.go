@@ -0,0 +1,289 @@
+package game
+
+import (
+	"bytes"
+)
+
+// yuvFrame is a picture in 8-bit YUV 4:2:0, its sizes multiples of 16.
+type yuvFrame struct {
+	width  int
+	height int
+	y      []byte
+	cb     []byte
+	cr     []byte
+}
+
+func newYUVFrame(width, height int) *yuvFrame {
+	return &yuvFrame{
+		width:  width,
+		height: height,
+		y:      make([]byte, width*height),
+		cb:     make([]byte, width*height/4),
+		cr:     make([]byte, width*height/4),
+	}
+}
+
+func (f *yuvFrame) clone() *yuvFrame {
+	return &yuvFrame{
+		width:  f.width,
+		height: f.height,
+		y:      bytes.Clone(f.y),
+		cb:     bytes.Clone(f.cb),
+		cr:     bytes.Clone(f.cr),
+	}
+}
+
+// macroblock appends the samples of a macroblock in I_PCM order: 16x16
+// luma, then 8x8 Cb and Cr.
+func (f *yuvFrame) macroblock(buf []byte, mbx, mby int) []byte {
+	for row := range 16 {
+		i := (mby*16+row)*f.width + mbx*16
+		buf = append(buf, f.y[i:i+16]...)
+	}
+
+	for _, plane := range [][]byte{f.cb, f.cr} {
+		for row := range 8 {
+			i := (mby*8+row)*f.width/2 + mbx*8
+			buf = append(buf, plane[i:i+8]...)
+		}
+	}
+
+	return buf
+}
+
+// pcmEncoder encodes pictures as H264 Constrained Baseline with I_PCM
+// macroblocks, so no transform or prediction is needed. Unchanged
+// macroblocks of P pictures are skipped, which keeps mostly static
+// pictures small.
+type pcmEncoder struct {
+	mbWidth  int
+	mbHeight int
+	frameNum uint64
+	idrID    uint
+	prev     *yuvFrame
+}
+
+func newPCMEncoder(width, height int) *pcmEncoder {
+	return &pcmEncoder{
+		mbWidth:  width / 16,
+		mbHeight: height / 16,
+	}
+}
+
+// Encode returns the access unit of the picture as an Annex B byte stream;
+// keyframes carry the parameter sets.
+func (e *pcmEncoder) Encode(frame *yuvFrame, keyframe bool) []byte {
+	idr := keyframe || e.prev == nil
+
+	var au [][]byte
+	if idr {
+		e.frameNum = 0
+		au = append(au, e.sps(), e.pps())
+	}
+
+	au = append(au, e.slice(frame, idr))
+
+	e.prev = frame.clone()
+	e.frameNum = (e.frameNum + 1) % 16
+	if idr {
+		e.idrID = (e.idrID + 1) % 2
+	}
+
+	var buf bytes.Buffer
+	for _, nal := range au {
+		buf.Write([]byte{0, 0, 0, 1})
+		buf.Write(nal)
+	}
+
+	return buf.Bytes()
+}
+
+func (e *pcmEncoder) sps() []byte {
+	var w bitWriter
+	w.bits(66, 8)   // profile_idc: Baseline
+	w.bits(0xE0, 8) // constraint_set0, 1 and 2: Constrained Baseline
+	w.bits(31, 8)   // level_idc: 3.1
+	w.ue(0)         // seq_parameter_set_id
+	w.ue(0)         // log2_max_frame_num_minus4
+	w.ue(2)         // pic_order_cnt_type: output in decoding order
+	w.ue(1)         // max_num_ref_frames
+	w.bits(0, 1)    // gaps_in_frame_num_value_allowed_flag
+	w.ue(uint(e.mbWidth - 1))
+	w.ue(uint(e.mbHeight - 1))
+	w.bits(1, 1) // frame_mbs_only_flag
+	w.bits(1, 1) // direct_8x8_inference_flag
+	w.bits(0, 1) // frame_cropping_flag
+	w.bits(0, 1) // vui_parameters_present_flag
+	w.trailing()
+
+	return nalUnit(3, nalTypeSPS, w.buf)
+}
+
+func (e *pcmEncoder) pps() []byte {
+	var w bitWriter
+	w.ue(0)      // pic_parameter_set_id
+	w.ue(0)      // seq_parameter_set_id
+	w.bits(0, 1) // entropy_coding_mode_flag: CAVLC
+	w.bits(0, 1) // bottom_field_pic_order_in_frame_present_flag
+	w.ue(0)      // num_slice_groups_minus1
+	w.ue(0)      // num_ref_idx_l0_default_active_minus1
+	w.ue(0)      // num_ref_idx_l1_default_active_minus1
+	w.bits(0, 1) // weighted_pred_flag
+	w.bits(0, 2) // weighted_bipred_idc
+	w.se(0)      // pic_init_qp_minus26
+	w.se(0)      // pic_init_qs_minus26
+	w.se(0)      // chroma_qp_index_offset
+	w.bits(1, 1) // deblocking_filter_control_present_flag
+	w.bits(0, 1) // constrained_intra_pred_flag
+	w.bits(0, 1) // redundant_pic_cnt_present_flag
+	w.trailing()
+
+	return nalUnit(3, nalTypePPS, w.buf)
+}
+
+const (
+	sliceTypeP = 5 // all slices of the picture are P
+	sliceTypeI = 7 // all slices of the picture are I
+
+	mbTypeIPCM = 25 // in I slices; intra types follow the 5 P types in P slices
+)
+
+func (e *pcmEncoder) slice(frame *yuvFrame, idr bool) []byte {
+	var w bitWriter
+	w.ue(0) // first_mb_in_slice
+	if idr {
+		w.ue(sliceTypeI)
+	} else {
+		w.ue(sliceTypeP)
+	}
+	w.ue(0) // pic_parameter_set_id
+	w.bits(e.frameNum, 4)
+
+	if idr {
+		w.ue(e.idrID)
+		w.bits(0, 1) // no_output_of_prior_pics_flag
+		w.bits(0, 1) // long_term_reference_flag
+	} else {
+		w.bits(0, 1) // num_ref_idx_active_override_flag
+		w.bits(0, 1) // ref_pic_list_modification_flag_l0
+		w.bits(0, 1) // adaptive_ref_pic_marking_mode_flag
+	}
+
+	w.se(0) // slice_qp_delta
+	w.ue(1) // disable_deblocking_filter_idc
+
+	var skipped uint
+	mb := make([]byte, 0, 384)
+	for mby := range e.mbHeight {
+		for mbx := range e.mbWidth {
+			mb = frame.macroblock(mb[:0], mbx, mby)
+
+			if idr {
+				w.ue(mbTypeIPCM)
+			} else {
+				if bytes.Equal(mb, e.prev.macroblock(nil, mbx, mby)) {
+					skipped++
+					continue
+				}
+
+				w.ue(skipped) // mb_skip_run
+				skipped = 0
+
+				w.ue(5 + mbTypeIPCM)
+			}
+
+			w.align() // pcm_alignment_zero_bit
+			w.buf = append(w.buf, mb...)
+		}
+	}
+
+	if skipped > 0 {
+		w.ue(skipped)
+	}
+
+	w.trailing()
+
+	if idr {
+		return nalUnit(3, nalTypeIDR, w.buf)
+	}
+
+	return nalUnit(2, nalTypeSlice, w.buf)
+}
+
+// nalUnit returns a NAL unit of the RBSP, with emulation prevention bytes
+// so no start code appears inside it.
+func nalUnit(refIdc, typ uint8, rbsp []byte) []byte {
+	nal := make([]byte, 0, len(rbsp)+len(rbsp)/64+1)
+	nal = append(nal, refIdc<<5|typ)
+
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+
+		nal = append(nal, b)
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return nal
+}
+
+// bitWriter writes an RBSP most significant bit first.
+type bitWriter struct {
+	buf  []byte
+	cur  byte
+	used uint8 // bits used in cur
+}
+
+func (w *bitWriter) bits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>i&1)
+		w.used++
+
+		if w.used == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.used = 0, 0
+		}
+	}
+}
+
+// ue writes an unsigned Exp-Golomb code.
+func (w *bitWriter) ue(v uint) {
+	x := uint64(v) + 1
+
+	n := 0
+	for t := x; t > 1; t >>= 1 {
+		n++
+	}
+
+	w.bits(0, n)
+	w.bits(x, n+1)
+}
+
+// se writes a signed Exp-Golomb code.
+func (w *bitWriter) se(v int) {
+	if v > 0 {
+		w.ue(uint(2*v - 1))
+	} else {
+		w.ue(uint(-2 * v))
+	}
+}
+
+func (w *bitWriter) align() {
+	if w.used > 0 {
+		w.bits(0, int(8-w.used))
+	}
+}
+
+// trailing writes the RBSP stop bit and aligns.
+func (w *bitWriter) trailing() {
+	w.bits(1, 1)
+	w.align()
+}
@@ -0,0 +1,207 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bitReader reads back what bitWriter wrote.
+type bitReader struct {
+	buf []byte
+	pos int // in bits
+}
+
+func (r *bitReader) bits(n int) uint64 {
+	var v uint64
+	for range n {
+		v = v<<1 | uint64(r.buf[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+
+	return v
+}
+
+func (r *bitReader) ue() uint {
+	n := 0
+	for r.bits(1) == 0 {
+		n++
+	}
+
+	return uint(1<<n - 1 + r.bits(n))
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
+
+// unescape removes emulation prevention bytes.
+func unescape(nal []byte) []byte {
+	var rbsp []byte
+
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+
+		rbsp = append(rbsp, b)
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return rbsp
+}
+
+// decodePCM decodes a slice of the PCM encoder into ref.
+func decodePCM(t *testing.T, nal []byte, ref *yuvFrame) {
+	assert := assert.New(t)
+
+	r := &bitReader{buf: unescape(nal[1:])}
+	idr := nalType(nal) == nalTypeIDR
+
+	assert.Equal(uint(0), r.ue()) // first_mb_in_slice
+	sliceType := r.ue()
+	assert.Equal(uint(0), r.ue()) // pic_parameter_set_id
+	r.bits(4)                     // frame_num
+
+	if idr {
+		assert.Equal(uint(sliceTypeI), sliceType)
+		r.ue()
+		r.bits(2)
+	} else {
+		assert.Equal(uint(sliceTypeP), sliceType)
+		r.bits(3)
+	}
+
+	assert.Equal(uint(0), r.ue()) // slice_qp_delta
+	assert.Equal(uint(1), r.ue()) // disable_deblocking_filter_idc
+
+	mbWidth := ref.width / 16
+	total := mbWidth * ref.height / 16
+
+	for mb := 0; mb < total; mb++ {
+		if !idr {
+			mb += int(r.ue())
+			if mb == total {
+				break
+			}
+
+			assert.Equal(uint(5+mbTypeIPCM), r.ue())
+		} else {
+			assert.Equal(uint(mbTypeIPCM), r.ue())
+		}
+
+		r.align()
+
+		mbx, mby := mb%mbWidth, mb/mbWidth
+		for row := range 16 {
+			for col := range 16 {
+				ref.y[(mby*16+row)*ref.width+mbx*16+col] = byte(r.bits(8))
+			}
+		}
+
+		for _, plane := range [][]byte{ref.cb, ref.cr} {
+			for row := range 8 {
+				for col := range 8 {
+					plane[(mby*8+row)*ref.width/2+mbx*8+col] = byte(r.bits(8))
+				}
+			}
+		}
+	}
+
+	assert.Equal(uint64(1), r.bits(1)) // rbsp_stop_one_bit
+	r.align()
+	assert.Equal(len(r.buf)*8, r.pos)
+}
+
+func TestPCMEncoder(t *testing.T) {
+	assert := assert.New(t)
+
+	encoder := newPCMEncoder(DemoWidth, DemoHeight)
+	frame := newYUVFrame(DemoWidth, DemoHeight)
+	ref := newYUVFrame(DemoWidth, DemoHeight)
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for n := range 5 {
+		drawDemoFrame(frame, n, start.Add(time.Duration(n)*33*time.Millisecond))
+
+		nals := splitAnnexB(encoder.Encode(frame, n == 3))
+
+		if n == 0 || n == 3 {
+			assert.Len(nals, 3)
+			assert.Equal(nalTypeSPS, nalType(nals[0]))
+			assert.Equal(nalTypePPS, nalType(nals[1]))
+			assert.Equal(nalTypeIDR, nalType(nals[2]))
+		} else {
+			assert.Len(nals, 1)
+			assert.Equal(nalTypeSlice, nalType(nals[0]))
+		}
+
+		// no start code inside a NAL unit
+		for _, nal := range nals {
+			for i := 0; i+2 < len(nal); i++ {
+				assert.False(nal[i] == 0 && nal[i+1] == 0 && nal[i+2] <= 1)
+			}
+		}
+
+		slice := nals[len(nals)-1]
+		decodePCM(t, slice, ref)
+		assert.Equal(frame, ref)
+
+		// P pictures skip the unchanged macroblocks
+		if n == 1 {
+			assert.Less(len(slice), DemoWidth*DemoHeight/4)
+		}
+	}
+}
+
+func TestBitWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	var w bitWriter
+	w.ue(0)  // 1
+	w.ue(3)  // 00100
+	w.se(-2) // 00101
+	w.se(1)  // 010
+	w.trailing()
+
+	assert.Equal([]byte{0b10010000, 0b10101010}, w.buf)
+
+	// emulation prevention
+	assert.Equal([]byte{0x67, 0, 0, 3, 1, 0, 0, 3, 0, 3}, nalUnit(3, nalTypeSPS, []byte{0, 0, 1, 0, 0, 0, 3}))
+}
+
+func TestDemoTone(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(byte(0xFF), mulaw(0))
+	assert.Equal(byte(0x80), mulaw(32767))
+	assert.Equal(byte(0x00), mulaw(-32768))
+
+	// a beep starts each second
+	frame := demoTone(0, 160)
+	assert.Len(frame, 160)
+	assert.NotEqual(byte(0xFF), frame[1])
+
+	frame = demoTone(4000, 160)
+	for _, b := range frame {
+		assert.Equal(byte(0xFF), b)
+	}
+}
+
+func TestDemoStream(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := DemoStream(DefaultStream)
+	assert.Equal(TransportDemo, stream.Transport)
+	assert.Equal(CodecH264, stream.Video.Codec())
+	assert.Equal(CodecPCMU, stream.Audio.Codec())
+}
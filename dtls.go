@@ -0,0 +1,342 @@
+package game
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v3"
+	"github.com/pion/dtls/v3/pkg/protocol/extension"
+	"github.com/pion/dtls/v3/pkg/protocol/handshake"
+	"github.com/pion/interceptor"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// DTLSRole is the DTLS role the service answers with. The SRTP profile of
+// a peer is only known when the service is the DTLS server.
+type DTLSRole string
+
+const (
+	DTLSRoleAuto   DTLSRole = "auto" // client, unless the client asks otherwise
+	DTLSRoleClient DTLSRole = "client"
+	DTLSRoleServer DTLSRole = "server"
+)
+
+func (role *DTLSRole) UnmarshalYAML(value *yaml.Node) error {
+	var raw string
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	switch r := DTLSRole(raw); r {
+	case "":
+		*role = DTLSRoleAuto
+	case DTLSRoleAuto, DTLSRoleClient, DTLSRoleServer:
+		*role = r
+	default:
+		return errors.New("dtls role unsupported: " + raw)
+	}
+
+	return nil
+}
+
+// DTLSInfo is what a peer's DTLS handshake negotiated, for audits of
+// fingerprint mismatches and man-in-the-middle reports.
+type DTLSInfo struct {
+	Role              string                   `json:"role,omitempty"` // of the service: client or server
+	State             string                   `json:"state"`
+	LocalFingerprints []webrtc.DTLSFingerprint `json:"local_fingerprints"`
+
+	// RemoteFingerprint is the SHA-256 fingerprint of the certificate the
+	// client presented; Matched tells whether its SDP signaled it.
+	RemoteFingerprint    string                   `json:"remote_fingerprint,omitempty"`
+	SignaledFingerprints []webrtc.DTLSFingerprint `json:"signaled_fingerprints,omitempty"`
+	Matched              bool                     `json:"matched"`
+
+	CipherSuite string `json:"cipher_suite,omitempty"`
+	SRTPProfile string `json:"srtp_profile,omitempty"`
+}
+
+// dtlsHandshake records the cipher suite and SRTP profile the service's
+// ServerHello selects.
+type dtlsHandshake struct {
+	cipherSuite string
+	srtpProfile string
+	sync.RWMutex
+}
+
+func (h *dtlsHandshake) serverHello(msg handshake.MessageServerHello) handshake.Message {
+	h.Lock()
+	defer h.Unlock()
+
+	if msg.CipherSuiteID != nil {
+		h.cipherSuite = dtls.CipherSuiteName(dtls.CipherSuiteID(*msg.CipherSuiteID))
+	}
+
+	for _, ext := range msg.Extensions {
+		if srtp, ok := ext.(*extension.UseSRTP); ok && len(srtp.ProtectionProfiles) > 0 {
+			h.srtpProfile = srtpProfileName(srtp.ProtectionProfiles[0])
+		}
+	}
+
+	return &msg
+}
+
+func (h *dtlsHandshake) selected() (cipherSuite, srtpProfile string) {
+	h.RLock()
+	defer h.RUnlock()
+
+	return h.cipherSuite, h.srtpProfile
+}
+
+// srtpProfileName returns the IANA name of an SRTP protection profile.
+func srtpProfileName(profile extension.SRTPProtectionProfile) string {
+	switch profile {
+	case extension.SRTP_AES128_CM_HMAC_SHA1_80:
+		return "SRTP_AES128_CM_HMAC_SHA1_80"
+	case extension.SRTP_AES128_CM_HMAC_SHA1_32:
+		return "SRTP_AES128_CM_HMAC_SHA1_32"
+	case extension.SRTP_AEAD_AES_128_GCM:
+		return "SRTP_AEAD_AES_128_GCM"
+	case extension.SRTP_AEAD_AES_256_GCM:
+		return "SRTP_AEAD_AES_256_GCM"
+	case extension.SRTP_NULL_HMAC_SHA1_80:
+		return "SRTP_NULL_HMAC_SHA1_80"
+	case extension.SRTP_NULL_HMAC_SHA1_32:
+		return "SRTP_NULL_HMAC_SHA1_32"
+	default:
+		return "unknown"
+	}
+}
+
+// peerMedia is what a peer's media engine negotiates besides pion's
+// defaults: the codec of its video, a target latency bounding the
+// playout delay of its video, whether its microphone sends levels, and the
+// Opus parameters of its audio.
+type peerMedia struct {
+	video         Codec
+	targetLatency *time.Duration
+	audioLevel    bool
+	opusFmtp      string // empty for pion's
+}
+
+// newPeerAPI returns the API of a peer connection, answering with the DTLS
+// role and recording its handshake.
+func newPeerAPI(role DTLSRole, h *dtlsHandshake, media peerMedia) (*webrtc.API, error) {
+	var se webrtc.SettingEngine
+
+	switch role {
+	case DTLSRoleClient:
+		if err := se.SetAnsweringDTLSRole(webrtc.DTLSRoleClient); err != nil {
+			return nil, err
+		}
+	case DTLSRoleServer:
+		if err := se.SetAnsweringDTLSRole(webrtc.DTLSRoleServer); err != nil {
+			return nil, err
+		}
+	}
+
+	se.SetDTLSServerHelloMessageHook(h.serverHello)
+
+	// As webrtc.NewAPI does by default, besides the peer's media.
+	m := new(webrtc.MediaEngine)
+
+	// Registered first, the Opus parameters take the place of pion's.
+	if media.opusFmtp != "" {
+		if err := m.RegisterCodec(opusCodec(media.opusFmtp), webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+
+	if media.video == CodecH265 {
+		if err := registerHEVC(m); err != nil {
+			return nil, err
+		}
+	}
+
+	if media.audioLevel {
+		err := m.RegisterHeaderExtension(
+			webrtc.RTPHeaderExtensionCapability{URI: audioLevelURI},
+			webrtc.RTPCodecTypeAudio,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	registry := new(interceptor.Registry)
+	if err := webrtc.RegisterDefaultInterceptors(m, registry); err != nil {
+		return nil, err
+	}
+
+	if target := media.targetLatency; target != nil {
+		if err := registerPlayoutDelay(m, registry, *target); err != nil {
+			return nil, err
+		}
+	}
+
+	return webrtc.NewAPI(
+		webrtc.WithSettingEngine(se),
+		webrtc.WithMediaEngine(m),
+		webrtc.WithInterceptorRegistry(registry),
+	), nil
+}
+
+// DTLS returns what the peer's DTLS handshake negotiated so far.
+func (peer *Peer) DTLS() *DTLSInfo {
+	transport := peer.SCTP().Transport()
+
+	info := &DTLSInfo{
+		State: transport.State().String(),
+	}
+
+	if params, err := transport.GetLocalParameters(); err == nil {
+		info.LocalFingerprints = params.Fingerprints
+	}
+
+	if local := peer.CurrentLocalDescription(); local != nil {
+		switch sdpAttribute(local, "setup") {
+		case "active":
+			info.Role = "client"
+		case "passive":
+			info.Role = "server"
+		}
+	}
+
+	if remote := peer.CurrentRemoteDescription(); remote != nil {
+		info.SignaledFingerprints = signaledFingerprints(remote)
+	}
+
+	if cert := transport.GetRemoteCertificate(); len(cert) > 0 {
+		info.RemoteFingerprint = certificateFingerprint(cert)
+
+		for _, fp := range info.SignaledFingerprints {
+			if strings.EqualFold(fp.Algorithm, "sha-256") && strings.EqualFold(fp.Value, info.RemoteFingerprint) {
+				info.Matched = true
+			}
+		}
+	}
+
+	if peer.dtls != nil {
+		info.CipherSuite, info.SRTPProfile = peer.dtls.selected()
+	}
+
+	return info
+}
+
+func (peer *Peer) logDTLS() {
+	info := peer.DTLS()
+
+	fields := []zap.Field{
+		zap.String("role", info.Role),
+		zap.String("remote_fingerprint", info.RemoteFingerprint),
+		zap.Bool("matched", info.Matched),
+		zap.String("cipher_suite", info.CipherSuite),
+		zap.String("srtp_profile", info.SRTPProfile),
+	}
+
+	for _, fp := range info.LocalFingerprints {
+		fields = append(fields, zap.String("local_fingerprint", fp.Algorithm+" "+fp.Value))
+	}
+
+	peer.log.Info("dtls established", fields...)
+}
+
+// certificateFingerprint returns the SHA-256 fingerprint of a DER
+// certificate, as signaled in SDP.
+func certificateFingerprint(cert []byte) string {
+	sum := sha256.Sum256(cert)
+
+	hexed := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(hexed); i += 2 {
+		parts = append(parts, hexed[i:i+2])
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// parseSDP parses a copy of the description's SDP. The descriptions pion
+// returns cache their parsed form, which pion reads concurrently, so
+// Unmarshal must not be called on them.
+func parseSDP(desc *webrtc.SessionDescription) (*sdp.SessionDescription, error) {
+	parsed := new(sdp.SessionDescription)
+	if err := parsed.UnmarshalString(desc.SDP); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// sdpAttribute returns the value of the first attribute named key, at the
+// session level or else in a media section.
+func sdpAttribute(desc *webrtc.SessionDescription, key string) string {
+	parsed, err := parseSDP(desc)
+	if err != nil {
+		return ""
+	}
+
+	if value, ok := parsed.Attribute(key); ok {
+		return value
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		if value, ok := media.Attribute(key); ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// signaledFingerprints returns the fingerprints a description signals.
+func signaledFingerprints(desc *webrtc.SessionDescription) []webrtc.DTLSFingerprint {
+	parsed, err := parseSDP(desc)
+	if err != nil {
+		return nil
+	}
+
+	var fingerprints []webrtc.DTLSFingerprint
+	seen := make(map[string]bool)
+
+	add := func(value string) {
+		algorithm, fingerprint, ok := strings.Cut(value, " ")
+		if !ok || seen[value] {
+			return
+		}
+		seen[value] = true
+
+		fingerprints = append(fingerprints, webrtc.DTLSFingerprint{
+			Algorithm: algorithm,
+			Value:     fingerprint,
+		})
+	}
+
+	for _, attr := range parsed.Attributes {
+		if attr.Key == "fingerprint" {
+			add(attr.Value)
+		}
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		for _, attr := range media.Attributes {
+			if attr.Key == "fingerprint" {
+				add(attr.Value)
+			}
+		}
+	}
+
+	return fingerprints
+}
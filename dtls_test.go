@@ -0,0 +1,118 @@
+package game
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDTLSRole(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg WebRTC
+	err := yaml.Unmarshal([]byte("dtlsRole: server"), &cfg)
+	if assert.NoError(err) {
+		assert.Equal(DTLSRoleServer, cfg.DTLSRole)
+	}
+
+	err = yaml.Unmarshal([]byte("dtlsRole: passive"), &cfg)
+	assert.EqualError(err, "dtls role unsupported: passive")
+}
+
+func TestCertificateFingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	cert := []byte("certificate")
+	sum := sha256.Sum256(cert)
+
+	fp := certificateFingerprint(cert)
+	assert.Len(fp, 32*3-1)
+	assert.Equal(strings.ToUpper(hex.EncodeToString(sum[:])), strings.ReplaceAll(fp, ":", ""))
+}
+
+func TestPeerDTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	handshake := new(dtlsHandshake)
+
+	api, err := newPeerAPI(DTLSRoleServer, handshake, peerMedia{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	server, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer server.Close()
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer client.Close()
+
+	if _, err := client.CreateDataChannel("control", nil); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	connected := make(chan struct{})
+	server.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			close(connected)
+		}
+	})
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	clientGathered := webrtc.GatheringCompletePromise(client)
+	client.SetLocalDescription(offer)
+	<-clientGathered
+
+	server.SetRemoteDescription(*client.LocalDescription())
+
+	answer, err := server.CreateAnswer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	serverGathered := webrtc.GatheringCompletePromise(server)
+	server.SetLocalDescription(answer)
+	<-serverGathered
+
+	client.SetRemoteDescription(*server.LocalDescription())
+
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		assert.Fail("peer not connected")
+		return
+	}
+
+	peer := &Peer{PeerConnection: server, dtls: handshake}
+
+	info := peer.DTLS()
+	assert.Equal("server", info.Role)
+	assert.Equal("connected", info.State)
+	assert.NotEmpty(info.LocalFingerprints)
+	assert.NotEmpty(info.SignaledFingerprints)
+	assert.NotEmpty(info.RemoteFingerprint)
+	assert.True(info.Matched)
+	assert.NotEmpty(info.CipherSuite)
+	assert.True(strings.HasPrefix(info.SRTPProfile, "SRTP_"), info.SRTPProfile)
+}
@@ -0,0 +1,123 @@
+package game
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+// EventType names a lifecycle event; it is published on the subject
+// EventSubjectPrefix + type.
+type EventType string
+
+const EventSubjectPrefix = "game.events."
+
+const (
+	EventPeerConnected      EventType = "peer.connected"
+	EventPeerDisconnected   EventType = "peer.disconnected"
+	EventPeerFailed         EventType = "peer.failed"
+	EventStreamStarted      EventType = "stream.started"
+	EventStreamStopped      EventType = "stream.stopped"
+	EventStreamStalled      EventType = "stream.stalled"
+	EventStreamIdle         EventType = "stream.idle"
+	EventStreamResumed      EventType = "stream.resumed"
+	EventNVStreamTerminated EventType = "nvstream.terminated"
+	EventPairingCompleted   EventType = "pairing.completed"
+)
+
+func (t EventType) Subject() string {
+	return EventSubjectPrefix + string(t)
+}
+
+// Event is the JSON envelope of every event.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+type PeerEvent struct {
+	Peer        string `json:"peer"`
+	Stream      string `json:"stream"`
+	Permissions string `json:"permissions"`
+	Guest       string `json:"guest,omitempty"`
+}
+
+// PeerFailedEvent is published when a peer missed a deadline of its setup,
+// before it is closed.
+type PeerFailedEvent struct {
+	PeerEvent
+	Reason    string        `json:"reason"`
+	Timeout   time.Duration `json:"timeout"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+type StreamEvent struct {
+	Stream    string    `json:"stream"`
+	Transport Transport `json:"transport"`
+}
+
+type StreamStalledEvent struct {
+	Stream  string        `json:"stream"`
+	Track   string        `json:"track"`
+	Stalled time.Duration `json:"stalled"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type StreamIdleEvent struct {
+	Stream string        `json:"stream"`
+	Reason string        `json:"reason"`
+	Idle   time.Duration `json:"idle"`
+}
+
+type NVStreamTerminatedEvent struct {
+	Stream    string `json:"stream"`
+	ErrorCode int    `json:"error_code"`
+}
+
+type PairingCompletedEvent struct {
+	Stream string             `json:"stream"`
+	State  nvstream.PairState `json:"state"`
+	Reason string             `json:"reason,omitempty"`
+}
+
+// EventBus publishes lifecycle events on NATS so dashboards and automations
+// can react to them. Publishing is best effort; a nil bus drops events.
+type EventBus struct {
+	nc  *nats.Conn
+	log *zap.Logger
+}
+
+func NewEventBus(nc *nats.Conn, log *zap.Logger) *EventBus {
+	return &EventBus{
+		nc:  nc,
+		log: log,
+	}
+}
+
+func (bus *EventBus) Publish(t EventType, data any) {
+	if bus == nil || bus.nc == nil {
+		return
+	}
+
+	bs, err := json.Marshal(&Event{
+		Type: t,
+		Time: time.Now(),
+		Data: data,
+	})
+
+	if err != nil {
+		bus.log.Error(err.Error(), zap.String("event", string(t)))
+		return
+	}
+
+	if err := bus.nc.Publish(t.Subject(), bs); err != nil {
+		bus.log.Warn("event dropped",
+			zap.String("event", string(t)),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,486 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// File drop messages, sent as JSON text on the "files" data channel.
+// File contents follow a file.start as chunk frames of a single transfer.
+const (
+	// client -> server
+	FileStart  ControlMessageType = "file.start"
+	FileCancel ControlMessageType = "file.cancel"
+
+	// server -> client
+	FileProgress ControlMessageType = "file.progress"
+	FileComplete ControlMessageType = "file.complete"
+)
+
+// maxFileCopies bounds the numbered copies an upload may be stored as when
+// its name is taken.
+const maxFileCopies = 99
+
+type FileInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Target string `json:"target,omitempty"` // the drop's directory if empty
+}
+
+type FileProgressPayload struct {
+	Received int64 `json:"received"`
+}
+
+type FileDrop struct {
+	Enabled    bool
+	Path       string
+	MaxSizeMB  int64
+	Extensions []string
+	Targets    map[string]*FileTarget
+
+	dir string
+}
+
+// FileTarget is another allowlisted directory uploads may name as their
+// target, e.g. a game's save or mod folder. Its limits default to the
+// drop's.
+type FileTarget struct {
+	Path       string
+	MaxSizeMB  int64
+	Extensions []string
+
+	dir string
+}
+
+func (cfg *FileDrop) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Enabled    bool     `yaml:"enabled"`
+		Path       string   `yaml:"path"`
+		MaxSizeMB  int64    `yaml:"maxSizeMB"`
+		Extensions []string `yaml:"extensions"`
+		Targets    map[string]struct {
+			Path       string   `yaml:"path"`
+			MaxSizeMB  int64    `yaml:"maxSizeMB"`
+			Extensions []string `yaml:"extensions"`
+		} `yaml:"targets"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Path == "" {
+		raw.Path = "uploads"
+	}
+
+	if raw.MaxSizeMB <= 0 {
+		raw.MaxSizeMB = 16
+	}
+
+	targets := make(map[string]*FileTarget)
+	for name, t := range raw.Targets {
+		if t.Path == "" {
+			return errors.New("file target without path: " + name)
+		}
+
+		if t.MaxSizeMB <= 0 {
+			t.MaxSizeMB = raw.MaxSizeMB
+		}
+
+		if len(t.Extensions) == 0 {
+			t.Extensions = raw.Extensions
+		}
+
+		targets[name] = &FileTarget{
+			Path:       t.Path,
+			MaxSizeMB:  t.MaxSizeMB,
+			Extensions: normalizeExtensions(t.Extensions),
+		}
+	}
+
+	cfg.Enabled = raw.Enabled
+	cfg.Path = raw.Path
+	cfg.MaxSizeMB = raw.MaxSizeMB
+	cfg.Extensions = normalizeExtensions(raw.Extensions)
+	cfg.Targets = targets
+
+	return nil
+}
+
+func normalizeExtensions(exts []string) []string {
+	normalized := make([]string, len(exts))
+	for i, ext := range exts {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		normalized[i] = ext
+	}
+
+	return normalized
+}
+
+// target returns the rules and the directory of the upload's target.
+func (cfg *FileDrop) target(name string) (*FileTarget, error) {
+	if name == "" {
+		return &FileTarget{
+			Path:       cfg.Path,
+			MaxSizeMB:  cfg.MaxSizeMB,
+			Extensions: cfg.Extensions,
+			dir:        cfg.dir,
+		}, nil
+	}
+
+	t, ok := cfg.Targets[name]
+	if !ok {
+		return nil, NewControlError(ControlErrBadRequest, "unknown file target")
+	}
+
+	return t, nil
+}
+
+// maxSizeMB is the largest upload any target takes.
+func (cfg *FileDrop) maxSizeMB() int64 {
+	size := cfg.MaxSizeMB
+	for _, t := range cfg.Targets {
+		size = max(size, t.MaxSizeMB)
+	}
+
+	return size
+}
+
+// Validate checks an upload against the sandbox rules: a known target, a
+// plain file name without any directory part, an allowed extension and the
+// size limit.
+func (cfg *FileDrop) Validate(info FileInfo) error {
+	target, err := cfg.target(info.Target)
+	if err != nil {
+		return err
+	}
+
+	name := info.Name
+	if name == "" || len(name) > 255 || strings.HasPrefix(name, ".") ||
+		strings.ContainsAny(name, `/\:`) || name != filepath.Base(name) {
+		return NewControlError(ControlErrBadRequest, "invalid file name")
+	}
+
+	if info.Size < 0 {
+		return NewControlError(ControlErrBadRequest, "invalid file size")
+	}
+
+	if info.Size > target.MaxSizeMB<<20 {
+		return NewControlError(ControlErrTooLarge, "file too large")
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if len(target.Extensions) > 0 && !slices.Contains(target.Extensions, ext) {
+		return NewControlError(ControlErrUnsupported, "file type not allowed")
+	}
+
+	return nil
+}
+
+// fileDir resolves a drop directory relative to the working directory and
+// creates it.
+func fileDir(root, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// fileReceiver handles one "files" data channel. Transfers are sequential:
+// a file.start announces the file, chunk frames carry its contents and
+// each chunk is acknowledged with the bytes received so far. The upload is
+// written to a temporary file and moved into place after the final chunk.
+type fileReceiver struct {
+	log    *zap.Logger
+	cfg    *FileDrop
+	chunks *ChunkReader
+
+	// send is used for results, trySend for progress which may be
+	// skipped while the channel is congested.
+	send    func(msg *ControlMessage) error
+	trySend func(msg *ControlMessage) bool
+
+	id       string
+	info     FileInfo
+	dir      string
+	file     *os.File
+	transfer *uint32
+	received int64
+	sync.Mutex
+}
+
+func newFileReceiver(cfg *FileDrop, dc *monitoredChannel, log *zap.Logger) *fileReceiver {
+	return &fileReceiver{
+		log: log.With(
+			zap.String("handler", "files"),
+		),
+		cfg:    cfg,
+		chunks: NewChunkReader(int(cfg.maxSizeMB() << 20)),
+		send: func(msg *ControlMessage) error {
+			return dc.SendJSON(msg)
+		},
+		trySend: func(msg *ControlMessage) bool {
+			return dc.TrySendJSON(msg)
+		},
+	}
+}
+
+func (r *fileReceiver) HandleMessage(msg webrtc.DataChannelMessage) {
+	r.Lock()
+	defer r.Unlock()
+
+	if !msg.IsString {
+		r.handleChunk(msg.Data)
+		return
+	}
+
+	var m *ControlMessage
+	if err := json.Unmarshal(msg.Data, &m); err != nil {
+		r.sendError("", NewControlError(ControlErrBadRequest, err.Error()))
+		return
+	}
+
+	switch m.Type {
+	case FileStart:
+		var info FileInfo
+		if err := json.Unmarshal(m.Payload, &info); err != nil {
+			r.sendError(m.ID, NewControlError(ControlErrBadRequest, err.Error()))
+			return
+		}
+
+		if err := r.start(m.ID, info); err != nil {
+			r.sendError(m.ID, ControlErrorFrom(err))
+		}
+
+	case FileCancel:
+		if r.file != nil {
+			r.log.Info("file upload canceled", zap.String("name", r.info.Name))
+			r.abort()
+		}
+
+	default:
+		r.sendError(m.ID, NewControlError(ControlErrUnsupported, "unsupported file message"))
+	}
+}
+
+func (r *fileReceiver) start(id string, info FileInfo) error {
+	if r.file != nil {
+		return NewControlError(ControlErrUnavailable, "file upload in progress")
+	}
+
+	if err := r.cfg.Validate(info); err != nil {
+		return err
+	}
+
+	target, err := r.cfg.target(info.Target)
+	if err != nil {
+		return err
+	}
+
+	// Temporary files are created next to the upload, so it is moved
+	// into place within the same file system.
+	f, err := os.CreateTemp(target.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+
+	r.id = id
+	r.info = info
+	r.dir = target.dir
+	r.file = f
+	r.transfer = nil
+	r.received = 0
+
+	r.log.Info("file upload started",
+		zap.String("name", info.Name),
+		zap.String("target", info.Target),
+		zap.Int64("size", info.Size))
+
+	if info.Size == 0 {
+		return r.complete()
+	}
+
+	return nil
+}
+
+func (r *fileReceiver) handleChunk(frame []byte) {
+	if r.file == nil {
+		r.sendError("", NewControlError(ControlErrBadRequest, "no file upload in progress"))
+		return
+	}
+
+	id := r.id
+
+	chunk, err := r.chunks.Read(frame)
+	if err != nil {
+		r.abort()
+		r.sendError(id, NewControlError(ControlErrBadRequest, err.Error()))
+		return
+	}
+
+	if r.transfer == nil {
+		r.transfer = &chunk.Transfer
+	}
+
+	if *r.transfer != chunk.Transfer {
+		r.chunks.Reset(chunk.Transfer)
+		r.abort()
+		r.sendError(id, NewControlError(ControlErrBadRequest, "unexpected transfer"))
+		return
+	}
+
+	if r.received+int64(len(chunk.Data)) > r.info.Size {
+		r.abort()
+		r.sendError(id, NewControlError(ControlErrTooLarge, "file exceeds announced size"))
+		return
+	}
+
+	if _, err := r.file.Write(chunk.Data); err != nil {
+		r.abort()
+		r.sendError(id, ControlErrorFrom(err))
+		return
+	}
+
+	r.received += int64(len(chunk.Data))
+
+	if chunk.Final {
+		if r.received != r.info.Size {
+			r.abort()
+			r.sendError(id, NewControlError(ControlErrBadRequest, "file smaller than announced size"))
+			return
+		}
+
+		if err := r.complete(); err != nil {
+			r.sendError(id, ControlErrorFrom(err))
+		}
+
+		return
+	}
+
+	msg, err := NewControlMessage(FileProgress, &FileProgressPayload{r.received})
+	if err != nil {
+		return
+	}
+
+	msg.ID = id
+
+	// Progress is cumulative, so a skipped ack is covered by the next one.
+	r.trySend(msg)
+}
+
+func (r *fileReceiver) complete() error {
+	f := r.file
+	r.file = nil
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	name, err := linkFree(f.Name(), r.dir, r.info.Name)
+	os.Remove(f.Name())
+
+	if err != nil {
+		return err
+	}
+
+	r.info.Name = name
+
+	path := filepath.Join(r.dir, name)
+
+	r.log.Info("file upload completed",
+		zap.String("name", r.info.Name),
+		zap.String("path", path),
+		zap.Int64("size", r.info.Size))
+
+	msg, err := NewControlMessage(FileComplete, &r.info)
+	if err != nil {
+		return err
+	}
+
+	msg.ID = r.id
+
+	return r.send(msg)
+}
+
+// linkFree links the file at tmp into dir as name, or as "name (n).ext"
+// with the lowest n free, and returns the name taken. A link never replaces
+// a file, so an upload cannot overwrite one, even one created meanwhile.
+func linkFree(tmp, dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for n := 1; ; n++ {
+		err := os.Link(tmp, filepath.Join(dir, candidate))
+		if err == nil {
+			return candidate, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+
+		if n > maxFileCopies {
+			return "", errors.New("too many copies of file: " + name)
+		}
+
+		candidate = base + " (" + strconv.Itoa(n) + ")" + ext
+	}
+}
+
+func (r *fileReceiver) abort() {
+	if r.file == nil {
+		return
+	}
+
+	if r.transfer != nil {
+		r.chunks.Reset(*r.transfer)
+		r.transfer = nil
+	}
+
+	r.file.Close()
+	os.Remove(r.file.Name())
+
+	r.file = nil
+}
+
+// Close discards an unfinished upload.
+func (r *fileReceiver) Close() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.abort()
+}
+
+func (r *fileReceiver) sendError(id string, e *ControlErrorPayload) {
+	msg, err := NewControlMessage(ControlError, e)
+	if err != nil {
+		return
+	}
+
+	msg.ID = id
+
+	if err := r.send(msg); err != nil {
+		r.log.Error(err.Error())
+	}
+}
@@ -0,0 +1,207 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFileDropValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &FileDrop{
+		MaxSizeMB:  1,
+		Extensions: []string{".sav"},
+	}
+
+	assert.NoError(cfg.Validate(FileInfo{Name: "slot1.sav", Size: 1024}))
+	assert.Error(cfg.Validate(FileInfo{Name: "../slot1.sav", Size: 1024}))
+	assert.Error(cfg.Validate(FileInfo{Name: `..\slot1.sav`, Size: 1024}))
+	assert.Error(cfg.Validate(FileInfo{Name: ".bashrc", Size: 1024}))
+	assert.Error(cfg.Validate(FileInfo{Name: "slot1.exe", Size: 1024}))
+
+	e := ControlErrorFrom(cfg.Validate(FileInfo{Name: "slot1.sav", Size: 2 << 20}))
+	assert.Equal(ControlErrTooLarge, e.Code)
+
+	e = ControlErrorFrom(cfg.Validate(FileInfo{Name: "slot1.sav", Size: 1024, Target: "mods"}))
+	assert.Equal(ControlErrBadRequest, e.Code)
+}
+
+func TestFileDropTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *FileDrop
+	err := yaml.Unmarshal([]byte(`
+enabled: true
+extensions: [ sav ]
+targets:
+  saves:
+    path: /games/saves
+  mods:
+    path: /games/mods
+    maxSizeMB: 256
+    extensions: [ .ZIP, pak ]
+`), &cfg)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(int64(256), cfg.maxSizeMB())
+
+	saves := cfg.Targets["saves"]
+	assert.Equal(int64(16), saves.MaxSizeMB)
+	assert.Equal([]string{".sav"}, saves.Extensions)
+
+	mods := cfg.Targets["mods"]
+	assert.Equal([]string{".zip", ".pak"}, mods.Extensions)
+
+	assert.NoError(cfg.Validate(FileInfo{Name: "ui.pak", Size: 100 << 20, Target: "mods"}))
+	assert.Error(cfg.Validate(FileInfo{Name: "ui.pak", Size: 100 << 20}))
+	assert.Error(cfg.Validate(FileInfo{Name: "slot1.sav", Size: 1024, Target: "mods"}))
+	assert.NoError(cfg.Validate(FileInfo{Name: "slot1.sav", Size: 1024, Target: "saves"}))
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("targets: { saves: {} }"), &cfg)
+	assert.ErrorContains(err, "without path")
+}
+
+func TestFileReceiver(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	var sent []*ControlMessage
+	r := &fileReceiver{
+		log:    zap.NewNop(),
+		cfg:    &FileDrop{MaxSizeMB: 1, dir: dir},
+		chunks: NewChunkReader(1 << 20),
+		send: func(msg *ControlMessage) error {
+			sent = append(sent, msg)
+			return nil
+		},
+		trySend: func(msg *ControlMessage) bool {
+			sent = append(sent, msg)
+			return true
+		},
+	}
+
+	r.HandleMessage(webrtc.DataChannelMessage{
+		IsString: true,
+		Data:     []byte(`{"id":"1","type":"file.start","payload":{"name":"slot1.sav","size":6}}`),
+	})
+
+	first := &Chunk{Transfer: 1, Seq: 0, Data: []byte("abc")}
+	final := &Chunk{Transfer: 1, Seq: 1, Final: true, Data: []byte("def")}
+
+	r.HandleMessage(webrtc.DataChannelMessage{Data: first.Marshal()})
+	r.HandleMessage(webrtc.DataChannelMessage{Data: final.Marshal()})
+
+	if !assert.Len(sent, 2) {
+		return
+	}
+
+	assert.Equal(FileProgress, sent[0].Type)
+	assert.JSONEq(`{"received":3}`, string(sent[0].Payload))
+	assert.Equal(FileComplete, sent[1].Type)
+	assert.Equal("1", sent[1].ID)
+
+	bs, err := os.ReadFile(filepath.Join(dir, "slot1.sav"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("abcdef", string(bs))
+
+	// an upload of the same name is stored next to the first
+	r.HandleMessage(webrtc.DataChannelMessage{
+		IsString: true,
+		Data:     []byte(`{"id":"1","type":"file.start","payload":{"name":"slot1.sav","size":3}}`),
+	})
+	again := &Chunk{Transfer: 1, Seq: 0, Final: true, Data: []byte("ghi")}
+	r.HandleMessage(webrtc.DataChannelMessage{Data: again.Marshal()})
+
+	if assert.Len(sent, 3) {
+		assert.Equal(FileComplete, sent[2].Type)
+		assert.JSONEq(`{"name":"slot1 (1).sav","size":3}`, string(sent[2].Payload))
+	}
+
+	bs, _ = os.ReadFile(filepath.Join(dir, "slot1.sav"))
+	assert.Equal("abcdef", string(bs))
+	bs, _ = os.ReadFile(filepath.Join(dir, "slot1 (1).sav"))
+	assert.Equal("ghi", string(bs))
+
+	// exceeding the announced size aborts the upload
+	r.HandleMessage(webrtc.DataChannelMessage{
+		IsString: true,
+		Data:     []byte(`{"id":"2","type":"file.start","payload":{"name":"slot2.sav","size":2}}`),
+	})
+	oversized := &Chunk{Transfer: 2, Seq: 0, Final: true, Data: []byte("abc")}
+	r.HandleMessage(webrtc.DataChannelMessage{Data: oversized.Marshal()})
+
+	assert.Equal(ControlError, sent[3].Type)
+	assert.Equal("2", sent[3].ID)
+
+	files, _ := os.ReadDir(dir)
+	assert.Len(files, 2)
+
+	// an upload naming a target lands in its directory
+	mods := t.TempDir()
+	r.cfg.Targets = map[string]*FileTarget{
+		"mods": {MaxSizeMB: 1, dir: mods},
+	}
+
+	r.HandleMessage(webrtc.DataChannelMessage{
+		IsString: true,
+		Data:     []byte(`{"id":"3","type":"file.start","payload":{"name":"ui.pak","size":3,"target":"mods"}}`),
+	})
+	mod := &Chunk{Transfer: 3, Seq: 0, Final: true, Data: []byte("abc")}
+	r.HandleMessage(webrtc.DataChannelMessage{Data: mod.Marshal()})
+
+	assert.Equal(FileComplete, sent[len(sent)-1].Type)
+	assert.FileExists(filepath.Join(mods, "ui.pak"))
+}
+
+func TestLinkFree(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	tmp := filepath.Join(dir, ".upload")
+	if err := os.WriteFile(tmp, []byte("new"), 0o644); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	taken := []string{"save.dat"}
+	for n := 1; n < maxFileCopies; n++ {
+		taken = append(taken, "save ("+strconv.Itoa(n)+").dat")
+	}
+
+	for _, name := range taken {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("old"), 0o644); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+	}
+
+	// the last copy is taken too
+	name, err := linkFree(tmp, dir, "save.dat")
+	if assert.NoError(err) {
+		assert.Equal("save ("+strconv.Itoa(maxFileCopies)+").dat", name)
+	}
+
+	_, err = linkFree(tmp, dir, "save.dat")
+	assert.ErrorContains(err, "too many copies")
+
+	// and none of the files taken is replaced
+	bs, _ := os.ReadFile(filepath.Join(dir, "save.dat"))
+	assert.Equal("old", string(bs))
+}
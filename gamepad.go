@@ -1,5 +1,12 @@
 package game
 
+import (
+	"errors"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
 type Gamepad interface {
 	Connect() error
 	Update(report GamepadReport) error
@@ -71,3 +78,123 @@ func (report *xboxGamepadReport) RightThumbStick() ThumbStick {
 		Y: report.rightThumbStickY,
 	}
 }
+
+type GamepadBackend string
+
+const (
+	GamepadBackendViGEm GamepadBackend = "vigem"
+	GamepadBackendVXbox GamepadBackend = "vxbox" // ScpVBus, where ViGEmBus cannot be installed
+	GamepadBackendNone  GamepadBackend = "none"  // accepts reports without a driver
+)
+
+type GamepadType string
+
+const (
+	GamepadTypeXbox360 GamepadType = "xbox360"
+	GamepadTypeDS4     GamepadType = "ds4" // for games that need a PlayStation controller
+)
+
+// GamepadConfig selects how virtual gamepads are emulated. With Fallback,
+// the default, the service falls back to the none backend when the
+// configured one is unavailable, e.g. ViGEmBus is not installed. Disabled
+// turns gamepad input off for all peers, for view-only deployments.
+type GamepadConfig struct {
+	Disabled    bool
+	Backend     GamepadBackend
+	Type        GamepadType
+	Fallback    bool
+	Controllers int // controller slots, the stream's shared one included
+	Deadzone    *Deadzone
+}
+
+func (cfg *GamepadConfig) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Disabled    bool      `yaml:"disabled"`
+		Backend     string    `yaml:"backend"`
+		Type        string    `yaml:"type"`
+		Fallback    *bool     `yaml:"fallback"`
+		Controllers int       `yaml:"controllers"`
+		Deadzone    *Deadzone `yaml:"deadzone"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	switch GamepadBackend(raw.Backend) {
+	case "", GamepadBackendViGEm:
+		cfg.Backend = GamepadBackendViGEm
+	case GamepadBackendVXbox:
+		cfg.Backend = GamepadBackendVXbox
+	case GamepadBackendNone:
+		cfg.Backend = GamepadBackendNone
+	default:
+		return errors.New("invalid gamepad backend: " + raw.Backend)
+	}
+
+	switch GamepadType(raw.Type) {
+	case "", GamepadTypeXbox360:
+		cfg.Type = GamepadTypeXbox360
+	case GamepadTypeDS4:
+		cfg.Type = GamepadTypeDS4
+	default:
+		return errors.New("invalid gamepad type: " + raw.Type)
+	}
+
+	// ScpVBus only emulates Xbox 360 controllers.
+	if cfg.Type == GamepadTypeDS4 && cfg.Backend == GamepadBackendVXbox {
+		return errors.New("ds4 gamepads need the vigem backend")
+	}
+
+	if raw.Controllers == 0 {
+		raw.Controllers = MaxGamepadSlots
+	}
+
+	if raw.Controllers < 1 || raw.Controllers > MaxGamepadSlots {
+		return errors.New("gamepad controllers must be from 1 to " + strconv.Itoa(MaxGamepadSlots))
+	}
+
+	cfg.Disabled = raw.Disabled
+	cfg.Fallback = raw.Fallback == nil || *raw.Fallback
+	cfg.Controllers = raw.Controllers
+	cfg.Deadzone = raw.Deadzone
+
+	return nil
+}
+
+var defaultGamepad = &GamepadConfig{
+	Backend:     GamepadBackendViGEm,
+	Type:        GamepadTypeXbox360,
+	Fallback:    true,
+	Controllers: MaxGamepadSlots,
+}
+
+// gamepadFactory returns the constructor for the configured backend.
+func gamepadFactory(cfg *GamepadConfig) func() (Gamepad, error) {
+	switch {
+	case cfg.Backend == GamepadBackendNone:
+		return NewNullGamepad
+	case cfg.Backend == GamepadBackendVXbox:
+		return NewVXboxGamepad
+	case cfg.Type == GamepadTypeDS4:
+		return NewDS4Gamepad
+	default:
+		return newGamepad
+	}
+}
+
+// connectGamepad creates and connects a gamepad, closing it if connecting
+// fails half way.
+func connectGamepad(create func() (Gamepad, error)) (Gamepad, error) {
+	gamepad, err := create()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gamepad.Connect(); err != nil {
+		gamepad.Close()
+		return nil, err
+	}
+
+	return gamepad, nil
+}
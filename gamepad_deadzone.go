@@ -0,0 +1,106 @@
+package game
+
+import (
+	"errors"
+	"math"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Deadzone configures the ranges of the sticks and triggers treated as
+// rest, for worn controllers that drift. Sticks use a radial deadzone;
+// outside of it, and past a trigger's, the remaining range is rescaled to
+// the full one so no precision is lost at the edges.
+type Deadzone struct {
+	LeftStick  int16 // 0 to 32767
+	RightStick int16 // 0 to 32767
+	Trigger    uint8 // 0 to 254
+}
+
+func (dz *Deadzone) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		LeftStick  int `yaml:"leftStick"`
+		RightStick int `yaml:"rightStick"`
+		Trigger    int `yaml:"trigger"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.LeftStick < 0 || raw.LeftStick > math.MaxInt16 ||
+		raw.RightStick < 0 || raw.RightStick > math.MaxInt16 {
+		return errors.New("stick deadzone out of range")
+	}
+
+	if raw.Trigger < 0 || raw.Trigger >= math.MaxUint8 {
+		return errors.New("trigger deadzone out of range")
+	}
+
+	dz.LeftStick = int16(raw.LeftStick)
+	dz.RightStick = int16(raw.RightStick)
+	dz.Trigger = uint8(raw.Trigger)
+
+	return nil
+}
+
+// Apply returns the report with the deadzones applied. Reports are
+// returned as is when no deadzone is set.
+func (dz *Deadzone) Apply(report GamepadReport) GamepadReport {
+	if dz == nil || *dz == (Deadzone{}) {
+		return report
+	}
+
+	left := stickDeadzone(report.LeftThumbStick(), dz.LeftStick)
+	right := stickDeadzone(report.RightThumbStick(), dz.RightStick)
+
+	base := xboxGamepadReport{
+		buttons:          report.Buttons(),
+		leftTrigger:      triggerDeadzone(report.LeftTrigger(), dz.Trigger),
+		rightTrigger:     triggerDeadzone(report.RightTrigger(), dz.Trigger),
+		leftThumbStickX:  left.X,
+		leftThumbStickY:  left.Y,
+		rightThumbStickX: right.X,
+		rightThumbStickY: right.Y,
+	}
+
+	if ext, ok := report.(*ds4GamepadReport); ok {
+		ds4 := *ext
+		ds4.xboxGamepadReport = base
+		return &ds4
+	}
+
+	return &base
+}
+
+func stickDeadzone(stick ThumbStick, dz int16) ThumbStick {
+	if dz == 0 {
+		return stick
+	}
+
+	x, y := float64(stick.X), float64(stick.Y)
+
+	magnitude := math.Hypot(x, y)
+	if magnitude <= float64(dz) {
+		return ThumbStick{}
+	}
+
+	scale := (magnitude - float64(dz)) / (math.MaxInt16 - float64(dz)) * math.MaxInt16 / magnitude
+
+	return ThumbStick{
+		X: clampAxis(x * scale),
+		Y: clampAxis(y * scale),
+	}
+}
+
+func clampAxis(v float64) int16 {
+	return int16(max(min(math.Round(v), math.MaxInt16), math.MinInt16))
+}
+
+func triggerDeadzone(v uint8, dz uint8) uint8 {
+	if v <= dz {
+		return 0
+	}
+
+	return uint8((int(v) - int(dz)) * math.MaxUint8 / (math.MaxUint8 - int(dz)))
+}
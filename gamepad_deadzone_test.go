@@ -0,0 +1,46 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadzone(t *testing.T) {
+	assert := assert.New(t)
+
+	dz := &Deadzone{LeftStick: 8000, Trigger: 55}
+
+	// Drift inside the deadzones rests.
+	r := dz.Apply(NewXBoxGamepadReport(xinputA, 40, 0, 5000, -5000, 100, 0))
+	assert.Equal(uint16(xinputA), r.Buttons())
+	assert.Equal(uint8(0), r.LeftTrigger())
+	assert.Equal(ThumbStick{}, r.LeftThumbStick())
+	assert.Equal(ThumbStick{X: 100}, r.RightThumbStick())
+
+	// The remaining range is rescaled to the full one.
+	r = dz.Apply(NewXBoxGamepadReport(0, 255, 155, 32767, -32768, 0, 0))
+	assert.Equal(uint8(255), r.LeftTrigger())
+	assert.Equal(uint8(127), r.RightTrigger())
+	assert.Equal(int16(32767), r.LeftThumbStick().X)
+	assert.Equal(int16(-32768), r.LeftThumbStick().Y)
+
+	r = dz.Apply(NewXBoxGamepadReport(0, 0, 0, 0, 20384, 0, 0))
+	assert.InDelta(16384, r.LeftThumbStick().Y, 1)
+
+	// The DualShock 4 extension is kept.
+	ds4 := &ds4GamepadReport{
+		xboxGamepadReport: xboxGamepadReport{leftThumbStickX: 1000},
+		special:           DS4SpecialPS,
+	}
+
+	r = dz.Apply(ds4)
+	if ext, ok := r.(DS4GamepadReport); assert.True(ok) {
+		assert.Equal(uint8(DS4SpecialPS), ext.Special())
+		assert.Equal(ThumbStick{}, ext.LeftThumbStick())
+	}
+
+	// Without deadzones, reports pass through.
+	var none *Deadzone
+	assert.Same(ds4, none.Apply(ds4))
+}
@@ -0,0 +1,147 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrGamepadDriverNotFound = errors.New("gamepad driver not installed")
+	ErrGamepadDriverOutdated = errors.New("gamepad driver version too old")
+	ErrGamepadDriverAccess   = errors.New("gamepad driver access denied")
+	ErrGamepadNoFreeSlot     = errors.New("no free gamepad slot")
+)
+
+// MinViGEmBusVersion is the oldest ViGEmBus release supported; older
+// releases cannot report when a controller is ready.
+const MinViGEmBusVersion = "1.17"
+
+// VigemError is a VIGEM_ERROR code returned by the ViGEm client.
+type VigemError uint32
+
+const (
+	VigemErrorBusNotFound           VigemError = 0xE0000001
+	VigemErrorNoFreeSlot            VigemError = 0xE0000002
+	VigemErrorInvalidTarget         VigemError = 0xE0000003
+	VigemErrorRemovalFailed         VigemError = 0xE0000004
+	VigemErrorAlreadyConnected      VigemError = 0xE0000005
+	VigemErrorTargetUninitialized   VigemError = 0xE0000006
+	VigemErrorTargetNotPluggedIn    VigemError = 0xE0000007
+	VigemErrorBusVersionMismatch    VigemError = 0xE0000008
+	VigemErrorBusAccessFailed       VigemError = 0xE0000009
+	VigemErrorCallbackAlreadyExists VigemError = 0xE0000010
+	VigemErrorCallbackNotFound      VigemError = 0xE0000011
+	VigemErrorBusAlreadyConnected   VigemError = 0xE0000012
+	VigemErrorBusInvalidHandle      VigemError = 0xE0000013
+	VigemErrorUserIndexOutOfRange   VigemError = 0xE0000014
+	VigemErrorInvalidParameter      VigemError = 0xE0000015
+	VigemErrorNotSupported          VigemError = 0xE0000016
+	VigemErrorWinAPI                VigemError = 0xE0000017
+	VigemErrorTimedOut              VigemError = 0xE0000018
+	VigemErrorIsDisposing           VigemError = 0xE0000019
+)
+
+var vigemErrorMessages = map[VigemError]string{
+	VigemErrorBusNotFound:           "bus not found",
+	VigemErrorNoFreeSlot:            "no free slot",
+	VigemErrorInvalidTarget:         "invalid target",
+	VigemErrorRemovalFailed:         "removal failed",
+	VigemErrorAlreadyConnected:      "already connected",
+	VigemErrorTargetUninitialized:   "target uninitialized",
+	VigemErrorTargetNotPluggedIn:    "target not plugged in",
+	VigemErrorBusVersionMismatch:    "bus version mismatch",
+	VigemErrorBusAccessFailed:       "bus access failed",
+	VigemErrorCallbackAlreadyExists: "callback already registered",
+	VigemErrorCallbackNotFound:      "callback not found",
+	VigemErrorBusAlreadyConnected:   "bus already connected",
+	VigemErrorBusInvalidHandle:      "bus invalid handle",
+	VigemErrorUserIndexOutOfRange:   "user index out of range",
+	VigemErrorInvalidParameter:      "invalid parameter",
+	VigemErrorNotSupported:          "not supported",
+	VigemErrorWinAPI:                "windows api error",
+	VigemErrorTimedOut:              "timed out",
+	VigemErrorIsDisposing:           "is disposing",
+}
+
+func (e VigemError) Error() string {
+	msg, ok := vigemErrorMessages[e]
+	if !ok {
+		msg = "unknown error"
+	}
+
+	return fmt.Sprintf("vigem: %s (%#x)", msg, uint32(e))
+}
+
+// Unwrap maps the codes an operator can act on to the typed gamepad
+// errors, so callers can match them with errors.Is.
+func (e VigemError) Unwrap() error {
+	switch e {
+	case VigemErrorBusNotFound:
+		return ErrGamepadDriverNotFound
+	case VigemErrorBusVersionMismatch:
+		return ErrGamepadDriverOutdated
+	case VigemErrorBusAccessFailed:
+		return ErrGamepadDriverAccess
+	case VigemErrorNoFreeSlot:
+		return ErrGamepadNoFreeSlot
+	default:
+		return nil
+	}
+}
+
+// GamepadDriver describes the virtual gamepad driver installed on the host.
+type GamepadDriver struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// GamepadStatus reports whether a virtual gamepad can be plugged in.
+type GamepadStatus struct {
+	Driver *GamepadDriver `json:"driver,omitempty"`
+	OK     bool           `json:"ok"`
+	Error  string         `json:"error,omitempty"`
+	Hint   string         `json:"hint,omitempty"`
+}
+
+// CheckGamepad looks up the driver and plugs a gamepad in and out.
+func CheckGamepad() *GamepadStatus {
+	status := new(GamepadStatus)
+
+	if driver, err := GamepadDriverInfo(); err == nil {
+		status.Driver = driver
+	}
+
+	err := func() error {
+		gamepad, err := NewGamepad()
+		if err != nil {
+			return err
+		}
+		defer gamepad.Close()
+
+		return gamepad.Connect()
+	}()
+
+	if err != nil {
+		status.Error = err.Error()
+		status.Hint = gamepadHint(err)
+		return status
+	}
+
+	status.OK = true
+	return status
+}
+
+func gamepadHint(err error) string {
+	switch {
+	case errors.Is(err, ErrGamepadDriverNotFound):
+		return "install ViGEmBus"
+	case errors.Is(err, ErrGamepadDriverOutdated):
+		return "update ViGEmBus to " + MinViGEmBusVersion + " or later"
+	case errors.Is(err, ErrGamepadDriverAccess):
+		return "check that no other application holds ViGEmBus exclusively"
+	case errors.Is(err, ErrGamepadNoFreeSlot):
+		return "unplug unused virtual controllers"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,29 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVigemError(t *testing.T) {
+	assert := assert.New(t)
+
+	err := fmt.Errorf("connect to ViGEmBus: %w", VigemError(0xE0000001))
+	assert.ErrorIs(err, ErrGamepadDriverNotFound)
+	assert.Equal("connect to ViGEmBus: vigem: bus not found (0xe0000001)", err.Error())
+
+	var code VigemError
+	assert.True(errors.As(err, &code))
+	assert.Equal(VigemErrorBusNotFound, code)
+
+	assert.ErrorIs(VigemErrorBusVersionMismatch, ErrGamepadDriverOutdated)
+	assert.ErrorIs(VigemErrorNoFreeSlot, ErrGamepadNoFreeSlot)
+	assert.NoError(VigemErrorTimedOut.Unwrap())
+	assert.Equal("vigem: unknown error (0xe00000ff)", VigemError(0xE00000FF).Error())
+
+	assert.Equal("install ViGEmBus", gamepadHint(err))
+	assert.Equal("update ViGEmBus to 1.17 or later", gamepadHint(VigemErrorBusVersionMismatch))
+}
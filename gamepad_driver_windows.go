@@ -0,0 +1,78 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	versionDLL                  = syscall.NewLazyDLL("version.dll")
+	procGetFileVersionInfoSizeW = versionDLL.NewProc("GetFileVersionInfoSizeW")
+	procGetFileVersionInfoW     = versionDLL.NewProc("GetFileVersionInfoW")
+	procVerQueryValueW          = versionDLL.NewProc("VerQueryValueW")
+)
+
+// vsFixedFileInfo mirrors the head of VS_FIXEDFILEINFO.
+type vsFixedFileInfo struct {
+	Signature     uint32
+	StrucVersion  uint32
+	FileVersionMS uint32
+	FileVersionLS uint32
+}
+
+// GamepadDriverInfo returns the installed ViGEmBus driver and its file
+// version.
+func GamepadDriverInfo() (*GamepadDriver, error) {
+	path := filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "ViGEmBus.sys")
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrGamepadDriverNotFound
+		}
+
+		return nil, err
+	}
+
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	size, _, err := procGetFileVersionInfoSizeW.Call(uintptr(unsafe.Pointer(name)), 0)
+	if size == 0 {
+		return nil, fmt.Errorf("get driver version: %w", err)
+	}
+
+	info := make([]byte, size)
+	ret, _, err := procGetFileVersionInfoW.Call(uintptr(unsafe.Pointer(name)), 0, size, uintptr(unsafe.Pointer(&info[0])))
+	if ret == 0 {
+		return nil, fmt.Errorf("get driver version: %w", err)
+	}
+
+	root, err := syscall.UTF16PtrFromString(`\`)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixed *vsFixedFileInfo
+	var length uint32
+	ret, _, _ = procVerQueryValueW.Call(
+		uintptr(unsafe.Pointer(&info[0])),
+		uintptr(unsafe.Pointer(root)),
+		uintptr(unsafe.Pointer(&fixed)),
+		uintptr(unsafe.Pointer(&length)),
+	)
+	if ret == 0 || fixed == nil {
+		return nil, errors.New("get driver version: no version resource")
+	}
+
+	return &GamepadDriver{
+		Name: "ViGEmBus",
+		Version: fmt.Sprintf("%d.%d.%d.%d",
+			fixed.FileVersionMS>>16, fixed.FileVersionMS&0xffff,
+			fixed.FileVersionLS>>16, fixed.FileVersionLS&0xffff),
+	}, nil
+}
@@ -0,0 +1,318 @@
+package game
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// DS4ReportLength is the length of a gamepad report carrying the DualShock
+// 4 extension. It starts with the 12 bytes of an Xbox report, followed by
+//
+//	12     special buttons: 0x01 PS, 0x02 touchpad click
+//	13-18  gyro X, Y, Z (big-endian int16)
+//	19-24  accelerometer X, Y, Z (big-endian int16)
+//	25     number of touches, 0 to 2
+//	26-35  two touches: tracking ID (1 byte), X and Y (big-endian uint16)
+//
+// Buttons keep the XInput layout and are mapped to their PlayStation
+// counterparts. Clients may send the extension to Xbox controllers, which
+// ignore it.
+const DS4ReportLength = 36
+
+const (
+	DS4SpecialPS       = 0x01
+	DS4SpecialTouchpad = 0x02
+)
+
+// The DualShock 4 touchpad resolution; touches are clamped to it.
+const (
+	DS4TouchpadWidth  = 1920
+	DS4TouchpadHeight = 943
+)
+
+type Vector struct {
+	X int16
+	Y int16
+	Z int16
+}
+
+// Touch is a finger on the touchpad. Each new finger down gets a new
+// tracking ID.
+type Touch struct {
+	ID uint8
+	X  uint16
+	Y  uint16
+}
+
+// DS4GamepadReport is a report with the DualShock 4 extension.
+type DS4GamepadReport interface {
+	GamepadReport
+	Special() uint8
+	Gyro() Vector
+	Accel() Vector
+	Touches() []Touch
+}
+
+type ds4GamepadReport struct {
+	xboxGamepadReport
+	special uint8
+	gyro    Vector
+	accel   Vector
+	touches []Touch
+}
+
+func (report *ds4GamepadReport) Special() uint8 {
+	return report.special
+}
+
+func (report *ds4GamepadReport) Gyro() Vector {
+	return report.gyro
+}
+
+func (report *ds4GamepadReport) Accel() Vector {
+	return report.accel
+}
+
+func (report *ds4GamepadReport) Touches() []Touch {
+	return report.touches
+}
+
+// withDS4Extension adds the extension in data, which must be at least
+// DS4ReportLength long, to the report.
+func withDS4Extension(report GamepadReport, data []byte) (GamepadReport, error) {
+	base, ok := report.(*xboxGamepadReport)
+	if !ok {
+		return nil, ErrMalformedMessage
+	}
+
+	n := int(data[25])
+	if n > 2 {
+		return nil, ErrMalformedMessage
+	}
+
+	ds4 := &ds4GamepadReport{
+		xboxGamepadReport: *base,
+		special:           data[12],
+		gyro:              readVector(data[13:19]),
+		accel:             readVector(data[19:25]),
+	}
+
+	for i := range n {
+		touch := data[26+5*i : 31+5*i]
+		ds4.touches = append(ds4.touches, Touch{
+			ID: touch[0] & 0x7F,
+			X:  min(binary.BigEndian.Uint16(touch[1:3]), DS4TouchpadWidth-1),
+			Y:  min(binary.BigEndian.Uint16(touch[3:5]), DS4TouchpadHeight-1),
+		})
+	}
+
+	return ds4, nil
+}
+
+func readVector(b []byte) Vector {
+	return Vector{
+		X: int16(binary.BigEndian.Uint16(b[0:2])),
+		Y: int16(binary.BigEndian.Uint16(b[2:4])),
+		Z: int16(binary.BigEndian.Uint16(b[4:6])),
+	}
+}
+
+// XInput button flags, the layout of reports on the data channel.
+const (
+	xinputDPadUp        = 0x0001
+	xinputDPadDown      = 0x0002
+	xinputDPadLeft      = 0x0004
+	xinputDPadRight     = 0x0008
+	xinputStart         = 0x0010
+	xinputBack          = 0x0020
+	xinputLeftThumb     = 0x0040
+	xinputRightThumb    = 0x0080
+	xinputLeftShoulder  = 0x0100
+	xinputRightShoulder = 0x0200
+	xinputGuide         = 0x0400
+	xinputA             = 0x1000
+	xinputB             = 0x2000
+	xinputX             = 0x4000
+	xinputY             = 0x8000
+)
+
+// ds4Buttons maps XInput buttons to DS4_BUTTONS.
+var ds4Buttons = []struct {
+	xinput uint16
+	ds4    uint16
+}{
+	{xinputRightThumb, 1 << 15},
+	{xinputLeftThumb, 1 << 14},
+	{xinputStart, 1 << 13}, // options
+	{xinputBack, 1 << 12},  // share
+	{xinputRightShoulder, 1 << 9},
+	{xinputLeftShoulder, 1 << 8},
+	{xinputY, 1 << 7}, // triangle
+	{xinputB, 1 << 6}, // circle
+	{xinputA, 1 << 5}, // cross
+	{xinputX, 1 << 4}, // square
+}
+
+const (
+	ds4TriggerLeft  = 1 << 10
+	ds4TriggerRight = 1 << 11
+	ds4DPadNone     = 0x8
+)
+
+// ds4State is the DS4_REPORT_EX a report translates to.
+type ds4State struct {
+	ThumbLX, ThumbLY uint8
+	ThumbRX, ThumbRY uint8
+	Buttons          uint16
+	Special          uint8
+	TriggerL         uint8
+	TriggerR         uint8
+	Timestamp        uint16
+	Gyro             Vector
+	Accel            Vector
+	TouchPackets     uint8
+	Touch            [9]byte
+}
+
+// ds4ReportSize is the size of DS4_REPORT_EX.
+const ds4ReportSize = 63
+
+// bytes lays the state out as the packed DS4_REPORT_EX.
+func (state ds4State) bytes() [ds4ReportSize]byte {
+	var b [ds4ReportSize]byte
+	b[0] = state.ThumbLX
+	b[1] = state.ThumbLY
+	b[2] = state.ThumbRX
+	b[3] = state.ThumbRY
+	binary.LittleEndian.PutUint16(b[4:], state.Buttons)
+	b[6] = state.Special
+	b[7] = state.TriggerL
+	b[8] = state.TriggerR
+	binary.LittleEndian.PutUint16(b[9:], state.Timestamp)
+
+	for i, v := range []int16{
+		state.Gyro.X, state.Gyro.Y, state.Gyro.Z,
+		state.Accel.X, state.Accel.Y, state.Accel.Z,
+	} {
+		binary.LittleEndian.PutUint16(b[12+2*i:], uint16(v))
+	}
+
+	b[32] = state.TouchPackets
+	copy(b[33:42], state.Touch[:])
+
+	return b
+}
+
+// ds4Translator keeps the state that spans reports: the timestamp and the
+// touch packet counter.
+type ds4Translator struct {
+	start   time.Time
+	packets uint8
+}
+
+func (t *ds4Translator) translate(r GamepadReport, now time.Time) ds4State {
+	if t.start.IsZero() {
+		t.start = now
+	}
+
+	left := r.LeftThumbStick()
+	right := r.RightThumbStick()
+
+	state := ds4State{
+		ThumbLX:  ds4Axis(left.X),
+		ThumbLY:  ds4Axis(invertAxis(left.Y)),
+		ThumbRX:  ds4Axis(right.X),
+		ThumbRY:  ds4Axis(invertAxis(right.Y)),
+		TriggerL: r.LeftTrigger(),
+		TriggerR: r.RightTrigger(),
+		Buttons:  ds4DPad(r.Buttons()),
+
+		// The DS4 counts time in units of 5.33µs.
+		Timestamp: uint16(now.Sub(t.start) * 3 / (16 * time.Microsecond)),
+	}
+
+	for _, b := range ds4Buttons {
+		if r.Buttons()&b.xinput != 0 {
+			state.Buttons |= b.ds4
+		}
+	}
+
+	if state.TriggerL > 0 {
+		state.Buttons |= ds4TriggerLeft
+	}
+
+	if state.TriggerR > 0 {
+		state.Buttons |= ds4TriggerRight
+	}
+
+	if r.Buttons()&xinputGuide != 0 {
+		state.Special |= DS4SpecialPS
+	}
+
+	ext, ok := r.(DS4GamepadReport)
+	if !ok {
+		return state
+	}
+
+	state.Special |= ext.Special() & (DS4SpecialPS | DS4SpecialTouchpad)
+	state.Gyro = ext.Gyro()
+	state.Accel = ext.Accel()
+
+	t.packets++
+	state.TouchPackets = 1
+	state.Touch[0] = t.packets
+
+	// Both fingers are up unless reported; bit 7 set means up.
+	state.Touch[1] = 0x80
+	state.Touch[5] = 0x80
+
+	for i, touch := range ext.Touches() {
+		b := state.Touch[1+4*i : 5+4*i]
+		b[0] = touch.ID & 0x7F
+		b[1] = byte(touch.X)
+		b[2] = byte(touch.X>>8)&0x0F | byte(touch.Y<<4)
+		b[3] = byte(touch.Y >> 4)
+	}
+
+	return state
+}
+
+// ds4Axis maps a signed XInput axis onto the unsigned DS4 axis, centered
+// at 0x80.
+func ds4Axis(v int16) uint8 {
+	return uint8((int32(v) + 32768) >> 8)
+}
+
+// invertAxis flips an axis: XInput Y points up, DS4 Y down.
+func invertAxis(v int16) int16 {
+	return int16(-1 - int32(v))
+}
+
+// ds4DPad returns the hat direction of the XInput D-pad buttons.
+func ds4DPad(buttons uint16) uint16 {
+	up := buttons&xinputDPadUp != 0
+	down := buttons&xinputDPadDown != 0
+	left := buttons&xinputDPadLeft != 0
+	right := buttons&xinputDPadRight != 0
+
+	switch {
+	case up && right:
+		return 0x1
+	case right && down:
+		return 0x3
+	case down && left:
+		return 0x5
+	case left && up:
+		return 0x7
+	case up:
+		return 0x0
+	case right:
+		return 0x2
+	case down:
+		return 0x4
+	case left:
+		return 0x6
+	default:
+		return ds4DPadNone
+	}
+}
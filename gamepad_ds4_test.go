@@ -0,0 +1,72 @@
+package game
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDS4Translate(t *testing.T) {
+	assert := assert.New(t)
+
+	var translator ds4Translator
+
+	now := time.Now()
+
+	report := NewXBoxGamepadReport(
+		xinputA|xinputStart|xinputDPadUp|xinputDPadRight|xinputGuide,
+		255, 0,
+		32767, 32767, -32768, 0,
+	)
+
+	state := translator.translate(report, now)
+	assert.Equal(uint16(0x1|1<<5|1<<13|ds4TriggerLeft), state.Buttons)
+	assert.Equal(uint8(DS4SpecialPS), state.Special)
+	assert.Equal(uint8(255), state.ThumbLX)
+	assert.Equal(uint8(0), state.ThumbLY)
+	assert.Equal(uint8(0), state.ThumbRX)
+	assert.Equal(uint8(0x7F), state.ThumbRY)
+	assert.Zero(state.TouchPackets)
+
+	state = translator.translate(NewXBoxGamepadReport(0, 0, 0, 0, 0, 0, 0), now.Add(16*time.Microsecond))
+	assert.Equal(uint16(ds4DPadNone), state.Buttons)
+	assert.Equal(uint16(3), state.Timestamp)
+}
+
+func TestDS4Extension(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make([]byte, DS4ReportLength)
+	data[12] = DS4SpecialTouchpad
+	binary.BigEndian.PutUint16(data[13:], uint16(100))
+	binary.BigEndian.PutUint16(data[23:], 0xFFFF) // accel Z -1
+	data[25] = 1
+	data[26] = 5
+	binary.BigEndian.PutUint16(data[27:], 0x123)
+	binary.BigEndian.PutUint16(data[29:], 2000) // clamped
+
+	report, err := withDS4Extension(NewXBoxGamepadReport(0, 0, 0, 0, 0, 0, 0), data)
+	if !assert.NoError(err) {
+		return
+	}
+
+	ds4 := report.(DS4GamepadReport)
+	assert.Equal(Vector{X: 100}, ds4.Gyro())
+	assert.Equal(Vector{Z: -1}, ds4.Accel())
+	assert.Equal([]Touch{{ID: 5, X: 0x123, Y: DS4TouchpadHeight - 1}}, ds4.Touches())
+
+	var translator ds4Translator
+	b := translator.translate(report, time.Now()).bytes()
+
+	assert.Equal(uint8(DS4SpecialTouchpad), b[6])
+	assert.Equal(uint16(100), binary.LittleEndian.Uint16(b[12:]))
+	assert.Equal(uint16(0xFFFF), binary.LittleEndian.Uint16(b[22:]))
+	assert.Equal(uint8(1), b[32])
+	assert.Equal([]byte{1, 5, 0x23, 0xE1, 0x3A, 0x80}, b[33:39])
+
+	data[25] = 3
+	_, err = withDS4Extension(NewXBoxGamepadReport(0, 0, 0, 0, 0, 0, 0), data)
+	assert.ErrorIs(err, ErrMalformedMessage)
+}
@@ -0,0 +1,92 @@
+//go:build windows && cgo && !purego
+
+package game
+
+/*
+#cgo CFLAGS: -Wno-pragma-pack
+#cgo CFLAGS: -IViGEm
+#cgo LDFLAGS: -LViGEm -lViGEmClient
+#include <stdlib.h>
+#include <Windows.h>
+#include <ViGEm/Client.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// NewDS4Gamepad emulates a DualShock 4 through ViGEm.
+func NewDS4Gamepad() (Gamepad, error) {
+	return &ds4Gamepad{}, nil
+}
+
+type ds4Gamepad struct {
+	client C.PVIGEM_CLIENT
+	target C.PVIGEM_TARGET
+	ds4Translator
+	sync.Mutex
+}
+
+func (gamepad *ds4Gamepad) Connect() error {
+	client := C.vigem_alloc()
+	if client == nil {
+		return errors.New("failed to allocate ViGEm client")
+	}
+	gamepad.client = client
+
+	if ret := C.vigem_connect(client); ret != C.VIGEM_ERROR_NONE {
+		return fmt.Errorf("connect to ViGEmBus: %w", VigemError(ret))
+	}
+
+	target := C.vigem_target_ds4_alloc()
+	if target == nil {
+		return errors.New("failed to allocate DualShock 4 target")
+	}
+	gamepad.target = target
+
+	if ret := C.vigem_target_add(client, target); ret != C.VIGEM_ERROR_NONE {
+		return fmt.Errorf("add virtual controller: %w", VigemError(ret))
+	}
+
+	if C.vigem_target_is_waitable_add_supported(target) == 0 {
+		return fmt.Errorf("ViGEmBus older than %s: %w", MinViGEmBusVersion, ErrGamepadDriverOutdated)
+	}
+
+	return nil
+}
+
+// Update sends the full report, so touchpad and motion reach the game.
+func (gamepad *ds4Gamepad) Update(r GamepadReport) error {
+	gamepad.Lock()
+	state := gamepad.translate(r, time.Now())
+	gamepad.Unlock()
+
+	var ex C.DS4_REPORT_EX
+	*(*[ds4ReportSize]byte)(unsafe.Pointer(&ex)) = state.bytes()
+
+	if ret := C.vigem_target_ds4_update_ex(gamepad.client, gamepad.target, ex); ret != C.VIGEM_ERROR_NONE {
+		return fmt.Errorf("update virtual controller: %w", VigemError(ret))
+	}
+
+	return nil
+}
+
+func (gamepad *ds4Gamepad) Close() {
+	client := gamepad.client
+	target := gamepad.target
+
+	// Connect may have failed half way
+	if target != nil {
+		C.vigem_target_remove(client, target)
+		C.vigem_target_free(target)
+	}
+
+	if client != nil {
+		C.vigem_disconnect(client)
+		C.vigem_free(client)
+	}
+}
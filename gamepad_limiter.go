@@ -0,0 +1,135 @@
+package game
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// reportLimiter caps the rate at which a peer submits gamepad reports, so a
+// flooding client cannot starve the virtual gamepad bus. Reports repeating
+// the current state are dropped. Reports arriving within the interval of
+// the last submission are coalesced: only the latest is kept and submitted
+// once the interval has passed, so the final state of a burst always
+// reaches the controller.
+type reportLimiter struct {
+	interval time.Duration
+	submit   func(report GamepadReport) error
+
+	last     GamepadReport
+	lastTime time.Time
+	pending  GamepadReport
+	timer    *time.Timer
+	closed   bool
+	sync.Mutex
+}
+
+// newReportLimiter allows up to rate reports per second; a rate of 0 or
+// less only drops redundant reports.
+func newReportLimiter(rate int, submit func(report GamepadReport) error) *reportLimiter {
+	l := &reportLimiter{submit: submit}
+	if rate > 0 {
+		l.interval = time.Second / time.Duration(rate)
+	}
+
+	return l
+}
+
+// Submit submits the report or defers it. It reports whether the report was
+// submitted right away, and returns ErrDiscardedMessage when a report was
+// dropped: the given one if redundant, or the pending one it replaces.
+func (l *reportLimiter) Submit(report GamepadReport, now time.Time) (bool, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.closed {
+		return false, ErrDiscardedMessage
+	}
+
+	current := l.last
+	if l.pending != nil {
+		current = l.pending
+	}
+
+	if current != nil && sameReport(current, report) {
+		return false, ErrDiscardedMessage
+	}
+
+	if elapsed := now.Sub(l.lastTime); elapsed < l.interval {
+		replaced := l.pending != nil
+		l.pending = report
+
+		if l.timer == nil {
+			l.timer = time.AfterFunc(l.interval-elapsed, l.flush)
+		}
+
+		if replaced {
+			return false, ErrDiscardedMessage
+		}
+
+		return false, nil
+	}
+
+	// A report differing from the pending one supersedes it.
+	l.pending = nil
+
+	return true, l.send(report, now)
+}
+
+func (l *reportLimiter) flush() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.timer = nil
+
+	if l.closed || l.pending == nil {
+		return
+	}
+
+	report := l.pending
+	l.pending = nil
+
+	l.send(report, time.Now())
+}
+
+func (l *reportLimiter) send(report GamepadReport, now time.Time) error {
+	l.last = report
+	l.lastTime = now
+
+	return l.submit(report)
+}
+
+// Close drops the pending report.
+func (l *reportLimiter) Close() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.closed = true
+	l.pending = nil
+
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+}
+
+func sameReport(a, b GamepadReport) bool {
+	same := a.Buttons() == b.Buttons() &&
+		a.LeftTrigger() == b.LeftTrigger() &&
+		a.RightTrigger() == b.RightTrigger() &&
+		a.LeftThumbStick() == b.LeftThumbStick() &&
+		a.RightThumbStick() == b.RightThumbStick()
+
+	extA, okA := a.(DS4GamepadReport)
+	extB, okB := b.(DS4GamepadReport)
+	if !same || !okA && !okB {
+		return same
+	}
+
+	// Motion and touches change while the buttons rest.
+	return okA && okB &&
+		extA.Special() == extB.Special() &&
+		extA.Gyro() == extB.Gyro() &&
+		extA.Accel() == extB.Accel() &&
+		slices.Equal(extA.Touches(), extB.Touches())
+}
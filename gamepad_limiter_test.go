@@ -0,0 +1,67 @@
+package game
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		submitted []GamepadReport
+		mu        sync.Mutex
+	)
+
+	l := newReportLimiter(100, func(report GamepadReport) error {
+		mu.Lock()
+		submitted = append(submitted, report)
+		mu.Unlock()
+		return nil
+	})
+	defer l.Close()
+
+	report := func(buttons uint16) GamepadReport {
+		return NewXBoxGamepadReport(buttons, 0, 0, 0, 0, 0, 0)
+	}
+
+	now := time.Now()
+
+	ok, err := l.Submit(report(1), now)
+	assert.True(ok)
+	assert.NoError(err)
+
+	// Repeating the state is redundant.
+	ok, err = l.Submit(report(1), now.Add(20*time.Millisecond))
+	assert.False(ok)
+	assert.ErrorIs(err, ErrDiscardedMessage)
+
+	// Within the interval, the latest report replaces the pending one.
+	ok, err = l.Submit(report(2), now.Add(time.Millisecond))
+	assert.False(ok)
+	assert.NoError(err)
+
+	ok, err = l.Submit(report(3), now.Add(2*time.Millisecond))
+	assert.False(ok)
+	assert.ErrorIs(err, ErrDiscardedMessage)
+
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(submitted) == 2
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(uint16(1), submitted[0].Buttons())
+	assert.Equal(uint16(3), submitted[1].Buttons())
+	mu.Unlock()
+
+	l.Close()
+
+	ok, err = l.Submit(report(4), time.Now().Add(time.Second))
+	assert.False(ok)
+	assert.ErrorIs(err, ErrDiscardedMessage)
+}
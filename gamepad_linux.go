@@ -2,6 +2,20 @@ package game
 
 import "errors"
 
+// NewGamepad forwards input through the NVStream connection, as on macOS,
+// so edge boxes need no virtual gamepad driver.
 func NewGamepad() (Gamepad, error) {
-	return nil, errors.New("gamepad not implemented")
+	return NewMoonlightGamepad()
+}
+
+func GamepadDriverInfo() (*GamepadDriver, error) {
+	return &GamepadDriver{Name: "moonlight"}, nil
+}
+
+func NewVXboxGamepad() (Gamepad, error) {
+	return nil, errors.New("vxbox gamepad not implemented")
+}
+
+func NewDS4Gamepad() (Gamepad, error) {
+	return nil, errors.New("ds4 gamepad not implemented")
 }
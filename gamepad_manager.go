@@ -0,0 +1,186 @@
+package game
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MaxGamepadSlots is the number of controllers XInput supports.
+const MaxGamepadSlots = 4
+
+var ErrSlotTaken = errors.New("controller slot taken")
+
+// GamepadManager hands out one virtual gamepad per controller slot. Slot 1
+// is the stream's shared controller, arbitrated by the peer group; slots 2
+// and up belong to a single peer each and their gamepads are plugged in on
+// acquire and unplugged on release, so the game sees players join and leave.
+// It also tracks the input latency of each controller against budget.
+type GamepadManager struct {
+	primary     Gamepad
+	create      func() (Gamepad, error)
+	slots       map[int]*gamepadSlot
+	controllers int
+	budget      time.Duration
+	latency     map[int]*latencyRecorder
+	sync.Mutex
+}
+
+type gamepadSlot struct {
+	owner   string
+	gamepad Gamepad
+}
+
+func NewGamepadManager(primary Gamepad, create func() (Gamepad, error), budget time.Duration) *GamepadManager {
+	return &GamepadManager{
+		primary:     primary,
+		create:      create,
+		slots:       make(map[int]*gamepadSlot),
+		controllers: MaxGamepadSlots,
+		budget:      budget,
+		latency:     make(map[int]*latencyRecorder),
+	}
+}
+
+// SetControllers limits the slots to n controllers, the primary one
+// included.
+func (m *GamepadManager) SetControllers(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.controllers = min(max(n, 1), MaxGamepadSlots)
+}
+
+func (m *GamepadManager) Primary() Gamepad {
+	return m.primary
+}
+
+// Acquire connects a gamepad for the slot and assigns it to owner.
+// Acquiring a slot the owner already holds returns the same gamepad.
+func (m *GamepadManager) Acquire(slot int, owner string) (Gamepad, error) {
+	if slot < 2 || slot > MaxGamepadSlots {
+		return nil, errors.New("invalid controller slot: " + strconv.Itoa(slot))
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if slot > m.controllers {
+		return nil, errors.New("controller slot not available: " + strconv.Itoa(slot))
+	}
+
+	if s, ok := m.slots[slot]; ok {
+		if s.owner != owner {
+			return nil, ErrSlotTaken
+		}
+
+		return s.gamepad, nil
+	}
+
+	gamepad, err := m.create()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gamepad.Connect(); err != nil {
+		gamepad.Close()
+		return nil, err
+	}
+
+	m.slots[slot] = &gamepadSlot{owner, gamepad}
+
+	// A new player starts with fresh statistics.
+	delete(m.latency, slot)
+
+	return gamepad, nil
+}
+
+// Release unplugs the slot's gamepad if owner still holds it.
+func (m *GamepadManager) Release(slot int, owner string) {
+	m.Lock()
+	defer m.Unlock()
+
+	s, ok := m.slots[slot]
+	if !ok || s.owner != owner {
+		return
+	}
+
+	s.gamepad.Close()
+	delete(m.slots, slot)
+}
+
+// Owner returns who holds the slot, if anyone.
+func (m *GamepadManager) Owner(slot int) (string, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	s, ok := m.slots[slot]
+	if !ok {
+		return "", false
+	}
+
+	return s.owner, true
+}
+
+// Controllers returns the number of gamepads plugged in, the primary one
+// included.
+func (m *GamepadManager) Controllers() int {
+	m.Lock()
+	defer m.Unlock()
+
+	return 1 + len(m.slots)
+}
+
+// Close unplugs the gamepads of all slots but the primary one.
+func (m *GamepadManager) Close() {
+	m.Lock()
+	defer m.Unlock()
+
+	for slot, s := range m.slots {
+		s.gamepad.Close()
+		delete(m.slots, slot)
+	}
+}
+
+// ObserveLatency records the time a report of the slot's controller took
+// from receipt to submission; slot 0 is the primary controller. It reports
+// whether the caller should warn about the budget being exceeded.
+func (m *GamepadManager) ObserveLatency(slot int, d time.Duration) bool {
+	if slot == 0 {
+		slot = 1
+	}
+
+	m.Lock()
+	r, ok := m.latency[slot]
+	if !ok {
+		r = newLatencyRecorder(m.budget)
+		m.latency[slot] = r
+	}
+	m.Unlock()
+
+	return r.Observe(d)
+}
+
+// LatencyStats returns the latency statistics of every controller that
+// received input, ordered by slot.
+func (m *GamepadManager) LatencyStats() []LatencyStats {
+	m.Lock()
+	recorders := make(map[int]*latencyRecorder, len(m.latency))
+	for slot, r := range m.latency {
+		recorders[slot] = r
+	}
+	m.Unlock()
+
+	stats := make([]LatencyStats, 0, len(recorders))
+	for slot, r := range recorders {
+		stats = append(stats, r.Stats(slot))
+	}
+
+	slices.SortFunc(stats, func(a, b LatencyStats) int {
+		return a.Controller - b.Controller
+	})
+
+	return stats
+}
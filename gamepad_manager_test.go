@@ -0,0 +1,87 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type slotGamepad struct {
+	connected bool
+	closed    bool
+}
+
+func (g *slotGamepad) Connect() error {
+	g.connected = true
+	return nil
+}
+
+func (g *slotGamepad) Update(report GamepadReport) error {
+	return nil
+}
+
+func (g *slotGamepad) Close() {
+	g.closed = true
+}
+
+func TestGamepadManager(t *testing.T) {
+	assert := assert.New(t)
+
+	var created []*slotGamepad
+	primary := &slotGamepad{}
+
+	m := NewGamepadManager(primary, func() (Gamepad, error) {
+		g := &slotGamepad{}
+		created = append(created, g)
+		return g, nil
+	}, 0)
+
+	assert.Equal(primary, m.Primary())
+
+	_, err := m.Acquire(1, "alice")
+	assert.Error(err)
+
+	_, err = m.Acquire(MaxGamepadSlots+1, "alice")
+	assert.Error(err)
+
+	g2, err := m.Acquire(2, "alice")
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.True(created[0].connected)
+
+	again, err := m.Acquire(2, "alice")
+	assert.NoError(err)
+	assert.Same(g2, again)
+	assert.Len(created, 1)
+
+	_, err = m.Acquire(2, "bob")
+	assert.ErrorIs(err, ErrSlotTaken)
+
+	owner, ok := m.Owner(2)
+	assert.True(ok)
+	assert.Equal("alice", owner)
+
+	// Only the owner releases the slot.
+	m.Release(2, "bob")
+	assert.False(created[0].closed)
+
+	m.Release(2, "alice")
+	assert.True(created[0].closed)
+
+	_, ok = m.Owner(2)
+	assert.False(ok)
+
+	_, err = m.Acquire(3, "bob")
+	assert.NoError(err)
+
+	m.Close()
+	assert.True(created[1].closed)
+
+	m.SetControllers(2)
+
+	_, err = m.Acquire(3, "bob")
+	assert.ErrorContains(err, "not available")
+	assert.False(primary.closed)
+}
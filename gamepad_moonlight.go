@@ -0,0 +1,148 @@
+package game
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flarexio/game/nvstream"
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// maxMoonlightGamepads is the number of controllers GameStream supports.
+const maxMoonlightGamepads = 16
+
+// moonlightButtons are the XInput buttons announced to the host.
+const moonlightButtons = 0xF7FF
+
+// moonlightGamepads tracks the controller numbers in use; the host is sent
+// the mask of all of them with each report.
+var moonlightGamepads struct {
+	mask uint16
+	sync.Mutex
+}
+
+// NewMoonlightGamepad returns a gamepad that forwards reports to the host
+// over the NVStream connection instead of emulating a local controller,
+// for platforms without a virtual gamepad driver. Reports fail while no
+// connection is up.
+func NewMoonlightGamepad() (Gamepad, error) {
+	return &moonlightGamepad{number: -1}, nil
+}
+
+type moonlightGamepad struct {
+	number int16
+
+	// announced is the connection generation the controller was last
+	// announced to.
+	announced  uint64
+	lastMotion map[MotionType]time.Time
+	sync.Mutex
+}
+
+// Connect takes the lowest free controller number.
+func (gamepad *moonlightGamepad) Connect() error {
+	moonlightGamepads.Lock()
+	defer moonlightGamepads.Unlock()
+
+	for i := range int16(maxMoonlightGamepads) {
+		if moonlightGamepads.mask&(1<<i) != 0 {
+			continue
+		}
+
+		moonlightGamepads.mask |= 1 << i
+		gamepad.number = i
+		return nil
+	}
+
+	return ErrGamepadNoFreeSlot
+}
+
+func (gamepad *moonlightGamepad) Update(r GamepadReport) error {
+	moonlightGamepads.Lock()
+	mask := moonlightGamepads.mask
+	moonlightGamepads.Unlock()
+
+	gamepad.announce(mask)
+
+	return gamepad.send(mask, r)
+}
+
+// announce tells the host of a new connection about the controller and its
+// motion sensors, so the host asks for motion events. It is retried with
+// the next report if the connection is not up yet.
+func (gamepad *moonlightGamepad) announce(mask uint16) {
+	gamepad.Lock()
+	defer gamepad.Unlock()
+
+	generation := nvstream.ConnectionGeneration()
+	if gamepad.announced == generation {
+		return
+	}
+
+	err := moonlight.SendControllerArrivalEvent(
+		byte(gamepad.number), mask,
+		moonlight.CONTROLLER_TYPE_XBOX, moonlightButtons,
+		moonlight.CAPABILITY_ANALOG_TRIGGERS|moonlight.CAPABILITY_RUMBLE|
+			moonlight.CAPABILITY_ACCEL|moonlight.CAPABILITY_GYRO,
+	)
+
+	if err == nil {
+		gamepad.announced = generation
+		gamepad.lastMotion = nil
+	}
+}
+
+// SendMotion forwards the reading if the host asked for the sensor's
+// events, dropping readings that arrive faster than the requested rate.
+func (gamepad *moonlightGamepad) SendMotion(event *MotionEvent) error {
+	rate := nvstream.MotionEventRate(uint16(gamepad.number), uint8(event.Type))
+	if rate == 0 {
+		return ErrDiscardedMessage
+	}
+
+	gamepad.Lock()
+	now := time.Now()
+	if now.Sub(gamepad.lastMotion[event.Type]) < time.Second/time.Duration(rate) {
+		gamepad.Unlock()
+		return ErrDiscardedMessage
+	}
+
+	if gamepad.lastMotion == nil {
+		gamepad.lastMotion = make(map[MotionType]time.Time)
+	}
+
+	gamepad.lastMotion[event.Type] = now
+	gamepad.Unlock()
+
+	return moonlight.SendControllerMotionEvent(
+		byte(gamepad.number), byte(event.Type),
+		event.X, event.Y, event.Z,
+	)
+}
+
+// Close frees the controller number and tells the host it was unplugged.
+func (gamepad *moonlightGamepad) Close() {
+	if gamepad.number < 0 {
+		return
+	}
+
+	moonlightGamepads.Lock()
+	moonlightGamepads.mask &^= 1 << gamepad.number
+	mask := moonlightGamepads.mask
+	moonlightGamepads.Unlock()
+
+	gamepad.send(mask, NewXBoxGamepadReport(0, 0, 0, 0, 0, 0, 0))
+	gamepad.number = -1
+}
+
+func (gamepad *moonlightGamepad) send(mask uint16, r GamepadReport) error {
+	left := r.LeftThumbStick()
+	right := r.RightThumbStick()
+
+	// Button flags share the XInput layout.
+	return moonlight.SendMultiControllerEvent(
+		gamepad.number, int16(mask), int(r.Buttons()),
+		r.LeftTrigger(), r.RightTrigger(),
+		left.X, left.Y, right.X, right.Y,
+	)
+}
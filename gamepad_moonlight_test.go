@@ -0,0 +1,60 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoonlightGamepadNumbers(t *testing.T) {
+	assert := assert.New(t)
+
+	// Start from no numbers in use, whatever other tests left connected.
+	moonlightGamepads.Lock()
+	mask := moonlightGamepads.mask
+	moonlightGamepads.mask = 0
+	moonlightGamepads.Unlock()
+
+	t.Cleanup(func() {
+		moonlightGamepads.Lock()
+		moonlightGamepads.mask = mask
+		moonlightGamepads.Unlock()
+	})
+
+	connect := func() *moonlightGamepad {
+		gamepad, _ := NewMoonlightGamepad()
+		if err := gamepad.Connect(); err != nil {
+			assert.Fail(err.Error())
+		}
+
+		return gamepad.(*moonlightGamepad)
+	}
+
+	first := connect()
+	second := connect()
+
+	assert.Equal(int16(0), first.number)
+	assert.Equal(int16(1), second.number)
+
+	first.Close()
+	assert.Equal(int16(-1), first.number)
+
+	// the freed number is taken again
+	third := connect()
+	assert.Equal(int16(0), third.number)
+
+	second.Close()
+	third.Close()
+
+	var gamepads []Gamepad
+	for range maxMoonlightGamepads {
+		gamepads = append(gamepads, connect())
+	}
+
+	gamepad, _ := NewMoonlightGamepad()
+	assert.ErrorIs(gamepad.Connect(), ErrGamepadNoFreeSlot)
+
+	for _, gamepad := range gamepads {
+		gamepad.Close()
+	}
+}
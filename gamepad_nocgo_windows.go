@@ -0,0 +1,19 @@
+//go:build windows && (purego || !cgo)
+
+package game
+
+import "errors"
+
+// NewGamepad accepts reports without a driver, as the none backend does:
+// ViGEm is only reached through cgo.
+func NewGamepad() (Gamepad, error) {
+	return NewNullGamepad()
+}
+
+func NewDS4Gamepad() (Gamepad, error) {
+	return nil, errors.New("ds4 gamepad requires cgo")
+}
+
+func NewGamepadProbe(gamepad Gamepad) (GamepadProbe, error) {
+	return nil, ErrGamepadProbeUnsupported
+}
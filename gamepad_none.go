@@ -0,0 +1,41 @@
+package game
+
+import (
+	"sync"
+)
+
+// NewNullGamepad returns a gamepad that accepts reports without a driver,
+// for CI and development machines without ViGEmBus. Reports go nowhere.
+func NewNullGamepad() (Gamepad, error) {
+	return &nullGamepad{}, nil
+}
+
+type nullGamepad struct {
+	last    GamepadReport
+	reports uint64
+	sync.Mutex
+}
+
+func (gamepad *nullGamepad) Connect() error {
+	return nil
+}
+
+func (gamepad *nullGamepad) Update(report GamepadReport) error {
+	gamepad.Lock()
+	defer gamepad.Unlock()
+
+	gamepad.last = report
+	gamepad.reports++
+
+	return nil
+}
+
+// Last returns the last report and how many were accepted.
+func (gamepad *nullGamepad) Last() (GamepadReport, uint64) {
+	gamepad.Lock()
+	defer gamepad.Unlock()
+
+	return gamepad.last, gamepad.reports
+}
+
+func (gamepad *nullGamepad) Close() {}
@@ -0,0 +1,56 @@
+package game
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGamepadFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	newGamepad = func() (Gamepad, error) { return nil, ErrGamepadDriverNotFound }
+	t.Cleanup(func() { newGamepad = NewGamepad })
+
+	newInput = func() (Input, error) { return new(recordingInput), nil }
+	t.Cleanup(func() { newInput = NewInput })
+
+	svc, err := NewService(&Config{}, nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer svc.Close()
+
+	health, err := svc.Health(context.Background())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(GamepadBackendNone, health.Gamepad.Backend)
+
+	cfg := &GamepadConfig{Backend: GamepadBackendViGEm}
+
+	_, err = NewService(&Config{Gamepad: cfg}, nil)
+	assert.ErrorIs(err, ErrGamepadDriverNotFound)
+}
+
+func TestNullGamepad(t *testing.T) {
+	assert := assert.New(t)
+
+	gamepad, err := connectGamepad(NewNullGamepad)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer gamepad.Close()
+
+	report := NewXBoxGamepadReport(xinputA, 0, 255, 0, 0, 0, 0)
+	assert.NoError(gamepad.Update(report))
+
+	last, n := gamepad.(*nullGamepad).Last()
+	assert.Equal(report, last)
+	assert.Equal(uint64(1), n)
+}
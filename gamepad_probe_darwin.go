@@ -0,0 +1,5 @@
+package game
+
+func NewGamepadProbe(gamepad Gamepad) (GamepadProbe, error) {
+	return nil, ErrGamepadProbeUnsupported
+}
@@ -0,0 +1,76 @@
+//go:build windows && cgo && !purego
+
+package game
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	xinput             = syscall.NewLazyDLL("xinput1_4.dll")
+	procXInputGetState = xinput.NewProc("XInputGetState")
+)
+
+// xinputState mirrors XINPUT_STATE.
+type xinputState struct {
+	PacketNumber uint32
+	Buttons      uint16
+	LeftTrigger  uint8
+	RightTrigger uint8
+	ThumbLX      int16
+	ThumbLY      int16
+	ThumbRX      int16
+	ThumbRY      int16
+}
+
+// NewGamepadProbe polls the gamepad's XInput user index.
+func NewGamepadProbe(gamepad Gamepad) (GamepadProbe, error) {
+	var index int
+	switch pad := gamepad.(type) {
+	case *xboxGamepad:
+		i, err := pad.UserIndex()
+		if err != nil {
+			return nil, err
+		}
+
+		index = i
+
+	case *vxboxGamepad:
+		index = int(pad.index) - 1
+
+	default:
+		return nil, ErrGamepadProbeUnsupported
+	}
+
+	if err := xinput.Load(); err != nil {
+		return nil, err
+	}
+
+	return &xinputProbe{uint32(index)}, nil
+}
+
+type xinputProbe struct {
+	index uint32
+}
+
+func (p *xinputProbe) State() (GamepadReport, error) {
+	var state xinputState
+	ret, _, _ := procXInputGetState.Call(uintptr(p.index), uintptr(unsafe.Pointer(&state)))
+	if ret != 0 {
+		return nil, errors.New("xinput: " + syscall.Errno(ret).Error())
+	}
+
+	return NewXBoxGamepadReport(
+		state.Buttons,
+		state.LeftTrigger,
+		state.RightTrigger,
+		state.ThumbLX,
+		state.ThumbLY,
+		state.ThumbRX,
+		state.ThumbRY,
+	), nil
+}
+
+func (p *xinputProbe) Close() {}
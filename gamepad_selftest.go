@@ -0,0 +1,138 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrGamepadProbeUnsupported = errors.New("gamepad probe unsupported")
+
+// GamepadProbe reads a virtual gamepad's state back through the OS input
+// API, the way a game would see it.
+type GamepadProbe interface {
+	State() (GamepadReport, error)
+	Close()
+}
+
+// GamepadTestStep is a report of the self-test pattern.
+type GamepadTestStep struct {
+	Name   string
+	Report GamepadReport
+}
+
+// GamepadTestResult is the outcome of a step. Received is the last state
+// read back, nil if the probe never returned one.
+type GamepadTestResult struct {
+	Step     GamepadTestStep
+	Received GamepadReport
+	Passed   bool
+}
+
+// gamepadTestButtons leaves out the guide button, which XInputGetState
+// does not report.
+var gamepadTestButtons = []struct {
+	name   string
+	button uint16
+}{
+	{"up", ButtonDPadUp},
+	{"down", ButtonDPadDown},
+	{"left", ButtonDPadLeft},
+	{"right", ButtonDPadRight},
+	{"start", ButtonStart},
+	{"back", ButtonBack},
+	{"ls", ButtonLeftThumb},
+	{"rs", ButtonRightThumb},
+	{"lb", ButtonLeftShoulder},
+	{"rb", ButtonRightShoulder},
+	{"a", ButtonA},
+	{"b", ButtonB},
+	{"x", ButtonX},
+	{"y", ButtonY},
+}
+
+// GamepadTestPattern presses each button alone, pulls each trigger and
+// moves each stick to its extremes, returning to neutral in between.
+func GamepadTestPattern() []GamepadTestStep {
+	neutral := NewXBoxGamepadReport(0, 0, 0, 0, 0, 0, 0)
+
+	steps := []GamepadTestStep{{"neutral", neutral}}
+	add := func(name string, report GamepadReport) {
+		steps = append(steps,
+			GamepadTestStep{name, report},
+			GamepadTestStep{"neutral", neutral},
+		)
+	}
+
+	for _, b := range gamepadTestButtons {
+		add(b.name, NewXBoxGamepadReport(b.button, 0, 0, 0, 0, 0, 0))
+	}
+
+	add("lt", NewXBoxGamepadReport(0, 255, 0, 0, 0, 0, 0))
+	add("rt", NewXBoxGamepadReport(0, 0, 255, 0, 0, 0, 0))
+
+	for _, v := range []int16{32767, -32768} {
+		add("lx", NewXBoxGamepadReport(0, 0, 0, v, 0, 0, 0))
+		add("ly", NewXBoxGamepadReport(0, 0, 0, 0, v, 0, 0))
+		add("rx", NewXBoxGamepadReport(0, 0, 0, 0, 0, v, 0))
+		add("ry", NewXBoxGamepadReport(0, 0, 0, 0, 0, 0, v))
+	}
+
+	return steps
+}
+
+// SelfTestGamepad sends each step to the gamepad and polls the probe until
+// the same state reads back or the step times out. A gamepad that passes
+// works locally, so remaining input problems lie in the network path.
+func SelfTestGamepad(ctx context.Context, gamepad Gamepad, probe GamepadProbe, steps []GamepadTestStep, timeout time.Duration) ([]GamepadTestResult, error) {
+	results := make([]GamepadTestResult, 0, len(steps))
+
+	for _, step := range steps {
+		if err := gamepad.Update(step.Report); err != nil {
+			return results, err
+		}
+
+		result := GamepadTestResult{Step: step}
+
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		result.Received, result.Passed = pollGamepadState(stepCtx, probe, step.Report)
+		cancel()
+
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func pollGamepadState(ctx context.Context, probe GamepadProbe, want GamepadReport) (GamepadReport, bool) {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+
+	var last GamepadReport
+	for {
+		if state, err := probe.State(); err == nil {
+			last = state
+			if sameGamepadState(state, want) {
+				return state, true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return last, false
+		case <-ticker.C:
+		}
+	}
+}
+
+func sameGamepadState(a, b GamepadReport) bool {
+	return a.Buttons() == b.Buttons() &&
+		a.LeftTrigger() == b.LeftTrigger() &&
+		a.RightTrigger() == b.RightTrigger() &&
+		a.LeftThumbStick() == b.LeftThumbStick() &&
+		a.RightThumbStick() == b.RightThumbStick()
+}
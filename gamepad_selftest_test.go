@@ -0,0 +1,73 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// loopbackGamepad reads back what it was sent, except for the stuck
+// buttons, which never arrive.
+type loopbackGamepad struct {
+	state GamepadReport
+	stuck uint16
+}
+
+func (g *loopbackGamepad) Connect() error {
+	return nil
+}
+
+func (g *loopbackGamepad) Update(report GamepadReport) error {
+	ls := report.LeftThumbStick()
+	rs := report.RightThumbStick()
+
+	g.state = NewXBoxGamepadReport(
+		report.Buttons()&^g.stuck,
+		report.LeftTrigger(), report.RightTrigger(),
+		ls.X, ls.Y, rs.X, rs.Y,
+	)
+
+	return nil
+}
+
+func (g *loopbackGamepad) Close() {}
+
+func (g *loopbackGamepad) State() (GamepadReport, error) {
+	return g.state, nil
+}
+
+func TestSelfTestGamepad(t *testing.T) {
+	assert := assert.New(t)
+
+	steps := GamepadTestPattern()
+
+	// neutral, then each button, trigger and stick extreme followed by neutral
+	assert.Len(steps, 1+2*(14+2+8))
+
+	gamepad := &loopbackGamepad{}
+
+	results, err := SelfTestGamepad(context.Background(), gamepad, gamepad, steps, 20*time.Millisecond)
+	assert.NoError(err)
+	assert.Len(results, len(steps))
+
+	for _, result := range results {
+		assert.True(result.Passed, result.Step.Name)
+	}
+
+	gamepad = &loopbackGamepad{stuck: ButtonA}
+
+	results, err = SelfTestGamepad(context.Background(), gamepad, gamepad, steps, 20*time.Millisecond)
+	assert.NoError(err)
+
+	var failed []string
+	for _, result := range results {
+		if !result.Passed {
+			failed = append(failed, result.Step.Name)
+			assert.Equal(uint16(0), result.Received.Buttons())
+		}
+	}
+
+	assert.Equal([]string{"a"}, failed)
+}
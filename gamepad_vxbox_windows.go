@@ -0,0 +1,157 @@
+package game
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// vXboxInterface drives ScpVBus, an older virtual bus that works where
+// ViGEmBus cannot be installed.
+var (
+	vxbox                 = syscall.NewLazyDLL("vXboxInterface.dll")
+	procIsVBusExists      = vxbox.NewProc("isVBusExists")
+	procPlugInNext        = vxbox.NewProc("PlugInNext")
+	procUnPlug            = vxbox.NewProc("UnPlug")
+	procSetDpad           = vxbox.NewProc("SetDpad")
+	procSetTriggerL       = vxbox.NewProc("SetTriggerL")
+	procSetTriggerR       = vxbox.NewProc("SetTriggerR")
+	procSetAxisX          = vxbox.NewProc("SetAxisX")
+	procSetAxisY          = vxbox.NewProc("SetAxisY")
+	procSetAxisRx         = vxbox.NewProc("SetAxisRx")
+	procSetAxisRy         = vxbox.NewProc("SetAxisRy")
+	vxboxButtonProcedures = []struct {
+		button uint16
+		proc   *syscall.LazyProc
+	}{
+		{ButtonStart, vxbox.NewProc("SetBtnStart")},
+		{ButtonBack, vxbox.NewProc("SetBtnBack")},
+		{ButtonLeftThumb, vxbox.NewProc("SetBtnLT")},
+		{ButtonRightThumb, vxbox.NewProc("SetBtnRT")},
+		{ButtonLeftShoulder, vxbox.NewProc("SetBtnLB")},
+		{ButtonRightShoulder, vxbox.NewProc("SetBtnRB")},
+		{ButtonA, vxbox.NewProc("SetBtnA")},
+		{ButtonB, vxbox.NewProc("SetBtnB")},
+		{ButtonX, vxbox.NewProc("SetBtnX")},
+		{ButtonY, vxbox.NewProc("SetBtnY")},
+	}
+)
+
+const vxboxDPadButtons = ButtonDPadUp | ButtonDPadDown | ButtonDPadLeft | ButtonDPadRight
+
+// NewVXboxGamepad emulates an Xbox 360 controller through ScpVBus. The bus
+// has no guide button, so it is dropped.
+func NewVXboxGamepad() (Gamepad, error) {
+	if err := vxbox.Load(); err != nil {
+		return nil, ErrGamepadDriverNotFound
+	}
+
+	return &vxboxGamepad{}, nil
+}
+
+type vxboxGamepad struct {
+	index uint32 // 1-based
+	last  *xboxGamepadReport
+}
+
+func (gamepad *vxboxGamepad) Connect() error {
+	if ret, _, _ := procIsVBusExists.Call(); ret == 0 {
+		return ErrGamepadDriverNotFound
+	}
+
+	var index uint32
+	if ret, _, _ := procPlugInNext.Call(uintptr(unsafe.Pointer(&index))); ret == 0 {
+		return ErrGamepadNoFreeSlot
+	}
+
+	gamepad.index = index
+	gamepad.last = new(xboxGamepadReport)
+
+	return nil
+}
+
+// Update sets what changed since the last report; the bus takes one call
+// per button or axis.
+func (gamepad *vxboxGamepad) Update(r GamepadReport) error {
+	last := gamepad.last
+	if last == nil {
+		return errors.New("virtual controller not connected")
+	}
+
+	index := uintptr(gamepad.index)
+
+	buttons := r.Buttons()
+	changed := buttons ^ last.buttons
+
+	if changed&vxboxDPadButtons != 0 {
+		// DPAD_UP, DOWN, LEFT and RIGHT share the XUSB bit values.
+		if err := vxboxCall(procSetDpad, index, uintptr(buttons&vxboxDPadButtons)); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range vxboxButtonProcedures {
+		if changed&b.button == 0 {
+			continue
+		}
+
+		var pressed uintptr
+		if buttons&b.button != 0 {
+			pressed = 1
+		}
+
+		if err := vxboxCall(b.proc, index, pressed); err != nil {
+			return err
+		}
+	}
+
+	ls := r.LeftThumbStick()
+	rs := r.RightThumbStick()
+
+	axes := []struct {
+		changed bool
+		proc    *syscall.LazyProc
+		value   uintptr
+	}{
+		{r.LeftTrigger() != last.leftTrigger, procSetTriggerL, uintptr(r.LeftTrigger())},
+		{r.RightTrigger() != last.rightTrigger, procSetTriggerR, uintptr(r.RightTrigger())},
+		{ls.X != last.leftThumbStickX, procSetAxisX, uintptr(uint16(ls.X))},
+		{ls.Y != last.leftThumbStickY, procSetAxisY, uintptr(uint16(ls.Y))},
+		{rs.X != last.rightThumbStickX, procSetAxisRx, uintptr(uint16(rs.X))},
+		{rs.Y != last.rightThumbStickY, procSetAxisRy, uintptr(uint16(rs.Y))},
+	}
+
+	for _, axis := range axes {
+		if !axis.changed {
+			continue
+		}
+
+		if err := vxboxCall(axis.proc, index, axis.value); err != nil {
+			return err
+		}
+	}
+
+	gamepad.last = &xboxGamepadReport{
+		buttons, r.LeftTrigger(), r.RightTrigger(),
+		ls.X, ls.Y, rs.X, rs.Y,
+	}
+
+	return nil
+}
+
+func (gamepad *vxboxGamepad) Close() {
+	if gamepad.last == nil {
+		return
+	}
+
+	procUnPlug.Call(uintptr(gamepad.index))
+	gamepad.last = nil
+}
+
+func vxboxCall(proc *syscall.LazyProc, args ...uintptr) error {
+	if ret, _, _ := proc.Call(args...); ret == 0 {
+		return errors.New("failed to update virtual controller: " + proc.Name)
+	}
+
+	return nil
+}
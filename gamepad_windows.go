@@ -1,3 +1,5 @@
+//go:build windows && cgo && !purego
+
 package game
 
 /*
@@ -11,6 +13,7 @@ package game
 import "C"
 import (
 	"errors"
+	"fmt"
 )
 
 func NewGamepad() (Gamepad, error) {
@@ -31,8 +34,8 @@ func (gamepad *xboxGamepad) Connect() error {
 	gamepad.client = client
 
 	// Connect to ViGEmBus
-	if C.vigem_connect(client) != C.VIGEM_ERROR_NONE {
-		return errors.New("failed to connect to ViGEmBus")
+	if ret := C.vigem_connect(client); ret != C.VIGEM_ERROR_NONE {
+		return fmt.Errorf("connect to ViGEmBus: %w", VigemError(ret))
 	}
 
 	// Create a virtual Xbox 360 controller
@@ -43,8 +46,13 @@ func (gamepad *xboxGamepad) Connect() error {
 	gamepad.target = target
 
 	// Add the virtual controller to the system
-	if C.vigem_target_add(client, target) != C.VIGEM_ERROR_NONE {
-		return errors.New("failed to add virtual controller")
+	if ret := C.vigem_target_add(client, target); ret != C.VIGEM_ERROR_NONE {
+		return fmt.Errorf("add virtual controller: %w", VigemError(ret))
+	}
+
+	// Only known once a controller is plugged in
+	if C.vigem_target_is_waitable_add_supported(target) == 0 {
+		return fmt.Errorf("ViGEmBus older than %s: %w", MinViGEmBusVersion, ErrGamepadDriverOutdated)
 	}
 
 	return nil
@@ -64,20 +72,35 @@ func (gamepad *xboxGamepad) Update(r GamepadReport) error {
 	report.sThumbRX = C.SHORT(rightThumbStick.X)
 	report.sThumbRY = C.SHORT(rightThumbStick.Y)
 
-	if C.vigem_target_x360_update(gamepad.client, gamepad.target, report) != C.VIGEM_ERROR_NONE {
-		return errors.New("failed to update virtual controller")
+	if ret := C.vigem_target_x360_update(gamepad.client, gamepad.target, report); ret != C.VIGEM_ERROR_NONE {
+		return fmt.Errorf("update virtual controller: %w", VigemError(ret))
 	}
 
 	return nil
 }
 
+// UserIndex returns the XInput user index the controller was assigned.
+func (gamepad *xboxGamepad) UserIndex() (int, error) {
+	var index C.ULONG
+	if ret := C.vigem_target_x360_get_user_index(gamepad.client, gamepad.target, &index); ret != C.VIGEM_ERROR_NONE {
+		return 0, fmt.Errorf("get user index: %w", VigemError(ret))
+	}
+
+	return int(index), nil
+}
+
 func (gamepad *xboxGamepad) Close() {
 	client := gamepad.client
 	target := gamepad.target
 
-	C.vigem_target_remove(client, target)
-	C.vigem_target_free(target)
+	// Connect may have failed half way
+	if target != nil {
+		C.vigem_target_remove(client, target)
+		C.vigem_target_free(target)
+	}
 
-	C.vigem_disconnect(client)
-	C.vigem_free(client)
+	if client != nil {
+		C.vigem_disconnect(client)
+		C.vigem_free(client)
+	}
 }
@@ -0,0 +1,134 @@
+package game
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"gopkg.in/yaml.v3"
+)
+
+// audioLevelURI is the header extension carrying the level of each audio
+// packet (RFC 6464), which the noise gate judges.
+const audioLevelURI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+// opusSilence is a 20 ms Opus frame of silence, sent in place of the
+// packets the gate holds back so the device keeps its timing.
+var opusSilence = []byte{0xF8, 0xFF, 0xFE}
+
+// NoiseGate silences audio quieter than Threshold, in dBov, once it has
+// been quiet for Hold. Levels are those the browser attaches to each packet;
+// packets without one pass.
+type NoiseGate struct {
+	Threshold int
+	Hold      time.Duration
+}
+
+var defaultNoiseGate = &NoiseGate{
+	Threshold: -50,
+	Hold:      300 * time.Millisecond,
+}
+
+func (cfg *NoiseGate) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Threshold int           `yaml:"threshold"`
+		Hold      time.Duration `yaml:"hold"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Threshold == 0 {
+		raw.Threshold = defaultNoiseGate.Threshold
+	}
+
+	if raw.Threshold < -127 || raw.Threshold > 0 {
+		return errors.New("noise gate threshold out of range: -127 to 0 dBov")
+	}
+
+	if raw.Hold < 0 {
+		return errors.New("noise gate hold must not be negative")
+	}
+
+	if raw.Hold == 0 {
+		raw.Hold = defaultNoiseGate.Hold
+	}
+
+	cfg.Threshold = raw.Threshold
+	cfg.Hold = raw.Hold
+
+	return nil
+}
+
+// gatedTrack replaces the packets of a track that must not be heard with
+// silence: all of them while push-to-talk is released, and those the noise
+// gate closes on.
+type gatedTrack struct {
+	track   rtpReader
+	gate    *NoiseGate       // nil passes all levels
+	talking *atomic.Bool     // nil without push-to-talk
+	level   uint8            // ID of the audio level extension, 0 if none
+	now     func() time.Time // of the clock, replaced in tests
+
+	lastLoud time.Time
+}
+
+func (t *gatedTrack) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	packet, attrs, err := t.track.ReadRTP()
+	if err != nil {
+		return packet, attrs, err
+	}
+
+	if !t.open(packet) {
+		packet.Payload = opusSilence
+		packet.Padding = false
+		packet.PaddingSize = 0
+	}
+
+	return packet, attrs, nil
+}
+
+func (t *gatedTrack) open(packet *rtp.Packet) bool {
+	if t.talking != nil && !t.talking.Load() {
+		return false
+	}
+
+	if t.gate == nil || t.level == 0 {
+		return true
+	}
+
+	ext := packet.GetExtension(t.level)
+	if ext == nil {
+		return true
+	}
+
+	var level rtp.AudioLevelExtension
+	if err := level.Unmarshal(ext); err != nil {
+		return true
+	}
+
+	now := t.now()
+
+	// The level is that of the packet below the overload point, negated.
+	if -int(level.Level) >= t.gate.Threshold {
+		t.lastLoud = now
+		return true
+	}
+
+	return now.Sub(t.lastLoud) < t.gate.Hold
+}
+
+// pushToTalk lets the host hear the peer's microphone, or stops it, while
+// push-to-talk is enabled.
+func (peer *Peer) pushToTalk(talking bool) error {
+	if !peer.requireTalk {
+		return NewControlError(ControlErrUnsupported, "push-to-talk disabled")
+	}
+
+	peer.talking.Store(talking)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package game
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNoiseGateUnmarshalYAML(t *testing.T) {
+	assert := assert.New(t)
+
+	var gate NoiseGate
+	err := yaml.Unmarshal([]byte("{}"), &gate)
+	if assert.NoError(err) {
+		assert.Equal(*defaultNoiseGate, gate)
+	}
+
+	err = yaml.Unmarshal([]byte("{threshold: -40, hold: 1s}"), &gate)
+	assert.NoError(err)
+	assert.Equal(NoiseGate{Threshold: -40, Hold: time.Second}, gate)
+
+	err = yaml.Unmarshal([]byte("threshold: 10"), &gate)
+	assert.Error(err)
+}
+
+type fakeRTPReader struct {
+	packets []*rtp.Packet
+}
+
+func (r *fakeRTPReader) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	if len(r.packets) == 0 {
+		return nil, nil, io.EOF
+	}
+
+	packet := r.packets[0]
+	r.packets = r.packets[1:]
+
+	return packet, nil, nil
+}
+
+// levelPacket is an Opus packet carrying its level in extension 1.
+func levelPacket(t *testing.T, dBov int) *rtp.Packet {
+	bs, err := rtp.AudioLevelExtension{Level: uint8(-dBov)}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := &rtp.Packet{Payload: []byte{0xFC, 0x01, 0x02}}
+	if err := packet.Header.SetExtension(1, bs); err != nil {
+		t.Fatal(err)
+	}
+
+	return packet
+}
+
+func TestGatedTrack(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1_700_000_000, 0)
+
+	track := &fakeRTPReader{}
+	gated := &gatedTrack{
+		track: track,
+		gate:  &NoiseGate{Threshold: -50, Hold: 300 * time.Millisecond},
+		level: 1,
+		now:   func() time.Time { return now },
+	}
+
+	read := func(packet *rtp.Packet) []byte {
+		track.packets = append(track.packets, packet)
+
+		packet, _, err := gated.ReadRTP()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return packet.Payload
+	}
+
+	// speech passes, and so does what follows it within the hold
+	assert.Equal([]byte{0xFC, 0x01, 0x02}, read(levelPacket(t, -20)))
+
+	now = now.Add(200 * time.Millisecond)
+	assert.Equal([]byte{0xFC, 0x01, 0x02}, read(levelPacket(t, -90)))
+
+	// background noise after the hold is silenced
+	now = now.Add(200 * time.Millisecond)
+	assert.Equal(opusSilence, read(levelPacket(t, -90)))
+
+	// packets without a level pass
+	assert.Equal([]byte{0xFC}, read(&rtp.Packet{Payload: []byte{0xFC}}))
+
+	// with push-to-talk only a held talk is heard
+	var talking atomic.Bool
+	gated.talking = &talking
+
+	assert.Equal(opusSilence, read(levelPacket(t, -20)))
+
+	talking.Store(true)
+	assert.Equal([]byte{0xFC, 0x01, 0x02}, read(levelPacket(t, -20)))
+}
+
+func TestPushToTalk(t *testing.T) {
+	assert := assert.New(t)
+
+	peer := &Peer{}
+	assert.Error(peer.pushToTalk(true))
+
+	peer.requireTalk = true
+	assert.NoError(peer.pushToTalk(true))
+	assert.True(peer.talking.Load())
+
+	assert.NoError(peer.pushToTalk(false))
+	assert.False(peer.talking.Load())
+}
@@ -0,0 +1,237 @@
+package game
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidGuestToken = errors.New("invalid guest token")
+	ErrGuestTokenExpired = errors.New("guest token expired")
+	ErrGuestTokenRevoked = errors.New("guest token revoked")
+)
+
+type GuestRole string
+
+const (
+	GuestSpectator GuestRole = "spectator"
+	GuestPlayer    GuestRole = "player" // owns a controller slot
+)
+
+func ParseGuestRole(role string) (GuestRole, error) {
+	switch role {
+	case "", "spectator":
+		return GuestSpectator, nil
+	case "player":
+		return GuestPlayer, nil
+	default:
+		return "", errors.New("invalid guest role: " + role)
+	}
+}
+
+// Permissions returns the input permissions granted to the role.
+func (role GuestRole) Permissions() Permissions {
+	if role == GuestPlayer {
+		return PermissionGamepad
+	}
+
+	return PermissionNone
+}
+
+// Guests configures guest links. Tokens are signed with Secret; without one
+// a random secret is generated at startup, so links end with the process.
+type Guests struct {
+	Secret     string
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+}
+
+func (cfg *Guests) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Secret     string        `yaml:"secret"`
+		DefaultTTL time.Duration `yaml:"defaultTTL"`
+		MaxTTL     time.Duration `yaml:"maxTTL"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if err := resolveSecrets(&raw.Secret); err != nil {
+		return err
+	}
+
+	if raw.DefaultTTL == 0 {
+		raw.DefaultTTL = time.Hour
+	}
+
+	if raw.MaxTTL == 0 {
+		raw.MaxTTL = 24 * time.Hour
+	}
+
+	cfg.Secret = raw.Secret
+	cfg.DefaultTTL = raw.DefaultTTL
+	cfg.MaxTTL = raw.MaxTTL
+
+	return nil
+}
+
+var defaultGuests = &Guests{
+	DefaultTTL: time.Hour,
+	MaxTTL:     24 * time.Hour,
+}
+
+type GuestOptions struct {
+	Stream string
+	Role   GuestRole
+	Slot   int // controller slot of a player, 2 to MaxGamepadSlots
+	TTL    time.Duration
+}
+
+type GuestToken struct {
+	ID        string    `json:"id"`
+	Stream    string    `json:"stream"`
+	Role      GuestRole `json:"role"`
+	Slot      int       `json:"slot,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Token     string    `json:"token,omitempty"`
+}
+
+func newGuestIssuer(cfg *Guests) (*guestIssuer, error) {
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return &guestIssuer{
+		cfg:     cfg,
+		secret:  secret,
+		revoked: make(map[string]time.Time),
+	}, nil
+}
+
+// guestIssuer mints and verifies guest tokens. A token is the base64url
+// encoded JSON claims and their HMAC-SHA256, joined by a dot.
+type guestIssuer struct {
+	cfg     *Guests
+	secret  []byte
+	revoked map[string]time.Time
+	sync.Mutex
+}
+
+func (g *guestIssuer) Issue(opts GuestOptions) (*GuestToken, error) {
+	if opts.Role == "" {
+		opts.Role = GuestSpectator
+	}
+
+	switch {
+	case opts.Role == GuestPlayer && (opts.Slot < 2 || opts.Slot > MaxGamepadSlots):
+		return nil, errors.New("player requires a controller slot from 2 to " + strconv.Itoa(MaxGamepadSlots))
+	case opts.Role != GuestPlayer && opts.Slot != 0:
+		return nil, errors.New("controller slot requires the player role")
+	}
+
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = g.cfg.DefaultTTL
+	}
+
+	if ttl > g.cfg.MaxTTL {
+		return nil, errors.New("guest token ttl exceeds " + g.cfg.MaxTTL.String())
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	token := &GuestToken{
+		ID:        hex.EncodeToString(id),
+		Stream:    opts.Stream,
+		Role:      opts.Role,
+		Slot:      opts.Slot,
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+	}
+
+	claims, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	token.Token = payload + "." + base64.RawURLEncoding.EncodeToString(g.sign(payload))
+
+	return token, nil
+}
+
+func (g *guestIssuer) Verify(s string) (*GuestToken, error) {
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, ErrInvalidGuestToken
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, g.sign(payload)) {
+		return nil, ErrInvalidGuestToken
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidGuestToken
+	}
+
+	var token *GuestToken
+	if err := json.Unmarshal(claims, &token); err != nil {
+		return nil, ErrInvalidGuestToken
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrGuestTokenExpired
+	}
+
+	g.Lock()
+	_, revoked := g.revoked[token.ID]
+	g.Unlock()
+
+	if revoked {
+		return nil, ErrGuestTokenRevoked
+	}
+
+	return token, nil
+}
+
+// Revoke rejects the token from now on. Revocations are kept for MaxTTL,
+// after which any token issued before has expired anyway.
+func (g *guestIssuer) Revoke(id string) {
+	g.Lock()
+	defer g.Unlock()
+
+	now := time.Now()
+	for revokedID, until := range g.revoked {
+		if now.After(until) {
+			delete(g.revoked, revokedID)
+		}
+	}
+
+	g.revoked[id] = now.Add(g.cfg.MaxTTL)
+}
+
+func (g *guestIssuer) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
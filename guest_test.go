@@ -0,0 +1,80 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuestToken(t *testing.T) {
+	assert := assert.New(t)
+
+	issuer, err := newGuestIssuer(defaultGuests)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	token, err := issuer.Issue(GuestOptions{Stream: "gamestream", Role: GuestSpectator})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.WithinDuration(time.Now().Add(time.Hour), token.ExpiresAt, 2*time.Second)
+
+	verified, err := issuer.Verify(token.Token)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(token.ID, verified.ID)
+	assert.Equal("gamestream", verified.Stream)
+	assert.Equal(PermissionNone, verified.Role.Permissions())
+
+	_, err = issuer.Verify(token.Token + "x")
+	assert.ErrorIs(err, ErrInvalidGuestToken)
+
+	other, _ := newGuestIssuer(defaultGuests)
+	_, err = other.Verify(token.Token)
+	assert.ErrorIs(err, ErrInvalidGuestToken)
+
+	issuer.Revoke(token.ID)
+	_, err = issuer.Verify(token.Token)
+	assert.ErrorIs(err, ErrGuestTokenRevoked)
+
+	expired, err := issuer.Issue(GuestOptions{Stream: "gamestream", TTL: time.Nanosecond})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = issuer.Verify(expired.Token)
+	assert.ErrorIs(err, ErrGuestTokenExpired)
+
+	_, err = issuer.Issue(GuestOptions{Stream: "gamestream", TTL: 48 * time.Hour})
+	assert.Error(err)
+
+	_, err = issuer.Issue(GuestOptions{Stream: "gamestream", Role: GuestPlayer})
+	assert.Error(err)
+
+	_, err = issuer.Issue(GuestOptions{Stream: "gamestream", Role: GuestSpectator, Slot: 2})
+	assert.Error(err)
+
+	player, err := issuer.Issue(GuestOptions{Stream: "gamestream", Role: GuestPlayer, Slot: 2})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	verified, err = issuer.Verify(player.Token)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(2, verified.Slot)
+	assert.Equal(PermissionGamepad, verified.Role.Permissions())
+}
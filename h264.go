@@ -0,0 +1,158 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	nalTypeSlice uint8 = 1
+	nalTypeIDR   uint8 = 5
+	nalTypeSEI   uint8 = 6
+	nalTypeSPS   uint8 = 7
+	nalTypePPS   uint8 = 8
+	nalTypeAUD   uint8 = 9
+)
+
+func nalType(nal []byte) uint8 {
+	if len(nal) == 0 {
+		return 0
+	}
+
+	return nal[0] & 0x1F
+}
+
+// splitAnnexB splits an Annex B byte stream into NAL units without start
+// codes. Data without any start code is returned as a single NAL unit.
+func splitAnnexB(data []byte) [][]byte {
+	var nals [][]byte
+
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+
+		if start >= 0 {
+			end := i
+			if end > start && data[end-1] == 0 {
+				end--
+			}
+
+			nals = append(nals, data[start:end])
+		}
+
+		start = i + 3
+		i += 2
+	}
+
+	if start < 0 {
+		if len(data) > 0 {
+			nals = append(nals, data)
+		}
+
+		return nals
+	}
+
+	if start < len(data) {
+		nals = append(nals, data[start:])
+	}
+
+	return nals
+}
+
+// accessUnits groups NAL units into access units, the NAL units of one
+// picture. A unit ends before an AUD, an SPS, a PPS, an SEI or the first
+// slice of the next picture, so it is only complete once the next one
+// starts.
+type accessUnits struct {
+	nals     [][]byte
+	hasSlice bool
+}
+
+// Push adds a NAL unit and returns the access unit it completed, if any,
+// as an Annex B byte stream.
+func (au *accessUnits) Push(nal []byte) []byte {
+	var unit []byte
+	if au.hasSlice && startsAccessUnit(nal) {
+		unit = au.Flush()
+	}
+
+	au.nals = append(au.nals, nal)
+
+	switch nalType(nal) {
+	case nalTypeSlice, nalTypeIDR:
+		au.hasSlice = true
+	}
+
+	return unit
+}
+
+// Flush returns the pending access unit, nil if there is none.
+func (au *accessUnits) Flush() []byte {
+	if len(au.nals) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, nal := range au.nals {
+		buf.Write([]byte{0, 0, 0, 1})
+		buf.Write(nal)
+	}
+
+	au.nals = au.nals[:0]
+	au.hasSlice = false
+
+	return buf.Bytes()
+}
+
+func startsAccessUnit(nal []byte) bool {
+	switch t := nalType(nal); t {
+	case nalTypeAUD, nalTypeSPS, nalTypePPS, nalTypeSEI:
+		return true
+
+	case nalTypeSlice, nalTypeIDR:
+		// first_mb_in_slice, ue(v), is 0 when its first bit is set
+		return len(nal) > 1 && nal[1]&0x80 != 0
+
+	default:
+		// 14 to 18 are reserved for, or only come before, a new picture
+		return t >= 14 && t <= 18
+	}
+}
+
+// lengthPrefixed converts NAL units to the AVCC format with 4-byte lengths.
+func lengthPrefixed(nals [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, nal := range nals {
+		binary.Write(&buf, binary.BigEndian, uint32(len(nal)))
+		buf.Write(nal)
+	}
+
+	return buf.Bytes()
+}
+
+// avcDecoderConfiguration builds an AVCDecoderConfigurationRecord (avcC)
+// from a single SPS and PPS.
+func avcDecoderConfiguration(sps, pps []byte) []byte {
+	if len(sps) < 4 {
+		return nil
+	}
+
+	buf := []byte{
+		1,      // configurationVersion
+		sps[1], // AVCProfileIndication
+		sps[2], // profile_compatibility
+		sps[3], // AVCLevelIndication
+		0xFF,   // lengthSizeMinusOne = 3
+		0xE1,   // numOfSequenceParameterSets = 1
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(sps)))
+	buf = append(buf, sps...)
+	buf = append(buf, 1) // numOfPictureParameterSets
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(pps)))
+	buf = append(buf, pps...)
+
+	return buf
+}
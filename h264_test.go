@@ -0,0 +1,40 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessUnits(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		aud    = []byte{0x09, 0xF0}
+		sps    = []byte{0x67, 0x42, 0xC0, 0x1F}
+		pps    = []byte{0x68, 0xCE, 0x3C, 0x80}
+		idr    = []byte{0x65, 0x88, 0x84}       // first_mb_in_slice 0
+		idr2   = []byte{0x65, 0x40, 0x84}       // a second slice of the picture
+		slice  = []byte{0x41, 0x9A, 0x02}       // first slice of the next picture
+		slice2 = []byte{0x41, 0x9A, 0x04, 0x01} // the one after
+	)
+
+	var units accessUnits
+
+	for _, nal := range [][]byte{aud, sps, pps, idr, idr2} {
+		assert.Nil(units.Push(nal))
+	}
+
+	// the next picture completes the keyframe, parameter sets included
+	unit := units.Push(slice)
+	assert.Equal([][]byte{aud, sps, pps, idr, idr2}, splitAnnexB(unit))
+
+	unit = units.Push(slice2)
+	assert.Equal([][]byte{slice}, splitAnnexB(unit))
+
+	unit = units.Push(aud)
+	assert.Equal([][]byte{slice2}, splitAnnexB(unit))
+
+	assert.Equal([][]byte{aud}, splitAnnexB(units.Flush()))
+	assert.Nil(units.Flush())
+}
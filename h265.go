@@ -0,0 +1,249 @@
+package game
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// H265 NAL unit types.
+const (
+	hevcNALTypeVPS       = 32
+	hevcNALTypeSPS       = 33
+	hevcNALTypePPS       = 34
+	hevcNALTypeAUD       = 35
+	hevcNALTypePrefixSEI = 39
+	hevcNALTypeFU        = 49 // fragmentation unit, RFC 7798
+)
+
+func hevcNALType(nal []byte) int {
+	if len(nal) == 0 {
+		return -1
+	}
+
+	return int(nal[0]>>1) & 0x3F
+}
+
+// nalReader reads the NAL units of an Annex B byte stream. Unlike pion's
+// h264reader, it leaves their headers alone, which H265 lays out apart.
+type nalReader struct {
+	r       *bufio.Reader
+	nal     []byte
+	zeros   int
+	started bool // past the first start code
+}
+
+func newNALReader(r io.Reader) *nalReader {
+	return &nalReader{r: bufio.NewReader(r)}
+}
+
+func (nr *nalReader) NextNAL() ([]byte, error) {
+	for {
+		b, err := nr.r.ReadByte()
+		if err != nil {
+			nal := nr.take()
+			if err == io.EOF && nr.started && len(nal) > 0 {
+				return nal, nil
+			}
+
+			return nil, err
+		}
+
+		if b == 1 && nr.zeros >= 2 {
+			nal := nr.take()
+			started := nr.started
+			nr.started = true
+
+			if started && len(nal) > 0 {
+				return nal, nil
+			}
+
+			continue
+		}
+
+		if b == 0 {
+			nr.zeros++
+		} else {
+			nr.zeros = 0
+		}
+
+		nr.nal = append(nr.nal, b)
+	}
+}
+
+// take returns the pending NAL unit without its trailing zeros, which
+// belong to the next start code.
+func (nr *nalReader) take() []byte {
+	nal := nr.nal[:len(nr.nal)-nr.zeros]
+
+	nr.nal = nil
+	nr.zeros = 0
+
+	return nal
+}
+
+// hevcAccessUnits groups H265 NAL units into access units, as accessUnits
+// does H264's.
+type hevcAccessUnits struct {
+	nals     [][]byte
+	hasSlice bool
+}
+
+func (au *hevcAccessUnits) Push(nal []byte) []byte {
+	var unit []byte
+	if au.hasSlice && startsHEVCAccessUnit(nal) {
+		unit = au.Flush()
+	}
+
+	au.nals = append(au.nals, nal)
+
+	if t := hevcNALType(nal); t >= 0 && t < 32 {
+		au.hasSlice = true
+	}
+
+	return unit
+}
+
+func (au *hevcAccessUnits) Flush() []byte {
+	if len(au.nals) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, nal := range au.nals {
+		buf.Write([]byte{0, 0, 0, 1})
+		buf.Write(nal)
+	}
+
+	au.nals = au.nals[:0]
+	au.hasSlice = false
+
+	return buf.Bytes()
+}
+
+func startsHEVCAccessUnit(nal []byte) bool {
+	switch t := hevcNALType(nal); {
+	case t < 0:
+		return false
+
+	case t < 32:
+		// first_slice_segment_in_pic_flag follows the 2-byte header
+		return len(nal) > 2 && nal[2]&0x80 != 0
+
+	case t >= hevcNALTypeVPS && t <= hevcNALTypeAUD, t == hevcNALTypePrefixSEI:
+		return true
+
+	default:
+		// 41 to 44 and 48 to 55 are reserved for, or only come before, a
+		// new picture
+		return t >= 41 && t <= 44 || t >= 48 && t <= 55
+	}
+}
+
+// hevcPayloader packetizes H265 access units in single NAL unit packets,
+// fragmenting those over the MTU.
+type hevcPayloader struct{}
+
+func (p *hevcPayloader) Payload(mtu uint16, payload []byte) [][]byte {
+	var payloads [][]byte
+
+	for _, nal := range splitAnnexB(payload) {
+		if len(nal) < 3 || hevcNALType(nal) == hevcNALTypeAUD {
+			continue
+		}
+
+		if len(nal) <= int(mtu) {
+			payloads = append(payloads, bytes.Clone(nal))
+			continue
+		}
+
+		// The payload header keeps the unit's F, LayerId and TID.
+		header := []byte{nal[0]&0x81 | hevcNALTypeFU<<1, nal[1]}
+		t := byte(hevcNALType(nal))
+
+		data := nal[2:]
+		size := int(mtu) - 3
+
+		for start := true; len(data) > 0; start = false {
+			n := min(size, len(data))
+
+			fu := t
+			if start {
+				fu |= 0x80
+			}
+
+			if n == len(data) {
+				fu |= 0x40
+			}
+
+			out := make([]byte, 0, 3+n)
+			out = append(out, header...)
+			out = append(out, fu)
+			out = append(out, data[:n]...)
+
+			payloads = append(payloads, out)
+			data = data[n:]
+		}
+	}
+
+	return payloads
+}
+
+// hevcTrack is a track of H265 samples, which pion cannot packetize.
+type hevcTrack struct {
+	*webrtc.TrackLocalStaticRTP
+	packetizer rtp.Packetizer
+	sync.Mutex
+}
+
+func newHEVCTrack(id, streamID string) (*hevcTrack, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeH265,
+		}, id, streamID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &hevcTrack{
+		TrackLocalStaticRTP: track,
+		packetizer:          rtp.NewPacketizer(rtpMTU, 0, 0, &hevcPayloader{}, rtp.NewRandomSequencer(), videoClockRate),
+	}, nil
+}
+
+func (t *hevcTrack) WriteSample(sample media.Sample) error {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, pkt := range t.packetizer.Packetize(sample.Data, clockSamples(sample.Duration)) {
+		if err := t.WriteRTP(pkt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerHEVC lets a media engine negotiate H265, which pion's default
+// codecs leave out. It comes before the interceptors, which give video
+// codecs their RTCP feedback.
+func registerHEVC(m *webrtc.MediaEngine) error {
+	return m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeH265,
+			ClockRate: videoClockRate,
+			RTCPFeedback: []webrtc.RTCPFeedback{
+				{Type: "goog-remb"},
+				{Type: "ccm", Parameter: "fir"},
+			},
+		},
+		PayloadType: 116, // unused by the defaults
+	}, webrtc.RTPCodecTypeVideo)
+}
@@ -0,0 +1,170 @@
+package game
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNALReader(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := []byte{
+		0, 0, 0, 1, 0x40, 0x01, 0x0C,
+		0, 0, 1, 0x26, 0x01, 0xAF, 0x00,
+		0, 0, 0, 1, 0x02, 0x01, 0x80,
+	}
+
+	reader := newNALReader(bytes.NewReader(stream))
+
+	var nals [][]byte
+	for {
+		nal, err := reader.NextNAL()
+		if err != nil {
+			assert.ErrorIs(err, io.EOF)
+			break
+		}
+
+		nals = append(nals, nal)
+	}
+
+	// an IDR, which h264reader would take for an SEI, is kept
+	assert.Equal([][]byte{
+		{0x40, 0x01, 0x0C},
+		{0x26, 0x01, 0xAF},
+		{0x02, 0x01, 0x80},
+	}, nals)
+}
+
+func TestHEVCAccessUnits(t *testing.T) {
+	assert := assert.New(t)
+
+	vps := []byte{0x40, 0x01, 0x0C}
+	idr := []byte{0x26, 0x01, 0xAF}   // first slice of a picture
+	trail := []byte{0x02, 0x01, 0x80} // first slice of a picture
+	cont := []byte{0x02, 0x01, 0x40}  // another slice of the same picture
+
+	var units hevcAccessUnits
+	assert.Nil(units.Push(vps))
+	assert.Nil(units.Push(idr))
+
+	unit := units.Push(trail)
+	assert.Equal([][]byte{vps, idr}, splitAnnexB(unit))
+
+	assert.Nil(units.Push(cont))
+	assert.Equal([][]byte{trail, cont}, splitAnnexB(units.Flush()))
+}
+
+func TestHEVCPayloader(t *testing.T) {
+	assert := assert.New(t)
+
+	small := []byte{0x40, 0x01, 0x0C}
+	aud := []byte{0x46, 0x01, 0x50}
+
+	large := make([]byte, 250)
+	large[0], large[1] = 0x26, 0x01 // IDR_W_RADL
+	for i := 2; i < len(large); i++ {
+		large[i] = byte(i)
+	}
+
+	var au []byte
+	for _, nal := range [][]byte{aud, small, large} {
+		au = append(au, 0, 0, 0, 1)
+		au = append(au, nal...)
+	}
+
+	payloads := (&hevcPayloader{}).Payload(100, au)
+
+	// the AUD is dropped, the IDR fragmented
+	assert.Equal(small, payloads[0])
+	assert.Len(payloads, 4)
+
+	var nal []byte
+	for i, p := range payloads[1:] {
+		assert.LessOrEqual(len(p), 100)
+		assert.Equal(hevcNALTypeFU, hevcNALType(p))
+		assert.Equal(byte(0x01), p[1])
+
+		assert.Equal(i == 0, p[2]&0x80 != 0)
+		assert.Equal(i == 2, p[2]&0x40 != 0)
+		assert.Equal(byte(19), p[2]&0x3F)
+
+		nal = append(nal, p[3:]...)
+	}
+
+	assert.Equal(large[2:], nal)
+}
+
+func TestHEVCNegotiation(t *testing.T) {
+	assert := assert.New(t)
+
+	api, err := newPeerAPI(DTLSRoleAuto, new(dtlsHandshake), peerMedia{video: CodecH265})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	server, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer server.Close()
+
+	m := new(webrtc.MediaEngine)
+	if err := registerHEVC(m); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	client, err := webrtc.NewAPI(webrtc.WithMediaEngine(m)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer client.Close()
+
+	_, err = client.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	})
+
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	track, err := newVideoTrack(CodecH265, "game_video", "game")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if _, err := server.AddTrack(track); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if err := server.SetRemoteDescription(offer); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	answer, err := server.CreateAnswer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(strings.Contains(answer.SDP, "H265/90000"))
+	assert.True(strings.Contains(answer.SDP, "a=sendonly"))
+}
@@ -0,0 +1,195 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/nvstream"
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+const (
+	// client -> server
+	ControlHDR ControlMessageType = "video.hdr"
+
+	// server -> client
+	ControlHDRMode ControlMessageType = "hdr.mode"
+)
+
+// HDRRequest is the payload of video.hdr: whether the client displays HDR
+// video, switching the host to the 10-bit profiles of the video formats.
+type HDRRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// HDRMode is the payload of hdr.mode: whether the host sends HDR video, with
+// the metadata clients need to tone map it when the host has them.
+type HDRMode struct {
+	Enabled  bool         `json:"enabled"`
+	Metadata *HDRMetadata `json:"metadata,omitempty"`
+}
+
+// HDRMetadata are the mastering display colour volume and the content light
+// levels of the video. Chromaticities are in units of 0.00002, the minimum
+// display luminance in 0.0001 nits and the other levels in nits.
+type HDRMetadata struct {
+	DisplayPrimaries      [3]Chromaticity `json:"display_primaries"` // red, green, blue
+	WhitePoint            Chromaticity    `json:"white_point"`
+	MaxDisplayLuminance   uint16          `json:"max_display_luminance"`
+	MinDisplayLuminance   uint16          `json:"min_display_luminance"`
+	MaxContentLightLevel  uint16          `json:"max_cll,omitempty"`
+	MaxFrameAverageLevel  uint16          `json:"max_fall,omitempty"`
+	MaxFullFrameLuminance uint16          `json:"max_full_frame_luminance,omitempty"`
+}
+
+type Chromaticity struct {
+	X uint16 `json:"x"`
+	Y uint16 `json:"y"`
+}
+
+func newHDRMode(mode nvstream.HDRMode) HDRMode {
+	m := HDRMode{Enabled: mode.Enabled}
+
+	if md := mode.Metadata; md != nil {
+		m.Metadata = &HDRMetadata{
+			WhitePoint:            Chromaticity(md.WhitePoint),
+			MaxDisplayLuminance:   md.MaxDisplayLuminance,
+			MinDisplayLuminance:   md.MinDisplayLuminance,
+			MaxContentLightLevel:  md.MaxContentLightLevel,
+			MaxFrameAverageLevel:  md.MaxFrameAverageLightLevel,
+			MaxFullFrameLuminance: md.MaxFullFrameLuminance,
+		}
+
+		for i, primary := range md.DisplayPrimaries {
+			m.Metadata.DisplayPrimaries[i] = Chromaticity(primary)
+		}
+	}
+
+	return m
+}
+
+// lastHDRMode keeps the mode the host last switched to for peers opening
+// their control channel later.
+type lastHDRMode struct {
+	mode *HDRMode
+	sync.Mutex
+}
+
+func (l *lastHDRMode) set(mode HDRMode) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.mode = &mode
+}
+
+func (l *lastHDRMode) get() (HDRMode, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.mode == nil {
+		return HDRMode{}, false
+	}
+
+	return *l.mode, true
+}
+
+// forwardHDRModes sends the HDR modes the host switches to to the peers of
+// the stream until ctx is done.
+func (svc *service) forwardHDRModes(ctx context.Context, stream *Stream, modes <-chan nvstream.HDRMode) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case m := <-modes:
+			mode := newHDRMode(m)
+			stream.hdr.set(mode)
+
+			if stream.peers == nil {
+				continue
+			}
+
+			for _, peer := range stream.peers.Peers() {
+				peer.sendHDRMode(mode)
+			}
+		}
+	}
+}
+
+func (peer *Peer) sendHDRMode(mode HDRMode) {
+	if !peer.mode.Video() {
+		return
+	}
+
+	msg, err := NewControlMessage(ControlHDRMode, &mode)
+	if err != nil {
+		return
+	}
+
+	peer.SendControl(msg)
+}
+
+// sendLastHDRMode sends the HDR mode the host last switched to, if any.
+func (peer *Peer) sendLastHDRMode() {
+	if peer.stream == nil || peer.stream.hdr == nil {
+		return
+	}
+
+	if mode, ok := peer.stream.hdr.get(); ok {
+		peer.sendHDRMode(mode)
+	}
+}
+
+// handleHDR switches the stream's source to or from HDR video. As every
+// peer of the stream gets it, only the sole peer or the one in control may.
+func (peer *Peer) handleHDR(msg *ControlMessage) error {
+	var req HDRRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return NewControlError(ControlErrBadRequest, err.Error())
+	}
+
+	stream := peer.stream
+	if stream.source == nil {
+		return errNoHook
+	}
+
+	if peer.group.Len() > 1 && peer.group.Controller() != peer {
+		return NewControlError(ControlErrPermissionDenied, "stream shared with other peers")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sourceTimeout)
+	defer cancel()
+
+	if err := stream.source.SetHDR(ctx, req.Enabled); err != nil {
+		return err
+	}
+
+	peer.log.Info("hdr changed", zap.Bool("enabled", req.Enabled))
+
+	return nil
+}
+
+// nvVideoCodec reports whether every video format of an NVStream stream
+// encodes in the codec of its track, which carries the host's video as is.
+func nvVideoCodec(codec Codec, formats []moonlight.VideoFormat) bool {
+	var mask moonlight.VideoFormatMask
+	switch codec {
+	case CodecH264:
+		mask = moonlight.VIDEO_FORMAT_MASK_H264
+	case CodecH265:
+		mask = moonlight.VIDEO_FORMAT_MASK_H265
+	default:
+		return false
+	}
+
+	for _, format := range formats {
+		if moonlight.VideoFormatMask(format)&mask == 0 {
+			return false
+		}
+	}
+
+	return true
+}
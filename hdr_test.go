@@ -0,0 +1,75 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/nvstream"
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+func TestForwardHDRModes(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &service{}
+	stream := &Stream{hdr: new(lastHDRMode)}
+
+	_, ok := stream.hdr.get()
+	assert.False(ok)
+
+	modes := make(chan nvstream.HDRMode)
+	go svc.forwardHDRModes(ctx, stream, modes)
+
+	modes <- nvstream.HDRMode{
+		Enabled: true,
+		Metadata: &moonlight.HDRMetadata{
+			DisplayPrimaries:     [3]moonlight.Chromaticity{{X: 35400, Y: 14600}, {X: 8500, Y: 39850}, {X: 6550, Y: 2300}},
+			WhitePoint:           moonlight.Chromaticity{X: 15635, Y: 16450},
+			MaxDisplayLuminance:  1000,
+			MinDisplayLuminance:  50,
+			MaxContentLightLevel: 800,
+		},
+	}
+
+	assert.Eventually(func() bool {
+		_, ok := stream.hdr.get()
+		return ok
+	}, time.Second, time.Millisecond)
+
+	mode, _ := stream.hdr.get()
+	if assert.True(mode.Enabled) && assert.NotNil(mode.Metadata) {
+		assert.Equal(Chromaticity{X: 8500, Y: 39850}, mode.Metadata.DisplayPrimaries[1])
+		assert.Equal(Chromaticity{X: 15635, Y: 16450}, mode.Metadata.WhitePoint)
+		assert.Equal(uint16(800), mode.Metadata.MaxContentLightLevel)
+	}
+
+	modes <- nvstream.HDRMode{}
+
+	assert.Eventually(func() bool {
+		mode, _ := stream.hdr.get()
+		return !mode.Enabled
+	}, time.Second, time.Millisecond)
+
+	mode, _ = stream.hdr.get()
+	assert.Nil(mode.Metadata)
+}
+
+func TestNVVideoCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	h264 := []moonlight.VideoFormat{moonlight.VIDEO_FORMAT_H264}
+	hevc := []moonlight.VideoFormat{moonlight.VIDEO_FORMAT_H265, moonlight.VIDEO_FORMAT_H265_MAIN10}
+
+	assert.True(nvVideoCodec(CodecH264, h264))
+	assert.True(nvVideoCodec(CodecH265, hevc))
+
+	assert.False(nvVideoCodec(CodecH264, hevc))
+	assert.False(nvVideoCodec(CodecH265, append(hevc, moonlight.VIDEO_FORMAT_H264)))
+	assert.False(nvVideoCodec(CodecAV1, []moonlight.VideoFormat{moonlight.VIDEO_FORMAT_AV1_MAIN8}))
+}
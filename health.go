@@ -0,0 +1,106 @@
+package game
+
+import (
+	"time"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+// StallTimeout is how long a track that has received samples may go
+// without one before it counts as stalled.
+const StallTimeout = 5 * time.Second
+
+// Health reports the state of each stream, the gamepad and the ICE
+// providers. The service is ready when every stream is.
+type Health struct {
+	Ready   bool                `json:"ready"`
+	Streams []*StreamHealth     `json:"streams"`
+	Gamepad *GamepadHealth      `json:"gamepad"`
+	ICE     []ICEProviderHealth `json:"ice,omitempty"`
+
+	// Subscriptions counts the active NATS subscriptions of peers.
+	Subscriptions int `json:"subscriptions"`
+}
+
+type StreamHealth struct {
+	Name      string                   `json:"name"`
+	Transport Transport                `json:"transport"`
+	Stage     nvstream.ConnectionStage `json:"stage,omitempty"` // NVStream only
+	Video     *TrackHealth             `json:"video,omitempty"`
+	Audio     *TrackHealth             `json:"audio,omitempty"`
+	Ready     bool                     `json:"ready"`
+	Standby   bool                     `json:"standby,omitempty"` // lazy or idle, without peers
+}
+
+type TrackHealth struct {
+	Listening  bool       `json:"listening,omitempty"` // raw only
+	LastSample *time.Time `json:"last_sample,omitempty"`
+	Stalled    bool       `json:"stalled"`
+}
+
+type GamepadHealth struct {
+	Disabled    bool           `json:"disabled,omitempty"`
+	Backend     GamepadBackend `json:"backend"`
+	Driver      *GamepadDriver `json:"driver,omitempty"`
+	Controllers int            `json:"controllers"`
+}
+
+// streamHealth checks a stream: a raw stream is ready while its sockets
+// listen, an NVStream stream while connected and a lazy or idle stream on
+// standby, and none may have a stalled track.
+func streamHealth(stream *Stream, now time.Time) *StreamHealth {
+	h := &StreamHealth{
+		Name:      stream.Name,
+		Transport: stream.Transport,
+		Ready:     true,
+	}
+
+	raw := stream.Transport == TransportRaw
+
+	// Until a peer connects, a lazy or idle stream has nothing to check.
+	if stream.standby() {
+		if stream.conn != nil {
+			h.Stage = stream.conn.Stage()
+		}
+
+		h.Standby = true
+		return h
+	}
+
+	if stream.conn != nil {
+		h.Stage = stream.conn.Stage()
+		h.Ready = h.Stage == nvstream.ConnectionStageConnected
+	}
+
+	check := func(track Track) *TrackHealth {
+		th := new(TrackHealth)
+
+		if raw {
+			th.Listening = track.health().Listening()
+			if !th.Listening {
+				h.Ready = false
+			}
+		}
+
+		if last := track.health().LastSample(); !last.IsZero() {
+			th.LastSample = &last
+			th.Stalled = now.Sub(last) > StallTimeout
+		}
+
+		if th.Stalled {
+			h.Ready = false
+		}
+
+		return th
+	}
+
+	if stream.Video != nil {
+		h.Video = check(stream.Video)
+	}
+
+	if stream.Audio != nil {
+		h.Audio = check(stream.Audio)
+	}
+
+	return h
+}
@@ -0,0 +1,70 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+type stagedConnection struct {
+	nvstream.NvConnection
+	stage nvstream.ConnectionStage
+}
+
+func (conn *stagedConnection) Stage() nvstream.ConnectionStage {
+	return conn.stage
+}
+
+func TestStreamHealth(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+
+	stream := &Stream{
+		Name:      "gamestream",
+		Transport: TransportRaw,
+		Video:     new(VideoTrack),
+		Audio:     new(AudioTrack),
+	}
+
+	// sockets not listening yet
+	h := streamHealth(stream, now)
+	assert.False(h.Ready)
+	assert.False(h.Video.Listening)
+	assert.Nil(h.Video.LastSample)
+
+	stream.Video.listening.Store(true)
+	stream.Audio.listening.Store(true)
+	stream.Video.lastSample.Store(now.Add(-time.Second).UnixNano())
+
+	h = streamHealth(stream, now)
+	assert.True(h.Ready)
+	assert.False(h.Video.Stalled)
+	assert.NotNil(h.Video.LastSample)
+	assert.False(h.Audio.Stalled)
+
+	// the source stopped writing
+	h = streamHealth(stream, now.Add(StallTimeout))
+	assert.False(h.Ready)
+	assert.True(h.Video.Stalled)
+
+	nv := &Stream{
+		Name:      "nv",
+		Transport: TransportNV,
+		Video:     new(VideoTrack),
+		conn:      &stagedConnection{stage: "RTSP handshake"},
+	}
+
+	h = streamHealth(nv, now)
+	assert.False(h.Ready)
+	assert.Equal(nvstream.ConnectionStage("RTSP handshake"), h.Stage)
+	assert.False(h.Video.Listening)
+
+	nv.conn = &stagedConnection{stage: nvstream.ConnectionStageConnected}
+
+	h = streamHealth(nv, now)
+	assert.True(h.Ready)
+}
@@ -0,0 +1,245 @@
+package game
+
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type HotkeyAction string
+
+const (
+	HotkeyQuit        HotkeyAction = "quit"
+	HotkeySaveReplay  HotkeyAction = "replay"
+	HotkeyToggleStats HotkeyAction = "stats"
+)
+
+func ParseHotkeyAction(action string) (HotkeyAction, error) {
+	switch HotkeyAction(action) {
+	case HotkeyQuit, HotkeySaveReplay, HotkeyToggleStats:
+		return HotkeyAction(action), nil
+	default:
+		return "", errors.New("hotkey action not supported: " + action)
+	}
+}
+
+// Keyboard modifiers, matching moonlight's MODIFIER_* values.
+const (
+	ModifierShift uint8 = 0x01
+	ModifierCtrl  uint8 = 0x02
+	ModifierAlt   uint8 = 0x04
+	ModifierMeta  uint8 = 0x08
+)
+
+// Gamepad buttons, matching the XUSB_BUTTON bitmask sent on the gamepad channel.
+const (
+	ButtonDPadUp        uint16 = 0x0001
+	ButtonDPadDown      uint16 = 0x0002
+	ButtonDPadLeft      uint16 = 0x0004
+	ButtonDPadRight     uint16 = 0x0008
+	ButtonStart         uint16 = 0x0010
+	ButtonBack          uint16 = 0x0020
+	ButtonLeftThumb     uint16 = 0x0040
+	ButtonRightThumb    uint16 = 0x0080
+	ButtonLeftShoulder  uint16 = 0x0100
+	ButtonRightShoulder uint16 = 0x0200
+	ButtonGuide         uint16 = 0x0400
+	ButtonA             uint16 = 0x1000
+	ButtonB             uint16 = 0x2000
+	ButtonX             uint16 = 0x4000
+	ButtonY             uint16 = 0x8000
+)
+
+var gamepadButtons = map[string]uint16{
+	"up":     ButtonDPadUp,
+	"down":   ButtonDPadDown,
+	"left":   ButtonDPadLeft,
+	"right":  ButtonDPadRight,
+	"start":  ButtonStart,
+	"back":   ButtonBack,
+	"ls":     ButtonLeftThumb,
+	"rs":     ButtonRightThumb,
+	"lb":     ButtonLeftShoulder,
+	"rb":     ButtonRightShoulder,
+	"guide":  ButtonGuide,
+	"a":      ButtonA,
+	"b":      ButtonB,
+	"x":      ButtonX,
+	"y":      ButtonY,
+	"select": ButtonBack,
+}
+
+var keyModifiers = map[string]uint8{
+	"shift": ModifierShift,
+	"ctrl":  ModifierCtrl,
+	"alt":   ModifierAlt,
+	"meta":  ModifierMeta,
+	"win":   ModifierMeta,
+}
+
+// Hotkey is a key or button combination that is intercepted on the server
+// and mapped to an action instead of being forwarded to the game.
+type Hotkey struct {
+	Combo  string
+	Action HotkeyAction
+
+	// keyboard combo
+	KeyCode   uint16
+	Modifiers uint8
+
+	// gamepad combo
+	Buttons uint16
+}
+
+// ParseHotkey parses a combo of gamepad buttons such as "pad:back+start",
+// or a keyboard combo of modifiers and exactly one other key such as
+// "key:ctrl+shift+q".
+func ParseHotkey(combo string, action HotkeyAction) (*Hotkey, error) {
+	hotkey := &Hotkey{
+		Combo:  combo,
+		Action: action,
+	}
+
+	device, keys, _ := strings.Cut(strings.ToLower(combo), ":")
+	tokens := strings.Split(keys, "+")
+
+	switch device {
+	case "pad":
+		for _, token := range tokens {
+			button, ok := gamepadButtons[strings.TrimSpace(token)]
+			if !ok {
+				return nil, errors.New("unknown button: " + token)
+			}
+
+			hotkey.Buttons |= button
+		}
+
+		return hotkey, nil
+
+	case "key":
+		// parsed below
+
+	default:
+		return nil, errors.New("hotkey combo must start with pad: or key:, " + combo)
+	}
+
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+
+		if modifier, ok := keyModifiers[token]; ok {
+			hotkey.Modifiers |= modifier
+			continue
+		}
+
+		if hotkey.KeyCode != 0 {
+			return nil, errors.New("invalid hotkey: " + combo)
+		}
+
+		keyCode, err := parseKeyCode(token)
+		if err != nil {
+			return nil, err
+		}
+
+		hotkey.KeyCode = keyCode
+	}
+
+	if hotkey.KeyCode == 0 {
+		return nil, errors.New("invalid hotkey: " + combo)
+	}
+
+	return hotkey, nil
+}
+
+// parseKeyCode converts a key name to a Windows virtual-key code.
+func parseKeyCode(key string) (uint16, error) {
+	if len(key) == 1 {
+		c := key[0]
+		switch {
+		case c >= 'a' && c <= 'z':
+			return uint16(c-'a') + 0x41, nil
+		case c >= '0' && c <= '9':
+			return uint16(c-'0') + 0x30, nil
+		}
+	}
+
+	if len(key) >= 2 && key[0] == 'f' {
+		var n int
+		for _, c := range key[1:] {
+			if c < '0' || c > '9' {
+				n = 0
+				break
+			}
+
+			n = n*10 + int(c-'0')
+		}
+
+		if n >= 1 && n <= 24 {
+			return uint16(0x70 + n - 1), nil
+		}
+	}
+
+	switch key {
+	case "esc", "escape":
+		return 0x1B, nil
+	case "tab":
+		return 0x09, nil
+	case "enter":
+		return 0x0D, nil
+	case "space":
+		return 0x20, nil
+	case "backspace":
+		return 0x08, nil
+	case "delete":
+		return 0x2E, nil
+	default:
+		return 0, errors.New("unknown key: " + key)
+	}
+}
+
+func (hotkey *Hotkey) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Combo  string `yaml:"combo"`
+		Action string `yaml:"action"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	action, err := ParseHotkeyAction(raw.Action)
+	if err != nil {
+		return err
+	}
+
+	h, err := ParseHotkey(raw.Combo, action)
+	if err != nil {
+		return err
+	}
+
+	*hotkey = *h
+
+	return nil
+}
+
+func (hotkey *Hotkey) IsGamepad() bool {
+	return hotkey.Buttons != 0
+}
+
+// MatchKey reports whether a key-down event triggers the hotkey.
+func (hotkey *Hotkey) MatchKey(keyCode uint16, modifiers uint8) bool {
+	return !hotkey.IsGamepad() &&
+		hotkey.KeyCode == keyCode &&
+		hotkey.Modifiers == modifiers
+}
+
+// MatchButtons reports whether the combo became pressed between two
+// consecutive gamepad reports, so holding the combo fires only once.
+func (hotkey *Hotkey) MatchButtons(prev, current uint16) bool {
+	if !hotkey.IsGamepad() {
+		return false
+	}
+
+	return current&hotkey.Buttons == hotkey.Buttons &&
+		prev&hotkey.Buttons != hotkey.Buttons
+}
@@ -0,0 +1,76 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseHotkey(t *testing.T) {
+	assert := assert.New(t)
+
+	hotkey, err := ParseHotkey("key:ctrl+shift+q", HotkeyQuit)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.False(hotkey.IsGamepad())
+	assert.Equal(uint16(0x51), hotkey.KeyCode)
+	assert.Equal(ModifierCtrl|ModifierShift, hotkey.Modifiers)
+	assert.True(hotkey.MatchKey(0x51, ModifierCtrl|ModifierShift))
+	assert.False(hotkey.MatchKey(0x51, ModifierCtrl))
+
+	hotkey, err = ParseHotkey("pad:back+start", HotkeyToggleStats)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(hotkey.IsGamepad())
+	assert.Equal(ButtonBack|ButtonStart, hotkey.Buttons)
+	assert.True(hotkey.MatchButtons(ButtonBack, ButtonBack|ButtonStart|ButtonA))
+	assert.False(hotkey.MatchButtons(ButtonBack|ButtonStart, ButtonBack|ButtonStart))
+
+	hotkey, err = ParseHotkey("key:alt+f4", HotkeyQuit)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(uint16(0x73), hotkey.KeyCode)
+
+	_, err = ParseHotkey("key:ctrl+shift", HotkeyQuit)
+	assert.Error(err)
+
+	_, err = ParseHotkey("key:ctrl+a+b", HotkeyQuit)
+	assert.Error(err)
+
+	// the namespace tells the key a from the button a
+	hotkey, err = ParseHotkey("key:a", HotkeyQuit)
+	if assert.NoError(err) {
+		assert.False(hotkey.IsGamepad())
+		assert.Equal(uint16(0x41), hotkey.KeyCode)
+	}
+
+	hotkey, err = ParseHotkey("pad:a", HotkeyQuit)
+	if assert.NoError(err) {
+		assert.Equal(ButtonA, hotkey.Buttons)
+	}
+
+	_, err = ParseHotkey("back+start", HotkeyQuit)
+	assert.Error(err)
+
+	_, err = ParseHotkey("pad:back+q", HotkeyQuit)
+	assert.Error(err)
+}
+
+func TestStreamHotkeys(t *testing.T) {
+	assert := assert.New(t)
+
+	// hotkeys belong to the access list, not to a stream
+	var stream *Stream
+	err := yaml.Unmarshal([]byte("{name: game, hotkeys: [{combo: 'pad:back+start', action: stats}]}"), &stream)
+	assert.ErrorContains(err, "access.hotkeys")
+}
@@ -0,0 +1,229 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+var errCircuitOpen = errors.New("ice provider circuit open")
+
+// ICEBreaker configures the circuit breakers around the credential APIs of
+// the ICE providers. A request is retried with a doubling backoff; after
+// Failures failed fetches in a row the provider's circuit opens and it is
+// not asked again for Cooldown, when a single trial request may close it.
+// While a provider fails, its last credentials are served until they
+// expire.
+type ICEBreaker struct {
+	Timeout  time.Duration // of a request
+	Retries  int           // of a failed request; -1 for none
+	Backoff  time.Duration // before the first retry
+	Failures int
+	Cooldown time.Duration
+}
+
+func (cfg *ICEBreaker) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Timeout  time.Duration `yaml:"timeout"`
+		Retries  int           `yaml:"retries"`
+		Backoff  time.Duration `yaml:"backoff"`
+		Failures int           `yaml:"failures"`
+		Cooldown time.Duration `yaml:"cooldown"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Timeout == 0 {
+		raw.Timeout = defaultICEBreaker.Timeout
+	}
+
+	switch {
+	case raw.Retries == 0:
+		raw.Retries = defaultICEBreaker.Retries
+	case raw.Retries < 0:
+		raw.Retries = 0
+	}
+
+	if raw.Backoff == 0 {
+		raw.Backoff = defaultICEBreaker.Backoff
+	}
+
+	if raw.Failures == 0 {
+		raw.Failures = defaultICEBreaker.Failures
+	}
+
+	if raw.Cooldown == 0 {
+		raw.Cooldown = defaultICEBreaker.Cooldown
+	}
+
+	if raw.Timeout < 0 || raw.Backoff < 0 || raw.Failures < 0 || raw.Cooldown < 0 {
+		return errors.New("ice breaker settings must not be negative")
+	}
+
+	cfg.Timeout = raw.Timeout
+	cfg.Retries = raw.Retries
+	cfg.Backoff = raw.Backoff
+	cfg.Failures = raw.Failures
+	cfg.Cooldown = raw.Cooldown
+
+	return nil
+}
+
+var defaultICEBreaker = &ICEBreaker{
+	Timeout:  5 * time.Second,
+	Retries:  2,
+	Backoff:  250 * time.Millisecond,
+	Failures: 3,
+	Cooldown: 30 * time.Second,
+}
+
+// iceCircuit is the state of a provider's circuit.
+type iceCircuit struct {
+	failures  int
+	openUntil time.Time // zero while closed
+	trial     bool      // a trial request of the half-open circuit is under way
+	cached    *ICECredentials
+}
+
+type iceBreaker struct {
+	cfg      *ICEBreaker
+	log      *zap.Logger
+	fetch    func(ctx context.Context, cfg *ICEServer, timeout time.Duration) (*ICECredentials, error)
+	now      func() time.Time
+	sleep    func(d time.Duration)
+	circuits map[ICEProvider]*iceCircuit
+	sync.Mutex
+}
+
+func newICEBreaker(cfg *ICEBreaker, log *zap.Logger) *iceBreaker {
+	return &iceBreaker{
+		cfg:      cfg,
+		log:      log,
+		fetch:    fetchICEServers,
+		now:      time.Now,
+		sleep:    time.Sleep,
+		circuits: make(map[ICEProvider]*iceCircuit),
+	}
+}
+
+// Fetch returns the provider's ICE servers. While its circuit is open, or
+// when the request failed, it returns the last credentials the provider
+// issued that have not expired yet, if any.
+func (b *iceBreaker) Fetch(ctx context.Context, cfg *ICEServer) (*ICECredentials, error) {
+	if !b.allow(cfg.Provider) {
+		return b.cachedOr(cfg.Provider, errCircuitOpen)
+	}
+
+	creds, err := b.attempt(ctx, cfg)
+	b.record(cfg.Provider, creds, err)
+
+	if err != nil {
+		return b.cachedOr(cfg.Provider, err)
+	}
+
+	return creds, nil
+}
+
+func (b *iceBreaker) circuit(provider ICEProvider) *iceCircuit {
+	c, ok := b.circuits[provider]
+	if !ok {
+		c = new(iceCircuit)
+		b.circuits[provider] = c
+	}
+
+	return c
+}
+
+// allow reports whether the provider may be asked, letting a single trial
+// through once an open circuit cooled down.
+func (b *iceBreaker) allow(provider ICEProvider) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	c := b.circuit(provider)
+	switch {
+	case c.openUntil.IsZero():
+		return true
+	case b.now().Before(c.openUntil), c.trial:
+		return false
+	}
+
+	c.trial = true
+	return true
+}
+
+func (b *iceBreaker) attempt(ctx context.Context, cfg *ICEServer) (*ICECredentials, error) {
+	backoff := b.cfg.Backoff
+
+	for i := 0; ; i++ {
+		creds, err := b.fetch(ctx, cfg, b.cfg.Timeout)
+		if err == nil || i >= b.cfg.Retries || ctx.Err() != nil {
+			return creds, err
+		}
+
+		b.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (b *iceBreaker) record(provider ICEProvider, creds *ICECredentials, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	c := b.circuit(provider)
+	c.trial = false
+
+	log := b.log.With(zap.String("provider", provider.String()))
+
+	if err == nil {
+		if !c.openUntil.IsZero() {
+			log.Info("ice provider circuit closed")
+		}
+
+		c.failures = 0
+		c.openUntil = time.Time{}
+		c.cached = creds
+		return
+	}
+
+	c.failures++
+	if c.failures < b.cfg.Failures {
+		return
+	}
+
+	if c.openUntil.IsZero() {
+		log.Warn("ice provider circuit open",
+			zap.Int("failures", c.failures),
+			zap.Duration("cooldown", b.cfg.Cooldown),
+			zap.Error(err))
+	}
+
+	c.openUntil = b.now().Add(b.cfg.Cooldown)
+}
+
+func (b *iceBreaker) cachedOr(provider ICEProvider, err error) (*ICECredentials, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	cached := b.circuit(provider).cached
+	if cached == nil || (!cached.ExpiresAt.IsZero() && !b.now().Before(cached.ExpiresAt)) {
+		return nil, err
+	}
+
+	return cached, nil
+}
+
+// fetchICEServers fetches through the breaker of the service, if any.
+func (svc *service) fetchICEServers(ctx context.Context, cfg *ICEServer) (*ICECredentials, error) {
+	if svc.iceBreaker == nil {
+		return FetchICEServers(ctx, cfg)
+	}
+
+	return svc.iceBreaker.Fetch(ctx, cfg)
+}
@@ -0,0 +1,170 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestICEBreakerConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *ICEBreaker
+	err := yaml.Unmarshal([]byte("{}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(defaultICEBreaker, cfg)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("{retries: -1, failures: 5, cooldown: 1m}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(0, cfg.Retries)
+	assert.Equal(5, cfg.Failures)
+	assert.Equal(time.Minute, cfg.Cooldown)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("timeout: -1s"), &cfg)
+	assert.Error(err)
+}
+
+func TestICEBreaker(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1_700_000_000, 0)
+
+	var (
+		calls  int
+		sleeps []time.Duration
+		fail   bool
+	)
+
+	b := newICEBreaker(&ICEBreaker{
+		Timeout:  time.Second,
+		Retries:  2,
+		Backoff:  100 * time.Millisecond,
+		Failures: 2,
+		Cooldown: 30 * time.Second,
+	}, zap.NewNop())
+
+	b.now = func() time.Time { return now }
+	b.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	b.fetch = func(ctx context.Context, cfg *ICEServer, timeout time.Duration) (*ICECredentials, error) {
+		calls++
+		if fail {
+			return nil, errors.New("503")
+		}
+
+		return &ICECredentials{
+			Servers:   []webrtc.ICEServer{{URLs: []string{"turn:turn.example.com:3478"}}},
+			ExpiresAt: now.Add(10 * time.Second),
+		}, nil
+	}
+
+	cloudflare := &ICEServer{Provider: Cloudflare}
+
+	creds, err := b.Fetch(context.Background(), cloudflare)
+	assert.NoError(err)
+	assert.Len(creds.Servers, 1)
+	assert.Equal(1, calls)
+
+	// failed requests are retried with a doubling backoff, then the cached
+	// credentials are served
+	fail = true
+	calls = 0
+
+	cached, err := b.Fetch(context.Background(), cloudflare)
+	assert.NoError(err)
+	assert.Equal(creds, cached)
+	assert.Equal(3, calls)
+	assert.Equal([]time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, sleeps)
+
+	// the second failed fetch opens the circuit
+	_, err = b.Fetch(context.Background(), cloudflare)
+	assert.NoError(err)
+	assert.Equal(6, calls)
+
+	// an open circuit is not asked
+	_, err = b.Fetch(context.Background(), cloudflare)
+	assert.NoError(err)
+	assert.Equal(6, calls)
+
+	// expired credentials are not served
+	now = now.Add(15 * time.Second)
+	_, err = b.Fetch(context.Background(), cloudflare)
+	assert.ErrorIs(err, errCircuitOpen)
+	assert.Equal(6, calls)
+
+	// after the cooldown a trial closes the circuit
+	fail = false
+	now = now.Add(30 * time.Second)
+
+	_, err = b.Fetch(context.Background(), cloudflare)
+	assert.NoError(err)
+	assert.Equal(7, calls)
+
+	_, err = b.Fetch(context.Background(), cloudflare)
+	assert.NoError(err)
+	assert.Equal(8, calls)
+
+	// providers have circuits of their own
+	fail = true
+	_, err = b.Fetch(context.Background(), &ICEServer{Provider: Metered})
+	assert.EqualError(err, "503")
+}
+
+func TestICEBreakerCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int
+
+	b := newICEBreaker(defaultICEBreaker, zap.NewNop())
+	b.sleep = func(d time.Duration) {}
+	b.fetch = func(ctx context.Context, cfg *ICEServer, timeout time.Duration) (*ICECredentials, error) {
+		calls++
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// a cancelled request is not retried
+	_, err := b.Fetch(ctx, &ICEServer{Provider: Cloudflare})
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(1, calls)
+}
+
+func TestICEServersGoogleFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	providers := []*ICEServer{
+		{Provider: Cloudflare},
+		{Provider: Metered},
+	}
+
+	svc := &service{
+		log:        zap.NewNop(),
+		cfg:        &Config{WebRTC: WebRTC{ICEServers: providers}},
+		iceHealth:  newICEHealth(providers, nil, zap.NewNop()),
+		iceBreaker: newICEBreaker(&ICEBreaker{Failures: 1, Cooldown: time.Minute}, zap.NewNop()),
+	}
+
+	svc.iceBreaker.fetch = func(ctx context.Context, cfg *ICEServer, timeout time.Duration) (*ICECredentials, error) {
+		return nil, errors.New("unreachable")
+	}
+
+	creds, err := svc.ICEServers(context.Background(), Cloudflare)
+	if assert.NoError(err) && assert.Len(creds.Servers, 1) {
+		assert.Contains(creds.Servers[0].URLs, "stun:stun.l.google.com:19302")
+	}
+
+	// unless the policy rules Google out
+	svc.clientICE = &ICEPolicy{Providers: []ICEProvider{Cloudflare, Metered}, Candidates: ICECandidatesAll}
+
+	_, err = svc.ICEServers(context.Background(), Cloudflare)
+	assert.Error(err)
+}
@@ -0,0 +1,326 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v3"
+	"github.com/pion/turn/v4"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ICEHealthCheck configures the periodic checks of the ICE providers. A
+// provider is healthy while its credential API answers and a TURN
+// allocation succeeds, or, for STUN-only providers, a binding succeeds.
+type ICEHealthCheck struct {
+	Disabled bool
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+func (cfg *ICEHealthCheck) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Disabled bool          `yaml:"disabled"`
+		Interval time.Duration `yaml:"interval"`
+		Timeout  time.Duration `yaml:"timeout"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Interval == 0 {
+		raw.Interval = 5 * time.Minute
+	}
+
+	if raw.Timeout == 0 {
+		raw.Timeout = 5 * time.Second
+	}
+
+	cfg.Disabled = raw.Disabled
+	cfg.Interval = raw.Interval
+	cfg.Timeout = raw.Timeout
+
+	return nil
+}
+
+var defaultICEHealthCheck = &ICEHealthCheck{
+	Interval: 5 * time.Minute,
+	Timeout:  5 * time.Second,
+}
+
+type ICEProviderHealth struct {
+	Provider  string     `json:"provider"`
+	Healthy   bool       `json:"healthy"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// iceHealth tracks the health of the configured ICE providers. Providers
+// not checked yet count as healthy.
+type iceHealth struct {
+	log       *zap.Logger
+	providers []*ICEServer
+	check     func(ctx context.Context, cfg *ICEServer) error
+	status    map[ICEProvider]*ICEProviderHealth
+	sync.RWMutex
+}
+
+func newICEHealth(providers []*ICEServer, check func(ctx context.Context, cfg *ICEServer) error, log *zap.Logger) *iceHealth {
+	status := make(map[ICEProvider]*ICEProviderHealth)
+	for _, cfg := range providers {
+		status[cfg.Provider] = &ICEProviderHealth{
+			Provider: cfg.Provider.String(),
+			Healthy:  true,
+		}
+	}
+
+	return &iceHealth{
+		log:       log,
+		providers: providers,
+		check:     check,
+		status:    status,
+	}
+}
+
+// Run checks the providers every interval until ctx is done.
+func (h *iceHealth) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.CheckAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *iceHealth) CheckAll(ctx context.Context) {
+	for _, cfg := range h.providers {
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.Report(cfg.Provider, h.check(ctx, cfg))
+	}
+}
+
+// Report records the outcome of a check, or of a failed request for
+// credentials.
+func (h *iceHealth) Report(provider ICEProvider, err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	s, ok := h.status[provider]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	healthy := err == nil
+
+	log := h.log.With(zap.String("provider", provider.String()))
+	switch {
+	case !healthy && s.Healthy:
+		log.Warn("ice provider failing", zap.Error(err))
+	case healthy && !s.Healthy:
+		log.Info("ice provider recovered")
+	}
+
+	s.Healthy = healthy
+	s.CheckedAt = &now
+	s.Error = ""
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+func (h *iceHealth) Healthy(provider ICEProvider) bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	s, ok := h.status[provider]
+	return ok && s.Healthy
+}
+
+// Order returns the providers in configured order, failing ones last.
+func (h *iceHealth) Order() []*ICEServer {
+	providers := slices.Clone(h.providers)
+
+	slices.SortStableFunc(providers, func(a, b *ICEServer) int {
+		healthyA, healthyB := h.Healthy(a.Provider), h.Healthy(b.Provider)
+		switch {
+		case healthyA == healthyB:
+			return 0
+		case healthyA:
+			return -1
+		default:
+			return 1
+		}
+	})
+
+	return providers
+}
+
+func (h *iceHealth) Statuses() []ICEProviderHealth {
+	h.RLock()
+	defer h.RUnlock()
+
+	statuses := make([]ICEProviderHealth, 0, len(h.providers))
+	for _, cfg := range h.providers {
+		statuses = append(statuses, *h.status[cfg.Provider])
+	}
+
+	return statuses
+}
+
+// checkICEProvider requests credentials from the provider and tries its
+// servers: TURN servers must allocate a relay, or accept a connection over
+// TCP and TLS; STUN servers must answer a binding.
+func checkICEProvider(ctx context.Context, cfg *ICEServer, timeout time.Duration) error {
+	creds, err := FetchICEServers(ctx, cfg)
+	if err != nil {
+		return errors.New("credentials: " + err.Error())
+	}
+
+	err = errors.New("no ice servers")
+	for _, server := range creds.Servers {
+		username := server.Username
+		password, _ := server.Credential.(string)
+
+		for _, raw := range server.URLs {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			uri, perr := stun.ParseURI(raw)
+			if perr != nil {
+				err = perr
+				continue
+			}
+
+			if err = probeICEServer(uri, username, password, timeout); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+func probeICEServer(uri *stun.URI, username, password string, timeout time.Duration) error {
+	hostport := net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port))
+
+	switch {
+	case uri.Scheme == stun.SchemeTypeTURN && uri.Proto != stun.ProtoTypeTCP:
+		return allocateTURN(hostport, username, password, timeout)
+
+	case uri.Scheme == stun.SchemeTypeSTUN:
+		conn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		addr, err := net.ResolveUDPAddr("udp4", hostport)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = stunBinding(conn, addr, timeout)
+		return err
+
+	default:
+		return probeTURN(nil, uri, timeout)
+	}
+}
+
+// allocateTURN allocates a relay on a TURN server and releases it.
+func allocateTURN(hostport, username, password string, timeout time.Duration) error {
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: hostport,
+		TURNServerAddr: hostport,
+		Username:       username,
+		Password:       password,
+		Conn:           conn,
+	})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Listen(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		relay, err := client.Allocate()
+		if err == nil {
+			relay.Close()
+		}
+
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errors.New("turn allocation timed out")
+	}
+}
+
+// anyICEServers returns the servers of all providers the policy allows,
+// healthy ones first. Failing providers are skipped while a healthy one
+// answered.
+func (svc *service) anyICEServers(ctx context.Context, policy *ICEPolicy, exclude ICEProvider) (*ICECredentials, error) {
+	all := new(ICECredentials)
+	var errs []error
+
+	for _, cfg := range svc.iceHealth.Order() {
+		if cfg.Provider == exclude || !policy.Allows(cfg.Provider) {
+			continue
+		}
+
+		if len(all.Servers) > 0 && !svc.iceHealth.Healthy(cfg.Provider) {
+			break
+		}
+
+		creds, err := svc.fetchICEServers(ctx, cfg)
+		if err != nil {
+			svc.iceHealth.Report(cfg.Provider, err)
+			errs = append(errs, errors.New(cfg.Provider.String()+": "+err.Error()))
+			continue
+		}
+
+		all.Servers = append(all.Servers, creds.Servers...)
+		all.expire(creds.ExpiresAt)
+	}
+
+	if len(all.Servers) == 0 {
+		if len(errs) == 0 {
+			return nil, errors.New("provider not supported")
+		}
+
+		return nil, errors.Join(errs...)
+	}
+
+	return all, nil
+}
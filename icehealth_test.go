@@ -0,0 +1,129 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/stun/v3"
+	"github.com/pion/turn/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestICEHealth(t *testing.T) {
+	assert := assert.New(t)
+
+	providers := []*ICEServer{
+		{Provider: Cloudflare},
+		{Provider: Metered},
+		{Provider: Google},
+	}
+
+	h := newICEHealth(providers, func(ctx context.Context, cfg *ICEServer) error {
+		if cfg.Provider == Cloudflare {
+			return errors.New("credentials: 503")
+		}
+
+		return nil
+	}, zap.NewNop())
+
+	// Unchecked providers count as healthy.
+	assert.True(h.Healthy(Cloudflare))
+	assert.False(h.Healthy(AnyProvider))
+
+	h.CheckAll(context.Background())
+
+	assert.False(h.Healthy(Cloudflare))
+	assert.Equal([]*ICEServer{providers[1], providers[2], providers[0]}, h.Order())
+
+	statuses := h.Statuses()
+	if assert.Len(statuses, 3) {
+		assert.Equal("cloudflare", statuses[0].Provider)
+		assert.Equal("credentials: 503", statuses[0].Error)
+		assert.NotNil(statuses[0].CheckedAt)
+		assert.True(statuses[1].Healthy)
+	}
+
+	h.Report(Cloudflare, nil)
+	assert.Equal(providers, h.Order())
+}
+
+func TestICEServersFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	providers := []*ICEServer{
+		{Provider: Cloudflare},
+		{Provider: Google},
+	}
+
+	svc := &service{
+		log:       zap.NewNop(),
+		cfg:       &Config{WebRTC: WebRTC{ICEServers: providers}},
+		iceHealth: newICEHealth(providers, nil, zap.NewNop()),
+	}
+
+	svc.iceHealth.Report(Cloudflare, errors.New("unreachable"))
+
+	// The failing provider is skipped without asking it for credentials.
+	creds, err := svc.ICEServers(context.Background(), Cloudflare)
+	if assert.NoError(err) && assert.Len(creds.Servers, 1) {
+		assert.Contains(creds.Servers[0].URLs, "stun:stun.l.google.com:19302")
+	}
+
+	creds, err = svc.ICEServers(context.Background(), AnyProvider)
+	if assert.NoError(err) {
+		assert.Len(creds.Servers, 1)
+	}
+
+	_, err = svc.ICEServers(context.Background(), Metered)
+	assert.EqualError(err, "provider not supported")
+}
+
+func TestProbeICEServer(t *testing.T) {
+	assert := assert.New(t)
+
+	uri, err := stun.ParseURI(serveSTUN(t))
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.NoError(probeICEServer(uri, "", "", time.Second))
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const realm = "flarex.io"
+
+	srv, err := turn.NewServer(turn.ServerConfig{
+		Realm: realm,
+		AuthHandler: func(username, realm string, addr net.Addr) ([]byte, bool) {
+			return turn.GenerateAuthKey("user", realm, "pass"), username == "user"
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn: conn,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+					RelayAddress: net.IPv4(127, 0, 0, 1),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	uri, err = stun.ParseURI("turn:" + conn.LocalAddr().String())
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.NoError(probeICEServer(uri, "user", "pass", time.Second))
+	assert.Error(probeICEServer(uri, "user", "wrong", time.Second))
+}
@@ -0,0 +1,85 @@
+package game
+
+import (
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+)
+
+const ControlConnectionPath ControlMessageType = "connection.path"
+
+// ConnectionPath is the payload of connection.path: the candidate pair ICE
+// selected. A switch mid-session, e.g. from direct to relayed, explains a
+// sudden change in latency.
+type ConnectionPath struct {
+	Local    string `json:"local"`  // candidate type: host, srflx, prflx or relay
+	Remote   string `json:"remote"` // candidate type of the client
+	Protocol string `json:"protocol"`
+	Relayed  bool   `json:"relayed"`
+	Switched bool   `json:"switched"` // a pair was selected before
+}
+
+func newConnectionPath(pair *webrtc.ICECandidatePair) ConnectionPath {
+	local := pair.Local.Typ
+	remote := pair.Remote.Typ
+
+	return ConnectionPath{
+		Local:    local.String(),
+		Remote:   remote.String(),
+		Protocol: pair.Local.Protocol.String(),
+		Relayed:  local == webrtc.ICECandidateTypeRelay || remote == webrtc.ICECandidateTypeRelay,
+	}
+}
+
+// watchPath logs each candidate pair ICE selects and tells the client.
+func (peer *Peer) watchPath() {
+	sctp := peer.SCTP()
+	if sctp == nil {
+		return
+	}
+
+	sctp.Transport().ICETransport().OnSelectedCandidatePairChange(func(pair *webrtc.ICECandidatePair) {
+		path := newConnectionPath(pair)
+
+		peer.Lock()
+		if peer.path != nil {
+			path.Switched = true
+		}
+		peer.path = &path
+		peer.Unlock()
+
+		log := peer.log.With(
+			zap.String("local", pair.Local.String()),
+			zap.String("remote", pair.Remote.String()),
+			zap.Bool("relayed", path.Relayed),
+		)
+
+		if path.Switched {
+			log.Warn("candidate pair switched")
+		} else {
+			log.Info("candidate pair selected")
+		}
+
+		peer.sendPath(path)
+	})
+}
+
+func (peer *Peer) sendPath(path ConnectionPath) {
+	msg, err := NewControlMessage(ControlConnectionPath, &path)
+	if err != nil {
+		return
+	}
+
+	peer.SendControl(msg)
+}
+
+// sendLastPath sends the selected pair, usually chosen before the client
+// opened its control channel.
+func (peer *Peer) sendLastPath() {
+	peer.RLock()
+	path := peer.path
+	peer.RUnlock()
+
+	if path != nil {
+		peer.sendPath(*path)
+	}
+}
@@ -0,0 +1,115 @@
+package game
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ICECandidates are the candidates a side of the connections gathers.
+type ICECandidates string
+
+const (
+	ICECandidatesAll   ICECandidates = "all"
+	ICECandidatesRelay ICECandidates = "relay" // TURN relays only
+	ICECandidatesHost  ICECandidates = "host"  // no ICE servers, host candidates only
+)
+
+// ICEPolicy selects the ICE servers of one side of the connections: the
+// server's peers, or the clients asking the iceservers endpoint. Providers
+// are the allowed ones, in order of preference; all are allowed when none
+// are listed.
+type ICEPolicy struct {
+	Providers  []ICEProvider
+	Candidates ICECandidates
+}
+
+func (policy *ICEPolicy) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Providers  []ICEProvider `yaml:"providers"`
+		Candidates ICECandidates `yaml:"candidates"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	switch raw.Candidates {
+	case "":
+		raw.Candidates = ICECandidatesAll
+	case ICECandidatesAll, ICECandidatesRelay, ICECandidatesHost:
+	default:
+		return errors.New("ice candidates unsupported: " + string(raw.Candidates))
+	}
+
+	policy.Providers = raw.Providers
+	policy.Candidates = raw.Candidates
+
+	return nil
+}
+
+// The server prefers Google's STUN servers, failing over to the other
+// providers; clients may ask for any provider.
+var (
+	defaultServerICEPolicy = &ICEPolicy{Candidates: ICECandidatesAll}
+	defaultClientICEPolicy = &ICEPolicy{Candidates: ICECandidatesAll}
+)
+
+func (policy *ICEPolicy) Allows(provider ICEProvider) bool {
+	return len(policy.Providers) == 0 || slices.Contains(policy.Providers, provider)
+}
+
+// Preferred returns the provider the server asks first.
+func (policy *ICEPolicy) Preferred() ICEProvider {
+	if len(policy.Providers) == 0 {
+		return Google
+	}
+
+	return policy.Providers[0]
+}
+
+// validate checks the policy only lists configured providers.
+func (policy *ICEPolicy) validate(servers []*ICEServer) error {
+	for _, provider := range policy.Providers {
+		if !slices.ContainsFunc(servers, func(s *ICEServer) bool { return s.Provider == provider }) {
+			return errors.New("ice provider not configured: " + provider.String())
+		}
+	}
+
+	return nil
+}
+
+// apply filters the servers by the policy's candidates.
+func (policy *ICEPolicy) apply(creds *ICECredentials) (*ICECredentials, error) {
+	switch policy.Candidates {
+	case ICECandidatesHost:
+		return &ICECredentials{}, nil
+
+	case ICECandidatesRelay:
+		relays := &ICECredentials{
+			ExpiresAt: creds.ExpiresAt,
+			RelayOnly: true,
+		}
+
+		for _, server := range creds.Servers {
+			server.URLs = slices.DeleteFunc(slices.Clone(server.URLs), func(url string) bool {
+				return !strings.HasPrefix(url, "turn:") && !strings.HasPrefix(url, "turns:")
+			})
+
+			if len(server.URLs) > 0 {
+				relays.Servers = append(relays.Servers, server)
+			}
+		}
+
+		if len(relays.Servers) == 0 {
+			return nil, errors.New("no turn servers")
+		}
+
+		return relays, nil
+
+	default:
+		return creds, nil
+	}
+}
@@ -0,0 +1,122 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestICEPolicyConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg WebRTC
+	err := yaml.Unmarshal([]byte(`
+iceServers:
+  - provider: google
+  - provider: cloudflare
+server:
+  candidates: host
+client:
+  providers: [ cloudflare ]
+  candidates: relay
+`), &cfg)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(ICECandidatesHost, cfg.Server.Candidates)
+	assert.Empty(cfg.Server.Providers)
+	assert.Equal([]ICEProvider{Cloudflare}, cfg.Client.Providers)
+	assert.Equal(ICECandidatesRelay, cfg.Client.Candidates)
+	assert.NoError(cfg.Client.validate(cfg.ICEServers))
+
+	policy := &ICEPolicy{Providers: []ICEProvider{Metered}}
+	assert.EqualError(policy.validate(cfg.ICEServers), "ice provider not configured: metered")
+
+	err = yaml.Unmarshal([]byte("candidates: srflx"), &policy)
+	assert.ErrorContains(err, "ice candidates unsupported")
+}
+
+func TestICEPolicyApply(t *testing.T) {
+	assert := assert.New(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	creds := &ICECredentials{
+		Servers: []webrtc.ICEServer{
+			{URLs: []string{"stun:stun.example.com:3478"}},
+			{
+				URLs: []string{
+					"stun:turn.example.com:3478",
+					"turn:turn.example.com:3478?transport=udp",
+					"turns:turn.example.com:5349?transport=tcp",
+				},
+				Username:   "user",
+				Credential: "pass",
+			},
+		},
+		ExpiresAt: expiresAt,
+	}
+
+	relays, err := (&ICEPolicy{Candidates: ICECandidatesRelay}).apply(creds)
+	if assert.NoError(err) && assert.Len(relays.Servers, 1) {
+		assert.Len(relays.Servers[0].URLs, 2)
+		assert.Equal("user", relays.Servers[0].Username)
+		assert.True(relays.RelayOnly)
+		assert.Equal(expiresAt, relays.ExpiresAt)
+	}
+
+	// the servers are left as they were
+	assert.Len(creds.Servers[1].URLs, 3)
+
+	host, err := (&ICEPolicy{Candidates: ICECandidatesHost}).apply(creds)
+	if assert.NoError(err) {
+		assert.Empty(host.Servers)
+	}
+
+	all, err := (&ICEPolicy{Candidates: ICECandidatesAll}).apply(creds)
+	if assert.NoError(err) {
+		assert.Equal(creds, all)
+	}
+
+	_, err = (&ICEPolicy{Candidates: ICECandidatesRelay}).apply(&ICECredentials{
+		Servers: creds.Servers[:1],
+	})
+	assert.EqualError(err, "no turn servers")
+}
+
+func TestICEServersPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	providers := []*ICEServer{
+		{Provider: Cloudflare},
+		{Provider: Google},
+	}
+
+	svc := &service{
+		log:       zap.NewNop(),
+		cfg:       &Config{WebRTC: WebRTC{ICEServers: providers}},
+		iceHealth: newICEHealth(providers, nil, zap.NewNop()),
+		serverICE: &ICEPolicy{Candidates: ICECandidatesHost},
+		clientICE: &ICEPolicy{Providers: []ICEProvider{Google}, Candidates: ICECandidatesAll},
+	}
+
+	_, err := svc.ICEServers(context.Background(), Cloudflare)
+	assert.EqualError(err, "provider not allowed: cloudflare")
+
+	// Cloudflare is not asked for credentials.
+	creds, err := svc.ICEServers(context.Background(), AnyProvider)
+	if assert.NoError(err) && assert.Len(creds.Servers, 1) {
+		assert.Contains(creds.Servers[0].URLs, "stun:stun.l.google.com:19302")
+	}
+
+	creds, err = svc.policyICEServers(context.Background(), svc.serverICE, svc.serverICE.Preferred())
+	if assert.NoError(err) {
+		assert.Empty(creds.Servers)
+	}
+}
@@ -0,0 +1,294 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/flarexio/game/session"
+)
+
+// ICERestart configures how the server recovers peers whose network
+// dropped. When ICE stays disconnected or failed for After, the server
+// offers an ICE restart to the client, up to Attempts times, before closing
+// the peer.
+type ICERestart struct {
+	Disabled bool
+	After    time.Duration
+	Attempts int
+	Timeout  time.Duration // for the client to answer
+}
+
+func (cfg *ICERestart) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Disabled bool          `yaml:"disabled"`
+		After    time.Duration `yaml:"after"`
+		Attempts int           `yaml:"attempts"`
+		Timeout  time.Duration `yaml:"timeout"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.After == 0 {
+		raw.After = 5 * time.Second
+	}
+
+	if raw.Attempts == 0 {
+		raw.Attempts = 3
+	}
+
+	if raw.Timeout == 0 {
+		raw.Timeout = 10 * time.Second
+	}
+
+	cfg.Disabled = raw.Disabled
+	cfg.After = raw.After
+	cfg.Attempts = raw.Attempts
+	cfg.Timeout = raw.Timeout
+
+	return nil
+}
+
+var defaultICERestart = &ICERestart{
+	After:    5 * time.Second,
+	Attempts: 3,
+	Timeout:  10 * time.Second,
+}
+
+// iceRestarter watches the ICE state of a peer and restarts ICE while the
+// connection is down. It gives up after the configured attempts.
+type iceRestarter struct {
+	log     *zap.Logger
+	cfg     *ICERestart
+	restart func() error
+	giveUp  func()
+
+	attempts   int
+	timer      *time.Timer
+	restarting bool
+	closed     bool
+	sync.Mutex
+}
+
+func newICERestarter(cfg *ICERestart, restart func() error, giveUp func(), log *zap.Logger) *iceRestarter {
+	return &iceRestarter{
+		log:     log,
+		cfg:     cfg,
+		restart: restart,
+		giveUp:  giveUp,
+	}
+}
+
+// Update arms the restart when the connection goes down and disarms it
+// once the connection is back.
+func (r *iceRestarter) Update(state webrtc.ICEConnectionState) {
+	r.Lock()
+	defer r.Unlock()
+
+	switch state {
+	case webrtc.ICEConnectionStateConnected,
+		webrtc.ICEConnectionStateCompleted:
+		r.stop()
+		r.attempts = 0
+
+	case webrtc.ICEConnectionStateDisconnected,
+		webrtc.ICEConnectionStateFailed:
+		r.arm()
+
+	case webrtc.ICEConnectionStateClosed:
+		r.stop()
+	}
+}
+
+func (r *iceRestarter) arm() {
+	if r.closed || r.timer != nil {
+		return
+	}
+
+	r.timer = time.AfterFunc(r.cfg.After, r.fire)
+}
+
+func (r *iceRestarter) stop() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+func (r *iceRestarter) fire() {
+	r.Lock()
+	r.timer = nil
+
+	if r.closed {
+		r.Unlock()
+		return
+	}
+
+	if r.attempts >= r.cfg.Attempts {
+		r.Unlock()
+
+		r.log.Warn("ice restart attempts exhausted",
+			zap.Int("attempts", r.cfg.Attempts))
+
+		r.giveUp()
+		return
+	}
+
+	if r.restarting {
+		// The restart under way arms the next one.
+		r.Unlock()
+		return
+	}
+
+	r.attempts++
+	attempt := r.attempts
+	r.restarting = true
+	r.Unlock()
+
+	r.log.Info("restarting ice", zap.Int("attempt", attempt))
+
+	if err := r.restart(); err != nil {
+		r.log.Warn("ice restart failed",
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+
+		if errors.Is(err, session.ErrStuck) {
+			r.stuck()
+			return
+		}
+	}
+
+	// Try again unless the restart brought the connection back.
+	r.Lock()
+	r.restarting = false
+	if r.attempts == attempt {
+		r.arm()
+	}
+	r.Unlock()
+}
+
+var errICERestarting = NewControlError(ControlErrUnavailable, "ice restart in progress")
+
+// Restart restarts ICE now, as asked by the client after its network
+// changed, rather than once the connection is seen down. It counts toward
+// no attempts.
+func (r *iceRestarter) Restart() error {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return errors.New("peer closed")
+	}
+
+	if r.restarting {
+		r.Unlock()
+		return errICERestarting
+	}
+
+	pending := r.timer != nil || r.attempts > 0
+
+	r.restarting = true
+	r.stop()
+	r.Unlock()
+
+	r.log.Info("restarting ice", zap.String("trigger", "client"))
+
+	err := r.restart()
+	if errors.Is(err, session.ErrStuck) {
+		r.stuck()
+		return err
+	}
+
+	r.Lock()
+	r.restarting = false
+
+	// The connection was down already; keep trying if this failed.
+	if err != nil && pending {
+		r.arm()
+	}
+	r.Unlock()
+
+	return err
+}
+
+// stuck gives up on a connection a failed restart left unable to negotiate
+// again, as no further restart can succeed.
+func (r *iceRestarter) stuck() {
+	r.Lock()
+	r.restarting = false
+	r.closed = true
+	r.stop()
+	r.Unlock()
+
+	r.log.Warn("ice restart left the connection stuck, giving up")
+
+	// Restarts the client asks for come from the handlers of the
+	// connection giving up closes.
+	go r.giveUp()
+}
+
+// Close disarms the restarter for good.
+func (r *iceRestarter) Close() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.closed = true
+	r.stop()
+}
+
+// RestartICE offers the client an ICE restart on its inbox, keeping the
+// connection's media and data channels.
+func (peer *Peer) RestartICE() error {
+	if peer.restarter == nil {
+		return NewControlError(ControlErrUnsupported, "ice restarts disabled")
+	}
+
+	return peer.restarter.Restart()
+}
+
+// restartICE offers an ICE restart to the client through signal and
+// applies its answer, unless the peer closes first.
+func (peer *Peer) restartICE(signal session.Signal, timeout time.Duration) error {
+	return peer.signaling.Offer(peer.ctx, &webrtc.OfferOptions{ICERestart: true}, timeout, signal)
+}
+
+// restartSignal sends restart offers to the client on the reply subject
+// of its negotiation, suffixed with .sdp.restart, and waits for the answer.
+func restartSignal(nc *nats.Conn, reply string, timeout time.Duration) session.Signal {
+	return offerSignal(nc, reply+".sdp.restart", timeout)
+}
+
+// offerSignal sends offers to the client on subject and waits for the
+// answer.
+func offerSignal(nc *nats.Conn, subject string, timeout time.Duration) session.Signal {
+	return func(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		var answer webrtc.SessionDescription
+
+		bs, err := json.Marshal(&offer)
+		if err != nil {
+			return answer, err
+		}
+
+		msg, err := nc.Request(subject, bs, timeout)
+		if err != nil {
+			return answer, err
+		}
+
+		if err := json.Unmarshal(msg.Data, &answer); err != nil {
+			return answer, err
+		}
+
+		if answer.Type != webrtc.SDPTypeAnswer {
+			return answer, errors.New("unexpected sdp type: " + answer.Type.String())
+		}
+
+		return answer, nil
+	}
+}
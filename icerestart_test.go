@@ -0,0 +1,161 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/session"
+)
+
+func TestICERestarter(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &ICERestart{
+		After:    10 * time.Millisecond,
+		Attempts: 2,
+	}
+
+	var restarts atomic.Int32
+	gaveUp := make(chan struct{})
+
+	r := newICERestarter(cfg,
+		func() error {
+			restarts.Add(1)
+			return errors.New("no answer")
+		},
+		func() { close(gaveUp) },
+		zap.NewNop(),
+	)
+	defer r.Close()
+
+	r.Update(webrtc.ICEConnectionStateDisconnected)
+
+	select {
+	case <-gaveUp:
+	case <-time.After(time.Second):
+		assert.Fail("restarter did not give up")
+	}
+
+	assert.Equal(int32(2), restarts.Load())
+}
+
+func TestICERestarterRecovered(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &ICERestart{
+		After:    20 * time.Millisecond,
+		Attempts: 1,
+	}
+
+	var restarts atomic.Int32
+	var gaveUp atomic.Bool
+
+	r := newICERestarter(cfg,
+		func() error {
+			restarts.Add(1)
+			return nil
+		},
+		func() { gaveUp.Store(true) },
+		zap.NewNop(),
+	)
+	defer r.Close()
+
+	// Recovering within the window needs no restart.
+	r.Update(webrtc.ICEConnectionStateDisconnected)
+	r.Update(webrtc.ICEConnectionStateConnected)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(int32(0), restarts.Load())
+
+	// A restart that brings the connection back resets the attempts.
+	r.Update(webrtc.ICEConnectionStateFailed)
+	time.Sleep(30 * time.Millisecond)
+	r.Update(webrtc.ICEConnectionStateConnected)
+
+	r.Update(webrtc.ICEConnectionStateDisconnected)
+	time.Sleep(30 * time.Millisecond)
+	r.Update(webrtc.ICEConnectionStateConnected)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(int32(2), restarts.Load())
+	assert.False(gaveUp.Load())
+}
+
+func TestICERestarterRestart(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &ICERestart{
+		After:    time.Hour,
+		Attempts: 1,
+	}
+
+	release := make(chan struct{})
+	var restarts atomic.Int32
+
+	r := newICERestarter(cfg,
+		func() error {
+			restarts.Add(1)
+			<-release
+			return nil
+		},
+		func() {},
+		zap.NewNop(),
+	)
+
+	done := make(chan error)
+	go func() { done <- r.Restart() }()
+
+	assert.Eventually(func() bool { return restarts.Load() == 1 }, time.Second, time.Millisecond)
+
+	// one restart at a time
+	assert.ErrorIs(r.Restart(), errICERestarting)
+
+	close(release)
+	assert.NoError(<-done)
+
+	r.Close()
+	assert.Error(r.Restart())
+	assert.Equal(int32(1), restarts.Load())
+}
+
+func TestICERestarterStuck(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &ICERestart{
+		After:    10 * time.Millisecond,
+		Attempts: 3,
+	}
+
+	var restarts atomic.Int32
+	gaveUp := make(chan struct{})
+
+	r := newICERestarter(cfg,
+		func() error {
+			restarts.Add(1)
+			return fmt.Errorf("%w: bad answer", session.ErrStuck)
+		},
+		func() { close(gaveUp) },
+		zap.NewNop(),
+	)
+	defer r.Close()
+
+	// a restart that leaves the connection stuck ends the attempts
+	r.Update(webrtc.ICEConnectionStateFailed)
+
+	select {
+	case <-gaveUp:
+	case <-time.After(time.Second):
+		assert.Fail("restarter did not give up")
+	}
+
+	assert.Equal(int32(1), restarts.Load())
+	assert.Error(r.Restart())
+	assert.Equal(int32(1), restarts.Load())
+}
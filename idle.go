@@ -0,0 +1,291 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+// Idle frees the resources of a stream nobody uses: after NoPeers without a
+// peer, or NoInput without input while peers only watch, an NVStream stream
+// quits its app and a raw stream closes its sockets, so the encoder feeding
+// it can stop. The next peer wakes the stream.
+type Idle struct {
+	NoPeers time.Duration // 0 never
+	NoInput time.Duration // 0 never
+	Exempt  []IdleExemption
+}
+
+// IdleExemption keeps a stream awake while it has a use besides its peers.
+type IdleExemption string
+
+const (
+	IdleExemptRecording IdleExemption = "recording"
+	IdleExemptRepublish IdleExemption = "republish"
+)
+
+func (cfg *Idle) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		NoPeers time.Duration   `yaml:"noPeers"`
+		NoInput time.Duration   `yaml:"noInput"`
+		Exempt  []IdleExemption `yaml:"exempt"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.NoPeers < 0 || raw.NoInput < 0 {
+		return errors.New("invalid idle timeout")
+	}
+
+	for _, exemption := range raw.Exempt {
+		switch exemption {
+		case IdleExemptRecording, IdleExemptRepublish:
+		default:
+			return errors.New("invalid idle exemption: " + string(exemption))
+		}
+	}
+
+	cfg.NoPeers = raw.NoPeers
+	cfg.NoInput = raw.NoInput
+	cfg.Exempt = raw.Exempt
+
+	return nil
+}
+
+const (
+	IdleReasonNoPeers = "no_peers"
+	IdleReasonNoInput = "no_input"
+)
+
+// idleSource frees and restores what the source of a stream holds.
+type idleSource interface {
+	sleep(ctx context.Context) error
+	wake(ctx context.Context) error
+}
+
+// nvIdleSource quits the app; resuming launches it again.
+type nvIdleSource struct {
+	conn nvstream.NvConnection
+}
+
+func (s *nvIdleSource) sleep(ctx context.Context) error {
+	return s.conn.StopApp(ctx)
+}
+
+func (s *nvIdleSource) wake(ctx context.Context) error {
+	return s.conn.ResumeApp(ctx)
+}
+
+// rawIdleSource closes the listeners of a raw stream, which wait while
+// paused.
+type rawIdleSource struct {
+	paused    *atomic.Bool
+	listeners []chan struct{}
+}
+
+func (s *rawIdleSource) sleep(ctx context.Context) error {
+	s.paused.Store(true)
+	s.restart()
+	return nil
+}
+
+func (s *rawIdleSource) wake(ctx context.Context) error {
+	s.paused.Store(false)
+	s.restart()
+	return nil
+}
+
+func (s *rawIdleSource) restart() {
+	for _, listener := range s.listeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// IdleTimeout bounds sleeping or waking a source.
+const IdleTimeout = 30 * time.Second
+
+// idleMonitor puts a stream to sleep when idle and wakes it for a peer.
+type idleMonitor struct {
+	log        *zap.Logger
+	cfg        *Idle
+	stream     string
+	transport  Transport
+	source     idleSource // nil when only the peers are closed
+	lastInput  *atomic.Int64
+	closePeers func()
+	publish    func(event EventType, data any)
+
+	peers    int
+	active   time.Time // when the last peer left, a peer came or the stream woke
+	sleeping bool
+
+	transition sync.Mutex // held while the source sleeps or wakes
+	sync.Mutex
+}
+
+// Sleeping reports whether the stream sleeps.
+func (m *idleMonitor) Sleeping() bool {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.sleeping
+}
+
+// Run checks the stream until ctx ends.
+func (m *idleMonitor) Run(ctx context.Context) {
+	interval := time.Minute
+	for _, timeout := range []time.Duration{m.cfg.NoPeers, m.cfg.NoInput} {
+		if timeout > 0 {
+			interval = min(interval, timeout/4)
+		}
+	}
+
+	interval = max(interval, time.Second)
+
+	m.Lock()
+	m.active = time.Now()
+	m.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			m.check(now)
+		}
+	}
+}
+
+// check puts the stream to sleep once it has been idle long enough.
+func (m *idleMonitor) check(now time.Time) {
+	m.Lock()
+
+	if m.sleeping {
+		m.Unlock()
+		return
+	}
+
+	last := m.active
+	if input := time.Unix(0, m.lastInput.Load()); input.After(last) {
+		last = input
+	}
+
+	idle := now.Sub(last)
+
+	var reason string
+	switch {
+	case m.peers == 0 && m.cfg.NoPeers > 0 && idle >= m.cfg.NoPeers:
+		reason = IdleReasonNoPeers
+	case m.peers > 0 && m.cfg.NoInput > 0 && idle >= m.cfg.NoInput:
+		reason = IdleReasonNoInput
+	default:
+		m.Unlock()
+		return
+	}
+
+	m.sleeping = true
+
+	m.transition.Lock()
+	defer m.transition.Unlock()
+
+	m.Unlock()
+
+	log := m.log.With(
+		zap.String("reason", reason),
+		zap.Duration("idle", idle),
+	)
+
+	log.Info("stream idle")
+
+	if reason == IdleReasonNoInput {
+		m.closePeers()
+	}
+
+	if m.source != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), IdleTimeout)
+		defer cancel()
+
+		if err := m.source.sleep(ctx); err != nil {
+			log.Error("stream sleep failed", zap.Error(err))
+		}
+	}
+
+	m.publish(EventStreamIdle, &StreamIdleEvent{
+		Stream: m.stream,
+		Reason: reason,
+		Idle:   idle,
+	})
+}
+
+// peersChanged wakes a sleeping stream for a peer.
+func (m *idleMonitor) peersChanged(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	came := n > m.peers
+	m.peers = n
+
+	if n == 0 || came {
+		m.active = time.Now()
+	}
+
+	if n == 0 || !m.sleeping {
+		return
+	}
+
+	m.sleeping = false
+
+	if m.source != nil {
+		// Not under the peer group's lock.
+		go func() {
+			m.transition.Lock()
+			defer m.transition.Unlock()
+
+			ctx, cancel := context.WithTimeout(context.Background(), IdleTimeout)
+			defer cancel()
+
+			if err := m.source.wake(ctx); err != nil {
+				m.log.Error("stream wake failed", zap.Error(err))
+				return
+			}
+
+			m.log.Info("stream woke")
+		}()
+	}
+
+	m.publish(EventStreamResumed, &StreamEvent{
+		Stream:    m.stream,
+		Transport: m.transport,
+	})
+}
+
+// exempt reports whether the stream has a use that keeps it awake.
+func (cfg *Idle) exempt(stream *Stream, recordings *Recordings) bool {
+	if slices.Contains(cfg.Exempt, IdleExemptRecording) &&
+		recordings != nil && recordings.Enabled && recordings.Includes(stream.Name) {
+		return true
+	}
+
+	if slices.Contains(cfg.Exempt, IdleExemptRepublish) && len(stream.Republish) > 0 {
+		return true
+	}
+
+	return false
+}
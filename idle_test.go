@@ -0,0 +1,102 @@
+package game
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestIdleConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg Idle
+	if err := yaml.Unmarshal([]byte("noPeers: 10m\nexempt: [recording]"), &cfg); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(10*time.Minute, cfg.NoPeers)
+	assert.Zero(cfg.NoInput)
+	assert.Equal([]IdleExemption{IdleExemptRecording}, cfg.Exempt)
+
+	assert.Error(yaml.Unmarshal([]byte(`noInput: -1m`), &cfg))
+	assert.Error(yaml.Unmarshal([]byte(`exempt: [snapshots]`), &cfg))
+
+	stream := &Stream{Name: "game", Republish: []*Republish{{}}}
+	recordings := &Recordings{Enabled: true}
+
+	assert.True(cfg.exempt(stream, recordings))
+	assert.False(cfg.exempt(stream, nil))
+	assert.True((&Idle{Exempt: []IdleExemption{IdleExemptRepublish}}).exempt(stream, nil))
+}
+
+type fakeIdleSource struct {
+	sleeps, wakes atomic.Int32
+}
+
+func (s *fakeIdleSource) sleep(ctx context.Context) error {
+	s.sleeps.Add(1)
+	return nil
+}
+
+func (s *fakeIdleSource) wake(ctx context.Context) error {
+	s.wakes.Add(1)
+	return nil
+}
+
+func TestIdleMonitor(t *testing.T) {
+	assert := assert.New(t)
+
+	source := new(fakeIdleSource)
+
+	var lastInput atomic.Int64
+	var closed int
+	var events []EventType
+
+	m := &idleMonitor{
+		log:        zap.NewNop(),
+		cfg:        &Idle{NoPeers: time.Minute, NoInput: 5 * time.Minute},
+		stream:     "game",
+		source:     source,
+		lastInput:  &lastInput,
+		closePeers: func() { closed++ },
+		publish: func(event EventType, data any) {
+			events = append(events, event)
+		},
+	}
+
+	start := time.Now()
+	m.active = start
+
+	m.check(start.Add(30 * time.Second))
+	assert.False(m.Sleeping())
+
+	m.check(start.Add(time.Minute))
+	assert.True(m.Sleeping())
+	assert.Equal(int32(1), source.sleeps.Load())
+	assert.Equal(0, closed)
+	assert.Equal([]EventType{EventStreamIdle}, events)
+
+	// The next peer wakes the stream.
+	m.peersChanged(1)
+	assert.False(m.Sleeping())
+	assert.Eventually(func() bool { return source.wakes.Load() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(EventStreamResumed, events[1])
+
+	// A watching peer keeps it awake while sending input.
+	now := time.Now()
+	lastInput.Store(now.Add(4 * time.Minute).UnixNano())
+
+	m.check(now.Add(8 * time.Minute))
+	assert.False(m.Sleeping())
+
+	m.check(now.Add(9 * time.Minute))
+	assert.True(m.Sleeping())
+	assert.Equal(1, closed)
+	assert.Equal(int32(2), source.sleeps.Load())
+}
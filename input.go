@@ -0,0 +1,115 @@
+package game
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Input injects keyboard and mouse events into the host, the way Gamepad
+// does for controllers. It serves transports without an NVStream
+// connection to carry the input, such as raw captures of the desktop.
+type Input interface {
+	// InjectKey presses or releases a key given as a Windows virtual-key
+	// code. Modifiers are keys of their own.
+	InjectKey(keyCode uint16, down bool) error
+
+	// InjectMouseMove moves the pointer relative to its position.
+	InjectMouseMove(dx, dy int32) error
+
+	InjectMouseButton(button MouseButton, down bool) error
+
+	// InjectScroll scrolls vertically and horizontally, in units of 120
+	// per wheel notch; positive is up and right.
+	InjectScroll(dy, dx int32) error
+
+	Close()
+}
+
+type MouseButton uint8
+
+const (
+	MouseButtonLeft MouseButton = iota + 1
+	MouseButtonMiddle
+	MouseButtonRight
+	MouseButtonX1
+	MouseButtonX2
+)
+
+// WheelDelta is the scroll amount of one wheel notch.
+const WheelDelta = 120
+
+// newInput creates the service's input injector; tests replace it.
+var newInput = NewInput
+
+type MouseEventKind uint8
+
+const (
+	MouseEventMove   MouseEventKind = 0x01
+	MouseEventButton MouseEventKind = 0x02
+	MouseEventScroll MouseEventKind = 0x03
+)
+
+var errInvalidMouseEvent = errors.New("invalid mouse event")
+
+// MouseEvent is a message of the mouse data channel: a kind byte followed
+// by two big-endian int16 for a move (dx, dy) or scroll (dy, dx), or by the
+// button and 1 for pressed, 0 for released.
+type MouseEvent struct {
+	Kind   MouseEventKind
+	DX, DY int16
+	Button MouseButton
+	Down   bool
+}
+
+func ParseMouseEvent(data []byte) (*MouseEvent, error) {
+	if len(data) < 1 {
+		return nil, errInvalidMouseEvent
+	}
+
+	event := &MouseEvent{Kind: MouseEventKind(data[0])}
+
+	switch event.Kind {
+	case MouseEventMove, MouseEventScroll:
+		if len(data) < 5 {
+			return nil, errInvalidMouseEvent
+		}
+
+		a := int16(binary.BigEndian.Uint16(data[1:3]))
+		b := int16(binary.BigEndian.Uint16(data[3:5]))
+
+		if event.Kind == MouseEventMove {
+			event.DX, event.DY = a, b
+		} else {
+			event.DY, event.DX = a, b
+		}
+
+	case MouseEventButton:
+		if len(data) < 3 {
+			return nil, errInvalidMouseEvent
+		}
+
+		event.Button = MouseButton(data[1])
+		if event.Button < MouseButtonLeft || event.Button > MouseButtonX2 {
+			return nil, errInvalidMouseEvent
+		}
+
+		event.Down = data[2] == 1
+
+	default:
+		return nil, errInvalidMouseEvent
+	}
+
+	return event, nil
+}
+
+// Inject sends the event to the input.
+func (event *MouseEvent) Inject(input Input) error {
+	switch event.Kind {
+	case MouseEventMove:
+		return input.InjectMouseMove(int32(event.DX), int32(event.DY))
+	case MouseEventScroll:
+		return input.InjectScroll(int32(event.DY), int32(event.DX))
+	default:
+		return input.InjectMouseButton(event.Button, event.Down)
+	}
+}
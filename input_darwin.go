@@ -0,0 +1,7 @@
+package game
+
+import "errors"
+
+func NewInput() (Input, error) {
+	return nil, errors.New("input injection not implemented")
+}
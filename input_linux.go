@@ -0,0 +1,254 @@
+package game
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiDevSetup   = 0x405c5503
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+
+	evSyn = 0x00
+	evKey = 0x01
+	evRel = 0x02
+
+	relX      = 0x00
+	relY      = 0x01
+	relHWheel = 0x06
+	relWheel  = 0x08
+
+	btnLeft   = 0x110
+	btnRight  = 0x111
+	btnMiddle = 0x112
+	btnSide   = 0x113
+	btnExtra  = 0x114
+)
+
+// linuxKeys maps Windows virtual-key codes to Linux input key codes.
+var linuxKeys = map[uint16]uint16{
+	0x08: 14,  // VK_BACK
+	0x09: 15,  // VK_TAB
+	0x0D: 28,  // VK_RETURN
+	0x10: 42,  // VK_SHIFT
+	0x11: 29,  // VK_CONTROL
+	0x12: 56,  // VK_MENU
+	0x13: 119, // VK_PAUSE
+	0x14: 58,  // VK_CAPITAL
+	0x1B: 1,   // VK_ESCAPE
+	0x20: 57,  // VK_SPACE
+	0x21: 104, // VK_PRIOR
+	0x22: 109, // VK_NEXT
+	0x23: 107, // VK_END
+	0x24: 102, // VK_HOME
+	0x25: 105, // VK_LEFT
+	0x26: 103, // VK_UP
+	0x27: 106, // VK_RIGHT
+	0x28: 108, // VK_DOWN
+	0x2C: 99,  // VK_SNAPSHOT
+	0x2D: 110, // VK_INSERT
+	0x2E: 111, // VK_DELETE
+
+	0x30: 11, 0x31: 2, 0x32: 3, 0x33: 4, 0x34: 5, // 0-4
+	0x35: 6, 0x36: 7, 0x37: 8, 0x38: 9, 0x39: 10, // 5-9
+
+	0x41: 30, 0x42: 48, 0x43: 46, 0x44: 32, 0x45: 18, 0x46: 33, 0x47: 34, // A-G
+	0x48: 35, 0x49: 23, 0x4A: 36, 0x4B: 37, 0x4C: 38, 0x4D: 50, 0x4E: 49, // H-N
+	0x4F: 24, 0x50: 25, 0x51: 16, 0x52: 19, 0x53: 31, 0x54: 20, 0x55: 22, // O-U
+	0x56: 47, 0x57: 17, 0x58: 45, 0x59: 21, 0x5A: 44, // V-Z
+
+	0x5B: 125, // VK_LWIN
+	0x5C: 126, // VK_RWIN
+	0x5D: 127, // VK_APPS
+
+	0x60: 82, 0x61: 79, 0x62: 80, 0x63: 81, 0x64: 75, // numpad 0-4
+	0x65: 76, 0x66: 77, 0x67: 71, 0x68: 72, 0x69: 73, // numpad 5-9
+	0x6A: 55, // VK_MULTIPLY
+	0x6B: 78, // VK_ADD
+	0x6D: 74, // VK_SUBTRACT
+	0x6E: 83, // VK_DECIMAL
+	0x6F: 98, // VK_DIVIDE
+
+	0x70: 59, 0x71: 60, 0x72: 61, 0x73: 62, 0x74: 63, 0x75: 64, // F1-F6
+	0x76: 65, 0x77: 66, 0x78: 67, 0x79: 68, 0x7A: 87, 0x7B: 88, // F7-F12
+
+	0x90: 69,  // VK_NUMLOCK
+	0x91: 70,  // VK_SCROLL
+	0xA0: 42,  // VK_LSHIFT
+	0xA1: 54,  // VK_RSHIFT
+	0xA2: 29,  // VK_LCONTROL
+	0xA3: 97,  // VK_RCONTROL
+	0xA4: 56,  // VK_LMENU
+	0xA5: 100, // VK_RMENU
+	0xBA: 39,  // VK_OEM_1, ;
+	0xBB: 13,  // VK_OEM_PLUS, =
+	0xBC: 51,  // VK_OEM_COMMA
+	0xBD: 12,  // VK_OEM_MINUS
+	0xBE: 52,  // VK_OEM_PERIOD
+	0xBF: 53,  // VK_OEM_2, /
+	0xC0: 41,  // VK_OEM_3, `
+	0xDB: 26,  // VK_OEM_4, [
+	0xDC: 43,  // VK_OEM_5, \
+	0xDD: 27,  // VK_OEM_6, ]
+	0xDE: 40,  // VK_OEM_7, '
+}
+
+var linuxButtons = map[MouseButton]uint16{
+	MouseButtonLeft:   btnLeft,
+	MouseButtonMiddle: btnMiddle,
+	MouseButtonRight:  btnRight,
+	MouseButtonX1:     btnSide,
+	MouseButtonX2:     btnExtra,
+}
+
+// uinputSetup is struct uinput_setup.
+type uinputSetup struct {
+	bustype      uint16
+	vendor       uint16
+	product      uint16
+	version      uint16
+	name         [80]byte
+	ffEffectsMax uint32
+}
+
+// NewInput creates a uinput keyboard and mouse device. The service needs
+// write access to /dev/uinput, e.g. through the input group.
+func NewInput() (Input, error) {
+	f, err := os.OpenFile("/dev/uinput", os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &uinput{f: f}
+
+	if err := input.setup(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return input, nil
+}
+
+type uinput struct {
+	f *os.File
+}
+
+func (input *uinput) setup() error {
+	for _, ev := range []uintptr{evKey, evRel} {
+		if err := input.ioctl(uiSetEvBit, ev); err != nil {
+			return err
+		}
+	}
+
+	for _, code := range linuxKeys {
+		if err := input.ioctl(uiSetKeyBit, uintptr(code)); err != nil {
+			return err
+		}
+	}
+
+	for _, code := range linuxButtons {
+		if err := input.ioctl(uiSetKeyBit, uintptr(code)); err != nil {
+			return err
+		}
+	}
+
+	for _, rel := range []uintptr{relX, relY, relWheel, relHWheel} {
+		if err := input.ioctl(uiSetRelBit, rel); err != nil {
+			return err
+		}
+	}
+
+	setup := uinputSetup{
+		bustype: 0x06, // BUS_VIRTUAL
+		vendor:  0x1209,
+		product: 0x0001,
+		version: 1,
+	}
+	copy(setup.name[:], "Game Virtual Input")
+
+	if err := input.ioctl(uiDevSetup, uintptr(unsafe.Pointer(&setup))); err != nil {
+		return err
+	}
+
+	return input.ioctl(uiDevCreate, 0)
+}
+
+func (input *uinput) ioctl(req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, input.f.Fd(), req, arg)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// emit writes the events followed by a report.
+func (input *uinput) emit(events ...[3]int32) error {
+	now := time.Now()
+
+	buf := make([]byte, 0, 24*(len(events)+1))
+	for _, ev := range append(events, [3]int32{evSyn, 0, 0}) {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(now.Unix()))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(now.Nanosecond()/1000))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(ev[0]))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(ev[1]))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(ev[2]))
+	}
+
+	_, err := input.f.Write(buf)
+	return err
+}
+
+func (input *uinput) InjectKey(keyCode uint16, down bool) error {
+	code, ok := linuxKeys[keyCode]
+	if !ok {
+		return errors.New("unmapped virtual-key code")
+	}
+
+	return input.emit([3]int32{evKey, int32(code), boolValue(down)})
+}
+
+func (input *uinput) InjectMouseMove(dx, dy int32) error {
+	return input.emit(
+		[3]int32{evRel, relX, dx},
+		[3]int32{evRel, relY, dy},
+	)
+}
+
+func (input *uinput) InjectMouseButton(button MouseButton, down bool) error {
+	code, ok := linuxButtons[button]
+	if !ok {
+		return errors.New("invalid mouse button")
+	}
+
+	return input.emit([3]int32{evKey, int32(code), boolValue(down)})
+}
+
+// InjectScroll scrolls whole notches, as REL_WHEEL counts them.
+func (input *uinput) InjectScroll(dy, dx int32) error {
+	return input.emit(
+		[3]int32{evRel, relWheel, dy / WheelDelta},
+		[3]int32{evRel, relHWheel, dx / WheelDelta},
+	)
+}
+
+func (input *uinput) Close() {
+	input.ioctl(uiDevDestroy, 0)
+	input.f.Close()
+}
+
+func boolValue(b bool) int32 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,54 @@
+package game
+
+import (
+	"errors"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// NewMoonlightInput returns an input that forwards events to the host over
+// the NVStream connection, like NewMoonlightGamepad does for controllers.
+func NewMoonlightInput() Input {
+	return moonlightInput{}
+}
+
+type moonlightInput struct{}
+
+// InjectKey sends the key without modifier flags; modifiers arrive as keys
+// of their own.
+func (moonlightInput) InjectKey(keyCode uint16, down bool) error {
+	action := moonlight.KEY_ACTION_UP
+	if down {
+		action = moonlight.KEY_ACTION_DOWN
+	}
+
+	return moonlight.SendKeyboardEvent(int16(keyCode), action, 0)
+}
+
+func (moonlightInput) InjectMouseMove(dx, dy int32) error {
+	return moonlight.SendMouseMoveEvent(clampInt16(dx), clampInt16(dy))
+}
+
+// InjectMouseButton relies on MouseButton sharing moonlight's numbering.
+func (moonlightInput) InjectMouseButton(button MouseButton, down bool) error {
+	if button < MouseButtonLeft || button > MouseButtonX2 {
+		return errors.New("invalid mouse button")
+	}
+
+	action := moonlight.BUTTON_ACTION_RELEASE
+	if down {
+		action = moonlight.BUTTON_ACTION_PRESS
+	}
+
+	return moonlight.SendMouseButtonEvent(action, int(button))
+}
+
+func (moonlightInput) InjectScroll(dy, dx int32) error {
+	return moonlight.SendScrollEvent(clampInt16(dy), clampInt16(dx))
+}
+
+func (moonlightInput) Close() {}
+
+func clampInt16(v int32) int16 {
+	return int16(max(min(v, 32767), -32768))
+}
@@ -0,0 +1,73 @@
+package game
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingInput records the injected events.
+type recordingInput struct {
+	events []string
+}
+
+func (input *recordingInput) InjectKey(keyCode uint16, down bool) error {
+	input.events = append(input.events, fmt.Sprintf("key %#x %t", keyCode, down))
+	return nil
+}
+
+func (input *recordingInput) InjectMouseMove(dx, dy int32) error {
+	input.events = append(input.events, fmt.Sprintf("move %d %d", dx, dy))
+	return nil
+}
+
+func (input *recordingInput) InjectMouseButton(button MouseButton, down bool) error {
+	input.events = append(input.events, fmt.Sprintf("button %d %t", button, down))
+	return nil
+}
+
+func (input *recordingInput) InjectScroll(dy, dx int32) error {
+	input.events = append(input.events, fmt.Sprintf("scroll %d %d", dy, dx))
+	return nil
+}
+
+func (input *recordingInput) Close() {}
+
+func TestMouseEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	input := new(recordingInput)
+
+	for _, data := range [][]byte{
+		{0x01, 0x00, 0x05, 0xFF, 0xFE}, // move 5, -2
+		{0x02, 0x03, 0x01},             // right button down
+		{0x02, 0x03, 0x00},             // right button up
+		{0x03, 0xFF, 0x88, 0x00, 0x00}, // scroll one notch down
+	} {
+		event, err := ParseMouseEvent(data)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.NoError(event.Inject(input))
+	}
+
+	assert.Equal([]string{
+		"move 5 -2",
+		"button 3 true",
+		"button 3 false",
+		"scroll -120 0",
+	}, input.events)
+
+	for _, data := range [][]byte{
+		nil,
+		{0x01, 0x00},       // short move
+		{0x02, 0x06, 0x01}, // unknown button
+		{0x04},             // unknown kind
+	} {
+		_, err := ParseMouseEvent(data)
+		assert.ErrorIs(err, errInvalidMouseEvent)
+	}
+}
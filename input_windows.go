@@ -0,0 +1,186 @@
+package game
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32        = syscall.NewLazyDLL("user32.dll")
+	procSendInput = user32.NewProc("SendInput")
+)
+
+const (
+	inputMouse    = 0
+	inputKeyboard = 1
+
+	keyEventExtendedKey = 0x0001
+	keyEventKeyUp       = 0x0002
+
+	mouseEventMove       = 0x0001
+	mouseEventLeftDown   = 0x0002
+	mouseEventLeftUp     = 0x0004
+	mouseEventRightDown  = 0x0008
+	mouseEventRightUp    = 0x0010
+	mouseEventMiddleDown = 0x0020
+	mouseEventMiddleUp   = 0x0040
+	mouseEventXDown      = 0x0080
+	mouseEventXUp        = 0x0100
+	mouseEventWheel      = 0x0800
+	mouseEventHWheel     = 0x1000
+)
+
+// mouseInput and keyboardInput lay out an INPUT of the respective type on
+// 64-bit Windows, where the union starts at offset 8 and is 32 bytes.
+type mouseInput struct {
+	typ       uint32
+	_         uint32
+	dx        int32
+	dy        int32
+	mouseData uint32
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+}
+
+type keyboardInput struct {
+	typ       uint32
+	_         uint32
+	vk        uint16
+	scan      uint16
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+	_         [8]byte
+}
+
+// extendedKeys are the virtual keys on the extended part of the keyboard,
+// which SendInput must flag to tell them from their numpad twins.
+var extendedKeys = map[uint16]bool{
+	0x21: true, // VK_PRIOR
+	0x22: true, // VK_NEXT
+	0x23: true, // VK_END
+	0x24: true, // VK_HOME
+	0x25: true, // VK_LEFT
+	0x26: true, // VK_UP
+	0x27: true, // VK_RIGHT
+	0x28: true, // VK_DOWN
+	0x2C: true, // VK_SNAPSHOT
+	0x2D: true, // VK_INSERT
+	0x2E: true, // VK_DELETE
+	0x5B: true, // VK_LWIN
+	0x5C: true, // VK_RWIN
+	0x5D: true, // VK_APPS
+	0x6F: true, // VK_DIVIDE
+	0x90: true, // VK_NUMLOCK
+	0xA3: true, // VK_RCONTROL
+	0xA5: true, // VK_RMENU
+}
+
+// NewInput injects events with SendInput into the interactive desktop of
+// the session the service runs in.
+func NewInput() (Input, error) {
+	if err := procSendInput.Find(); err != nil {
+		return nil, err
+	}
+
+	return &sendInput{}, nil
+}
+
+type sendInput struct{}
+
+func (*sendInput) InjectKey(keyCode uint16, down bool) error {
+	input := keyboardInput{
+		typ: inputKeyboard,
+		vk:  keyCode,
+	}
+
+	if extendedKeys[keyCode] {
+		input.flags |= keyEventExtendedKey
+	}
+
+	if !down {
+		input.flags |= keyEventKeyUp
+	}
+
+	return injectInput(unsafe.Pointer(&input), unsafe.Sizeof(input))
+}
+
+func (*sendInput) InjectMouseMove(dx, dy int32) error {
+	input := mouseInput{
+		typ:   inputMouse,
+		dx:    dx,
+		dy:    dy,
+		flags: mouseEventMove,
+	}
+
+	return injectInput(unsafe.Pointer(&input), unsafe.Sizeof(input))
+}
+
+func (*sendInput) InjectMouseButton(button MouseButton, down bool) error {
+	input := mouseInput{typ: inputMouse}
+
+	switch button {
+	case MouseButtonLeft:
+		input.flags = inputFlag(down, mouseEventLeftDown, mouseEventLeftUp)
+	case MouseButtonMiddle:
+		input.flags = inputFlag(down, mouseEventMiddleDown, mouseEventMiddleUp)
+	case MouseButtonRight:
+		input.flags = inputFlag(down, mouseEventRightDown, mouseEventRightUp)
+	case MouseButtonX1, MouseButtonX2:
+		input.flags = inputFlag(down, mouseEventXDown, mouseEventXUp)
+		input.mouseData = uint32(button-MouseButtonX1) + 1 // XBUTTON1 or XBUTTON2
+	default:
+		return errors.New("invalid mouse button")
+	}
+
+	return injectInput(unsafe.Pointer(&input), unsafe.Sizeof(input))
+}
+
+func (*sendInput) InjectScroll(dy, dx int32) error {
+	if dy != 0 {
+		input := mouseInput{
+			typ:       inputMouse,
+			mouseData: uint32(dy),
+			flags:     mouseEventWheel,
+		}
+
+		if err := injectInput(unsafe.Pointer(&input), unsafe.Sizeof(input)); err != nil {
+			return err
+		}
+	}
+
+	if dx != 0 {
+		input := mouseInput{
+			typ:       inputMouse,
+			mouseData: uint32(dx),
+			flags:     mouseEventHWheel,
+		}
+
+		return injectInput(unsafe.Pointer(&input), unsafe.Sizeof(input))
+	}
+
+	return nil
+}
+
+func (*sendInput) Close() {}
+
+func injectInput(input unsafe.Pointer, size uintptr) error {
+	n, _, err := procSendInput.Call(1, uintptr(input), size)
+	if n != 1 {
+		// The error does not tell when UIPI blocked the input, e.g.
+		// while an elevated window has focus.
+		return errors.New("send input: " + err.Error())
+	}
+
+	return nil
+}
+
+func inputFlag(down bool, ifDown, ifUp uint32) uint32 {
+	if down {
+		return ifDown
+	}
+
+	return ifUp
+}
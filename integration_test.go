@@ -0,0 +1,544 @@
+package game
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+type testGamepad struct {
+	reports chan GamepadReport
+}
+
+func (g *testGamepad) Connect() error {
+	return nil
+}
+
+func (g *testGamepad) Update(report GamepadReport) error {
+	g.reports <- report
+	return nil
+}
+
+func (g *testGamepad) Close() {}
+
+// testHarness runs the service with its micro endpoints on an in-process
+// NATS server, a recording gamepad and a raw H264 stream fed over a unix
+// socket.
+type testHarness struct {
+	nc      *nats.Conn
+	svc     Service
+	gamepad *testGamepad
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	nc, err := nats.Connect(runNATSServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(nc.Close)
+
+	dir := t.TempDir()
+	videoSock := filepath.Join(dir, "video.sock")
+	audioSock := filepath.Join(dir, "audio.sock")
+
+	var cfg *Config
+	err = yaml.Unmarshal([]byte(`
+webrtc:
+  iceServers:
+  - provider: google
+streams:
+- name: gamestream
+  transport: raw
+  video:
+    codec: h264
+    address: unix://`+videoSock+`
+    fps: 60
+  audio:
+    codec: opus
+    address: unix://`+audioSock+`
+`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Path = dir
+
+	gamepad := &testGamepad{
+		reports: make(chan GamepadReport, 16),
+	}
+
+	newGamepad = func() (Gamepad, error) { return gamepad, nil }
+	t.Cleanup(func() { newGamepad = NewGamepad })
+
+	newInput = func() (Input, error) { return new(recordingInput), nil }
+	t.Cleanup(func() { newInput = NewInput })
+
+	svc, err := NewService(cfg, nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { svc.Close() })
+
+	srv, err := micro.AddService(nc, micro.Config{
+		Name:    "game",
+		Version: "0.0.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { srv.Stop() })
+
+	if err := AddEndpoints(srv, svc, DefaultRequestTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go feedTestVideo(ctx, videoSock)
+
+	return &testHarness{nc, svc, gamepad}
+}
+
+// feedTestVideo writes a synthetic H264 stream (SPS, PPS, IDR) until the
+// context is done.
+func feedTestVideo(ctx context.Context, addr string) {
+	var conn net.Conn
+	for conn == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+			conn, _ = net.Dial("unix", addr)
+		}
+	}
+
+	defer conn.Close()
+
+	frame := []byte{
+		0, 0, 0, 1, 0x67, 0x42, 0xC0, 0x1F,
+		0, 0, 0, 1, 0x68, 0xCE, 0x3C, 0x80,
+		0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00, 0x33,
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := conn.Write(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newTestHarness(t)
+
+	events, err := h.nc.SubscribeSync(EventPeerConnected.Subject())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	defer client.Close()
+
+	recvonly := webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}
+	client.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, recvonly)
+	client.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, recvonly)
+
+	tracks := make(chan *webrtc.TrackRemote, 2)
+	client.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		tracks <- track
+	})
+
+	dc, err := client.CreateDataChannel("gamepad", nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	opened := make(chan struct{})
+	dc.OnOpen(func() { close(opened) })
+
+	control, err := client.CreateDataChannel("control", nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	paths := make(chan ConnectionPath, 1)
+	control.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var m ControlMessage
+		if err := json.Unmarshal(msg.Data, &m); err != nil || m.Type != ControlConnectionPath {
+			return
+		}
+
+		var path ConnectionPath
+		if err := json.Unmarshal(m.Payload, &path); err == nil {
+			select {
+			case paths <- path:
+			default:
+			}
+		}
+	})
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(client)
+
+	if err := client.SetLocalDescription(offer); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	<-gatherComplete
+
+	reply := "peers.negotiation.harness"
+
+	candidates := make(chan *nats.Msg, 64)
+	if _, err := h.nc.ChanSubscribe(reply+".candidates.callee", candidates); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	answers := make(chan *nats.Msg, 1)
+	if _, err := h.nc.ChanSubscribe(reply+".sdp.answer", answers); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	bs, err := json.Marshal(client.LocalDescription())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if err := h.nc.PublishRequest("peers.negotiation", reply+".sdp.answer", bs); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var msg *nats.Msg
+	select {
+	case msg = <-answers:
+	case <-time.After(30 * time.Second):
+		assert.Fail("negotiation timed out")
+		return
+	}
+
+	if code := msg.Header.Get(micro.ErrorCodeHeader); code != "" {
+		assert.Fail(msg.Header.Get(micro.ErrorHeader))
+		return
+	}
+
+	var answer webrtc.SessionDescription
+	if err := json.Unmarshal(msg.Data, &answer); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if err := client.SetRemoteDescription(answer); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// Callee candidates are trickled while the service gathers; they are
+	// also part of the answer.
+	assert.NotEmpty(candidates)
+
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		assert.Fail("data channel not opened")
+		return
+	}
+
+	report := make([]byte, 12)
+	binary.BigEndian.PutUint16(report[0:2], 0x1000)
+	report[2] = 10
+	report[3] = 20
+	binary.BigEndian.PutUint16(report[4:6], uint16(1000))
+
+	if err := dc.Send(report); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	select {
+	case r := <-h.gamepad.reports:
+		assert.Equal(uint16(0x1000), r.Buttons())
+		assert.Equal(uint8(10), r.LeftTrigger())
+		assert.Equal(uint8(20), r.RightTrigger())
+		assert.Equal(ThumbStick{X: 1000}, r.LeftThumbStick())
+	case <-time.After(5 * time.Second):
+		assert.Fail("gamepad report not received")
+	}
+
+	if msg, err := events.NextMsg(5 * time.Second); err == nil {
+		var event struct {
+			Type EventType  `json:"type"`
+			Data *PeerEvent `json:"data"`
+		}
+
+		assert.NoError(json.Unmarshal(msg.Data, &event))
+		assert.Equal(EventPeerConnected, event.Type)
+		assert.Equal(DefaultStream, event.Data.Stream)
+	} else {
+		assert.Fail(err.Error())
+	}
+
+	// The report is counted once the gamepad returned.
+	time.Sleep(10 * time.Millisecond)
+
+	msg, err = h.nc.Request("gamepads.stats", nil, 5*time.Second)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var stats []LatencyStats
+	if err := json.Unmarshal(msg.Data, &stats); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if assert.Len(stats, 1) {
+		assert.Equal(1, stats[0].Controller)
+		assert.Equal(uint64(1), stats[0].Samples)
+	}
+
+	msg, err = h.nc.Request("peers.list", nil, 5*time.Second)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var peers []*PeerInfo
+	if err := json.Unmarshal(msg.Data, &peers); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if assert.Len(peers, 1) {
+		assert.Equal("harness", peers[0].ID)
+		assert.Equal(DefaultStream, peers[0].Stream)
+		assert.Equal("connected", peers[0].State)
+
+		dtls := peers[0].DTLS
+		assert.Equal("client", dtls.Role)
+		assert.NotEmpty(dtls.LocalFingerprints)
+		assert.True(dtls.Matched)
+	}
+
+	select {
+	case track := <-tracks:
+		assert.Equal(webrtc.RTPCodecTypeVideo, track.Kind())
+		assert.Equal(webrtc.MimeTypeH264, track.Codec().MimeType)
+	case <-time.After(5 * time.Second):
+		assert.Fail("video track not received")
+	}
+
+	// The pair selected before the control channel opened is sent on open.
+	select {
+	case path := <-paths:
+		assert.False(path.Relayed)
+		assert.False(path.Switched)
+		assert.NotEmpty(path.Local)
+	case <-time.After(5 * time.Second):
+		assert.Fail("connection path not received")
+	}
+}
+
+func TestDescribeStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newTestHarness(t)
+
+	msg, err := h.nc.Request("streams.describe", nil, 5*time.Second)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var manifests []*StreamManifest
+	if err := json.Unmarshal(msg.Data, &manifests); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if !assert.Len(manifests, 1) {
+		return
+	}
+
+	m := manifests[0]
+	assert.Equal("gamestream", m.Name)
+	assert.Equal(TransportRaw, m.Transport)
+	assert.True(m.Live)
+	assert.Equal(StreamStatusLive, m.Status)
+	assert.True(m.Snapshots)
+	assert.Equal(&VideoManifest{Codec: CodecH264, FPS: 60}, m.Video)
+	assert.Equal(&AudioManifest{Codec: CodecOpus}, m.Audio)
+	assert.Equal([]string{"gamepad", "keyboard", "mouse", "text"}, m.Inputs)
+
+	req := nats.NewMsg("streams.describe")
+	req.Header.Set("stream", "unknown")
+
+	msg, err = h.nc.RequestMsg(req, 5*time.Second)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("404", msg.Header.Get(micro.ErrorCodeHeader))
+}
+
+func TestHealth(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newTestHarness(t)
+
+	// wait for the fed video to arrive
+	time.Sleep(100 * time.Millisecond)
+
+	msg, err := h.nc.Request("ready", nil, 5*time.Second)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Empty(msg.Header.Get(micro.ErrorCodeHeader))
+
+	var health *Health
+	if err := json.Unmarshal(msg.Data, &health); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(health.Ready)
+
+	if assert.Len(health.Streams, 1) {
+		stream := health.Streams[0]
+		assert.Equal("gamestream", stream.Name)
+		assert.True(stream.Video.Listening)
+		assert.NotNil(stream.Video.LastSample)
+		assert.True(stream.Audio.Listening)
+		assert.Nil(stream.Audio.LastSample)
+	}
+
+	if assert.NotNil(health.Gamepad) {
+		assert.Equal(GamepadBackendViGEm, health.Gamepad.Backend)
+		assert.Equal(1, health.Gamepad.Controllers)
+	}
+}
+
+func TestPair(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newTestHarness(t)
+
+	// The reply subject must carry the .state suffix.
+	msg, err := h.nc.Request("nvstream.pair", nil, 5*time.Second)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("400", msg.Header.Get(micro.ErrorCodeHeader))
+
+	states := make(chan *nats.Msg, 1)
+	if _, err := h.nc.ChanSubscribe("pairing.harness.state", states); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if err := h.nc.PublishRequest("nvstream.pair", "pairing.harness.state", nil); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	select {
+	case msg = <-states:
+		assert.Equal("417", msg.Header.Get(micro.ErrorCodeHeader))
+		assert.Contains(msg.Header.Get(micro.ErrorHeader), "requires an nvstream stream")
+	case <-time.After(5 * time.Second):
+		assert.Fail("pairing not answered")
+	}
+}
+
+func TestAudioOnlyPeer(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newTestHarness(t)
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	defer client.Close()
+
+	recvonly := webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}
+	client.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, recvonly)
+	client.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, recvonly)
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	peer, err := h.svc.AcceptPeer(context.Background(), offer, "peers.negotiation.audio", PeerOptions{
+		Permissions: PermissionNone,
+		Mode:        PeerModeAudioOnly,
+	})
+
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	defer peer.Close()
+
+	var kinds []webrtc.RTPCodecType
+	for _, sender := range peer.GetSenders() {
+		if track := sender.Track(); track != nil {
+			kinds = append(kinds, track.Kind())
+		}
+	}
+
+	assert.Equal([]webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio}, kinds)
+
+	_, err = ParsePeerMode("video")
+	assert.Error(err)
+}
@@ -0,0 +1,143 @@
+package game
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InputConfig configures the input injection path. Reports taking longer
+// than LatencyBudget from data channel receipt to submission are logged.
+// MaxGamepadRate caps the gamepad reports per second each peer submits;
+// a negative rate lifts the cap.
+type InputConfig struct {
+	LatencyBudget  time.Duration
+	MaxGamepadRate int
+}
+
+func (cfg *InputConfig) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		LatencyBudget  time.Duration `yaml:"latencyBudget"`
+		MaxGamepadRate int           `yaml:"maxGamepadRate"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.LatencyBudget == 0 {
+		raw.LatencyBudget = 4 * time.Millisecond
+	}
+
+	if raw.MaxGamepadRate == 0 {
+		raw.MaxGamepadRate = 250
+	}
+
+	cfg.LatencyBudget = raw.LatencyBudget
+	cfg.MaxGamepadRate = raw.MaxGamepadRate
+
+	return nil
+}
+
+var defaultInput = &InputConfig{
+	LatencyBudget:  4 * time.Millisecond,
+	MaxGamepadRate: 250,
+}
+
+// LatencyStats summarizes the injection latency of a controller over the
+// most recent reports.
+type LatencyStats struct {
+	Controller int           `json:"controller"`
+	Samples    uint64        `json:"samples"`
+	OverBudget uint64        `json:"over_budget"`
+	P50        time.Duration `json:"p50"`
+	P99        time.Duration `json:"p99"`
+}
+
+const (
+	latencyWindow       = 1024
+	latencyWarnInterval = 10 * time.Second
+)
+
+// latencyRecorder keeps a ring of the most recent latencies.
+type latencyRecorder struct {
+	budget   time.Duration
+	window   []time.Duration
+	next     int
+	samples  uint64
+	over     uint64
+	lastWarn time.Time
+	sync.Mutex
+}
+
+func newLatencyRecorder(budget time.Duration) *latencyRecorder {
+	return &latencyRecorder{
+		budget: budget,
+		window: make([]time.Duration, 0, latencyWindow),
+	}
+}
+
+// Observe records a latency. It reports whether the budget was exceeded
+// and no warning was due within the last latencyWarnInterval, so callers
+// can warn without flooding the log at the report rate.
+func (r *latencyRecorder) Observe(d time.Duration) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.window) < latencyWindow {
+		r.window = append(r.window, d)
+	} else {
+		r.window[r.next] = d
+		r.next = (r.next + 1) % latencyWindow
+	}
+
+	r.samples++
+
+	if r.budget <= 0 || d <= r.budget {
+		return false
+	}
+
+	r.over++
+
+	now := time.Now()
+	if now.Sub(r.lastWarn) < latencyWarnInterval {
+		return false
+	}
+
+	r.lastWarn = now
+	return true
+}
+
+func (r *latencyRecorder) Stats(controller int) LatencyStats {
+	r.Lock()
+	sorted := slices.Clone(r.window)
+	stats := LatencyStats{
+		Controller: controller,
+		Samples:    r.samples,
+		OverBudget: r.over,
+	}
+	r.Unlock()
+
+	if len(sorted) == 0 {
+		return stats
+	}
+
+	slices.Sort(sorted)
+
+	stats.P50 = percentile(sorted, 50)
+	stats.P99 = percentile(sorted, 99)
+
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,67 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyRecorder(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newLatencyRecorder(50 * time.Millisecond)
+
+	stats := r.Stats(1)
+	assert.Equal(LatencyStats{Controller: 1}, stats)
+
+	// The first report over budget warns, the next ones within the
+	// interval do not.
+	for i := 1; i <= 100; i++ {
+		warn := r.Observe(time.Duration(i) * time.Millisecond)
+		assert.Equal(i == 51, warn)
+	}
+
+	stats = r.Stats(1)
+	assert.Equal(uint64(100), stats.Samples)
+	assert.Equal(uint64(50), stats.OverBudget)
+	assert.Equal(50*time.Millisecond, stats.P50)
+	assert.Equal(99*time.Millisecond, stats.P99)
+
+	// Only the most recent window counts toward the percentiles.
+	for i := 0; i < latencyWindow; i++ {
+		r.Observe(time.Millisecond)
+	}
+
+	stats = r.Stats(1)
+	assert.Equal(uint64(100+latencyWindow), stats.Samples)
+	assert.Equal(time.Millisecond, stats.P99)
+}
+
+func TestGamepadManagerLatency(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewGamepadManager(&slotGamepad{}, func() (Gamepad, error) {
+		return &slotGamepad{}, nil
+	}, 10*time.Millisecond)
+
+	assert.Empty(m.LatencyStats())
+
+	assert.False(m.ObserveLatency(0, time.Millisecond))
+	assert.True(m.ObserveLatency(2, 20*time.Millisecond))
+
+	stats := m.LatencyStats()
+	if !assert.Len(stats, 2) {
+		return
+	}
+
+	assert.Equal(1, stats[0].Controller)
+	assert.Equal(time.Millisecond, stats[0].P50)
+	assert.Equal(2, stats[1].Controller)
+	assert.Equal(uint64(1), stats[1].OverBudget)
+
+	// A new player on the slot starts over.
+	_, err := m.Acquire(2, "alice")
+	assert.NoError(err)
+	assert.Len(m.LatencyStats(), 1)
+}
@@ -0,0 +1,226 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+type LaunchMode string
+
+const (
+	LaunchEager LaunchMode = "eager" // at startup
+	LaunchLazy  LaunchMode = "lazy"  // when the first peer connects
+)
+
+// Launch sets when an NVStream stream launches its app. A lazy stream quits
+// the app once its last peer has been gone for Grace, so no game runs
+// without viewers.
+type Launch struct {
+	Mode    LaunchMode
+	Grace   time.Duration
+	Timeout time.Duration // of a launch
+}
+
+func (cfg *Launch) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Mode    LaunchMode    `yaml:"mode"`
+		Grace   time.Duration `yaml:"grace"`
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	switch raw.Mode {
+	case "":
+		raw.Mode = defaultLaunch.Mode
+	case LaunchEager, LaunchLazy:
+	default:
+		return errors.New("invalid launch mode: " + string(raw.Mode))
+	}
+
+	if raw.Grace < 0 {
+		return errors.New("invalid launch grace: " + raw.Grace.String())
+	}
+
+	if raw.Grace == 0 {
+		raw.Grace = defaultLaunch.Grace
+	}
+
+	if raw.Timeout <= 0 {
+		raw.Timeout = defaultLaunch.Timeout
+	}
+
+	cfg.Mode = raw.Mode
+	cfg.Grace = raw.Grace
+	cfg.Timeout = raw.Timeout
+
+	return nil
+}
+
+var defaultLaunch = &Launch{
+	Mode:    LaunchEager,
+	Grace:   time.Minute,
+	Timeout: 30 * time.Second,
+}
+
+// appLauncher launches the app of a lazy stream for its peers and quits it
+// after they left.
+type appLauncher struct {
+	log     *zap.Logger
+	ctx     context.Context // of the stream
+	cfg     *Launch
+	launch  func(ctx context.Context) error
+	quit    func(ctx context.Context) error
+	peers   int
+	running bool
+	pending bool          // launching
+	timer   *time.Timer   // quits the app, nil unless idle with it running
+	idle    int           // counts the idle periods, so a stale timer quits nothing
+	done    chan struct{} // closed when the pending launch ended
+	closed  bool
+	sync.Mutex
+}
+
+// newAppLauncher launches the app returned by app, which changes with the
+// origin of the stream.
+func newAppLauncher(ctx context.Context, cfg *Launch, conn nvstream.NvConnection, app func() nvstream.NvApp, log *zap.Logger) *appLauncher {
+	return &appLauncher{
+		log: log,
+		ctx: ctx,
+		cfg: cfg,
+		launch: func(ctx context.Context) error {
+			return conn.StartApp(ctx, app())
+		},
+		quit: conn.StopApp,
+	}
+}
+
+// Running reports whether the app runs or is being launched.
+func (l *appLauncher) Running() bool {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.running || l.pending
+}
+
+// peersChanged launches the app for the first peer and schedules its quit
+// after the last.
+func (l *appLauncher) peersChanged(n int) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.peers = n
+
+	if n > 0 {
+		if l.timer != nil {
+			l.timer.Stop()
+			l.timer = nil
+		}
+
+		if !l.running && !l.pending && !l.closed {
+			l.pending = true
+			l.done = make(chan struct{})
+			go l.start(l.done)
+		}
+
+		return
+	}
+
+	if l.running && l.timer == nil {
+		l.scheduleQuit()
+	}
+}
+
+// scheduleQuit quits the app after the grace period, unless a peer comes.
+func (l *appLauncher) scheduleQuit() {
+	if l.closed {
+		return
+	}
+
+	l.idle++
+	idle := l.idle
+
+	l.log.Info("app idle", zap.Duration("grace", l.cfg.Grace))
+	l.timer = time.AfterFunc(l.cfg.Grace, func() {
+		l.stop(idle)
+	})
+}
+
+func (l *appLauncher) start(done chan struct{}) {
+	defer close(done)
+
+	l.log.Info("launching app")
+
+	ctx, cancel := context.WithTimeout(l.ctx, l.cfg.Timeout)
+	err := l.launch(ctx)
+	cancel()
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.pending = false
+
+	if err != nil {
+		// The next peer tries again.
+		l.log.Error("app launch failed", zap.Error(err))
+		return
+	}
+
+	l.running = true
+	l.log.Info("app launched")
+
+	// The peers may have left during the launch.
+	if l.peers == 0 && l.timer == nil {
+		l.scheduleQuit()
+	}
+}
+
+func (l *appLauncher) stop(idle int) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.timer == nil || l.idle != idle || l.peers > 0 {
+		return
+	}
+
+	l.timer = nil
+	l.running = false
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.cfg.Timeout)
+	defer cancel()
+
+	if err := l.quit(ctx); err != nil {
+		l.log.Error("app quit failed", zap.Error(err))
+		return
+	}
+
+	l.log.Info("app quit")
+}
+
+// close cancels a scheduled quit and waits for a pending launch, leaving
+// the app as it is like the stop of an eager stream.
+func (l *appLauncher) close() {
+	l.Lock()
+	l.closed = true
+
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+
+	done := l.done
+	l.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
@@ -0,0 +1,85 @@
+package game
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLaunchConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg Launch
+	if err := yaml.Unmarshal([]byte(`mode: lazy`), &cfg); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(LaunchLazy, cfg.Mode)
+	assert.Equal(time.Minute, cfg.Grace)
+	assert.Equal(30*time.Second, cfg.Timeout)
+
+	assert.Error(yaml.Unmarshal([]byte(`mode: later`), &cfg))
+	assert.Error(yaml.Unmarshal([]byte(`grace: -1s`), &cfg))
+}
+
+func TestAppLauncher(t *testing.T) {
+	assert := assert.New(t)
+
+	var launches, quits atomic.Int32
+
+	l := &appLauncher{
+		log: zap.NewNop(),
+		ctx: context.Background(),
+		cfg: &Launch{Mode: LaunchLazy, Grace: 50 * time.Millisecond, Timeout: time.Second},
+		launch: func(ctx context.Context) error {
+			launches.Add(1)
+			return nil
+		},
+		quit: func(ctx context.Context) error {
+			quits.Add(1)
+			return nil
+		},
+	}
+
+	group := NewPeerGroup(0, false)
+	group.Watch(l.peersChanged)
+
+	assert.False(l.Running())
+
+	first, second := &Peer{}, &Peer{}
+	assert.NoError(group.Add(first))
+	assert.NoError(group.Add(second))
+
+	assert.Eventually(func() bool { return launches.Load() == 1 }, time.Second, time.Millisecond)
+	assert.True(l.Running())
+
+	// a peer back within the grace period keeps the app
+	group.Remove(first)
+	group.Remove(second)
+	assert.NoError(group.Add(first))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(int32(0), quits.Load())
+	assert.Equal(int32(1), launches.Load())
+
+	group.Remove(first)
+	assert.Eventually(func() bool { return quits.Load() == 1 }, time.Second, time.Millisecond)
+	assert.False(l.Running())
+
+	// the next peer launches the app again
+	assert.NoError(group.Add(second))
+	assert.Eventually(func() bool { return launches.Load() == 2 }, time.Second, time.Millisecond)
+
+	// closing leaves the app running
+	group.Remove(second)
+	l.close()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(int32(1), quits.Load())
+}
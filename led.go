@@ -0,0 +1,105 @@
+package game
+
+import (
+	"context"
+	"sync"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+const ControlControllerLED ControlMessageType = "controller.led"
+
+// ControllerLED is the payload of controller.led: the light bar color the
+// game set on the peer's controller, e.g. for WebHID clients to set on a
+// DualSense.
+type ControllerLED struct {
+	R uint8 `json:"r"`
+	G uint8 `json:"g"`
+	B uint8 `json:"b"`
+}
+
+// controllerLEDs keeps the last color per controller number for peers
+// opening their control channel later.
+type controllerLEDs struct {
+	colors map[uint16]ControllerLED
+	sync.Mutex
+}
+
+func (leds *controllerLEDs) set(controller uint16, color ControllerLED) {
+	leds.Lock()
+	defer leds.Unlock()
+
+	if leds.colors == nil {
+		leds.colors = make(map[uint16]ControllerLED)
+	}
+
+	leds.colors[controller] = color
+}
+
+func (leds *controllerLEDs) get(controller uint16) (ControllerLED, bool) {
+	leds.Lock()
+	defer leds.Unlock()
+
+	color, ok := leds.colors[controller]
+	return color, ok
+}
+
+// forwardLEDs sends the colors the host sets to the peers of each
+// controller until ctx is done.
+func (svc *service) forwardLEDs(ctx context.Context, stream *Stream, leds <-chan nvstream.ControllerLED) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case led := <-leds:
+			color := ControllerLED{led.R, led.G, led.B}
+			stream.leds.set(led.Controller, color)
+
+			if stream.peers == nil {
+				continue
+			}
+
+			for _, peer := range stream.peers.Peers() {
+				if peer.controllerNumber() == led.Controller {
+					peer.sendLED(color)
+				}
+			}
+		}
+	}
+}
+
+// controllerNumber returns the number the NVStream host knows the peer's
+// controller by: guests own the controller of their slot, the others share
+// the first one.
+func (peer *Peer) controllerNumber() uint16 {
+	if pad, ok := peer.gamepad.(*moonlightGamepad); ok && pad.number >= 0 {
+		return uint16(pad.number)
+	}
+
+	return uint16(max(peer.slot-1, 0))
+}
+
+func (peer *Peer) sendLED(color ControllerLED) {
+	if !peer.perms.Has(PermissionGamepad) {
+		return
+	}
+
+	msg, err := NewControlMessage(ControlControllerLED, &color)
+	if err != nil {
+		return
+	}
+
+	peer.SendControl(msg)
+}
+
+// sendLastLED sends the color last set on the peer's controller, if any.
+func (peer *Peer) sendLastLED() {
+	if peer.stream == nil || peer.stream.leds == nil {
+		return
+	}
+
+	if color, ok := peer.stream.leds.get(peer.controllerNumber()); ok {
+		peer.sendLED(color)
+	}
+}
@@ -0,0 +1,45 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+func TestForwardLEDs(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &service{}
+	stream := &Stream{leds: new(controllerLEDs)}
+
+	leds := make(chan nvstream.ControllerLED)
+	go svc.forwardLEDs(ctx, stream, leds)
+
+	leds <- nvstream.ControllerLED{Controller: 1, R: 255, B: 64}
+
+	assert.Eventually(func() bool {
+		_, ok := stream.leds.get(1)
+		return ok
+	}, time.Second, time.Millisecond)
+
+	color, _ := stream.leds.get(1)
+	assert.Equal(ControllerLED{R: 255, B: 64}, color)
+
+	_, ok := stream.leds.get(0)
+	assert.False(ok)
+}
+
+func TestPeerControllerNumber(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(uint16(0), (&Peer{}).controllerNumber())
+	assert.Equal(uint16(2), (&Peer{slot: 3}).controllerNumber())
+	assert.Equal(uint16(5), (&Peer{gamepad: &moonlightGamepad{number: 5}}).controllerNumber())
+}
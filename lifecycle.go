@@ -0,0 +1,180 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const DefaultStopTimeout = 5 * time.Second
+
+var ErrStopTimeout = errors.New("stop timeout exceeded")
+
+type StopFunc func(ctx context.Context) error
+
+// NewLifecycle creates a lifecycle whose components share the given parent
+// context. Components are stopped in the reverse order they were added.
+func NewLifecycle(parent context.Context, log *zap.Logger) *Lifecycle {
+	ctx, cancel := context.WithCancel(parent)
+
+	return &Lifecycle{
+		log: log.With(
+			zap.String("component", "lifecycle"),
+		),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+type Lifecycle struct {
+	log        *zap.Logger
+	ctx        context.Context
+	cancel     context.CancelFunc
+	components []*component
+	stopped    bool
+	sync.Mutex
+}
+
+type component struct {
+	name    string
+	timeout time.Duration
+	cancel  context.CancelFunc
+	stop    StopFunc
+}
+
+// Add registers a component and returns its context, which is canceled
+// right before the component's stop hook runs. The hook may be nil when
+// canceling the context is enough, e.g. for listeners.
+func (lc *Lifecycle) Add(name string, timeout time.Duration, stop StopFunc) context.Context {
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+
+	ctx, cancel := context.WithCancel(lc.ctx)
+
+	lc.Lock()
+	defer lc.Unlock()
+
+	if lc.stopped {
+		cancel()
+		return ctx
+	}
+
+	lc.components = append(lc.components, &component{
+		name:    name,
+		timeout: timeout,
+		cancel:  cancel,
+		stop:    stop,
+	})
+
+	return ctx
+}
+
+// Stopped reports whether Stop has been called.
+func (lc *Lifecycle) Stopped() bool {
+	lc.Lock()
+	defer lc.Unlock()
+
+	return lc.stopped
+}
+
+// Stop stops the components one at a time, last added first. A component
+// that does not stop within its timeout is abandoned and reported with
+// ErrStopTimeout, so one stuck component cannot block the others. Calling
+// Stop again is a no-op.
+func (lc *Lifecycle) Stop(ctx context.Context) error {
+	lc.Lock()
+	if lc.stopped {
+		lc.Unlock()
+		return nil
+	}
+
+	lc.stopped = true
+	components := lc.components
+	lc.components = nil
+	lc.Unlock()
+
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+
+		log := lc.log.With(
+			zap.String("name", c.name),
+		)
+
+		start := time.Now()
+
+		if err := c.shutdown(ctx); err != nil {
+			log.Error("component stop failed", zap.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+			continue
+		}
+
+		log.Debug("component stopped", zap.Duration("elapsed", time.Since(start)))
+	}
+
+	lc.cancel()
+
+	return errors.Join(errs...)
+}
+
+// StopComponent stops the components of the given name, last added first,
+// and removes them, e.g. a stream stopped at runtime.
+func (lc *Lifecycle) StopComponent(ctx context.Context, name string) error {
+	lc.Lock()
+	var matched []*component
+	lc.components = slices.DeleteFunc(lc.components, func(c *component) bool {
+		if c.name != name {
+			return false
+		}
+
+		matched = append(matched, c)
+		return true
+	})
+	lc.Unlock()
+
+	var errs []error
+	for i := len(matched) - 1; i >= 0; i-- {
+		if err := matched[i].shutdown(ctx); err != nil {
+			lc.log.Error("component stop failed",
+				zap.String("name", name),
+				zap.Error(err))
+
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *component) shutdown(ctx context.Context) error {
+	c.cancel()
+
+	if c.stop == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.stop(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrStopTimeout
+		}
+
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,97 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestLifecycleStop(t *testing.T) {
+	assert := assert.New(t)
+
+	lc := NewLifecycle(context.Background(), zap.NewNop())
+
+	var order []string
+	hook := func(name string) StopFunc {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	lc.Add("gamepad", 0, hook("gamepad"))
+	var streamCtx context.Context
+	streamCtx = lc.Add("stream", 0, func(ctx context.Context) error {
+		assert.Error(streamCtx.Err()) // canceled before the hook runs
+		order = append(order, "stream")
+		return nil
+	})
+	lc.Add("listener", 0, nil)
+	lc.Add("peers", 0, hook("peers"))
+
+	assert.NoError(streamCtx.Err())
+
+	assert.NoError(lc.Stop(context.Background()))
+	assert.Equal([]string{"peers", "stream", "gamepad"}, order)
+	assert.True(lc.Stopped())
+
+	// Stop is idempotent and late components are canceled immediately.
+	assert.NoError(lc.Stop(context.Background()))
+	assert.Error(lc.Add("late", 0, nil).Err())
+}
+
+func TestLifecycleStopComponent(t *testing.T) {
+	assert := assert.New(t)
+
+	lc := NewLifecycle(context.Background(), zap.NewNop())
+
+	var order []string
+	hook := func(name string) StopFunc {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	lc.Add("stream.game", 0, hook("game"))
+	first := lc.Add("republish.game", 0, hook("republish 1"))
+	lc.Add("republish.game", 0, hook("republish 2"))
+	lc.Add("peers", 0, hook("peers"))
+
+	assert.NoError(lc.StopComponent(context.Background(), "republish.game"))
+	assert.Equal([]string{"republish 2", "republish 1"}, order)
+	assert.Error(first.Err())
+
+	order = nil
+	assert.NoError(lc.Stop(context.Background()))
+	assert.Equal([]string{"peers", "game"}, order)
+}
+
+func TestLifecycleStopTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	lc := NewLifecycle(context.Background(), zap.NewNop())
+
+	stopped := false
+	lc.Add("first", 0, func(ctx context.Context) error {
+		stopped = true
+		return nil
+	})
+
+	lc.Add("stuck", 10*time.Millisecond, func(ctx context.Context) error {
+		select {}
+	})
+
+	lc.Add("failing", 0, func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+
+	err := lc.Stop(context.Background())
+	assert.ErrorIs(err, ErrStopTimeout)
+	assert.ErrorContains(err, "failing: boom")
+	assert.True(stopped)
+}
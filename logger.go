@@ -0,0 +1,303 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+type LogFormat string
+
+const (
+	LogFormatConsole LogFormat = "console"
+	LogFormatJSON    LogFormat = "json"
+)
+
+// Logging configures the service's logs. Components, as named by the
+// component field of their logs, e.g. nvstream or nvstream.rtsp, may log at
+// a level of their own; the longest matching name wins.
+type Logging struct {
+	Level      zapcore.Level
+	Format     LogFormat
+	File       string // relative to the working directory; stderr if empty
+	MaxSizeMB  int64  // rotate the file after this size, 0 = never
+	MaxBackups int    // rotated files kept, 0 = all
+	Components map[string]zapcore.Level
+}
+
+func (cfg *Logging) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Level      string            `yaml:"level"`
+		Format     LogFormat         `yaml:"format"`
+		File       string            `yaml:"file"`
+		MaxSizeMB  int64             `yaml:"maxSizeMB"`
+		MaxBackups int               `yaml:"maxBackups"`
+		Components map[string]string `yaml:"components"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Level == "" {
+		raw.Level = "debug"
+	}
+
+	level, err := zapcore.ParseLevel(raw.Level)
+	if err != nil {
+		return err
+	}
+
+	if raw.Format == "" {
+		raw.Format = LogFormatConsole
+	}
+
+	switch raw.Format {
+	case LogFormatConsole, LogFormatJSON:
+	default:
+		return errors.New("invalid log format: " + string(raw.Format))
+	}
+
+	if raw.MaxSizeMB < 0 || raw.MaxBackups < 0 {
+		return errors.New("log rotation limits must not be negative")
+	}
+
+	components := make(map[string]zapcore.Level, len(raw.Components))
+	for component, l := range raw.Components {
+		level, err := zapcore.ParseLevel(l)
+		if err != nil {
+			return fmt.Errorf("component %s: %w", component, err)
+		}
+
+		components[component] = level
+	}
+
+	cfg.Level = level
+	cfg.Format = raw.Format
+	cfg.File = raw.File
+	cfg.MaxSizeMB = raw.MaxSizeMB
+	cfg.MaxBackups = raw.MaxBackups
+	cfg.Components = components
+
+	return nil
+}
+
+// NewLogger builds the logger of the config, writing files under path.
+// Without a config it logs as zap's development logger does.
+func NewLogger(cfg *Logging, path string) (*zap.Logger, error) {
+	if cfg == nil {
+		return zap.NewDevelopment()
+	}
+
+	var encoder zapcore.Encoder
+	switch cfg.Format {
+	case LogFormatJSON:
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	default:
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	}
+
+	out := zapcore.Lock(os.Stderr)
+
+	if cfg.File != "" {
+		name := cfg.File
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(path, name)
+		}
+
+		f, err := newRotatingFile(name, cfg.MaxSizeMB<<20, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+
+		out = f
+	}
+
+	core := &componentCore{
+		Core:       zapcore.NewCore(encoder, out, zapcore.DebugLevel),
+		level:      cfg.Level,
+		components: cfg.Components,
+	}
+
+	return zap.New(core,
+		zap.AddCaller(),
+		zap.AddStacktrace(zapcore.ErrorLevel),
+		zap.ErrorOutput(zapcore.Lock(os.Stderr)),
+	), nil
+}
+
+// componentCore filters entries by the level of the component a logger was
+// given with a component field, or by the default level.
+type componentCore struct {
+	zapcore.Core
+	level      zapcore.Level
+	components map[string]zapcore.Level
+}
+
+func (c *componentCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c *componentCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+
+	for _, f := range fields {
+		if f.Key == "component" && f.Type == zapcore.StringType {
+			clone.level = componentLevel(c.components, f.String, c.level)
+		}
+	}
+
+	return &clone
+}
+
+func (c *componentCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+
+	return ce
+}
+
+// componentLevel is the level of the longest name in components that is
+// the component or one of its parents, e.g. nvstream for nvstream.rtsp.
+func componentLevel(components map[string]zapcore.Level, component string, fallback zapcore.Level) zapcore.Level {
+	level, match := fallback, ""
+	for name, l := range components {
+		if name != component && !strings.HasPrefix(component, name+".") {
+			continue
+		}
+
+		if len(name) > len(match) {
+			level, match = l, name
+		}
+	}
+
+	return level
+}
+
+// rotatingFile is a log file that is renamed with a timestamp once it
+// reaches maxSize, keeping the newest maxBackups of the renamed files.
+type rotatingFile struct {
+	name       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+	sync.Mutex
+}
+
+func newRotatingFile(name string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+
+	r := &rotatingFile{
+		name:       name,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = info.Size()
+
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *rotatingFile) Sync() error {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.f.Sync()
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(r.name)
+	backup := strings.TrimSuffix(r.name, ext) +
+		time.Now().Format("-20060102T150405.000000000") + ext
+
+	if err := os.Rename(r.name, backup); err != nil {
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	return r.prune()
+}
+
+// prune removes the oldest rotated files beyond maxBackups.
+func (r *rotatingFile) prune() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(r.name)
+	backups, err := filepath.Glob(strings.TrimSuffix(r.name, ext) + "-[0-9]*" + ext)
+	if err != nil {
+		return err
+	}
+
+	// The timestamps sort by name.
+	slices.Sort(backups)
+
+	for len(backups) > r.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoggingConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *Logging
+	err := yaml.Unmarshal([]byte("{}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(zapcore.DebugLevel, cfg.Level)
+	assert.Equal(LogFormatConsole, cfg.Format)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("{level: info, format: json, components: {nvstream: warn}}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(zapcore.InfoLevel, cfg.Level)
+	assert.Equal(LogFormatJSON, cfg.Format)
+	assert.Equal(zapcore.WarnLevel, cfg.Components["nvstream"])
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("format: xml"), &cfg)
+	assert.ErrorContains(err, "invalid log format")
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("components: {nvstream: loud}"), &cfg)
+	assert.ErrorContains(err, "component nvstream")
+}
+
+func TestComponentLevels(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	log, err := NewLogger(&Logging{
+		Level:  zapcore.DebugLevel,
+		Format: LogFormatJSON,
+		File:   "game.log",
+		Components: map[string]zapcore.Level{
+			"nvstream":      zapcore.InfoLevel,
+			"nvstream.rtsp": zapcore.WarnLevel,
+		},
+	}, dir)
+
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	log.Debug("peer debug", zap.String("peer", "p1"))
+	log.With(zap.String("component", "nvstream.connection")).Debug("connection debug")
+	log.With(zap.String("component", "nvstream.connection")).Info("connection info")
+	log.With(zap.String("component", "nvstream.rtsp")).Info("rtsp info")
+	log.With(zap.String("component", "nvstreamer")).Debug("other debug")
+	log.Sync()
+
+	bs, err := os.ReadFile(filepath.Join(dir, "game.log"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	out := string(bs)
+	assert.Contains(out, "peer debug")
+	assert.NotContains(out, "connection debug")
+	assert.Contains(out, "connection info")
+	assert.NotContains(out, "rtsp info")
+	assert.Contains(out, "other debug")
+}
+
+func TestRotatingFile(t *testing.T) {
+	assert := assert.New(t)
+
+	name := filepath.Join(t.TempDir(), "game.log")
+
+	f, err := newRotatingFile(name, 10, 2)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	line := []byte("12345678\n")
+	for range 5 {
+		if _, err := f.Write(line); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+	}
+
+	bs, err := os.ReadFile(name)
+	assert.NoError(err)
+	assert.Equal(line, bs)
+
+	backups, err := filepath.Glob(strings.TrimSuffix(name, ".log") + "-*.log")
+	assert.NoError(err)
+	assert.LessOrEqual(len(backups), 2)
+	assert.NotEmpty(backups)
+}
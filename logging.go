@@ -1,8 +1,12 @@
 package game
 
 import (
+	"context"
+
 	"github.com/pion/webrtc/v4"
 	"go.uber.org/zap"
+
+	"github.com/flarexio/game/nvstream"
 )
 
 func LoggingMiddleware(log *zap.Logger) ServiceMiddleware {
@@ -22,13 +26,13 @@ type loggingMiddleware struct {
 	next Service
 }
 
-func (mw *loggingMiddleware) FindStream(name string) (*Stream, error) {
+func (mw *loggingMiddleware) FindStream(ctx context.Context, name string) (*Stream, error) {
 	log := mw.log.With(
 		zap.String("action", "find_stream"),
 		zap.String("stream", name),
 	)
 
-	stream, err := mw.next.FindStream(name)
+	stream, err := mw.next.FindStream(ctx, name)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
@@ -39,29 +43,35 @@ func (mw *loggingMiddleware) FindStream(name string) (*Stream, error) {
 	return stream, nil
 }
 
-func (mw *loggingMiddleware) ICEServers(provider ICEProvider) ([]webrtc.ICEServer, error) {
+func (mw *loggingMiddleware) ICEServers(ctx context.Context, provider ICEProvider) (*ICECredentials, error) {
 	log := mw.log.With(
 		zap.String("action", "ice_servers"),
 		zap.String("provider", provider.String()),
+		zap.String("request_id", RequestIDFrom(ctx)),
 	)
 
-	servers, err := mw.next.ICEServers(provider)
+	creds, err := mw.next.ICEServers(ctx, provider)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
 	}
 
-	log.Info("got servers", zap.Int("count", len(servers)))
-	return servers, nil
+	log.Info("got servers",
+		zap.Int("count", len(creds.Servers)),
+		zap.Time("expires_at", creds.ExpiresAt))
+
+	return creds, nil
 }
 
-func (mw *loggingMiddleware) AcceptPeer(offer webrtc.SessionDescription, reply string) (*Peer, error) {
+func (mw *loggingMiddleware) AcceptPeer(ctx context.Context, offer webrtc.SessionDescription, reply string, opts PeerOptions) (*Peer, error) {
 	log := mw.log.With(
 		zap.String("action", "accept_peer"),
 		zap.String("reply", reply),
+		zap.Stringer("permissions", opts.Permissions),
+		zap.String("request_id", RequestIDFrom(ctx)),
 	)
 
-	peer, err := mw.next.AcceptPeer(offer, reply)
+	peer, err := mw.next.AcceptPeer(ctx, offer, reply, opts)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
@@ -72,6 +82,300 @@ func (mw *loggingMiddleware) AcceptPeer(offer webrtc.SessionDescription, reply s
 	return peer, nil
 }
 
+func (mw *loggingMiddleware) Snapshot(ctx context.Context, name string, opts SnapshotOptions) (*Snapshot, error) {
+	log := mw.log.With(
+		zap.String("action", "snapshot"),
+		zap.String("stream", name),
+		zap.String("format", string(opts.Format)),
+	)
+
+	snapshot, err := mw.next.Snapshot(ctx, name, opts)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Info("snapshot taken", zap.Int("size", len(snapshot.Data)))
+
+	return snapshot, nil
+}
+
+func (mw *loggingMiddleware) DescribeStreams(ctx context.Context) ([]*StreamManifest, error) {
+	log := mw.log.With(
+		zap.String("action", "describe_streams"),
+	)
+
+	manifests, err := mw.next.DescribeStreams(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("streams described", zap.Int("streams", len(manifests)))
+
+	return manifests, nil
+}
+
+func (mw *loggingMiddleware) AddStream(ctx context.Context, stream *Stream) (*StreamManifest, error) {
+	log := mw.log.With(
+		zap.String("action", "add_stream"),
+		zap.String("stream", stream.Name),
+		zap.String("transport", string(stream.Transport)),
+	)
+
+	manifest, err := mw.next.AddStream(ctx, stream)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Info("stream added", zap.String("status", string(manifest.Status)))
+
+	return manifest, nil
+}
+
+func (mw *loggingMiddleware) RemoveStream(ctx context.Context, name string) error {
+	log := mw.log.With(
+		zap.String("action", "remove_stream"),
+		zap.String("stream", name),
+	)
+
+	if err := mw.next.RemoveStream(ctx, name); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("stream removed")
+
+	return nil
+}
+
+func (mw *loggingMiddleware) StartStream(ctx context.Context, name string) error {
+	log := mw.log.With(
+		zap.String("action", "start_stream"),
+		zap.String("stream", name),
+	)
+
+	if err := mw.next.StartStream(ctx, name); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("stream started")
+
+	return nil
+}
+
+func (mw *loggingMiddleware) StopStream(ctx context.Context, name string) error {
+	log := mw.log.With(
+		zap.String("action", "stop_stream"),
+		zap.String("stream", name),
+	)
+
+	if err := mw.next.StopStream(ctx, name); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("stream stopped")
+
+	return nil
+}
+
+func (mw *loggingMiddleware) CreateGuestToken(ctx context.Context, opts GuestOptions) (*GuestToken, error) {
+	log := mw.log.With(
+		zap.String("action", "create_guest_token"),
+		zap.String("stream", opts.Stream),
+		zap.String("role", string(opts.Role)),
+		zap.Int("slot", opts.Slot),
+		zap.Duration("ttl", opts.TTL),
+	)
+
+	token, err := mw.next.CreateGuestToken(ctx, opts)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Info("guest token created",
+		zap.String("guest", token.ID),
+		zap.Time("expires_at", token.ExpiresAt))
+
+	return token, nil
+}
+
+func (mw *loggingMiddleware) RevokeGuestToken(ctx context.Context, id string) error {
+	log := mw.log.With(
+		zap.String("action", "revoke_guest_token"),
+		zap.String("guest", id),
+	)
+
+	if err := mw.next.RevokeGuestToken(ctx, id); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("guest token revoked")
+
+	return nil
+}
+
+func (mw *loggingMiddleware) Pair(ctx context.Context, name string, reply string) (*PairResult, error) {
+	log := mw.log.With(
+		zap.String("action", "pair"),
+		zap.String("stream", name),
+		zap.String("reply", reply),
+	)
+
+	result, err := mw.next.Pair(ctx, name, reply)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	if result.State != nvstream.PairStatePaired {
+		log.Warn("pairing failed",
+			zap.Stringer("state", result.State),
+			zap.String("reason", result.Reason))
+		return result, nil
+	}
+
+	log.Info("paired")
+
+	return result, nil
+}
+
+func (mw *loggingMiddleware) Apps(ctx context.Context, name string) ([]*App, error) {
+	log := mw.log.With(
+		zap.String("action", "apps"),
+		zap.String("stream", name),
+	)
+
+	apps, err := mw.next.Apps(ctx, name)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("apps listed", zap.Int("apps", len(apps)))
+
+	return apps, nil
+}
+
+func (mw *loggingMiddleware) AppBoxArt(ctx context.Context, name string, appID int) ([]byte, error) {
+	log := mw.log.With(
+		zap.String("action", "app_box_art"),
+		zap.String("stream", name),
+		zap.Int("app", appID),
+	)
+
+	data, err := mw.next.AppBoxArt(ctx, name, appID)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("box art fetched", zap.Int("size", len(data)))
+
+	return data, nil
+}
+
+func (mw *loggingMiddleware) InputStats(ctx context.Context) ([]LatencyStats, error) {
+	log := mw.log.With(
+		zap.String("action", "input_stats"),
+	)
+
+	stats, err := mw.next.InputStats(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("input stats collected", zap.Int("controllers", len(stats)))
+
+	return stats, nil
+}
+
+func (mw *loggingMiddleware) ChannelStats(ctx context.Context) ([]LabelStats, error) {
+	log := mw.log.With(
+		zap.String("action", "channel_stats"),
+	)
+
+	stats, err := mw.next.ChannelStats(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("channel stats collected", zap.Int("labels", len(stats)))
+
+	return stats, nil
+}
+
+func (mw *loggingMiddleware) ListPeers(ctx context.Context) ([]*PeerInfo, error) {
+	log := mw.log.With(
+		zap.String("action", "list_peers"),
+	)
+
+	peers, err := mw.next.ListPeers(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("peers listed", zap.Int("peers", len(peers)))
+
+	return peers, nil
+}
+
+func (mw *loggingMiddleware) PeerLatency(ctx context.Context) ([]PeerLatencyStats, error) {
+	log := mw.log.With(
+		zap.String("action", "peer_latency"),
+	)
+
+	stats, err := mw.next.PeerLatency(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("peer latency listed", zap.Int("peers", len(stats)))
+
+	return stats, nil
+}
+
+func (mw *loggingMiddleware) NVStreamStats(ctx context.Context) ([]NVStreamStats, error) {
+	log := mw.log.With(
+		zap.String("action", "nvstream_stats"),
+	)
+
+	stats, err := mw.next.NVStreamStats(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("nvstream stats collected", zap.Int("streams", len(stats)))
+
+	return stats, nil
+}
+
+func (mw *loggingMiddleware) Health(ctx context.Context) (*Health, error) {
+	log := mw.log.With(
+		zap.String("action", "health"),
+	)
+
+	health, err := mw.next.Health(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
+	log.Debug("health checked", zap.Bool("ready", health.Ready))
+
+	return health, nil
+}
+
 func (mw *loggingMiddleware) Close() error {
 	log := mw.log.With(
 		zap.String("action", "close"),
@@ -0,0 +1,622 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Management lets operators add, remove, start and stop streams at runtime
+// over the streams endpoints, without restarting the service.
+type Management struct {
+	Enabled bool `yaml:"enabled"`
+	Persist bool `yaml:"persist"` // writes the changes to config.yaml
+}
+
+var (
+	ErrManagementDisabled = errors.New("stream management disabled")
+	ErrStreamExists       = errors.New("stream already exists")
+)
+
+var errNoStreamSpec = errors.New("stream not defined in config")
+
+// StreamSpec is a stream specified through the API, with the keys of a
+// stream in config.yaml besides those that act on the host: hooks, which
+// run commands, ffmpeg paths, republishing, which sends the stream
+// anywhere, and secret references, which are resolved only in the config
+// file. Tracks listen on TCP or UDP ports only. Unknown keys are refused.
+type StreamSpec struct {
+	Name       string        `yaml:"name"`
+	Transport  Transport     `yaml:"transport"`
+	Origins    []string      `yaml:"origins,omitempty"`
+	NVStream   yaml.Node     `yaml:"nvstream,omitempty"`
+	Sunshine   *SunshineSpec `yaml:"sunshine,omitempty"`
+	Video      *VideoSpec    `yaml:"video,omitempty"`
+	Variants   []*VideoSpec  `yaml:"variants,omitempty"`
+	Renditions []*VideoSpec  `yaml:"renditions,omitempty"`
+	Audio      *AudioSpec    `yaml:"audio,omitempty"`
+
+	CodecPreference []Codec `yaml:"codecPreference,omitempty"`
+
+	MaxPeers            int  `yaml:"maxPeers,omitempty"`
+	ExclusiveController bool `yaml:"exclusiveController,omitempty"`
+
+	Watchdog yaml.Node `yaml:"watchdog,omitempty"`
+	Quality  yaml.Node `yaml:"quality,omitempty"`
+	Preview  yaml.Node `yaml:"preview,omitempty"`
+	Pacing   yaml.Node `yaml:"pacing,omitempty"`
+	Launch   yaml.Node `yaml:"launch,omitempty"`
+	Idle     yaml.Node `yaml:"idle,omitempty"`
+
+	TargetLatencyMs *int `yaml:"targetLatencyMs,omitempty"`
+	Disabled        bool `yaml:"disabled,omitempty"`
+}
+
+// SunshineSpec is the Sunshine web UI of a stream specified through the
+// API. The credentials are taken as they are.
+type SunshineSpec struct {
+	URL                string `yaml:"url"`
+	Username           string `yaml:"username,omitempty"`
+	Password           string `yaml:"password,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
+}
+
+// VideoSpec is a video track of a stream specified through the API.
+type VideoSpec struct {
+	Name    string  `yaml:"name,omitempty"`
+	Address string  `yaml:"address,omitempty"`
+	Codec   Codec   `yaml:"codec,omitempty"`
+	FPS     float64 `yaml:"fps,omitempty"`
+}
+
+// AudioSpec is the audio track of a stream specified through the API.
+// AAC sources are transcoded by the ffmpeg of the config.
+type AudioSpec struct {
+	Address       string        `yaml:"address,omitempty"`
+	Codec         Codec         `yaml:"codec,omitempty"`
+	Container     Container     `yaml:"container,omitempty"`
+	FrameDuration time.Duration `yaml:"frameDuration,omitempty"`
+	Bitrate       int           `yaml:"bitrate,omitempty"`
+	DTX           bool          `yaml:"dtx,omitempty"`
+	FEC           *bool         `yaml:"fec,omitempty"`
+}
+
+// ParseStreamSpec parses a stream spec from YAML or JSON.
+func ParseStreamSpec(data []byte) (*StreamSpec, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var spec *StreamSpec
+	if err := dec.Decode(&spec); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("stream not specified")
+		}
+
+		return nil, err
+	}
+
+	if spec == nil {
+		return nil, errors.New("stream not specified")
+	}
+
+	return spec, nil
+}
+
+// specNetworks are the networks the tracks of a stream specified through
+// the API may listen on. Unix sockets would create files on the host.
+var specNetworks = []string{"tcp", "tcp4", "tcp6", "udp", "udp4", "udp6"}
+
+// checkAddress tells whether a track specified through the API may listen
+// on the address: a port of a network in specNetworks.
+func (spec *StreamSpec) checkAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(specNetworks, u.Scheme) {
+		return fmt.Errorf("address scheme unsupported in stream specs: %s: %q", spec.Name, u.Scheme)
+	}
+
+	if u.Port() == "" || u.User != nil || u.Path != "" || u.RawQuery != "" {
+		return fmt.Errorf("address must be a host and port in stream specs: %s: %s", spec.Name, address)
+	}
+
+	return nil
+}
+
+// Stream returns the stream of the spec. Secret references are refused
+// rather than resolved.
+func (spec *StreamSpec) Stream() (*Stream, error) {
+	var addresses []string
+	for _, video := range append([]*VideoSpec{spec.Video}, append(spec.Variants, spec.Renditions...)...) {
+		if video != nil {
+			addresses = append(addresses, video.Address)
+		}
+	}
+
+	if audio := spec.Audio; audio != nil {
+		addresses = append(addresses, audio.Address)
+	}
+
+	for _, address := range addresses {
+		if err := spec.checkAddress(address); err != nil {
+			return nil, err
+		}
+	}
+
+	if sunshine := spec.Sunshine; sunshine != nil {
+		for _, value := range []string{sunshine.Username, sunshine.Password} {
+			if isSecretReference(value) {
+				return nil, errors.New("secret references unsupported in stream specs: " + spec.Name)
+			}
+		}
+	}
+
+	var node yaml.Node
+	if err := node.Encode(spec); err != nil {
+		return nil, err
+	}
+
+	var stream *Stream
+	if err := node.Decode(&stream); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// streamList returns the running streams.
+func (svc *service) streamList() []*Stream {
+	svc.RLock()
+	defer svc.RUnlock()
+
+	streams := make([]*Stream, 0, len(svc.streams))
+	for _, stream := range svc.streams {
+		streams = append(streams, stream)
+	}
+
+	return streams
+}
+
+// stoppedList returns the streams configured but not running.
+func (svc *service) stoppedList() []*Stream {
+	svc.RLock()
+	defer svc.RUnlock()
+
+	streams := make([]*Stream, 0, len(svc.stopped))
+	for _, stream := range svc.stopped {
+		streams = append(streams, stream)
+	}
+
+	return streams
+}
+
+func (svc *service) hasStream(name string) bool {
+	svc.RLock()
+	defer svc.RUnlock()
+
+	_, running := svc.streams[name]
+	_, stopped := svc.stopped[name]
+	return running || stopped
+}
+
+func (svc *service) manageable() error {
+	if m := svc.cfg.Management; m == nil || !m.Enabled {
+		return ErrManagementDisabled
+	}
+
+	return nil
+}
+
+// AddStream adds a stream and starts it unless it is disabled.
+func (svc *service) AddStream(ctx context.Context, stream *Stream) (*StreamManifest, error) {
+	if err := svc.manageable(); err != nil {
+		return nil, err
+	}
+
+	if stream.Name == "" {
+		return nil, errors.New("stream name not specified")
+	}
+
+	svc.manage.Lock()
+	defer svc.manage.Unlock()
+
+	if svc.hasStream(stream.Name) {
+		return nil, fmt.Errorf("%w: %s", ErrStreamExists, stream.Name)
+	}
+
+	if stream.Disabled {
+		svc.Lock()
+		svc.stopped[stream.Name] = stream
+		svc.Unlock()
+	} else if err := svc.startStream(ctx, stream); err != nil {
+		return nil, err
+	}
+
+	svc.Lock()
+	svc.order = append(svc.order, stream.Name)
+	svc.Unlock()
+
+	if err := svc.persistStreams(); err != nil {
+		return nil, err
+	}
+
+	manifests, err := svc.DescribeStreams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i := slices.IndexFunc(manifests, func(m *StreamManifest) bool {
+		return m.Name == stream.Name
+	})
+
+	if i < 0 {
+		return nil, ErrStreamNotFound
+	}
+
+	return manifests[i], nil
+}
+
+// RemoveStream stops a stream, disconnecting its peers, and removes it.
+func (svc *service) RemoveStream(ctx context.Context, name string) error {
+	if err := svc.manageable(); err != nil {
+		return err
+	}
+
+	svc.manage.Lock()
+	defer svc.manage.Unlock()
+
+	if !svc.hasStream(name) {
+		return ErrStreamNotFound
+	}
+
+	if stream, err := svc.FindStream(ctx, name); err == nil {
+		svc.shutdownStream(stream)
+	}
+
+	svc.Lock()
+	delete(svc.streams, name)
+	delete(svc.stopped, name)
+	svc.order = slices.DeleteFunc(svc.order, func(s string) bool {
+		return s == name
+	})
+	svc.Unlock()
+
+	return svc.persistStreams()
+}
+
+// StartStream starts a stopped stream as configured.
+func (svc *service) StartStream(ctx context.Context, name string) error {
+	if err := svc.manageable(); err != nil {
+		return err
+	}
+
+	svc.manage.Lock()
+	defer svc.manage.Unlock()
+
+	svc.RLock()
+	_, running := svc.streams[name]
+	stopped, ok := svc.stopped[name]
+	svc.RUnlock()
+
+	if running {
+		return nil
+	}
+
+	if !ok {
+		return ErrStreamNotFound
+	}
+
+	if stopped.spec == nil {
+		return fmt.Errorf("%w: %s", errNoStreamSpec, name)
+	}
+
+	// A stopped stream's tracks keep their sinks, so it starts afresh.
+	stream := new(Stream)
+	if err := stopped.spec.Decode(stream); err != nil {
+		return err
+	}
+
+	stream.Disabled = false
+
+	if err := svc.startStream(ctx, stream); err != nil {
+		return err
+	}
+
+	svc.Lock()
+	delete(svc.stopped, name)
+	svc.Unlock()
+
+	setSpecDisabled(stream.spec, false)
+
+	return svc.persistStreams()
+}
+
+// StopStream stops a running stream, disconnecting its peers. It remains
+// configured, disabled.
+func (svc *service) StopStream(ctx context.Context, name string) error {
+	if err := svc.manageable(); err != nil {
+		return err
+	}
+
+	svc.manage.Lock()
+	defer svc.manage.Unlock()
+
+	stream, err := svc.FindStream(ctx, name)
+	if err != nil {
+		if svc.hasStream(name) {
+			return nil
+		}
+
+		return err
+	}
+
+	svc.shutdownStream(stream)
+
+	stream.Disabled = true
+
+	svc.Lock()
+	delete(svc.streams, name)
+	svc.stopped[name] = stream
+	svc.Unlock()
+
+	setSpecDisabled(stream.spec, true)
+
+	return svc.persistStreams()
+}
+
+// startStream builds a stream with its recorder and republishers, and adds
+// it to the running streams. On failure what was started is stopped.
+func (svc *service) startStream(ctx context.Context, stream *Stream) error {
+	err := svc.buildStream(ctx, stream)
+
+	if rec := svc.cfg.Recordings; err == nil && rec != nil && rec.Enabled {
+		err = svc.buildRecorder(rec, stream)
+	}
+
+	if err == nil {
+		err = svc.buildRepublisher(stream)
+	}
+
+	if err != nil {
+		svc.stopComponents(stream)
+		return err
+	}
+
+	svc.Lock()
+	svc.streams[stream.Name] = stream
+	svc.Unlock()
+
+	svc.log.Info("stream started",
+		zap.String("stream", stream.Name),
+		zap.String("transport", string(stream.Transport)))
+
+	return nil
+}
+
+// shutdownStream closes the stream's peers and stops its components.
+func (svc *service) shutdownStream(stream *Stream) {
+	if stream.peers != nil {
+		for _, peer := range stream.peers.Peers() {
+			peer.Close()
+		}
+	}
+
+	svc.stopComponents(stream)
+
+	svc.events.Publish(EventStreamStopped, &StreamEvent{
+		Stream:    stream.Name,
+		Transport: stream.Transport,
+	})
+
+	svc.log.Info("stream stopped", zap.String("stream", stream.Name))
+}
+
+func (svc *service) stopComponents(stream *Stream) {
+	ctx := context.Background()
+
+	for _, name := range []string{"republish.", "recorder.", "stream."} {
+		if err := svc.lifecycle.StopComponent(ctx, name+stream.Name); err != nil {
+			svc.log.Error("stream stop failed",
+				zap.String("stream", stream.Name),
+				zap.Error(err))
+		}
+	}
+}
+
+// persistStreams writes the streams to config.yaml, keeping the rest of
+// the file and the streams' comments.
+func (svc *service) persistStreams() error {
+	if m := svc.cfg.Management; m == nil || !m.Persist {
+		return nil
+	}
+
+	svc.RLock()
+	specs := make([]*yaml.Node, 0, len(svc.order))
+	for _, name := range svc.order {
+		stream, ok := svc.streams[name]
+		if !ok {
+			stream, ok = svc.stopped[name]
+		}
+
+		if ok && stream.spec != nil {
+			specs = append(specs, stream.spec)
+		}
+	}
+	svc.RUnlock()
+
+	path := filepath.Join(svc.cfg.Path, "config.yaml")
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	bs, err = replaceStreams(bs, specs)
+	if err != nil {
+		return err
+	}
+
+	// Replaced at once, so a crash cannot leave half a config.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bs, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// replaceStreams replaces the streams of a config with the given specs. The
+// specs are of ConfigVersion, so the rest of the config is migrated to it
+// and the config written as of that version.
+func replaceStreams(config []byte, specs []*yaml.Node) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(config, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("invalid config")
+	}
+
+	// An empty config may have been written as {}.
+	root.Style &^= yaml.FlowStyle
+
+	version, err := configVersion(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := migrateConfig(root, version); err != nil {
+		return nil, err
+	}
+
+	setConfigVersion(root)
+
+	for _, spec := range specs {
+		blockStyle(spec)
+	}
+
+	streams := &yaml.Node{
+		Kind:    yaml.SequenceNode,
+		Tag:     "!!seq",
+		Content: specs,
+	}
+
+	i := slices.IndexFunc(root.Content, func(n *yaml.Node) bool {
+		return n.Kind == yaml.ScalarNode && n.Value == "streams"
+	})
+
+	// Keys and values alternate in a mapping.
+	if i >= 0 && i%2 == 0 {
+		root.Content[i+1] = streams
+	} else {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "streams"},
+			streams,
+		)
+	}
+
+	return encodeYAML(&doc)
+}
+
+// setConfigVersion sets the version of a config mapping to ConfigVersion,
+// adding it first if absent.
+func setConfigVersion(root *yaml.Node) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(ConfigVersion)}
+
+	if i := slices.IndexFunc(root.Content, func(n *yaml.Node) bool {
+		return n.Kind == yaml.ScalarNode && n.Value == "version"
+	}); i >= 0 && i%2 == 0 {
+		root.Content[i+1] = value
+		return
+	}
+
+	root.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+		value,
+	}, root.Content...)
+}
+
+func encodeYAML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// blockStyle writes a spec sent as JSON like the rest of config.yaml.
+func blockStyle(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		n.Style &^= yaml.FlowStyle
+	case yaml.ScalarNode:
+		n.Style &^= yaml.DoubleQuotedStyle
+	}
+
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// setSpecDisabled sets the disabled key of a stream's spec, or removes it.
+func setSpecDisabled(spec *yaml.Node, disabled bool) {
+	if spec == nil || spec.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(spec.Content); i += 2 {
+		if spec.Content[i].Value != "disabled" {
+			continue
+		}
+
+		if disabled {
+			spec.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"}
+		} else {
+			spec.Content = slices.Delete(spec.Content, i, i+2)
+		}
+
+		return
+	}
+
+	if disabled {
+		spec.Content = append(spec.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "disabled"},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"},
+		)
+	}
+}
@@ -0,0 +1,395 @@
+package game
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestReplaceStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	config := []byte(`webrtc:
+  iceServers:
+    - provider: google
+streams:
+  # the console
+  - name: game
+    transport: demo
+`)
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(config, &doc); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	game := doc.Content[0].Content[3].Content[0]
+
+	var camera yaml.Node
+	if err := yaml.Unmarshal([]byte(`{"name": "1080", "transport": "demo", "maxPeers": 2, "video": {"codec": "h264"}}`), &camera); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	setSpecDisabled(camera.Content[0], true)
+
+	bs, err := replaceStreams(config, []*yaml.Node{game, camera.Content[0]})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// The config of version 1 is written as of the current version.
+	assert.Equal(`version: 2
+webrtc:
+  iceServers:
+    - provider: google
+streams:
+  # the console
+  - name: game
+    transport: demo
+  - name: "1080"
+    transport: demo
+    maxPeers: 2
+    video:
+      codec: h264
+    disabled: true
+access:
+  permissions: all
+`, string(bs))
+
+	setSpecDisabled(camera.Content[0], false)
+
+	bs, err = replaceStreams([]byte("{}\n"), []*yaml.Node{camera.Content[0]})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.NotContains(string(bs), "disabled")
+	assert.Contains(string(bs), "streams:\n  - name: \"1080\"\n")
+	assert.True(strings.HasPrefix(string(bs), "version: 2\n"))
+
+	bs, err = replaceStreams([]byte("version: 2\nstreams: []\n"), nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("version: 2\nstreams: []\n", string(bs))
+}
+
+func TestStreamManagement(t *testing.T) {
+	assert := assert.New(t)
+
+	nc, err := nats.Connect(runNATSServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	newGamepad = func() (Gamepad, error) { return &testGamepad{}, nil }
+	t.Cleanup(func() { newGamepad = NewGamepad })
+
+	dir := t.TempDir()
+	config := []byte(`management:
+  enabled: true
+  persist: true
+streams:
+  - name: game
+    transport: demo
+    video:
+      codec: h264
+    disabled: true
+`)
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), config, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg *Config
+	if err := yaml.Unmarshal(config, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Path = dir
+
+	svc, err := NewService(cfg, nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Close() })
+
+	persisted := func() string {
+		bs, err := os.ReadFile(filepath.Join(dir, "config.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(bs)
+	}
+
+	manifests, err := svc.DescribeStreams(context.Background())
+	if assert.NoError(err) && assert.Len(manifests, 1) {
+		assert.Equal(StreamStatusStopped, manifests[0].Status)
+	}
+
+	_, err = svc.FindStream(context.Background(), "game")
+	assert.ErrorIs(err, ErrStreamNotFound)
+
+	assert.NoError(svc.StartStream(context.Background(), "game"))
+	assert.NotContains(persisted(), "disabled")
+
+	stream, err := svc.FindStream(context.Background(), "game")
+	if assert.NoError(err) {
+		assert.NotNil(stream.Video.Track())
+	}
+
+	tone, err := ParseStreamSpec([]byte(`{"name": "tone", "transport": "demo", "audio": {"codec": "pcmu"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := tone.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := svc.AddStream(context.Background(), spec)
+	if assert.NoError(err) {
+		assert.Equal("tone", manifest.Name)
+		assert.NotEqual(StreamStatusStopped, manifest.Status)
+	}
+
+	_, err = svc.AddStream(context.Background(), spec)
+	assert.ErrorIs(err, ErrStreamExists)
+	assert.Contains(persisted(), "  - name: tone\n")
+
+	assert.NoError(svc.StopStream(context.Background(), "game"))
+	assert.Contains(persisted(), "disabled: true")
+
+	_, err = svc.FindStream(context.Background(), "game")
+	assert.ErrorIs(err, ErrStreamNotFound)
+
+	// started again from its spec
+	assert.NoError(svc.StartStream(context.Background(), "game"))
+	_, err = svc.FindStream(context.Background(), "game")
+	assert.NoError(err)
+
+	assert.NoError(svc.RemoveStream(context.Background(), "tone"))
+	assert.NotContains(persisted(), "tone")
+	assert.ErrorIs(svc.RemoveStream(context.Background(), "tone"), ErrStreamNotFound)
+	assert.ErrorIs(svc.StopStream(context.Background(), "tone"), ErrStreamNotFound)
+
+	manifests, err = svc.DescribeStreams(context.Background())
+	if assert.NoError(err) {
+		assert.Len(manifests, 1)
+	}
+}
+
+func TestVideoOnlyStreamPeer(t *testing.T) {
+	assert := assert.New(t)
+
+	nc, err := nats.Connect(runNATSServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	newGamepad = func() (Gamepad, error) { return &testGamepad{}, nil }
+	t.Cleanup(func() { newGamepad = NewGamepad })
+
+	var cfg *Config
+	err = yaml.Unmarshal([]byte(`
+webrtc:
+  iceServers:
+  - provider: google
+management:
+  enabled: true
+`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Path = t.TempDir()
+
+	svc, err := NewService(cfg, nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Close() })
+
+	spec, err := ParseStreamSpec([]byte(`{"name": "silent", "transport": "demo", "video": {"codec": "h264"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := spec.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.AddStream(context.Background(), stream); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	defer client.Close()
+
+	recvonly := webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}
+	client.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, recvonly)
+	client.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, recvonly)
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	peer, err := svc.AcceptPeer(context.Background(), offer, "peers.negotiation.silent", PeerOptions{
+		Stream:      "silent",
+		Permissions: PermissionNone,
+	})
+
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	defer peer.Close()
+
+	var kinds []webrtc.RTPCodecType
+	for _, sender := range peer.GetSenders() {
+		if track := sender.Track(); track != nil {
+			kinds = append(kinds, track.Kind())
+		}
+	}
+
+	assert.Equal([]webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo}, kinds)
+}
+
+func TestStreamSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv("GAME_TEST_SECRET", "secret")
+
+	spec, err := ParseStreamSpec([]byte(`name: game
+transport: nvstream
+origins:
+  - tcp://192.168.1.10
+sunshine:
+  url: https://192.168.1.10:47990
+  username: admin
+  password: hunter2
+audio:
+  codec: opus
+  dtx: true
+`))
+
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	stream, err := spec.Stream()
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("game", stream.Name)
+	assert.Equal("hunter2", stream.Sunshine.Password)
+	assert.Contains(stream.Audio.Fmtp(), "usedtx=1")
+
+	// what would act on the host is refused
+	for _, data := range []string{
+		"name: game\ntransport: raw\nhooks:\n  keyframe: [touch, /tmp/pwned]\n",
+		"name: game\ntransport: raw\naudio:\n  codec: aac\n  ffmpeg: /tmp/pwned\n",
+		"name: game\ntransport: raw\nunknown: true\n",
+		"name: game\ntransport: nvstream\naddress: https://192.168.1.10:47984\n",
+		"name: game\ntransport: raw\nrepublish:\n  - url: srt://203.0.113.1:9000\n",
+		"name: game\ntransport: raw\nvideo:\n  codec: h264\n  ffmpeg: /tmp/pwned\n",
+	} {
+		_, err := ParseStreamSpec([]byte(data))
+		assert.Error(err, data)
+	}
+
+	// tracks listen on TCP and UDP ports only
+	for _, address := range []string{
+		"unix:///var/run/docker.sock",
+		"tcp://:3003/path",
+		"tcp://127.0.0.1",
+		"file:///etc/passwd",
+	} {
+		for _, data := range []string{
+			`{"name": "game", "transport": "raw", "video": {"codec": "h264", "address": "` + address + `"}}`,
+			`{"name": "game", "transport": "raw", "renditions": [{"codec": "h264", "address": "` + address + `"}]}`,
+			`{"name": "game", "transport": "raw", "audio": {"codec": "opus", "address": "` + address + `"}}`,
+		} {
+			spec, err := ParseStreamSpec([]byte(data))
+			if err != nil {
+				assert.Fail(err.Error())
+				return
+			}
+
+			_, err = spec.Stream()
+			assert.ErrorContains(err, "stream specs", data)
+		}
+	}
+
+	spec, err = ParseStreamSpec([]byte(`{"name": "camera", "transport": "raw",
+  "video": {"codec": "h264", "address": "tcp://:3003", "fps": 60},
+  "variants": [{"codec": "h265", "address": "udp://127.0.0.1:3004"}]}`))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	stream, err = spec.Stream()
+	if assert.NoError(err) {
+		assert.Equal("tcp", stream.Video.Address().Scheme)
+		assert.Equal(60.0, stream.Video.FPS())
+		assert.Len(stream.Variants, 1)
+	}
+
+	for _, password := range []string{
+		"file:/etc/shadow",
+		"vault:secret/data/game#password",
+		"${GAME_TEST_SECRET}",
+		"prefix-${GAME_TEST_SECRET}",
+	} {
+		spec := &StreamSpec{
+			Name:      "game",
+			Transport: TransportNV,
+			Sunshine:  &SunshineSpec{URL: "https://192.168.1.10:47990", Password: password},
+		}
+
+		_, err := spec.Stream()
+		assert.ErrorContains(err, "secret references unsupported", password)
+	}
+}
+
+func TestStreamManagementDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := &service{cfg: new(Config)}
+
+	_, err := svc.AddStream(context.Background(), &Stream{Name: "game"})
+	assert.ErrorIs(err, ErrManagementDisabled)
+	assert.ErrorIs(svc.StopStream(context.Background(), "game"), ErrManagementDisabled)
+	assert.Equal("403", errorCode(err))
+}
@@ -0,0 +1,159 @@
+package game
+
+import (
+	"slices"
+	"time"
+
+	"github.com/flarexio/game/nvstream"
+)
+
+// StreamManifest describes what a stream offers, so clients can build
+// their UI without assumptions about the host.
+type StreamManifest struct {
+	Name                string         `json:"name"`
+	Transport           Transport      `json:"transport"`
+	Live                bool           `json:"live"`
+	Status              StreamStatus   `json:"status"`
+	Video               *VideoManifest `json:"video,omitempty"`
+	Audio               *AudioManifest `json:"audio,omitempty"`
+	Inputs              []string       `json:"inputs"`
+	Snapshots           bool           `json:"snapshots"`
+	MaxPeers            int            `json:"max_peers"`
+	Peers               int            `json:"peers"`
+	ExclusiveController bool           `json:"exclusive_controller"`
+}
+
+// StreamStatus tells what a stream's source is doing.
+type StreamStatus string
+
+const (
+	StreamStatusLive       StreamStatus = "live"
+	StreamStatusConnecting StreamStatus = "connecting" // not listening or connected yet
+	StreamStatusStalled    StreamStatus = "stalled"
+	StreamStatusDown       StreamStatus = "down" // the NVStream connection ended
+	StreamStatusStopped    StreamStatus = "stopped"
+	StreamStatusStandby    StreamStatus = "standby" // launched when a peer connects
+)
+
+// StreamSummary is the short form of a manifest listed by streams.list.
+type StreamSummary struct {
+	Name      string       `json:"name"`
+	Transport Transport    `json:"transport"`
+	Status    StreamStatus `json:"status"`
+	Codecs    []Codec      `json:"codecs"` // video first
+	Peers     int          `json:"peers"`
+	MaxPeers  int          `json:"max_peers"`
+}
+
+type VideoManifest struct {
+	Codec  Codec   `json:"codec"`
+	Codecs []Codec `json:"codecs,omitempty"` // offered to peers, most preferred first
+
+	// Renditions name the lower qualities a peer may switch to.
+	Renditions []string `json:"renditions,omitempty"`
+	Width      int      `json:"width,omitempty"`
+	Height     int      `json:"height,omitempty"`
+	FPS        float64  `json:"fps,omitempty"`
+}
+
+type AudioManifest struct {
+	Codec    Codec `json:"codec"`
+	Channels int   `json:"channels,omitempty"`
+}
+
+// Manifest describes the stream. Inputs lists the input data channels a
+// peer with all permissions may open.
+func (s *Stream) Manifest(inputs []string) *StreamManifest {
+	m := &StreamManifest{
+		Name:                s.Name,
+		Transport:           s.Transport,
+		Inputs:              slices.Clone(inputs),
+		Snapshots:           s.keyframes != nil,
+		MaxPeers:            s.MaxPeers,
+		ExclusiveController: s.ExclusiveController,
+	}
+
+	h := streamHealth(s, time.Now())
+	m.Live = h.Ready
+	m.Status = h.status()
+
+	if s.peers != nil {
+		m.Peers = s.peers.Len()
+	}
+
+	if video := s.Video; video != nil {
+		m.Video = &VideoManifest{
+			Codec: video.Codec(),
+			FPS:   video.FPS(),
+		}
+
+		if len(s.Variants) > 0 {
+			m.Video.Codecs = s.codecPreference()
+		}
+
+		for _, rendition := range s.Renditions {
+			m.Video.Renditions = append(m.Video.Renditions, rendition.Name())
+		}
+
+		if nv := s.NVStream; nv != nil {
+			m.Video.Width = nv.Width
+			m.Video.Height = nv.Height
+		}
+	}
+
+	if audio := s.Audio; audio != nil {
+		m.Audio = &AudioManifest{
+			Codec: audio.Codec(),
+		}
+
+		if nv := s.NVStream; nv != nil {
+			m.Audio.Channels = nv.AudioConfiguration.ChannelCount
+		}
+	}
+
+	return m
+}
+
+// status sums up the health of a stream.
+func (h *StreamHealth) status() StreamStatus {
+	switch {
+	case h.Standby:
+		return StreamStatusStandby
+	case h.Ready:
+		return StreamStatusLive
+	case h.Stage == nvstream.ConnectionStageFailed,
+		h.Stage == nvstream.ConnectionStageTerminated,
+		h.Stage == nvstream.ConnectionStageStopped:
+		return StreamStatusDown
+	case h.Video != nil && h.Video.Stalled, h.Audio != nil && h.Audio.Stalled:
+		return StreamStatusStalled
+	default:
+		return StreamStatusConnecting
+	}
+}
+
+// Summary shortens the manifest to what streams.list returns.
+func (m *StreamManifest) Summary() *StreamSummary {
+	summary := &StreamSummary{
+		Name:      m.Name,
+		Transport: m.Transport,
+		Status:    m.Status,
+		Codecs:    make([]Codec, 0, 2),
+		Peers:     m.Peers,
+		MaxPeers:  m.MaxPeers,
+	}
+
+	if video := m.Video; video != nil {
+		if len(video.Codecs) > 0 {
+			summary.Codecs = append(summary.Codecs, video.Codecs...)
+		} else {
+			summary.Codecs = append(summary.Codecs, video.Codec)
+		}
+	}
+
+	if audio := m.Audio; audio != nil {
+		summary.Codecs = append(summary.Codecs, audio.Codec)
+	}
+
+	return summary
+}
@@ -0,0 +1,269 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Matroska element IDs used by the live muxer.
+const (
+	mkvEBML               uint32 = 0x1A45DFA3
+	mkvEBMLVersion        uint32 = 0x4286
+	mkvEBMLReadVersion    uint32 = 0x42F7
+	mkvEBMLMaxIDLength    uint32 = 0x42F2
+	mkvEBMLMaxSizeLength  uint32 = 0x42F3
+	mkvDocType            uint32 = 0x4282
+	mkvDocTypeVersion     uint32 = 0x4287
+	mkvDocTypeReadVersion uint32 = 0x4285
+
+	mkvSegment       uint32 = 0x18538067
+	mkvInfo          uint32 = 0x1549A966
+	mkvTimecodeScale uint32 = 0x2AD7B1
+	mkvMuxingApp     uint32 = 0x4D80
+	mkvWritingApp    uint32 = 0x5741
+
+	mkvTracks          uint32 = 0x1654AE6B
+	mkvTrackEntry      uint32 = 0xAE
+	mkvTrackNumber     uint32 = 0xD7
+	mkvTrackUID        uint32 = 0x73C5
+	mkvTrackType       uint32 = 0x83
+	mkvCodecID         uint32 = 0x86
+	mkvCodecPrivate    uint32 = 0x63A2
+	mkvCodecDelay      uint32 = 0x56AA
+	mkvSeekPreRoll     uint32 = 0x56BB
+	mkvVideo           uint32 = 0xE0
+	mkvPixelWidth      uint32 = 0xB0
+	mkvPixelHeight     uint32 = 0xBA
+	mkvAudio           uint32 = 0xE1
+	mkvSamplingFreq    uint32 = 0xB5
+	mkvChannels        uint32 = 0x9F
+	mkvCluster         uint32 = 0x1F43B675
+	mkvClusterTimecode uint32 = 0xE7
+	mkvSimpleBlock     uint32 = 0xA3
+)
+
+const (
+	mkvTrackTypeVideo uint64 = 1
+	mkvTrackTypeAudio uint64 = 2
+)
+
+// mkvUnknownSize marks live elements whose size is not known up front.
+var mkvUnknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+type mkvTrack struct {
+	Number       uint64
+	Type         uint64
+	CodecID      string
+	CodecPrivate []byte
+
+	// video
+	Width  int
+	Height int
+
+	// audio
+	SampleRate float64
+	Channels   int
+}
+
+func ebmlID(id uint32) []byte {
+	switch {
+	case id >= 0x1000000:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x10000:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x100:
+		return []byte{byte(id >> 8), byte(id)}
+	default:
+		return []byte{byte(id)}
+	}
+}
+
+func ebmlSize(size uint64) []byte {
+	for n := 1; n <= 8; n++ {
+		if size < (1<<(7*n))-1 {
+			buf := make([]byte, n)
+			for i := n - 1; i >= 0; i-- {
+				buf[i] = byte(size)
+				size >>= 8
+			}
+
+			buf[0] |= 1 << (8 - n)
+			return buf
+		}
+	}
+
+	return mkvUnknownSize
+}
+
+func ebmlElement(id uint32, data []byte) []byte {
+	buf := ebmlID(id)
+	buf = append(buf, ebmlSize(uint64(len(data)))...)
+	return append(buf, data...)
+}
+
+func ebmlUint(id uint32, v uint64) []byte {
+	n := 1
+	for v>>(8*n) != 0 && n < 8 {
+		n++
+	}
+
+	data := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		data[i] = byte(v)
+		v >>= 8
+	}
+
+	return ebmlElement(id, data)
+}
+
+func ebmlFloat(id uint32, v float64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(v))
+	return ebmlElement(id, data)
+}
+
+func ebmlString(id uint32, v string) []byte {
+	return ebmlElement(id, []byte(v))
+}
+
+func ebmlMaster(id uint32, children ...[]byte) []byte {
+	return ebmlElement(id, bytes.Join(children, nil))
+}
+
+// mkvWriter writes a live Matroska stream: the segment and its clusters
+// use unknown sizes, so the file never needs to be seeked and is playable
+// even if the process dies mid-recording.
+type mkvWriter struct {
+	w       io.Writer
+	written int64
+
+	clusterOpen bool
+	clusterTime int64
+}
+
+func newMKVWriter(w io.Writer, tracks []*mkvTrack) (*mkvWriter, error) {
+	mw := &mkvWriter{w: w}
+
+	header := ebmlMaster(mkvEBML,
+		ebmlUint(mkvEBMLVersion, 1),
+		ebmlUint(mkvEBMLReadVersion, 1),
+		ebmlUint(mkvEBMLMaxIDLength, 4),
+		ebmlUint(mkvEBMLMaxSizeLength, 8),
+		ebmlString(mkvDocType, "matroska"),
+		ebmlUint(mkvDocTypeVersion, 4),
+		ebmlUint(mkvDocTypeReadVersion, 2),
+	)
+
+	segment := append(ebmlID(mkvSegment), mkvUnknownSize...)
+
+	info := ebmlMaster(mkvInfo,
+		ebmlUint(mkvTimecodeScale, 1000000), // 1ms
+		ebmlString(mkvMuxingApp, "flarexio/game"),
+		ebmlString(mkvWritingApp, "flarexio/game"),
+	)
+
+	entries := make([][]byte, len(tracks))
+	for i, track := range tracks {
+		children := [][]byte{
+			ebmlUint(mkvTrackNumber, track.Number),
+			ebmlUint(mkvTrackUID, track.Number),
+			ebmlUint(mkvTrackType, track.Type),
+			ebmlString(mkvCodecID, track.CodecID),
+		}
+
+		if len(track.CodecPrivate) > 0 {
+			children = append(children, ebmlElement(mkvCodecPrivate, track.CodecPrivate))
+		}
+
+		switch track.Type {
+		case mkvTrackTypeVideo:
+			if track.Width > 0 && track.Height > 0 {
+				children = append(children, ebmlMaster(mkvVideo,
+					ebmlUint(mkvPixelWidth, uint64(track.Width)),
+					ebmlUint(mkvPixelHeight, uint64(track.Height)),
+				))
+			}
+
+		case mkvTrackTypeAudio:
+			if track.CodecID == "A_OPUS" {
+				children = append(children,
+					ebmlUint(mkvCodecDelay, 6500000),
+					ebmlUint(mkvSeekPreRoll, 80000000),
+				)
+			}
+
+			children = append(children, ebmlMaster(mkvAudio,
+				ebmlFloat(mkvSamplingFreq, track.SampleRate),
+				ebmlUint(mkvChannels, uint64(track.Channels)),
+			))
+		}
+
+		entries[i] = ebmlMaster(mkvTrackEntry, children...)
+	}
+
+	tracksElement := ebmlMaster(mkvTracks, entries...)
+
+	if err := mw.write(header, segment, info, tracksElement); err != nil {
+		return nil, err
+	}
+
+	return mw, nil
+}
+
+func (mw *mkvWriter) write(chunks ...[]byte) error {
+	for _, chunk := range chunks {
+		n, err := mw.w.Write(chunk)
+		mw.written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteBlock writes a SimpleBlock at the given timestamp in milliseconds.
+// A new cluster is started on video keyframes and whenever the relative
+// block timecode would overflow.
+func (mw *mkvWriter) WriteBlock(track uint64, timestamp int64, keyframe bool, video bool, data []byte) error {
+	relative := timestamp - mw.clusterTime
+
+	if !mw.clusterOpen || (keyframe && video) || relative > math.MaxInt16 || relative < math.MinInt16 {
+		cluster := append(ebmlID(mkvCluster), mkvUnknownSize...)
+		cluster = append(cluster, ebmlUint(mkvClusterTimecode, uint64(timestamp))...)
+
+		if err := mw.write(cluster); err != nil {
+			return err
+		}
+
+		mw.clusterOpen = true
+		mw.clusterTime = timestamp
+		relative = 0
+	}
+
+	block := make([]byte, 0, len(data)+4)
+	block = append(block, ebmlSize(track)...)
+	block = binary.BigEndian.AppendUint16(block, uint16(int16(relative)))
+
+	var flags byte
+	if keyframe {
+		flags |= 0x80
+	}
+
+	block = append(block, flags)
+	block = append(block, data...)
+
+	return mw.write(ebmlElement(mkvSimpleBlock, block))
+}
+
+// Finish has nothing left to write: live Matroska is complete after each
+// block.
+func (mw *mkvWriter) Finish() error {
+	return nil
+}
+
+func (mw *mkvWriter) Written() int64 {
+	return mw.written
+}
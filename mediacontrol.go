@@ -0,0 +1,126 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+)
+
+// sourceTimeout bounds a quality change, which restarts an NVStream
+// connection.
+const sourceTimeout = 30 * time.Second
+
+// handleQuality changes the quality of the stream's source. As every peer
+// of the stream gets it, only the sole peer or the one in control may.
+func (peer *Peer) handleQuality(msg *ControlMessage) error {
+	var req QualityRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return NewControlError(ControlErrBadRequest, err.Error())
+	}
+
+	if err := req.validate(); err != nil {
+		return NewControlError(ControlErrBadRequest, err.Error())
+	}
+
+	stream := peer.stream
+	if stream.source == nil {
+		return errNoHook
+	}
+
+	if peer.group.Len() > 1 && peer.group.Controller() != peer {
+		return NewControlError(ControlErrPermissionDenied, "stream shared with other peers")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sourceTimeout)
+	defer cancel()
+
+	if err := stream.source.SetQuality(ctx, req); err != nil {
+		return err
+	}
+
+	peer.log.Info("quality changed",
+		zap.Int("bitrate", req.Bitrate),
+		zap.Int("width", req.Width),
+		zap.Int("height", req.Height),
+		zap.Int("fps", req.FPS))
+
+	return nil
+}
+
+func (peer *Peer) requestKeyframe() error {
+	if !peer.mode.Video() {
+		return NewControlError(ControlErrUnsupported, "peer receives no video")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sourceTimeout)
+	defer cancel()
+
+	return peer.stream.requestKeyframe(ctx)
+}
+
+// pauseVideo stops or resumes sending the peer video. Resumed video starts
+// from a keyframe.
+func (peer *Peer) pauseVideo(paused bool) error {
+	peer.Lock()
+	defer peer.Unlock()
+
+	if !peer.mode.Video() || peer.videoSender == nil || peer.video == nil {
+		return NewControlError(ControlErrUnsupported, "peer receives no video")
+	}
+
+	if peer.videoPaused == paused {
+		return nil
+	}
+
+	var track webrtc.TrackLocal
+	if !paused {
+		track = peer.video.Track()
+
+		if pacer := peer.pacer; pacer != nil {
+			pacer.resync()
+			track = pacer.Track()
+		}
+	}
+
+	if err := peer.videoSender.ReplaceTrack(track); err != nil {
+		return err
+	}
+
+	peer.videoPaused = paused
+
+	if !paused {
+		go peer.stream.requestKeyframe(context.Background())
+	}
+
+	return nil
+}
+
+// muteAudio stops or resumes sending the peer audio.
+func (peer *Peer) muteAudio(muted bool) error {
+	peer.Lock()
+	defer peer.Unlock()
+
+	if peer.audioSender == nil {
+		return NewControlError(ControlErrUnsupported, "peer receives no audio")
+	}
+
+	if peer.audioMuted == muted {
+		return nil
+	}
+
+	var track webrtc.TrackLocal
+	if !muted {
+		track = peer.stream.Audio.Track()
+	}
+
+	if err := peer.audioSender.ReplaceTrack(track); err != nil {
+		return err
+	}
+
+	peer.audioMuted = muted
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package game
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChannelMetrics counts the data channel traffic of all peers by label, so
+// input flooding and protocol mismatches show up in the stats endpoint and
+// in Prometheus.
+type ChannelMetrics struct {
+	labels map[string]*labelMetrics
+	sync.Mutex
+}
+
+func NewChannelMetrics() *ChannelMetrics {
+	return &ChannelMetrics{
+		labels: make(map[string]*labelMetrics),
+	}
+}
+
+// label returns the counters of the label, creating them on first use.
+// Labels are route labels, which keeps their number bounded.
+func (m *ChannelMetrics) label(label string) *labelMetrics {
+	if m == nil {
+		return nil
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	lm, ok := m.labels[label]
+	if !ok {
+		lm = new(labelMetrics)
+		m.labels[label] = lm
+	}
+
+	return lm
+}
+
+// Stats returns the counters of every label seen, sorted by label.
+func (m *ChannelMetrics) Stats() []LabelStats {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	stats := make([]LabelStats, 0, len(m.labels))
+	for label, lm := range m.labels {
+		stats = append(stats, LabelStats{
+			Label:         label,
+			Received:      lm.received.Load(),
+			ReceivedBytes: lm.receivedBytes.Load(),
+			DecodeErrors:  lm.decodeErrors.Load(),
+			Discarded:     lm.discarded.Load(),
+			Sent:          lm.sent.Load(),
+			SentBytes:     lm.sentBytes.Load(),
+			Dropped:       lm.dropped.Load(),
+			Rate:          lm.rate.Rate(now),
+		})
+	}
+
+	slices.SortFunc(stats, func(a, b LabelStats) int {
+		return cmp.Compare(a.Label, b.Label)
+	})
+
+	return stats
+}
+
+var prometheusMetrics = []struct {
+	name  string
+	help  string
+	value func(s *LabelStats) uint64
+}{
+	{"game_datachannel_received_messages_total", "Messages received from peers.", func(s *LabelStats) uint64 { return s.Received }},
+	{"game_datachannel_received_bytes_total", "Bytes received from peers.", func(s *LabelStats) uint64 { return s.ReceivedBytes }},
+	{"game_datachannel_decode_errors_total", "Received messages that failed to decode.", func(s *LabelStats) uint64 { return s.DecodeErrors }},
+	{"game_datachannel_discarded_messages_total", "Received messages that were valid but not delivered.", func(s *LabelStats) uint64 { return s.Discarded }},
+	{"game_datachannel_sent_messages_total", "Messages sent to peers.", func(s *LabelStats) uint64 { return s.Sent }},
+	{"game_datachannel_sent_bytes_total", "Bytes sent to peers.", func(s *LabelStats) uint64 { return s.SentBytes }},
+	{"game_datachannel_dropped_messages_total", "Messages not sent because the channel was congested.", func(s *LabelStats) uint64 { return s.Dropped }},
+}
+
+// WritePrometheus writes the label counters in the Prometheus text format.
+func WritePrometheus(w io.Writer, stats []LabelStats) error {
+	for _, metric := range prometheusMetrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name); err != nil {
+			return err
+		}
+
+		for i := range stats {
+			if _, err := fmt.Fprintf(w, "%s{label=%q} %d\n", metric.name, stats[i].Label, metric.value(&stats[i])); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WritePrometheusGauge writes a gauge in the Prometheus text format.
+func WritePrometheusGauge(w io.Writer, name, help string, value int) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	return err
+}
+
+type LabelStats struct {
+	Label         string  `json:"label"`
+	Received      uint64  `json:"received"`
+	ReceivedBytes uint64  `json:"received_bytes"`
+	DecodeErrors  uint64  `json:"decode_errors"`
+	Discarded     uint64  `json:"discarded"`
+	Sent          uint64  `json:"sent"`
+	SentBytes     uint64  `json:"sent_bytes"`
+	Dropped       uint64  `json:"dropped"`
+	Rate          float64 `json:"rate"` // messages received per second
+}
+
+// labelMetrics are the counters of a label. A nil *labelMetrics counts
+// nothing, for channels created without metrics.
+type labelMetrics struct {
+	received      atomic.Uint64
+	receivedBytes atomic.Uint64
+	decodeErrors  atomic.Uint64
+	discarded     atomic.Uint64
+	sent          atomic.Uint64
+	sentBytes     atomic.Uint64
+	dropped       atomic.Uint64
+	rate          rateCounter
+}
+
+func (lm *labelMetrics) receive(n int) {
+	if lm == nil {
+		return
+	}
+
+	lm.received.Add(1)
+	lm.receivedBytes.Add(uint64(n))
+	lm.rate.Add(time.Now())
+}
+
+func (lm *labelMetrics) send(n int) {
+	if lm == nil {
+		return
+	}
+
+	lm.sent.Add(1)
+	lm.sentBytes.Add(uint64(n))
+}
+
+func (lm *labelMetrics) decodeError() {
+	if lm != nil {
+		lm.decodeErrors.Add(1)
+	}
+}
+
+func (lm *labelMetrics) discard() {
+	if lm != nil {
+		lm.discarded.Add(1)
+	}
+}
+
+func (lm *labelMetrics) drop() {
+	if lm != nil {
+		lm.dropped.Add(1)
+	}
+}
+
+// rateCounter counts events per second; Rate reports the count of the last
+// complete second.
+type rateCounter struct {
+	second int64
+	count  uint64
+	last   uint64
+	sync.Mutex
+}
+
+func (r *rateCounter) Add(now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.advance(now.Unix())
+	r.count++
+}
+
+func (r *rateCounter) Rate(now time.Time) float64 {
+	r.Lock()
+	defer r.Unlock()
+
+	r.advance(now.Unix())
+	return float64(r.last)
+}
+
+func (r *rateCounter) advance(second int64) {
+	switch {
+	case second == r.second:
+		return
+	case second == r.second+1:
+		r.last = r.count
+	default:
+		r.last = 0
+	}
+
+	r.second = second
+	r.count = 0
+}
@@ -0,0 +1,71 @@
+package game
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestChannelMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics := NewChannelMetrics()
+
+	mc := newMonitoredChannel(new(fakeDataChannel), zap.NewNop())
+	mc.metrics = metrics.label("gamepad")
+
+	results := []error{nil, ErrMalformedMessage, ErrDiscardedMessage, nil}
+	for _, result := range results {
+		mc.receive(webrtc.DataChannelMessage{Data: make([]byte, 12)}, func(webrtc.DataChannelMessage) error {
+			return result
+		})
+	}
+
+	assert.NoError(mc.SendText("ack"))
+
+	stats := mc.Stats()
+	assert.Equal(uint64(4), stats.Received)
+	assert.Equal(uint64(48), stats.ReceivedBytes)
+	assert.Equal(uint64(1), stats.DecodeErrors)
+	assert.Equal(uint64(1), stats.Discarded)
+
+	labels := metrics.Stats()
+	if !assert.Len(labels, 1) {
+		return
+	}
+
+	assert.Equal("gamepad", labels[0].Label)
+	assert.Equal(uint64(4), labels[0].Received)
+	assert.Equal(uint64(1), labels[0].DecodeErrors)
+	assert.Equal(uint64(1), labels[0].Sent)
+	assert.Equal(uint64(3), labels[0].SentBytes)
+
+	var sb strings.Builder
+	assert.NoError(WritePrometheus(&sb, labels))
+	assert.Contains(sb.String(), "# TYPE game_datachannel_received_messages_total counter\n")
+	assert.Contains(sb.String(), `game_datachannel_received_messages_total{label="gamepad"} 4`)
+	assert.Contains(sb.String(), `game_datachannel_decode_errors_total{label="gamepad"} 1`)
+
+	sb.Reset()
+	assert.NoError(WritePrometheusGauge(&sb, "game_nats_peer_subscriptions", "Active NATS subscriptions of peers.", 2))
+	assert.Contains(sb.String(), "# TYPE game_nats_peer_subscriptions gauge\ngame_nats_peer_subscriptions 2\n")
+}
+
+func TestRateCounter(t *testing.T) {
+	assert := assert.New(t)
+
+	var r rateCounter
+
+	now := time.Unix(100, 0)
+	for range 30 {
+		r.Add(now)
+	}
+
+	assert.Equal(0.0, r.Rate(now))
+	assert.Equal(30.0, r.Rate(now.Add(time.Second)))
+	assert.Equal(0.0, r.Rate(now.Add(3*time.Second)))
+}
@@ -0,0 +1,72 @@
+package game
+
+import (
+	"errors"
+	"io"
+
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+	"gopkg.in/yaml.v3"
+)
+
+// Microphone plays the audio of peers with the microphone permission on an
+// output device of the host that games take for a microphone, e.g. a
+// PulseAudio null sink, whose monitor is the source, or a virtual audio
+// cable. Each peer's Opus track is decoded by its own ffmpeg process,
+// writing to Device in the ffmpeg output Format.
+//
+// With PushToTalk a peer is only heard while it holds the mic.talk control
+// message, and Gate silences the audio of a peer that is not speaking.
+type Microphone struct {
+	Enabled    bool
+	FFmpeg     string
+	Format     string
+	Device     string
+	PushToTalk bool
+	Gate       *NoiseGate // nil passes all audio
+}
+
+func (cfg *Microphone) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Enabled    bool       `yaml:"enabled"`
+		FFmpeg     string     `yaml:"ffmpeg"`
+		Format     string     `yaml:"format"`
+		Device     string     `yaml:"device"`
+		PushToTalk bool       `yaml:"pushToTalk"`
+		Gate       *NoiseGate `yaml:"gate"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Enabled && (raw.Format == "" || raw.Device == "") {
+		return errors.New("microphone requires a format and a device")
+	}
+
+	if raw.FFmpeg == "" {
+		raw.FFmpeg = "ffmpeg"
+	}
+
+	cfg.Enabled = raw.Enabled
+	cfg.FFmpeg = raw.FFmpeg
+	cfg.Format = raw.Format
+	cfg.Device = raw.Device
+	cfg.PushToTalk = raw.PushToTalk
+	cfg.Gate = raw.Gate
+
+	return nil
+}
+
+// play decodes the Opus packets of track to the device until the track
+// ends or the ffmpeg process exits.
+func (mic *Microphone) play(track rtpReader, channels uint16) error {
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-f", "ogg", "-i", "pipe:0",
+		"-f", mic.Format, mic.Device,
+	}
+
+	return playTrack(mic.FFmpeg, args, track, func(w io.Writer) (rtpWriter, error) {
+		return oggwriter.NewWith(w, 48000, max(channels, 1))
+	})
+}
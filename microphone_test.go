@@ -0,0 +1,95 @@
+package game
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMicrophoneUnmarshalYAML(t *testing.T) {
+	assert := assert.New(t)
+
+	var mic Microphone
+	err := yaml.Unmarshal([]byte("enabled: true\nformat: pulse\ndevice: game-mic"), &mic)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(Microphone{Enabled: true, FFmpeg: "ffmpeg", Format: "pulse", Device: "game-mic"}, mic)
+
+	err = yaml.Unmarshal([]byte("enabled: true\nformat: pulse"), &mic)
+	assert.Error(err)
+
+	err = yaml.Unmarshal([]byte("enabled: false"), &mic)
+	assert.NoError(err)
+
+	mic = Microphone{}
+	err = yaml.Unmarshal([]byte("enabled: true\nformat: pulse\ndevice: game-mic\npushToTalk: true\ngate: {}"), &mic)
+	if assert.NoError(err) {
+		assert.True(mic.PushToTalk)
+		assert.Equal(defaultNoiseGate, mic.Gate)
+	}
+}
+
+func TestMicrophonePlay(t *testing.T) {
+	assert := assert.New(t)
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "device")
+
+	// Takes the place of ffmpeg, writing the Ogg stream to the device.
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\nfor last; do :; done\ncat > \"$last\"\n"
+	if err := os.WriteFile(ffmpeg, []byte(script), 0o755); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	mic := &Microphone{Enabled: true, FFmpeg: ffmpeg, Format: "pulse", Device: out}
+
+	track := &fakeRTPReader{}
+	for i := range 3 {
+		track.packets = append(track.packets, &rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: uint16(i), Timestamp: uint32(960 * i)},
+			Payload: []byte{0xFC, 0xFF, 0xFE},
+		})
+	}
+
+	if err := mic.play(track, 2); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	bs, err := os.ReadFile(out)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(bytes.HasPrefix(bs, []byte("OggS")))
+	assert.Contains(string(bs), "OpusHead")
+
+	// ffmpeg failing is reported with its output.
+	failing := filepath.Join(dir, "failing")
+	script = "#!/bin/sh\necho unknown output format >&2\nexit 1\n"
+	if err := os.WriteFile(failing, []byte(script), 0o755); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	mic.FFmpeg = failing
+	track.packets = []*rtp.Packet{{Payload: []byte{0xFC}}}
+
+	assert.ErrorContains(mic.play(track, 2), "unknown output format")
+}
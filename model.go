@@ -1,30 +1,75 @@
 package game
 
 import (
+	"context"
 	"errors"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
 	"gopkg.in/yaml.v3"
 
 	"github.com/flarexio/game/nvstream"
 )
 
 type Config struct {
-	Path    string    `yaml:"-"`
-	WebRTC  WebRTC    `yaml:"webrtc"`
-	Streams []*Stream `yaml:"streams"`
+	Path       string         `yaml:"-"`
+	Version    int            `yaml:"version"`
+	WebRTC     WebRTC         `yaml:"webrtc"`
+	Streams    []*Stream      `yaml:"streams"`
+	Recordings *Recordings    `yaml:"recordings"`
+	Files      *FileDrop      `yaml:"files"`
+	Microphone *Microphone    `yaml:"microphone"`
+	Camera     *Camera        `yaml:"camera"`
+	Snapshots  *Snapshots     `yaml:"snapshots"`
+	Guests     *Guests        `yaml:"guests"`
+	Access     *Access        `yaml:"access"`
+	Input      *InputConfig   `yaml:"input"`
+	Gamepad    *GamepadConfig `yaml:"gamepad"`
+	Tracing    *Tracing       `yaml:"tracing"`
+	Logging    *Logging       `yaml:"logging"`
+	Sessions   *Sessions      `yaml:"sessions"`
+	Management *Management    `yaml:"management"`
 }
 
 type WebRTC struct {
-	ICEServers []*ICEServer `yaml:"iceServers"`
+	ICEServers []*ICEServer    `yaml:"iceServers"`
+	ICERestart *ICERestart     `yaml:"iceRestart"`
+	ICEHealth  *ICEHealthCheck `yaml:"iceHealth"`
+	ICEBreaker *ICEBreaker     `yaml:"iceBreaker"`
+	Deadlines  *Deadlines      `yaml:"deadlines"`
+	Server     *ICEPolicy      `yaml:"server"` // ICE servers of the server's peers
+	Client     *ICEPolicy      `yaml:"client"` // ICE servers handed to clients
+	DTLSRole   DTLSRole        `yaml:"dtlsRole"`
 }
 
 type ICEServer struct {
-	Provider ICEProvider `yaml:"provider"`
-	ID       string      `yaml:"id"`
-	Token    string      `yaml:"token"`
+	Provider ICEProvider   `yaml:"provider"`
+	ID       string        `yaml:"id"`
+	Token    string        `yaml:"token"`
+	TTL      time.Duration `yaml:"ttl"` // of requested TURN credentials; DefaultTURNTTL if unset
+}
+
+func (s *ICEServer) UnmarshalYAML(value *yaml.Node) error {
+	type plain ICEServer
+
+	var raw plain
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if err := resolveSecrets(&raw.ID, &raw.Token); err != nil {
+		return err
+	}
+
+	*s = ICEServer(raw)
+
+	return nil
 }
 
 type ICEProvider int
@@ -33,10 +78,15 @@ const (
 	Google ICEProvider = iota
 	Cloudflare
 	Metered
+
+	// AnyProvider asks for the servers of all healthy providers.
+	AnyProvider
 )
 
 func ParseICEProvider(provider string) (ICEProvider, error) {
 	switch provider {
+	case "", "any":
+		return AnyProvider, nil
 	case "google":
 		return Google, nil
 	case "cloudflare":
@@ -59,6 +109,10 @@ func (provider *ICEProvider) UnmarshalYAML(value *yaml.Node) error {
 		return err
 	}
 
+	if p == AnyProvider {
+		return errors.New("provider not supported: " + raw)
+	}
+
 	*provider = p
 
 	return nil
@@ -72,50 +126,283 @@ func (provider ICEProvider) String() string {
 		return "cloudflare"
 	case Metered:
 		return "metered"
+	case AnyProvider:
+		return "any"
 	default:
 		return "unknown"
 	}
 }
 
 type Stream struct {
-	Name      string
-	Transport Transport
-	Address   *url.URL
-	NVStream  *nvstream.StreamConfiguration
-	Video     *VideoTrack
-	Audio     *AudioTrack
+	Name                string
+	Transport           Transport
+	Origins             []*url.URL // NVStream hosts in failover order
+	NVStream            *nvstream.StreamConfiguration
+	Sunshine            *nvstream.Sunshine
+	Video               *VideoTrack
+	Variants            []*VideoTrack // the video in other codecs, each its own source
+	Renditions          []*VideoTrack // the video in lower qualities, best first
+	CodecPreference     []Codec       // of video, most preferred first
+	Audio               *AudioTrack
+	MaxPeers            int
+	ExclusiveController bool
+	Republish           []*Republish
+	Watchdog            *Watchdog
+	Quality             *Quality
+	Preview             *Preview
+	Pacing              *Pacing
+	TargetLatency       *time.Duration // nil leaves the browser's default
+	Hooks               *SourceHooks   // of a raw stream's encoder
+	Launch              *Launch        // of an NVStream stream's app, eager if nil
+	Idle                *Idle          // frees the stream while unused, never if nil
+	Disabled            bool           // configured, but not started
+
+	spec      *yaml.Node // the stream as configured, nil if built in code
+	peers     *PeerGroup
+	conn      nvstream.NvConnection
+	launcher  *appLauncher // of a lazy stream
+	recorder  *Recorder    // nil unless recorded or replayed
+	idle      *idleMonitor
+	keyframes *keyframeCache
+	preview   *previewer
+	leds      *controllerLEDs
+	hdr       *lastHDRMode
+	source    sourceControl // nil when the source takes no requests
+
+	origin            atomic.Pointer[url.URL]        // the origin connected to, nil before
+	app               atomic.Pointer[nvstream.NvApp] // the app on the origin
+	keyframeRequested atomic.Int64                   // unix nanoseconds
+	lastInput         atomic.Int64                   // unix nanoseconds
+	paused            atomic.Bool                    // raw listeners closed while idle
+}
+
+// Address returns the NVStream host in use: the origin the stream last
+// connected to, or the first origin before it connects.
+func (s *Stream) Address() *url.URL {
+	if origin := s.origin.Load(); origin != nil {
+		return origin
+	}
+
+	if len(s.Origins) == 0 {
+		return nil
+	}
+
+	return s.Origins[0]
+}
+
+// stop ends the stream's source. Listeners stop with the stream's context;
+// an NVStream connection is closed, leaving the app running on the host,
+// also that of a lazy stream.
+func (s *Stream) stop(ctx context.Context) error {
+	if s.launcher != nil {
+		s.launcher.close()
+	}
+
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.Close()
+}
+
+// standby reports whether the stream waits for a peer to start its source,
+// lazy or idle.
+func (s *Stream) standby() bool {
+	if s.launcher != nil && !s.launcher.Running() {
+		return true
+	}
+
+	return s.idle != nil && s.idle.Sleeping()
+}
+
+// tracks returns the stream's configured tracks.
+func (s *Stream) tracks() []Track {
+	var tracks []Track
+
+	for _, video := range s.videoTracks() {
+		tracks = append(tracks, video)
+	}
+
+	for _, video := range s.Renditions {
+		tracks = append(tracks, video)
+	}
+
+	if s.Audio != nil {
+		tracks = append(tracks, s.Audio)
+	}
+
+	return tracks
+}
+
+// videoTracks returns the stream's video track and its variants.
+func (s *Stream) videoTracks() []*VideoTrack {
+	var tracks []*VideoTrack
+
+	if s.Video != nil {
+		tracks = append(tracks, s.Video)
+	}
+
+	return append(tracks, s.Variants...)
+}
+
+// videoTrack returns the stream's video track of a codec, nil if none is.
+func (s *Stream) videoTrack(codec Codec) *VideoTrack {
+	for _, video := range s.videoTracks() {
+		if video.Codec() == codec {
+			return video
+		}
+	}
+
+	return nil
+}
+
+// codecPreference returns the video codecs in the order peers get them:
+// as configured, or else those of the video track and its variants.
+func (s *Stream) codecPreference() []Codec {
+	if len(s.CodecPreference) > 0 {
+		return s.CodecPreference
+	}
+
+	var codecs []Codec
+	for _, video := range s.videoTracks() {
+		codecs = append(codecs, video.Codec())
+	}
+
+	return codecs
+}
+
+// checkVariants tells whether the stream's video variants and codec
+// preference are consistent: one track per codec, and one for each
+// preferred codec.
+func (s *Stream) checkVariants() error {
+	if len(s.Variants) > 0 {
+		if s.Video == nil {
+			return errors.New("video variants without video")
+		}
+
+		if s.Transport != TransportRaw {
+			return errors.New("video variants unsupported for transport: " + string(s.Transport))
+		}
+	}
+
+	seen := make(map[Codec]bool)
+	for _, video := range s.videoTracks() {
+		if seen[video.Codec()] {
+			return errors.New("duplicate video codec: " + string(video.Codec()))
+		}
+
+		seen[video.Codec()] = true
+	}
+
+	for _, codec := range s.CodecPreference {
+		if !seen[codec] {
+			return errors.New("no video source for codec: " + string(codec))
+		}
+	}
+
+	return nil
 }
 
 func (s *Stream) UnmarshalYAML(value *yaml.Node) error {
 	var raw struct {
-		Name      string                        `yaml:"name"`
-		Transport Transport                     `yaml:"transport"`
-		Address   string                        `yaml:"address"`
-		NVStream  *nvstream.StreamConfiguration `yaml:"nvstream"`
-		Video     *VideoTrack                   `yaml:"video"`
-		Audio     *AudioTrack                   `yaml:"audio"`
+		Name       string                        `yaml:"name"`
+		Transport  Transport                     `yaml:"transport"`
+		Address    yaml.Node                     `yaml:"address"`
+		Origins    []string                      `yaml:"origins"`
+		NVStream   *nvstream.StreamConfiguration `yaml:"nvstream"`
+		Sunshine   *nvstream.Sunshine            `yaml:"sunshine"`
+		Video      *VideoTrack                   `yaml:"video"`
+		Variants   []*VideoTrack                 `yaml:"variants"`
+		Renditions []*VideoTrack                 `yaml:"renditions"`
+		Audio      *AudioTrack                   `yaml:"audio"`
+
+		CodecPreference []Codec `yaml:"codecPreference"`
+
+		MaxPeers            int  `yaml:"maxPeers"`
+		ExclusiveController bool `yaml:"exclusiveController"`
+
+		Hotkeys   yaml.Node    `yaml:"hotkeys"`
+		Republish []*Republish `yaml:"republish"`
+		Watchdog  *Watchdog    `yaml:"watchdog"`
+		Quality   *Quality     `yaml:"quality"`
+		Preview   *Preview     `yaml:"preview"`
+		Pacing    *Pacing      `yaml:"pacing"`
+		Hooks     *SourceHooks `yaml:"hooks"`
+		Launch    *Launch      `yaml:"launch"`
+		Idle      *Idle        `yaml:"idle"`
+
+		TargetLatencyMs *int `yaml:"targetLatencyMs"`
+		Disabled        bool `yaml:"disabled"`
 	}
 
 	if err := value.Decode(&raw); err != nil {
 		return err
 	}
 
+	if !raw.Hotkeys.IsZero() {
+		return errors.New("stream hotkeys moved to access.hotkeys: " + raw.Name)
+	}
+
+	// Configs of version 1 have their address migrated before decoding.
+	if !raw.Address.IsZero() {
+		return errors.New("stream address moved to origins: " + raw.Name)
+	}
+
 	s.Name = raw.Name
 	s.Transport = raw.Transport
 
-	if raw.Address != "" {
-		url, err := url.Parse(raw.Address)
+	s.Origins = nil
+	for _, origin := range raw.Origins {
+		url, err := url.Parse(origin)
 		if err != nil {
 			return err
 		}
 
-		s.Address = url
+		s.Origins = append(s.Origins, url)
+	}
+
+	if sunshine := raw.Sunshine; sunshine != nil {
+		if err := resolveSecrets(&sunshine.Username, &sunshine.Password); err != nil {
+			return err
+		}
 	}
 
 	s.NVStream = raw.NVStream
+	s.Sunshine = raw.Sunshine
 	s.Video = raw.Video
+	s.Variants = raw.Variants
+	s.Renditions = raw.Renditions
 	s.Audio = raw.Audio
 
+	for _, codec := range raw.CodecPreference {
+		if !strings.HasPrefix(codec.MimeType(), "video") {
+			return errors.New("invalid codec preference: " + string(codec))
+		}
+	}
+
+	s.CodecPreference = raw.CodecPreference
+	s.MaxPeers = raw.MaxPeers
+	s.ExclusiveController = raw.ExclusiveController
+	s.Republish = raw.Republish
+	s.Watchdog = raw.Watchdog
+	s.Quality = raw.Quality
+	s.Preview = raw.Preview
+	s.Pacing = raw.Pacing
+	s.Hooks = raw.Hooks
+	s.Launch = raw.Launch
+	s.Idle = raw.Idle
+	s.Disabled = raw.Disabled
+	s.spec = value
+
+	if raw.TargetLatencyMs != nil {
+		target, err := parseTargetLatency(*raw.TargetLatencyMs)
+		if err != nil {
+			return err
+		}
+
+		s.TargetLatency = &target
+	}
+
 	return nil
 }
 
@@ -123,13 +410,95 @@ type Track interface {
 	Address() *url.URL
 	Codec() Codec
 	Track() webrtc.TrackLocal
+	SampleWriter
+	health() *trackHealth
+}
+
+// SampleWriter writes samples. A sample's data is only valid during
+// WriteSample, as its storage may be reused; writers copy what they keep.
+type SampleWriter interface {
+	WriteSample(sample media.Sample) error
+}
+
+// sampleSinks fans the samples written to a track out to additional
+// writers such as recorders.
+type sampleSinks struct {
+	sinks []SampleWriter
+	sync.RWMutex
+}
+
+func (s *sampleSinks) AddSink(sink SampleWriter) {
+	s.Lock()
+	s.sinks = append(s.sinks, sink)
+	s.Unlock()
+}
+
+func (s *sampleSinks) RemoveSink(sink SampleWriter) {
+	s.Lock()
+	s.sinks = slices.DeleteFunc(s.sinks, func(w SampleWriter) bool { return w == sink })
+	s.Unlock()
+}
+
+func (s *sampleSinks) writeSinks(sample media.Sample) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, sink := range s.sinks {
+		sink.WriteSample(sample)
+	}
+}
+
+// trackHealth records what health checks report about a track.
+type trackHealth struct {
+	listening  atomic.Bool
+	lastSample atomic.Int64 // unix nanoseconds
+}
+
+func (h *trackHealth) health() *trackHealth {
+	return h
+}
+
+// Listening reports whether the track's socket accepts a source.
+func (h *trackHealth) Listening() bool {
+	return h.listening.Load()
+}
+
+// LastSample returns when a sample was last written, zero if none was.
+func (h *trackHealth) LastSample() time.Time {
+	ns := h.lastSample.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ns)
+}
+
+func writeSample(track webrtc.TrackLocal, sinks *sampleSinks, sample media.Sample) error {
+	t, ok := track.(SampleWriter)
+	if !ok {
+		return errors.New("invalid type")
+	}
+
+	err := t.WriteSample(sample)
+
+	sinks.writeSinks(sample)
+
+	return err
 }
 
 type VideoTrack struct {
+	name    string // of a rendition
 	address *url.URL
 	codec   Codec
 	fps     float64
 	track   webrtc.TrackLocal
+	sampleSinks
+	trackHealth
+	frameTiming
+}
+
+func (video *VideoTrack) Name() string {
+	return video.name
 }
 
 func (video *VideoTrack) Address() *url.URL {
@@ -148,8 +517,15 @@ func (video *VideoTrack) Track() webrtc.TrackLocal {
 	return video.track
 }
 
+func (video *VideoTrack) WriteSample(sample media.Sample) error {
+	video.frames.Add(1)
+	video.lastSample.Store(time.Now().UnixNano())
+	return writeSample(video.track, &video.sampleSinks, sample)
+}
+
 func (video *VideoTrack) UnmarshalYAML(value *yaml.Node) error {
 	var raw struct {
+		Name    string  `yaml:"name"`
 		Address string  `yaml:"address"`
 		Codec   Codec   `yaml:"codec"`
 		FPS     float64 `yaml:"fps"`
@@ -179,16 +555,39 @@ func (video *VideoTrack) UnmarshalYAML(value *yaml.Node) error {
 		}
 	}
 
+	video.name = raw.Name
 	video.codec = raw.Codec
 	video.fps = raw.FPS
 
 	return nil
 }
 
+// newVideoTrack returns a track of a video codec, packetizing H265 itself.
+func newVideoTrack(codec Codec, id, streamID string) (webrtc.TrackLocal, error) {
+	if codec == CodecH265 {
+		return newHEVCTrack(id, streamID)
+	}
+
+	return webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{
+			MimeType: codec.MimeType(),
+		}, id, streamID,
+	)
+}
+
 type AudioTrack struct {
-	address *url.URL
-	codec   Codec
-	track   webrtc.TrackLocal
+	address       *url.URL
+	codec         Codec
+	source        Codec // transcoded to codec when it differs
+	container     Container
+	frameDuration time.Duration
+	ffmpeg        string
+	bitrate       int // kbps of transcoded audio
+	dtx           bool
+	fec           bool
+	track         webrtc.TrackLocal
+	sampleSinks
+	trackHealth
 }
 
 func (audio *AudioTrack) Address() *url.URL {
@@ -199,14 +598,63 @@ func (audio *AudioTrack) Codec() Codec {
 	return audio.codec
 }
 
+// Source returns the codec the source sends; AAC is transcoded to Opus.
+func (audio *AudioTrack) Source() Codec {
+	return audio.source
+}
+
+func (audio *AudioTrack) Container() Container {
+	return audio.container
+}
+
+// FrameDuration is the duration of each frame of a raw container.
+func (audio *AudioTrack) FrameDuration() time.Duration {
+	return audio.frameDuration
+}
+
 func (audio *AudioTrack) Track() webrtc.TrackLocal {
 	return audio.track
 }
 
+// Fmtp returns the Opus parameters signaled for the track: in-band FEC
+// and DTX, so clients expect their use. The source's encoder produces them;
+// the service passes its packets through.
+func (audio *AudioTrack) Fmtp() string {
+	if audio.codec != CodecOpus {
+		return ""
+	}
+
+	fmtp := "minptime=10;useinbandfec=" + boolParam(audio.fec)
+	if audio.dtx {
+		fmtp += ";usedtx=1"
+	}
+
+	return fmtp
+}
+
+func boolParam(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
+
+func (audio *AudioTrack) WriteSample(sample media.Sample) error {
+	audio.lastSample.Store(time.Now().UnixNano())
+	return writeSample(audio.track, &audio.sampleSinks, sample)
+}
+
 func (audio *AudioTrack) UnmarshalYAML(value *yaml.Node) error {
 	var raw struct {
-		Address string
-		Codec   Codec
+		Address       string
+		Codec         Codec
+		Container     Container     `yaml:"container"`
+		FrameDuration time.Duration `yaml:"frameDuration"`
+		FFmpeg        string        `yaml:"ffmpeg"`
+		Bitrate       int           `yaml:"bitrate"`
+		DTX           bool          `yaml:"dtx"`
+		FEC           *bool         `yaml:"fec"`
 	}
 
 	if err := value.Decode(&raw); err != nil {
@@ -233,7 +681,48 @@ func (audio *AudioTrack) UnmarshalYAML(value *yaml.Node) error {
 		}
 	}
 
+	switch raw.Container {
+	case "":
+		raw.Container = ContainerOgg
+	case ContainerOgg, ContainerRaw, ContainerRTP:
+	default:
+		return errors.New("container unsupported: " + string(raw.Container))
+	}
+
+	if raw.FrameDuration == 0 {
+		raw.FrameDuration = 20 * time.Millisecond
+	}
+
+	// AAC sources reach peers as Opus, transcoded by ffmpeg.
+	source := raw.Codec
+	if source == CodecAAC {
+		if !aacTranscode {
+			return errors.New("aac transcode unsupported: build with -tags aac")
+		}
+
+		if raw.Container != ContainerOgg {
+			return errors.New("container unsupported for aac: " + string(raw.Container))
+		}
+
+		raw.Codec = CodecOpus
+	}
+
+	if raw.FFmpeg == "" {
+		raw.FFmpeg = "ffmpeg"
+	}
+
+	if raw.Bitrate == 0 {
+		raw.Bitrate = 96
+	}
+
 	audio.codec = raw.Codec
+	audio.source = source
+	audio.ffmpeg = raw.FFmpeg
+	audio.bitrate = raw.Bitrate
+	audio.container = raw.Container
+	audio.frameDuration = raw.FrameDuration
+	audio.dtx = raw.DTX
+	audio.fec = raw.FEC == nil || *raw.FEC
 
 	return nil
 }
@@ -247,6 +736,16 @@ const (
 	TransportRTMP Transport = "rtmp"
 	TransportHTTP Transport = "http"
 	TransportNV   Transport = "nvstream"
+	TransportDemo Transport = "demo" // synthetic test pattern and tone
+)
+
+// Container is how audio frames arrive on a raw transport socket.
+type Container string
+
+const (
+	ContainerOgg Container = "ogg"
+	ContainerRaw Container = "raw" // bare Opus frames
+	ContainerRTP Container = "rtp" // RTP packets carrying Opus
 )
 
 type Codec string
@@ -262,6 +761,7 @@ const (
 	CodecG722 Codec = "g722"
 	CodecPCMU Codec = "pcmu"
 	CodecPCMA Codec = "pcma"
+	CodecAAC  Codec = "aac" // transcoded to Opus
 )
 
 func (codec Codec) MimeType() string {
@@ -284,6 +784,8 @@ func (codec Codec) MimeType() string {
 		return webrtc.MimeTypePCMU
 	case CodecPCMA:
 		return webrtc.MimeTypePCMA
+	case CodecAAC:
+		return "audio/aac"
 	default:
 		return "unknown"
 	}
@@ -3,6 +3,7 @@ package game
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
@@ -26,15 +27,25 @@ func TestConfig(t *testing.T) {
 
 	assert.Len(cfg.WebRTC.ICEServers, 3)
 	assert.Equal(Google, cfg.WebRTC.ICEServers[0].Provider)
+	assert.Equal(3, cfg.WebRTC.ICERestart.Attempts)
+	assert.Equal(10*time.Second, cfg.WebRTC.ICERestart.Timeout)
+
+	assert.Equal(GamepadBackendViGEm, cfg.Gamepad.Backend)
+	assert.Equal(250, cfg.Input.MaxGamepadRate)
+
+	assert.False(cfg.Tracing.Enabled)
+	assert.Equal("http://localhost:4318", cfg.Tracing.Endpoint)
 
 	assert.Len(cfg.Streams, 2)
 
 	{
 		stream := cfg.Streams[0]
 		assert.Equal(TransportNV, stream.Transport)
-		assert.Equal("https://localhost:47984", stream.Address.String())
+		assert.Equal("https://localhost:47984", stream.Address().String())
 
 		assert.NotNil(stream.NVStream)
+		assert.Equal(0.05, stream.Quality.LossThreshold)
+		assert.False(stream.Quality.AutoDowngrade)
 
 		assert.Equal(CodecH264, stream.Video.Codec())
 		assert.Equal(CodecOpus, stream.Audio.Codec())
@@ -43,6 +54,8 @@ func TestConfig(t *testing.T) {
 	{
 		stream := cfg.Streams[1]
 		assert.Equal(TransportRaw, stream.Transport)
+		assert.Equal(10*time.Second, stream.Watchdog.Timeout)
+		assert.Equal(320, stream.Preview.Width)
 
 		assert.Equal(CodecH264, stream.Video.Codec())
 		assert.Equal("unix", stream.Video.Address().Scheme)
@@ -51,5 +64,90 @@ func TestConfig(t *testing.T) {
 		assert.Equal(CodecOpus, stream.Audio.Codec())
 		assert.Equal("unix", stream.Audio.Address().Scheme)
 		assert.Equal("/tmp/stream/audio.sock", stream.Audio.Address().Path)
+		assert.Equal(ContainerOgg, stream.Audio.Container())
+		assert.Equal(20*time.Millisecond, stream.Audio.FrameDuration())
+		assert.Equal("minptime=10;useinbandfec=1", stream.Audio.Fmtp())
 	}
 }
+
+func TestGamepadConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *GamepadConfig
+	err := yaml.Unmarshal([]byte("backend: vxbox"), &cfg)
+	assert.NoError(err)
+	assert.Equal(GamepadBackendVXbox, cfg.Backend)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("{}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(GamepadBackendViGEm, cfg.Backend)
+	assert.Equal(GamepadTypeXbox360, cfg.Type)
+	assert.True(cfg.Fallback)
+	assert.False(cfg.Disabled)
+	assert.Equal(MaxGamepadSlots, cfg.Controllers)
+	assert.Nil(cfg.Deadzone)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("{controllers: 2, deadzone: {leftStick: 4000, trigger: 30}}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(2, cfg.Controllers)
+	assert.Equal(&Deadzone{LeftStick: 4000, Trigger: 30}, cfg.Deadzone)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("controllers: 5"), &cfg)
+	assert.ErrorContains(err, "controllers must be from 1")
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("deadzone: {trigger: 255}"), &cfg)
+	assert.ErrorContains(err, "out of range")
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("{backend: none, type: ds4, fallback: false}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(GamepadBackendNone, cfg.Backend)
+	assert.False(cfg.Fallback)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("backend: uinput"), &cfg)
+	assert.ErrorContains(err, "invalid gamepad backend")
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("type: ds4"), &cfg)
+	assert.NoError(err)
+	assert.Equal(GamepadTypeDS4, cfg.Type)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("{backend: vxbox, type: ds4}"), &cfg)
+	assert.ErrorContains(err, "need the vigem backend")
+}
+
+func TestStreamOrigins(t *testing.T) {
+	assert := assert.New(t)
+
+	var stream *Stream
+	err := yaml.Unmarshal([]byte("{name: game, transport: nvstream, origins: [https://host:47984]}"), &stream)
+	assert.NoError(err)
+	assert.Len(stream.Origins, 1)
+	assert.Equal("https://host:47984", stream.Address().String())
+
+	stream = nil
+	err = yaml.Unmarshal([]byte("{name: game, transport: nvstream, origins: [https://a:47984, https://b:47984]}"), &stream)
+	assert.NoError(err)
+	assert.Len(stream.Origins, 2)
+	assert.Equal("https://a:47984", stream.Address().String())
+	assert.Equal("b:47984", stream.Origins[1].Host)
+
+	stream = nil
+	err = yaml.Unmarshal([]byte("{name: game, transport: nvstream, address: https://a:47984}"), &stream)
+	assert.ErrorContains(err, "address moved to origins")
+
+	// only version 1 configs have an address, moved to the origins
+	var cfg *Config
+	err = yaml.Unmarshal([]byte("version: 2\nstreams:\n- {name: game, transport: nvstream, address: https://a:47984}\n"), &cfg)
+	assert.ErrorContains(err, "address moved to origins")
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("streams:\n- {name: game, transport: nvstream, address: https://a:47984}\n"), &cfg)
+	assert.NoError(err)
+}
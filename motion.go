@@ -0,0 +1,49 @@
+package game
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// MotionType values are those of moonlight's motion events.
+type MotionType uint8
+
+const (
+	MotionAccel MotionType = 0x01 // m/s²
+	MotionGyro  MotionType = 0x02 // degrees per second
+)
+
+var errInvalidMotionEvent = errors.New("invalid motion event")
+
+// MotionEvent is a message of the motion data channel: the sensor type
+// followed by the X, Y and Z readings as big-endian float32.
+type MotionEvent struct {
+	Type    MotionType
+	X, Y, Z float32
+}
+
+func ParseMotionEvent(data []byte) (*MotionEvent, error) {
+	if len(data) < 13 {
+		return nil, errInvalidMotionEvent
+	}
+
+	event := &MotionEvent{
+		Type: MotionType(data[0]),
+		X:    math.Float32frombits(binary.BigEndian.Uint32(data[1:5])),
+		Y:    math.Float32frombits(binary.BigEndian.Uint32(data[5:9])),
+		Z:    math.Float32frombits(binary.BigEndian.Uint32(data[9:13])),
+	}
+
+	if event.Type != MotionAccel && event.Type != MotionGyro {
+		return nil, errInvalidMotionEvent
+	}
+
+	return event, nil
+}
+
+// MotionSender is implemented by gamepads that forward motion sensor
+// readings to the host.
+type MotionSender interface {
+	SendMotion(event *MotionEvent) error
+}
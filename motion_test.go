@@ -0,0 +1,47 @@
+package game
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMotionEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte{byte(MotionGyro)}
+	for _, v := range []float32{1.5, -90, 0} {
+		data = binary.BigEndian.AppendUint32(data, math.Float32bits(v))
+	}
+
+	event, err := ParseMotionEvent(data)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(&MotionEvent{Type: MotionGyro, X: 1.5, Y: -90}, event)
+
+	_, err = ParseMotionEvent(data[:12])
+	assert.Error(err)
+
+	data[0] = 0x03
+	_, err = ParseMotionEvent(data)
+	assert.Error(err)
+}
+
+func TestMoonlightGamepadMotion(t *testing.T) {
+	assert := assert.New(t)
+
+	gamepad, _ := NewMoonlightGamepad()
+	if err := gamepad.Connect(); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer gamepad.Close()
+
+	// The host has not asked for motion events.
+	err := gamepad.(MotionSender).SendMotion(&MotionEvent{Type: MotionAccel})
+	assert.ErrorIs(err, ErrDiscardedMessage)
+}
@@ -0,0 +1,374 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// mp4FragmentDuration bounds how long a fragment buffers samples when no
+// video keyframe starts a new one, and so how much of a recording is lost
+// if the process dies.
+const mp4FragmentDuration = time.Second
+
+// Sample flags of the track fragment runs (ISO/IEC 14496-12 8.8.3.1).
+const (
+	mp4SampleSync    uint32 = 0x02000000 // depends on no other sample
+	mp4SampleNonSync uint32 = 0x01010000 // depends on others, not a sync sample
+)
+
+// mp4Matrix is the identity transformation of the movie and its tracks.
+var mp4Matrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func mp4Box(typ string, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+
+	buf := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	buf = append(buf, typ...)
+	return append(buf, data...)
+}
+
+func mp4FullBox(typ string, version byte, flags uint32, children ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(typ, append([][]byte{header}, children...)...)
+}
+
+func mp4Uint16(vs ...uint16) []byte {
+	var buf []byte
+	for _, v := range vs {
+		buf = binary.BigEndian.AppendUint16(buf, v)
+	}
+
+	return buf
+}
+
+func mp4Uint32(vs ...uint32) []byte {
+	var buf []byte
+	for _, v := range vs {
+		buf = binary.BigEndian.AppendUint32(buf, v)
+	}
+
+	return buf
+}
+
+// mp4Sample is a sample of a track fragment, its duration in the timescale
+// of the track.
+type mp4Sample struct {
+	timestamp int64 // in milliseconds
+	duration  uint32
+	keyframe  bool
+	data      []byte
+}
+
+// mp4Track buffers the samples of a track for the next fragment. The last
+// sample is held back until the next one gives its duration.
+type mp4Track struct {
+	*mkvTrack
+	timescale uint32
+
+	ready    []mp4Sample
+	held     *mp4Sample
+	duration uint32 // of the last sample given one
+}
+
+func (t *mp4Track) ticks(ms int64) int64 {
+	return ms * int64(t.timescale) / 1000
+}
+
+// mp4Writer writes a fragmented MP4 stream: the movie header announces the
+// tracks without samples, which follow in fragments of their own, so the
+// file never needs to be seeked and is playable up to the last fragment
+// even if the process dies mid-recording. Tracks are described as they are
+// for Matroska.
+type mp4Writer struct {
+	w       io.Writer
+	written int64
+
+	tracks   []*mp4Track
+	sequence uint32
+
+	fragmentOpen  bool
+	fragmentStart int64
+}
+
+func newMP4Writer(w io.Writer, tracks []*mkvTrack) (*mp4Writer, error) {
+	mw := &mp4Writer{w: w}
+
+	ftyp := mp4Box("ftyp",
+		[]byte("iso5"),
+		mp4Uint32(512),
+		[]byte("iso5iso6mp41"),
+	)
+
+	var (
+		traks [][]byte
+		trexs [][]byte
+		next  uint32
+	)
+
+	for _, track := range tracks {
+		t := &mp4Track{mkvTrack: track, timescale: 90000}
+		if track.Type == mkvTrackTypeAudio {
+			t.timescale = uint32(track.SampleRate)
+		}
+
+		mw.tracks = append(mw.tracks, t)
+
+		id := uint32(track.Number)
+		next = max(next, id+1)
+
+		traks = append(traks, mp4Trak(t))
+		trexs = append(trexs, mp4FullBox("trex", 0, 0, mp4Uint32(id, 1, 0, 0, 0)))
+	}
+
+	mvhd := mp4FullBox("mvhd", 0, 0,
+		mp4Uint32(0, 0, 1000, 0), // creation, modification, timescale, duration
+		mp4Uint32(0x00010000),    // rate 1.0
+		mp4Uint16(0x0100, 0),     // volume 1.0, reserved
+		mp4Uint32(0, 0),          // reserved
+		mp4Uint32(mp4Matrix...),
+		mp4Uint32(0, 0, 0, 0, 0, 0), // pre_defined
+		mp4Uint32(next),
+	)
+
+	moov := mp4Box("moov",
+		mvhd,
+		bytes.Join(traks, nil),
+		mp4Box("mvex", trexs...),
+	)
+
+	if err := mw.write(ftyp, moov); err != nil {
+		return nil, err
+	}
+
+	return mw, nil
+}
+
+func mp4Trak(t *mp4Track) []byte {
+	var (
+		volume        uint16
+		width, height uint32
+		handler, name string
+		header        []byte
+		entry         []byte
+	)
+
+	switch t.Type {
+	case mkvTrackTypeVideo:
+		width, height = uint32(t.Width), uint32(t.Height)
+		handler, name = "vide", "VideoHandler"
+		header = mp4FullBox("vmhd", 0, 1, mp4Uint16(0, 0, 0, 0))
+		entry = mp4Box("avc1",
+			make([]byte, 6),  // reserved
+			mp4Uint16(1),     // data_reference_index
+			make([]byte, 16), // pre_defined, reserved
+			mp4Uint16(uint16(width), uint16(height)),
+			mp4Uint32(0x00480000, 0x00480000, 0), // 72 dpi, reserved
+			mp4Uint16(1),                         // frame_count
+			make([]byte, 32),                     // compressorname
+			mp4Uint16(0x0018, 0xFFFF),            // depth, pre_defined
+			mp4Box("avcC", t.CodecPrivate),
+		)
+
+	case mkvTrackTypeAudio:
+		volume = 0x0100
+		handler, name = "soun", "SoundHandler"
+		header = mp4FullBox("smhd", 0, 0, mp4Uint16(0, 0))
+
+		dOps := []byte{0, byte(t.Channels)}
+		dOps = binary.BigEndian.AppendUint16(dOps, 3840) // pre-skip, as in the OpusHead
+		dOps = binary.BigEndian.AppendUint32(dOps, t.timescale)
+		dOps = append(dOps, 0, 0, 0) // output gain, channel mapping family
+
+		entry = mp4Box("Opus",
+			make([]byte, 6), // reserved
+			mp4Uint16(1),    // data_reference_index
+			make([]byte, 8), // reserved
+			mp4Uint16(uint16(t.Channels), 16, 0, 0),
+			mp4Uint32(t.timescale<<16),
+			mp4Box("dOps", dOps),
+		)
+	}
+
+	tkhd := mp4FullBox("tkhd", 0, 3, // enabled, in movie
+		mp4Uint32(0, 0, uint32(t.Number), 0, 0), // creation, modification, track_ID, reserved, duration
+		mp4Uint32(0, 0),                         // reserved
+		mp4Uint16(0, 0, volume, 0),              // layer, alternate_group, volume, reserved
+		mp4Uint32(mp4Matrix...),
+		mp4Uint32(width<<16, height<<16),
+	)
+
+	mdhd := mp4FullBox("mdhd", 0, 0,
+		mp4Uint32(0, 0, t.timescale, 0), // creation, modification, timescale, duration
+		mp4Uint16(0x55C4, 0),            // language und, pre_defined
+	)
+
+	hdlr := mp4FullBox("hdlr", 0, 0,
+		mp4Uint32(0),
+		[]byte(handler),
+		mp4Uint32(0, 0, 0),
+		append([]byte(name), 0),
+	)
+
+	dinf := mp4Box("dinf",
+		mp4FullBox("dref", 0, 0, mp4Uint32(1), mp4FullBox("url ", 0, 1)),
+	)
+
+	// The sample tables are empty: samples are in the fragments.
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, mp4Uint32(1), entry),
+		mp4FullBox("stts", 0, 0, mp4Uint32(0)),
+		mp4FullBox("stsc", 0, 0, mp4Uint32(0)),
+		mp4FullBox("stsz", 0, 0, mp4Uint32(0, 0)),
+		mp4FullBox("stco", 0, 0, mp4Uint32(0)),
+	)
+
+	return mp4Box("trak",
+		tkhd,
+		mp4Box("mdia", mdhd, hdlr, mp4Box("minf", header, dinf, stbl)),
+	)
+}
+
+func (mw *mp4Writer) write(chunks ...[]byte) error {
+	for _, chunk := range chunks {
+		n, err := mw.w.Write(chunk)
+		mw.written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteBlock buffers a sample at the given timestamp in milliseconds. The
+// fragment buffered so far is written on video keyframes, so fragments
+// start with one, and whenever it spans mp4FragmentDuration.
+func (mw *mp4Writer) WriteBlock(track uint64, timestamp int64, keyframe bool, video bool, data []byte) error {
+	var t *mp4Track
+	for _, candidate := range mw.tracks {
+		if candidate.Number == track {
+			t = candidate
+			break
+		}
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	if held := t.held; held != nil {
+		held.duration = uint32(max(t.ticks(timestamp)-t.ticks(held.timestamp), 0))
+		t.duration = held.duration
+		t.ready = append(t.ready, *held)
+		t.held = nil
+	}
+
+	due := (keyframe && video) ||
+		time.Duration(timestamp-mw.fragmentStart)*time.Millisecond >= mp4FragmentDuration
+
+	if mw.fragmentOpen && due {
+		if err := mw.flush(); err != nil {
+			return err
+		}
+	}
+
+	if !mw.fragmentOpen {
+		mw.fragmentOpen = true
+		mw.fragmentStart = timestamp
+	}
+
+	t.held = &mp4Sample{
+		timestamp: timestamp,
+		keyframe:  keyframe,
+		data:      data,
+	}
+
+	return nil
+}
+
+// flush writes the samples given a duration as a fragment.
+func (mw *mp4Writer) flush() error {
+	mw.fragmentOpen = false
+
+	var tracks []*mp4Track
+	for _, t := range mw.tracks {
+		if len(t.ready) > 0 {
+			tracks = append(tracks, t)
+		}
+	}
+
+	if len(tracks) == 0 {
+		return nil
+	}
+
+	mw.sequence++
+
+	// The size of the moof is known before the data offsets in it are.
+	size := 8 + 16 // moof, mfhd
+	for _, t := range tracks {
+		size += 8 + 16 + 20 + 20 + 12*len(t.ready) // traf, tfhd, tfdt, trun
+	}
+
+	var (
+		trafs  [][]byte
+		mdat   [][]byte
+		offset = size + 8
+	)
+
+	for _, t := range tracks {
+		trun := mp4Uint32(uint32(len(t.ready)), uint32(offset))
+		for _, sample := range t.ready {
+			flags := mp4SampleNonSync
+			if sample.keyframe {
+				flags = mp4SampleSync
+			}
+
+			trun = append(trun, mp4Uint32(sample.duration, uint32(len(sample.data)), flags)...)
+			mdat = append(mdat, sample.data)
+			offset += len(sample.data)
+		}
+
+		decodeTime := binary.BigEndian.AppendUint64(nil, uint64(t.ticks(t.ready[0].timestamp)))
+
+		trafs = append(trafs, mp4Box("traf",
+			mp4FullBox("tfhd", 0, 0x020000, mp4Uint32(uint32(t.Number))), // default-base-is-moof
+			mp4FullBox("tfdt", 1, 0, decodeTime),
+			mp4FullBox("trun", 0, 0x000701, trun), // data offset, sample duration, size and flags
+		))
+
+		t.ready = nil
+	}
+
+	moof := mp4Box("moof",
+		mp4FullBox("mfhd", 0, 0, mp4Uint32(mw.sequence)),
+		bytes.Join(trafs, nil),
+	)
+
+	return mw.write(moof, mp4Box("mdat", mdat...))
+}
+
+// Finish writes the held samples, each lasting as long as the one before
+// it, in a last fragment.
+func (mw *mp4Writer) Finish() error {
+	for _, t := range mw.tracks {
+		if t.held == nil {
+			continue
+		}
+
+		if t.duration == 0 {
+			t.duration = uint32(t.ticks(20)) // an Opus frame, or a 50 fps video frame
+		}
+
+		t.held.duration = t.duration
+		t.ready = append(t.ready, *t.held)
+		t.held = nil
+	}
+
+	return mw.flush()
+}
+
+func (mw *mp4Writer) Written() int64 {
+	return mw.written
+}
@@ -0,0 +1,360 @@
+package game
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	tsPacketSize = 188
+
+	tsPIDPAT   uint16 = 0x0000
+	tsPIDPMT   uint16 = 0x1000
+	tsPIDVideo uint16 = 0x0100
+	tsPIDAudio uint16 = 0x0101
+
+	tsStreamTypeH264    uint8 = 0x1B
+	tsStreamTypePrivate uint8 = 0x06
+
+	pesStreamIDVideo   uint8 = 0xE0
+	pesStreamIDPrivate uint8 = 0xBD
+)
+
+// tsDelay offsets PTS from PCR so decoders have room to buffer.
+const tsDelay = 63000 // 700ms at 90kHz
+
+// tsMuxer writes an MPEG-TS stream with a single program carrying H264 video
+// and Opus audio. Every write is a whole number of 188-byte packets.
+type tsMuxer struct {
+	w     io.Writer
+	video bool
+	audio bool
+
+	sps []byte
+	pps []byte
+
+	waitKeyframe bool
+	audioFrames  int
+	cc           map[uint16]uint8
+}
+
+func newTSMuxer(w io.Writer, video bool, audio bool) *tsMuxer {
+	return &tsMuxer{
+		w:            w,
+		video:        video,
+		audio:        audio,
+		waitKeyframe: video,
+		cc:           make(map[uint16]uint8),
+	}
+}
+
+// Reset drops everything up to the next keyframe, e.g. after output was lost.
+func (mux *tsMuxer) Reset() {
+	mux.waitKeyframe = mux.video
+}
+
+func (mux *tsMuxer) pcrPID() uint16 {
+	if mux.video {
+		return tsPIDVideo
+	}
+
+	return tsPIDAudio
+}
+
+// WriteVideo muxes an Annex B access unit at the given 90kHz timestamp.
+// Tables are repeated and parameter sets re-inserted before each keyframe
+// so receivers can join at any IDR.
+func (mux *tsMuxer) WriteVideo(pts int64, data []byte) error {
+	var (
+		nals     [][]byte
+		keyframe bool
+	)
+
+	for _, nal := range splitAnnexB(data) {
+		switch nalType(nal) {
+		case nalTypeSPS:
+			mux.sps = append(mux.sps[:0], nal...)
+			continue
+
+		case nalTypePPS:
+			mux.pps = append(mux.pps[:0], nal...)
+			continue
+
+		case nalTypeAUD:
+			continue
+
+		case nalTypeIDR:
+			keyframe = true
+		}
+
+		nals = append(nals, nal)
+	}
+
+	if len(nals) == 0 || (mux.waitKeyframe && !keyframe) {
+		return nil
+	}
+
+	mux.waitKeyframe = false
+
+	es := []byte{0, 0, 0, 1, 0x09, 0xF0} // access unit delimiter
+	if keyframe && mux.sps != nil && mux.pps != nil {
+		es = append(es, 0, 0, 0, 1)
+		es = append(es, mux.sps...)
+		es = append(es, 0, 0, 0, 1)
+		es = append(es, mux.pps...)
+	}
+
+	for _, nal := range nals {
+		es = append(es, 0, 0, 0, 1)
+		es = append(es, nal...)
+	}
+
+	if keyframe {
+		if err := mux.writeTables(); err != nil {
+			return err
+		}
+	}
+
+	pes := pesPacket(pesStreamIDVideo, pts+tsDelay, es, false)
+
+	return mux.writePES(tsPIDVideo, pes, pts, keyframe)
+}
+
+// WriteAudio muxes an Opus packet using the Opus-in-TS control header framing.
+func (mux *tsMuxer) WriteAudio(pts int64, packet []byte) error {
+	if mux.waitKeyframe {
+		return nil
+	}
+
+	es := []byte{0x7F, 0xE0}
+
+	size := len(packet)
+	for size >= 255 {
+		es = append(es, 0xFF)
+		size -= 255
+	}
+
+	es = append(es, byte(size))
+	es = append(es, packet...)
+
+	pes := pesPacket(pesStreamIDPrivate, pts+tsDelay, es, true)
+
+	// Without video, tables are repeated about once a second instead.
+	random := !mux.video && mux.audioFrames%50 == 0
+	mux.audioFrames++
+
+	if random {
+		if err := mux.writeTables(); err != nil {
+			return err
+		}
+	}
+
+	return mux.writePES(tsPIDAudio, pes, pts, random)
+}
+
+func (mux *tsMuxer) writeTables() error {
+	pat := []byte{
+		0x00, 0x01, // transport_stream_id
+		0xC1, 0x00, 0x00,
+		0x00, 0x01, // program_number
+	}
+	pat = appendPID(pat, tsPIDPMT)
+
+	pmt := []byte{
+		0x00, 0x01, // program_number
+		0xC1, 0x00, 0x00,
+	}
+	pmt = appendPID(pmt, mux.pcrPID())
+	pmt = append(pmt, 0xF0, 0x00) // program_info_length
+
+	if mux.video {
+		pmt = append(pmt, tsStreamTypeH264)
+		pmt = appendPID(pmt, tsPIDVideo)
+		pmt = append(pmt, 0xF0, 0x00)
+	}
+
+	if mux.audio {
+		descriptors := []byte{
+			0x05, 0x04, 'O', 'p', 'u', 's', // registration descriptor
+			0x7F, 0x02, 0x80, 0x02, // extension descriptor, stereo
+		}
+
+		pmt = append(pmt, tsStreamTypePrivate)
+		pmt = appendPID(pmt, tsPIDAudio)
+		pmt = append(pmt, 0xF0, byte(len(descriptors)))
+		pmt = append(pmt, descriptors...)
+	}
+
+	if err := mux.writeSection(tsPIDPAT, 0x00, pat); err != nil {
+		return err
+	}
+
+	return mux.writeSection(tsPIDPMT, 0x02, pmt)
+}
+
+// appendPID appends a 13-bit PID with the three reserved bits set.
+func appendPID(buf []byte, pid uint16) []byte {
+	return binary.BigEndian.AppendUint16(buf, 0xE000|pid)
+}
+
+func (mux *tsMuxer) writeSection(pid uint16, tableID uint8, body []byte) error {
+	length := len(body) + 4 // CRC
+
+	section := []byte{tableID, 0xB0 | byte(length>>8), byte(length)}
+	section = append(section, body...)
+	section = binary.BigEndian.AppendUint32(section, crc32MPEG(section))
+
+	pkt := make([]byte, tsPacketSize)
+	for i := range pkt {
+		pkt[i] = 0xFF
+	}
+
+	mux.header(pkt, pid, true, false)
+	pkt[4] = 0x00 // pointer_field
+	copy(pkt[5:], section)
+
+	_, err := mux.w.Write(pkt)
+	return err
+}
+
+func (mux *tsMuxer) header(pkt []byte, pid uint16, start bool, adaptation bool) {
+	pkt[0] = 0x47
+	pkt[1] = byte(pid>>8) & 0x1F
+	if start {
+		pkt[1] |= 0x40
+	}
+
+	pkt[2] = byte(pid)
+
+	cc := mux.cc[pid]
+	mux.cc[pid] = (cc + 1) & 0x0F
+
+	pkt[3] = 0x10 | cc
+	if adaptation {
+		pkt[3] |= 0x20
+	}
+}
+
+func (mux *tsMuxer) writePES(pid uint16, pes []byte, pts int64, random bool) error {
+	buf := make([]byte, 0, (len(pes)/(tsPacketSize-4)+2)*tsPacketSize)
+
+	first := true
+	for len(pes) > 0 {
+		pkt := make([]byte, tsPacketSize)
+
+		var af []byte
+		if first && (random || pid == mux.pcrPID()) {
+			af = []byte{0x00}
+			if random {
+				af[0] |= 0x40 // random_access_indicator
+			}
+
+			if pid == mux.pcrPID() {
+				af[0] |= 0x10 // PCR_flag
+				af = append(af, pcrBytes(pts)...)
+			}
+		}
+
+		space := tsPacketSize - 4
+		if af != nil {
+			space -= 1 + len(af)
+		}
+
+		if len(pes) < space {
+			stuffing := space - len(pes)
+			if af == nil {
+				// An empty adaptation field takes a single length byte.
+				af = []byte{}
+				stuffing--
+				if stuffing > 0 {
+					af = append(af, 0x00)
+					stuffing--
+				}
+			}
+
+			for range stuffing {
+				af = append(af, 0xFF)
+			}
+
+			space = len(pes)
+		}
+
+		mux.header(pkt, pid, first, af != nil)
+
+		n := 4
+		if af != nil {
+			pkt[n] = byte(len(af))
+			copy(pkt[n+1:], af)
+			n += 1 + len(af)
+		}
+
+		copy(pkt[n:], pes[:space])
+		pes = pes[space:]
+
+		buf = append(buf, pkt...)
+		first = false
+	}
+
+	_, err := mux.w.Write(buf)
+	return err
+}
+
+func pesPacket(streamID uint8, pts int64, es []byte, bounded bool) []byte {
+	pes := []byte{0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80, 0x80, 0x05}
+	pes = append(pes, ptsBytes(0x20, pts)...)
+
+	if length := len(pes) - 6 + len(es); bounded && length <= 0xFFFF {
+		binary.BigEndian.PutUint16(pes[4:], uint16(length))
+	}
+
+	return append(pes, es...)
+}
+
+func ptsBytes(prefix byte, pts int64) []byte {
+	return []byte{
+		prefix | byte(pts>>29)&0x0E | 0x01,
+		byte(pts >> 22),
+		byte(pts>>14) | 0x01,
+		byte(pts >> 7),
+		byte(pts<<1) | 0x01,
+	}
+}
+
+func pcrBytes(pts int64) []byte {
+	base := uint64(pts) & 0x1FFFFFFFF
+	return []byte{
+		byte(base >> 25),
+		byte(base >> 17),
+		byte(base >> 9),
+		byte(base >> 1),
+		byte(base<<7) | 0x7E,
+		0x00,
+	}
+}
+
+var crc32MPEGTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+
+		table[i] = crc
+	}
+
+	return table
+}()
+
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc = crc<<8 ^ crc32MPEGTable[byte(crc>>24)^b]
+	}
+
+	return crc
+}
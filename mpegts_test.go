@@ -0,0 +1,58 @@
+package game
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRC32MPEG(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(uint32(0x0376E6E7), crc32MPEG([]byte("123456789")))
+}
+
+func TestTSMuxer(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	mux := newTSMuxer(&buf, true, true)
+
+	// dropped until the first keyframe
+	assert.NoError(mux.WriteAudio(0, []byte{0xFC, 1}))
+	assert.NoError(mux.WriteVideo(0, []byte{0, 0, 0, 1, 0x41, 1}))
+	assert.Zero(buf.Len())
+
+	sps := []byte{0, 0, 0, 1, 0x67, 0x64, 0x00, 0x1F, 0xAC}
+	pps := []byte{0, 0, 0, 1, 0x68, 0xEE, 0x3C, 0x80}
+	assert.NoError(mux.WriteVideo(0, sps))
+	assert.NoError(mux.WriteVideo(0, pps))
+	assert.Zero(buf.Len())
+
+	idr := append([]byte{0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{0x88}, 400)...)
+	assert.NoError(mux.WriteVideo(3000, idr))
+	assert.NoError(mux.WriteAudio(3000, []byte{0xFC, 2}))
+
+	out := buf.Bytes()
+	assert.Zero(len(out) % tsPacketSize)
+
+	var pids []uint16
+	for i := 0; i < len(out); i += tsPacketSize {
+		pkt := out[i : i+tsPacketSize]
+		assert.Equal(byte(0x47), pkt[0])
+
+		pids = append(pids, uint16(pkt[1]&0x1F)<<8|uint16(pkt[2]))
+	}
+
+	// PAT, PMT, three video packets, one audio packet
+	assert.Equal([]uint16{tsPIDPAT, tsPIDPMT, tsPIDVideo, tsPIDVideo, tsPIDVideo, tsPIDAudio}, pids)
+
+	// the keyframe carries the parameter sets
+	assert.True(bytes.Contains(out, sps[4:]))
+	assert.True(bytes.Contains(out, pps[4:]))
+
+	// PAT section CRC
+	section := out[5 : 5+3+5+4+4]
+	assert.Zero(crc32MPEG(section))
+}
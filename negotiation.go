@@ -0,0 +1,179 @@
+package game
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Downgrade reports where a peer gets less than the stream's preferred
+// configuration because of what its offer supports.
+type Downgrade struct {
+	Track     string `json:"track"`
+	Property  string `json:"property"`
+	Preferred string `json:"preferred"`
+	Applied   string `json:"applied"`
+	Reason    string `json:"reason"`
+}
+
+type offeredCodec struct {
+	name string
+	fmtp string
+}
+
+// matchCapabilities compares the stream's tracks with the codecs in the
+// offer, and picks the video track of the most preferred codec the offer
+// supports; the stream's video track if it supports none.
+func matchCapabilities(stream *Stream, offer webrtc.SessionDescription) (*VideoTrack, []Downgrade, error) {
+	desc, err := offer.Unmarshal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offered := make(map[string][]offeredCodec)
+	for _, media := range desc.MediaDescriptions {
+		kind := media.MediaName.Media
+
+		fmtps := make(map[string]string)
+		for _, attr := range media.Attributes {
+			if attr.Key != "fmtp" {
+				continue
+			}
+
+			pt, params, _ := strings.Cut(attr.Value, " ")
+			fmtps[pt] = params
+		}
+
+		for _, attr := range media.Attributes {
+			if attr.Key != "rtpmap" {
+				continue
+			}
+
+			// e.g. "111 opus/48000/2"
+			pt, encoding, ok := strings.Cut(attr.Value, " ")
+			if !ok {
+				continue
+			}
+
+			name, _, _ := strings.Cut(encoding, "/")
+
+			offered[kind] = append(offered[kind], offeredCodec{
+				name: strings.ToLower(name),
+				fmtp: fmtps[pt],
+			})
+		}
+	}
+
+	var downgrades []Downgrade
+
+	video := stream.Video
+	if video != nil {
+		preference := stream.codecPreference()
+
+		applied := "none"
+		for _, codec := range preference {
+			if _, ok := findCodec(offered["video"], codec); ok {
+				video = stream.videoTrack(codec)
+				applied = codecName(codec)
+				break
+			}
+		}
+
+		if applied != codecName(preference[0]) {
+			downgrades = append(downgrades, Downgrade{
+				Track:     "video",
+				Property:  "codec",
+				Preferred: codecName(preference[0]),
+				Applied:   applied,
+				Reason:    "codec not supported by client",
+			})
+		}
+	}
+
+	if audio := stream.Audio; audio != nil {
+		codec, ok := findCodec(offered["audio"], audio.Codec())
+
+		switch {
+		case !ok:
+			downgrades = append(downgrades, Downgrade{
+				Track:     "audio",
+				Property:  "codec",
+				Preferred: codecName(audio.Codec()),
+				Applied:   "none",
+				Reason:    "codec not supported by client",
+			})
+
+		case audio.Codec() == CodecOpus && hasFmtpParam(codec.fmtp, "stereo=0"):
+			downgrades = append(downgrades, Downgrade{
+				Track:     "audio",
+				Property:  "channels",
+				Preferred: "stereo",
+				Applied:   "mono",
+				Reason:    "client prefers mono",
+			})
+		}
+	}
+
+	return video, downgrades, nil
+}
+
+func codecName(codec Codec) string {
+	_, name, _ := strings.Cut(codec.MimeType(), "/")
+	return name
+}
+
+func findCodec(codecs []offeredCodec, codec Codec) (offeredCodec, bool) {
+	name := strings.ToLower(codecName(codec))
+	for _, c := range codecs {
+		if c.name == name {
+			return c, true
+		}
+	}
+
+	return offeredCodec{}, false
+}
+
+func hasFmtpParam(fmtp string, param string) bool {
+	for _, p := range strings.Split(fmtp, ";") {
+		if strings.TrimSpace(p) == param {
+			return true
+		}
+	}
+
+	return false
+}
+
+// opusCodec is the Opus codec of a peer's media engine signaling fmtp,
+// in place of pion's default, whose payload type it keeps. Offers carry it
+// from the media engine, answers from preferOpus.
+func opusCodec(fmtp string) webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeOpus,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: fmtp,
+		},
+		PayloadType: 111,
+	}
+}
+
+// preferOpus has the transceiver of an Opus sender answer with fmtp. Pion
+// answers with the parameters of the offer otherwise. The payload type is
+// left to the offer.
+func preferOpus(pc *webrtc.PeerConnection, sender *webrtc.RTPSender, fmtp string) error {
+	if fmtp == "" {
+		return nil
+	}
+
+	codec := opusCodec(fmtp)
+	codec.PayloadType = 0
+
+	for _, t := range pc.GetTransceivers() {
+		if t.Sender() == sender {
+			return t.SetCodecPreferences([]webrtc.RTPCodecParameters{codec})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package game
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/session"
+)
+
+const testOfferSDP = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 minptime=10;stereo=0;useinbandfec=1\r\n"
+
+func TestMatchCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := &Stream{
+		Video: &VideoTrack{codec: CodecH264},
+		Audio: &AudioTrack{codec: CodecOpus},
+	}
+
+	offer := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  testOfferSDP,
+	}
+
+	video, downgrades, err := matchCapabilities(stream, offer)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(stream.Video, video)
+	assert.Equal([]Downgrade{
+		{"video", "codec", "H264", "none", "codec not supported by client"},
+		{"audio", "channels", "stereo", "mono", "client prefers mono"},
+	}, downgrades)
+
+	stream.Video.codec = CodecVP8
+
+	_, downgrades, err = matchCapabilities(stream, offer)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Len(downgrades, 1)
+}
+
+func TestCodecPreference(t *testing.T) {
+	assert := assert.New(t)
+
+	h264 := &VideoTrack{codec: CodecH264}
+	h265 := &VideoTrack{codec: CodecH265}
+	av1 := &VideoTrack{codec: CodecAV1}
+
+	stream := &Stream{
+		Transport:       TransportRaw,
+		Video:           h264,
+		Variants:        []*VideoTrack{h265, av1},
+		CodecPreference: []Codec{CodecAV1, CodecH265, CodecH264},
+	}
+
+	assert.NoError(stream.checkVariants())
+
+	offer := func(codecs ...string) webrtc.SessionDescription {
+		sdp := "v=0\r\n" +
+			"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+			"s=-\r\n" +
+			"t=0 0\r\n" +
+			"m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n" +
+			"c=IN IP4 0.0.0.0\r\n"
+
+		for i, codec := range codecs {
+			sdp += "a=rtpmap:" + strconv.Itoa(96+i) + " " + codec + "/90000\r\n"
+		}
+
+		return webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+	}
+
+	video, downgrades, err := matchCapabilities(stream, offer("H264", "H265"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(h265, video)
+	assert.Equal([]Downgrade{
+		{"video", "codec", "AV1", "H265", "codec not supported by client"},
+	}, downgrades)
+
+	video, downgrades, err = matchCapabilities(stream, offer("AV1", "H264"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(av1, video)
+	assert.Empty(downgrades)
+
+	video, _, err = matchCapabilities(stream, offer("H264"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(h264, video)
+
+	// without a preference, the video track comes first
+	stream.CodecPreference = nil
+
+	video, _, err = matchCapabilities(stream, offer("AV1", "H264"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(h264, video)
+
+	stream.CodecPreference = []Codec{CodecVP9}
+	assert.Error(stream.checkVariants())
+
+	stream.CodecPreference = nil
+	stream.Variants = []*VideoTrack{{codec: CodecH264}}
+	assert.Error(stream.checkVariants())
+
+	stream.Variants = []*VideoTrack{h265}
+	stream.Transport = TransportNV
+	assert.Error(stream.checkVariants())
+}
+
+func TestOpusParams(t *testing.T) {
+	assert := assert.New(t)
+
+	audio := &AudioTrack{codec: CodecOpus, dtx: true}
+	assert.Equal("minptime=10;useinbandfec=0;usedtx=1", audio.Fmtp())
+
+	audio.fec = true
+
+	api, err := newPeerAPI(DTLSRoleAuto, new(dtlsHandshake), peerMedia{opusFmtp: audio.Fmtp()})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	server, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer server.Close()
+
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus},
+		"audio", "stream",
+	)
+
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	sender, err := server.AddTrack(track)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if err := preferOpus(server, sender, audio.Fmtp()); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer client.Close()
+
+	_, err = client.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	})
+
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if err := client.SetLocalDescription(offer); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	signaling, err := session.NewSessionManager().Open("peer", server)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	answer, err := signaling.Answer(context.Background(), offer)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Contains(answer.SDP, "a=fmtp:111 minptime=10;useinbandfec=1;usedtx=1\r\n")
+	assert.NoError(client.SetRemoteDescription(*answer))
+}
@@ -0,0 +1,336 @@
+package game
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/stun/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// NATMapping is how the host's NAT maps its UDP sockets to public
+// addresses, as far as STUN tells.
+type NATMapping string
+
+const (
+	NATMappingNone                NATMapping = "none" // the host has a public address
+	NATMappingEndpointIndependent NATMapping = "endpoint-independent"
+	NATMappingEndpointDependent   NATMapping = "endpoint-dependent" // symmetric NAT
+	NATMappingUnknown             NATMapping = "unknown"
+)
+
+type STUNResult struct {
+	Server string        `json:"server"`
+	Mapped string        `json:"mapped,omitempty"`
+	RTT    time.Duration `json:"rtt,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type TURNResult struct {
+	Server    string `json:"server"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NetCheckReport is what a network check found about the host's
+// connectivity, and whether peers will need a TURN relay to reach it.
+type NetCheckReport struct {
+	UDP          bool         `json:"udp"`
+	Mapping      NATMapping   `json:"mapping"`
+	Hairpin      *bool        `json:"hairpin,omitempty"` // nil when not tested
+	STUN         []STUNResult `json:"stun"`
+	TURN         []TURNResult `json:"turn"`
+	TURNRequired bool         `json:"turn_required"`
+	Verdict      string       `json:"verdict"`
+}
+
+// TURNReachable reports whether any TURN server answered.
+func (r *NetCheckReport) TURNReachable() bool {
+	for _, t := range r.TURN {
+		if t.Reachable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NetCheck runs STUN binding tests from a single socket against the STUN
+// servers, so differing mapped addresses reveal a symmetric NAT, tests
+// hairpinning and whether the TURN servers are reachable. Each probe waits
+// for timeout at most.
+func NetCheck(ctx context.Context, servers []webrtc.ICEServer, timeout time.Duration) (*NetCheckReport, error) {
+	var stunURIs, turnURIs []*stun.URI
+	seen := make(map[string]bool)
+	for _, server := range servers {
+		for _, raw := range server.URLs {
+			if seen[raw] {
+				continue
+			}
+			seen[raw] = true
+
+			uri, err := stun.ParseURI(raw)
+			if err != nil {
+				continue
+			}
+
+			switch uri.Scheme {
+			case stun.SchemeTypeSTUN:
+				stunURIs = append(stunURIs, uri)
+			case stun.SchemeTypeTURN, stun.SchemeTypeTURNS:
+				turnURIs = append(turnURIs, uri)
+			}
+		}
+	}
+
+	if len(stunURIs) == 0 && len(turnURIs) == 0 {
+		return nil, errors.New("no ice servers to check")
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	report := &NetCheckReport{
+		Mapping: NATMappingUnknown,
+	}
+
+	// mapped addresses by STUN server IP
+	mapped := make(map[string]*net.UDPAddr)
+	for _, uri := range stunURIs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result := STUNResult{Server: uri.String()}
+
+		addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port)))
+		if err == nil {
+			var m *net.UDPAddr
+			m, result.RTT, err = stunBinding(conn, addr, timeout)
+			if err == nil {
+				result.Mapped = m.String()
+				mapped[addr.IP.String()] = m
+			}
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		report.STUN = append(report.STUN, result)
+	}
+
+	report.UDP = len(mapped) > 0
+	report.Mapping = natMapping(mapped)
+
+	if report.UDP && report.Mapping != NATMappingNone {
+		for _, m := range mapped {
+			hairpin := testHairpin(conn, m, timeout)
+			report.Hairpin = &hairpin
+			break
+		}
+	}
+
+	for _, uri := range turnURIs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result := TURNResult{Server: uri.String()}
+		if err := probeTURN(conn, uri, timeout); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Reachable = true
+		}
+
+		report.TURN = append(report.TURN, result)
+	}
+
+	report.TURNRequired, report.Verdict = verdict(report)
+
+	return report, nil
+}
+
+// stunBinding sends a binding request to server and returns the address
+// it saw the request come from.
+func stunBinding(conn *net.UDPConn, server *net.UDPAddr, timeout time.Duration) (*net.UDPAddr, time.Duration, error) {
+	req, err := stun.Build(stun.TransactionID, stun.BindingRequest, stun.Fingerprint)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteToUDP(req.Raw, server); err != nil {
+		return nil, 0, err
+	}
+
+	conn.SetReadDeadline(start.Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !from.IP.Equal(server.IP) || from.Port != server.Port || !stun.IsMessage(buf[:n]) {
+			continue
+		}
+
+		res := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err := res.Decode(); err != nil || res.TransactionID != req.TransactionID {
+			continue
+		}
+
+		var addr stun.XORMappedAddress
+		if err := addr.GetFrom(res); err != nil {
+			return nil, 0, err
+		}
+
+		return &net.UDPAddr{IP: addr.IP, Port: addr.Port}, time.Since(start), nil
+	}
+}
+
+// natMapping tells the mapping from the addresses STUN servers at
+// different IPs saw.
+func natMapping(mapped map[string]*net.UDPAddr) NATMapping {
+	var first *net.UDPAddr
+	for _, m := range mapped {
+		if first == nil {
+			first = m
+			continue
+		}
+
+		if !m.IP.Equal(first.IP) || m.Port != first.Port {
+			return NATMappingEndpointDependent
+		}
+	}
+
+	if first == nil {
+		return NATMappingUnknown
+	}
+
+	if isLocalIP(first.IP) {
+		return NATMappingNone
+	}
+
+	if len(mapped) < 2 {
+		return NATMappingUnknown
+	}
+
+	return NATMappingEndpointIndependent
+}
+
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// testHairpin sends a packet from another socket to the public address of
+// conn; it only arrives if the NAT loops it back.
+func testHairpin(conn *net.UDPConn, public *net.UDPAddr, timeout time.Duration) bool {
+	other, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return false
+	}
+	defer other.Close()
+
+	probe := []byte("hairpin")
+	if _, err := other.WriteToUDP(probe, public); err != nil {
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return false
+		}
+
+		if string(buf[:n]) == string(probe) {
+			return true
+		}
+	}
+}
+
+// probeTURN checks a TURN server answers: over UDP with a binding request,
+// over TCP and TLS by connecting.
+func probeTURN(conn *net.UDPConn, uri *stun.URI, timeout time.Duration) error {
+	hostport := net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port))
+
+	if uri.Scheme == stun.SchemeTypeTURN && uri.Proto != stun.ProtoTypeTCP {
+		addr, err := net.ResolveUDPAddr("udp4", hostport)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = stunBinding(conn, addr, timeout)
+		return err
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+
+	if uri.Scheme == stun.SchemeTypeTURNS {
+		c, err := tls.DialWithDialer(dialer, "tcp", hostport, &tls.Config{ServerName: uri.Host})
+		if err != nil {
+			return err
+		}
+
+		return c.Close()
+	}
+
+	c, err := dialer.Dial("tcp", hostport)
+	if err != nil {
+		return err
+	}
+
+	return c.Close()
+}
+
+func verdict(r *NetCheckReport) (bool, string) {
+	var required bool
+	var msg string
+
+	switch {
+	case !r.UDP:
+		required = true
+		msg = "UDP is blocked: peers can only connect through TURN over TCP or TLS"
+	case r.Mapping == NATMappingEndpointDependent:
+		required = true
+		msg = "symmetric NAT: most peers will need TURN to reach this host"
+	case r.Mapping == NATMappingNone:
+		msg = "public address: direct connections should work"
+	case r.Mapping == NATMappingEndpointIndependent:
+		msg = "endpoint-independent NAT: direct connections should work, except with peers behind symmetric NATs"
+	default:
+		required = true
+		msg = "NAT mapping unknown: configure a TURN provider to be safe"
+	}
+
+	if required && !r.TURNReachable() {
+		msg += ", but no TURN server is reachable"
+	}
+
+	return required, msg
+}
@@ -0,0 +1,104 @@
+package game
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/stun/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSTUN answers binding requests with the address they came from.
+func serveSTUN(t *testing.T) string {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if err := req.Decode(); err != nil {
+				continue
+			}
+
+			res, err := stun.Build(req, stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: from.IP, Port: from.Port},
+				stun.Fingerprint,
+			)
+			if err != nil {
+				continue
+			}
+
+			conn.WriteToUDP(res.Raw, from)
+		}
+	}()
+
+	return "stun:" + conn.LocalAddr().String()
+}
+
+func TestNetCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := []webrtc.ICEServer{
+		{URLs: []string{serveSTUN(t)}},
+	}
+
+	report, err := NetCheck(context.Background(), servers, time.Second)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(report.UDP)
+	assert.Equal(NATMappingNone, report.Mapping)
+	assert.Nil(report.Hairpin)
+	assert.False(report.TURNRequired)
+
+	if assert.Len(report.STUN, 1) {
+		assert.Empty(report.STUN[0].Error)
+		assert.Contains(report.STUN[0].Mapped, "127.0.0.1:")
+	}
+}
+
+func TestNetCheckVerdict(t *testing.T) {
+	assert := assert.New(t)
+
+	mapped := map[string]*net.UDPAddr{
+		"192.0.2.1": {IP: net.IPv4(203, 0, 113, 7), Port: 40000},
+		"192.0.2.2": {IP: net.IPv4(203, 0, 113, 7), Port: 40000},
+	}
+	assert.Equal(NATMappingEndpointIndependent, natMapping(mapped))
+
+	mapped["192.0.2.2"] = &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 40001}
+	assert.Equal(NATMappingEndpointDependent, natMapping(mapped))
+
+	report := &NetCheckReport{UDP: true, Mapping: NATMappingEndpointDependent}
+
+	required, msg := verdict(report)
+	assert.True(required)
+	assert.Contains(msg, "no TURN server is reachable")
+
+	report.TURN = []TURNResult{{Server: "turn:turn.example.com:3478", Reachable: true}}
+
+	required, msg = verdict(report)
+	assert.True(required)
+	assert.NotContains(msg, "no TURN server")
+
+	report = &NetCheckReport{}
+
+	required, msg = verdict(report)
+	assert.True(required)
+	assert.Contains(msg, "UDP is blocked")
+}
@@ -113,7 +113,9 @@ func (as *audioStream) Cleanup() {
 }
 
 func (as *audioStream) PlayEncodedSample(sampleData []byte, sampleLength int) {
-	if as.closed || sampleLength == 0 {
+	// Decrypted packets lose their padding, and may be shorter than the
+	// buffer; lost ones come empty.
+	if as.closed || sampleLength <= 0 || sampleLength > len(sampleData) {
 		return
 	}
 
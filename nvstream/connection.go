@@ -4,19 +4,144 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/flarexio/game/telemetry"
 	"github.com/flarexio/game/thirdparty/moonlight"
 )
 
 type NvConnection interface {
 	StartApp(ctx context.Context, app NvApp) error
+	ResumeApp(ctx context.Context) error
 	StopApp(ctx context.Context) error
+
+	// Relocate restarts the connection on the host of http, to its app.
+	// The connection stays on its host if the restart fails.
+	Relocate(ctx context.Context, http NvHTTP, app NvApp) error
+
+	// SetBitrate restarts the connection with the video bitrate in kbps,
+	// which the host only reads when a connection starts.
+	SetBitrate(ctx context.Context, kbps int) error
+
+	// Reconfigure restarts the connection with the video settings, as
+	// SetBitrate does.
+	Reconfigure(ctx context.Context, settings VideoSettings) error
+
+	// SetHDR restarts the connection asking the host for HDR video, in the
+	// 10-bit profiles of the video formats, or back to SDR.
+	SetHDR(ctx context.Context, enabled bool) error
+
+	// SetupCallbacks sets the renderers of the video and audio, before the
+	// connection starts.
+	SetupCallbacks(vr moonlight.VideoDecoderRenderer, ar moonlight.AudioRenderer)
+
+	Close() error
+
+	// Stage reports how far the connection got.
+	Stage() ConnectionStage
+
+	// Terminated delivers the error code of a connection that ended without
+	// being stopped.
+	Terminated() <-chan int
+
+	// ControllerLEDs delivers the light bar colors the host sets on
+	// controllers. Colors are dropped while the buffer is full.
+	ControllerLEDs() <-chan ControllerLED
+
+	// HDRModes delivers the HDR mode the host switches the video to. Modes
+	// are dropped while the buffer is full.
+	HDRModes() <-chan HDRMode
+
+	// Stats reports the RTT, the frames pending and the stage timing of
+	// the connection, false unless it is connected.
+	Stats() (moonlight.ConnectionStats, bool)
+
 	moonlight.ConnectionListener
 }
 
+// VideoSettings change the video of a connection; zero fields keep their
+// value.
+type VideoSettings struct {
+	Width       int
+	Height      int
+	RefreshRate int
+	Bitrate     int // kbps
+}
+
+func (s VideoSettings) apply(cfg *StreamConfiguration) {
+	if s.Width > 0 && s.Height > 0 {
+		cfg.Width = s.Width
+		cfg.Height = s.Height
+	}
+
+	if s.RefreshRate > 0 {
+		cfg.RefreshRate = s.RefreshRate
+	}
+
+	if s.Bitrate > 0 {
+		cfg.Bitrate = s.Bitrate
+	}
+}
+
+// ControllerLED is a light bar color the host set on a controller.
+type ControllerLED struct {
+	Controller uint16
+	R, G, B    uint8
+}
+
+// HDRMode is whether the host sends HDR video, with the mastering display
+// and content light levels when it knows them.
+type HDRMode struct {
+	Enabled  bool
+	Metadata *moonlight.HDRMetadata
+}
+
+// hdrVideoFormats returns the 10-bit profiles of the formats, leaving out
+// H.264, which has none.
+func hdrVideoFormats(formats []moonlight.VideoFormat) []moonlight.VideoFormat {
+	var hdr []moonlight.VideoFormat
+	for _, format := range formats {
+		switch format {
+		case moonlight.VIDEO_FORMAT_H265, moonlight.VIDEO_FORMAT_H265_MAIN10:
+			format = moonlight.VIDEO_FORMAT_H265_MAIN10
+		case moonlight.VIDEO_FORMAT_H265_REXT8_444, moonlight.VIDEO_FORMAT_H265_REXT10_444:
+			format = moonlight.VIDEO_FORMAT_H265_REXT10_444
+		case moonlight.VIDEO_FORMAT_AV1_MAIN8, moonlight.VIDEO_FORMAT_AV1_MAIN10:
+			format = moonlight.VIDEO_FORMAT_AV1_MAIN10
+		case moonlight.VIDEO_FORMAT_AV1_HIGH8_444, moonlight.VIDEO_FORMAT_AV1_HIGH10_444:
+			format = moonlight.VIDEO_FORMAT_AV1_HIGH10_444
+		default:
+			continue
+		}
+
+		if !slices.Contains(hdr, format) {
+			hdr = append(hdr, format)
+		}
+	}
+
+	return hdr
+}
+
+// ConnectionStage is the state of a connection: idle before it starts, the
+// moonlight stage name while starting, then connected until it fails,
+// terminates or is stopped.
+type ConnectionStage string
+
+const (
+	ConnectionStageIdle       ConnectionStage = "idle"
+	ConnectionStageConnected  ConnectionStage = "connected"
+	ConnectionStageFailed     ConnectionStage = "failed"
+	ConnectionStageTerminated ConnectionStage = "terminated"
+	ConnectionStageStopped    ConnectionStage = "stopped"
+)
+
 func NewConnection(http NvHTTP, stream *StreamConfiguration) (NvConnection, error) {
 	log := zap.L().With(
 		zap.String("component", "nvstream.connection"),
@@ -27,22 +152,213 @@ func NewConnection(http NvHTTP, stream *StreamConfiguration) (NvConnection, erro
 		return nil, err
 	}
 
-	return &nvConnection{
-		log:    log,
-		http:   http,
-		stream: stream,
-		ri:     ri,
-	}, nil
+	conn := &nvConnection{
+		log:        log,
+		http:       http,
+		stream:     stream,
+		ri:         ri,
+		terminated: make(chan int, 1),
+		leds:       make(chan ControllerLED, 16),
+		hdrModes:   make(chan HDRMode, 4),
+	}
+
+	conn.stage.Store(ConnectionStageIdle)
+
+	return conn, nil
 }
 
 type nvConnection struct {
-	log    *zap.Logger
-	http   NvHTTP
-	stream *StreamConfiguration
-	ri     *moonlight.RemoteInputAES
+	log        *zap.Logger
+	http       NvHTTP
+	stream     *StreamConfiguration
+	ri         *moonlight.RemoteInputAES
+	app        NvApp
+	callbacks  *moonlight.Callbacks
+	terminated chan int
+	leds       chan ControllerLED
+	hdrModes   chan HDRMode
+	hdr        bool // asked for with SetHDR
+
+	// written from moonlight callbacks while start holds the lock
+	stage atomic.Value
+	spans connectionSpans
+
+	// statsGen ends the watch of the previous connection
+	statsGen atomic.Uint64
+
+	sync.Mutex
+}
+
+// connectionSpans traces the moonlight stages of a starting connection,
+// such as the RTSP handshake, under the span of start.
+type connectionSpans struct {
+	ctx   context.Context
+	stage trace.Span // nil between stages
+	sync.Mutex
+}
+
+func (s *connectionSpans) begin(ctx context.Context) {
+	s.Lock()
+	s.ctx = ctx
+	s.Unlock()
+}
+
+func (s *connectionSpans) stageStarting(name string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.ctx == nil {
+		return
+	}
+
+	if s.stage != nil {
+		s.stage.End()
+	}
+
+	_, s.stage = telemetry.Start(s.ctx, "nvstream.stage", attribute.String("nvstream.stage", name))
+}
+
+func (s *connectionSpans) stageEnded(err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.stage == nil {
+		return
+	}
+
+	telemetry.RecordError(s.stage, err)
+	s.stage.End()
+	s.stage = nil
+}
+
+func (s *connectionSpans) end() {
+	s.stageEnded(nil)
+
+	s.Lock()
+	s.ctx = nil
+	s.Unlock()
+}
+
+func (conn *nvConnection) SetupCallbacks(vr moonlight.VideoDecoderRenderer, ar moonlight.AudioRenderer) {
+	conn.Lock()
+	defer conn.Unlock()
+
+	conn.callbacks = moonlight.SetupCallbacks(conn, vr, ar)
 }
 
 func (conn *nvConnection) StartApp(ctx context.Context, app NvApp) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	conn.app = app
+
+	return conn.start(ctx)
+}
+
+// ResumeApp restarts a terminated connection to the app of StartApp with a
+// new remote input key. The app is launched again if it no longer runs on
+// the host.
+func (conn *nvConnection) ResumeApp(ctx context.Context) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	return conn.restart(ctx)
+}
+
+func (conn *nvConnection) Relocate(ctx context.Context, http NvHTTP, app NvApp) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	prevHTTP, prevApp := conn.http, conn.app
+	conn.http, conn.app = http, app
+
+	if err := conn.restart(ctx); err != nil {
+		conn.http, conn.app = prevHTTP, prevApp
+		return err
+	}
+
+	return nil
+}
+
+func (conn *nvConnection) SetBitrate(ctx context.Context, kbps int) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	conn.stream.Bitrate = kbps
+
+	return conn.restart(ctx)
+}
+
+func (conn *nvConnection) Reconfigure(ctx context.Context, settings VideoSettings) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	settings.apply(conn.stream)
+
+	return conn.restart(ctx)
+}
+
+func (conn *nvConnection) SetHDR(ctx context.Context, enabled bool) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	if enabled == conn.hdr {
+		return nil
+	}
+
+	if enabled {
+		if len(hdrVideoFormats(conn.stream.SupportedVideoFormats)) == 0 {
+			return ErrHDRUnsupported
+		}
+
+		// Checked before the running connection is stopped.
+		info, err := conn.http.ServerInfo()
+		if err != nil {
+			return err
+		}
+
+		if info.ServerCodecModeSupport&0x20200 == 0 {
+			return ErrHDRUnsupported
+		}
+	}
+
+	conn.hdr = enabled
+
+	return conn.restart(ctx)
+}
+
+// restart stops the current connection and starts a new one with a new
+// remote input key.
+func (conn *nvConnection) restart(ctx context.Context) error {
+	// release the resources of the previous connection
+	moonlight.StopConnection(conn.callbacks)
+
+	ri, err := moonlight.NewRemoteInputAES()
+	if err != nil {
+		return err
+	}
+
+	conn.ri = ri
+
+	return conn.start(ctx)
+}
+
+func (conn *nvConnection) start(ctx context.Context) (err error) {
+	if conn.callbacks == nil {
+		return errors.New("callbacks not set up")
+	}
+
+	app := conn.app
+
+	ctx, span := telemetry.Start(ctx, "nvstream.start",
+		attribute.String("nvstream.app", app.Name),
+		attribute.Int("nvstream.app_id", app.ID))
+
+	defer func() {
+		telemetry.RecordError(span, err)
+		span.End()
+	}()
+
 	info, err := conn.http.ServerInfo()
 	if err != nil {
 		return err
@@ -55,6 +371,10 @@ func (conn *nvConnection) StartApp(ctx context.Context, app NvApp) error {
 	isNvidiaServerSoftware := false
 
 	supportedVideoFormats := conn.stream.SupportedVideoFormatsBitmask()
+	if conn.hdr {
+		hdr := StreamConfiguration{SupportedVideoFormats: hdrVideoFormats(conn.stream.SupportedVideoFormats)}
+		supportedVideoFormats = hdr.SupportedVideoFormatsBitmask()
+	}
 
 	negotiatedHDR := (supportedVideoFormats & moonlight.VIDEO_FORMAT_MASK_10BIT) != 0
 	if (info.ServerCodecModeSupport&0x20200) == 0 && negotiatedHDR {
@@ -87,7 +407,23 @@ func (conn *nvConnection) StartApp(ctx context.Context, app NvApp) error {
 	ctx = context.WithValue(ctx, CtxKeyStreamConfiguration, conn.stream)
 	ctx = context.WithValue(ctx, CtxKeyRemoteInputAES, conn.ri)
 
-	rtspSessionURL, err := conn.http.LaunchApp(ctx, app.ID, false)
+	// Rejoin the session if the app still runs, as relaunching would
+	// restart it.
+	resume := info.CurrentGame == app.ID
+
+	launchCtx, launchSpan := telemetry.StartKind(ctx, "nvstream.launch", trace.SpanKindClient,
+		attribute.Bool("nvstream.resume", resume))
+
+	var rtspSessionURL string
+	if resume {
+		rtspSessionURL, err = conn.http.ResumeApp(launchCtx, app.ID, conn.hdr)
+	} else {
+		rtspSessionURL, err = conn.http.LaunchApp(launchCtx, app.ID, conn.hdr)
+	}
+
+	telemetry.RecordError(launchSpan, err)
+	launchSpan.End()
+
 	if err != nil {
 		return err
 	}
@@ -116,28 +452,81 @@ func (conn *nvConnection) StartApp(ctx context.Context, app NvApp) error {
 		RemoteInputAES:        conn.ri,
 	}
 
-	return moonlight.StartConnection(serverInfo, streamConfig)
+	connCtx, connSpan := telemetry.Start(ctx, "nvstream.connection")
+
+	conn.spans.begin(connCtx)
+	err = moonlight.StartConnection(conn.callbacks, serverInfo, streamConfig)
+	conn.spans.end()
+
+	telemetry.RecordError(connSpan, err)
+	connSpan.End()
+
+	return err
 }
 
 func (conn *nvConnection) StopApp(ctx context.Context) error {
-	moonlight.StopConnection()
+	conn.Lock()
+	defer conn.Unlock()
+
+	moonlight.StopConnection(conn.callbacks)
+	conn.stage.Store(ConnectionStageStopped)
 
 	return conn.http.QuitApp(ctx)
 }
 
+// Close stops the streaming connection without quitting the app and
+// releases its callbacks.
+func (conn *nvConnection) Close() error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	if conn.callbacks != nil {
+		conn.callbacks.Close()
+		conn.callbacks = nil
+	}
+
+	conn.stage.Store(ConnectionStageStopped)
+
+	return nil
+}
+
+func (conn *nvConnection) Stage() ConnectionStage {
+	return conn.stage.Load().(ConnectionStage)
+}
+
+func (conn *nvConnection) Terminated() <-chan int {
+	return conn.terminated
+}
+
+func (conn *nvConnection) ControllerLEDs() <-chan ControllerLED {
+	return conn.leds
+}
+
+func (conn *nvConnection) HDRModes() <-chan HDRMode {
+	return conn.hdrModes
+}
+
 func (conn *nvConnection) StageStarting(stage int) {
+	conn.stage.Store(ConnectionStage(moonlight.StageName(stage)))
+	conn.spans.stageStarting(moonlight.StageName(stage))
+
 	conn.log.Info("connection starting",
 		zap.Int("stage", stage),
 		zap.String("stage_name", moonlight.StageName(stage)))
 }
 
 func (conn *nvConnection) StageComplete(stage int) {
+	conn.spans.stageEnded(nil)
+
 	conn.log.Info("connection complete",
 		zap.Int("stage", stage),
 		zap.String("stage_name", moonlight.StageName(stage)))
 }
 
 func (conn *nvConnection) StageFailed(stage int, errorCode int) {
+	conn.stage.Store(ConnectionStageFailed)
+	conn.spans.stageEnded(fmt.Errorf("stage failed with error code %d", errorCode))
+
 	conn.log.Error("connection failed",
 		zap.Int("stage", stage),
 		zap.String("stage_name", moonlight.StageName(stage)),
@@ -145,11 +534,23 @@ func (conn *nvConnection) StageFailed(stage int, errorCode int) {
 }
 
 func (conn *nvConnection) ConnectionStarted() {
+	conn.stage.Store(ConnectionStageConnected)
+	resetControllers()
+
+	go conn.watchStats(conn.statsGen.Add(1))
+
 	conn.log.Info("connection started")
 }
 
 func (conn *nvConnection) ConnectionTerminated(errorCode int) {
 	conn.log.Info("connection terminated", zap.Int("error_code", errorCode))
+
+	conn.stage.Store(ConnectionStageTerminated)
+
+	select {
+	case conn.terminated <- errorCode:
+	default:
+	}
 }
 
 func (conn *nvConnection) LogMessage(format string, args ...interface{}) {
@@ -172,10 +573,15 @@ func (conn *nvConnection) ConnectionStatusUpdate(connectionStatus int) {
 	conn.log.Info("connection status update", zap.Int("status", connectionStatus))
 }
 
-func (conn *nvConnection) SetHDRMode(hdrEnabled bool) {
-	conn.log.Info("set hdr mode", zap.Bool("enabled", hdrEnabled))
+func (conn *nvConnection) SetHDRMode(hdrEnabled bool, metadata *moonlight.HDRMetadata) {
+	conn.log.Info("set hdr mode",
+		zap.Bool("enabled", hdrEnabled),
+		zap.Bool("metadata", metadata != nil))
 
-	// TODO: decoderRenderer.SetHDRMode(hdrEnabled)
+	select {
+	case conn.hdrModes <- HDRMode{hdrEnabled, metadata}:
+	default:
+	}
 }
 
 func (conn *nvConnection) RumbleTriggers(controllerNumber, leftTriggerMotor, rightTriggerMotor uint16) {
@@ -193,15 +599,18 @@ func (conn *nvConnection) SetMotionEventState(controllerNumber uint16, motionTyp
 		zap.Uint8("motion_type", motionType),
 		zap.Uint16("report_rate_hz", reportRateHz))
 
-	// TODO: implement motion event state
+	setMotionEventRate(controllerNumber, motionType, reportRateHz)
 }
 
 func (conn *nvConnection) SetControllerLED(controllerNumber uint16, r, g, b uint8) {
-	conn.log.Info("set controller led color",
+	conn.log.Debug("set controller led color",
 		zap.Uint16("controller", controllerNumber),
 		zap.Uint8("r", r),
 		zap.Uint8("g", g),
 		zap.Uint8("b", b))
 
-	// TODO: implement controller LED color
+	select {
+	case conn.leds <- ControllerLED{controllerNumber, r, g, b}:
+	default:
+	}
 }
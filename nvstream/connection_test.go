@@ -24,7 +24,7 @@ func TestStartConnection(t *testing.T) {
 
 	defer log.Sync()
 
-	http, err := NewHTTP("MyGameClient", "localhost")
+	http, err := NewHTTP("localhost", WithUniqueID("MyGameClient"))
 	if err != nil {
 		assert.Fail(err.Error())
 		return
@@ -61,7 +61,7 @@ func TestStartConnection(t *testing.T) {
 	vs := NewVideoStream()
 	as := NewAudioStream()
 
-	moonlight.SetupCallbacks(conn, vs, as)
+	conn.SetupCallbacks(vs, as)
 
 	ctx := context.Background()
 	if err := conn.StartApp(ctx, app); err != nil {
@@ -75,7 +75,7 @@ func TestStartConnection(t *testing.T) {
 func TestStopConnection(t *testing.T) {
 	assert := assert.New(t)
 
-	http, err := NewHTTP("MyGameClient", "localhost")
+	http, err := NewHTTP("localhost", WithUniqueID("MyGameClient"))
 	if err != nil {
 		assert.Fail(err.Error())
 		return
@@ -93,3 +93,37 @@ func TestStopConnection(t *testing.T) {
 		return
 	}
 }
+
+func TestVideoSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &StreamConfiguration{Width: 1920, Height: 1080, RefreshRate: 60, Bitrate: 20000}
+
+	VideoSettings{Bitrate: 10000}.apply(cfg)
+	assert.Equal(StreamConfiguration{Width: 1920, Height: 1080, RefreshRate: 60, Bitrate: 10000}, *cfg)
+
+	// a resolution changes whole
+	VideoSettings{Width: 1280, RefreshRate: 30}.apply(cfg)
+	assert.Equal(StreamConfiguration{Width: 1920, Height: 1080, RefreshRate: 30, Bitrate: 10000}, *cfg)
+
+	VideoSettings{Width: 1280, Height: 720}.apply(cfg)
+	assert.Equal(StreamConfiguration{Width: 1280, Height: 720, RefreshRate: 30, Bitrate: 10000}, *cfg)
+}
+
+func TestHDRVideoFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	formats := hdrVideoFormats([]moonlight.VideoFormat{
+		moonlight.VIDEO_FORMAT_H264,
+		moonlight.VIDEO_FORMAT_H265,
+		moonlight.VIDEO_FORMAT_H265_MAIN10,
+		moonlight.VIDEO_FORMAT_AV1_HIGH8_444,
+	})
+
+	assert.Equal([]moonlight.VideoFormat{
+		moonlight.VIDEO_FORMAT_H265_MAIN10,
+		moonlight.VIDEO_FORMAT_AV1_HIGH10_444,
+	}, formats)
+
+	assert.Empty(hdrVideoFormats([]moonlight.VideoFormat{moonlight.VIDEO_FORMAT_H264}))
+}
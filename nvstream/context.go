@@ -1,6 +1,8 @@
 package nvstream
 
 import (
+	"time"
+
 	"gopkg.in/yaml.v3"
 
 	"github.com/flarexio/game/thirdparty/moonlight"
@@ -38,9 +40,18 @@ func DefaultStreamConfiguration() *StreamConfiguration {
 		ColorRange:                    moonlight.COLOR_RANGE_LIMITED,
 		ColorSpace:                    moonlight.COLORSPACE_REC_709,
 		PersistGamepadAfterDisconnect: false,
+		RTTWarning:                    defaultRTTWarning,
+		PendingFramesWarning:          defaultPendingFramesWarning,
 	}
 }
 
+// The thresholds of the connection statistics over which warnings are
+// logged.
+const (
+	defaultRTTWarning           = 150 * time.Millisecond
+	defaultPendingFramesWarning = 4
+)
+
 type StreamConfiguration struct {
 	App                           NvApp
 	Width                         int
@@ -61,6 +72,11 @@ type StreamConfiguration struct {
 	ColorRange                    moonlight.ColorRange
 	ColorSpace                    moonlight.ColorSpace
 	PersistGamepadAfterDisconnect bool
+
+	// RTTWarning and PendingFramesWarning are the host RTT and the video
+	// frames queued over which the connection warns.
+	RTTWarning           time.Duration
+	PendingFramesWarning int
 }
 
 func (cfg *StreamConfiguration) UnmarshalYAML(value *yaml.Node) error {
@@ -84,6 +100,9 @@ func (cfg *StreamConfiguration) UnmarshalYAML(value *yaml.Node) error {
 		ColorRange                    string   `yaml:"colorRange"`
 		ColorSpace                    string   `yaml:"colorSpace"`
 		PersistGamepadAfterDisconnect bool     `yaml:"persistGamepadAfterDisconnect"`
+
+		RTTWarning           time.Duration `yaml:"rttWarning"`
+		PendingFramesWarning int           `yaml:"pendingFramesWarning"`
 	}
 
 	if err := value.Decode(&raw); err != nil {
@@ -146,6 +165,17 @@ func (cfg *StreamConfiguration) UnmarshalYAML(value *yaml.Node) error {
 
 	cfg.PersistGamepadAfterDisconnect = raw.PersistGamepadAfterDisconnect
 
+	if raw.RTTWarning == 0 {
+		raw.RTTWarning = defaultRTTWarning
+	}
+
+	if raw.PendingFramesWarning == 0 {
+		raw.PendingFramesWarning = defaultPendingFramesWarning
+	}
+
+	cfg.RTTWarning = raw.RTTWarning
+	cfg.PendingFramesWarning = raw.PendingFramesWarning
+
 	return nil
 }
 
@@ -0,0 +1,60 @@
+package nvstream
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// The host keeps controller state per connection, and moonlight runs one
+// connection per process, so the state is kept globally.
+var controllers struct {
+	generation  atomic.Uint64
+	motionRates map[motionKey]uint16
+	sync.RWMutex
+}
+
+type motionKey struct {
+	controller uint16
+	motionType uint8
+}
+
+// ConnectionGeneration changes each time a connection starts. Controllers
+// announced to the host must be announced again once it changed.
+func ConnectionGeneration() uint64 {
+	return controllers.generation.Load()
+}
+
+// MotionEventRate returns the rate in Hz at which the host asked for motion
+// events of the type from the controller, 0 if it asked for none.
+func MotionEventRate(controllerNumber uint16, motionType uint8) uint16 {
+	controllers.RLock()
+	defer controllers.RUnlock()
+
+	return controllers.motionRates[motionKey{controllerNumber, motionType}]
+}
+
+func setMotionEventRate(controllerNumber uint16, motionType uint8, reportRateHz uint16) {
+	controllers.Lock()
+	defer controllers.Unlock()
+
+	if controllers.motionRates == nil {
+		controllers.motionRates = make(map[motionKey]uint16)
+	}
+
+	key := motionKey{controllerNumber, motionType}
+	if reportRateHz == 0 {
+		delete(controllers.motionRates, key)
+		return
+	}
+
+	controllers.motionRates[key] = reportRateHz
+}
+
+// resetControllers forgets the state of the previous connection.
+func resetControllers() {
+	controllers.Lock()
+	controllers.motionRates = nil
+	controllers.Unlock()
+
+	controllers.generation.Add(1)
+}
@@ -0,0 +1,25 @@
+package nvstream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMotionEventRate(t *testing.T) {
+	assert := assert.New(t)
+
+	generation := ConnectionGeneration()
+
+	setMotionEventRate(1, 2, 100)
+	assert.Equal(uint16(100), MotionEventRate(1, 2))
+	assert.Zero(MotionEventRate(1, 1))
+
+	setMotionEventRate(1, 2, 0)
+	assert.Zero(MotionEventRate(1, 2))
+
+	setMotionEventRate(0, 1, 200)
+	resetControllers()
+	assert.Zero(MotionEventRate(0, 1))
+	assert.Equal(generation+1, ConnectionGeneration())
+}
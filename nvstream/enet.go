@@ -0,0 +1,613 @@
+package nvstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Gen 7 hosts run the control stream over ENet. enetPeer is the client
+// side of it, only as much as the control stream uses: it connects, sends
+// reliable packets and receives the host's packets in order. Fragmented
+// and compressed packets are not supported.
+
+const (
+	enetCommandAcknowledge            = 1
+	enetCommandConnect                = 2
+	enetCommandVerifyConnect          = 3
+	enetCommandDisconnect             = 4
+	enetCommandPing                   = 5
+	enetCommandSendReliable           = 6
+	enetCommandSendUnreliable         = 7
+	enetCommandSendFragment           = 8
+	enetCommandSendUnsequenced        = 9
+	enetCommandBandwidthLimit         = 10
+	enetCommandThrottleConfigure      = 11
+	enetCommandSendUnreliableFragment = 12
+
+	enetCommandMask             = 0x0F
+	enetCommandFlagAcknowledge  = 0x80
+	enetCommandFlagUnsequenced  = 0x40
+	enetHeaderFlagCompressed    = 0x4000
+	enetHeaderFlagSentTime      = 0x8000
+	enetHeaderSessionMask       = 0x3000
+	enetHeaderSessionShift      = 12
+	enetMaximumPeerID           = 0x0FFF
+	enetSystemChannel           = 0xFF
+	enetDefaultMTU              = 1392
+	enetMaximumWindowSize       = 65536
+	enetPacketThrottleInterval  = 5000
+	enetPacketThrottleAccel     = 2
+	enetPacketThrottleDecel     = 2
+	enetResendInterval          = 300 * time.Millisecond
+	enetPingInterval            = time.Second
+	enetTimeout                 = 10 * time.Second
+	enetConnectAttemptsInterval = 500 * time.Millisecond
+)
+
+// enetCommandSizes are the sizes of the commands with their header,
+// without the data they carry.
+var enetCommandSizes = [...]int{
+	enetCommandAcknowledge:            8,
+	enetCommandConnect:                48,
+	enetCommandVerifyConnect:          44,
+	enetCommandDisconnect:             8,
+	enetCommandPing:                   4,
+	enetCommandSendReliable:           6,
+	enetCommandSendUnreliable:         8,
+	enetCommandSendFragment:           24,
+	enetCommandSendUnsequenced:        8,
+	enetCommandBandwidthLimit:         12,
+	enetCommandThrottleConfigure:      16,
+	enetCommandSendUnreliableFragment: 24,
+}
+
+var (
+	errENetTimeout      = errors.New("enet peer timed out")
+	errENetDisconnected = errors.New("enet peer disconnected")
+)
+
+type enetReliableKey struct {
+	channel  uint8
+	sequence uint16
+}
+
+type enetOutgoing struct {
+	command []byte
+	sentAt  time.Time
+	firstAt time.Time
+}
+
+type enetPeer struct {
+	log   *zap.Logger
+	conn  *net.UDPConn
+	start time.Time
+
+	connectID         uint32
+	outgoingPeerID    uint16
+	outgoingSessionID uint8
+	connected         bool
+
+	outgoingSequence map[uint8]uint16
+	incomingSequence map[uint8]uint16
+	incomingPending  map[enetReliableKey][]byte
+	unacked          map[enetReliableKey]*enetOutgoing
+	lastReceive      time.Time
+
+	// estimated from the acknowledgements, as ENet does
+	rtt         time.Duration
+	rttVariance time.Duration
+
+	verified chan struct{}
+	received chan []byte
+	done     chan struct{}
+	err      error
+	closed   bool
+	sync.Mutex
+}
+
+// dialENet connects to an ENet host, passing data with the connect
+// command as the GameStream connect data.
+func dialENet(ctx context.Context, address string, channels int, data uint32) (*enetPeer, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p := &enetPeer{
+		log: zap.L().With(
+			zap.String("component", "nvstream.enet"),
+			zap.String("host", address),
+		),
+		conn:              conn,
+		start:             time.Now(),
+		connectID:         binary.BigEndian.Uint32(id[:]),
+		outgoingPeerID:    enetMaximumPeerID,
+		outgoingSessionID: 0xFF,
+		outgoingSequence:  make(map[uint8]uint16),
+		incomingSequence:  make(map[uint8]uint16),
+		incomingPending:   make(map[enetReliableKey][]byte),
+		unacked:           make(map[enetReliableKey]*enetOutgoing),
+		lastReceive:       time.Now(),
+		verified:          make(chan struct{}),
+		received:          make(chan []byte, 64),
+		done:              make(chan struct{}),
+	}
+
+	go p.readLoop()
+
+	connect := make([]byte, 44)
+	binary.BigEndian.PutUint16(connect[0:2], 0) // our peer ID
+	connect[2] = 0xFF                           // incoming session ID
+	connect[3] = 0xFF                           // outgoing session ID
+	binary.BigEndian.PutUint32(connect[4:8], enetDefaultMTU)
+	binary.BigEndian.PutUint32(connect[8:12], enetMaximumWindowSize)
+	binary.BigEndian.PutUint32(connect[12:16], uint32(channels))
+	binary.BigEndian.PutUint32(connect[16:20], 0) // incoming bandwidth
+	binary.BigEndian.PutUint32(connect[20:24], 0) // outgoing bandwidth
+	binary.BigEndian.PutUint32(connect[24:28], enetPacketThrottleInterval)
+	binary.BigEndian.PutUint32(connect[28:32], enetPacketThrottleAccel)
+	binary.BigEndian.PutUint32(connect[32:36], enetPacketThrottleDecel)
+	binary.BigEndian.PutUint32(connect[36:40], p.connectID)
+	binary.BigEndian.PutUint32(connect[40:44], data)
+
+	p.Lock()
+	command := p.reliableCommand(enetCommandConnect, enetSystemChannel, connect)
+	p.Unlock()
+
+	ticker := time.NewTicker(enetConnectAttemptsInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.write(command, true); err != nil {
+			p.Close()
+			return nil, err
+		}
+
+		select {
+		case <-p.verified:
+			go p.serviceLoop()
+			return p, nil
+
+		case <-p.done:
+			return nil, p.Err()
+
+		case <-ctx.Done():
+			p.Close()
+			return nil, ctx.Err()
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// reliableCommand builds a command the host acknowledges, numbered in
+// its channel.
+func (p *enetPeer) reliableCommand(command byte, channel uint8, body []byte) []byte {
+	p.outgoingSequence[channel]++
+	sequence := p.outgoingSequence[channel]
+
+	b := make([]byte, 4, 4+len(body))
+	b[0] = command | enetCommandFlagAcknowledge
+	b[1] = channel
+	binary.BigEndian.PutUint16(b[2:4], sequence)
+
+	return append(b, body...)
+}
+
+func (p *enetPeer) sentTime() uint16 {
+	return uint16(time.Since(p.start).Milliseconds())
+}
+
+// write sends a command in a datagram of its own.
+func (p *enetPeer) write(command []byte, sentTime bool) error {
+	p.Lock()
+	peerID := p.outgoingPeerID
+	if peerID < enetMaximumPeerID {
+		peerID |= uint16(p.outgoingSessionID) << enetHeaderSessionShift & enetHeaderSessionMask
+	}
+	p.Unlock()
+
+	datagram := make([]byte, 2, 4+len(command))
+
+	if sentTime {
+		binary.BigEndian.PutUint16(datagram[0:2], peerID|enetHeaderFlagSentTime)
+		datagram = binary.BigEndian.AppendUint16(datagram, p.sentTime())
+	} else {
+		binary.BigEndian.PutUint16(datagram[0:2], peerID)
+	}
+
+	datagram = append(datagram, command...)
+
+	_, err := p.conn.Write(datagram)
+	return err
+}
+
+// sendReliable sends a command until the host acknowledges it.
+func (p *enetPeer) sendReliable(command byte, channel uint8, body []byte) error {
+	p.Lock()
+
+	if p.closed {
+		p.Unlock()
+		return net.ErrClosed
+	}
+
+	b := p.reliableCommand(command, channel, body)
+
+	now := time.Now()
+	p.unacked[enetReliableKey{channel, binary.BigEndian.Uint16(b[2:4])}] = &enetOutgoing{
+		command: b,
+		sentAt:  now,
+		firstAt: now,
+	}
+
+	p.Unlock()
+
+	return p.write(b, true)
+}
+
+// Send sends a packet reliably on a channel.
+func (p *enetPeer) Send(channel uint8, packet []byte) error {
+	body := make([]byte, 2, 2+len(packet))
+	binary.BigEndian.PutUint16(body, uint16(len(packet)))
+	body = append(body, packet...)
+
+	return p.sendReliable(enetCommandSendReliable, channel, body)
+}
+
+// Received delivers the host's packets; it is closed once the peer is.
+func (p *enetPeer) Received() <-chan []byte {
+	return p.received
+}
+
+// Done is closed once the peer is, Err telling why.
+func (p *enetPeer) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *enetPeer) Err() error {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.err
+}
+
+// Close disconnects from the host.
+func (p *enetPeer) Close() error {
+	p.Lock()
+	connected := p.connected && !p.closed
+	if connected {
+		p.outgoingSequence[enetSystemChannel]++
+	}
+	sequence := p.outgoingSequence[enetSystemChannel]
+	p.Unlock()
+
+	if connected {
+		b := make([]byte, 8)
+		b[0] = enetCommandDisconnect | enetCommandFlagAcknowledge
+		b[1] = enetSystemChannel
+		binary.BigEndian.PutUint16(b[2:4], sequence)
+
+		p.write(b, true)
+	}
+
+	p.shutdown(nil)
+	return nil
+}
+
+func (p *enetPeer) shutdown(err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	p.err = err
+	p.conn.Close()
+	close(p.done)
+}
+
+// serviceLoop resends what the host did not acknowledge, pings it and
+// times it out.
+func (p *enetPeer) serviceLoop() {
+	ticker := time.NewTicker(enetResendInterval / 3)
+	defer ticker.Stop()
+
+	lastPing := time.Now()
+
+	for {
+		select {
+		case <-p.done:
+			return
+
+		case now := <-ticker.C:
+			p.Lock()
+
+			if now.Sub(p.lastReceive) > enetTimeout {
+				p.Unlock()
+				p.shutdown(errENetTimeout)
+				return
+			}
+
+			var resend [][]byte
+			timedOut := false
+			for _, out := range p.unacked {
+				if now.Sub(out.firstAt) > enetTimeout {
+					timedOut = true
+					break
+				}
+
+				if now.Sub(out.sentAt) >= enetResendInterval {
+					out.sentAt = now
+					resend = append(resend, out.command)
+				}
+			}
+
+			p.Unlock()
+
+			if timedOut {
+				p.shutdown(errENetTimeout)
+				return
+			}
+
+			for _, command := range resend {
+				p.write(command, true)
+			}
+
+			if now.Sub(lastPing) >= enetPingInterval {
+				lastPing = now
+				p.sendReliable(enetCommandPing, enetSystemChannel, nil)
+			}
+		}
+	}
+}
+
+func (p *enetPeer) readLoop() {
+	defer close(p.received)
+
+	buf := make([]byte, 2048)
+
+	for {
+		n, err := p.conn.Read(buf)
+		if err != nil {
+			p.shutdown(nil)
+			return
+		}
+
+		if err := p.handleDatagram(buf[:n]); err != nil {
+			p.shutdown(err)
+			return
+		}
+	}
+}
+
+// handleDatagram handles the commands of a datagram from the host.
+func (p *enetPeer) handleDatagram(b []byte) error {
+	if len(b) < 2 {
+		return nil
+	}
+
+	header := binary.BigEndian.Uint16(b[0:2])
+	b = b[2:]
+
+	if header&enetHeaderFlagCompressed != 0 {
+		p.log.Debug("compressed enet packet dropped")
+		return nil
+	}
+
+	var sentTime uint16
+	if header&enetHeaderFlagSentTime != 0 {
+		if len(b) < 2 {
+			return nil
+		}
+
+		sentTime = binary.BigEndian.Uint16(b[0:2])
+		b = b[2:]
+	}
+
+	p.Lock()
+	p.lastReceive = time.Now()
+	p.Unlock()
+
+	for len(b) >= 4 {
+		command := b[0] & enetCommandMask
+		if int(command) >= len(enetCommandSizes) || enetCommandSizes[command] == 0 {
+			return nil
+		}
+
+		size := enetCommandSizes[command]
+		if len(b) < size {
+			return nil
+		}
+
+		channel := b[1]
+		sequence := binary.BigEndian.Uint16(b[2:4])
+
+		var data []byte
+		switch command {
+		case enetCommandSendReliable:
+			data = b[4:6]
+		case enetCommandSendUnreliable, enetCommandSendUnsequenced:
+			data = b[6:8]
+		case enetCommandSendFragment, enetCommandSendUnreliableFragment:
+			data = b[6:8]
+		}
+
+		if data != nil {
+			size += int(binary.BigEndian.Uint16(data))
+			if len(b) < size {
+				return nil
+			}
+
+			data = b[enetCommandSizes[command]:size]
+		}
+
+		if err := p.handleCommand(command, channel, sequence, b[:size], data); err != nil {
+			return err
+		}
+
+		// Acknowledged once handled, so that of the verify carries our
+		// peer ID.
+		if b[0]&enetCommandFlagAcknowledge != 0 {
+			p.acknowledge(channel, sequence, sentTime)
+		}
+
+		b = b[size:]
+	}
+
+	return nil
+}
+
+func (p *enetPeer) acknowledge(channel uint8, sequence, sentTime uint16) {
+	b := make([]byte, 8)
+	b[0] = enetCommandAcknowledge
+	b[1] = channel
+	binary.BigEndian.PutUint16(b[2:4], sequence)
+	binary.BigEndian.PutUint16(b[4:6], sequence)
+	binary.BigEndian.PutUint16(b[6:8], sentTime)
+
+	p.write(b, false)
+}
+
+func (p *enetPeer) handleCommand(command byte, channel uint8, sequence uint16, b, data []byte) error {
+	switch command {
+	case enetCommandAcknowledge:
+		acked := binary.BigEndian.Uint16(b[4:6])
+		sample := time.Duration(p.sentTime()-binary.BigEndian.Uint16(b[6:8])) * time.Millisecond
+
+		p.Lock()
+		delete(p.unacked, enetReliableKey{channel, acked})
+		p.updateRTT(sample)
+		p.Unlock()
+
+	case enetCommandVerifyConnect:
+		p.Lock()
+		defer p.Unlock()
+
+		if p.connected || binary.BigEndian.Uint32(b[40:44]) != p.connectID {
+			return nil
+		}
+
+		p.outgoingPeerID = binary.BigEndian.Uint16(b[4:6])
+		p.outgoingSessionID = b[7]
+		p.connected = true
+
+		close(p.verified)
+
+	case enetCommandDisconnect:
+		return errENetDisconnected
+
+	case enetCommandSendReliable:
+		p.deliverReliable(channel, sequence, bytes.Clone(data))
+
+	case enetCommandSendUnreliable, enetCommandSendUnsequenced:
+		p.deliver(bytes.Clone(data))
+
+	case enetCommandSendFragment, enetCommandSendUnreliableFragment:
+		p.log.Debug("fragmented enet packet dropped")
+
+		if command == enetCommandSendFragment {
+			// It takes its place in the channel's order.
+			p.deliverReliable(channel, sequence, nil)
+		}
+	}
+
+	return nil
+}
+
+// updateRTT smooths a round trip time measured into the estimate.
+func (p *enetPeer) updateRTT(sample time.Duration) {
+	if p.rtt == 0 {
+		p.rtt = sample
+		p.rttVariance = sample / 2
+		return
+	}
+
+	diff := sample - p.rtt
+	if diff < 0 {
+		diff = -diff
+	}
+
+	p.rttVariance += (diff - p.rttVariance) / 4
+
+	if sample >= p.rtt {
+		p.rtt += diff / 8
+	} else {
+		p.rtt -= diff / 8
+	}
+}
+
+// RTT returns the estimated round trip time to the host and its
+// variance, zero until an acknowledgement came back.
+func (p *enetPeer) RTT() (time.Duration, time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.rtt, p.rttVariance
+}
+
+// deliverReliable delivers the reliable packets of a channel in order,
+// holding back those arriving early and dropping repeats. A nil packet
+// only takes its place in the order.
+func (p *enetPeer) deliverReliable(channel uint8, sequence uint16, data []byte) {
+	p.Lock()
+
+	next := p.incomingSequence[channel] + 1
+	if int16(sequence-next) < 0 {
+		p.Unlock()
+		return
+	}
+
+	p.incomingPending[enetReliableKey{channel, sequence}] = data
+
+	var ready [][]byte
+	for {
+		key := enetReliableKey{channel, next}
+
+		data, ok := p.incomingPending[key]
+		if !ok {
+			break
+		}
+
+		delete(p.incomingPending, key)
+		p.incomingSequence[channel] = next
+		next++
+
+		if data != nil {
+			ready = append(ready, data)
+		}
+	}
+
+	p.Unlock()
+
+	for _, data := range ready {
+		p.deliver(data)
+	}
+}
+
+func (p *enetPeer) deliver(packet []byte) {
+	select {
+	case p.received <- packet:
+	case <-p.done:
+	}
+}
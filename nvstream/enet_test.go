@@ -0,0 +1,162 @@
+package nvstream
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeENetHost verifies the first connect it receives, then hands the
+// datagrams that follow to the test.
+func fakeENetHost(t *testing.T) (string, *net.UDPConn, <-chan []byte, func() *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	datagrams := make(chan []byte, 64)
+	peer := make(chan *net.UDPAddr, 1)
+
+	go func() {
+		buf := make([]byte, 2048)
+		verified := false
+
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			b := append([]byte(nil), buf[:n]...)
+
+			// The header, the sent time and the command.
+			if !verified && len(b) >= 4+48 && b[4]&enetCommandMask == enetCommandConnect {
+				verify := make([]byte, 2+44)
+				binary.BigEndian.PutUint16(verify[0:2], 0)
+				verify[2] = enetCommandVerifyConnect | enetCommandFlagAcknowledge
+				verify[3] = enetSystemChannel
+				binary.BigEndian.PutUint16(verify[4:6], 1)
+				binary.BigEndian.PutUint16(verify[6:8], 7) // the client's peer ID
+				verify[9] = 1                              // outgoing session ID
+				copy(verify[2+40:2+44], b[4+40:4+44])
+
+				conn.WriteToUDP(verify, addr)
+
+				verified = true
+				peer <- addr
+				continue
+			}
+
+			datagrams <- b
+		}
+	}()
+
+	var addr *net.UDPAddr
+	peerAddr := func() *net.UDPAddr {
+		if addr == nil {
+			addr = <-peer
+		}
+
+		return addr
+	}
+
+	return conn.LocalAddr().String(), conn, datagrams, peerAddr
+}
+
+func enetReliable(sequence uint16, packet []byte) []byte {
+	b := make([]byte, 2+6, 2+6+len(packet))
+	b[2] = enetCommandSendReliable | enetCommandFlagAcknowledge
+	binary.BigEndian.PutUint16(b[4:6], sequence)
+	binary.BigEndian.PutUint16(b[6:8], uint16(len(packet)))
+
+	return append(b, packet...)
+}
+
+func TestENetPeer(t *testing.T) {
+	assert := assert.New(t)
+
+	address, host, datagrams, peerAddr := fakeENetHost(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peer, err := dialENet(ctx, address, 0x20, 42)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	defer peer.Close()
+
+	// Sent after the verify, with the peer ID and session it assigned.
+	if assert.NoError(peer.Send(0, []byte("hello"))) {
+		var send []byte
+		for send == nil {
+			select {
+			case b := <-datagrams:
+				if b[4]&enetCommandMask == enetCommandSendReliable {
+					send = b
+				}
+			case <-ctx.Done():
+				assert.Fail("send not received")
+				return
+			}
+		}
+
+		header := binary.BigEndian.Uint16(send[0:2])
+		assert.Equal(uint16(7|1<<enetHeaderSessionShift|enetHeaderFlagSentTime), header)
+		assert.Equal([]byte("hello"), send[4+6:])
+	}
+
+	// Delivered in order, once each.
+	host.WriteToUDP(enetReliable(2, []byte("second")), peerAddr())
+	host.WriteToUDP(enetReliable(1, []byte("first")), peerAddr())
+	host.WriteToUDP(enetReliable(1, []byte("first")), peerAddr())
+	host.WriteToUDP(enetReliable(3, []byte("third")), peerAddr())
+
+	for _, want := range []string{"first", "second", "third"} {
+		select {
+		case packet := <-peer.Received():
+			assert.Equal(want, string(packet))
+		case <-ctx.Done():
+			assert.Fail("packet not received", want)
+			return
+		}
+	}
+
+	disconnect := make([]byte, 2+8)
+	disconnect[2] = enetCommandDisconnect | enetCommandFlagAcknowledge
+	disconnect[3] = enetSystemChannel
+	binary.BigEndian.PutUint16(disconnect[4:6], 2)
+
+	host.WriteToUDP(disconnect, peerAddr())
+
+	select {
+	case <-peer.Done():
+		assert.ErrorIs(peer.Err(), errENetDisconnected)
+	case <-ctx.Done():
+		assert.Fail("disconnect not received")
+	}
+}
+
+func TestENetRTT(t *testing.T) {
+	assert := assert.New(t)
+
+	p := new(enetPeer)
+
+	p.updateRTT(40 * time.Millisecond)
+	rtt, variance := p.RTT()
+	assert.Equal(40*time.Millisecond, rtt)
+	assert.Equal(20*time.Millisecond, variance)
+
+	p.updateRTT(120 * time.Millisecond)
+	rtt, variance = p.RTT()
+	assert.Equal(50*time.Millisecond, rtt)
+	assert.Equal(35*time.Millisecond, variance)
+}
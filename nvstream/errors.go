@@ -0,0 +1,87 @@
+package nvstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrNotPaired       = errors.New("not paired with host")
+	ErrAppNotFound     = errors.New("app not found")
+	ErrSessionBusy     = errors.New("host session busy")
+	ErrHostUnreachable = errors.New("host unreachable")
+	ErrHDRUnsupported  = errors.New("hdr not supported")
+)
+
+// HTTPError is a failed GameStream request, reported either by the HTTP
+// status or by the status_code attribute of the XML response.
+type HTTPError struct {
+	Endpoint   string
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+
+	return fmt.Sprintf("%s: status %d: %s", e.Endpoint, e.StatusCode, msg)
+}
+
+// Unwrap classifies the status codes hosts use for conditions callers act
+// on: 401 for an unpaired client, 503 when the host streams to another
+// client and 599 when the running session belongs to another client.
+func (e *HTTPError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrNotPaired
+	case http.StatusServiceUnavailable, 599:
+		return ErrSessionBusy
+	default:
+		return nil
+	}
+}
+
+// checkStatus turns a non-200 status_code of a response root into an
+// HTTPError.
+func checkStatus(endpoint string, code int, message string) error {
+	if code == 0 || code == http.StatusOK {
+		return nil
+	}
+
+	return &HTTPError{
+		Endpoint:   endpoint,
+		StatusCode: code,
+		Message:    message,
+	}
+}
+
+// do sends the request, classifying transport failures as
+// ErrHostUnreachable and non-OK responses as HTTPError.
+func do(client *http.Client, endpoint string, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.Canceled),
+			errors.Is(err, ErrServerCertMismatch):
+			return nil, err
+		default:
+			return nil, fmt.Errorf("%w: %w", ErrHostUnreachable, err)
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, &HTTPError{
+			Endpoint:   endpoint,
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	return resp, nil
+}
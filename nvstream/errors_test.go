@@ -0,0 +1,109 @@
+package nvstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+func TestHTTPErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	var paired atomic.Bool
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/serverinfo":
+			if !paired.Load() {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			w.Write([]byte(`<root status_code="200"><currentgame>0</currentgame></root>`))
+		case "/applist":
+			w.Write([]byte(`<root status_code="401" status_message="The client is not authorized. Certificate verification failed."/>`))
+		case "/launch":
+			w.Write([]byte(`<root status_code="404" status_message="Cannot find requested application"/>`))
+		case "/cancel":
+			w.Write([]byte(`<root status_code="599" status_message="This session wasn't started by this device"/>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	client, err := NewHTTP(u.Hostname(),
+		WithPath(t.TempDir()),
+		WithPorts(port, port),
+		WithTransport(srv.Client().Transport),
+	)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = client.ServerInfo()
+	assert.ErrorIs(err, ErrNotPaired)
+
+	var httpErr *HTTPError
+	if assert.True(errors.As(err, &httpErr)) {
+		assert.Equal("serverinfo", httpErr.Endpoint)
+		assert.Equal(http.StatusUnauthorized, httpErr.StatusCode)
+	}
+
+	_, err = client.AppList()
+	assert.ErrorIs(err, ErrNotPaired)
+	assert.ErrorContains(err, "Certificate verification failed")
+
+	paired.Store(true)
+
+	ctx := context.WithValue(context.Background(), CtxKeyStreamConfiguration, &StreamConfiguration{})
+	ctx = context.WithValue(ctx, CtxKeyRemoteInputAES, &moonlight.RemoteInputAES{})
+
+	_, err = client.LaunchApp(ctx, 42, false)
+	assert.ErrorIs(err, ErrAppNotFound)
+
+	err = client.QuitApp(context.Background())
+	assert.ErrorIs(err, ErrSessionBusy)
+}
+
+func TestHTTPHostUnreachable(t *testing.T) {
+	assert := assert.New(t)
+
+	// A port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	client, err := NewHTTP("127.0.0.1",
+		WithPath(t.TempDir()),
+		WithPorts(port, port),
+		WithTimeout(time.Second),
+	)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = client.ServerInfo()
+	assert.ErrorIs(err, ErrHostUnreachable)
+}
@@ -0,0 +1,273 @@
+package nvstream
+
+import (
+	"errors"
+	"sync"
+)
+
+// The host protects video packets with Reed-Solomon erasure coding over
+// GF(2^8), using the systematic Vandermonde matrix of Backblaze's
+// implementation, which moonlight-common-c's rs.c and Sunshine's nanors
+// share: data shards are sent as is, followed by parity shards.
+
+const gfPolynomial = 0x11d
+
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [510]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPolynomial
+		}
+	}
+
+	// Doubled, so products need no modulo.
+	for i := 255; i < 510; i++ {
+		exp[i] = exp[i-255]
+	}
+
+	return exp, log
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfPow returns a^n, with 0^0 = 1.
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+
+	return m
+}
+
+func (m gfMatrix) mul(o gfMatrix) gfMatrix {
+	result := newGFMatrix(len(m), len(o[0]))
+
+	for r := range m {
+		for c := range o[0] {
+			var v byte
+			for i := range o {
+				v ^= gfMul(m[r][i], o[i][c])
+			}
+
+			result[r][c] = v
+		}
+	}
+
+	return result
+}
+
+var errSingularMatrix = errors.New("singular fec matrix")
+
+// invert returns the inverse of a square matrix by Gauss-Jordan elimination.
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+
+	work := newGFMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		if work[c][c] == 0 {
+			swapped := false
+			for r := c + 1; r < n; r++ {
+				if work[r][c] != 0 {
+					work[c], work[r] = work[r], work[c]
+					swapped = true
+					break
+				}
+			}
+
+			if !swapped {
+				return nil, errSingularMatrix
+			}
+		}
+
+		if pivot := work[c][c]; pivot != 1 {
+			for i := range work[c] {
+				work[c][i] = gfDiv(work[c][i], pivot)
+			}
+		}
+
+		for r := 0; r < n; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+
+			factor := work[r][c]
+			for i := range work[r] {
+				work[r][i] ^= gfMul(factor, work[c][i])
+			}
+		}
+	}
+
+	inverse := newGFMatrix(n, n)
+	for r := range inverse {
+		copy(inverse[r], work[r][n:])
+	}
+
+	return inverse, nil
+}
+
+// reedSolomon encodes and reconstructs blocks of dataShards data shards
+// and parityShards parity shards.
+type reedSolomon struct {
+	dataShards   int
+	parityShards int
+	matrix       gfMatrix // rows of all shards by the data shards
+}
+
+var reedSolomonCache sync.Map // [2]int → *reedSolomon
+
+func newReedSolomon(dataShards, parityShards int) (*reedSolomon, error) {
+	if dataShards <= 0 || parityShards < 0 || dataShards+parityShards > 255 {
+		return nil, errors.New("invalid fec shard counts")
+	}
+
+	key := [2]int{dataShards, parityShards}
+	if rs, ok := reedSolomonCache.Load(key); ok {
+		return rs.(*reedSolomon), nil
+	}
+
+	total := dataShards + parityShards
+
+	vandermonde := newGFMatrix(total, dataShards)
+	for r := range vandermonde {
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+
+	top, err := vandermonde[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &reedSolomon{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       vandermonde.mul(top),
+	}
+
+	reedSolomonCache.Store(key, rs)
+
+	return rs, nil
+}
+
+// encode computes the parity shards from the data shards, all of a size.
+func (rs *reedSolomon) encode(shards [][]byte) {
+	for p := 0; p < rs.parityShards; p++ {
+		row := rs.matrix[rs.dataShards+p]
+		out := shards[rs.dataShards+p]
+		clear(out)
+
+		for d := 0; d < rs.dataShards; d++ {
+			gfMulAdd(out, shards[d], row[d])
+		}
+	}
+}
+
+var errTooFewShards = errors.New("too few fec shards")
+
+// reconstruct fills the missing data shards, nil, of a block from any
+// dataShards shards received. Parity shards are left missing.
+func (rs *reedSolomon) reconstruct(shards [][]byte) error {
+	present := make([]int, 0, rs.dataShards)
+	size := 0
+
+	missing := false
+	for i, shard := range shards {
+		if shard == nil {
+			missing = missing || i < rs.dataShards
+			continue
+		}
+
+		if len(present) < rs.dataShards {
+			present = append(present, i)
+			size = len(shard)
+		}
+	}
+
+	if !missing {
+		return nil
+	}
+
+	if len(present) < rs.dataShards {
+		return errTooFewShards
+	}
+
+	sub := newGFMatrix(rs.dataShards, rs.dataShards)
+	for r, i := range present {
+		copy(sub[r], rs.matrix[i])
+	}
+
+	decode, err := sub.invert()
+	if err != nil {
+		return err
+	}
+
+	for d := 0; d < rs.dataShards; d++ {
+		if shards[d] != nil {
+			continue
+		}
+
+		out := make([]byte, size)
+		for r, i := range present {
+			gfMulAdd(out, shards[i], decode[d][r])
+		}
+
+		shards[d] = out
+	}
+
+	return nil
+}
+
+// gfMulAdd adds in * c to out.
+func gfMulAdd(out, in []byte, c byte) {
+	if c == 0 {
+		return
+	}
+
+	logC := int(gfLog[c])
+	for i, v := range in {
+		if v != 0 {
+			out[i] ^= gfExp[int(gfLog[v])+logC]
+		}
+	}
+}
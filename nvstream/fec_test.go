@@ -0,0 +1,64 @@
+package nvstream
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReedSolomon(t *testing.T) {
+	assert := assert.New(t)
+
+	rs, err := newReedSolomon(5, 3)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// Systematic: the data shards are sent as is.
+	for r := 0; r < rs.dataShards; r++ {
+		for c := 0; c < rs.dataShards; c++ {
+			var identity byte
+			if r == c {
+				identity = 1
+			}
+
+			assert.Equal(identity, rs.matrix[r][c])
+		}
+	}
+
+	shards := make([][]byte, 8)
+	for i := range shards {
+		shards[i] = make([]byte, 64)
+		if i < rs.dataShards {
+			for j := range shards[i] {
+				shards[i][j] = byte(rand.IntN(256))
+			}
+		}
+	}
+
+	rs.encode(shards)
+
+	want := make([][]byte, rs.dataShards)
+	for i := range want {
+		want[i] = append([]byte(nil), shards[i]...)
+	}
+
+	// Any three shards may be lost.
+	lost := shards[:0:0]
+	lost = append(lost, shards...)
+	lost[0], lost[3], lost[6] = nil, nil, nil
+
+	if assert.NoError(rs.reconstruct(lost)) {
+		assert.Equal(want, lost[:rs.dataShards])
+	}
+
+	lost = append(lost[:0:0], shards...)
+	lost[1], lost[2], lost[4], lost[7] = nil, nil, nil, nil
+
+	assert.ErrorIs(rs.reconstruct(lost), errTooFewShards)
+
+	_, err = newReedSolomon(200, 60)
+	assert.Error(err)
+}
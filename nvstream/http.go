@@ -1,6 +1,7 @@
 package nvstream
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/rand"
@@ -13,12 +14,15 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/flarexio/game/thirdparty/moonlight"
@@ -27,6 +31,8 @@ import (
 const (
 	DEFAULT_HTTPS_PORT int = 47984
 	DEFAULT_HTTP_PORT  int = 47989
+
+	DEFAULT_DEVICE_NAME string = "roth"
 )
 
 type NvHTTP interface {
@@ -39,7 +45,9 @@ type NvHTTP interface {
 	ServerInfo() (*ServerInfoResponse, error)
 
 	AppList() ([]NvApp, error)
+	AppBoxArt(appID int) ([]byte, error)
 	LaunchApp(ctx context.Context, appID int, enableHDR bool) (string, error)
+	ResumeApp(ctx context.Context, appID int, enableHDR bool) (string, error)
 	QuitApp(ctx context.Context) error
 
 	ExecutePairingCommand(ctx context.Context, args map[string]string) (*PairResponse, error)
@@ -47,41 +55,157 @@ type NvHTTP interface {
 	Unpair() error
 }
 
-func NewHTTP(uniqueID string, host string, dir ...string) (NvHTTP, error) {
-	if uniqueID == "" {
-		uniqueID = "0123456789ABCDEF"
+// DefaultPath returns the default working directory, ~/.flarex/game.
+func DefaultPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
 	}
 
-	h := &nvHTTP{
-		uniqueID: uniqueID,
-		host:     host,
-		http:     new(http.Client),
+	return filepath.Join(homeDir, ".flarex", "game"), nil
+}
+
+var ErrServerCertMismatch = errors.New("server certificate does not match the paired host")
+
+type HTTPOption func(*httpOptions)
+
+type httpOptions struct {
+	uniqueID     string
+	deviceName   string
+	path         string
+	httpsPort    int
+	httpPort     int
+	timeout      time.Duration
+	verification TLSVerification
+	transport    http.RoundTripper
+}
+
+// TLSVerification selects how the host's HTTPS certificate is checked.
+type TLSVerification int
+
+const (
+	// TLSVerifyPinned accepts only the server certificate stored when
+	// pairing. Until then any certificate is accepted, as GameStream hosts
+	// use self-signed certificates.
+	TLSVerifyPinned TLSVerification = iota
+
+	// TLSSkipVerify accepts any certificate. It is meant for re-pairing a
+	// host whose certificate changed.
+	TLSSkipVerify
+
+	// TLSVerifySystem verifies against the system roots, e.g. for hosts
+	// behind a reverse proxy with a public certificate.
+	TLSVerifySystem
+)
+
+// WithUniqueID sets the client's uniqueid, "0123456789ABCDEF" by default.
+func WithUniqueID(uniqueID string) HTTPOption {
+	return func(opts *httpOptions) {
+		opts.uniqueID = uniqueID
+	}
+}
+
+// WithDeviceName sets the name shown on the host after pairing.
+func WithDeviceName(name string) HTTPOption {
+	return func(opts *httpOptions) {
+		opts.deviceName = name
 	}
+}
 
-	var workdir string
-	if len(dir) > 0 {
-		workdir = dir[0]
-	} else {
-		homeDir, err := os.UserHomeDir()
+// WithPath sets the working directory; the client certificate is kept in
+// its certs directory, so instances with different paths pair independently.
+// It defaults to DefaultPath.
+func WithPath(path string) HTTPOption {
+	return func(opts *httpOptions) {
+		opts.path = path
+	}
+}
+
+// WithPorts sets the HTTPS and HTTP ports, for hosts not using 47984 and
+// 47989.
+func WithPorts(httpsPort, httpPort int) HTTPOption {
+	return func(opts *httpOptions) {
+		opts.httpsPort = httpsPort
+		opts.httpPort = httpPort
+	}
+}
+
+// WithTimeout limits each request, including reading the response body.
+func WithTimeout(timeout time.Duration) HTTPOption {
+	return func(opts *httpOptions) {
+		opts.timeout = timeout
+	}
+}
+
+func WithTLSVerification(verification TLSVerification) HTTPOption {
+	return func(opts *httpOptions) {
+		opts.verification = verification
+	}
+}
+
+// WithTransport replaces the transport of both the HTTP and HTTPS clients.
+// The transport is then responsible for TLS, including presenting the
+// client certificate.
+func WithTransport(transport http.RoundTripper) HTTPOption {
+	return func(opts *httpOptions) {
+		opts.transport = transport
+	}
+}
+
+func NewHTTP(host string, opts ...HTTPOption) (NvHTTP, error) {
+	options := &httpOptions{
+		uniqueID:   "0123456789ABCDEF",
+		deviceName: DEFAULT_DEVICE_NAME,
+		httpsPort:  DEFAULT_HTTPS_PORT,
+		httpPort:   DEFAULT_HTTP_PORT,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.path == "" {
+		path, err := DefaultPath()
 		if err != nil {
 			return nil, err
 		}
 
-		workdir = filepath.Join(homeDir, ".flarex", "game")
+		options.path = path
 	}
 
-	h.path = filepath.Join(workdir, "certs")
+	h := &nvHTTP{
+		uniqueID:   options.uniqueID,
+		deviceName: options.deviceName,
+		host:       host,
+		httpsPort:  options.httpsPort,
+		httpPort:   options.httpPort,
+		path:       filepath.Join(options.path, "certs"),
+	}
 
-	if err := h.loadClientCertificate(); err != nil {
+	if err := h.loadClientCertificate(options.verification); err != nil {
 		return nil, err
 	}
 
+	h.http = &http.Client{
+		Timeout: options.timeout,
+	}
+
+	h.https.Timeout = options.timeout
+
+	if options.transport != nil {
+		h.http.Transport = options.transport
+		h.https.Transport = options.transport
+	}
+
 	return h, nil
 }
 
 type nvHTTP struct {
-	uniqueID string
-	host     string
+	uniqueID   string
+	deviceName string
+	host       string
+	httpsPort  int
+	httpPort   int
 
 	path    string
 	keyPEM  []byte
@@ -93,9 +217,18 @@ type nvHTTP struct {
 
 	http  *http.Client
 	https *http.Client
+	sync.RWMutex
+}
+
+func (h *nvHTTP) httpsURL(resource string) string {
+	return "https://" + net.JoinHostPort(h.host, strconv.Itoa(h.httpsPort)) + "/" + resource
+}
+
+func (h *nvHTTP) httpURL(resource string) string {
+	return "http://" + net.JoinHostPort(h.host, strconv.Itoa(h.httpPort)) + "/" + resource
 }
 
-func (h *nvHTTP) loadClientCertificate() error {
+func (h *nvHTTP) loadClientCertificate(verification TLSVerification) error {
 	if err := os.MkdirAll(h.path, 0700); err != nil {
 		return err
 	}
@@ -147,8 +280,16 @@ func (h *nvHTTP) loadClientCertificate() error {
 	}
 
 	tlsConfig := &tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		InsecureSkipVerify: true,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	switch verification {
+	case TLSVerifyPinned:
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = h.verifyPinnedCert
+
+	case TLSSkipVerify:
+		tlsConfig.InsecureSkipVerify = true
 	}
 
 	h.https = &http.Client{
@@ -182,6 +323,21 @@ func (h *nvHTTP) loadClientCertificate() error {
 	return nil
 }
 
+// verifyPinnedCert checks the host presents the certificate received when
+// pairing.
+func (h *nvHTTP) verifyPinnedCert(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	serverCert := h.ServerCert()
+	if serverCert == nil {
+		return nil
+	}
+
+	if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], serverCert.Raw) {
+		return ErrServerCertMismatch
+	}
+
+	return nil
+}
+
 func (h *nvHTTP) CertPEM() []byte {
 	return h.certPEM
 }
@@ -191,6 +347,9 @@ func (h *nvHTTP) ClientCert() *x509.Certificate {
 }
 
 func (h *nvHTTP) ServerCert() *x509.Certificate {
+	h.RLock()
+	defer h.RUnlock()
+
 	return h.serverCert
 }
 
@@ -211,7 +370,13 @@ func (h *nvHTTP) SetServerCert(certPEM []byte) error {
 		return fmt.Errorf("failed to parse certificate: %w", err)
 	}
 
+	h.Lock()
 	h.serverCert = serverCert
+	h.Unlock()
+
+	// Connections verified against the previous certificate are dropped.
+	h.https.CloseIdleConnections()
+
 	return nil
 }
 
@@ -228,6 +393,8 @@ func (h *nvHTTP) Sign(data []byte) ([]byte, error) {
 
 type ServerInfoResponse struct {
 	XMLName                xml.Name `xml:"root"`
+	StatusCode             int      `xml:"status_code,attr"`
+	StatusMessage          string   `xml:"status_message,attr"`
 	Hostname               string   `xml:"hostname"`
 	AppVersion             string   `xml:"appversion"`
 	GfeVersion             string   `xml:"GfeVersion"`
@@ -259,22 +426,23 @@ func (h *nvHTTP) ServerInfo() (*ServerInfoResponse, error) {
 	values := url.Values{}
 	values.Add("uniqueid", h.uniqueID)
 
-	url, err := url.Parse("https://" + h.host + ":" + strconv.Itoa(DEFAULT_HTTPS_PORT) + "/serverinfo")
+	url, err := url.Parse(h.httpsURL("serverinfo"))
 	if err != nil {
 		return nil, err
 	}
 
 	url.RawQuery = values.Encode()
 
-	resp, err := h.https.Get(url.String())
+	req, err := http.NewRequest("GET", url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP request failed with status: %s", resp.Status)
+	resp, err := do(h.https, "serverinfo", req)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	decoder := xml.NewDecoder(resp.Body)
 
@@ -283,6 +451,10 @@ func (h *nvHTTP) ServerInfo() (*ServerInfoResponse, error) {
 		return nil, err
 	}
 
+	if err := checkStatus("serverinfo", info.StatusCode, info.StatusMessage); err != nil {
+		return nil, err
+	}
+
 	return info, nil
 }
 
@@ -296,9 +468,10 @@ func (h *nvHTTP) CurrentGame() int {
 }
 
 type AppListResponse struct {
-	XMLName    xml.Name `xml:"root"`
-	StatusCode int      `xml:"status_code,attr"`
-	Apps       []NvApp  `xml:"App"`
+	XMLName       xml.Name `xml:"root"`
+	StatusCode    int      `xml:"status_code,attr"`
+	StatusMessage string   `xml:"status_message,attr"`
+	Apps          []NvApp  `xml:"App"`
 }
 
 type NvApp struct {
@@ -329,22 +502,23 @@ func (h *nvHTTP) AppList() ([]NvApp, error) {
 	values := url.Values{}
 	values.Add("uniqueid", h.uniqueID)
 
-	url, err := url.Parse("https://" + h.host + ":" + strconv.Itoa(DEFAULT_HTTPS_PORT) + "/applist")
+	url, err := url.Parse(h.httpsURL("applist"))
 	if err != nil {
 		return nil, err
 	}
 
 	url.RawQuery = values.Encode()
 
-	resp, err := h.https.Get(url.String())
+	req, err := http.NewRequest("GET", url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP request failed with status: %s", resp.Status)
+	resp, err := do(h.https, "applist", req)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	decoder := xml.NewDecoder(resp.Body)
 
@@ -353,18 +527,115 @@ func (h *nvHTTP) AppList() ([]NvApp, error) {
 		return nil, err
 	}
 
+	if err := checkStatus("applist", appListResp.StatusCode, appListResp.StatusMessage); err != nil {
+		return nil, err
+	}
+
 	return appListResp.Apps, nil
 }
 
+// MaxBoxArtSize bounds the box art read from the host.
+const MaxBoxArtSize = 4 << 20
+
+// AppBoxArt returns the box art of an app, usually a PNG image.
+func (h *nvHTTP) AppBoxArt(appID int) ([]byte, error) {
+	values := url.Values{}
+	values.Add("uniqueid", h.uniqueID)
+	values.Add("appid", strconv.Itoa(appID))
+	values.Add("AssetType", "2")
+	values.Add("AssetIdx", "0")
+
+	url, err := url.Parse(h.httpsURL("appasset"))
+	if err != nil {
+		return nil, err
+	}
+
+	url.RawQuery = values.Encode()
+
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := do(h.https, "appasset", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxBoxArtSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MaxBoxArtSize {
+		return nil, errors.New("box art too large")
+	}
+
+	// Errors come as XML with a status code.
+	if bytes.HasPrefix(data, []byte("<?xml")) || bytes.HasPrefix(data, []byte("<root")) {
+		var status struct {
+			StatusCode    int    `xml:"status_code,attr"`
+			StatusMessage string `xml:"status_message,attr"`
+		}
+
+		if err := xml.Unmarshal(data, &status); err != nil {
+			return nil, err
+		}
+
+		if err := checkStatus("appasset", status.StatusCode, status.StatusMessage); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrAppNotFound
+	}
+
+	return data, nil
+}
+
 func (h *nvHTTP) LaunchApp(ctx context.Context, appID int, enableHDR bool) (string, error) {
+	values, err := h.sessionValues(ctx, appID, enableHDR)
+	if err != nil {
+		return "", err
+	}
+
+	switch currentGame := h.CurrentGame(); currentGame {
+	case 0:
+	case appID:
+		return h.startSession(ctx, "resume", values)
+	default:
+		if err := h.QuitApp(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	return h.startSession(ctx, "launch", values)
+}
+
+// ResumeApp rejoins the session of the app running on the host, the usual
+// flow when serverinfo reports a currentgame, or after the streaming
+// connection terminated. It takes the same parameters as LaunchApp; the
+// remote input key in ctx replaces the one of the previous connection.
+func (h *nvHTTP) ResumeApp(ctx context.Context, appID int, enableHDR bool) (string, error) {
+	values, err := h.sessionValues(ctx, appID, enableHDR)
+	if err != nil {
+		return "", err
+	}
+
+	return h.startSession(ctx, "resume", values)
+}
+
+// sessionValues builds the query shared by /launch and /resume from the
+// stream configuration and remote input key in ctx.
+func (h *nvHTTP) sessionValues(ctx context.Context, appID int, enableHDR bool) (url.Values, error) {
 	stream, ok := ctx.Value(CtxKeyStreamConfiguration).(*StreamConfiguration)
 	if !ok {
-		return "", errors.New("stream configuration not found in context")
+		return nil, errors.New("stream configuration not found in context")
 	}
 
 	ri, ok := ctx.Value(CtxKeyRemoteInputAES).(*moonlight.RemoteInputAES)
 	if !ok {
-		return "", errors.New("remote input AES not found in context")
+		return nil, errors.New("remote input AES not found in context")
 	}
 
 	values := url.Values{}
@@ -381,7 +652,7 @@ func (h *nvHTTP) LaunchApp(ctx context.Context, appID int, enableHDR bool) (stri
 	}
 
 	values.Add("rikey", hex.EncodeToString(ri.Key[:]))
-	values.Add("rikeyid", fmt.Sprintf("%d", ri.IV[:]))
+	values.Add("rikeyid", strconv.Itoa(int(ri.KeyID())))
 
 	if enableHDR {
 		values.Add("hdrMode", "1")
@@ -409,21 +680,11 @@ func (h *nvHTTP) LaunchApp(ctx context.Context, appID int, enableHDR bool) (stri
 
 	values.Add("corever", "1")
 
-	action := "launch"
-	if currentGame := h.CurrentGame(); currentGame != 0 {
-		if appID == h.CurrentGame() {
-			action = "resume"
-		} else {
-			err := h.QuitApp(ctx)
-			if err != nil {
-				return "", err
-			}
-		}
-	}
-
-	resource := fmt.Sprintf("https://%s:%d/%s", h.host, DEFAULT_HTTPS_PORT, action)
+	return values, nil
+}
 
-	url, err := url.Parse(resource)
+func (h *nvHTTP) startSession(ctx context.Context, action string, values url.Values) (string, error) {
+	url, err := url.Parse(h.httpsURL(action))
 	if err != nil {
 		return "", err
 	}
@@ -435,22 +696,19 @@ func (h *nvHTTP) LaunchApp(ctx context.Context, appID int, enableHDR bool) (stri
 		return "", err
 	}
 
-	resp, err := h.https.Do(req)
+	resp, err := do(h.https, action, req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP request failed with status: %s", resp.Status)
-	}
-
 	var raw struct {
-		XMLName     xml.Name `xml:"root"`
-		StatusCode  int      `xml:"status_code,attr"`
-		SessionURL  string   `xml:"sessionUrl0"`
-		GameSession int      `xml:"gamesession"`
-		Resume      int      `xml:"resume"`
+		XMLName       xml.Name `xml:"root"`
+		StatusCode    int      `xml:"status_code,attr"`
+		StatusMessage string   `xml:"status_message,attr"`
+		SessionURL    string   `xml:"sessionUrl0"`
+		GameSession   int      `xml:"gamesession"`
+		Resume        int      `xml:"resume"`
 	}
 
 	decoder := xml.NewDecoder(resp.Body)
@@ -458,6 +716,16 @@ func (h *nvHTTP) LaunchApp(ctx context.Context, appID int, enableHDR bool) (stri
 		return "", err
 	}
 
+	if err := checkStatus(action, raw.StatusCode, raw.StatusMessage); err != nil {
+		// Hosts answer 404 for an app ID they do not know.
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return "", fmt.Errorf("%w: %w", ErrAppNotFound, err)
+		}
+
+		return "", err
+	}
+
 	if action == "launch" && raw.GameSession != 1 {
 		return "", errors.New("failed to launch app")
 	}
@@ -473,7 +741,7 @@ func (h *nvHTTP) QuitApp(ctx context.Context) error {
 	values := url.Values{}
 	values.Add("uniqueid", h.uniqueID)
 
-	url, err := url.Parse("https://" + h.host + ":" + strconv.Itoa(DEFAULT_HTTPS_PORT) + "/cancel")
+	url, err := url.Parse(h.httpsURL("cancel"))
 	if err != nil {
 		return err
 	}
@@ -485,20 +753,17 @@ func (h *nvHTTP) QuitApp(ctx context.Context) error {
 		return err
 	}
 
-	resp, err := h.https.Do(req)
+	resp, err := do(h.https, "cancel", req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP request failed with status: %s", resp.Status)
-	}
-
 	var raw struct {
-		XMLName    xml.Name `xml:"root"`
-		StatusCode int      `xml:"status_code,attr"`
-		Cancel     int      `xml:"cancel"`
+		XMLName       xml.Name `xml:"root"`
+		StatusCode    int      `xml:"status_code,attr"`
+		StatusMessage string   `xml:"status_message,attr"`
+		Cancel        int      `xml:"cancel"`
 	}
 
 	decoder := xml.NewDecoder(resp.Body)
@@ -506,6 +771,10 @@ func (h *nvHTTP) QuitApp(ctx context.Context) error {
 		return err
 	}
 
+	if err := checkStatus("cancel", raw.StatusCode, raw.StatusMessage); err != nil {
+		return err
+	}
+
 	if raw.Cancel != 1 {
 		return errors.New("failed to quit app")
 	}
@@ -516,6 +785,7 @@ func (h *nvHTTP) QuitApp(ctx context.Context) error {
 type PairResponse struct {
 	XMLName                 xml.Name `xml:"root"`
 	StatusCode              int      `xml:"status_code,attr"`
+	StatusMessage           string   `xml:"status_message,attr"`
 	Paired                  int      `xml:"paired"`
 	ServerCert              string   `xml:"plaincert"`
 	ServerChallengeResponse string   `xml:"challengeresponse"`
@@ -525,14 +795,14 @@ type PairResponse struct {
 func (h *nvHTTP) ExecutePairingCommand(ctx context.Context, args map[string]string) (*PairResponse, error) {
 	values := url.Values{}
 	values.Add("uniqueid", h.uniqueID)
-	values.Add("devicename", "roth")
+	values.Add("devicename", h.deviceName)
 	values.Add("updateState", "1")
 
 	for k, v := range args {
 		values.Add(k, v)
 	}
 
-	url, err := url.Parse("http://" + h.host + ":" + strconv.Itoa(DEFAULT_HTTP_PORT) + "/pair")
+	url, err := url.Parse(h.httpURL("pair"))
 	if err != nil {
 		return nil, err
 	}
@@ -544,16 +814,12 @@ func (h *nvHTTP) ExecutePairingCommand(ctx context.Context, args map[string]stri
 		return nil, err
 	}
 
-	resp, err := h.http.Do(req)
+	resp, err := do(h.http, "pair", req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("HTTP request failed with status: " + resp.Status)
-	}
-
 	decoder := xml.NewDecoder(resp.Body)
 
 	var pairResp *PairResponse
@@ -567,11 +833,11 @@ func (h *nvHTTP) ExecutePairingCommand(ctx context.Context, args map[string]stri
 func (h *nvHTTP) ExecutePairingChallenge(ctx context.Context) (*PairResponse, error) {
 	values := url.Values{}
 	values.Add("uniqueid", h.uniqueID)
-	values.Add("devicename", "roth")
+	values.Add("devicename", h.deviceName)
 	values.Add("updateState", "1")
 	values.Add("phrase", "pairchallenge")
 
-	url, err := url.Parse("http://" + h.host + ":" + strconv.Itoa(DEFAULT_HTTP_PORT) + "/pair")
+	url, err := url.Parse(h.httpURL("pair"))
 	if err != nil {
 		return nil, err
 	}
@@ -583,16 +849,12 @@ func (h *nvHTTP) ExecutePairingChallenge(ctx context.Context) (*PairResponse, er
 		return nil, err
 	}
 
-	resp, err := h.https.Do(req)
+	resp, err := do(h.https, "pair", req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP request failed with status: %s", resp.Status)
-	}
-
 	decoder := xml.NewDecoder(resp.Body)
 
 	var pairResp *PairResponse
@@ -607,22 +869,22 @@ func (h *nvHTTP) Unpair() error {
 	values := url.Values{}
 	values.Add("uniqueid", h.uniqueID)
 
-	url, err := url.Parse("http://" + h.host + ":" + strconv.Itoa(DEFAULT_HTTP_PORT) + "/unpair")
+	url, err := url.Parse(h.httpURL("unpair"))
 	if err != nil {
 		return err
 	}
 
 	url.RawQuery = values.Encode()
 
-	resp, err := http.Get(url.String())
+	req, err := http.NewRequest("GET", url.String(), nil)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.New("HTTP request failed with status: " + resp.Status)
+	resp, err := do(h.http, "unpair", req)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return resp.Body.Close()
 }
@@ -3,17 +3,25 @@ package nvstream
 import (
 	"context"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
 )
 
 func TestServerInfo(t *testing.T) {
 	assert := assert.New(t)
 
-	http, err := NewHTTP("MyGameClient", "localhost")
+	http, err := NewHTTP("localhost", WithUniqueID("MyGameClient"))
 	if err != nil {
 		assert.Fail(err.Error())
 		return
@@ -38,7 +46,7 @@ func TestServerInfo(t *testing.T) {
 func TestAppList(t *testing.T) {
 	assert := assert.New(t)
 
-	http, err := NewHTTP("MyGameClient", "localhost")
+	http, err := NewHTTP("localhost", WithUniqueID("MyGameClient"))
 	if err != nil {
 		assert.Fail(err.Error())
 		return
@@ -60,7 +68,7 @@ func TestAppList(t *testing.T) {
 func TestLaunchApp(t *testing.T) {
 	assert := assert.New(t)
 
-	http, err := NewHTTP("MyGameClient", "localhost")
+	http, err := NewHTTP("localhost", WithUniqueID("MyGameClient"))
 	if err != nil {
 		assert.Fail(err.Error())
 		return
@@ -100,7 +108,7 @@ func TestLaunchApp(t *testing.T) {
 func TestQuitApp(t *testing.T) {
 	assert := assert.New(t)
 
-	http, err := NewHTTP("MyGameClient", "localhost")
+	http, err := NewHTTP("localhost", WithUniqueID("MyGameClient"))
 	if err != nil {
 		assert.Fail(err.Error())
 		return
@@ -112,3 +120,232 @@ func TestQuitApp(t *testing.T) {
 		return
 	}
 }
+
+func TestResumeApp(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		launched bool
+		query    url.Values
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/serverinfo":
+			w.Write([]byte(`<root status_code="200"><currentgame>42</currentgame></root>`))
+		case "/resume":
+			query = r.URL.Query()
+			w.Write([]byte(`<root status_code="200"><sessionUrl0>rtsp://host:48010</sessionUrl0><resume>1</resume></root>`))
+		case "/launch":
+			launched = true
+			w.Write([]byte(`<root status_code="200"><gamesession>1</gamesession></root>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	client, err := NewHTTP(u.Hostname(),
+		WithPath(t.TempDir()),
+		WithPorts(port, port),
+		WithTransport(srv.Client().Transport),
+	)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	ri := &moonlight.RemoteInputAES{
+		Key: [16]byte{0xde, 0xad, 0xbe, 0xef},
+		IV:  [16]byte{0xff, 0xff, 0xff, 0xfe},
+	}
+
+	ctx := context.WithValue(context.Background(), CtxKeyStreamConfiguration, DefaultStreamConfiguration())
+	ctx = context.WithValue(ctx, CtxKeyRemoteInputAES, ri)
+
+	sessionURL, err := client.ResumeApp(ctx, 42, false)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("rtsp://host:48010", sessionURL)
+	assert.Equal("42", query.Get("appid"))
+	assert.Equal("deadbeef000000000000000000000000", query.Get("rikey"))
+	assert.Equal("-2", query.Get("rikeyid"))
+
+	// launching the running app rejoins it
+	query = nil
+
+	_, err = client.LaunchApp(ctx, 42, false)
+	assert.NoError(err)
+	assert.NotNil(query)
+	assert.False(launched)
+}
+
+func TestAppBoxArt(t *testing.T) {
+	assert := assert.New(t)
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+
+	var query url.Values
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/appasset" {
+			http.NotFound(w, r)
+			return
+		}
+
+		query = r.URL.Query()
+
+		if query.Get("appid") != "42" {
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><root status_code="404" status_message="Game not found"/>`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	client, err := NewHTTP(u.Hostname(),
+		WithPath(t.TempDir()),
+		WithPorts(port, port),
+		WithTransport(srv.Client().Transport),
+	)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	data, err := client.AppBoxArt(42)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(png, data)
+	assert.Equal("2", query.Get("AssetType"))
+	assert.Equal("0", query.Get("AssetIdx"))
+
+	_, err = client.AppBoxArt(7)
+
+	var httpErr *HTTPError
+	if assert.ErrorAs(err, &httpErr) {
+		assert.Equal(404, httpErr.StatusCode)
+	}
+}
+
+func TestHTTPOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	var queries []url.Values
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+
+		switch r.URL.Path {
+		case "/serverinfo":
+			w.Write([]byte(`<root status_code="200"><hostname>sunshine</hostname><PairStatus>1</PairStatus></root>`))
+		case "/pair":
+			w.Write([]byte(`<root status_code="200"><paired>1</paired></root>`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	httpsSrv := httptest.NewTLSServer(handler)
+	defer httpsSrv.Close()
+
+	httpSrv := httptest.NewServer(handler)
+	defer httpSrv.Close()
+
+	httpsURL, _ := url.Parse(httpsSrv.URL)
+	httpURL, _ := url.Parse(httpSrv.URL)
+
+	httpsPort, _ := strconv.Atoi(httpsURL.Port())
+	httpPort, _ := strconv.Atoi(httpURL.Port())
+
+	client, err := NewHTTP(httpsURL.Hostname(),
+		WithPath(t.TempDir()),
+		WithUniqueID("TESTCLIENT"),
+		WithDeviceName("harness"),
+		WithPorts(httpsPort, httpPort),
+		WithTimeout(5*time.Second),
+		WithTransport(httpsSrv.Client().Transport),
+	)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	info, err := client.ServerInfo()
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("sunshine", info.Hostname)
+	assert.True(info.IsPaired())
+
+	resp, err := client.ExecutePairingCommand(context.Background(), map[string]string{"phrase": "getservercert"})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(1, resp.Paired)
+
+	if assert.Len(queries, 2) {
+		assert.Equal("TESTCLIENT", queries[0].Get("uniqueid"))
+		assert.Equal("harness", queries[1].Get("devicename"))
+		assert.Equal("getservercert", queries[1].Get("phrase"))
+	}
+}
+
+func TestHTTPPinnedServerCert(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<root status_code="200"><hostname>sunshine</hostname></root>`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	client, err := NewHTTP(u.Hostname(),
+		WithPath(t.TempDir()),
+		WithPorts(port, port),
+	)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// Not paired yet: any certificate is accepted.
+	_, err = client.ServerInfo()
+	assert.NoError(err)
+
+	otherPEM, _, err := GenerateCertificate(time.Hour, 1024)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.NoError(client.SetServerCert(otherPEM))
+
+	_, err = client.ServerInfo()
+	assert.ErrorIs(err, ErrServerCertMismatch)
+
+	serverPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(client.SetServerCert(serverPEM))
+
+	_, err = client.ServerInfo()
+	assert.NoError(err)
+}
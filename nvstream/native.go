@@ -0,0 +1,923 @@
+package nvstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// nativeEngine runs connections in Go, in builds without moonlight-common-c.
+// It supports hosts with an encrypted control stream (Sunshine, GFE 3.22
+// and later), H.264 video and unencrypted video and audio.
+type nativeEngine struct{}
+
+func (nativeEngine) Start(ctx context.Context, cl moonlight.ConnectionListener, vr moonlight.VideoDecoderRenderer, ar moonlight.AudioRenderer,
+	serverInfo moonlight.ServerInformation, streamConfig moonlight.StreamConfiguration) (moonlight.Session, error) {
+
+	s := &nativeSession{
+		log: zap.L().With(
+			zap.String("component", "nvstream.native"),
+			zap.String("host", serverInfo.Address),
+		),
+		listener: cl,
+		video:    vr,
+		audio:    ar,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+	}
+
+	if err := s.connect(ctx, serverInfo, streamConfig); err != nil {
+		s.Stop()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Control stream packet types of Gen 7 hosts; Sunshine's extensions
+// start with 0x55.
+const (
+	controlTypeEncrypted      uint16 = 0x0001
+	controlTypePeriodicPing   uint16 = 0x0200
+	controlTypeInput          uint16 = 0x0206
+	controlTypeIDRFrame       uint16 = 0x0302
+	controlTypeStartA         uint16 = 0x0305
+	controlTypeStartB         uint16 = 0x0307
+	controlTypeTermination    uint16 = 0x0109
+	controlTypeRumble         uint16 = 0x010b
+	controlTypeHDRMode        uint16 = 0x010e
+	controlTypeRumbleTriggers uint16 = 0x5500
+	controlTypeMotionEvent    uint16 = 0x5501
+	controlTypeControllerLED  uint16 = 0x5502
+)
+
+const (
+	controlChannelCount = 0x20
+	controlTimeout      = 10 * time.Second
+	pingInterval        = 500 * time.Millisecond
+	videoTimeout        = 10 * time.Second
+	idrRequestInterval  = 500 * time.Millisecond
+
+	// terminationServerClosed is the host's code of a graceful end.
+	terminationServerClosed = 0x80030023
+)
+
+type nativeSession struct {
+	log      *zap.Logger
+	listener moonlight.ConnectionListener
+	video    moonlight.VideoDecoderRenderer
+	audio    moonlight.AudioRenderer
+	start    time.Time
+
+	rtsp      *RTSPClient
+	session   *RTSPSession
+	hostIP    net.IP
+	control   *controlStream
+	videoConn *net.UDPConn
+	audioConn *net.UDPConn
+
+	// audioCipher decrypts the audio, nil unless encrypted
+	audioCipher *audioCipher
+
+	videoSetup, videoStarted bool
+	audioSetup, audioStarted bool
+
+	lastIDRRequest time.Time
+	idrMu          sync.Mutex
+
+	terminated sync.Once
+	done       chan struct{}
+	stopped    sync.Once
+	wg         sync.WaitGroup
+}
+
+// stage runs a stage of the connection, reporting it to the listener.
+func (s *nativeSession) stage(stage int, fn func() error) error {
+	s.listener.StageStarting(stage)
+
+	if err := fn(); err != nil {
+		s.listener.StageFailed(stage, -1)
+		return err
+	}
+
+	s.listener.StageComplete(stage)
+	return nil
+}
+
+func (s *nativeSession) connect(ctx context.Context, serverInfo moonlight.ServerInformation, cfg moonlight.StreamConfiguration) error {
+	if cfg.SupportedVideoFormats&int(moonlight.VIDEO_FORMAT_MASK_H264) == 0 {
+		return errors.New("native connections only support h264")
+	}
+
+	if cfg.RemoteInputAES == nil {
+		return errors.New("remote input key not set")
+	}
+
+	if !appVersionAtLeast(serverInfo.AppVersion, 7, 1, 431) {
+		return errors.New("native connections need a host with an encrypted control stream")
+	}
+
+	sessionURL := serverInfo.RTSPSessionURL
+	if sessionURL == "" {
+		sessionURL = "rtsp://" + net.JoinHostPort(serverInfo.Address, strconv.Itoa(DEFAULT_RTSP_PORT))
+	}
+
+	u, err := url.Parse(sessionURL)
+	if err != nil {
+		return err
+	}
+
+	host := u.Hostname()
+
+	stages := []struct {
+		stage int
+		fn    func() error
+	}{
+		{moonlight.STAGE_PLATFORM_INIT, func() error {
+			return nil
+		}},
+		{moonlight.STAGE_NAME_RESOLUTION, func() error {
+			addr, err := net.ResolveIPAddr("ip", host)
+			if err != nil {
+				return err
+			}
+
+			s.hostIP = addr.IP
+			return nil
+		}},
+		{moonlight.STAGE_AUDIO_STREAM_INIT, func() error {
+			s.audioConn, err = net.ListenUDP("udp", nil)
+			return err
+		}},
+		{moonlight.STAGE_RTSP_HANDSHAKE, func() error {
+			return s.handshake(ctx, sessionURL, host, serverInfo.AppVersion, &cfg)
+		}},
+		{moonlight.STAGE_CONTROL_STREAM_INIT, func() error {
+			s.control, err = newControlStream(cfg.RemoteInputAES.Key[:], s.encryptionEnabled(&cfg)&ssEncControlV2 != 0)
+			return err
+		}},
+		{moonlight.STAGE_VIDEO_STREAM_INIT, func() error {
+			s.videoConn, err = net.ListenUDP("udp", nil)
+			return err
+		}},
+		{moonlight.STAGE_INPUT_STREAM_INIT, func() error {
+			// Input rides the control stream.
+			return nil
+		}},
+		{moonlight.STAGE_CONTROL_STREAM_START, func() error {
+			return s.startControl(ctx)
+		}},
+		{moonlight.STAGE_VIDEO_STREAM_START, func() error {
+			return s.startVideo(&cfg)
+		}},
+		{moonlight.STAGE_AUDIO_STREAM_START, func() error {
+			return s.startAudio(&cfg)
+		}},
+		{moonlight.STAGE_INPUT_STREAM_START, func() error {
+			return nil
+		}},
+	}
+
+	for _, stage := range stages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := s.stage(stage.stage, stage.fn); err != nil {
+			return err
+		}
+	}
+
+	s.wg.Add(1)
+	go s.pingLoop()
+
+	s.listener.ConnectionStarted()
+
+	return nil
+}
+
+// encryptionEnabled returns the encryption announced to the host, as
+// BuildStreamSDP computes it.
+func (s *nativeSession) encryptionEnabled(cfg *moonlight.StreamConfiguration) uint32 {
+	return negotiateEncryption(moonlight.EncryptionFlags(cfg.EncryptionFlags), s.session.Description)
+}
+
+func (s *nativeSession) handshake(ctx context.Context, sessionURL, host, appVersion string, cfg *moonlight.StreamConfiguration) error {
+	// Sunshine versions end in a negative number and keep the
+	// connection.
+	persistent := parseAppVersion(appVersion)[3] < 0
+
+	client, err := NewRTSPClient(sessionURL, RTSPClientVersion(appVersion), persistent)
+	if err != nil {
+		return err
+	}
+
+	s.rtsp = client
+
+	// Only H.264 is offered, the one codec depacketized.
+	stream := &StreamConfiguration{
+		Width:                 cfg.Width,
+		Height:                cfg.Height,
+		RefreshRate:           cfg.FPS,
+		ClientRefreshRateX100: cfg.ClientRefreshRateX100,
+		Bitrate:               cfg.Bitrate,
+		MaxPacketSize:         cfg.PacketSize,
+		Remote:                moonlight.StreamingRemotely(cfg.StreamingRemotely),
+		AudioConfiguration:    cfg.AudioConfiguration,
+		SupportedVideoFormats: []moonlight.VideoFormat{moonlight.VIDEO_FORMAT_H264},
+		EncryptionFlags:       moonlight.EncryptionFlags(cfg.EncryptionFlags),
+		ColorRange:            moonlight.ColorRange(cfg.ColorRange),
+		ColorSpace:            moonlight.ColorSpace(cfg.ColorSpace),
+	}
+
+	s.session, err = client.Handshake(ctx, host, appVersion, stream)
+	if err != nil {
+		return err
+	}
+
+	if s.encryptionEnabled(cfg)&ssEncVideo != 0 {
+		return errors.New("native connections do not support encrypted video")
+	}
+
+	return nil
+}
+
+func (s *nativeSession) startControl(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, controlTimeout)
+	defer cancel()
+
+	address := net.JoinHostPort(s.hostIP.String(), strconv.Itoa(s.session.ControlPort))
+
+	peer, err := dialENet(ctx, address, controlChannelCount, s.session.ConnectData)
+	if err != nil {
+		return err
+	}
+
+	s.control.peer = peer
+
+	s.wg.Add(1)
+	go s.controlLoop()
+
+	if err := s.control.send(controlTypeStartA, []byte{0, 0}); err != nil {
+		return err
+	}
+
+	return s.control.send(controlTypeStartB, []byte{0})
+}
+
+func (s *nativeSession) startVideo(cfg *moonlight.StreamConfiguration) error {
+	rc := s.video.Setup(int(moonlight.VIDEO_FORMAT_H264), cfg.Width, cfg.Height, cfg.FPS, nil, 0)
+	if rc != 0 {
+		return errors.New("video setup failed with code " + strconv.Itoa(rc))
+	}
+
+	s.videoSetup = true
+
+	s.video.Start()
+	s.videoStarted = true
+
+	s.wg.Add(1)
+	go s.videoLoop()
+
+	return nil
+}
+
+func (s *nativeSession) startAudio(cfg *moonlight.StreamConfiguration) error {
+	if s.encryptionEnabled(cfg)&ssEncAudio != 0 {
+		c, err := newAudioCipher(cfg.RemoteInputAES)
+		if err != nil {
+			return err
+		}
+
+		s.audioCipher = c
+	}
+
+	opusConfig := opusConfiguration(cfg.AudioConfiguration, s.session.Description)
+
+	rc := s.audio.Init(cfg.AudioConfiguration, opusConfig, nil, 0)
+	if rc != 0 {
+		return errors.New("audio init failed with code " + strconv.Itoa(rc))
+	}
+
+	s.audioSetup = true
+
+	s.audio.Start()
+	s.audioStarted = true
+
+	s.wg.Add(1)
+	go s.audioLoop()
+
+	return nil
+}
+
+// Stop ends the connection and stops the renderers.
+func (s *nativeSession) Stop() {
+	s.stopped.Do(func() {
+		close(s.done)
+
+		if s.control != nil && s.control.peer != nil {
+			s.control.peer.Close()
+		}
+
+		if s.videoConn != nil {
+			s.videoConn.Close()
+		}
+
+		if s.audioConn != nil {
+			s.audioConn.Close()
+		}
+
+		s.wg.Wait()
+
+		if s.videoStarted {
+			s.video.Stop()
+		}
+
+		if s.videoSetup {
+			s.video.Cleanup()
+		}
+
+		if s.audioStarted {
+			s.audio.Stop()
+		}
+
+		if s.audioSetup {
+			s.audio.Cleanup()
+		}
+
+		if s.rtsp != nil {
+			s.rtsp.Close()
+		}
+	})
+}
+
+func (s *nativeSession) stopping() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// terminate reports the end of the connection once, unless it was
+// stopped.
+func (s *nativeSession) terminate(errorCode int) {
+	if s.stopping() {
+		return
+	}
+
+	s.terminated.Do(func() {
+		s.listener.ConnectionTerminated(errorCode)
+	})
+}
+
+func (s *nativeSession) RequestIDRFrame() {
+	s.idrMu.Lock()
+	s.lastIDRRequest = time.Now()
+	s.idrMu.Unlock()
+
+	if err := s.control.send(controlTypeIDRFrame, []byte{0, 0}); err != nil {
+		s.log.Warn("idr frame request failed", zap.Error(err))
+	}
+}
+
+// requestIDR asks for a keyframe after a loss, not more often than
+// idrRequestInterval.
+func (s *nativeSession) requestIDR() {
+	s.idrMu.Lock()
+	recent := time.Since(s.lastIDRRequest) < idrRequestInterval
+	s.idrMu.Unlock()
+
+	if !recent {
+		s.RequestIDRFrame()
+	}
+}
+
+// Stats fills the RTT the control stream estimated. Frames are handed to
+// the renderers as they complete, none pending.
+func (s *nativeSession) Stats(stats *moonlight.ConnectionStats) {
+	stats.RTT, stats.RTTVariance = s.control.peer.RTT()
+}
+
+func (s *nativeSession) SendInput(packet []byte) error {
+	return s.control.send(controlTypeInput, packet)
+}
+
+// pingLoop pings the video and audio ports, through which the host learns
+// where to send the streams, and the control stream, which it times out
+// without.
+func (s *nativeSession) pingLoop() {
+	defer s.wg.Done()
+
+	videoAddr := &net.UDPAddr{IP: s.hostIP, Port: s.session.VideoPort}
+	audioAddr := &net.UDPAddr{IP: s.hostIP, Port: s.session.AudioPort}
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	var sequence uint32
+	for {
+		sequence++
+		ping := pingPacket(s.session.PingPayload, sequence)
+
+		s.videoConn.WriteToUDP(ping, videoAddr)
+		s.audioConn.WriteToUDP(ping, audioAddr)
+
+		s.control.send(controlTypePeriodicPing, []byte{4, 0, 0, 0, 0, 0, 0, 0})
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pingPacket is Sunshine's ping, its payload and a sequence number, or
+// the legacy PING.
+func pingPacket(payload string, sequence uint32) []byte {
+	if len(payload) != 16 {
+		return []byte("PING")
+	}
+
+	return binary.BigEndian.AppendUint32([]byte(payload), sequence)
+}
+
+func (s *nativeSession) controlLoop() {
+	defer s.wg.Done()
+
+	peer := s.control.peer
+
+	for packet := range peer.Received() {
+		packetType, payload, err := s.control.decode(packet)
+		if err != nil {
+			s.log.Warn("invalid control packet", zap.Error(err))
+			continue
+		}
+
+		s.handleControl(packetType, payload)
+	}
+
+	switch err := peer.Err(); {
+	case err == nil:
+	case errors.Is(err, errENetDisconnected):
+		s.terminate(moonlight.ML_ERROR_GRACEFUL_TERMINATION)
+	default:
+		s.log.Error("control stream failed", zap.Error(err))
+
+		// moonlight-common-c reports socket failures as negative codes
+		// of its own.
+		s.terminate(-1)
+	}
+}
+
+func (s *nativeSession) handleControl(packetType uint16, payload []byte) {
+	le := binary.LittleEndian
+
+	switch packetType {
+	case controlTypeTermination:
+		errorCode := moonlight.ML_ERROR_GRACEFUL_TERMINATION
+		if len(payload) >= 4 {
+			if code := binary.BigEndian.Uint32(payload); code != terminationServerClosed {
+				errorCode = int(int32(code))
+			}
+		}
+
+		s.terminate(errorCode)
+
+	case controlTypeRumble:
+		if len(payload) >= 10 {
+			s.listener.Rumble(le.Uint16(payload[4:6]), le.Uint16(payload[6:8]), le.Uint16(payload[8:10]))
+		}
+
+	case controlTypeHDRMode:
+		if len(payload) >= 1 {
+			enabled := payload[0] != 0
+
+			var metadata *moonlight.HDRMetadata
+			if enabled {
+				metadata = parseHDRMetadata(payload[1:])
+			}
+
+			s.listener.SetHDRMode(enabled, metadata)
+		}
+
+	case controlTypeRumbleTriggers:
+		if len(payload) >= 6 {
+			s.listener.RumbleTriggers(le.Uint16(payload[0:2]), le.Uint16(payload[2:4]), le.Uint16(payload[4:6]))
+		}
+
+	case controlTypeMotionEvent:
+		if len(payload) >= 5 {
+			s.listener.SetMotionEventState(le.Uint16(payload[0:2]), payload[4], le.Uint16(payload[2:4]))
+		}
+
+	case controlTypeControllerLED:
+		if len(payload) >= 5 {
+			s.listener.SetControllerLED(le.Uint16(payload[0:2]), payload[2], payload[3], payload[4])
+		}
+	}
+}
+
+// hdrMetadataSize is the size of Sunshine's HDR metadata: the display
+// primaries and white point, then five light levels, all 16-bit.
+const hdrMetadataSize = 26
+
+// parseHDRMetadata parses the HDR metadata following the mode, nil for
+// hosts that send none.
+func parseHDRMetadata(b []byte) *moonlight.HDRMetadata {
+	if len(b) < hdrMetadataSize {
+		return nil
+	}
+
+	le := binary.LittleEndian
+
+	chromaticity := func(b []byte) moonlight.Chromaticity {
+		return moonlight.Chromaticity{X: le.Uint16(b[0:2]), Y: le.Uint16(b[2:4])}
+	}
+
+	metadata := &moonlight.HDRMetadata{
+		WhitePoint:                chromaticity(b[12:16]),
+		MaxDisplayLuminance:       le.Uint16(b[16:18]),
+		MinDisplayLuminance:       le.Uint16(b[18:20]),
+		MaxContentLightLevel:      le.Uint16(b[20:22]),
+		MaxFrameAverageLightLevel: le.Uint16(b[22:24]),
+		MaxFullFrameLuminance:     le.Uint16(b[24:26]),
+	}
+
+	for i := range metadata.DisplayPrimaries {
+		metadata.DisplayPrimaries[i] = chromaticity(b[4*i:])
+	}
+
+	return metadata
+}
+
+func (s *nativeSession) videoLoop() {
+	defer s.wg.Done()
+
+	d := newVideoDepacketizer()
+	buf := make([]byte, 4096)
+
+	// Frames decode from a keyframe on, so after a loss the next is
+	// awaited.
+	waitIDR := true
+
+	for {
+		s.videoConn.SetReadDeadline(time.Now().Add(videoTimeout))
+
+		n, addr, err := s.videoConn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				s.terminate(moonlight.ML_ERROR_NO_VIDEO_TRAFFIC)
+			}
+
+			return
+		}
+
+		if !addr.IP.Equal(s.hostIP) {
+			continue
+		}
+
+		frame, lost, err := d.push(buf[:n], time.Now())
+		if err != nil {
+			s.log.Debug("video packet dropped", zap.Error(err))
+		}
+
+		if lost {
+			waitIDR = true
+			s.requestIDR()
+		}
+
+		if frame == nil {
+			continue
+		}
+
+		unit, err := frame.decodeUnit(s.start)
+		if err != nil {
+			s.log.Warn("video frame dropped", zap.Error(err))
+			waitIDR = true
+			s.requestIDR()
+			continue
+		}
+
+		if waitIDR && unit.FrameType != int(moonlight.FRAME_TYPE_IDR) {
+			continue
+		}
+
+		waitIDR = false
+
+		if s.video.SubmitDecodeUnit(unit) == moonlight.DR_NEED_IDR {
+			waitIDR = true
+			s.requestIDR()
+		}
+	}
+}
+
+const (
+	audioPayloadTypeOpus = 97
+)
+
+func (s *nativeSession) audioLoop() {
+	defer s.wg.Done()
+
+	buf := make([]byte, 2048)
+
+	var last uint16
+	started := false
+
+	for {
+		n, addr, err := s.audioConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if !addr.IP.Equal(s.hostIP) || n <= rtpHeaderSize {
+			continue
+		}
+
+		// FEC packets are of another type; lost audio is not recovered.
+		if buf[1]&0x7F != audioPayloadTypeOpus {
+			continue
+		}
+
+		sequence := binary.BigEndian.Uint16(buf[2:4])
+		if started && int16(sequence-last) <= 0 {
+			continue
+		}
+
+		var sample []byte
+		if s.audioCipher != nil {
+			sample, err = s.audioCipher.decrypt(sequence, buf[rtpHeaderSize:n])
+			if err != nil {
+				s.log.Debug("audio packet dropped",
+					zap.Uint16("sequence", sequence),
+					zap.Error(err))
+				continue
+			}
+		} else {
+			sample = bytes.Clone(buf[rtpHeaderSize:n])
+		}
+
+		last = sequence
+		started = true
+
+		s.audio.PlayEncodedSample(sample, len(sample))
+	}
+}
+
+// audioCipher decrypts encrypted audio: each Opus packet is sealed with
+// AES-128-CBC and PKCS #7 padding under the remote input key, its IV the
+// key ID plus the RTP sequence number, big-endian, then zeros.
+type audioCipher struct {
+	block cipher.Block
+	keyID uint32
+}
+
+func newAudioCipher(ri *moonlight.RemoteInputAES) (*audioCipher, error) {
+	block, err := aes.NewCipher(ri.Key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &audioCipher{
+		block: block,
+		keyID: uint32(ri.KeyID()),
+	}, nil
+}
+
+func (c *audioCipher) decrypt(sequence uint16, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("audio ciphertext not in whole blocks")
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv, c.keyID+uint32(sequence))
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(c.block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("invalid audio padding")
+	}
+
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("invalid audio padding")
+		}
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// opusConfiguration returns the Opus layout of the audio configuration:
+// the host's from the surround-params it advertised, or moonlight's
+// defaults.
+func opusConfiguration(audio moonlight.AudioConfiguration, desc *ServerDescription) *moonlight.OpusMultiStreamConfiguration {
+	cfg := &moonlight.OpusMultiStreamConfiguration{
+		SampleRate:      48000,
+		ChannelCount:    audio.ChannelCount,
+		SamplesPerFrame: 48 * 5, // 5 ms packets
+	}
+
+	if desc != nil {
+		for _, params := range desc.SurroundParams {
+			if parseSurroundParams(params, cfg) {
+				return cfg
+			}
+		}
+	}
+
+	switch audio.ChannelCount {
+	case 6:
+		cfg.Streams, cfg.CoupledStreams = 4, 2
+		copy(cfg.Mapping[:], []byte{0, 4, 1, 5, 2, 3})
+	case 8:
+		cfg.Streams, cfg.CoupledStreams = 5, 3
+		copy(cfg.Mapping[:], []byte{0, 6, 1, 7, 2, 3, 4, 5})
+	default:
+		cfg.Streams, cfg.CoupledStreams = 1, 1
+		copy(cfg.Mapping[:], []byte{0, 1})
+	}
+
+	return cfg
+}
+
+// parseSurroundParams fills cfg from surround params of its channel
+// count: digits of the channel count, streams, coupled streams and the
+// mapping of each channel.
+func parseSurroundParams(params string, cfg *moonlight.OpusMultiStreamConfiguration) bool {
+	if len(params) < 3 {
+		return false
+	}
+
+	digits := make([]byte, len(params))
+	for i := range params {
+		if params[i] < '0' || params[i] > '9' {
+			return false
+		}
+
+		digits[i] = params[i] - '0'
+	}
+
+	channels := int(digits[0])
+	if channels != cfg.ChannelCount || len(digits) != 3+channels {
+		return false
+	}
+
+	cfg.Streams = int(digits[1])
+	cfg.CoupledStreams = int(digits[2])
+	copy(cfg.Mapping[:], digits[3:])
+
+	return true
+}
+
+// controlStream encrypts the control stream with AES-GCM, keyed by the
+// remote input key.
+type controlStream struct {
+	peer     *enetPeer
+	aead     cipher.AEAD
+	v2       bool // Sunshine's IVs, else the legacy ones
+	sequence uint32
+	sync.Mutex
+}
+
+func newControlStream(key []byte, v2 bool) (*controlStream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := 16
+	if v2 {
+		nonceSize = 12
+	}
+
+	aead, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &controlStream{
+		aead: aead,
+		v2:   v2,
+	}, nil
+}
+
+// iv returns the IV of a packet: its sequence number and, with v2, who
+// sent it.
+func (c *controlStream) iv(sequence uint32, fromHost bool) []byte {
+	if !c.v2 {
+		iv := make([]byte, 16)
+		iv[0] = byte(sequence)
+		return iv
+	}
+
+	iv := make([]byte, 12)
+	binary.LittleEndian.PutUint32(iv[0:4], sequence)
+
+	iv[10] = 'C'
+	if fromHost {
+		iv[10] = 'H'
+	}
+
+	iv[11] = 'C'
+
+	return iv
+}
+
+// seal encrypts a packet: the encrypted type, length and sequence number,
+// the tag, then the packet's type, length and payload encrypted.
+func (c *controlStream) seal(packetType uint16, payload []byte) []byte {
+	plaintext := make([]byte, 4, 4+len(payload))
+	binary.LittleEndian.PutUint16(plaintext[0:2], packetType)
+	binary.LittleEndian.PutUint16(plaintext[2:4], uint16(len(payload)))
+	plaintext = append(plaintext, payload...)
+
+	sequence := c.sequence
+	c.sequence++
+
+	sealed := c.aead.Seal(nil, c.iv(sequence, false), plaintext, nil)
+	ciphertext, tag := sealed[:len(plaintext)], sealed[len(plaintext):]
+
+	packet := make([]byte, 8, 8+len(sealed))
+	binary.LittleEndian.PutUint16(packet[0:2], controlTypeEncrypted)
+	binary.LittleEndian.PutUint16(packet[2:4], uint16(4+len(sealed)))
+	binary.LittleEndian.PutUint32(packet[4:8], sequence)
+	packet = append(packet, tag...)
+	packet = append(packet, ciphertext...)
+
+	return packet
+}
+
+func (c *controlStream) send(packetType uint16, payload []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.peer == nil {
+		return errors.New("control stream not connected")
+	}
+
+	// Sent under the lock, in the order of the sequence numbers.
+	return c.peer.Send(0, c.seal(packetType, payload))
+}
+
+// decode returns the type and payload of a packet from the host.
+func (c *controlStream) decode(packet []byte) (uint16, []byte, error) {
+	if len(packet) < 2 {
+		return 0, nil, errors.New("short control packet")
+	}
+
+	packetType := binary.LittleEndian.Uint16(packet[0:2])
+	if packetType != controlTypeEncrypted {
+		return packetType, packet[2:], nil
+	}
+
+	if len(packet) < 8 {
+		return 0, nil, errors.New("short control packet")
+	}
+
+	length := int(binary.LittleEndian.Uint16(packet[2:4]))
+	sequence := binary.LittleEndian.Uint32(packet[4:8])
+
+	if length < 4+c.aead.Overhead() || len(packet) < 4+length {
+		return 0, nil, errors.New("short control packet")
+	}
+
+	tag := packet[8 : 8+c.aead.Overhead()]
+	ciphertext := packet[8+c.aead.Overhead() : 4+length]
+
+	sealed := append(bytes.Clone(ciphertext), tag...)
+
+	plaintext, err := c.aead.Open(nil, c.iv(sequence, true), sealed, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(plaintext) < 4 {
+		return 0, nil, errors.New("short control packet")
+	}
+
+	packetType = binary.LittleEndian.Uint16(plaintext[0:2])
+	size := int(binary.LittleEndian.Uint16(plaintext[2:4]))
+
+	if len(plaintext) < 4+size {
+		return 0, nil, errors.New("short control packet")
+	}
+
+	return packetType, plaintext[4 : 4+size], nil
+}
@@ -0,0 +1,10 @@
+//go:build purego || !cgo
+
+package nvstream
+
+import "github.com/flarexio/game/thirdparty/moonlight"
+
+// Without moonlight-common-c, connections run natively.
+func init() {
+	moonlight.RegisterEngine(nativeEngine{})
+}
@@ -0,0 +1,172 @@
+package nvstream
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+func TestControlStream(t *testing.T) {
+	assert := assert.New(t)
+
+	key := make([]byte, 16)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	c, err := newControlStream(key, true)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	packet := c.seal(controlTypeIDRFrame, []byte{0, 0})
+
+	le := binary.LittleEndian
+	assert.Equal(controlTypeEncrypted, le.Uint16(packet[0:2]))
+	assert.Equal(len(packet)-4, int(le.Uint16(packet[2:4])))
+	assert.Equal(uint32(0), le.Uint32(packet[4:8]))
+
+	// The host opens it with the client's IV.
+	tag, ciphertext := packet[8:24], packet[24:]
+
+	plaintext, err := c.aead.Open(nil, c.iv(0, false), append(append([]byte(nil), ciphertext...), tag...), nil)
+	if assert.NoError(err) {
+		assert.Equal([]byte{0x02, 0x03, 2, 0, 0, 0}, plaintext)
+	}
+
+	assert.Equal(uint32(1), le.Uint32(c.seal(controlTypeIDRFrame, nil)[4:8]))
+
+	// The host seals with its own.
+	sealed := c.aead.Seal(nil, c.iv(5, true), []byte{0x0e, 0x01, 1, 0, 1}, nil)
+
+	host := make([]byte, 8)
+	le.PutUint16(host[0:2], controlTypeEncrypted)
+	le.PutUint16(host[2:4], uint16(4+len(sealed)))
+	le.PutUint32(host[4:8], 5)
+	host = append(host, sealed[len(sealed)-16:]...)
+	host = append(host, sealed[:len(sealed)-16]...)
+
+	packetType, payload, err := c.decode(host)
+	if assert.NoError(err) {
+		assert.Equal(controlTypeHDRMode, packetType)
+		assert.Equal([]byte{1}, payload)
+	}
+
+	_, _, err = c.decode(packet)
+	assert.Error(err)
+
+	_, _, err = c.decode(host[:20])
+	assert.Error(err)
+}
+
+func TestOpusConfiguration(t *testing.T) {
+	assert := assert.New(t)
+
+	stereo := moonlight.AudioConfiguration{ChannelCount: 2, ChannelMask: 0x3}
+
+	cfg := opusConfiguration(stereo, nil)
+	assert.Equal(48000, cfg.SampleRate)
+	assert.Equal(240, cfg.SamplesPerFrame)
+	assert.Equal(1, cfg.Streams)
+	assert.Equal(1, cfg.CoupledStreams)
+	assert.Equal([]byte{0, 1}, cfg.Mapping[:2])
+
+	desc := ParseServerDescription([]byte(testServerSDP))
+
+	surround := moonlight.AudioConfiguration{ChannelCount: 6, ChannelMask: 0x3F}
+
+	cfg = opusConfiguration(surround, desc)
+	assert.Equal(4, cfg.Streams)
+	assert.Equal(2, cfg.CoupledStreams)
+	assert.Equal([]byte{0, 1, 4, 5, 2, 3}, cfg.Mapping[:6])
+
+	cfg = opusConfiguration(surround, nil)
+	assert.Equal([]byte{0, 4, 1, 5, 2, 3}, cfg.Mapping[:6])
+}
+
+func TestPingPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]byte("PING"), pingPacket("", 1))
+
+	ping := pingPacket("0123456789abcdef", 2)
+	assert.Equal([]byte("0123456789abcdef\x00\x00\x00\x02"), ping)
+}
+
+func TestParseHDRMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	le := binary.LittleEndian
+
+	b := make([]byte, hdrMetadataSize)
+	for i, v := range []uint16{
+		34000, 16000, 13250, 34500, 7500, 3000, // BT.2020 primaries
+		15635, 16450, // D65
+		1000, 50, 800, 400, 0,
+	} {
+		le.PutUint16(b[2*i:], v)
+	}
+
+	metadata := parseHDRMetadata(b)
+	if !assert.NotNil(metadata) {
+		return
+	}
+
+	assert.Equal(moonlight.Chromaticity{X: 34000, Y: 16000}, metadata.DisplayPrimaries[0])
+	assert.Equal(moonlight.Chromaticity{X: 7500, Y: 3000}, metadata.DisplayPrimaries[2])
+	assert.Equal(moonlight.Chromaticity{X: 15635, Y: 16450}, metadata.WhitePoint)
+	assert.Equal(uint16(1000), metadata.MaxDisplayLuminance)
+	assert.Equal(uint16(50), metadata.MinDisplayLuminance)
+	assert.Equal(uint16(800), metadata.MaxContentLightLevel)
+	assert.Equal(uint16(400), metadata.MaxFrameAverageLightLevel)
+
+	assert.Nil(parseHDRMetadata(b[:10]))
+}
+
+func TestAudioCipher(t *testing.T) {
+	assert := assert.New(t)
+
+	ri := new(moonlight.RemoteInputAES)
+	for i := range ri.Key {
+		ri.Key[i] = byte(i)
+	}
+	binary.BigEndian.PutUint32(ri.IV[:4], 0xFFFFFFF0)
+
+	c, err := newAudioCipher(ri)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// The host pads and seals with the key ID plus the sequence number,
+	// wrapping.
+	opus := []byte("an opus packet")
+	padded := append(bytes.Clone(opus), 2, 2)
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv, 0x00000010)
+
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(c.block, iv).CryptBlocks(ciphertext, padded)
+
+	sample, err := c.decrypt(0x20, ciphertext)
+	if assert.NoError(err) {
+		assert.Equal(opus, sample)
+	}
+
+	// Not padded.
+	cipher.NewCBCEncrypter(c.block, iv).CryptBlocks(ciphertext, append(bytes.Clone(opus), 2, 0))
+
+	_, err = c.decrypt(0x20, ciphertext)
+	assert.Error(err)
+
+	_, err = c.decrypt(0x20, ciphertext[:10])
+	assert.Error(err)
+}
@@ -0,0 +1,404 @@
+package nvstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// The host sends a frame as RTP packets, each an NV video header and a
+// piece of the frame, in up to four FEC blocks of data shards and parity
+// shards. The parity covers the packets with their headers zeroed, as the
+// host fills those in after encoding.
+
+const (
+	rtpHeaderSize     = 12
+	rtpFlagExtension  = 0x10
+	nvVideoHeaderSize = 16
+
+	// maxPendingFrames bounds the frames being received at once; older
+	// ones are given up.
+	maxPendingFrames = 2
+)
+
+var errShortVideoPacket = errors.New("short video packet")
+
+// videoPacket is a parsed RTP video packet.
+type videoPacket struct {
+	timestamp  uint32
+	headerSize int // RTP and NV headers
+
+	frameIndex   uint32
+	block        int
+	lastBlock    int
+	dataShards   int
+	shardIndex   int
+	fecPercent   int
+	parityShards int
+
+	data []byte // the whole packet
+}
+
+func parseVideoPacket(b []byte) (*videoPacket, error) {
+	if len(b) < rtpHeaderSize {
+		return nil, errShortVideoPacket
+	}
+
+	offset := rtpHeaderSize
+	if b[0]&rtpFlagExtension != 0 {
+		offset += 4
+	}
+
+	if len(b) < offset+nvVideoHeaderSize {
+		return nil, errShortVideoPacket
+	}
+
+	nv := b[offset : offset+nvVideoHeaderSize]
+
+	multiFecBlocks := nv[11]
+	fecInfo := binary.LittleEndian.Uint32(nv[12:16])
+
+	p := &videoPacket{
+		timestamp:  binary.BigEndian.Uint32(b[4:8]),
+		headerSize: offset + nvVideoHeaderSize,
+		frameIndex: binary.LittleEndian.Uint32(nv[4:8]),
+		block:      int(multiFecBlocks>>4) & 0x3,
+		lastBlock:  int(multiFecBlocks>>6) & 0x3,
+		dataShards: int(fecInfo>>22) & 0x3FF,
+		shardIndex: int(fecInfo>>12) & 0x3FF,
+		fecPercent: int(fecInfo>>4) & 0xFF,
+		data:       b,
+	}
+
+	p.parityShards = (p.dataShards*p.fecPercent + 99) / 100
+
+	if p.dataShards == 0 || p.shardIndex >= p.dataShards+p.parityShards {
+		return nil, errors.New("invalid video fec info")
+	}
+
+	return p, nil
+}
+
+// fecBlock collects the shards of a block until its data shards are known.
+type fecBlock struct {
+	dataShards   int
+	parityShards int
+	shards       [][]byte
+	received     int
+	payloads     [][]byte // the data shards' payloads, once complete
+}
+
+func (b *fecBlock) add(p *videoPacket) error {
+	if b.complete() {
+		return nil
+	}
+
+	if b.shards == nil {
+		b.dataShards = p.dataShards
+		b.parityShards = p.parityShards
+		b.shards = make([][]byte, p.dataShards+p.parityShards)
+	}
+
+	if p.dataShards != b.dataShards || b.shards[p.shardIndex] != nil {
+		return nil
+	}
+
+	for _, shard := range b.shards {
+		if shard != nil && len(shard) != len(p.data) {
+			return errors.New("video shards of different sizes")
+		}
+	}
+
+	shard := bytes.Clone(p.data)
+	clear(shard[:p.headerSize])
+
+	b.shards[p.shardIndex] = shard
+	b.received++
+
+	if b.received < b.dataShards {
+		return nil
+	}
+
+	rs, err := newReedSolomon(b.dataShards, b.parityShards)
+	if err != nil {
+		return err
+	}
+
+	if err := rs.reconstruct(b.shards); err != nil {
+		return err
+	}
+
+	b.payloads = make([][]byte, b.dataShards)
+	for i := range b.payloads {
+		b.payloads[i] = b.shards[i][p.headerSize:]
+	}
+
+	b.shards = nil
+
+	return nil
+}
+
+func (b *fecBlock) complete() bool {
+	return b.payloads != nil
+}
+
+type pendingFrame struct {
+	index       uint32
+	timestamp   uint32
+	lastBlock   int
+	blocks      [4]fecBlock
+	payloadSize int
+	received    time.Time // of the first packet
+}
+
+// videoFrame is a frame received whole, with its short frame header.
+type videoFrame struct {
+	index       uint32
+	timestamp   uint32 // RTP, in 90 kHz
+	received    time.Time
+	payloadSize int // of a packet
+	data        []byte
+}
+
+// videoDepacketizer assembles frames from video packets, recovering lost
+// packets by FEC and giving up frames that cannot be.
+type videoDepacketizer struct {
+	frames    map[uint32]*pendingFrame
+	lastFrame uint32 // the last frame completed or given up
+	started   bool
+}
+
+func newVideoDepacketizer() *videoDepacketizer {
+	return &videoDepacketizer{
+		frames: make(map[uint32]*pendingFrame),
+	}
+}
+
+// push adds a packet, returning the frame it completed, if any, and
+// whether frames were lost since the last one returned.
+func (d *videoDepacketizer) push(b []byte, now time.Time) (*videoFrame, bool, error) {
+	p, err := parseVideoPacket(b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if d.started && int32(p.frameIndex-d.lastFrame) <= 0 {
+		return nil, false, nil
+	}
+
+	lost := false
+
+	// Frames this far behind will not be completed.
+	for index := range d.frames {
+		if int32(p.frameIndex-index) >= maxPendingFrames {
+			delete(d.frames, index)
+			d.skip(index)
+			lost = true
+		}
+	}
+
+	frame, ok := d.frames[p.frameIndex]
+	if !ok {
+		frame = &pendingFrame{
+			index:       p.frameIndex,
+			timestamp:   p.timestamp,
+			lastBlock:   p.lastBlock,
+			payloadSize: len(p.data) - p.headerSize,
+			received:    now,
+		}
+
+		d.frames[p.frameIndex] = frame
+	}
+
+	if err := frame.blocks[p.block].add(p); err != nil {
+		delete(d.frames, p.frameIndex)
+		d.skip(p.frameIndex)
+		return nil, true, err
+	}
+
+	for i := 0; i <= frame.lastBlock; i++ {
+		if !frame.blocks[i].complete() {
+			return nil, lost, nil
+		}
+	}
+
+	delete(d.frames, p.frameIndex)
+
+	// The frames before it are given up.
+	for index := range d.frames {
+		if int32(p.frameIndex-index) > 0 {
+			delete(d.frames, index)
+			lost = true
+		}
+	}
+
+	if d.started && p.frameIndex != d.lastFrame+1 {
+		lost = true
+	}
+
+	d.lastFrame = p.frameIndex
+	d.started = true
+
+	var data []byte
+	for i := 0; i <= frame.lastBlock; i++ {
+		for _, payload := range frame.blocks[i].payloads {
+			data = append(data, payload...)
+		}
+	}
+
+	return &videoFrame{
+		index:       frame.index,
+		timestamp:   frame.timestamp,
+		received:    frame.received,
+		payloadSize: frame.payloadSize,
+		data:        data,
+	}, lost, nil
+}
+
+func (d *videoDepacketizer) skip(index uint32) {
+	if !d.started || int32(index-d.lastFrame) > 0 {
+		d.lastFrame = index
+		d.started = true
+	}
+}
+
+const (
+	nvShortFrameHeaderSize = 8
+	nvFrameTypeIDR         = 2
+)
+
+// frameHeader is the short frame header starting the data of a frame.
+type frameHeader struct {
+	latency        uint16 // host processing, in 0.1 ms
+	frameType      byte
+	lastPayloadLen int // of the last packet, 0 if padded
+}
+
+func parseFrameHeader(data []byte) (*frameHeader, error) {
+	if len(data) < nvShortFrameHeaderSize || data[0] != 0x01 {
+		return nil, errors.New("unsupported frame header")
+	}
+
+	return &frameHeader{
+		latency:        binary.LittleEndian.Uint16(data[1:3]),
+		frameType:      data[3],
+		lastPayloadLen: int(binary.LittleEndian.Uint16(data[4:6])),
+	}, nil
+}
+
+// decodeUnit turns an H.264 frame into a decode unit: its parameter sets
+// each in a buffer, then the picture data.
+func (f *videoFrame) decodeUnit(start time.Time) (*moonlight.DecodeUnit, error) {
+	header, err := parseFrameHeader(f.data)
+	if err != nil {
+		return nil, err
+	}
+
+	data := f.data
+
+	// The last packet is padded to the size of the others.
+	if n := header.lastPayloadLen; n > 0 && n <= f.payloadSize && len(data) >= f.payloadSize {
+		data = data[:len(data)-f.payloadSize+n]
+	} else {
+		data = bytes.TrimRight(data, "\x00")
+	}
+
+	data = data[nvShortFrameHeaderSize:]
+
+	unit := &moonlight.DecodeUnit{
+		FrameNumber:                int(f.index),
+		FrameType:                  int(moonlight.FRAME_TYPE_PFRAME),
+		FrameHostProcessingLatency: header.latency,
+		ReceiveTimeMs:              uint64(f.received.Sub(start).Milliseconds()),
+		EnqueueTimeMs:              uint64(time.Since(start).Milliseconds()),
+		PresentationTimeMs:         uint(f.timestamp / 90),
+		FullLength:                 len(data),
+	}
+
+	if header.frameType == nvFrameTypeIDR {
+		unit.FrameType = int(moonlight.FRAME_TYPE_IDR)
+	}
+
+	var head *moonlight.Lentry
+	next := &head
+
+	add := func(bufferType moonlight.BufferType, b []byte) {
+		entry := &moonlight.Lentry{
+			Data:       b,
+			Length:     len(b),
+			BufferType: int(bufferType),
+		}
+
+		*next = entry
+		next = &entry.Next
+	}
+
+	rest := data
+	for len(rest) > 0 {
+		nal, after := nextNAL(rest)
+
+		var bufferType moonlight.BufferType
+		switch nalType(nal) {
+		case 7:
+			bufferType = moonlight.BUFFER_TYPE_SPS
+		case 8:
+			bufferType = moonlight.BUFFER_TYPE_PPS
+		case 5:
+			unit.FrameType = int(moonlight.FRAME_TYPE_IDR)
+			fallthrough
+		default:
+			add(moonlight.BUFFER_TYPE_PICDATA, rest)
+			rest = nil
+			continue
+		}
+
+		add(bufferType, nal)
+		rest = after
+	}
+
+	unit.BufferList = head
+
+	return unit, nil
+}
+
+// nextNAL splits the first NAL unit, with its start code, from Annex B
+// data.
+func nextNAL(b []byte) (nal, rest []byte) {
+	start := startCodeLen(b)
+
+	i := bytes.Index(b[start:], []byte{0, 0, 1})
+	if i < 0 {
+		return b, nil
+	}
+
+	end := start + i
+	if end > start && b[end-1] == 0 {
+		end--
+	}
+
+	return b[:end], b[end:]
+}
+
+func startCodeLen(b []byte) int {
+	switch {
+	case bytes.HasPrefix(b, []byte{0, 0, 0, 1}):
+		return 4
+	case bytes.HasPrefix(b, []byte{0, 0, 1}):
+		return 3
+	default:
+		return 0
+	}
+}
+
+// nalType returns the type of an H.264 NAL unit, -1 without one.
+func nalType(nal []byte) int {
+	start := startCodeLen(nal)
+	if len(nal) <= start {
+		return -1
+	}
+
+	return int(nal[start] & 0x1F)
+}
@@ -0,0 +1,159 @@
+package nvstream
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// testVideoPackets packetizes a frame as the host does, in one FEC block of
+// payloadSize pieces with 50% parity.
+func testVideoPackets(t *testing.T, index uint32, frame []byte, payloadSize int) [][]byte {
+	dataShards := (len(frame) + payloadSize - 1) / payloadSize
+	parityShards := (dataShards*50 + 99) / 100
+
+	headerSize := rtpHeaderSize + nvVideoHeaderSize
+
+	shards := make([][]byte, dataShards+parityShards)
+	for i := range shards {
+		shards[i] = make([]byte, headerSize+payloadSize)
+		if i < dataShards {
+			copy(shards[i][headerSize:], frame[i*payloadSize:])
+		}
+	}
+
+	rs, err := newReedSolomon(dataShards, parityShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs.encode(shards)
+
+	for i, shard := range shards {
+		shard[0] = 0x80
+		shard[1] = 0x60
+		binary.BigEndian.PutUint32(shard[4:8], index*1500)
+
+		nv := shard[rtpHeaderSize:headerSize]
+		binary.LittleEndian.PutUint32(nv[4:8], index)
+		binary.LittleEndian.PutUint32(nv[12:16], uint32(dataShards)<<22|uint32(i)<<12|50<<4)
+	}
+
+	return shards
+}
+
+func testFrame(frameType byte, nals ...[]byte) []byte {
+	frame := []byte{0x01, 10, 0, frameType, 0, 0, 0, 0}
+	for _, nal := range nals {
+		frame = append(frame, nal...)
+	}
+
+	return frame
+}
+
+func TestVideoDepacketizer(t *testing.T) {
+	assert := assert.New(t)
+
+	sps := []byte{0, 0, 0, 1, 0x67, 1, 2, 3}
+	pps := []byte{0, 0, 0, 1, 0x68, 4, 5}
+	idr := append([]byte{0, 0, 0, 1, 0x65}, make([]byte, 100)...)
+	for i := range idr[5:] {
+		idr[5+i] = byte(i + 1)
+	}
+
+	frame := testFrame(nvFrameTypeIDR, sps, pps, idr)
+	packets := testVideoPackets(t, 1, frame, 32)
+
+	d := newVideoDepacketizer()
+	start := time.Now()
+
+	// Two data packets lost are recovered from the parity.
+	var got *videoFrame
+	for i, packet := range packets {
+		if i == 0 || i == 2 {
+			continue
+		}
+
+		f, lost, err := d.push(packet, start)
+		assert.NoError(err)
+		assert.False(lost)
+
+		if f != nil {
+			got = f
+			break
+		}
+	}
+
+	if !assert.NotNil(got) {
+		return
+	}
+
+	unit, err := got.decodeUnit(start)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(1, unit.FrameNumber)
+	assert.Equal(int(moonlight.FRAME_TYPE_IDR), unit.FrameType)
+	assert.Equal(uint16(10), unit.FrameHostProcessingLatency)
+	assert.Equal(len(sps)+len(pps)+len(idr), unit.FullLength)
+
+	entry := unit.BufferList
+	if assert.NotNil(entry) {
+		assert.Equal(int(moonlight.BUFFER_TYPE_SPS), entry.BufferType)
+		assert.Equal(sps, entry.Data)
+		entry = entry.Next
+	}
+
+	if assert.NotNil(entry) {
+		assert.Equal(int(moonlight.BUFFER_TYPE_PPS), entry.BufferType)
+		assert.Equal(pps, entry.Data)
+		entry = entry.Next
+	}
+
+	if assert.NotNil(entry) {
+		assert.Equal(int(moonlight.BUFFER_TYPE_PICDATA), entry.BufferType)
+		assert.Equal(idr, entry.Data)
+		assert.Nil(entry.Next)
+	}
+
+	// Late packets of a returned frame are ignored.
+	f, lost, err := d.push(packets[0], start)
+	assert.Nil(f)
+	assert.False(lost)
+	assert.NoError(err)
+
+	// A frame that cannot be recovered is reported lost once a later one
+	// completes.
+	pframe := testFrame(1, append([]byte{0, 0, 0, 1, 0x41}, make([]byte, 60)...))
+
+	for _, packet := range testVideoPackets(t, 2, pframe, 32)[:1] {
+		d.push(packet, start)
+	}
+
+	var completed *videoFrame
+	anyLost := false
+	for _, packet := range testVideoPackets(t, 3, pframe, 32) {
+		f, lost, err := d.push(packet, start)
+		assert.NoError(err)
+
+		anyLost = anyLost || lost
+		if f != nil {
+			completed = f
+			break
+		}
+	}
+
+	if assert.NotNil(completed) {
+		assert.Equal(uint32(3), completed.index)
+	}
+
+	assert.True(anyLost)
+
+	_, _, err = d.push([]byte{0x80, 0x60}, start)
+	assert.ErrorIs(err, errShortVideoPacket)
+}
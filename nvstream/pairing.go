@@ -24,13 +24,63 @@ const (
 	PairStateAlreadyInProgress
 )
 
+func (s PairState) String() string {
+	switch s {
+	case PairStateNotPaired:
+		return "not_paired"
+	case PairStatePaired:
+		return "paired"
+	case PairStatePinWrong:
+		return "pin_wrong"
+	case PairStateFailed:
+		return "failed"
+	case PairStateAlreadyInProgress:
+		return "already_in_progress"
+	default:
+		return "unknown"
+	}
+}
+
+func (s PairState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 var (
 	ErrPairingFailed     = errors.New("pairing failed")
 	ErrPairingInProgress = errors.New("pairing already in progress")
+	ErrPairingPinWrong   = errors.New("incorrect pin")
 )
 
+// PairStage is a step of the pairing handshake, reported as it starts.
+type PairStage int
+
+const (
+	PairStageSalt            PairStage = iota // salt sent, waiting for the PIN on the host
+	PairStageChallenge                        // client challenge sent
+	PairStageSecretExchange                   // secrets exchanged and signatures checked
+	PairStageChallengeVerify                  // paired certificate verified over HTTPS
+)
+
+func (s PairStage) String() string {
+	switch s {
+	case PairStageSalt:
+		return "salt"
+	case PairStageChallenge:
+		return "challenge"
+	case PairStageSecretExchange:
+		return "secret_exchange"
+	case PairStageChallengeVerify:
+		return "challenge_verify"
+	default:
+		return "unknown"
+	}
+}
+
 type PairingManager interface {
-	Pair(pin string) PairState
+	// Pair runs the pairing handshake with the PIN the user enters on the
+	// host. onProgress may be nil. The error explains a failed state; it is
+	// the context's error if ctx is done first.
+	Pair(ctx context.Context, pin string, onProgress func(stage PairStage)) (PairState, error)
 }
 
 type pairingManager struct {
@@ -43,13 +93,19 @@ func NewPairingManager(http NvHTTP) PairingManager {
 	}
 }
 
-func (pm *pairingManager) Pair(pin string) PairState {
+func (pm *pairingManager) Pair(ctx context.Context, pin string, onProgress func(stage PairStage)) (PairState, error) {
 	defer pm.http.Unpair()
 
+	progress := func(stage PairStage) {
+		if onProgress != nil {
+			onProgress(stage)
+		}
+	}
+
 	// Generate a salt for hashing the PIN
 	salt, err := generateRandomBytes(16)
 	if err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	// Combine the salt and pin, then create an AES key from them
@@ -57,60 +113,66 @@ func (pm *pairingManager) Pair(pin string) PairState {
 	aesKey := generateAESKey(saltedPin)
 
 	// Send the salt and get the server cert
-	serverCertPEM, err := pm.getServerCert(salt)
+	progress(PairStageSalt)
+
+	serverCertPEM, err := pm.getServerCert(ctx, salt)
 	if err != nil {
 		if errors.Is(err, ErrPairingInProgress) {
-			return PairStateAlreadyInProgress
+			return PairStateAlreadyInProgress, err
 		}
 
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	pm.http.SetServerCert(serverCertPEM)
 
 	// Generate a random challenge and encrypt it with our AES key
+	progress(PairStageChallenge)
+
 	randomChallenge, err := generateRandomBytes(16)
 	if err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	encryptedChallenge, err := encrypt(randomChallenge, aesKey)
 	if err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	// Send the encrypted challenge to the server
-	encryptedServerChallengeResponse, err := pm.sendClientChallenge(encryptedChallenge)
+	encryptedServerChallengeResponse, err := pm.sendClientChallenge(ctx, encryptedChallenge)
 	if err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	// Decode the server's response and subsequent challenge
 	serverChallengeResponse, err := decrypt(encryptedServerChallengeResponse, aesKey)
 	if err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	serverResponse := serverChallengeResponse[:sha256.Size]
 	serverChallenge := serverChallengeResponse[sha256.Size:48]
 
 	// Using another 16 bytes secret, compute a challenge response hash using the secret, our cert sig, and the challenge
+	progress(PairStageSecretExchange)
+
 	clientSecret, err := generateRandomBytes(16)
 	if err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	challengeRespHash := sha256.Sum256(append(append(serverChallenge, pm.http.ClientCert().Signature...), clientSecret...))
 
 	challengeRespEncrypted, err := encrypt(challengeRespHash[:], aesKey)
 	if err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	// Get the server's signed secret
-	serverSecretResp, err := pm.sendServerChallengeResponse(challengeRespEncrypted)
+	serverSecretResp, err := pm.sendServerChallengeResponse(ctx, challengeRespEncrypted)
 	if err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	serverSecret := serverSecretResp[:16]
@@ -118,34 +180,36 @@ func (pm *pairingManager) Pair(pin string) PairState {
 
 	// Ensure the authenticity of the data
 	if err := pm.verifyServerSecretSignature(serverSecret, serverSignature); err != nil {
-		return PairStateFailed
+		return PairStateFailed, err
 	}
 
 	// Ensure the server challenge matched what we expected (aka the PIN was correct)
 	serverChallengeHash := sha256.Sum256(append(append(randomChallenge, pm.http.ServerCert().Signature...), serverSecret...))
 	if !slices.Equal(serverChallengeHash[:], serverResponse) {
-		return PairStatePinWrong
+		return PairStatePinWrong, ErrPairingPinWrong
 	}
 
 	// Send the server our signed secret
-	if err := pm.sendClientSignedSecret(clientSecret); err != nil {
-		return PairStateFailed
+	if err := pm.sendClientSignedSecret(ctx, clientSecret); err != nil {
+		return PairStateFailed, err
 	}
 
-	if err := pm.pairingChallenge(); err != nil {
-		return PairStateFailed
+	progress(PairStageChallengeVerify)
+
+	if err := pm.pairingChallenge(ctx); err != nil {
+		return PairStateFailed, err
 	}
 
-	return PairStatePaired
+	return PairStatePaired, nil
 }
 
-func (pm *pairingManager) getServerCert(salt []byte) ([]byte, error) {
+func (pm *pairingManager) getServerCert(ctx context.Context, salt []byte) ([]byte, error) {
 	args := make(map[string]string)
 	args["phrase"] = "getservercert"
 	args["salt"] = hex.EncodeToString(salt)
 	args["clientcert"] = hex.EncodeToString(pm.http.CertPEM())
 
-	ctx := context.Background()
+	// No timeout: the host waits for the user to enter the PIN.
 	resp, err := pm.http.ExecutePairingCommand(ctx, args)
 	if err != nil {
 		return nil, err
@@ -167,11 +231,10 @@ func (pm *pairingManager) getServerCert(salt []byte) ([]byte, error) {
 	return certBytes, nil
 }
 
-func (pm *pairingManager) sendClientChallenge(challenge []byte) ([]byte, error) {
+func (pm *pairingManager) sendClientChallenge(ctx context.Context, challenge []byte) ([]byte, error) {
 	args := make(map[string]string)
 	args["clientchallenge"] = hex.EncodeToString(challenge)
 
-	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 5000*time.Millisecond)
 	defer cancel()
 
@@ -191,11 +254,10 @@ func (pm *pairingManager) sendClientChallenge(challenge []byte) ([]byte, error)
 	return hex.DecodeString(resp.ServerChallengeResponse)
 }
 
-func (pm *pairingManager) sendServerChallengeResponse(response []byte) ([]byte, error) {
+func (pm *pairingManager) sendServerChallengeResponse(ctx context.Context, response []byte) ([]byte, error) {
 	args := make(map[string]string)
 	args["serverchallengeresp"] = hex.EncodeToString(response)
 
-	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 5000*time.Millisecond)
 	defer cancel()
 
@@ -226,7 +288,7 @@ func (pm *pairingManager) verifyServerSecretSignature(serverSecret, signature []
 	return nil
 }
 
-func (pm *pairingManager) sendClientSignedSecret(clientSecret []byte) error {
+func (pm *pairingManager) sendClientSignedSecret(ctx context.Context, clientSecret []byte) error {
 	signature, err := pm.http.Sign(clientSecret)
 	if err != nil {
 		return err
@@ -237,7 +299,6 @@ func (pm *pairingManager) sendClientSignedSecret(clientSecret []byte) error {
 	args := make(map[string]string)
 	args["clientpairingsecret"] = hex.EncodeToString(clientPairingSecret)
 
-	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 5000*time.Millisecond)
 	defer cancel()
 
@@ -253,8 +314,7 @@ func (pm *pairingManager) sendClientSignedSecret(clientSecret []byte) error {
 	return nil
 }
 
-func (pm *pairingManager) pairingChallenge() error {
-	ctx := context.Background()
+func (pm *pairingManager) pairingChallenge(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 5000*time.Millisecond)
 	defer cancel()
 
@@ -0,0 +1,58 @@
+package nvstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitingHTTP never answers getservercert, like a host waiting for the PIN.
+type waitingHTTP struct {
+	NvHTTP
+	unpaired bool
+}
+
+func (h *waitingHTTP) CertPEM() []byte {
+	return nil
+}
+
+func (h *waitingHTTP) ExecutePairingCommand(ctx context.Context, args map[string]string) (*PairResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (h *waitingHTTP) Unpair() error {
+	h.unpaired = true
+	return nil
+}
+
+func TestPairCanceled(t *testing.T) {
+	assert := assert.New(t)
+
+	http := &waitingHTTP{}
+	pm := NewPairingManager(http)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var stages []PairStage
+	state, err := pm.Pair(ctx, "1234", func(stage PairStage) {
+		stages = append(stages, stage)
+	})
+
+	assert.Equal(PairStateFailed, state)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal([]PairStage{PairStageSalt}, stages)
+	assert.True(http.unpaired)
+}
+
+func TestPairStateText(t *testing.T) {
+	assert := assert.New(t)
+
+	text, err := PairStatePinWrong.MarshalText()
+	assert.NoError(err)
+	assert.Equal("pin_wrong", string(text))
+	assert.Equal("secret_exchange", PairStageSecretExchange.String())
+}
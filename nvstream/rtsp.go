@@ -0,0 +1,544 @@
+package nvstream
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/telemetry"
+)
+
+const (
+	DEFAULT_RTSP_PORT    int = 48010
+	DEFAULT_VIDEO_PORT   int = 47998
+	DEFAULT_CONTROL_PORT int = 47999
+	DEFAULT_AUDIO_PORT   int = 48000
+)
+
+type RTSPRequest struct {
+	Method  string
+	Target  string
+	Headers map[string]string
+	Body    []byte
+}
+
+type RTSPResponse struct {
+	StatusCode int
+	Status     string
+	Headers    textproto.MIMEHeader
+	Body       []byte
+}
+
+// NewRTSPClient creates a client for the session URL returned by /launch or
+// /resume. In persistent mode every request rides the same TCP connection,
+// as Sunshine supports; otherwise a connection is opened per request, which
+// is what GeForce Experience expects.
+func NewRTSPClient(sessionURL string, clientVersion int, persistent bool) (*RTSPClient, error) {
+	u, err := url.Parse(sessionURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "rtsp" && u.Scheme != "rtspenc" {
+		return nil, errors.New("invalid rtsp session url: " + sessionURL)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), strconv.Itoa(DEFAULT_RTSP_PORT))
+	}
+
+	return &RTSPClient{
+		log: zap.L().With(
+			zap.String("component", "nvstream.rtsp"),
+			zap.String("host", host),
+		),
+		host:          host,
+		target:        "rtsp://" + host,
+		clientVersion: clientVersion,
+		persistent:    persistent,
+		timeout:       10 * time.Second,
+	}, nil
+}
+
+type RTSPClient struct {
+	log           *zap.Logger
+	host          string
+	target        string
+	clientVersion int
+	persistent    bool
+	timeout       time.Duration
+
+	conn    net.Conn
+	reader  *bufio.Reader
+	cseq    int
+	session string
+	sync.Mutex
+}
+
+// Target returns the base rtsp:// URL used as request target.
+func (c *RTSPClient) Target() string {
+	return c.target
+}
+
+// SessionID returns the session established by SETUP, if any.
+func (c *RTSPClient) SessionID() string {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.session
+}
+
+// Do sends a request and reads its response. CSeq, X-GS-ClientVersion,
+// Host and, once established, Session are added automatically.
+func (c *RTSPClient) Do(ctx context.Context, req *RTSPRequest) (_ *RTSPResponse, err error) {
+	ctx, span := telemetry.StartKind(ctx, "rtsp.request", trace.SpanKindClient,
+		attribute.String("rtsp.method", req.Method),
+		attribute.String("rtsp.target", req.Target))
+
+	defer func() {
+		telemetry.RecordError(span, err)
+		span.End()
+	}()
+
+	c.Lock()
+	defer c.Unlock()
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		c.closeConn()
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("rtsp.status_code", resp.StatusCode))
+
+	if !c.persistent {
+		c.closeConn()
+	}
+
+	if session := resp.Headers.Get("Session"); session != "" {
+		// e.g. "DEADBEEFCAFE;timeout = 90"
+		id, _, _ := strings.Cut(session, ";")
+		c.session = strings.TrimSpace(id)
+	}
+
+	if resp.StatusCode != 200 {
+		return resp, fmt.Errorf("rtsp %s %s failed with status: %d %s",
+			req.Method, req.Target, resp.StatusCode, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (c *RTSPClient) do(ctx context.Context, req *RTSPRequest) (*RTSPResponse, error) {
+	if c.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", c.host)
+		if err != nil {
+			return nil, err
+		}
+
+		c.conn = conn
+		c.reader = bufio.NewReader(conn)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.timeout)
+	}
+
+	c.conn.SetDeadline(deadline)
+
+	c.cseq++
+
+	var b strings.Builder
+	b.WriteString(req.Method + " " + req.Target + " RTSP/1.0\r\n")
+	b.WriteString("CSeq: " + strconv.Itoa(c.cseq) + "\r\n")
+	b.WriteString("X-GS-ClientVersion: " + strconv.Itoa(c.clientVersion) + "\r\n")
+	b.WriteString("Host: " + c.host + "\r\n")
+
+	if c.session != "" {
+		b.WriteString("Session: " + c.session + "\r\n")
+	}
+
+	for k, v := range req.Headers {
+		b.WriteString(k + ": " + v + "\r\n")
+	}
+
+	if len(req.Body) > 0 {
+		b.WriteString("Content-length: " + strconv.Itoa(len(req.Body)) + "\r\n")
+	}
+
+	b.WriteString("\r\n")
+
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	if len(req.Body) > 0 {
+		if _, err := c.conn.Write(req.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return nil, err
+	}
+
+	if cseq := resp.Headers.Get("CSeq"); cseq != "" && cseq != strconv.Itoa(c.cseq) {
+		return nil, errors.New("rtsp response out of sequence: " + cseq)
+	}
+
+	return resp, nil
+}
+
+func (c *RTSPClient) readResponse() (*RTSPResponse, error) {
+	tp := textproto.NewReader(c.reader)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok || !strings.HasPrefix(proto, "RTSP/") {
+		return nil, errors.New("invalid rtsp status line: " + line)
+	}
+
+	code, reason, _ := strings.Cut(status, " ")
+
+	statusCode, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, errors.New("invalid rtsp status code: " + code)
+	}
+
+	headers, err := tp.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	resp := &RTSPResponse{
+		StatusCode: statusCode,
+		Status:     reason,
+		Headers:    headers,
+	}
+
+	if length := headers.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil {
+			return nil, errors.New("invalid rtsp content length: " + length)
+		}
+
+		resp.Body = make([]byte, n)
+		if _, err := io.ReadFull(c.reader, resp.Body); err != nil {
+			return nil, err
+		}
+	} else if !c.persistent {
+		// GFE omits the length and closes the connection after the body.
+		body, err := io.ReadAll(c.reader)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Body = body
+	}
+
+	return resp, nil
+}
+
+// Play starts the streams of the session. Servers since GFE 3.x expect a
+// single PLAY on "/"; older ones one per stream, e.g. "streamid=video".
+func (c *RTSPClient) Play(ctx context.Context, targets ...string) error {
+	if c.SessionID() == "" {
+		return errors.New("rtsp session not established")
+	}
+
+	if len(targets) == 0 {
+		targets = []string{"/"}
+	}
+
+	for _, target := range targets {
+		_, err := c.Do(ctx, &RTSPRequest{
+			Method: "PLAY",
+			Target: target,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Teardown ends the session. It is a no-op without a session.
+func (c *RTSPClient) Teardown(ctx context.Context) error {
+	if c.SessionID() == "" {
+		return nil
+	}
+
+	_, err := c.Do(ctx, &RTSPRequest{
+		Method: "TEARDOWN",
+		Target: "/",
+	})
+
+	c.Lock()
+	c.session = ""
+	c.Unlock()
+
+	return err
+}
+
+// Close tears down the session, best effort, and closes the connection.
+func (c *RTSPClient) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.Teardown(ctx); err != nil {
+		c.log.Warn("rtsp teardown failed", zap.Error(err))
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.closeConn()
+}
+
+func (c *RTSPClient) closeConn() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+
+	return err
+}
+
+// RTSPClientVersion maps the host's app version to the X-GS-ClientVersion
+// it expects.
+func RTSPClientVersion(appVersion string) int {
+	quad := parseAppVersion(appVersion)
+
+	switch quad[0] {
+	case 3:
+		return 10
+	case 4:
+		return 11
+	case 5:
+		return 12
+	case 6:
+		return 13
+	default:
+		return 14
+	}
+}
+
+func parseAppVersion(appVersion string) [4]int {
+	var quad [4]int
+	for i, part := range strings.SplitN(appVersion, ".", 4) {
+		quad[i], _ = strconv.Atoi(part)
+	}
+
+	return quad
+}
+
+func appVersionAtLeast(appVersion string, major, minor, patch int) bool {
+	quad := parseAppVersion(appVersion)
+
+	if quad[0] != major {
+		return quad[0] > major
+	}
+
+	if quad[1] != minor {
+		return quad[1] > minor
+	}
+
+	return quad[2] >= patch
+}
+
+const rtspIfModifiedSince = "Thu, 01 Jan 1970 00:00:00 GMT"
+
+func (c *RTSPClient) Options(ctx context.Context) error {
+	_, err := c.Do(ctx, &RTSPRequest{
+		Method: "OPTIONS",
+		Target: c.target,
+	})
+
+	return err
+}
+
+func (c *RTSPClient) Describe(ctx context.Context) (*ServerDescription, error) {
+	resp, err := c.Do(ctx, &RTSPRequest{
+		Method: "DESCRIBE",
+		Target: c.target,
+		Headers: map[string]string{
+			"Accept":            "application/sdp",
+			"If-Modified-Since": rtspIfModifiedSince,
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseServerDescription(resp.Body), nil
+}
+
+// Setup sets up a stream, e.g. "streamid=video/0/0", and returns the
+// server port from the Transport header, or 0 if none was given.
+func (c *RTSPClient) Setup(ctx context.Context, target string) (int, *RTSPResponse, error) {
+	resp, err := c.Do(ctx, &RTSPRequest{
+		Method: "SETUP",
+		Target: target,
+		Headers: map[string]string{
+			"Transport":         "unicast;X-GS-ClientPort=50000-50001",
+			"If-Modified-Since": rtspIfModifiedSince,
+		},
+	})
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var port int
+	for _, param := range strings.Split(resp.Headers.Get("Transport"), ";") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(param), "server_port=")
+		if !ok {
+			continue
+		}
+
+		value, _, _ = strings.Cut(value, "-")
+		port, _ = strconv.Atoi(value)
+	}
+
+	return port, resp, nil
+}
+
+func (c *RTSPClient) Announce(ctx context.Context, target string, sdp []byte) error {
+	_, err := c.Do(ctx, &RTSPRequest{
+		Method: "ANNOUNCE",
+		Target: target,
+		Headers: map[string]string{
+			"Content-type": "application/sdp",
+		},
+		Body: sdp,
+	})
+
+	return err
+}
+
+// RTSPSession is the result of a completed handshake: the ports and
+// connection data needed to open the video, audio and control streams.
+type RTSPSession struct {
+	ID          string
+	Description *ServerDescription
+	VideoPort   int
+	AudioPort   int
+	ControlPort int
+	PingPayload string
+	ConnectData uint32
+}
+
+// Handshake runs the GameStream RTSP handshake in Go: OPTIONS, DESCRIBE,
+// SETUP of the audio, video and control streams, ANNOUNCE of the stream
+// SDP and PLAY. host is the address put into the SDP origin.
+func (c *RTSPClient) Handshake(ctx context.Context, host string, appVersion string, cfg *StreamConfiguration) (_ *RTSPSession, err error) {
+	ctx, span := telemetry.Start(ctx, "rtsp.handshake",
+		attribute.String("server.address", host),
+		attribute.String("nvstream.app_version", appVersion))
+
+	defer func() {
+		telemetry.RecordError(span, err)
+		span.End()
+	}()
+
+	if err := c.Options(ctx); err != nil {
+		return nil, err
+	}
+
+	desc, err := c.Describe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &RTSPSession{
+		Description: desc,
+		VideoPort:   DEFAULT_VIDEO_PORT,
+		AudioPort:   DEFAULT_AUDIO_PORT,
+		ControlPort: DEFAULT_CONTROL_PORT,
+	}
+
+	port, resp, err := c.Setup(ctx, "streamid=audio/0/0")
+	if err != nil {
+		return nil, err
+	}
+
+	if port > 0 {
+		session.AudioPort = port
+	}
+
+	// Sunshine sends a ping payload to identify the client's UDP streams.
+	session.PingPayload = resp.Headers.Get("X-SS-Ping-Payload")
+
+	port, _, err = c.Setup(ctx, "streamid=video/0/0")
+	if err != nil {
+		return nil, err
+	}
+
+	if port > 0 {
+		session.VideoPort = port
+	}
+
+	controlTarget := "streamid=control/1/0"
+	if appVersionAtLeast(appVersion, 7, 1, 431) {
+		controlTarget = "streamid=control/13/0"
+	}
+
+	port, resp, err = c.Setup(ctx, controlTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	if port > 0 {
+		session.ControlPort = port
+	}
+
+	if data := resp.Headers.Get("X-SS-Connect-Data"); data != "" {
+		session.ConnectData = parseUint32(data)
+	}
+
+	sdp := BuildStreamSDP(host, cfg, desc)
+	if err := c.Announce(ctx, controlTarget, sdp); err != nil {
+		return nil, err
+	}
+
+	if appVersionAtLeast(appVersion, 5, 0, 0) {
+		err = c.Play(ctx)
+	} else {
+		err = c.Play(ctx, "streamid=video", "streamid=audio")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	session.ID = c.SessionID()
+
+	return session, nil
+}
@@ -0,0 +1,191 @@
+package nvstream
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type rtspRecord struct {
+	Line    string
+	Session string
+}
+
+const testServerSDP = "v=0\r\n" +
+	"a=x-ss-general.featureFlags:3\r\n" +
+	"a=x-ss-general.encryptionSupported:5\r\n" +
+	"a=x-ss-general.encryptionRequested:1\r\n" +
+	"a=fmtp:96 sprop-parameter-sets=AAAAAU\r\n" +
+	"a=fmtp:97 surround-params=21101\r\n" +
+	"a=fmtp:97 surround-params=642014523\r\n"
+
+// fakeRTSPServer answers every request with 200 OK and hands out a session
+// on SETUP. Without keepAlive the connection is closed after each response
+// and the body is sent without a length, like GFE does.
+func fakeRTSPServer(t *testing.T, keepAlive bool) (string, <-chan rtspRecord) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	records := make(chan rtspRecord, 16)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				tp := textproto.NewReader(bufio.NewReader(conn))
+				for {
+					line, err := tp.ReadLine()
+					if err != nil {
+						return
+					}
+
+					headers, err := tp.ReadMIMEHeader()
+					if err != nil {
+						return
+					}
+
+					if length := headers.Get("Content-Length"); length != "" {
+						n, _ := strconv.Atoi(length)
+						tp.R.Discard(n)
+					}
+
+					records <- rtspRecord{line, headers.Get("Session")}
+
+					resp := "RTSP/1.0 200 OK\r\nCSeq: " + headers.Get("CSeq") + "\r\n"
+					body := "ok"
+
+					switch {
+					case strings.HasPrefix(line, "SETUP streamid=audio"):
+						resp += "Session: DEADBEEFCAFE;timeout = 90\r\n"
+						resp += "Transport: server_port=48100-48101\r\n"
+						resp += "X-SS-Ping-Payload: 0123456789ABCDEF\r\n"
+					case strings.HasPrefix(line, "SETUP streamid=control"):
+						resp += "Session: DEADBEEFCAFE;timeout = 90\r\n"
+						resp += "X-SS-Connect-Data: 3735928559\r\n"
+					case strings.HasPrefix(line, "SETUP"):
+						resp += "Session: DEADBEEFCAFE;timeout = 90\r\n"
+					case strings.HasPrefix(line, "DESCRIBE"):
+						body = testServerSDP
+					}
+
+					if keepAlive {
+						conn.Write([]byte(resp + "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+						continue
+					}
+
+					conn.Write([]byte(resp + "\r\n" + body))
+					return
+				}
+			}(conn)
+		}
+	}()
+
+	return "rtsp://" + ln.Addr().String(), records
+}
+
+func TestRTSPClient(t *testing.T) {
+	for _, persistent := range []bool{true, false} {
+		assert := assert.New(t)
+
+		url, records := fakeRTSPServer(t, persistent)
+
+		client, err := NewRTSPClient(url, 14, persistent)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		ctx := context.Background()
+
+		assert.Error(client.Play(ctx))
+
+		resp, err := client.Do(ctx, &RTSPRequest{
+			Method: "SETUP",
+			Target: "streamid=video/0/0",
+		})
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal([]byte("ok"), resp.Body)
+		assert.Equal("DEADBEEFCAFE", client.SessionID())
+		assert.Equal(rtspRecord{"SETUP streamid=video/0/0 RTSP/1.0", ""}, <-records)
+
+		assert.NoError(client.Play(ctx, "streamid=video", "streamid=audio"))
+		assert.Equal(rtspRecord{"PLAY streamid=video RTSP/1.0", "DEADBEEFCAFE"}, <-records)
+		assert.Equal(rtspRecord{"PLAY streamid=audio RTSP/1.0", "DEADBEEFCAFE"}, <-records)
+
+		assert.NoError(client.Close())
+		assert.Equal(rtspRecord{"TEARDOWN / RTSP/1.0", "DEADBEEFCAFE"}, <-records)
+		assert.Empty(client.SessionID())
+	}
+}
+
+func TestRTSPHandshake(t *testing.T) {
+	assert := assert.New(t)
+
+	url, records := fakeRTSPServer(t, true)
+
+	client, err := NewRTSPClient(url, RTSPClientVersion("7.1.431.-1"), true)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	defer client.Close()
+
+	session, err := client.Handshake(context.Background(), "127.0.0.1", "7.1.431.-1", DefaultStreamConfiguration())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("DEADBEEFCAFE", session.ID)
+	assert.Equal(48100, session.AudioPort)
+	assert.Equal(DEFAULT_VIDEO_PORT, session.VideoPort)
+	assert.Equal(DEFAULT_CONTROL_PORT, session.ControlPort)
+	assert.Equal("0123456789ABCDEF", session.PingPayload)
+	assert.Equal(uint32(0xDEADBEEF), session.ConnectData)
+	assert.True(session.Description.HEVC)
+	assert.Equal(uint32(5), session.Description.EncryptionSupported)
+
+	target := client.Target()
+	expected := []rtspRecord{
+		{"OPTIONS " + target + " RTSP/1.0", ""},
+		{"DESCRIBE " + target + " RTSP/1.0", ""},
+		{"SETUP streamid=audio/0/0 RTSP/1.0", ""},
+		{"SETUP streamid=video/0/0 RTSP/1.0", "DEADBEEFCAFE"},
+		{"SETUP streamid=control/13/0 RTSP/1.0", "DEADBEEFCAFE"},
+		{"ANNOUNCE streamid=control/13/0 RTSP/1.0", "DEADBEEFCAFE"},
+		{"PLAY / RTSP/1.0", "DEADBEEFCAFE"},
+	}
+
+	for _, record := range expected {
+		assert.Equal(record, <-records)
+	}
+}
+
+func TestRTSPClientVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(10, RTSPClientVersion("3.20.0.0"))
+	assert.Equal(13, RTSPClientVersion("6.0.0.0"))
+	assert.Equal(14, RTSPClientVersion("7.1.431.-1"))
+}
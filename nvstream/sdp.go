@@ -0,0 +1,221 @@
+package nvstream
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// ServerDescription holds the capabilities advertised in the DESCRIBE
+// response SDP.
+type ServerDescription struct {
+	HEVC bool
+	AV1  bool
+
+	// Sunshine extensions
+	FeatureFlags        uint32
+	EncryptionSupported uint32
+	EncryptionRequested uint32
+
+	// SurroundParams are the Opus layouts of the audio stream, in the
+	// order advertised; fmtp attributes repeat, one per layout.
+	SurroundParams []string
+
+	Attributes map[string]string
+}
+
+// Sunshine's flags of x-ss-general.encryptionEnabled.
+const (
+	ssEncControlV2 = 0x01
+	ssEncVideo     = 0x02
+	ssEncAudio     = 0x04
+)
+
+// negotiateEncryption returns the encryption enabled for a connection, as
+// moonlight negotiates it: the control stream's whenever the host supports
+// it, the video and audio's when the flags ask for them or the host
+// requires them.
+func negotiateEncryption(flags moonlight.EncryptionFlags, desc *ServerDescription) uint32 {
+	if desc == nil {
+		return 0
+	}
+
+	want := ssEncControlV2 | desc.EncryptionRequested
+	if flags&moonlight.ENCFLG_VIDEO != 0 {
+		want |= ssEncVideo
+	}
+
+	if flags&moonlight.ENCFLG_AUDIO != 0 {
+		want |= ssEncAudio
+	}
+
+	return want & desc.EncryptionSupported
+}
+
+func ParseServerDescription(sdp []byte) *ServerDescription {
+	desc := &ServerDescription{
+		Attributes: make(map[string]string),
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(sdp)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// HEVC parameter sets are only advertised by hosts supporting it.
+		if strings.Contains(line, "sprop-parameter-sets=AAAAAU") {
+			desc.HEVC = true
+		}
+
+		attr, ok := strings.CutPrefix(line, "a=")
+		if !ok {
+			continue
+		}
+
+		if strings.HasPrefix(attr, "rtpmap:98 AV1/90000") {
+			desc.AV1 = true
+		}
+
+		key, value, ok := strings.Cut(attr, ":")
+		if !ok {
+			continue
+		}
+
+		desc.Attributes[key] = strings.TrimSpace(value)
+
+		switch key {
+		case "x-ss-general.featureFlags":
+			desc.FeatureFlags = parseUint32(value)
+		case "x-ss-general.encryptionSupported":
+			desc.EncryptionSupported = parseUint32(value)
+		case "x-ss-general.encryptionRequested":
+			desc.EncryptionRequested = parseUint32(value)
+		case "fmtp":
+			if params, ok := strings.CutPrefix(strings.TrimSpace(value), "97 surround-params="); ok {
+				desc.SurroundParams = append(desc.SurroundParams, params)
+			}
+		}
+	}
+
+	return desc
+}
+
+func parseUint32(s string) uint32 {
+	n, _ := strconv.ParseUint(strings.TrimSpace(s), 0, 32)
+	return uint32(n)
+}
+
+// BuildStreamSDP builds the session description sent with ANNOUNCE. It
+// follows the attributes moonlight-common-c sends to Gen 7 servers
+// (GFE 3.x and Sunshine).
+func BuildStreamSDP(host string, cfg *StreamConfiguration, desc *ServerDescription) []byte {
+	var b strings.Builder
+
+	attr := func(key string, value any) {
+		b.WriteString("a=" + key + ":")
+
+		switch v := value.(type) {
+		case int:
+			b.WriteString(strconv.Itoa(v))
+		case string:
+			b.WriteString(v)
+		}
+
+		b.WriteString(" \r\n")
+	}
+
+	b.WriteString("v=0\r\n")
+	b.WriteString("o=android 0 14 IN IPv4 " + host + "\r\n")
+	b.WriteString("s=NVIDIA Streaming Client\r\n")
+
+	packetSize := cfg.MaxPacketSize
+	if cfg.Remote == moonlight.STREAM_CFG_REMOTE {
+		packetSize = 1024
+	}
+
+	attr("x-nv-video[0].clientViewportWd", cfg.Width)
+	attr("x-nv-video[0].clientViewportHt", cfg.Height)
+	attr("x-nv-video[0].maxFPS", cfg.RefreshRate)
+	attr("x-nv-video[0].packetSize", packetSize)
+	attr("x-nv-video[0].rateControlMode", 4)
+	attr("x-nv-video[0].timeoutLengthMs", 7000)
+	attr("x-nv-video[0].framesWithInvalidRefThreshold", 0)
+
+	attr("x-nv-video[0].initialBitrateKbps", cfg.Bitrate)
+	attr("x-nv-video[0].initialPeakBitrateKbps", cfg.Bitrate)
+	attr("x-nv-vqos[0].bw.minimumBitrateKbps", cfg.Bitrate)
+	attr("x-nv-vqos[0].bw.maximumBitrateKbps", cfg.Bitrate)
+	attr("x-ml-video.configuredBitrateKbps", cfg.Bitrate)
+
+	attr("x-nv-vqos[0].fec.enable", 1)
+	attr("x-nv-vqos[0].videoQualityScoreUpdateTime", 5000)
+	attr("x-nv-vqos[0].qosTrafficType", 0)
+	attr("x-nv-aqos.qosTrafficType", 0)
+
+	attr("x-nv-general.featureFlags", 167)
+	attr("x-nv-general.useReliableUdp", 13)
+	attr("x-nv-vqos[0].fec.minRequiredFecPackets", 2)
+	attr("x-nv-vqos[0].bllFec.enable", 0)
+	attr("x-nv-vqos[0].drc.enable", 0)
+	attr("x-nv-general.enableRecoveryMode", 0)
+
+	attr("x-nv-video[0].videoEncoderSlicesPerFrame", 1)
+
+	formats := cfg.SupportedVideoFormatsBitmask()
+
+	bitStreamFormat := 0
+	switch {
+	case desc != nil && desc.AV1 && formats&moonlight.VIDEO_FORMAT_MASK_AV1 != 0:
+		bitStreamFormat = 2
+	case desc != nil && desc.HEVC && formats&moonlight.VIDEO_FORMAT_MASK_H265 != 0:
+		bitStreamFormat = 1
+	}
+
+	if bitStreamFormat == 1 {
+		attr("x-nv-clientSupportHevc", 1)
+	} else {
+		attr("x-nv-clientSupportHevc", 0)
+	}
+
+	attr("x-nv-vqos[0].bitStreamFormat", bitStreamFormat)
+
+	dynamicRange := 0
+	if bitStreamFormat != 0 && formats&moonlight.VIDEO_FORMAT_MASK_10BIT != 0 {
+		dynamicRange = 1
+	}
+
+	attr("x-nv-video[0].dynamicRangeMode", dynamicRange)
+	attr("x-nv-video[0].maxNumReferenceFrames", 1)
+	attr("x-nv-video[0].clientRefreshRateX100", cfg.ClientRefreshRateX100)
+
+	// BT.601 = 0, BT.709 = 1, BT.2020 = 2, doubled, plus one for full range
+	csc := int(cfg.ColorSpace) << 1
+	if cfg.ColorRange == moonlight.COLOR_RANGE_FULL {
+		csc |= 1
+	}
+
+	attr("x-nv-video[0].encoderCscMode", csc)
+
+	audio := cfg.AudioConfiguration
+	attr("x-nv-audio.surround.numChannels", audio.ChannelCount)
+	attr("x-nv-audio.surround.channelMask", audio.ChannelMask)
+
+	if audio.ChannelCount > 2 {
+		attr("x-nv-audio.surround.enable", 1)
+	} else {
+		attr("x-nv-audio.surround.enable", 0)
+	}
+
+	attr("x-nv-audio.surround.AudioQuality", 0)
+	attr("x-nv-aqos.packetDuration", 5)
+
+	if desc != nil && desc.EncryptionSupported != 0 {
+		attr("x-ss-general.encryptionEnabled", int(negotiateEncryption(cfg.EncryptionFlags, desc)))
+	}
+
+	b.WriteString("t=0 0\r\n")
+	b.WriteString("m=video " + strconv.Itoa(DEFAULT_VIDEO_PORT) + "  \r\n")
+
+	return []byte(b.String())
+}
@@ -0,0 +1,63 @@
+package nvstream
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+func TestParseServerDescription(t *testing.T) {
+	assert := assert.New(t)
+
+	desc := ParseServerDescription([]byte(testServerSDP))
+
+	assert.True(desc.HEVC)
+	assert.False(desc.AV1)
+	assert.Equal(uint32(3), desc.FeatureFlags)
+	assert.Equal(uint32(5), desc.EncryptionSupported)
+	assert.Equal(uint32(1), desc.EncryptionRequested)
+	assert.Equal([]string{"21101", "642014523"}, desc.SurroundParams)
+	assert.Equal("3", desc.Attributes["x-ss-general.featureFlags"])
+}
+
+func TestBuildStreamSDP(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := DefaultStreamConfiguration()
+	cfg.SupportedVideoFormats = append(cfg.SupportedVideoFormats, moonlight.VIDEO_FORMAT_H265)
+
+	sdp := string(BuildStreamSDP("192.168.1.10", cfg, &ServerDescription{HEVC: true}))
+
+	assert.True(strings.HasPrefix(sdp, "v=0\r\no=android 0 14 IN IPv4 192.168.1.10\r\n"))
+	assert.Contains(sdp, "a=x-nv-video[0].clientViewportWd:1920 \r\n")
+	assert.Contains(sdp, "a=x-nv-video[0].maxFPS:60 \r\n")
+	assert.Contains(sdp, "a=x-nv-vqos[0].bitStreamFormat:1 \r\n")
+	assert.Contains(sdp, "a=x-nv-clientSupportHevc:1 \r\n")
+	assert.NotContains(sdp, "x-ss-general.encryptionEnabled")
+	assert.True(strings.HasSuffix(sdp, "m=video 47998  \r\n"))
+
+	sdp = string(BuildStreamSDP("192.168.1.10", cfg, nil))
+	assert.Contains(sdp, "a=x-nv-vqos[0].bitStreamFormat:0 \r\n")
+}
+
+func TestNegotiateEncryption(t *testing.T) {
+	assert := assert.New(t)
+
+	desc := ParseServerDescription([]byte(testServerSDP))
+
+	// The control stream's is always on; the host supports audio.
+	assert.Equal(uint32(ssEncControlV2), negotiateEncryption(moonlight.ENCFLG_NONE, desc))
+	assert.Equal(uint32(ssEncControlV2), negotiateEncryption(moonlight.ENCFLG_VIDEO, desc))
+	assert.Equal(uint32(ssEncControlV2|ssEncAudio), negotiateEncryption(moonlight.ENCFLG_AUDIO, desc))
+	assert.Equal(uint32(ssEncControlV2|ssEncAudio), negotiateEncryption(moonlight.ENCFLG_ALL, desc))
+
+	// Required by the host whatever the flags.
+	desc.EncryptionSupported = ssEncControlV2 | ssEncVideo | ssEncAudio
+	desc.EncryptionRequested = ssEncVideo
+	assert.Equal(uint32(ssEncControlV2|ssEncVideo), negotiateEncryption(moonlight.ENCFLG_NONE, desc))
+
+	assert.Zero(negotiateEncryption(moonlight.ENCFLG_ALL, nil))
+}
@@ -0,0 +1,98 @@
+package nvstream
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// statsInterval is how often the statistics of a running connection are
+// checked against the thresholds.
+const statsInterval = 5 * time.Second
+
+// statsWatch warns once when the host RTT or the video frames pending go
+// over their thresholds, and tells once they are back under.
+type statsWatch struct {
+	log           *zap.Logger
+	rtt           time.Duration
+	pendingFrames int
+
+	rttHigh   bool
+	queueHigh bool
+}
+
+func (w *statsWatch) observe(stats moonlight.ConnectionStats) {
+	if high := stats.RTT > w.rtt; high != w.rttHigh {
+		w.rttHigh = high
+
+		log := w.log.With(
+			zap.Duration("rtt", stats.RTT),
+			zap.Duration("rtt_variance", stats.RTTVariance),
+			zap.Duration("threshold", w.rtt),
+		)
+
+		if high {
+			log.Warn("host rtt high")
+		} else {
+			log.Info("host rtt recovered")
+		}
+	}
+
+	if high := stats.PendingVideoFrames > w.pendingFrames; high != w.queueHigh {
+		w.queueHigh = high
+
+		log := w.log.With(
+			zap.Int("pending_video_frames", stats.PendingVideoFrames),
+			zap.Int("threshold", w.pendingFrames),
+		)
+
+		if high {
+			log.Warn("video frames queued")
+		} else {
+			log.Info("video frame queue drained")
+		}
+	}
+}
+
+// watchStats checks the statistics until the connection of generation gen
+// ends.
+func (conn *nvConnection) watchStats(gen uint64) {
+	w := &statsWatch{
+		log:           conn.log.With(zap.String("action", "watch_stats")),
+		rtt:           conn.stream.RTTWarning,
+		pendingFrames: conn.stream.PendingFramesWarning,
+	}
+
+	if w.rtt <= 0 {
+		w.rtt = defaultRTTWarning
+	}
+
+	if w.pendingFrames <= 0 {
+		w.pendingFrames = defaultPendingFramesWarning
+	}
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if conn.statsGen.Load() != gen || conn.Stage() != ConnectionStageConnected {
+			return
+		}
+
+		if stats, ok := moonlight.Stats(); ok {
+			w.observe(stats)
+		}
+	}
+}
+
+// Stats reports the statistics of the connection while it runs.
+func (conn *nvConnection) Stats() (moonlight.ConnectionStats, bool) {
+	// The one connection moonlight runs may be another's.
+	if conn.Stage() != ConnectionStageConnected {
+		return moonlight.ConnectionStats{}, false
+	}
+
+	return moonlight.Stats()
+}
@@ -0,0 +1,38 @@
+package nvstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+func TestStatsWatch(t *testing.T) {
+	assert := assert.New(t)
+
+	w := &statsWatch{
+		log:           zap.NewNop(),
+		rtt:           100 * time.Millisecond,
+		pendingFrames: 3,
+	}
+
+	w.observe(moonlight.ConnectionStats{RTT: 20 * time.Millisecond, PendingVideoFrames: 1})
+	assert.False(w.rttHigh)
+	assert.False(w.queueHigh)
+
+	w.observe(moonlight.ConnectionStats{RTT: 180 * time.Millisecond, PendingVideoFrames: 1})
+	assert.True(w.rttHigh)
+	assert.False(w.queueHigh)
+
+	w.observe(moonlight.ConnectionStats{RTT: 90 * time.Millisecond, PendingVideoFrames: 5})
+	assert.False(w.rttHigh)
+	assert.True(w.queueHigh)
+
+	// Unknown until estimated.
+	w.observe(moonlight.ConnectionStats{})
+	assert.False(w.rttHigh)
+	assert.False(w.queueHigh)
+}
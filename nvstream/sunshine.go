@@ -0,0 +1,143 @@
+package nvstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrNoPendingPairing = errors.New("no pending pairing request")
+
+// Sunshine holds the admin credentials of a Sunshine host's web API, used
+// to submit pairing PINs without anyone at the host.
+type Sunshine struct {
+	URL      *url.URL
+	Username string
+	Password string
+
+	// InsecureSkipVerify accepts the web UI's self-signed certificate.
+	InsecureSkipVerify bool
+}
+
+func (cfg *Sunshine) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		URL                string `yaml:"url"`
+		Username           string `yaml:"username"`
+		Password           string `yaml:"password"`
+		InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.URL == "" {
+		return errors.New("sunshine url not specified")
+	}
+
+	u, err := url.Parse(raw.URL)
+	if err != nil {
+		return err
+	}
+
+	cfg.URL = u
+	cfg.Username = raw.Username
+	cfg.Password = raw.Password
+	cfg.InsecureSkipVerify = raw.InsecureSkipVerify
+
+	return nil
+}
+
+func NewSunshineClient(cfg *Sunshine) *SunshineClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	return &SunshineClient{
+		cfg: cfg,
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   10 * time.Second,
+		},
+	}
+}
+
+type SunshineClient struct {
+	cfg  *Sunshine
+	http *http.Client
+}
+
+// SubmitPIN enters the PIN for the host's pending pairing request, naming
+// the paired client name. It fails with ErrNoPendingPairing if the host is
+// not waiting for a PIN.
+func (c *SunshineClient) SubmitPIN(ctx context.Context, pin string, name string) error {
+	body, err := json.Marshal(map[string]string{
+		"pin":  pin,
+		"name": name,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.cfg.URL.JoinPath("api", "pin").String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("HTTP request failed with status: " + resp.Status)
+	}
+
+	// Older releases report the status as a string.
+	var result struct {
+		Status json.RawMessage `json:"status"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	switch string(result.Status) {
+	case `true`, `"true"`:
+		return nil
+	default:
+		return ErrNoPendingPairing
+	}
+}
+
+// AutoSubmitPIN submits the PIN once the host received the pairing
+// request, retrying every interval until it is accepted or ctx is done.
+func (c *SunshineClient) AutoSubmitPIN(ctx context.Context, pin string, name string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		err := c.SubmitPIN(ctx, pin, name)
+		if !errors.Is(err, ErrNoPendingPairing) {
+			return err
+		}
+	}
+}
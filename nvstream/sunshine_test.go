@@ -0,0 +1,66 @@
+package nvstream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSunshineAutoSubmitPIN(t *testing.T) {
+	assert := assert.New(t)
+
+	attempts := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+
+		assert.Equal("/api/pin", r.URL.Path)
+		assert.Equal("1234", body["pin"])
+		assert.Equal("roth", body["name"])
+
+		// The host has no pending request until the second attempt; older
+		// releases answer with a string.
+		attempts++
+		if attempts < 2 {
+			w.Write([]byte(`{"status":"false"}`))
+			return
+		}
+
+		w.Write([]byte(`{"status":"true"}`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	cfg := &Sunshine{
+		URL:                u,
+		Username:           "admin",
+		Password:           "secret",
+		InsecureSkipVerify: true,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := NewSunshineClient(cfg)
+	err := client.AutoSubmitPIN(ctx, "1234", "roth", 10*time.Millisecond)
+	assert.NoError(err)
+	assert.Equal(2, attempts)
+
+	// Wrong credentials are not retried.
+	cfg.Password = "wrong"
+	err = client.AutoSubmitPIN(ctx, "1234", "roth", 10*time.Millisecond)
+	assert.ErrorContains(err, "401")
+	assert.Equal(2, attempts)
+}
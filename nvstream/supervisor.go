@@ -0,0 +1,103 @@
+package nvstream
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Backoff bounds the delay between reconnect attempts, which doubles from
+// Initial up to Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+var DefaultBackoff = Backoff{
+	Initial: time.Second,
+	Max:     30 * time.Second,
+}
+
+// Failover moves a connection that keeps failing to resume off its host:
+// after After consecutive failures, the next attempt calls Next in place
+// of resuming, which relocates the connection to another host.
+type Failover struct {
+	After int
+	Next  func(ctx context.Context) error
+}
+
+// Supervise resumes the connection whenever it terminates, retrying with
+// backoff until a resume succeeds. The video and audio streams registered
+// with moonlight stay the same, so their readers see a gap in the media
+// rather than the end of it. Supervise returns when ctx is done.
+//
+// failover, if not nil, moves the connection to another host while its
+// own keeps failing. terminated, if not nil, is called with the error code
+// of each termination before resuming.
+func Supervise(ctx context.Context, conn NvConnection, backoff Backoff, failover *Failover, terminated func(errorCode int)) {
+	log := zap.L().With(
+		zap.String("component", "nvstream.supervisor"),
+	)
+
+	for {
+		var errorCode int
+		select {
+		case <-ctx.Done():
+			return
+		case errorCode = <-conn.Terminated():
+		}
+
+		log.Warn("connection terminated, resuming",
+			zap.String("action", "resume"),
+			zap.Int("error_code", errorCode))
+
+		if terminated != nil {
+			terminated(errorCode)
+		}
+
+		delay := backoff.Initial
+		failures := 0
+		for attempt := 1; ; attempt++ {
+			action := "resume"
+			resume := conn.ResumeApp
+
+			if failover != nil && failures >= failover.After {
+				action = "failover"
+				resume = failover.Next
+				failures = 0
+			}
+
+			err := resume(ctx)
+			if err == nil {
+				log.Info("connection resumed",
+					zap.String("action", action),
+					zap.Int("attempt", attempt))
+
+				break
+			}
+
+			if action == "resume" {
+				failures++
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Error("resume failed",
+				zap.String("action", action),
+				zap.Int("attempt", attempt),
+				zap.Duration("retry_in", delay),
+				zap.Error(err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			delay = min(delay*2, backoff.Max)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package nvstream
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyConnection fails the first resumes after a termination.
+type flakyConnection struct {
+	NvConnection
+	terminated chan int
+	failures   int32
+	resumes    atomic.Int32
+	resumed    chan struct{}
+}
+
+func (conn *flakyConnection) Terminated() <-chan int {
+	return conn.terminated
+}
+
+func (conn *flakyConnection) ResumeApp(ctx context.Context) error {
+	if conn.resumes.Add(1) <= conn.failures {
+		return errors.New("host unreachable")
+	}
+
+	conn.resumed <- struct{}{}
+	return nil
+}
+
+func TestSupervise(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := &flakyConnection{
+		terminated: make(chan int, 1),
+		failures:   2,
+		resumed:    make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		Supervise(ctx, conn, Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond}, nil, nil)
+		close(done)
+	}()
+
+	conn.terminated <- -1
+
+	select {
+	case <-conn.resumed:
+	case <-time.After(time.Second):
+		assert.Fail("connection not resumed")
+		return
+	}
+
+	assert.Equal(int32(3), conn.resumes.Load())
+
+	// a later termination is resumed again
+	conn.terminated <- -1
+
+	select {
+	case <-conn.resumed:
+	case <-time.After(time.Second):
+		assert.Fail("connection not resumed")
+		return
+	}
+
+	assert.Equal(int32(4), conn.resumes.Load())
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail("supervisor not stopped")
+	}
+}
+
+func TestSuperviseFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	// the host never comes back
+	conn := &flakyConnection{
+		terminated: make(chan int, 1),
+		failures:   100,
+		resumed:    make(chan struct{}),
+	}
+
+	relocated := make(chan int32, 1)
+	failover := &Failover{
+		After: 3,
+		Next: func(ctx context.Context) error {
+			relocated <- conn.resumes.Load()
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go Supervise(ctx, conn, Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond}, failover, nil)
+
+	conn.terminated <- -1
+
+	select {
+	case resumes := <-relocated:
+		assert.Equal(int32(3), resumes)
+	case <-time.After(time.Second):
+		assert.Fail("connection not relocated")
+	}
+}
@@ -1,11 +1,12 @@
 package nvstream
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
+	"time"
 	"unsafe"
 
 	"go.uber.org/zap"
@@ -13,9 +14,129 @@ import (
 	"github.com/flarexio/game/thirdparty/moonlight"
 )
 
+// VideoStream delivers the host's frames, whole, with their timestamps.
 type VideoStream interface {
 	moonlight.VideoDecoderRenderer
-	io.ReadCloser
+	io.Closer
+
+	// NextFrame waits for the next frame; io.EOF once the stream is closed.
+	// The caller releases the frame.
+	NextFrame() (*Frame, error)
+
+	// RefreshRate is the frame rate the stream was set up with.
+	RefreshRate() int
+}
+
+// Frame is a decode unit: a whole picture. Its storage is pooled: once
+// the frame is written out, Release hands it back, after which neither
+// Buffers nor Data may be used.
+type Frame struct {
+	FrameNumber int
+	IDR         bool
+
+	// Buffers are the frame's NAL units, in Annex B. An IDR frame's
+	// parameter sets come first, typed as such.
+	Buffers []Buffer
+
+	// PresentationTime is when the host captured the frame, on its clock;
+	// zero if the host does not tell.
+	PresentationTime time.Duration
+
+	// ReceiveTime is when the frame's first packet arrived, on moonlight's
+	// monotonic clock.
+	ReceiveTime time.Duration
+
+	// HostProcessingLatency is how long the host took from capture to
+	// sending the frame; zero if the host does not tell.
+	HostProcessingLatency time.Duration
+
+	data *[]byte // pooled, the buffers back to back
+}
+
+type Buffer struct {
+	Type moonlight.BufferType
+	Data []byte
+}
+
+// framePool recycles the storage of frames, which would otherwise be
+// allocated anew 60 times a second.
+var framePool = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// newFrame copies the decode unit's buffers into pooled storage.
+func newFrame(decodeUnit *moonlight.DecodeUnit) *Frame {
+	frame := &Frame{
+		FrameNumber:      decodeUnit.FrameNumber,
+		IDR:              decodeUnit.FrameType == int(moonlight.FRAME_TYPE_IDR),
+		PresentationTime: time.Duration(decodeUnit.PresentationTimeMs) * time.Millisecond,
+		ReceiveTime:      time.Duration(decodeUnit.ReceiveTimeMs) * time.Millisecond,
+
+		// in units of 0.1 ms
+		HostProcessingLatency: time.Duration(decodeUnit.FrameHostProcessingLatency) * 100 * time.Microsecond,
+
+		data: framePool.Get().(*[]byte),
+	}
+
+	var size int
+	for entry := decodeUnit.BufferList; entry != nil; entry = entry.Next {
+		size += entry.Length
+	}
+
+	// Sized up front, so the buffers never move while appended to.
+	data := slices.Grow((*frame.data)[:0], size)
+
+	for entry := decodeUnit.BufferList; entry != nil; entry = entry.Next {
+		if entry.Length == 0 {
+			continue
+		}
+
+		start := len(data)
+		data = append(data, entry.Data[:entry.Length]...)
+
+		frame.Buffers = append(frame.Buffers, Buffer{
+			Type: moonlight.BufferType(entry.BufferType),
+			Data: data[start:len(data):len(data)],
+		})
+	}
+
+	*frame.data = data
+
+	return frame
+}
+
+// Data returns the frame's buffers in order, as one Annex B access unit.
+func (f *Frame) Data() []byte {
+	if f.data == nil {
+		return nil
+	}
+
+	return *f.data
+}
+
+// Release returns the frame's storage to the pool.
+func (f *Frame) Release() {
+	if f.data == nil {
+		return
+	}
+
+	*f.data = (*f.data)[:0]
+	framePool.Put(f.data)
+
+	f.data = nil
+	f.Buffers = nil
+}
+
+// releaseFrames releases the frames and returns the emptied queue.
+func releaseFrames(frames []*Frame) []*Frame {
+	for i, frame := range frames {
+		frame.Release()
+		frames[i] = nil
+	}
+
+	return frames[:0]
 }
 
 func NewVideoStream() VideoStream {
@@ -23,12 +144,13 @@ func NewVideoStream() VideoStream {
 		zap.String("component", "nvstream.video_stream"),
 	)
 
-	return &videoStream{
+	vs := &videoStream{
 		log:    log,
-		stream: new(bytes.Buffer),
 		closed: false,
-		cond:   sync.NewCond(&sync.Mutex{}),
 	}
+	vs.cond = sync.NewCond(&vs.Mutex)
+
+	return vs
 }
 
 type videoStream struct {
@@ -39,7 +161,7 @@ type videoStream struct {
 	videoFormat   int
 	refreshRate   int
 
-	stream *bytes.Buffer
+	frames []*Frame
 	closed bool
 	cond   *sync.Cond
 	sync.Mutex
@@ -54,10 +176,12 @@ func (vs *videoStream) Setup(format, width, height, redrawRate int, _ unsafe.Poi
 		zap.Int("format", format),
 	)
 
+	vs.Lock()
 	vs.initialWidth = width
 	vs.initialHeight = height
 	vs.videoFormat = format
 	vs.refreshRate = redrawRate
+	vs.Unlock()
 
 	videoFormat := moonlight.VideoFormatMask(format)
 
@@ -96,36 +220,40 @@ func (vs *videoStream) Stop() {
 	vs.log.Info("video stream stopped", zap.String("action", "stop"))
 }
 
+func (vs *videoStream) RefreshRate() int {
+	vs.Lock()
+	defer vs.Unlock()
+
+	return vs.refreshRate
+}
+
 func (vs *videoStream) Cleanup() {
 	vs.Lock()
-	vs.stream.Reset()
+	vs.frames = releaseFrames(vs.frames)
 	vs.Unlock()
 
 	vs.log.Info("video stream cleaned up", zap.String("action", "cleanup"))
 }
 
 func (vs *videoStream) SubmitDecodeUnit(decodeUnit *moonlight.DecodeUnit) int {
+	frame := newFrame(decodeUnit)
+
 	vs.Lock()
 	defer vs.Unlock()
 
-	isIDR := decodeUnit.FrameType == int(moonlight.FRAME_TYPE_IDR)
-	if isIDR {
-		if vs.stream.Len() > 0 {
-			vs.stream.Reset()
-		}
-
-		vs.log.Debug("received IDR frame")
+	if vs.closed {
+		frame.Release()
+		return moonlight.DR_OK
 	}
 
-	for currentEntry := decodeUnit.BufferList; currentEntry != nil; currentEntry = currentEntry.Next {
-		length := currentEntry.Length
-		if length == 0 {
-			continue
-		}
-
-		vs.stream.Write(currentEntry.Data[:length])
+	// An IDR frame decodes without the frames before it; skip those still
+	// queued to catch up.
+	if frame.IDR {
+		vs.frames = releaseFrames(vs.frames)
+		vs.log.Debug("received IDR frame")
 	}
 
+	vs.frames = append(vs.frames, frame)
 	vs.cond.Signal()
 
 	return moonlight.DR_OK
@@ -136,26 +264,30 @@ func (vs *videoStream) Capabilities() int {
 	return 0
 }
 
-func (vs *videoStream) Read(p []byte) (n int, err error) {
-	vs.cond.L.Lock()
-	defer vs.cond.L.Unlock()
+func (vs *videoStream) NextFrame() (*Frame, error) {
+	vs.Lock()
+	defer vs.Unlock()
 
-	// Wait until there's data to read or the stream is closed
-	for vs.stream.Len() == 0 && !vs.closed {
+	// Wait until there's a frame or the stream is closed
+	for len(vs.frames) == 0 && !vs.closed {
 		vs.cond.Wait()
 	}
 
-	if vs.closed && vs.stream.Len() == 0 {
-		return 0, io.EOF
+	if vs.closed {
+		return nil, io.EOF
 	}
 
-	return vs.stream.Read(p)
+	frame := vs.frames[0]
+	vs.frames[0] = nil
+	vs.frames = vs.frames[1:]
+
+	return frame, nil
 }
 
 func (vs *videoStream) Close() error {
 	vs.Lock()
 	vs.closed = true
-	vs.stream.Reset()
+	vs.frames = releaseFrames(vs.frames)
 	vs.Unlock()
 
 	vs.cond.Broadcast()
@@ -0,0 +1,99 @@
+package nvstream
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+func decodeUnit(number int, frameType moonlight.FrameType, ptsMs uint, buffers ...moonlight.Lentry) *moonlight.DecodeUnit {
+	unit := &moonlight.DecodeUnit{
+		FrameNumber:        number,
+		FrameType:          int(frameType),
+		PresentationTimeMs: ptsMs,
+		ReceiveTimeMs:      uint64(ptsMs) + 5,
+	}
+
+	for i := len(buffers) - 1; i >= 0; i-- {
+		entry := buffers[i]
+		entry.Next = unit.BufferList
+		entry.Length = len(entry.Data)
+
+		unit.BufferList = &entry
+		unit.FullLength += entry.Length
+	}
+
+	return unit
+}
+
+func TestVideoStreamFrames(t *testing.T) {
+	assert := assert.New(t)
+
+	vs := NewVideoStream()
+
+	var (
+		sps   = moonlight.Lentry{Data: []byte{0, 0, 0, 1, 0x67}, BufferType: int(moonlight.BUFFER_TYPE_SPS)}
+		idr   = moonlight.Lentry{Data: []byte{0, 0, 0, 1, 0x65}}
+		slice = moonlight.Lentry{Data: []byte{0, 0, 0, 1, 0x41}}
+	)
+
+	vs.SubmitDecodeUnit(decodeUnit(1, moonlight.FRAME_TYPE_IDR, 1000, sps, idr))
+	vs.SubmitDecodeUnit(decodeUnit(2, moonlight.FRAME_TYPE_PFRAME, 1017, slice))
+
+	frame, err := vs.NextFrame()
+	assert.NoError(err)
+	assert.Equal(1, frame.FrameNumber)
+	assert.True(frame.IDR)
+	assert.Equal([]Buffer{
+		{moonlight.BUFFER_TYPE_SPS, sps.Data},
+		{moonlight.BUFFER_TYPE_PICDATA, idr.Data},
+	}, frame.Buffers)
+	assert.Equal([]byte{0, 0, 0, 1, 0x67, 0, 0, 0, 1, 0x65}, frame.Data())
+	assert.Equal(time.Second, frame.PresentationTime)
+	assert.Equal(1005*time.Millisecond, frame.ReceiveTime)
+
+	// an IDR frame skips the frames still queued
+	vs.SubmitDecodeUnit(decodeUnit(3, moonlight.FRAME_TYPE_IDR, 1033, sps, idr))
+
+	frame, err = vs.NextFrame()
+	assert.NoError(err)
+	assert.Equal(3, frame.FrameNumber)
+	assert.Equal(1033*time.Millisecond, frame.PresentationTime)
+
+	done := make(chan error)
+	go func() {
+		_, err := vs.NextFrame()
+		done <- err
+	}()
+
+	vs.Close()
+	assert.ErrorIs(<-done, io.EOF)
+}
+
+func TestFrameRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	sps := []byte{0, 0, 0, 1, 0x67}
+	idr := []byte{0, 0, 0, 1, 0x65, 0x88}
+
+	frame := newFrame(decodeUnit(1, moonlight.FRAME_TYPE_IDR, 1000,
+		moonlight.Lentry{Data: sps, BufferType: int(moonlight.BUFFER_TYPE_SPS)},
+		moonlight.Lentry{Data: idr},
+	))
+
+	// the buffers are copied, back to back
+	sps[4] = 0
+	assert.Equal([]byte{0, 0, 0, 1, 0x67, 0, 0, 0, 1, 0x65, 0x88}, frame.Data())
+	assert.Equal([]byte{0, 0, 0, 1, 0x67}, frame.Buffers[0].Data)
+	assert.Equal(5, cap(frame.Buffers[0].Data))
+
+	frame.Release()
+	assert.Nil(frame.Data())
+	assert.Nil(frame.Buffers)
+
+	frame.Release()
+}
@@ -0,0 +1,156 @@
+package game
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/core/model"
+)
+
+// opusClockRate is the RTP clock rate of Opus, whatever its sample rate.
+const opusClockRate = 48000
+
+// maxOpusFrame bounds a frame, or an RTP packet, read from a transport.
+const maxOpusFrame = 1 << 16
+
+// frameReader reads frames off a raw transport. On datagram sockets each
+// datagram is a frame; on stream sockets each frame is prefixed with its
+// length, 2 bytes big endian, as RFC 4571 frames RTP over TCP.
+type frameReader struct {
+	r        io.Reader
+	datagram bool
+	buf      []byte
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	_, datagram := r.(net.PacketConn)
+
+	return &frameReader{
+		r:        r,
+		datagram: datagram,
+		buf:      make([]byte, maxOpusFrame),
+	}
+}
+
+// Next returns the next frame. It is only valid until the next call.
+func (fr *frameReader) Next() ([]byte, error) {
+	if fr.datagram {
+		n, err := fr.r.Read(fr.buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return fr.buf[:n], nil
+	}
+
+	var size [2]byte
+	if _, err := io.ReadFull(fr.r, size[:]); err != nil {
+		return nil, err
+	}
+
+	frame := fr.buf[:binary.BigEndian.Uint16(size[:])]
+	if _, err := io.ReadFull(fr.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// rtpDepacketizer returns the Opus payloads of RTP packets with their
+// durations, told by the timestamps. The first packet, and packets after
+// a gap, last the configured frame duration.
+type rtpDepacketizer struct {
+	frameDuration time.Duration
+	started       bool
+	lastSeq       uint16
+	lastTimestamp uint32
+}
+
+func (d *rtpDepacketizer) Depacketize(raw []byte) ([]byte, time.Duration, error) {
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(raw); err != nil {
+		return nil, 0, err
+	}
+
+	duration := d.frameDuration
+	if d.started && pkt.SequenceNumber == d.lastSeq+1 {
+		delta := pkt.Timestamp - d.lastTimestamp
+		if delta > 0 && delta <= opusClockRate {
+			duration = time.Duration(delta) * time.Second / opusClockRate
+		}
+	}
+
+	d.started = true
+	d.lastSeq = pkt.SequenceNumber
+	d.lastTimestamp = pkt.Timestamp
+
+	return pkt.Payload, duration, nil
+}
+
+// framedOpusHandler plays bare Opus frames, or RTP packets carrying Opus,
+// from a raw transport.
+func (svc *service) framedOpusHandler(ctx context.Context, r io.ReadCloser, audio *AudioTrack) {
+	log, ok := ctx.Value(model.Logger).(*zap.Logger)
+	if !ok {
+		log = svc.log
+	}
+
+	log = log.With(
+		zap.String("track", "audio"),
+		zap.String("container", string(audio.Container())),
+		zap.String("codec", string(audio.Codec())),
+	)
+
+	reader := newFrameReader(r)
+	depacketizer := &rtpDepacketizer{frameDuration: audio.FrameDuration()}
+
+	log.Info("playing", zap.Duration("frame_duration", audio.FrameDuration()))
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.Close()
+			log.Info("done")
+			return
+
+		default:
+			frame, err := reader.Next()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Error(err.Error())
+				}
+				return
+			}
+
+			duration := audio.FrameDuration()
+			if audio.Container() == ContainerRTP {
+				frame, duration, err = depacketizer.Depacketize(frame)
+				if err != nil {
+					log.Warn("invalid rtp packet", zap.Error(err))
+					continue
+				}
+			}
+
+			if len(frame) == 0 {
+				continue
+			}
+
+			audio.WriteSample(media.Sample{
+				Data:     frame,
+				Duration: duration,
+			})
+		}
+	}
+}
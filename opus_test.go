@@ -0,0 +1,131 @@
+package game
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAudioContainer(t *testing.T) {
+	assert := assert.New(t)
+
+	var audio *AudioTrack
+	err := yaml.Unmarshal([]byte("codec: opus\ncontainer: rtp\nframeDuration: 10ms"), &audio)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(ContainerRTP, audio.Container())
+	assert.Equal(10*time.Millisecond, audio.FrameDuration())
+
+	err = yaml.Unmarshal([]byte("codec: opus\ncontainer: webm"), &audio)
+	assert.ErrorContains(err, "container unsupported")
+}
+
+func TestFrameReaderStream(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := []byte{0, 2, 0xFC, 1, 0, 0, 0, 3, 0xFC, 2, 3, 0, 5, 0xFC}
+	reader := newFrameReader(bytes.NewReader(stream))
+
+	frame, err := reader.Next()
+	assert.NoError(err)
+	assert.Equal([]byte{0xFC, 1}, frame)
+
+	frame, err = reader.Next()
+	assert.NoError(err)
+	assert.Empty(frame)
+
+	frame, err = reader.Next()
+	assert.NoError(err)
+	assert.Equal([]byte{0xFC, 2, 3}, frame)
+
+	_, err = reader.Next()
+	assert.ErrorIs(err, io.ErrUnexpectedEOF)
+}
+
+func TestFrameReaderDatagram(t *testing.T) {
+	assert := assert.New(t)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer conn.Close()
+
+	sender, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer sender.Close()
+
+	sender.Write([]byte{0xFC, 1, 2})
+	sender.Write([]byte{0xFC})
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	reader := newFrameReader(conn)
+
+	frame, err := reader.Next()
+	assert.NoError(err)
+	assert.Equal([]byte{0xFC, 1, 2}, frame)
+
+	frame, err = reader.Next()
+	assert.NoError(err)
+	assert.Equal([]byte{0xFC}, frame)
+}
+
+func TestRTPDepacketizer(t *testing.T) {
+	assert := assert.New(t)
+
+	packet := func(seq uint16, ts uint32, payload ...byte) []byte {
+		pkt := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    111,
+				SequenceNumber: seq,
+				Timestamp:      ts,
+			},
+			Payload: payload,
+		}
+
+		raw, _ := pkt.Marshal()
+		return raw
+	}
+
+	d := &rtpDepacketizer{frameDuration: 20 * time.Millisecond}
+
+	// the first packet lasts the frame duration
+	payload, duration, err := d.Depacketize(packet(10, 1000, 0xFC, 1))
+	assert.NoError(err)
+	assert.Equal([]byte{0xFC, 1}, payload)
+	assert.Equal(20*time.Millisecond, duration)
+
+	// then the timestamps tell
+	_, duration, err = d.Depacketize(packet(11, 1000+480, 0xFC, 2))
+	assert.NoError(err)
+	assert.Equal(10*time.Millisecond, duration)
+
+	// the timestamp wraps around
+	d.lastTimestamp = 0xFFFFFF00
+	_, duration, err = d.Depacketize(packet(12, 0x000002C0, 0xFC, 3))
+	assert.NoError(err)
+	assert.Equal(20*time.Millisecond, duration)
+
+	// a gap falls back to the frame duration
+	_, duration, err = d.Depacketize(packet(20, 50000, 0xFC, 4))
+	assert.NoError(err)
+	assert.Equal(20*time.Millisecond, duration)
+
+	_, _, err = d.Depacketize([]byte{0x80})
+	assert.Error(err)
+}
@@ -0,0 +1,420 @@
+package game
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Pacing bounds the video frames queued for each peer of an H264 stream.
+// Frames are held in the queue while the peer's receiver reports lag more
+// than Window behind what was sent. When a peer's network stalls,
+// non-reference frames are dropped first; if the queue still overflows it
+// is flushed and the peer waits for a keyframe, so its latency stays
+// bounded.
+type Pacing struct {
+	Disabled bool
+	Queue    int // frames
+	Window   time.Duration
+}
+
+func (cfg *Pacing) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Disabled bool          `yaml:"disabled"`
+		Queue    int           `yaml:"queue"`
+		Window   time.Duration `yaml:"window"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Queue <= 0 {
+		raw.Queue = defaultPacing.Queue
+	}
+
+	if raw.Window < 0 {
+		return errors.New("pacing window must not be negative")
+	}
+
+	if raw.Window == 0 {
+		raw.Window = defaultPacing.Window
+	}
+
+	cfg.Disabled = raw.Disabled
+	cfg.Queue = raw.Queue
+	cfg.Window = raw.Window
+
+	return nil
+}
+
+// Browsers send a receiver report about every second, so the window
+// leaves room for one and the round trip.
+var defaultPacing = &Pacing{
+	Queue:  8,
+	Window: 2 * time.Second,
+}
+
+// PacingStats counts what a peer's pacer dropped, and how often it held
+// frames back for the peer to catch up.
+type PacingStats struct {
+	Queued           int    `json:"queued"`
+	Dropped          uint64 `json:"dropped"`
+	KeyframeRequests uint64 `json:"keyframe_requests"`
+	Holds            uint64 `json:"holds"`
+}
+
+// pacedFrame is a queued frame. skip is the duration of the frames dropped
+// right before it, which its timestamp still accounts for.
+type pacedFrame struct {
+	data      []byte
+	duration  time.Duration
+	skip      time.Duration
+	keyframe  bool
+	reference bool
+}
+
+// packetWriter sends the packets of a pacer.
+type packetWriter interface {
+	WriteRTP(p *rtp.Packet) error
+}
+
+// sentPacket is a packet the peer has not acknowledged yet.
+type sentPacket struct {
+	seq uint16
+	at  time.Time
+}
+
+// peerPacer feeds a peer its own video track from a bounded queue. It
+// packetizes the frames itself, so dropped frames leave a gap in the RTP
+// timestamps but none in the sequence numbers.
+//
+// Writing to the track never blocks, so the peer's receiver reports tell
+// how far behind it is: once they arrive, the pacer holds the queued
+// frames while the oldest packet they have not acknowledged was sent more
+// than the window ago. A stalled peer then fills the queue, rather than
+// the network, and the drop policy applies.
+type peerPacer struct {
+	log             *zap.Logger
+	track           *webrtc.TrackLocalStaticRTP
+	writer          packetWriter // the track, replaced in tests
+	packetizer      rtp.Packetizer
+	capacity        int
+	window          time.Duration
+	now             func() time.Time // of the clock, replaced in tests
+	requestKeyframe func()
+
+	queue   []*pacedFrame
+	skip    time.Duration // dropped since the last queued frame
+	waiting bool          // for a keyframe, dropping other frames
+	started bool
+	closed  bool
+	stats   PacingStats
+	cond    *sync.Cond
+
+	// sent are the packets not acknowledged yet, oldest first, recorded
+	// once the peer reports.
+	sent     []sentPacket
+	reported bool
+	highest  uint16 // sequence number the peer last reported
+	holding  bool
+	sync.Mutex
+}
+
+func newPeerPacer(cfg *Pacing, video *VideoTrack, id, streamID string, log *zap.Logger) (*peerPacer, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{
+			MimeType: video.Codec().MimeType(),
+		}, id, streamID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peerPacer{
+		log:        log.With(zap.String("handler", "pacer")),
+		track:      track,
+		writer:     track,
+		packetizer: rtp.NewPacketizer(rtpMTU, 0, 0, &codecs.H264Payloader{}, rtp.NewRandomSequencer(), videoClockRate),
+		capacity:   cfg.Queue,
+		window:     cfg.Window,
+		now:        time.Now,
+		waiting:    true, // frames before the first keyframe are undecodable
+	}
+
+	if p.window <= 0 {
+		p.window = defaultPacing.Window
+	}
+
+	p.cond = sync.NewCond(&p.Mutex)
+
+	return p, nil
+}
+
+const (
+	// rtpMTU bounds the packets a pacer sends, as pion does its own.
+	rtpMTU = 1200
+
+	videoClockRate = 90000
+)
+
+// Track returns the peer's video track.
+func (p *peerPacer) Track() webrtc.TrackLocal {
+	return p.track
+}
+
+// WriteSample queues a frame of the stream.
+func (p *peerPacer) WriteSample(sample media.Sample) error {
+	frame := &pacedFrame{duration: sample.Duration}
+	frame.keyframe, frame.reference = classifyH264(sample.Data)
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	if frame.keyframe {
+		p.waiting = false
+	}
+
+	if p.waiting {
+		p.drop(frame)
+		return nil
+	}
+
+	if len(p.queue) >= p.capacity && !p.dropNonReference() {
+		// A keyframe makes the queued frames needless.
+		p.discard()
+
+		if !frame.keyframe {
+			p.waitKeyframe()
+			p.drop(frame)
+			return nil
+		}
+	}
+
+	frame.data = bytes.Clone(sample.Data)
+	frame.skip = p.skip
+	p.skip = 0
+	p.started = true
+
+	p.queue = append(p.queue, frame)
+	p.cond.Signal()
+
+	return nil
+}
+
+// drop drops a frame that was not queued.
+func (p *peerPacer) drop(frame *pacedFrame) {
+	p.stats.Dropped++
+
+	// Nothing was sent yet whose timestamp the frame follows.
+	if p.started {
+		p.skip += frame.skip + frame.duration
+	}
+}
+
+// dropNonReference drops the oldest queued frame no other refers to.
+func (p *peerPacer) dropNonReference() bool {
+	for i, frame := range p.queue {
+		if frame.reference {
+			continue
+		}
+
+		if i+1 < len(p.queue) {
+			p.queue[i+1].skip += frame.skip + frame.duration
+		} else {
+			p.skip += frame.skip + frame.duration
+		}
+
+		p.queue = append(p.queue[:i], p.queue[i+1:]...)
+		p.stats.Dropped++
+
+		return true
+	}
+
+	return false
+}
+
+// discard drops the queued frames.
+func (p *peerPacer) discard() {
+	for _, frame := range p.queue {
+		p.skip += frame.skip + frame.duration
+	}
+
+	p.stats.Dropped += uint64(len(p.queue))
+	p.queue = p.queue[:0]
+}
+
+// resync drops the queued frames and waits for a keyframe, as the pacer
+// is fed from another source.
+func (p *peerPacer) resync() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.discard()
+	p.waiting = true
+}
+
+// waitKeyframe drops frames until a keyframe, asking the source for one
+// when it can.
+func (p *peerPacer) waitKeyframe() {
+	p.waiting = true
+
+	p.log.Warn("video queue overflowed, waiting for a keyframe",
+		zap.Int("queue", p.capacity))
+
+	if p.requestKeyframe != nil {
+		p.stats.KeyframeRequests++
+		p.requestKeyframe()
+	}
+}
+
+// Run sends the queued frames until the pacer closes, holding them while
+// the peer lags behind.
+func (p *peerPacer) Run() {
+	for {
+		p.Lock()
+
+		for !p.closed && (len(p.queue) == 0 || p.lagging()) {
+			p.cond.Wait()
+		}
+
+		if p.closed {
+			p.Unlock()
+			return
+		}
+
+		frame := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+
+		p.Unlock()
+
+		p.send(frame)
+	}
+}
+
+func (p *peerPacer) send(frame *pacedFrame) {
+	if frame.skip > 0 {
+		p.packetizer.SkipSamples(clockSamples(frame.skip))
+	}
+
+	var sent []sentPacket
+	for _, pkt := range p.packetizer.Packetize(frame.data, clockSamples(frame.duration)) {
+		if err := p.writer.WriteRTP(pkt); err != nil {
+			p.log.Debug("rtp write failed", zap.Error(err))
+			break
+		}
+
+		sent = append(sent, sentPacket{pkt.SequenceNumber, p.now()})
+	}
+
+	p.Lock()
+	if p.reported {
+		p.sent = append(p.sent, sent...)
+	}
+	p.Unlock()
+}
+
+// lagging reports whether the oldest packet the peer has not acknowledged
+// was sent more than the window ago, and counts the holds it starts.
+func (p *peerPacer) lagging() bool {
+	lagging := len(p.sent) > 0 && p.now().Sub(p.sent[0].at) > p.window
+
+	if lagging && !p.holding {
+		p.stats.Holds++
+
+		p.log.Debug("peer lagging, holding video",
+			zap.Int("queue", len(p.queue)))
+	}
+
+	p.holding = lagging
+
+	return lagging
+}
+
+// acknowledge takes the highest sequence number a receiver report of the
+// peer's video tells it received. A report that tells of nothing new
+// while the pacer holds means the packets since were lost.
+func (p *peerPacer) acknowledge(highest uint32) {
+	seq := uint16(highest)
+
+	p.Lock()
+	defer p.Unlock()
+
+	progress := !p.reported || seq != p.highest
+	p.reported = true
+	p.highest = seq
+
+	n := 0
+	for _, pkt := range p.sent {
+		if int16(seq-pkt.seq) < 0 {
+			break
+		}
+
+		n++
+	}
+
+	p.sent = p.sent[n:]
+
+	if !progress && p.holding {
+		p.sent = nil
+	}
+
+	p.cond.Broadcast()
+}
+
+func clockSamples(d time.Duration) uint32 {
+	return uint32(d.Seconds() * videoClockRate)
+}
+
+func (p *peerPacer) Stats() PacingStats {
+	p.Lock()
+	defer p.Unlock()
+
+	stats := p.stats
+	stats.Queued = len(p.queue)
+
+	return stats
+}
+
+func (p *peerPacer) Close() {
+	p.Lock()
+	p.closed = true
+	p.queue = nil
+	p.sent = nil
+	p.Unlock()
+
+	p.cond.Broadcast()
+}
+
+// classifyH264 tells whether an access unit is a keyframe, and whether
+// other frames may refer to it: any of its slices has a nonzero
+// nal_ref_idc.
+func classifyH264(data []byte) (keyframe, reference bool) {
+	for _, nal := range splitAnnexB(data) {
+		switch nalType(nal) {
+		case nalTypeIDR:
+			return true, true
+
+		case nalTypeSlice:
+			if nal[0]>>5&0x03 != 0 {
+				reference = true
+			}
+		}
+	}
+
+	return false, reference
+}
@@ -0,0 +1,238 @@
+package game
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPacingUnmarshalYAML(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg Pacing
+	err := yaml.Unmarshal([]byte("{}"), &cfg)
+	if assert.NoError(err) {
+		assert.Equal(*defaultPacing, cfg)
+	}
+
+	err = yaml.Unmarshal([]byte("{queue: 4, window: 500ms}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(Pacing{Queue: 4, Window: 500 * time.Millisecond}, cfg)
+
+	err = yaml.Unmarshal([]byte("window: -1s"), &cfg)
+	assert.Error(err)
+}
+
+func TestPacerDropPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		idr     = []byte{0, 0, 0, 1, 0x65, 0x88}
+		ref     = []byte{0, 0, 0, 1, 0x41, 0x9A} // nal_ref_idc 2
+		nonRef  = []byte{0, 0, 0, 1, 0x01, 0x9E} // nal_ref_idc 0
+		frame   = 10 * time.Millisecond
+		request int
+	)
+
+	video := &VideoTrack{codec: CodecH264}
+	p, err := newPeerPacer(&Pacing{Queue: 3}, video, "video", "stream", zap.NewNop())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	p.requestKeyframe = func() { request++ }
+
+	write := func(data []byte) {
+		p.WriteSample(media.Sample{Data: data, Duration: frame})
+	}
+
+	// frames before the first keyframe are dropped, without a gap
+	write(ref)
+	assert.Empty(p.queue)
+	assert.Zero(p.skip)
+
+	write(idr)
+	write(nonRef)
+	write(ref)
+	assert.Len(p.queue, 3)
+
+	// a full queue drops its non-reference frames first
+	write(ref)
+	assert.Len(p.queue, 3)
+	assert.Equal(2*frame, p.queue[1].skip+p.queue[1].duration)
+	assert.Equal(uint64(2), p.Stats().Dropped)
+
+	// then flushes and waits for a keyframe
+	write(ref)
+	assert.Empty(p.queue)
+	assert.True(p.waiting)
+	assert.Equal(1, request)
+
+	write(ref)
+	assert.Empty(p.queue)
+
+	// the keyframe's timestamp accounts for the frames dropped before it
+	write(idr)
+	assert.Len(p.queue, 1)
+	assert.False(p.waiting)
+	assert.Equal(6*frame, p.queue[0].skip)
+
+	stats := p.Stats()
+	assert.Equal(1, stats.Queued)
+	assert.Equal(uint64(7), stats.Dropped)
+	assert.Equal(uint64(1), stats.KeyframeRequests)
+
+	// a keyframe replaces a full queue of reference frames
+	write(ref)
+	write(ref)
+	write(idr)
+	assert.Len(p.queue, 1)
+	assert.False(p.waiting)
+	assert.Equal(1, request)
+
+	p.Close()
+	write(idr)
+	assert.Empty(p.queue)
+}
+
+// stalledWriter takes the packets of a peer whose network stalled: none of
+// them is acknowledged until the test says so.
+type stalledWriter struct {
+	packets []*rtp.Packet
+	sync.Mutex
+}
+
+func (w *stalledWriter) WriteRTP(p *rtp.Packet) error {
+	w.Lock()
+	defer w.Unlock()
+
+	w.packets = append(w.packets, p)
+	return nil
+}
+
+func (w *stalledWriter) Sent() []*rtp.Packet {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.packets
+}
+
+func TestPacerStalledPeer(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		idr     = []byte{0, 0, 0, 1, 0x65, 0x88}
+		ref     = []byte{0, 0, 0, 1, 0x41, 0x9A}
+		request int
+	)
+
+	video := &VideoTrack{codec: CodecH264}
+	p, err := newPeerPacer(&Pacing{Queue: 3, Window: time.Second}, video, "video", "stream", zap.NewNop())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var clock struct {
+		now time.Time
+		sync.Mutex
+	}
+
+	clock.now = time.Unix(1_700_000_000, 0)
+	advance := func(d time.Duration) {
+		clock.Lock()
+		clock.now = clock.now.Add(d)
+		clock.Unlock()
+	}
+
+	writer := &stalledWriter{}
+	p.writer = writer
+	p.now = func() time.Time {
+		clock.Lock()
+		defer clock.Unlock()
+
+		return clock.now
+	}
+
+	p.requestKeyframe = func() { request++ }
+
+	write := func(data []byte) {
+		p.WriteSample(media.Sample{Data: data, Duration: 10 * time.Millisecond})
+	}
+
+	go p.Run()
+	defer p.Close()
+
+	// the peer reports before the first frame is sent
+	p.acknowledge(0)
+
+	write(idr)
+	assert.Eventually(func() bool { return len(writer.Sent()) == 1 }, time.Second, time.Millisecond)
+
+	// nothing is acknowledged for longer than the window, so frames queue up
+	advance(2 * time.Second)
+	write(ref)
+	assert.Eventually(func() bool { return p.Stats().Holds == 1 }, time.Second, time.Millisecond)
+	assert.Equal(1, p.Stats().Queued)
+
+	write(ref)
+	write(ref)
+	write(ref)
+	assert.Zero(p.Stats().Queued)
+	assert.Equal(1, request)
+	assert.Len(writer.Sent(), 1)
+
+	// once the peer catches up, the next keyframe is sent
+	p.acknowledge(uint32(writer.Sent()[0].SequenceNumber))
+
+	write(idr)
+	assert.Eventually(func() bool { return len(writer.Sent()) == 2 }, time.Second, time.Millisecond)
+
+	// a report of nothing new while holding gives up on the packets since
+	advance(2 * time.Second)
+	write(ref)
+	assert.Eventually(func() bool { return p.Stats().Holds == 2 }, time.Second, time.Millisecond)
+	assert.Len(writer.Sent(), 2)
+
+	p.acknowledge(uint32(writer.Sent()[0].SequenceNumber))
+	assert.Eventually(func() bool { return len(writer.Sent()) == 3 }, time.Second, time.Millisecond)
+}
+
+func TestPacerAcknowledge(t *testing.T) {
+	assert := assert.New(t)
+
+	p := &peerPacer{reported: true, highest: 65533}
+	p.cond = sync.NewCond(&p.Mutex)
+
+	p.sent = []sentPacket{{seq: 65534}, {seq: 65535}, {seq: 0}}
+
+	// the extended sequence number wraps along with the packets'
+	p.acknowledge(1<<16 | 65535)
+	assert.Equal([]sentPacket{{seq: 0}}, p.sent)
+
+	p.acknowledge(2 << 16)
+	assert.Empty(p.sent)
+}
+
+func TestClassifyH264(t *testing.T) {
+	assert := assert.New(t)
+
+	keyframe, reference := classifyH264([]byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x65, 0x88})
+	assert.True(keyframe)
+	assert.True(reference)
+
+	keyframe, reference = classifyH264([]byte{0, 0, 0, 1, 0x41, 0x9A})
+	assert.False(keyframe)
+	assert.True(reference)
+
+	keyframe, reference = classifyH264([]byte{0, 0, 0, 1, 0x01, 0x9E})
+	assert.False(keyframe)
+	assert.False(reference)
+}
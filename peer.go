@@ -0,0 +1,810 @@
+package game
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/session"
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+type Peer struct {
+	*webrtc.PeerConnection
+	id      string
+	log     *zap.Logger
+	sub     *nats.Subscription
+	perms   Permissions
+	guest   string // guest token ID, if joined with a guest link
+	stream  *Stream
+	video   *VideoTrack // of the codec negotiated, or the rendition chosen
+	group   *PeerGroup
+	gamepad Gamepad
+	input   Input // nil when the host cannot inject keyboard and mouse
+	files   *FileDrop
+	router  *DataChannelRouter
+	events  *EventBus
+	metrics *ChannelMetrics
+
+	// mode selects the media; in stills mode, snapshot provides the stills
+	// sent every stillsInterval.
+	mode           PeerMode
+	stillsInterval time.Duration
+	snapshot       func(ctx context.Context, opts SnapshotOptions) (*Snapshot, error)
+	preview        *previewer
+	videoSender    *webrtc.RTPSender
+	audioSender    *webrtc.RTPSender
+	videoPaused    bool
+	audioMuted     bool
+	pacer          *peerPacer    // nil when the peer shares the stream's video track
+	latency        *latencyProbe // once the client opens the latency channel
+
+	// slot is the controller slot owned by a co-play guest; 0 for peers
+	// sharing the stream's controller.
+	slot     int
+	gamepads *GamepadManager
+
+	// requireTalk makes the peer's microphone heard only while talking is
+	// held by push-to-talk.
+	requireTalk bool
+	talking     atomic.Bool
+
+	downgrades  []Downgrade
+	channels    map[string]*monitoredChannel
+	control     *monitoredChannel
+	lastButtons uint16
+	reports     *reportLimiter
+	deadzone    *Deadzone
+	path        *ConnectionPath
+	restarter   *iceRestarter // nil when ICE restarts are disabled
+	dtls        *dtlsHandshake
+	sess        *SessionDescriptor
+	sessions    sessionStore // nil when sessions are not persisted
+
+	// hotkeys are those of the access list; guests have none.
+	hotkeys []*Hotkey
+
+	// signaling negotiates the connection; after the first negotiation the
+	// server's offers go out through renegotiation and offers subscribes to
+	// the client's.
+	signaling     *session.Session
+	renegotiation session.Signal
+	offers        *nats.Subscription
+	findStream    func(name string) (*Stream, error)
+	addVideo      func() error
+	attached      map[string][]*webrtc.RTPSender // by stream name
+	closeOnce     sync.Once
+	connected     atomic.Bool // once connected, the connection deadline is met
+
+	// ctx ends when the peer closes, abandoning the negotiations under way.
+	ctx    context.Context
+	cancel context.CancelFunc
+	sync.RWMutex
+}
+
+func (peer *Peer) ID() string {
+	return peer.id
+}
+
+// RequestID returns the request ID of the peer's negotiation.
+func (peer *Peer) RequestID() string {
+	if peer.sess == nil {
+		return ""
+	}
+
+	return peer.sess.RequestID
+}
+
+// Downgrades returns where the peer gets less than the stream's preferred
+// configuration.
+func (peer *Peer) Downgrades() []Downgrade {
+	return peer.downgrades
+}
+
+func (peer *Peer) Init() {
+	log := peer.log
+
+	peer.channels = make(map[string]*monitoredChannel)
+
+	peer.watchPath()
+
+	if peer.restarter != nil {
+		peer.OnICEConnectionStateChange(peer.restarter.Update)
+	}
+
+	peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Info("connection state updated",
+			zap.String("state", state.String()))
+
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			peer.connected.Store(true)
+
+			// The new peer starts from a keyframe, which sources such as
+			// NVStream hosts send only on request.
+			if stream := peer.stream; stream != nil {
+				go stream.requestKeyframe(context.Background())
+			}
+
+			peer.logDTLS()
+
+			peer.events.Publish(EventPeerConnected, peer.event())
+
+		case webrtc.PeerConnectionStateFailed:
+			// A failed ICE is left to the restarter.
+			if peer.restarter == nil || peer.ICEConnectionState() != webrtc.ICEConnectionStateFailed {
+				peer.Close()
+			}
+
+		case webrtc.PeerConnectionStateClosed:
+			peer.Close()
+		}
+	})
+
+	peer.OnDataChannel(func(dc *webrtc.DataChannel) {
+		label := dc.Label()
+
+		reject := func(reason string) {
+			log.Warn("data channel rejected",
+				zap.String("label", label),
+				zap.String("reason", reason))
+
+			dc.Close()
+		}
+
+		perm := LabelPermission(label)
+		if !peer.perms.Has(perm) {
+			reject("permission denied")
+			return
+		}
+
+		// Input keeps an idle stream awake.
+		input := perm&(PermissionGamepad|PermissionKeyboard|PermissionMouse) != 0
+
+		route, open, ok := peer.router.match(label)
+		if !ok {
+			reject("unknown label")
+			return
+		}
+
+		// Channels are known by label, so a label is open once at a time,
+		// and the channels map holds exactly those open.
+		peer.Lock()
+		if _, ok := peer.channels[label]; ok {
+			peer.Unlock()
+			reject("label already open")
+			return
+		}
+
+		if len(peer.channels) >= MaxDataChannels {
+			peer.Unlock()
+			reject("too many data channels")
+			return
+		}
+
+		mc := newMonitoredChannel(dc, log)
+		mc.metrics = peer.metrics.label(route)
+
+		peer.channels[label] = mc
+		peer.Unlock()
+
+		dc.OnClose(func() {
+			peer.removeChannel(mc)
+			mc.closed()
+		})
+
+		handle, err := open(peer, dc)
+		if err != nil {
+			peer.removeChannel(mc)
+			reject(err.Error())
+			return
+		}
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			if input {
+				peer.stream.lastInput.Store(time.Now().UnixNano())
+			}
+
+			mc.receive(msg, handle)
+		})
+	})
+}
+
+func (peer *Peer) gamepadHandler(data []byte) error {
+	received := time.Now()
+
+	log := peer.log.With(
+		zap.String("handler", "gamepad"),
+	)
+
+	if peer.slot == 0 && !peer.group.CanControl(peer) {
+		return ErrDiscardedMessage
+	}
+
+	if len(data) < 12 {
+		log.Warn("invalid gamepad report", zap.Int("length", len(data)))
+		return ErrMalformedMessage
+	}
+
+	buttons := binary.BigEndian.Uint16(data[0:2])
+
+	prev := peer.lastButtons
+	peer.lastButtons = buttons
+
+	// Buttons held as part of a hotkey are not forwarded to the game.
+	for _, hotkey := range peer.hotkeys {
+		if !hotkey.IsGamepad() || buttons&hotkey.Buttons != hotkey.Buttons {
+			continue
+		}
+
+		if hotkey.MatchButtons(prev, buttons) {
+			peer.hotkeyHandler(hotkey)
+		}
+
+		buttons &^= hotkey.Buttons
+	}
+
+	report := NewXBoxGamepadReport(
+		buttons,
+		data[2],
+		data[3],
+		int16(binary.BigEndian.Uint16(data[4:6])),
+		int16(binary.BigEndian.Uint16(data[6:8])),
+		int16(binary.BigEndian.Uint16(data[8:10])),
+		int16(binary.BigEndian.Uint16(data[10:12])),
+	)
+
+	if len(data) >= DS4ReportLength {
+		ds4, err := withDS4Extension(report, data)
+		if err != nil {
+			log.Warn("invalid ds4 extension", zap.Int("touches", int(data[25])))
+			return err
+		}
+
+		report = ds4
+	}
+
+	if peer.reports != nil {
+		// Latency is only observed for reports submitted right away;
+		// deferred ones wait for the rate limit on purpose.
+		submitted, err := peer.reports.Submit(report, received)
+		if err != nil || !submitted {
+			return err
+		}
+	} else if err := peer.submitReport(report); err != nil {
+		return err
+	}
+
+	// Slow injection points at cgo contention or a slow host, not the network.
+	latency := time.Since(received)
+	if peer.gamepads.ObserveLatency(peer.slot, latency) {
+		log.Warn("input latency over budget",
+			zap.Int("slot", max(peer.slot, 1)),
+			zap.Duration("latency", latency))
+	}
+
+	return nil
+}
+
+func (peer *Peer) submitReport(report GamepadReport) error {
+	if err := peer.gamepad.Update(peer.deadzone.Apply(report)); err != nil {
+		peer.log.Error(err.Error(), zap.String("handler", "gamepad"))
+		return err
+	}
+
+	return nil
+}
+
+// motionHandler forwards motion sensor readings to gamepads that take them,
+// at most at the rate the host asked for.
+func (peer *Peer) motionHandler(data []byte) error {
+	if peer.slot == 0 && !peer.group.CanControl(peer) {
+		return ErrDiscardedMessage
+	}
+
+	event, err := ParseMotionEvent(data)
+	if err != nil {
+		peer.log.Warn(err.Error(), zap.String("handler", "motion"))
+		return ErrMalformedMessage
+	}
+
+	sender, ok := peer.gamepad.(MotionSender)
+	if !ok {
+		return ErrDiscardedMessage
+	}
+
+	return sender.SendMotion(event)
+}
+
+// keyboardHandler handles key events encoded as a big-endian virtual-key
+// code (2 bytes), a key action (1 byte) and the modifier flags (1 byte).
+func (peer *Peer) keyboardHandler(data []byte) error {
+	log := peer.log.With(
+		zap.String("handler", "keyboard"),
+	)
+
+	if len(data) < 4 {
+		log.Warn("invalid keyboard event", zap.Int("length", len(data)))
+		return ErrMalformedMessage
+	}
+
+	keyCode := binary.BigEndian.Uint16(data[0:2])
+	keyAction := data[2]
+	modifiers := data[3]
+
+	for _, hotkey := range peer.hotkeys {
+		if hotkey.MatchKey(keyCode, modifiers) {
+			if keyAction == moonlight.KEY_ACTION_DOWN {
+				peer.hotkeyHandler(hotkey)
+			}
+
+			return nil
+		}
+	}
+
+	var err error
+	switch {
+	case peer.stream.Transport == TransportNV:
+		err = moonlight.SendKeyboardEvent(int16(keyCode), keyAction, modifiers)
+
+	case peer.input != nil:
+		err = peer.input.InjectKey(keyCode, keyAction == moonlight.KEY_ACTION_DOWN)
+
+	default:
+		log.Warn("keyboard input unsupported",
+			zap.String("transport", string(peer.stream.Transport)))
+
+		return ErrDiscardedMessage
+	}
+
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	return err
+}
+
+// mouseHandler handles the pointer events of the mouse channel, encoded as
+// described by MouseEvent.
+func (peer *Peer) mouseHandler(data []byte) error {
+	log := peer.log.With(
+		zap.String("handler", "mouse"),
+	)
+
+	if peer.input == nil {
+		log.Warn("mouse input unsupported",
+			zap.String("transport", string(peer.stream.Transport)))
+		return ErrDiscardedMessage
+	}
+
+	event, err := ParseMouseEvent(data)
+	if err != nil {
+		log.Warn(err.Error(), zap.Int("length", len(data)))
+		return ErrMalformedMessage
+	}
+
+	if err := event.Inject(peer.input); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (peer *Peer) hotkeyHandler(hotkey *Hotkey) {
+	log := peer.log.With(
+		zap.String("handler", "hotkey"),
+		zap.String("combo", hotkey.Combo),
+		zap.String("action", string(hotkey.Action)),
+	)
+
+	switch hotkey.Action {
+	case HotkeyQuit:
+		conn := peer.stream.conn
+		if conn == nil {
+			log.Warn("no application to quit")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := conn.StopApp(ctx); err != nil {
+			log.Error(err.Error())
+			peer.sendControlError("", NewControlError(ControlErrFailed, err.Error()))
+			return
+		}
+
+	case HotkeySaveReplay:
+		rec := peer.stream.recorder
+		if rec == nil {
+			log.Warn("instant replay disabled")
+			peer.sendControlError("", NewControlError(ControlErrUnavailable, "instant replay disabled"))
+			return
+		}
+
+		// Writing the file must not hold up the input.
+		go func() {
+			path, err := rec.SaveReplay()
+			if err != nil {
+				log.Error(err.Error())
+				peer.sendControlError("", NewControlError(ControlErrFailed, err.Error()))
+				return
+			}
+
+			msg, err := NewControlMessage(ControlReplaySaved, &ReplaySaved{filepath.Base(path)})
+			if err != nil {
+				return
+			}
+
+			peer.SendControl(msg)
+		}()
+
+	case HotkeyToggleStats:
+		msg, err := NewControlMessage(ControlStatsToggle, nil)
+		if err != nil {
+			return
+		}
+
+		peer.SendControl(msg)
+	}
+
+	log.Info("hotkey triggered")
+}
+
+const MaxTextInputLength = 1024
+
+// textHandler injects a composed (IME-friendly) string into the host,
+// e.g. a password typed on a mobile client.
+func (peer *Peer) textHandler(data []byte) error {
+	log := peer.log.With(
+		zap.String("handler", "text"),
+	)
+
+	if len(data) > MaxTextInputLength {
+		log.Warn("text input too long", zap.Int("length", len(data)))
+		return ErrMalformedMessage
+	}
+
+	if !utf8.Valid(data) {
+		log.Warn("text input is not valid utf-8")
+		return ErrMalformedMessage
+	}
+
+	switch peer.stream.Transport {
+	case TransportNV:
+		if err := moonlight.SendUTF8Text(string(data)); err != nil {
+			log.Error(err.Error())
+			return err
+		}
+
+		return nil
+
+	default:
+		log.Warn("text input unsupported",
+			zap.String("transport", string(peer.stream.Transport)))
+
+		return ErrDiscardedMessage
+	}
+}
+
+func (peer *Peer) controlHandler(data []byte) error {
+	log := peer.log.With(
+		zap.String("handler", "control"),
+	)
+
+	var msg *ControlMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Error(err.Error())
+		peer.sendControlError("", NewControlError(ControlErrBadRequest, err.Error()))
+		return ErrMalformedMessage
+	}
+
+	log = log.With(
+		zap.String("id", msg.ID),
+		zap.String("type", string(msg.Type)),
+	)
+
+	if err := peer.handleControl(msg); err != nil {
+		log.Warn("control request failed", zap.Error(err))
+		peer.sendControlError(msg.ID, ControlErrorFrom(err))
+		return nil
+	}
+
+	if msg.ID != "" {
+		peer.SendControl(&ControlMessage{
+			ID:   msg.ID,
+			Type: ControlAck,
+		})
+	}
+
+	log.Info("control request handled")
+
+	return nil
+}
+
+func (peer *Peer) handleControl(msg *ControlMessage) error {
+	switch msg.Type {
+	case ControlTakeover:
+		return peer.group.Takeover(peer)
+
+	case ControlRelease:
+		peer.group.Release(peer)
+		return nil
+
+	case ControlRendition:
+		return peer.handleRendition(msg)
+
+	case ControlQuality:
+		return peer.handleQuality(msg)
+
+	case ControlHDR:
+		return peer.handleHDR(msg)
+
+	case ControlKeyframe:
+		return peer.requestKeyframe()
+
+	case ControlPause, ControlResume:
+		return peer.pauseVideo(msg.Type == ControlPause)
+
+	case ControlMute, ControlUnmute:
+		return peer.muteAudio(msg.Type == ControlMute)
+
+	case ControlICERestart:
+		return peer.RestartICE()
+
+	case ControlMode:
+		return peer.handleMode(msg)
+
+	case ControlAttach, ControlDetach:
+		return peer.handleAttach(msg)
+
+	case ControlTalk, ControlTalkRelease:
+		return peer.pushToTalk(msg.Type == ControlTalk)
+
+	default:
+		return NewControlError(ControlErrUnsupported, "unsupported control message")
+	}
+}
+
+func (peer *Peer) sendControlError(id string, e *ControlErrorPayload) {
+	msg, err := NewControlMessage(ControlError, e)
+	if err != nil {
+		return
+	}
+
+	msg.ID = id
+
+	peer.SendControl(msg)
+}
+
+// SendControl sends a message to the peer over its control data channel.
+// Messages are dropped if the client has not opened the channel.
+func (peer *Peer) SendControl(msg *ControlMessage) error {
+	peer.RLock()
+	dc := peer.control
+	peer.RUnlock()
+
+	if dc == nil || !dc.Open() {
+		return nil
+	}
+
+	return dc.SendJSON(msg)
+}
+
+// removeChannel forgets a channel, unless another took its label since.
+func (peer *Peer) removeChannel(mc *monitoredChannel) {
+	peer.Lock()
+	defer peer.Unlock()
+
+	if peer.channels[mc.Label()] == mc {
+		delete(peer.channels, mc.Label())
+	}
+
+	if peer.control == mc {
+		peer.control = nil
+	}
+}
+
+// ChannelStats reports the send statistics of the peer's data channels,
+// including how often each was congested.
+func (peer *Peer) ChannelStats() []ChannelStats {
+	peer.RLock()
+	defer peer.RUnlock()
+
+	stats := make([]ChannelStats, 0, len(peer.channels))
+	for _, mc := range peer.channels {
+		stats = append(stats, mc.Stats())
+	}
+
+	return stats
+}
+
+func (peer *Peer) candidateUpdatedHandler() nats.MsgHandler {
+	log := peer.log.With(
+		zap.String("handler", "candidate_updated"),
+	)
+
+	return func(msg *nats.Msg) {
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal(msg.Data, &candidate); err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		if err := peer.signaling.AddCandidate(candidate); err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		log.Info("candidate added",
+			zap.String("candidate", candidate.Candidate))
+	}
+}
+
+func (peer *Peer) ICEConnectionStateChangeHandler(cancel context.CancelFunc) func(webrtc.ICEConnectionState) {
+	log := peer.log.With(
+		zap.String("handler", "ice_connection_state_change"),
+	)
+
+	return func(state webrtc.ICEConnectionState) {
+		log.Info("connection state has changed",
+			zap.String("state", state.String()))
+
+		if state == webrtc.ICEConnectionStateConnected {
+			cancel()
+		}
+	}
+}
+
+// Close releases the peer's signaling subscription, leaves its stream
+// group and closes the underlying peer connection. It is safe to call
+// more than once.
+// PeerInfo describes a connected peer for peers.list.
+type PeerInfo struct {
+	ID          string          `json:"id"`
+	Stream      string          `json:"stream"`
+	Permissions string          `json:"permissions"`
+	Guest       string          `json:"guest,omitempty"`
+	Mode        PeerMode        `json:"mode,omitempty"`
+	State       string          `json:"state"`
+	Path        *ConnectionPath `json:"path,omitempty"`
+	DTLS        *DTLSInfo       `json:"dtls"`
+	Pacing      *PacingStats    `json:"pacing,omitempty"`
+	VideoCodec  Codec           `json:"video_codec,omitempty"`
+	Rendition   string          `json:"rendition,omitempty"`
+	VideoPaused bool            `json:"video_paused,omitempty"`
+	AudioMuted  bool            `json:"audio_muted,omitempty"`
+}
+
+func (peer *Peer) Info() *PeerInfo {
+	peer.RLock()
+	path := peer.path
+	mode := peer.mode
+	pacer := peer.pacer
+	peer.RUnlock()
+
+	info := &PeerInfo{
+		ID:          peer.id,
+		Permissions: peer.perms.String(),
+		Guest:       peer.guest,
+		Mode:        mode,
+		State:       peer.ConnectionState().String(),
+		Path:        path,
+		DTLS:        peer.DTLS(),
+	}
+
+	if pacer != nil {
+		stats := pacer.Stats()
+		info.Pacing = &stats
+	}
+
+	if video := peer.Rendition(); video != nil && mode.Video() {
+		info.VideoCodec = video.Codec()
+		info.Rendition = video.Name()
+	}
+
+	peer.RLock()
+	info.VideoPaused = peer.videoPaused
+	info.AudioMuted = peer.audioMuted
+	peer.RUnlock()
+
+	if peer.stream != nil {
+		info.Stream = peer.stream.Name
+	}
+
+	return info
+}
+
+func (peer *Peer) event() *PeerEvent {
+	event := &PeerEvent{
+		Peer:        peer.id,
+		Permissions: peer.perms.String(),
+		Guest:       peer.guest,
+	}
+
+	if peer.stream != nil {
+		event.Stream = peer.stream.Name
+	}
+
+	return event
+}
+
+func (peer *Peer) Close() error {
+	var err error
+	peer.closeOnce.Do(func() {
+		if peer.cancel != nil {
+			peer.cancel()
+		}
+
+		peer.dropSession()
+
+		if peer.signaling != nil {
+			peer.signaling.Close()
+		}
+
+		if peer.sub != nil {
+			peer.sub.Unsubscribe()
+		}
+
+		if peer.offers != nil {
+			peer.offers.Unsubscribe()
+		}
+
+		if peer.group != nil {
+			peer.group.Remove(peer)
+		}
+
+		if peer.reports != nil {
+			peer.reports.Close()
+		}
+
+		if peer.restarter != nil {
+			peer.restarter.Close()
+		}
+
+		if peer.preview != nil {
+			peer.preview.Release()
+		}
+
+		peer.RLock()
+		pacer := peer.pacer
+		peer.RUnlock()
+
+		if pacer != nil {
+			peer.Rendition().RemoveSink(pacer)
+			pacer.Close()
+		}
+
+		if peer.slot > 0 {
+			peer.gamepads.Release(peer.slot, peer.id)
+		}
+
+		err = peer.PeerConnection.Close()
+
+		for _, stats := range peer.ChannelStats() {
+			peer.log.Info("data channel stats",
+				zap.String("label", stats.Label),
+				zap.Uint64("sent", stats.Sent),
+				zap.Uint64("dropped", stats.Dropped),
+				zap.Uint64("refused", stats.Refused),
+				zap.Uint64("congestions", stats.Congestions),
+				zap.Uint64("peak_buffered", stats.PeakBuffered))
+		}
+
+		peer.log.Info("peer closed")
+
+		peer.events.Publish(EventPeerDisconnected, peer.event())
+	})
+
+	return err
+}
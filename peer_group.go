@@ -0,0 +1,180 @@
+package game
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrTooManyPeers = errors.New("too many peers")
+
+// PeerGroup tracks the peers watching a stream. All peers share the stream's
+// local tracks; the group only enforces the connection limit and, in
+// exclusive mode, which single peer currently owns the controller.
+func NewPeerGroup(max int, exclusive bool) *PeerGroup {
+	return &PeerGroup{
+		max:       max,
+		exclusive: exclusive,
+		peers:     make([]*Peer, 0),
+	}
+}
+
+type PeerGroup struct {
+	max        int
+	exclusive  bool
+	peers      []*Peer
+	controller *Peer
+	watchers   []func(n int)
+	sync.RWMutex
+}
+
+func (g *PeerGroup) Add(peer *Peer) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.max > 0 && len(g.peers) >= g.max {
+		return ErrTooManyPeers
+	}
+
+	g.peers = append(g.peers, peer)
+
+	if g.exclusive && g.controller == nil && peer.perms.Has(PermissionGamepad) {
+		g.controller = peer
+	}
+
+	for _, watch := range g.watchers {
+		watch(len(g.peers))
+	}
+
+	return nil
+}
+
+func (g *PeerGroup) Remove(peer *Peer) {
+	g.Lock()
+
+	for i, p := range g.peers {
+		if p == peer {
+			g.peers = append(g.peers[:i], g.peers[i+1:]...)
+			break
+		}
+	}
+
+	changed := g.controller == peer
+	if changed {
+		g.controller = nil
+	}
+
+	for _, watch := range g.watchers {
+		watch(len(g.peers))
+	}
+
+	g.Unlock()
+
+	if changed {
+		g.announce()
+	}
+}
+
+// Watch calls watch with the number of peers whenever it changes, under the
+// group's lock so the calls arrive in order.
+func (g *PeerGroup) Watch(watch func(n int)) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.watchers = append(g.watchers, watch)
+}
+
+func (g *PeerGroup) Len() int {
+	g.RLock()
+	defer g.RUnlock()
+
+	return len(g.peers)
+}
+
+func (g *PeerGroup) Peers() []*Peer {
+	g.RLock()
+	defer g.RUnlock()
+
+	peers := make([]*Peer, len(g.peers))
+	copy(peers, g.peers)
+
+	return peers
+}
+
+// CanControl reports whether the peer may currently send gamepad input.
+func (g *PeerGroup) CanControl(peer *Peer) bool {
+	if !peer.perms.Has(PermissionGamepad) {
+		return false
+	}
+
+	if !g.exclusive {
+		return true
+	}
+
+	g.RLock()
+	defer g.RUnlock()
+
+	return g.controller == peer
+}
+
+// Takeover hands the controller to the peer and announces the change
+// to every peer in the group.
+func (g *PeerGroup) Takeover(peer *Peer) error {
+	if !peer.perms.Has(PermissionGamepad) {
+		return ErrPermissionDenied
+	}
+
+	if !g.exclusive {
+		return nil
+	}
+
+	g.Lock()
+	changed := g.controller != peer
+	g.controller = peer
+	g.Unlock()
+
+	if changed {
+		g.announce()
+	}
+
+	return nil
+}
+
+func (g *PeerGroup) Release(peer *Peer) {
+	g.Lock()
+	changed := g.controller == peer
+	if changed {
+		g.controller = nil
+	}
+	g.Unlock()
+
+	if changed {
+		g.announce()
+	}
+}
+
+func (g *PeerGroup) Controller() *Peer {
+	g.RLock()
+	defer g.RUnlock()
+
+	return g.controller
+}
+
+func (g *PeerGroup) Broadcast(msg *ControlMessage) {
+	for _, peer := range g.Peers() {
+		peer.SendControl(msg)
+	}
+}
+
+func (g *PeerGroup) announce() {
+	var changed ControllerChanged
+	if controller := g.Controller(); controller != nil {
+		changed.Peer = controller.id
+	}
+
+	msg, err := NewControlMessage(ControlControllerChanged, &changed)
+	if err != nil {
+		return
+	}
+
+	g.Broadcast(msg)
+}
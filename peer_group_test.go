@@ -0,0 +1,38 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerGroup(t *testing.T) {
+	assert := assert.New(t)
+
+	group := NewPeerGroup(2, true)
+
+	player := &Peer{id: "player", perms: PermissionAll}
+	spectator := &Peer{id: "spectator", perms: PermissionNone}
+	other := &Peer{id: "other", perms: PermissionGamepad}
+
+	assert.NoError(group.Add(player))
+	assert.NoError(group.Add(spectator))
+	assert.ErrorIs(group.Add(other), ErrTooManyPeers)
+
+	assert.Equal(player, group.Controller())
+	assert.True(group.CanControl(player))
+	assert.False(group.CanControl(spectator))
+	assert.ErrorIs(group.Takeover(spectator), ErrPermissionDenied)
+
+	group.Remove(spectator)
+	assert.NoError(group.Add(other))
+	assert.False(group.CanControl(other))
+
+	assert.NoError(group.Takeover(other))
+	assert.True(group.CanControl(other))
+	assert.False(group.CanControl(player))
+
+	group.Remove(other)
+	assert.Nil(group.Controller())
+	assert.Equal(1, group.Len())
+}
@@ -0,0 +1,105 @@
+package game
+
+import (
+	"errors"
+	"strings"
+)
+
+type Permissions uint8
+
+const (
+	PermissionGamepad Permissions = 1 << iota
+	PermissionKeyboard
+	PermissionMouse
+	PermissionFiles
+	PermissionMicrophone
+	PermissionCamera
+
+	PermissionNone Permissions = 0
+	PermissionAll  Permissions = PermissionGamepad | PermissionKeyboard | PermissionMouse | PermissionFiles |
+		PermissionMicrophone | PermissionCamera
+)
+
+// ParsePermissions parses a comma-separated permission list, e.g. "gamepad,mouse".
+// The special values "all" and "none" (or "watch") are also accepted.
+func ParsePermissions(s string) (Permissions, error) {
+	var perms Permissions
+	for _, p := range strings.Split(s, ",") {
+		switch strings.TrimSpace(p) {
+		case "all":
+			perms |= PermissionAll
+		case "none", "watch":
+			// no input permissions
+		case "gamepad":
+			perms |= PermissionGamepad
+		case "keyboard":
+			perms |= PermissionKeyboard
+		case "mouse":
+			perms |= PermissionMouse
+		case "files":
+			perms |= PermissionFiles
+		case "microphone":
+			perms |= PermissionMicrophone
+		case "camera":
+			perms |= PermissionCamera
+		default:
+			return PermissionNone, errors.New("invalid permission: " + p)
+		}
+	}
+
+	return perms, nil
+}
+
+func (perms Permissions) Has(perm Permissions) bool {
+	return perms&perm == perm
+}
+
+func (perms Permissions) String() string {
+	if perms == PermissionNone {
+		return "none"
+	}
+
+	var names []string
+	if perms.Has(PermissionGamepad) {
+		names = append(names, "gamepad")
+	}
+
+	if perms.Has(PermissionKeyboard) {
+		names = append(names, "keyboard")
+	}
+
+	if perms.Has(PermissionMouse) {
+		names = append(names, "mouse")
+	}
+
+	if perms.Has(PermissionFiles) {
+		names = append(names, "files")
+	}
+
+	if perms.Has(PermissionMicrophone) {
+		names = append(names, "microphone")
+	}
+
+	if perms.Has(PermissionCamera) {
+		names = append(names, "camera")
+	}
+
+	return strings.Join(names, ",")
+}
+
+// LabelPermission returns the permission required to open a data channel
+// with the given label. Labels that carry no input require no permission.
+func LabelPermission(label string) Permissions {
+	switch label {
+	case "gamepad", "motion":
+		return PermissionGamepad
+	case "keyboard", "text":
+		return PermissionKeyboard
+	case "mouse":
+		return PermissionMouse
+	case "files":
+		return PermissionFiles
+	default:
+		return PermissionNone
+	}
+}
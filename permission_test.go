@@ -0,0 +1,44 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePermissions(t *testing.T) {
+	assert := assert.New(t)
+
+	perms, err := ParsePermissions("gamepad,mouse")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(perms.Has(PermissionGamepad))
+	assert.True(perms.Has(PermissionMouse))
+	assert.False(perms.Has(PermissionKeyboard))
+	assert.Equal("gamepad,mouse", perms.String())
+
+	perms, err = ParsePermissions("watch")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(PermissionNone, perms)
+	assert.False(perms.Has(LabelPermission("gamepad")))
+	assert.True(perms.Has(LabelPermission("chat")))
+
+	perms, err = ParsePermissions("all")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(PermissionAll, perms)
+	assert.Equal("gamepad,keyboard,mouse,files,microphone,camera", perms.String())
+
+	_, err = ParsePermissions("admin")
+	assert.Error(err)
+}
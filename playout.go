@@ -0,0 +1,97 @@
+package game
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// playoutDelayURI is the header extension by which a sender bounds the
+// delay a browser's jitter buffer adds before rendering.
+const playoutDelayURI = "http://www.webrtc.org/experiments/rtp-hdrext/playout-delay"
+
+const (
+	playoutDelayUnit = 10 * time.Millisecond
+	playoutDelayMax  = 0x0FFF * playoutDelayUnit
+)
+
+// parseTargetLatency parses a stream's targetLatencyMs: the most a browser
+// should buffer its video, 0 to render frames as soon as they decode.
+func parseTargetLatency(ms int) (time.Duration, error) {
+	target := time.Duration(ms) * time.Millisecond
+	if target < 0 || target > playoutDelayMax {
+		return 0, errors.New("target latency out of range: " + strconv.Itoa(ms) + "ms")
+	}
+
+	return target, nil
+}
+
+// playoutDelay encodes the playout-delay extension: a minimum of zero and
+// a maximum of the target, 12 bits each, in units of 10 ms.
+func playoutDelay(target time.Duration) []byte {
+	delay := uint16(min(target, playoutDelayMax) / playoutDelayUnit)
+
+	return []byte{0, byte(delay >> 8), byte(delay)}
+}
+
+// playoutDelayInterceptor sets the playout-delay extension on the packets
+// of the video tracks that negotiated it.
+type playoutDelayInterceptor struct {
+	interceptor.NoOp
+	payload []byte
+}
+
+func (i *playoutDelayInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	var id uint8
+	for _, ext := range info.RTPHeaderExtensions {
+		if ext.URI == playoutDelayURI {
+			id = uint8(ext.ID)
+		}
+	}
+
+	if id == 0 {
+		return writer
+	}
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		// The header may be shared by the peers of a track.
+		h := *header
+		h.Extensions = slices.Clone(header.Extensions)
+
+		if err := h.SetExtension(id, i.payload); err != nil {
+			return 0, err
+		}
+
+		return writer.Write(&h, payload, attributes)
+	})
+}
+
+type playoutDelayFactory struct {
+	payload []byte
+}
+
+func (f *playoutDelayFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &playoutDelayInterceptor{payload: f.payload}, nil
+}
+
+// registerPlayoutDelay offers the playout-delay extension on video, which
+// the interceptor then sets to the target.
+func registerPlayoutDelay(m *webrtc.MediaEngine, registry *interceptor.Registry, target time.Duration) error {
+	err := m.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: playoutDelayURI},
+		webrtc.RTPCodecTypeVideo,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	registry.Add(&playoutDelayFactory{payload: playoutDelay(target)})
+
+	return nil
+}
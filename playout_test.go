@@ -0,0 +1,81 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTargetLatencyConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var stream Stream
+	err := yaml.Unmarshal([]byte("name: game\ntargetLatencyMs: 50"), &stream)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if assert.NotNil(stream.TargetLatency) {
+		assert.Equal(50*time.Millisecond, *stream.TargetLatency)
+	}
+
+	stream = Stream{}
+	err = yaml.Unmarshal([]byte("name: game"), &stream)
+	assert.NoError(err)
+	assert.Nil(stream.TargetLatency)
+
+	err = yaml.Unmarshal([]byte("name: game\ntargetLatencyMs: -1"), &stream)
+	assert.Error(err)
+
+	err = yaml.Unmarshal([]byte("name: game\ntargetLatencyMs: 50000"), &stream)
+	assert.Error(err)
+}
+
+func TestPlayoutDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]byte{0, 0, 0}, playoutDelay(0))
+	assert.Equal([]byte{0, 0, 5}, playoutDelay(50*time.Millisecond))
+	assert.Equal([]byte{0, 0x0F, 0xFF}, playoutDelay(playoutDelayMax))
+
+	var ext rtp.PlayoutDelayExtension
+	assert.NoError(ext.Unmarshal(playoutDelay(100 * time.Millisecond)))
+}
+
+func TestPlayoutDelayInterceptor(t *testing.T) {
+	assert := assert.New(t)
+
+	var written *rtp.Header
+	writer := interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		written = header
+		return len(payload), nil
+	})
+
+	i := &playoutDelayInterceptor{payload: playoutDelay(30 * time.Millisecond)}
+
+	// not negotiated
+	w := i.BindLocalStream(&interceptor.StreamInfo{}, writer)
+
+	header := &rtp.Header{}
+	_, err := w.Write(header, []byte{1}, nil)
+	assert.NoError(err)
+	assert.False(written.Extension)
+
+	w = i.BindLocalStream(&interceptor.StreamInfo{
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{
+			{URI: playoutDelayURI, ID: 4},
+		},
+	}, writer)
+
+	_, err = w.Write(header, []byte{1}, nil)
+	assert.NoError(err)
+	assert.Equal([]byte{0, 0, 3}, written.GetExtension(4))
+
+	// the shared header is left as it was
+	assert.False(header.Extension)
+}
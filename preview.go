@@ -0,0 +1,308 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/h264reader"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Preview configures a low-resolution, low-frame-rate rendition of a
+// stream, so dashboards tiling many streams need not pull full-bitrate
+// feeds. It is transcoded by an ffmpeg process while preview peers are
+// connected.
+type Preview struct {
+	Width   int
+	FPS     float64
+	Bitrate int // kbps
+}
+
+func (cfg *Preview) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Width   int     `yaml:"width"`
+		FPS     float64 `yaml:"fps"`
+		Bitrate int     `yaml:"bitrate"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Width == 0 {
+		raw.Width = 320
+	}
+
+	if raw.FPS == 0 {
+		raw.FPS = 5
+	}
+
+	if raw.Bitrate == 0 {
+		raw.Bitrate = 250
+	}
+
+	cfg.Width = raw.Width
+	cfg.FPS = raw.FPS
+	cfg.Bitrate = raw.Bitrate
+
+	return nil
+}
+
+// previewArgs transcodes an Annex B H264 stream on stdin to the preview
+// rendition on stdout, with an access unit delimiter before each frame.
+func previewArgs(cfg *Preview) []string {
+	return []string{
+		"-hide_banner", "-loglevel", "error",
+		"-fflags", "nobuffer", "-use_wallclock_as_timestamps", "1",
+		"-f", "h264", "-i", "pipe:0",
+		"-vf", "fps=" + strconv.FormatFloat(cfg.FPS, 'f', -1, 64) + ",scale=" + strconv.Itoa(cfg.Width) + ":-2",
+		"-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency",
+		"-profile:v", "baseline", "-b:v", strconv.Itoa(cfg.Bitrate) + "k",
+		"-g", strconv.Itoa(max(int(cfg.FPS*2), 1)),
+		"-bsf:v", "h264_metadata=aud=insert",
+		"-f", "h264", "pipe:1",
+	}
+}
+
+var startCode = []byte{0, 0, 0, 1}
+
+// previewer feeds a stream's video to the transcoder while peers watch the
+// preview track. Samples are queued so a slow transcoder never holds up the
+// stream; when the queue overflows, samples are dropped up to the next
+// keyframe.
+type previewer struct {
+	log             *zap.Logger
+	cfg             *Preview
+	ffmpeg          string
+	track           *webrtc.TrackLocalStaticSample
+	requestKeyframe func()
+
+	ctx     context.Context
+	viewers int
+	queue   chan []byte
+	synced  bool
+	cancel  context.CancelFunc
+	sync.Mutex
+}
+
+func newPreviewer(ctx context.Context, cfg *Preview, ffmpeg string, stream *Stream) (*previewer, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeH264,
+		}, stream.Name+"_preview", stream.Name,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &previewer{
+		log: zap.L().With(
+			zap.String("component", "previewer"),
+			zap.String("stream", stream.Name),
+		),
+		cfg:    cfg,
+		ffmpeg: ffmpeg,
+		track:  track,
+		ctx:    ctx,
+	}, nil
+}
+
+func (p *previewer) Track() webrtc.TrackLocal {
+	return p.track
+}
+
+// Acquire starts the transcoder for the first viewer.
+func (p *previewer) Acquire() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.viewers++
+	if p.viewers == 1 {
+		p.start()
+	}
+}
+
+// Release stops the transcoder once the last viewer left.
+func (p *previewer) Release() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.viewers--
+	if p.viewers == 0 && p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+		p.queue = nil
+	}
+}
+
+func (p *previewer) start() {
+	ctx, cancel := context.WithCancel(p.ctx)
+
+	cmd := exec.CommandContext(ctx, p.ffmpeg, previewArgs(p.cfg)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		p.log.Error(err.Error())
+		return
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		p.log.Error(err.Error())
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		p.log.Error(err.Error())
+		return
+	}
+
+	queue := make(chan []byte, 64)
+
+	p.cancel = cancel
+	p.queue = queue
+	p.synced = false
+
+	if p.requestKeyframe != nil {
+		p.requestKeyframe()
+	}
+
+	go p.feed(ctx, stdin, queue)
+	go p.read(stdout)
+
+	go func() {
+		err := cmd.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			err = errors.New(string(msg))
+		}
+
+		p.log.Warn("preview transcoder exited", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+
+		p.Lock()
+		defer p.Unlock()
+
+		if p.queue == queue {
+			cancel()
+			p.start()
+		}
+	}()
+}
+
+func (p *previewer) feed(ctx context.Context, stdin io.WriteCloser, queue <-chan []byte) {
+	defer stdin.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case data := <-queue:
+			if _, err := stdin.Write(data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// read writes each access unit of the transcoder's output as a sample.
+func (p *previewer) read(stdout io.Reader) {
+	reader, err := h264reader.NewReader(stdout)
+	if err != nil {
+		return
+	}
+
+	duration := time.Duration(float64(time.Second) / p.cfg.FPS)
+
+	var frame []byte
+	for {
+		nal, err := reader.NextNAL()
+		if err != nil {
+			return
+		}
+
+		if nalType(nal.Data) != nalTypeAUD {
+			frame = append(frame, startCode...)
+			frame = append(frame, nal.Data...)
+			continue
+		}
+
+		if len(frame) > 0 {
+			p.track.WriteSample(media.Sample{
+				Data:     frame,
+				Duration: duration,
+			})
+		}
+
+		frame = nil
+	}
+}
+
+// WriteSample queues the stream's samples while the transcoder runs,
+// starting from a keyframe.
+func (p *previewer) WriteSample(sample media.Sample) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.queue == nil {
+		return nil
+	}
+
+	nals := splitAnnexB(sample.Data)
+
+	if !p.synced {
+		for _, nal := range nals {
+			if t := nalType(nal); t == nalTypeSPS || t == nalTypeIDR {
+				p.synced = true
+				break
+			}
+		}
+
+		if !p.synced {
+			return nil
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, nal := range nals {
+		buf.Write(startCode)
+		buf.Write(nal)
+	}
+
+	select {
+	case p.queue <- buf.Bytes():
+	default:
+		p.synced = false
+
+		if p.requestKeyframe != nil {
+			p.requestKeyframe()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPreviewConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *Preview
+	err := yaml.Unmarshal([]byte("{}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(&Preview{Width: 320, FPS: 5, Bitrate: 250}, cfg)
+
+	args := previewArgs(cfg)
+	assert.Contains(args, "fps=5,scale=320:-2")
+	assert.Contains(args, "250k")
+}
+
+func TestPreviewerWriteSample(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int
+
+	p := &previewer{
+		queue:           make(chan []byte, 2),
+		requestKeyframe: func() { requests++ },
+	}
+
+	slice := media.Sample{Data: []byte{0x41, 0x9a}}
+	idr := media.Sample{Data: []byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x68, 0xce, 0, 0, 1, 0x65, 0x88}}
+
+	// Samples before the first keyframe cannot be decoded.
+	p.WriteSample(slice)
+	assert.Len(p.queue, 0)
+
+	p.WriteSample(idr)
+	p.WriteSample(slice)
+	assert.Len(p.queue, 2)
+
+	data := <-p.queue
+	assert.Equal([]byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x68, 0xce, 0, 0, 0, 1, 0x65, 0x88}, data)
+
+	data = <-p.queue
+	assert.Equal([]byte{0, 0, 0, 1, 0x41, 0x9a}, data)
+
+	// Overflowing the queue waits for the next keyframe.
+	p.WriteSample(slice)
+	p.WriteSample(slice)
+	p.WriteSample(slice)
+	assert.Equal(1, requests)
+	assert.False(p.synced)
+
+	p.WriteSample(slice)
+	assert.Len(p.queue, 2)
+}
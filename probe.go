@@ -0,0 +1,157 @@
+package game
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+)
+
+// LatencyPing is a client's probe on the latency data channel. ClientTime
+// is echoed as is, in the client's units. The client reports what it
+// measured from earlier pongs, in microseconds: the round trip, and the
+// latency of frames from their sending to their display.
+type LatencyPing struct {
+	Seq          uint64  `json:"seq"`
+	ClientTime   float64 `json:"client_time"`
+	RTT          int64   `json:"rtt_us,omitempty"`
+	FrameLatency int64   `json:"frame_latency_us,omitempty"`
+}
+
+// LatencyPong answers a ping with the host's receive and send times, in
+// unix microseconds, from which the client estimates the round trip and
+// the offset of the clocks as NTP does. Frame tells the timing of the
+// last video frame sent.
+type LatencyPong struct {
+	Seq         uint64       `json:"seq"`
+	ClientTime  float64      `json:"client_time"`
+	HostReceive int64        `json:"host_receive_us"`
+	HostSend    int64        `json:"host_send_us"`
+	Frame       *FrameTiming `json:"frame,omitempty"`
+}
+
+// FrameTiming is the timing of a video frame: when the host sent it, in
+// unix microseconds, and how long an NVStream host took from capture to
+// sending it.
+type FrameTiming struct {
+	Number         uint64 `json:"number"`
+	Sent           int64  `json:"sent_us"`
+	HostProcessing int64  `json:"host_processing_us,omitempty"`
+}
+
+// frameTiming records the frames a video track sends.
+type frameTiming struct {
+	frames         atomic.Uint64
+	hostProcessing atomic.Int64 // of the last frame
+}
+
+// lastFrame returns the timing of the last frame sent, nil if none was.
+func (video *VideoTrack) lastFrame() *FrameTiming {
+	sent := video.LastSample()
+	if sent.IsZero() {
+		return nil
+	}
+
+	return &FrameTiming{
+		Number:         video.frames.Load(),
+		Sent:           sent.UnixMicro(),
+		HostProcessing: time.Duration(video.hostProcessing.Load()).Microseconds(),
+	}
+}
+
+// PeerLatencyStats summarizes what a peer's client measured over its most
+// recent pings.
+type PeerLatencyStats struct {
+	Peer        string        `json:"peer"`
+	Stream      string        `json:"stream"`
+	Samples     uint64        `json:"samples"`
+	RTTP50      time.Duration `json:"rtt_p50"`
+	RTTP99      time.Duration `json:"rtt_p99"`
+	FrameP50    time.Duration `json:"frame_p50"`
+	FrameP99    time.Duration `json:"frame_p99"`
+	HostLatency time.Duration `json:"host_processing"` // of the last frame
+}
+
+// latencyProbe aggregates the measurements of a peer's client.
+type latencyProbe struct {
+	rtt   *latencyRecorder
+	frame *latencyRecorder
+}
+
+func newLatencyProbe() *latencyProbe {
+	return &latencyProbe{
+		rtt:   newLatencyRecorder(0),
+		frame: newLatencyRecorder(0),
+	}
+}
+
+// Pong answers a ping received at receive, recording what the client
+// measured.
+func (p *latencyProbe) Pong(ping *LatencyPing, receive time.Time, video *VideoTrack) *LatencyPong {
+	if ping.RTT > 0 {
+		p.rtt.Observe(time.Duration(ping.RTT) * time.Microsecond)
+	}
+
+	if ping.FrameLatency > 0 {
+		p.frame.Observe(time.Duration(ping.FrameLatency) * time.Microsecond)
+	}
+
+	pong := &LatencyPong{
+		Seq:         ping.Seq,
+		ClientTime:  ping.ClientTime,
+		HostReceive: receive.UnixMicro(),
+	}
+
+	if video != nil {
+		pong.Frame = video.lastFrame()
+	}
+
+	pong.HostSend = time.Now().UnixMicro()
+
+	return pong
+}
+
+func (p *latencyProbe) Stats() PeerLatencyStats {
+	rtt := p.rtt.Stats(0)
+	frame := p.frame.Stats(0)
+
+	return PeerLatencyStats{
+		Samples:  rtt.Samples,
+		RTTP50:   rtt.P50,
+		RTTP99:   rtt.P99,
+		FrameP50: frame.P50,
+		FrameP99: frame.P99,
+	}
+}
+
+// openLatency answers the pings of the latency data channel.
+func openLatency(peer *Peer, dc *webrtc.DataChannel) (MessageHandler, error) {
+	peer.RLock()
+	mc := peer.channels[dc.Label()]
+	peer.RUnlock()
+
+	probe := newLatencyProbe()
+
+	peer.Lock()
+	peer.latency = probe
+	peer.Unlock()
+
+	return func(msg webrtc.DataChannelMessage) error {
+		receive := time.Now()
+
+		var ping *LatencyPing
+		if err := json.Unmarshal(msg.Data, &ping); err != nil || ping == nil {
+			peer.log.Warn("invalid latency ping", zap.String("handler", "latency"))
+			return ErrMalformedMessage
+		}
+
+		// A pong delayed behind a congested channel would only mislead.
+		if !mc.TrySendJSON(probe.Pong(ping, receive, peer.Rendition())) {
+			return ErrDiscardedMessage
+		}
+
+		return nil
+	}, nil
+}
@@ -0,0 +1,43 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyProbe(t *testing.T) {
+	assert := assert.New(t)
+
+	probe := newLatencyProbe()
+	video := &VideoTrack{}
+
+	receive := time.Now()
+
+	// no frame sent yet
+	pong := probe.Pong(&LatencyPing{Seq: 1, ClientTime: 1234.5}, receive, video)
+	assert.Equal(uint64(1), pong.Seq)
+	assert.Equal(1234.5, pong.ClientTime)
+	assert.Equal(receive.UnixMicro(), pong.HostReceive)
+	assert.GreaterOrEqual(pong.HostSend, pong.HostReceive)
+	assert.Nil(pong.Frame)
+
+	video.hostProcessing.Store(int64(3500 * time.Microsecond))
+	video.WriteSample(media.Sample{Data: []byte{0}, Duration: time.Millisecond})
+
+	pong = probe.Pong(&LatencyPing{Seq: 2, RTT: 20000, FrameLatency: 60000}, time.Now(), video)
+	assert.Equal(uint64(1), pong.Frame.Number)
+	assert.Equal(int64(3500), pong.Frame.HostProcessing)
+	assert.Equal(video.LastSample().UnixMicro(), pong.Frame.Sent)
+
+	probe.Pong(&LatencyPing{Seq: 3, RTT: 30000, FrameLatency: 80000}, time.Now(), nil)
+
+	stats := probe.Stats()
+	assert.Equal(uint64(2), stats.Samples)
+	assert.Equal(20*time.Millisecond, stats.RTTP50)
+	assert.Equal(30*time.Millisecond, stats.RTTP99)
+	assert.Equal(60*time.Millisecond, stats.FrameP50)
+	assert.Equal(80*time.Millisecond, stats.FrameP99)
+}
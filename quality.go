@@ -0,0 +1,295 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Quality configures how the link to each peer is judged from the RTCP
+// receiver reports of its video. Loss or jitter above the thresholds for
+// Sustain degrades the link, which the peer is told about; with
+// AutoDowngrade, an NVStream stream lowers its bitrate once the link stays
+// degraded for DowngradeAfter.
+type Quality struct {
+	LossThreshold   float64
+	JitterThreshold time.Duration
+	Sustain         time.Duration
+	AutoDowngrade   bool
+	DowngradeAfter  time.Duration
+	MinBitrate      int // kbps
+}
+
+func (cfg *Quality) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		LossThreshold   float64       `yaml:"lossThreshold"`
+		JitterThreshold time.Duration `yaml:"jitterThreshold"`
+		Sustain         time.Duration `yaml:"sustain"`
+		AutoDowngrade   bool          `yaml:"autoDowngrade"`
+		DowngradeAfter  time.Duration `yaml:"downgradeAfter"`
+		MinBitrate      int           `yaml:"minBitrate"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.LossThreshold == 0 {
+		raw.LossThreshold = defaultQuality.LossThreshold
+	}
+
+	if raw.JitterThreshold == 0 {
+		raw.JitterThreshold = defaultQuality.JitterThreshold
+	}
+
+	if raw.Sustain == 0 {
+		raw.Sustain = defaultQuality.Sustain
+	}
+
+	if raw.DowngradeAfter == 0 {
+		raw.DowngradeAfter = defaultQuality.DowngradeAfter
+	}
+
+	if raw.MinBitrate == 0 {
+		raw.MinBitrate = defaultQuality.MinBitrate
+	}
+
+	cfg.LossThreshold = raw.LossThreshold
+	cfg.JitterThreshold = raw.JitterThreshold
+	cfg.Sustain = raw.Sustain
+	cfg.AutoDowngrade = raw.AutoDowngrade
+	cfg.DowngradeAfter = raw.DowngradeAfter
+	cfg.MinBitrate = raw.MinBitrate
+
+	return nil
+}
+
+var defaultQuality = &Quality{
+	LossThreshold:   0.05,
+	JitterThreshold: 30 * time.Millisecond,
+	Sustain:         3 * time.Second,
+	DowngradeAfter:  10 * time.Second,
+	MinBitrate:      2000,
+}
+
+// QualityReport is what a receiver report tells about the link.
+type QualityReport struct {
+	SSRC    uint32
+	Loss    float64 // fraction of packets lost since the previous report
+	Jitter  time.Duration
+	Highest uint32 // extended highest sequence number received
+}
+
+// QualityAdvisory is the payload of the quality control messages.
+type QualityAdvisory struct {
+	Loss      float64 `json:"loss"`
+	JitterMs  float64 `json:"jitter_ms"`
+	Message   string  `json:"message,omitempty"`
+	Bitrate   int     `json:"bitrate,omitempty"`   // kbps, after a downgrade
+	Rendition string  `json:"rendition,omitempty"` // after a downgrade
+}
+
+type qualityAction int
+
+const (
+	qualityNone qualityAction = iota
+	qualityDegraded
+	qualityRecovered
+	qualityDowngrade
+)
+
+// qualityMonitor turns the receiver reports of a peer into transitions,
+// so advisories are sent once per degradation rather than per report.
+type qualityMonitor struct {
+	cfg *Quality
+
+	degradedSince time.Time // zero while the link is fine
+	advised       bool
+	downgraded    bool
+}
+
+func (m *qualityMonitor) observe(report QualityReport, now time.Time) qualityAction {
+	bad := report.Loss > m.cfg.LossThreshold || report.Jitter > m.cfg.JitterThreshold
+
+	if !bad {
+		m.degradedSince = time.Time{}
+		m.downgraded = false
+
+		if m.advised {
+			m.advised = false
+			return qualityRecovered
+		}
+
+		return qualityNone
+	}
+
+	if m.degradedSince.IsZero() {
+		m.degradedSince = now
+	}
+
+	degraded := now.Sub(m.degradedSince)
+
+	switch {
+	case !m.advised && degraded >= m.cfg.Sustain:
+		m.advised = true
+		return qualityDegraded
+
+	case m.advised && m.cfg.AutoDowngrade && !m.downgraded && degraded >= m.cfg.DowngradeAfter:
+		m.downgraded = true
+		return qualityDowngrade
+	}
+
+	return qualityNone
+}
+
+// receiverReports reads the RTCP of a sender until the peer closes and
+// passes on the report blocks about the sender's stream.
+func receiverReports(sender *webrtc.RTPSender, clockRate uint32, observe func(QualityReport)) {
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, pkt := range pkts {
+			rr, ok := pkt.(*rtcp.ReceiverReport)
+			if !ok {
+				continue
+			}
+
+			for _, block := range rr.Reports {
+				observe(QualityReport{
+					SSRC:    block.SSRC,
+					Loss:    float64(block.FractionLost) / 256,
+					Jitter:  time.Duration(block.Jitter) * time.Second / time.Duration(clockRate),
+					Highest: block.LastSequenceNumber,
+				})
+			}
+		}
+	}
+}
+
+// watchQuality sends quality advisories to the peer from the reports of
+// its video sender, and passes them on to its pacer.
+func (peer *Peer) watchQuality(sender *webrtc.RTPSender) {
+	cfg := peer.stream.Quality
+	if cfg == nil {
+		cfg = defaultQuality
+	}
+
+	monitor := &qualityMonitor{cfg: cfg}
+
+	log := peer.log.With(
+		zap.String("handler", "quality"),
+	)
+
+	var ssrc uint32
+	if encodings := sender.GetParameters().Encodings; len(encodings) > 0 {
+		ssrc = uint32(encodings[0].SSRC)
+	}
+
+	receiverReports(sender, 90000, func(report QualityReport) {
+		peer.RLock()
+		pacer := peer.pacer
+		peer.RUnlock()
+
+		if pacer != nil && report.SSRC == ssrc {
+			pacer.acknowledge(report.Highest)
+		}
+
+		advisory := &QualityAdvisory{
+			Loss:     report.Loss,
+			JitterMs: float64(report.Jitter) / float64(time.Millisecond),
+		}
+
+		var typ ControlMessageType
+		switch monitor.observe(report, time.Now()) {
+		case qualityDegraded:
+			typ = ControlQualityDegraded
+			advisory.Message = "network degraded, consider lowering quality"
+
+			log.Warn("network degraded",
+				zap.Float64("loss", advisory.Loss),
+				zap.Float64("jitter_ms", advisory.JitterMs))
+
+		case qualityRecovered:
+			typ = ControlQualityRecovered
+
+			log.Info("network recovered")
+
+		case qualityDowngrade:
+			// Renditions lower the quality of this peer alone.
+			if len(peer.stream.Renditions) > 0 {
+				rendition, err := peer.lowerRendition()
+				if err != nil {
+					log.Warn("downgrade skipped", zap.Error(err))
+					return
+				}
+
+				typ = ControlQualityDowngraded
+				advisory.Message = "quality lowered"
+				advisory.Rendition = rendition
+
+				log.Warn("quality lowered", zap.String("rendition", rendition))
+				break
+			}
+
+			bitrate, err := peer.lowerBitrate(cfg.MinBitrate)
+			if err != nil {
+				log.Warn("downgrade skipped", zap.Error(err))
+				return
+			}
+
+			typ = ControlQualityDowngraded
+			advisory.Message = "quality lowered"
+			advisory.Bitrate = bitrate
+
+			log.Warn("quality lowered", zap.Int("bitrate", bitrate))
+
+		default:
+			return
+		}
+
+		msg, err := NewControlMessage(typ, advisory)
+		if err != nil {
+			return
+		}
+
+		peer.SendControl(msg)
+	})
+}
+
+var errBitrateAtMinimum = errors.New("bitrate at minimum")
+
+// lowerBitrate halves the bitrate of the peer's NVStream stream, down to
+// floor. As every peer of the stream gets the lower quality, only the sole
+// peer or the one in control may lower it.
+func (peer *Peer) lowerBitrate(floor int) (int, error) {
+	stream := peer.stream
+	if stream.conn == nil {
+		return 0, errors.New("bitrate fixed by the source")
+	}
+
+	if peer.group.Len() > 1 && peer.group.Controller() != peer {
+		return 0, errors.New("stream shared with other peers")
+	}
+
+	bitrate := max(stream.NVStream.Bitrate/2, floor)
+	if bitrate >= stream.NVStream.Bitrate {
+		return 0, errBitrateAtMinimum
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := stream.conn.SetBitrate(ctx, bitrate); err != nil {
+		return 0, err
+	}
+
+	return bitrate, nil
+}
@@ -0,0 +1,51 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestQualityMonitor(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := *defaultQuality
+	cfg.AutoDowngrade = true
+
+	m := &qualityMonitor{cfg: &cfg}
+
+	now := time.Now()
+	good := QualityReport{Loss: 0.01, Jitter: 5 * time.Millisecond}
+	lossy := QualityReport{Loss: 0.2, Jitter: 5 * time.Millisecond}
+	jittery := QualityReport{Loss: 0, Jitter: 80 * time.Millisecond}
+
+	assert.Equal(qualityNone, m.observe(good, now))
+
+	// a short burst is not sustained
+	assert.Equal(qualityNone, m.observe(lossy, now))
+	assert.Equal(qualityNone, m.observe(good, now.Add(time.Second)))
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(qualityNone, m.observe(lossy, now))
+	assert.Equal(qualityNone, m.observe(jittery, now.Add(2*time.Second)))
+	assert.Equal(qualityDegraded, m.observe(lossy, now.Add(3*time.Second)))
+	assert.Equal(qualityNone, m.observe(lossy, now.Add(4*time.Second)))
+	assert.Equal(qualityDowngrade, m.observe(lossy, now.Add(10*time.Second)))
+	assert.Equal(qualityNone, m.observe(lossy, now.Add(20*time.Second)))
+	assert.Equal(qualityRecovered, m.observe(good, now.Add(21*time.Second)))
+	assert.Equal(qualityNone, m.observe(good, now.Add(22*time.Second)))
+}
+
+func TestQualityConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *Quality
+	err := yaml.Unmarshal([]byte("lossThreshold: 0.1\nautoDowngrade: true"), &cfg)
+	assert.NoError(err)
+	assert.Equal(0.1, cfg.LossThreshold)
+	assert.True(cfg.AutoDowngrade)
+	assert.Equal(30*time.Millisecond, cfg.JitterThreshold)
+	assert.Equal(2000, cfg.MinBitrate)
+}
@@ -0,0 +1,527 @@
+package game
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+type RecordingFormat string
+
+const (
+	RecordingMKV RecordingFormat = "mkv"
+	RecordingMP4 RecordingFormat = "mp4"
+)
+
+type Recordings struct {
+	Enabled     bool
+	Path        string
+	Format      RecordingFormat
+	MaxSizeMB   int64
+	MaxDuration time.Duration
+	Replay      time.Duration // of the instant replay buffer, none if 0
+	Streams     []string
+}
+
+func (cfg *Recordings) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Enabled     bool            `yaml:"enabled"`
+		Path        string          `yaml:"path"`
+		Format      RecordingFormat `yaml:"format"`
+		MaxSizeMB   int64           `yaml:"maxSizeMB"`
+		MaxDuration time.Duration   `yaml:"maxDuration"`
+		Replay      time.Duration   `yaml:"replay"`
+		Streams     []string        `yaml:"streams"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	switch raw.Format {
+	case "":
+		raw.Format = RecordingMKV
+
+	case RecordingMKV, RecordingMP4:
+		// ok
+
+	default:
+		return errors.New("invalid recording format: " + string(raw.Format))
+	}
+
+	if raw.Replay < 0 {
+		return errors.New("replay must not be negative")
+	}
+
+	if raw.Path == "" {
+		raw.Path = "recordings"
+	}
+
+	cfg.Enabled = raw.Enabled
+	cfg.Path = raw.Path
+	cfg.Format = raw.Format
+	cfg.MaxSizeMB = raw.MaxSizeMB
+	cfg.MaxDuration = raw.MaxDuration
+	cfg.Replay = raw.Replay
+	cfg.Streams = raw.Streams
+
+	return nil
+}
+
+// Includes reports whether the stream should be recorded.
+// An empty stream list records every stream.
+func (cfg *Recordings) Includes(stream string) bool {
+	return len(cfg.Streams) == 0 || slices.Contains(cfg.Streams, stream)
+}
+
+// NewRecorder tees the samples of a stream into Matroska or fragmented MP4
+// files under dir, starting a new file whenever the size or duration limit
+// is reached, and buffers the last cfg.Replay of them for SaveReplay. Only
+// H264 video and Opus audio can be recorded.
+func NewRecorder(cfg *Recordings, dir string, stream *Stream) (*Recorder, error) {
+	rec := &Recorder{
+		log: zap.L().With(
+			zap.String("component", "recorder"),
+			zap.String("stream", stream.Name),
+		),
+		cfg:  cfg,
+		dir:  dir,
+		name: stream.Name,
+	}
+
+	var number uint64
+
+	if video := stream.Video; video != nil {
+		if video.Codec() != CodecH264 {
+			return nil, errors.New("recording unsupported for video codec: " + string(video.Codec()))
+		}
+
+		number++
+
+		rec.video = &mkvTrack{
+			Number:  number,
+			Type:    mkvTrackTypeVideo,
+			CodecID: "V_MPEG4/ISO/AVC",
+		}
+
+		if nv := stream.NVStream; nv != nil {
+			rec.video.Width = nv.Width
+			rec.video.Height = nv.Height
+		}
+	}
+
+	if audio := stream.Audio; audio != nil {
+		if audio.Codec() != CodecOpus {
+			return nil, errors.New("recording unsupported for audio codec: " + string(audio.Codec()))
+		}
+
+		number++
+
+		rec.audio = &mkvTrack{
+			Number:       number,
+			Type:         mkvTrackTypeAudio,
+			CodecID:      "A_OPUS",
+			CodecPrivate: opusHead(2, 48000),
+			SampleRate:   48000,
+			Channels:     2,
+		}
+	}
+
+	if number == 0 {
+		return nil, errors.New("stream has no tracks to record")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// recordingWriter writes the blocks of a recording in its container format.
+type recordingWriter interface {
+	WriteBlock(track uint64, timestamp int64, keyframe bool, video bool, data []byte) error
+
+	// Finish writes what the writer still buffers; the file is complete
+	// after it.
+	Finish() error
+
+	Written() int64
+}
+
+func newRecordingWriter(format RecordingFormat, w io.Writer, tracks []*mkvTrack) (recordingWriter, error) {
+	if format == RecordingMP4 {
+		return newMP4Writer(w, tracks)
+	}
+
+	return newMKVWriter(w, tracks)
+}
+
+type Recorder struct {
+	log  *zap.Logger
+	cfg  *Recordings
+	dir  string
+	name string
+
+	video *mkvTrack
+	audio *mkvTrack
+
+	sps     []byte
+	pps     []byte
+	pending [][]byte
+
+	// replay holds the blocks of the instant replay; requestKeyframe, if
+	// set, asks for a keyframe to start it from when none came for long.
+	replay          []replayBlock
+	requestKeyframe func()
+
+	file   *os.File
+	mw     recordingWriter
+	start  time.Time
+	closed bool
+	sync.Mutex
+}
+
+func (rec *Recorder) VideoWriter() SampleWriter {
+	return &recorderSink{rec, true}
+}
+
+func (rec *Recorder) AudioWriter() SampleWriter {
+	return &recorderSink{rec, false}
+}
+
+type recorderSink struct {
+	rec   *Recorder
+	video bool
+}
+
+func (sink *recorderSink) WriteSample(sample media.Sample) error {
+	var err error
+	if sink.video {
+		err = sink.rec.writeVideo(sample)
+	} else {
+		err = sink.rec.writeAudio(sample)
+	}
+
+	if err != nil {
+		sink.rec.log.Error(err.Error())
+	}
+
+	return err
+}
+
+func (rec *Recorder) writeVideo(sample media.Sample) error {
+	rec.Lock()
+	defer rec.Unlock()
+
+	if rec.closed || rec.video == nil {
+		return nil
+	}
+
+	var (
+		frame    [][]byte
+		keyframe bool
+	)
+
+	for _, nal := range splitAnnexB(sample.Data) {
+		switch nalType(nal) {
+		case nalTypeSPS:
+			rec.sps = slices.Clone(nal)
+			rec.pending = append(rec.pending, rec.sps)
+
+		case nalTypePPS:
+			rec.pps = slices.Clone(nal)
+			rec.pending = append(rec.pending, rec.pps)
+
+		case nalTypeAUD:
+			// dropped
+
+		case nalTypeIDR:
+			keyframe = true
+			frame = append(frame, nal)
+
+		default:
+			frame = append(frame, nal)
+		}
+	}
+
+	if len(frame) == 0 {
+		return nil
+	}
+
+	// A keyframe cannot be decoded without the parameter sets.
+	if keyframe && (rec.sps == nil || rec.pps == nil) {
+		rec.pending = nil
+		return nil
+	}
+
+	if rec.cfg.Enabled && keyframe && rec.rotationDue() {
+		if err := rec.open(); err != nil {
+			return err
+		}
+	}
+
+	data := lengthPrefixed(append(rec.pending, frame...))
+	rec.pending = nil
+
+	now := time.Now()
+	rec.buffer(replayBlock{track: rec.video.Number, at: now, keyframe: keyframe, video: true, data: data})
+
+	if rec.mw == nil {
+		return nil
+	}
+
+	return rec.mw.WriteBlock(rec.video.Number, now.Sub(rec.start).Milliseconds(), keyframe, true, data)
+}
+
+func (rec *Recorder) writeAudio(sample media.Sample) error {
+	rec.Lock()
+	defer rec.Unlock()
+
+	if rec.closed || rec.audio == nil {
+		return nil
+	}
+
+	// Audio-only recordings rotate on any sample; otherwise files
+	// are opened and rotated on video keyframes.
+	if rec.cfg.Enabled && rec.video == nil && rec.rotationDue() {
+		if err := rec.open(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	rec.buffer(replayBlock{track: rec.audio.Number, at: now, keyframe: true, data: sample.Data})
+
+	if rec.mw == nil {
+		return nil
+	}
+
+	return rec.mw.WriteBlock(rec.audio.Number, now.Sub(rec.start).Milliseconds(), true, false, sample.Data)
+}
+
+// replayBlock is a block of the instant replay.
+type replayBlock struct {
+	track    uint64
+	at       time.Time
+	keyframe bool
+	video    bool
+	data     []byte
+}
+
+// starts reports whether a replay may start with the block: a keyframe,
+// of the video if the stream has any.
+func (rec *Recorder) starts(block replayBlock) bool {
+	return block.keyframe && (block.video || rec.video == nil)
+}
+
+// buffer keeps a block for the instant replay, and drops the blocks before
+// the last keyframe older than cfg.Replay. Without such a keyframe for
+// twice as long, the older blocks are dropped anyway and a keyframe is
+// asked for, which bounds the buffer of streams sending few keyframes.
+func (rec *Recorder) buffer(block replayBlock) {
+	if rec.cfg.Replay <= 0 {
+		return
+	}
+
+	block.data = slices.Clone(block.data)
+	rec.replay = append(rec.replay, block)
+
+	since := block.at.Add(-rec.cfg.Replay)
+	limit := since.Add(-rec.cfg.Replay)
+
+	cut, old := -1, 0
+	for i, b := range rec.replay {
+		if b.at.After(since) {
+			break
+		}
+
+		old = i + 1
+		if rec.starts(b) {
+			cut = i
+		}
+	}
+
+	switch {
+	case cut >= 0 && !rec.replay[cut].at.Before(limit):
+		rec.replay = rec.replay[cut:]
+
+	case rec.replay[0].at.Before(limit):
+		rec.replay = rec.replay[old:]
+
+		if rec.requestKeyframe != nil {
+			rec.requestKeyframe()
+		}
+	}
+}
+
+// SaveReplay writes the instant replay, the buffered last cfg.Replay of the
+// stream from a keyframe on, to a file of its own and returns its path.
+func (rec *Recorder) SaveReplay() (string, error) {
+	rec.Lock()
+
+	if rec.cfg.Replay <= 0 {
+		rec.Unlock()
+		return "", errors.New("instant replay disabled")
+	}
+
+	start := slices.IndexFunc(rec.replay, rec.starts)
+	if start < 0 {
+		rec.Unlock()
+		return "", errors.New("no instant replay buffered yet")
+	}
+
+	// Blocks are not changed once buffered, so they are written unlocked.
+	blocks := slices.Clone(rec.replay[start:])
+	tracks := rec.tracks()
+
+	rec.Unlock()
+
+	filename := rec.name + "_replay_" + time.Now().Format("20060102-150405.000") + "." + string(rec.cfg.Format)
+	path := filepath.Join(rec.dir, filename)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	mw, err := newRecordingWriter(rec.cfg.Format, f, tracks)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+
+	for _, b := range blocks {
+		if err := mw.WriteBlock(b.track, b.at.Sub(blocks[0].at).Milliseconds(), b.keyframe, b.video, b.data); err != nil {
+			f.Close()
+			return "", err
+		}
+	}
+
+	if err := mw.Finish(); err != nil {
+		f.Close()
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	rec.log.Info("instant replay saved",
+		zap.String("file", path),
+		zap.Int64("bytes", mw.Written()))
+
+	return path, nil
+}
+
+// tracks returns the tracks of a new file, the video described by the last
+// parameter sets.
+func (rec *Recorder) tracks() []*mkvTrack {
+	var tracks []*mkvTrack
+	if rec.video != nil {
+		video := *rec.video
+		video.CodecPrivate = avcDecoderConfiguration(rec.sps, rec.pps)
+		tracks = append(tracks, &video)
+	}
+
+	if rec.audio != nil {
+		tracks = append(tracks, rec.audio)
+	}
+
+	return tracks
+}
+
+func (rec *Recorder) rotationDue() bool {
+	if rec.mw == nil {
+		return true
+	}
+
+	if max := rec.cfg.MaxSizeMB; max > 0 && rec.mw.Written() >= max<<20 {
+		return true
+	}
+
+	if max := rec.cfg.MaxDuration; max > 0 && time.Since(rec.start) >= max {
+		return true
+	}
+
+	return false
+}
+
+func (rec *Recorder) open() error {
+	if err := rec.closeFile(); err != nil {
+		rec.log.Error(err.Error())
+	}
+
+	tracks := rec.tracks()
+
+	now := time.Now()
+	filename := rec.name + "_" + now.Format("20060102-150405.000") + "." + string(rec.cfg.Format)
+	path := filepath.Join(rec.dir, filename)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	mw, err := newRecordingWriter(rec.cfg.Format, f, tracks)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rec.file = f
+	rec.mw = mw
+	rec.start = now
+	rec.pending = nil
+
+	rec.log.Info("recording started", zap.String("file", path))
+
+	return nil
+}
+
+func (rec *Recorder) closeFile() error {
+	if rec.file == nil {
+		return nil
+	}
+
+	err := errors.Join(rec.mw.Finish(), rec.file.Close())
+
+	rec.log.Info("recording finished",
+		zap.String("file", rec.file.Name()),
+		zap.Int64("bytes", rec.mw.Written()))
+
+	rec.file = nil
+	rec.mw = nil
+
+	return err
+}
+
+func (rec *Recorder) Close() error {
+	rec.Lock()
+	defer rec.Unlock()
+
+	rec.closed = true
+
+	return rec.closeFile()
+}
+
+// opusHead builds the OpusHead identification header used as CodecPrivate.
+func opusHead(channels uint8, sampleRate uint32) []byte {
+	head := []byte("OpusHead")
+	head = append(head, 1, channels)
+	head = append(head, 0x00, 0x0F) // pre-skip 3840, little endian
+	head = append(head,
+		byte(sampleRate), byte(sampleRate>>8), byte(sampleRate>>16), byte(sampleRate>>24))
+	head = append(head, 0, 0) // output gain
+	head = append(head, 0)    // channel mapping family
+
+	return head
+}
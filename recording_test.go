@@ -0,0 +1,354 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRecordingsConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg *Recordings
+	err := yaml.Unmarshal([]byte("{}"), &cfg)
+	assert.NoError(err)
+	assert.Equal(RecordingMKV, cfg.Format)
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("format: mp4"), &cfg)
+	assert.NoError(err)
+	assert.Equal(RecordingMP4, cfg.Format)
+
+	err = yaml.Unmarshal([]byte("format: avi"), &cfg)
+	assert.EqualError(err, "invalid recording format: avi")
+
+	cfg = nil
+	err = yaml.Unmarshal([]byte("replay: 30s"), &cfg)
+	assert.NoError(err)
+	assert.Equal(30*time.Second, cfg.Replay)
+}
+
+func TestSplitAnnexB(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte{0, 0, 0, 1, 0x67, 1, 2, 0, 0, 1, 0x68, 3, 0, 0, 0, 1, 0x65, 4, 5}
+
+	nals := splitAnnexB(data)
+	assert.Len(nals, 3)
+	assert.Equal(nalTypeSPS, nalType(nals[0]))
+	assert.Equal([]byte{0x68, 3}, nals[1])
+	assert.Equal(nalTypeIDR, nalType(nals[2]))
+
+	nals = splitAnnexB([]byte{0x41, 9, 9})
+	assert.Len(nals, 1)
+}
+
+func TestRecorder(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	stream := &Stream{
+		Name:  "test",
+		Video: &VideoTrack{codec: CodecH264},
+		Audio: &AudioTrack{codec: CodecOpus},
+	}
+
+	cfg := &Recordings{
+		Enabled:   true,
+		Format:    RecordingMKV,
+		MaxSizeMB: 1,
+	}
+
+	rec, err := NewRecorder(cfg, dir, stream)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	audio := rec.AudioWriter()
+	video := rec.VideoWriter()
+
+	// dropped until the first keyframe
+	assert.NoError(audio.WriteSample(media.Sample{Data: []byte{0xFC, 1}, Duration: 20 * time.Millisecond}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x41, 1}}))
+
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x67, 0x64, 0x00, 0x1F, 0xAC}}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x68, 0xEE, 0x3C, 0x80}}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x65, 0x88, 0x84}}))
+	assert.NoError(audio.WriteSample(media.Sample{Data: []byte{0xFC, 2}, Duration: 20 * time.Millisecond}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x41, 0x9A}}))
+
+	assert.NoError(rec.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "test_*.mkv"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Len(files, 1)
+
+	bs, err := os.ReadFile(files[0])
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(bytes.HasPrefix(bs, []byte{0x1A, 0x45, 0xDF, 0xA3}))
+	assert.True(bytes.Contains(bs, []byte("V_MPEG4/ISO/AVC")))
+	assert.True(bytes.Contains(bs, []byte("OpusHead")))
+}
+
+// mp4Boxes returns the top-level boxes of an MP4 file by type, in order.
+func mp4Boxes(t *testing.T, bs []byte) ([]string, map[string][][]byte) {
+	var types []string
+	boxes := make(map[string][][]byte)
+
+	for len(bs) >= 8 {
+		size := binary.BigEndian.Uint32(bs)
+		if size < 8 || int(size) > len(bs) {
+			t.Fatalf("invalid box size %d", size)
+		}
+
+		typ := string(bs[4:8])
+		types = append(types, typ)
+		boxes[typ] = append(boxes[typ], bs[8:size])
+
+		bs = bs[size:]
+	}
+
+	return types, boxes
+}
+
+func TestRecorderMP4(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	stream := &Stream{
+		Name:  "test",
+		Video: &VideoTrack{codec: CodecH264},
+		Audio: &AudioTrack{codec: CodecOpus},
+	}
+
+	cfg := &Recordings{
+		Enabled: true,
+		Format:  RecordingMP4,
+	}
+
+	rec, err := NewRecorder(cfg, dir, stream)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	audio := rec.AudioWriter()
+	video := rec.VideoWriter()
+
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x67, 0x64, 0x00, 0x1F, 0xAC}}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x68, 0xEE, 0x3C, 0x80}}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x65, 0x88, 0x84}}))
+	assert.NoError(audio.WriteSample(media.Sample{Data: []byte{0xFC, 2}, Duration: 20 * time.Millisecond}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x41, 0x9A}}))
+
+	// the second keyframe writes the fragment of the first
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x65, 0x77}}))
+
+	assert.NoError(rec.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "test_*.mp4"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if !assert.Len(files, 1) {
+		return
+	}
+
+	bs, err := os.ReadFile(files[0])
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	types, boxes := mp4Boxes(t, bs)
+	assert.Equal([]string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"}, types)
+
+	moov := boxes["moov"][0]
+	assert.True(bytes.Contains(moov, []byte("avcC")))
+	assert.True(bytes.Contains(moov, []byte("dOps")))
+	assert.True(bytes.Contains(moov, []byte("mvex")))
+
+	// the first fragment holds the frames of the first keyframe, whose
+	// parameter sets are in the avcC, the last the second keyframe and the
+	// held audio
+	mdat := boxes["mdat"]
+	assert.Equal(lengthPrefixed([][]byte{{0x65, 0x88, 0x84}, {0x41, 0x9A}}), mdat[0])
+	assert.True(bytes.Contains(mdat[1], []byte{0x65, 0x77}))
+	assert.True(bytes.Contains(mdat[1], []byte{0xFC, 2}))
+}
+
+func TestMP4WriterDataOffsets(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+
+	mw, err := newMP4Writer(&buf, []*mkvTrack{
+		{Number: 1, Type: mkvTrackTypeVideo},
+		{Number: 2, Type: mkvTrackTypeAudio, SampleRate: 48000, Channels: 2},
+	})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.NoError(mw.WriteBlock(1, 0, true, true, []byte{1, 1, 1}))
+	assert.NoError(mw.WriteBlock(2, 0, true, false, []byte{2, 2}))
+	assert.NoError(mw.WriteBlock(2, 20, true, false, []byte{3}))
+	assert.NoError(mw.WriteBlock(1, 40, false, true, []byte{4, 4}))
+	assert.NoError(mw.Finish())
+	assert.Equal(int64(buf.Len()), mw.Written())
+
+	types, _ := mp4Boxes(t, buf.Bytes())
+	assert.Equal([]string{"ftyp", "moov", "moof", "mdat"}, types)
+
+	// every run points at its own samples, from the start of the moof
+	bs := buf.Bytes()
+	moof := bytes.Index(bs, []byte("moof")) - 4
+
+	var (
+		samples   [][]byte
+		durations []uint32
+	)
+
+	for rest := bs[moof:]; ; {
+		i := bytes.Index(rest, []byte("trun"))
+		if i < 0 {
+			break
+		}
+
+		trun := rest[i+8:] // past the version and flags
+		count := binary.BigEndian.Uint32(trun)
+		offset := moof + int(binary.BigEndian.Uint32(trun[4:]))
+
+		for n := range count {
+			entry := trun[8+12*n:]
+			size := int(binary.BigEndian.Uint32(entry[4:]))
+
+			durations = append(durations, binary.BigEndian.Uint32(entry))
+			samples = append(samples, bs[offset:offset+size])
+			offset += size
+		}
+
+		rest = rest[i+4:]
+	}
+
+	assert.Equal([][]byte{{1, 1, 1}, {4, 4}, {2, 2}, {3}}, samples)
+
+	// 40 ms at 90 kHz, the last lasting as long as the one before; 20 ms at 48 kHz
+	assert.Equal([]uint32{3600, 3600, 960, 960}, durations)
+}
+
+func TestRecorderReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	stream := &Stream{
+		Name:  "test",
+		Video: &VideoTrack{codec: CodecH264},
+		Audio: &AudioTrack{codec: CodecOpus},
+	}
+
+	// the replay is buffered without recording to files
+	cfg := &Recordings{
+		Format: RecordingMKV,
+		Replay: time.Minute,
+	}
+
+	rec, err := NewRecorder(cfg, dir, stream)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = rec.SaveReplay()
+	assert.ErrorContains(err, "no instant replay")
+
+	audio := rec.AudioWriter()
+	video := rec.VideoWriter()
+
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x67, 0x64, 0x00, 0x1F, 0xAC}}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x68, 0xEE, 0x3C, 0x80}}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x65, 0x88, 0x84}}))
+	assert.NoError(audio.WriteSample(media.Sample{Data: []byte{0xFC, 2}, Duration: 20 * time.Millisecond}))
+	assert.NoError(video.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x41, 0x9A}}))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.mkv"))
+	assert.Empty(files)
+
+	path, err := rec.SaveReplay()
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(dir, filepath.Dir(path))
+	assert.True(strings.HasPrefix(filepath.Base(path), "test_replay_"))
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(bytes.Contains(bs, []byte("V_MPEG4/ISO/AVC")))
+	assert.True(bytes.Contains(bs, []byte{0x65, 0x88, 0x84}))
+	assert.True(bytes.Contains(bs, []byte{0x41, 0x9A}))
+
+	assert.NoError(rec.Close())
+}
+
+func TestRecorderReplayBuffer(t *testing.T) {
+	assert := assert.New(t)
+
+	var requested int
+
+	rec := &Recorder{
+		cfg:             &Recordings{Replay: 10 * time.Second},
+		video:           &mkvTrack{Number: 1},
+		requestKeyframe: func() { requested++ },
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	block := func(after time.Duration, keyframe bool) {
+		rec.buffer(replayBlock{track: 1, at: now.Add(after), keyframe: keyframe, video: true})
+	}
+
+	block(0, true)
+	block(5*time.Second, false)
+	block(8*time.Second, true)
+	block(12*time.Second, false)
+
+	// the keyframe before the last 10s is kept to start from
+	assert.Len(rec.replay, 4)
+
+	block(19*time.Second, false)
+	assert.Len(rec.replay, 3)
+	assert.True(rec.replay[0].keyframe)
+
+	// without keyframes the buffer is bounded and a keyframe asked for
+	block(40*time.Second, false)
+	assert.Len(rec.replay, 1)
+	assert.Equal(1, requested)
+}
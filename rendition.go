@@ -0,0 +1,161 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+// Renditions are lower qualities of a stream's video, e.g. 720p30 next to
+// 1080p60, each from its own source. A peer starts on the stream's video
+// and switches between them without renegotiating: they share its codec,
+// so the sender keeps its track, and only the source it is fed from moves.
+
+// RenditionRequest asks to switch the peer's video to the rendition named,
+// the stream's video when empty.
+type RenditionRequest struct {
+	Name string `json:"name"`
+}
+
+var errRenditionAtMinimum = errors.New("rendition at minimum")
+
+// renditions returns the stream's video and its renditions, from the best
+// quality down.
+func (s *Stream) renditions() []*VideoTrack {
+	if s.Video == nil {
+		return nil
+	}
+
+	return append([]*VideoTrack{s.Video}, s.Renditions...)
+}
+
+// rendition returns the rendition named, the stream's video when empty.
+func (s *Stream) rendition(name string) (*VideoTrack, bool) {
+	if name == "" {
+		return s.Video, s.Video != nil
+	}
+
+	for _, video := range s.renditions() {
+		if video.Name() == name {
+			return video, true
+		}
+	}
+
+	return nil, false
+}
+
+// checkRenditions tells whether the stream's renditions can stand in for
+// its video: named, of its codec, from sockets.
+func (s *Stream) checkRenditions() error {
+	if len(s.Renditions) == 0 {
+		return nil
+	}
+
+	if s.Video == nil {
+		return errors.New("renditions without video")
+	}
+
+	if s.Transport != TransportRaw {
+		return errors.New("renditions unsupported for transport: " + string(s.Transport))
+	}
+
+	seen := map[string]bool{s.Video.Name(): true}
+	for _, video := range s.Renditions {
+		if video.Name() == "" {
+			return errors.New("rendition name not specified")
+		}
+
+		if seen[video.Name()] {
+			return errors.New("duplicate rendition: " + video.Name())
+		}
+
+		seen[video.Name()] = true
+
+		if video.Codec() != s.Video.Codec() {
+			return errors.New("rendition codec differs from video: " + video.Name())
+		}
+	}
+
+	return nil
+}
+
+// Rendition returns the video the peer gets.
+func (peer *Peer) Rendition() *VideoTrack {
+	peer.RLock()
+	defer peer.RUnlock()
+
+	return peer.video
+}
+
+// SetRendition switches the peer's video to the rendition named.
+func (peer *Peer) SetRendition(name string) error {
+	video, ok := peer.stream.rendition(name)
+	if !ok {
+		return NewControlError(ControlErrBadRequest, "unknown rendition: "+name)
+	}
+
+	peer.Lock()
+	defer peer.Unlock()
+
+	current := peer.video
+
+	switch {
+	case !peer.mode.Video() || current == nil:
+		return NewControlError(ControlErrUnsupported, "peer receives no video")
+
+	case current.Codec() != video.Codec():
+		return NewControlError(ControlErrUnsupported, "renditions unavailable for codec: "+string(current.Codec()))
+
+	case current == video:
+		return nil
+	}
+
+	if pacer := peer.pacer; pacer != nil {
+		// Frames of the new source only decode from its next keyframe.
+		current.RemoveSink(pacer)
+		pacer.resync()
+		video.AddSink(pacer)
+	} else if !peer.videoPaused {
+		if err := peer.videoSender.ReplaceTrack(video.Track()); err != nil {
+			return err
+		}
+	}
+
+	peer.video = video
+
+	peer.log.Info("rendition changed", zap.String("rendition", video.Name()))
+
+	return nil
+}
+
+// lowerRendition switches the peer's video to the next lower rendition.
+func (peer *Peer) lowerRendition() (string, error) {
+	renditions := peer.stream.renditions()
+
+	i := slices.Index(renditions, peer.Rendition())
+	if i < 0 {
+		return "", errors.New("renditions unavailable")
+	}
+
+	if i+1 >= len(renditions) {
+		return "", errRenditionAtMinimum
+	}
+
+	next := renditions[i+1]
+	if err := peer.SetRendition(next.Name()); err != nil {
+		return "", err
+	}
+
+	return next.Name(), nil
+}
+
+func (peer *Peer) handleRendition(msg *ControlMessage) error {
+	var req RenditionRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return NewControlError(ControlErrBadRequest, err.Error())
+	}
+
+	return peer.SetRendition(req.Name)
+}
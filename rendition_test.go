@@ -0,0 +1,122 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenditionConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var stream Stream
+	err := yaml.Unmarshal([]byte(`
+name: stream
+transport: raw
+video:
+  codec: h264
+  address: unix:///tmp/stream/video.sock
+  fps: 60
+renditions:
+  - name: 720p30
+    codec: h264
+    address: unix:///tmp/stream/video_720p.sock
+    fps: 30
+`), &stream)
+
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.NoError(stream.checkRenditions())
+
+	video, ok := stream.rendition("720p30")
+	assert.True(ok)
+	assert.Equal(30.0, video.FPS())
+
+	video, ok = stream.rendition("")
+	assert.True(ok)
+	assert.Equal(stream.Video, video)
+
+	_, ok = stream.rendition("480p")
+	assert.False(ok)
+
+	assert.Equal([]string{"720p30"}, stream.Manifest(nil).Video.Renditions)
+
+	stream.Renditions[0].codec = CodecAV1
+	assert.Error(stream.checkRenditions())
+
+	stream.Renditions[0].codec = CodecH264
+	stream.Renditions[0].name = ""
+	assert.Error(stream.checkRenditions())
+
+	stream.Renditions[0].name = "720p30"
+	stream.Transport = TransportNV
+	assert.Error(stream.checkRenditions())
+}
+
+func TestSetRendition(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		idr = []byte{0, 0, 0, 1, 0x65, 0x88}
+		ref = []byte{0, 0, 0, 1, 0x41, 0x9A}
+	)
+
+	full := &VideoTrack{codec: CodecH264}
+	low := &VideoTrack{name: "720p30", codec: CodecH264}
+
+	stream := &Stream{
+		Transport:  TransportRaw,
+		Video:      full,
+		Renditions: []*VideoTrack{low},
+	}
+
+	pacer, err := newPeerPacer(&Pacing{Queue: 8}, full, "video", "stream", zap.NewNop())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	full.AddSink(pacer)
+
+	peer := &Peer{
+		log:    zap.NewNop(),
+		stream: stream,
+		video:  full,
+		pacer:  pacer,
+	}
+
+	write := func(video *VideoTrack, data []byte) {
+		video.writeSinks(media.Sample{Data: data, Duration: 10 * time.Millisecond})
+	}
+
+	write(full, idr)
+	assert.Len(pacer.queue, 1)
+
+	rendition, err := peer.lowerRendition()
+	assert.NoError(err)
+	assert.Equal("720p30", rendition)
+	assert.Equal(low, peer.Rendition())
+
+	// the old source is cut off, the new one waits for a keyframe
+	write(full, idr)
+	write(low, ref)
+	assert.Empty(pacer.queue)
+
+	write(low, idr)
+	assert.Len(pacer.queue, 1)
+
+	_, err = peer.lowerRendition()
+	assert.ErrorIs(err, errRenditionAtMinimum)
+
+	assert.Error(peer.SetRendition("480p"))
+
+	assert.NoError(peer.SetRendition(""))
+	assert.Equal(full, peer.Rendition())
+}
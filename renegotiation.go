@@ -0,0 +1,307 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/session"
+)
+
+// After the first negotiation either side may offer again over the peer's
+// inbox, following the perfect negotiation pattern of the session package:
+// the server sends its offers on the reply subject suffixed with
+// .sdp.renegotiate and the client its own on .sdp.offer. When both offer at
+// once, the server is the impolite peer and ignores the client's offer,
+// which the client rolls back to answer the server's.
+
+// RenegotiationTimeout bounds an offer of the server until the client
+// answers.
+const RenegotiationTimeout = 10 * time.Second
+
+// ModeRequest switches the peer between receiving video and audio only.
+type ModeRequest struct {
+	Mode PeerMode `json:"mode"`
+}
+
+// AttachRequest adds the media of another stream to the peer, or removes
+// it.
+type AttachRequest struct {
+	Stream string `json:"stream"`
+}
+
+// renegotiate offers the client the peer's tracks as they are now and
+// applies its answer.
+func (peer *Peer) renegotiate() error {
+	if peer.renegotiation == nil {
+		return NewControlError(ControlErrUnsupported, "renegotiation unavailable")
+	}
+
+	err := peer.signaling.Offer(peer.ctx, nil, RenegotiationTimeout, peer.renegotiation)
+	if err != nil {
+		peer.negotiationFailed(err)
+		return err
+	}
+
+	peer.log.Info("renegotiated")
+
+	return nil
+}
+
+// negotiationFailed closes the peer when a failed negotiation left its
+// connection unable to negotiate again; the client reconnects.
+func (peer *Peer) negotiationFailed(err error) {
+	if !errors.Is(err, session.ErrStuck) {
+		return
+	}
+
+	peer.log.Error("negotiation stuck, closing peer", zap.Error(err))
+
+	// Not from within the handlers of the connection it closes.
+	go peer.Close()
+}
+
+// offerHandler answers the client's offers. Errors are returned in the
+// headers of the micro framework, 409 when the offers collided.
+func (peer *Peer) offerHandler() nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		respondError := func(code string, err error) {
+			reply := nats.NewMsg(msg.Reply)
+			reply.Header.Set("Nats-Service-Error-Code", code)
+			reply.Header.Set("Nats-Service-Error", err.Error())
+			msg.RespondMsg(reply)
+		}
+
+		var offer webrtc.SessionDescription
+		if err := json.Unmarshal(msg.Data, &offer); err != nil {
+			respondError("400", err)
+			return
+		}
+
+		answer, err := peer.signaling.AnswerOffer(offer, RenegotiationTimeout)
+		if err != nil {
+			code := "417"
+			if errors.Is(err, session.ErrGlare) {
+				code = "409"
+			}
+
+			peer.log.Warn("client offer rejected", zap.Error(err))
+			respondError(code, err)
+			peer.negotiationFailed(err)
+			return
+		}
+
+		bs, err := json.Marshal(answer)
+		if err != nil {
+			respondError("500", err)
+			return
+		}
+
+		msg.Respond(bs)
+	}
+}
+
+// SetMode switches the peer between receiving video and audio only,
+// adding or removing its video track.
+func (peer *Peer) SetMode(mode PeerMode) error {
+	switchable := func(mode PeerMode) bool {
+		return mode == PeerModeFull || mode == PeerModeAudioOnly
+	}
+
+	peer.Lock()
+
+	current := peer.mode
+	if !switchable(current) || !switchable(mode) {
+		peer.Unlock()
+		return NewControlError(ControlErrUnsupported, "mode switch unsupported: "+string(current)+" to "+string(mode))
+	}
+
+	if current == mode {
+		peer.Unlock()
+		return nil
+	}
+
+	if peer.video == nil {
+		peer.video = peer.stream.Video
+	}
+
+	video := peer.video
+	sender := peer.videoSender
+	pacer := peer.pacer
+
+	peer.mode = mode
+	peer.videoSender = nil
+	peer.videoPaused = false
+	peer.pacer = nil
+	peer.Unlock()
+
+	if mode.Video() {
+		if peer.addVideo == nil {
+			return NewControlError(ControlErrUnsupported, "video unavailable")
+		}
+
+		if err := peer.addVideo(); err != nil {
+			peer.Lock()
+			peer.mode = current
+			peer.Unlock()
+
+			return err
+		}
+	} else {
+		if pacer != nil {
+			video.RemoveSink(pacer)
+			pacer.Close()
+		}
+
+		if sender != nil {
+			if err := peer.RemoveTrack(sender); err != nil {
+				return err
+			}
+		}
+	}
+
+	peer.log.Info("mode changed", zap.String("mode", string(mode)))
+
+	return peer.renegotiate()
+}
+
+// AttachStream adds the audio, and unless the peer is in audio only mode
+// the video, of another stream to the peer. Input still goes to the
+// peer's own stream.
+func (peer *Peer) AttachStream(name string) error {
+	if peer.guest != "" {
+		return ErrPermissionDenied
+	}
+
+	if peer.findStream == nil {
+		return NewControlError(ControlErrUnsupported, "streams unavailable")
+	}
+
+	stream, err := peer.findStream(name)
+	if err != nil {
+		return NewControlError(ControlErrBadRequest, err.Error())
+	}
+
+	if stream == peer.stream {
+		return NewControlError(ControlErrBadRequest, "stream already sent: "+name)
+	}
+
+	peer.Lock()
+	if _, ok := peer.attached[name]; ok {
+		peer.Unlock()
+		return nil
+	}
+
+	var tracks []webrtc.TrackLocal
+	if peer.mode.Video() {
+		video := stream.Video
+		if peer.video != nil {
+			if v := stream.videoTrack(peer.video.Codec()); v != nil {
+				video = v
+			}
+		}
+
+		if video != nil && video.Track() != nil {
+			tracks = append(tracks, video.Track())
+		}
+	}
+
+	if stream.Audio != nil && stream.Audio.Track() != nil {
+		tracks = append(tracks, stream.Audio.Track())
+	}
+
+	if len(tracks) == 0 {
+		peer.Unlock()
+		return NewControlError(ControlErrUnavailable, "stream not ready: "+name)
+	}
+
+	var senders []*webrtc.RTPSender
+	for _, track := range tracks {
+		sender, err := peer.AddTrack(track)
+		if err != nil {
+			for _, sender := range senders {
+				peer.RemoveTrack(sender)
+			}
+
+			peer.Unlock()
+			return err
+		}
+
+		senders = append(senders, sender)
+	}
+
+	if peer.attached == nil {
+		peer.attached = make(map[string][]*webrtc.RTPSender)
+	}
+
+	peer.attached[name] = senders
+	peer.Unlock()
+
+	peer.log.Info("stream attached", zap.String("attached", name))
+
+	return peer.renegotiate()
+}
+
+// DetachStream removes the media of an attached stream from the peer.
+func (peer *Peer) DetachStream(name string) error {
+	peer.Lock()
+	senders, ok := peer.attached[name]
+	if !ok {
+		peer.Unlock()
+		return NewControlError(ControlErrBadRequest, "stream not attached: "+name)
+	}
+
+	delete(peer.attached, name)
+
+	for _, sender := range senders {
+		peer.RemoveTrack(sender)
+	}
+	peer.Unlock()
+
+	peer.log.Info("stream detached", zap.String("attached", name))
+
+	return peer.renegotiate()
+}
+
+// AttachedStreams returns the names of the streams attached to the peer.
+func (peer *Peer) AttachedStreams() []string {
+	peer.RLock()
+	defer peer.RUnlock()
+
+	names := make([]string, 0, len(peer.attached))
+	for name := range peer.attached {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func (peer *Peer) handleMode(msg *ControlMessage) error {
+	var req ModeRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return NewControlError(ControlErrBadRequest, err.Error())
+	}
+
+	return peer.SetMode(req.Mode)
+}
+
+func (peer *Peer) handleAttach(msg *ControlMessage) error {
+	var req AttachRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return NewControlError(ControlErrBadRequest, err.Error())
+	}
+
+	if req.Stream == "" {
+		return NewControlError(ControlErrBadRequest, "stream not specified")
+	}
+
+	if msg.Type == ControlDetach {
+		return peer.DetachStream(req.Stream)
+	}
+
+	return peer.AttachStream(req.Stream)
+}
@@ -0,0 +1,121 @@
+package game
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/flarexio/game/session"
+)
+
+func TestRenegotiation(t *testing.T) {
+	assert := assert.New(t)
+
+	newAudio := func(stream string) *AudioTrack {
+		track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeOpus,
+		}, stream+"_audio", stream)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &AudioTrack{codec: CodecOpus, track: track}
+	}
+
+	game := &Stream{Name: "game", Audio: newAudio("game")}
+	camera := &Stream{Name: "camera", Audio: newAudio("camera")}
+
+	server, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer server.Close()
+
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer client.Close()
+
+	// answer applies an offer to the peer at the other end.
+	answer := func(pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		if err := pc.SetRemoteDescription(offer); err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			return answer, err
+		}
+
+		gatherComplete := webrtc.GatheringCompletePromise(pc)
+
+		if err := pc.SetLocalDescription(answer); err != nil {
+			return answer, err
+		}
+
+		<-gatherComplete
+
+		return *pc.LocalDescription(), nil
+	}
+
+	signaling, err := session.NewSessionManager().Open("peer", server)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	peer := &Peer{
+		PeerConnection: server,
+		signaling:      signaling,
+		log:            zap.NewNop(),
+		ctx:            context.Background(),
+		stream:         game,
+		mode:           PeerModeAudioOnly,
+		renegotiation: func(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+			return answer(client, offer)
+		},
+		findStream: func(name string) (*Stream, error) {
+			if name != camera.Name {
+				return nil, ErrStreamNotFound
+			}
+
+			return camera, nil
+		},
+	}
+
+	if _, err := server.AddTrack(game.Audio.Track()); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// the first negotiation, offered by the server too
+	if err := peer.renegotiate(); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(webrtc.SignalingStateStable, server.SignalingState())
+
+	assert.NoError(peer.AttachStream("camera"))
+	assert.Equal([]string{"camera"}, peer.AttachedStreams())
+	assert.True(strings.Contains(client.RemoteDescription().SDP, "msid:camera camera_audio"))
+
+	assert.Error(peer.AttachStream("game"))
+	assert.Error(peer.AttachStream("unknown"))
+
+	assert.NoError(peer.DetachStream("camera"))
+	assert.Empty(peer.AttachedStreams())
+	assert.Error(peer.DetachStream("camera"))
+
+	// stills peers keep their mode
+	peer.mode = PeerModeStills
+	assert.Error(peer.SetMode(PeerModeFull))
+}
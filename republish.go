@@ -0,0 +1,274 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Republish forwards a stream as MPEG-TS over SRT, e.g. to a broadcast
+// pipeline or a restreaming service.
+type Republish struct {
+	URL      *url.URL
+	StreamID string
+	Latency  time.Duration
+}
+
+func (cfg *Republish) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		URL      string        `yaml:"url"`
+		StreamID string        `yaml:"streamId"`
+		Latency  time.Duration `yaml:"latency"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(raw.URL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "srt" {
+		return errors.New("republish scheme not supported: " + u.Scheme)
+	}
+
+	if u.Port() == "" {
+		return errors.New("republish port not specified")
+	}
+
+	if raw.StreamID == "" {
+		raw.StreamID = u.Query().Get("streamid")
+	}
+
+	if raw.Latency == 0 {
+		raw.Latency = 120 * time.Millisecond
+	}
+
+	cfg.URL = u
+	cfg.StreamID = raw.StreamID
+	cfg.Latency = raw.Latency
+
+	return nil
+}
+
+// NewRepublisher muxes the samples of a stream into MPEG-TS and sends them
+// to the SRT destination, reconnecting whenever the connection is lost.
+// Only H264 video and Opus audio can be republished.
+func NewRepublisher(cfg *Republish, stream *Stream) (*Republisher, error) {
+	video := stream.Video != nil
+	if video && stream.Video.Codec() != CodecH264 {
+		return nil, errors.New("republish unsupported for video codec: " + string(stream.Video.Codec()))
+	}
+
+	audio := stream.Audio != nil
+	if audio && stream.Audio.Codec() != CodecOpus {
+		return nil, errors.New("republish unsupported for audio codec: " + string(stream.Audio.Codec()))
+	}
+
+	if !video && !audio {
+		return nil, errors.New("stream has no tracks to republish")
+	}
+
+	r := &Republisher{
+		log: zap.L().With(
+			zap.String("component", "republisher"),
+			zap.String("stream", stream.Name),
+			zap.String("destination", cfg.URL.Host),
+		),
+		cfg:   cfg,
+		queue: make(chan []byte, 1024),
+	}
+
+	r.mux = newTSMuxer(&tsChunker{r}, video, audio)
+
+	// NVStream hosts send keyframes only on request, and so may encoders
+	// with hooks.
+	r.requestKeyframe = func() {
+		go stream.requestKeyframe(context.Background())
+	}
+
+	return r, nil
+}
+
+type Republisher struct {
+	log   *zap.Logger
+	cfg   *Republish
+	mux   *tsMuxer
+	queue chan []byte
+
+	start           time.Time
+	connected       bool
+	requestKeyframe func()
+	cancel          context.CancelFunc
+	sync.Mutex
+}
+
+func (r *Republisher) VideoWriter() SampleWriter {
+	return &republishSink{r, true}
+}
+
+func (r *Republisher) AudioWriter() SampleWriter {
+	return &republishSink{r, false}
+}
+
+type republishSink struct {
+	r     *Republisher
+	video bool
+}
+
+func (sink *republishSink) WriteSample(sample media.Sample) error {
+	r := sink.r
+
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.connected {
+		return nil
+	}
+
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+
+	pts := time.Since(r.start).Microseconds() * 9 / 100
+
+	var err error
+	if sink.video {
+		err = r.mux.WriteVideo(pts, sample.Data)
+	} else {
+		err = r.mux.WriteAudio(pts, sample.Data)
+	}
+
+	if err != nil {
+		r.log.Error(err.Error())
+	}
+
+	return err
+}
+
+// tsChunker splits the muxer output into SRT payloads. Payloads are dropped
+// if the sender falls behind, and the muxer then waits for a keyframe.
+type tsChunker struct {
+	r *Republisher
+}
+
+func (c *tsChunker) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		size := min(len(p), srtPayloadSize)
+
+		select {
+		case c.r.queue <- p[:size]:
+		default:
+			c.r.log.Warn("republish queue full, waiting for keyframe")
+			c.r.reset()
+			return n, nil
+		}
+
+		p = p[size:]
+	}
+
+	return n, nil
+}
+
+func (r *Republisher) reset() {
+	r.mux.Reset()
+
+	if r.requestKeyframe != nil {
+		r.requestKeyframe()
+	}
+}
+
+func (r *Republisher) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+
+	go r.run(ctx)
+}
+
+func (r *Republisher) run(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		connected, err := r.publish(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if connected {
+			backoff = time.Second
+		}
+
+		r.log.Warn("republish disconnected", zap.Error(err), zap.Duration("retry", backoff))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (r *Republisher) publish(ctx context.Context) (bool, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	conn, err := dialSRT(dialCtx, r.cfg.URL.Host, r.cfg.StreamID, r.cfg.Latency)
+	cancel()
+
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	r.log.Info("republish connected")
+
+	r.Lock()
+	r.connected = true
+	r.start = time.Time{}
+	r.reset()
+	r.Unlock()
+
+	defer func() {
+		r.Lock()
+		r.connected = false
+		r.Unlock()
+
+		for len(r.queue) > 0 {
+			<-r.queue
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+
+		case <-conn.Done():
+			return true, conn.Err()
+
+		case payload := <-r.queue:
+			if _, err := conn.Write(payload); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+func (r *Republisher) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	return nil
+}
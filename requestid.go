@@ -0,0 +1,34 @@
+package game
+
+import (
+	"context"
+
+	"github.com/nats-io/nuid"
+)
+
+// A negotiation is correlated by a request ID: clients send it as
+// Request-Id with the ICE servers request and the offer, and the service
+// returns it as Request-Id on the replies and the candidates it publishes.
+// Requests get their ID, a new one if they come without, where they enter
+// the service, the NATS endpoints or the Connect API, and carry it on
+// their context. The logs of the negotiation and of the peer carry it as
+// request_id.
+const RequestIDHeader = "Request-Id"
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx correlated by the request ID, a new one if id
+// is empty.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		id = nuid.Next()
+	}
+
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID of ctx, empty without one.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
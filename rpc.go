@@ -0,0 +1,456 @@
+package game
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"github.com/pion/webrtc/v4"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	gamev1 "github.com/flarexio/game/api/game/v1"
+	"github.com/flarexio/game/api/game/v1/gamev1connect"
+)
+
+// ConnectAuth authenticates the callers of the Connect API.
+type ConnectAuth struct {
+	// Token is the bearer token callers present in the Authorization
+	// header. Negotiations with a guest token need none.
+	Token string
+
+	// Permissions are those of the peers negotiated without a guest token,
+	// as access grants them.
+	Permissions Permissions
+}
+
+// ConnectHandler serves the service over gRPC, gRPC-Web and Connect at the
+// path returned, to the callers auth authenticates. Pairing progress
+// travels over nc, as the service publishes it there.
+func ConnectHandler(svc Service, nc *nats.Conn, auth *ConnectAuth) (string, http.Handler) {
+	return gamev1connect.NewGameServiceHandler(
+		&connectServer{svc: svc, nc: nc, perms: auth.Permissions},
+		connect.WithInterceptors(&connectAuthInterceptor{token: auth.Token}),
+	)
+}
+
+type connectServer struct {
+	gamev1connect.UnimplementedGameServiceHandler
+	svc   Service
+	nc    *nats.Conn
+	perms Permissions
+}
+
+var errConnectUnauthenticated = connect.NewError(connect.CodeUnauthenticated, errors.New("invalid bearer token"))
+
+// connectAuthInterceptor refuses calls without the bearer token, except
+// negotiations of guests, whose guest token the service verifies.
+type connectAuthInterceptor struct {
+	token string
+}
+
+func (i *connectAuthInterceptor) authenticated(header http.Header) bool {
+	token, ok := strings.CutPrefix(header.Get("Authorization"), "Bearer ")
+	if !ok || i.token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(i.token)) == 1
+}
+
+func (i *connectAuthInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if i.authenticated(req.Header()) {
+			return next(ctx, req)
+		}
+
+		if msg, ok := req.Any().(*gamev1.NegotiateRequest); ok && msg.GuestToken != "" {
+			return next(ctx, req)
+		}
+
+		return nil, errConnectUnauthenticated
+	}
+}
+
+func (i *connectAuthInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *connectAuthInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if !i.authenticated(conn.RequestHeader()) {
+			return errConnectUnauthenticated
+		}
+
+		return next(ctx, conn)
+	}
+}
+
+// connectError maps errors of the service to Connect codes, as errorCode
+// does to those of the micro endpoints.
+func connectError(err error) error {
+	code := connect.CodeUnknown
+	switch errorCode(err) {
+	case "400":
+		code = connect.CodeInvalidArgument
+	case "401":
+		code = connect.CodeUnauthenticated
+	case "403":
+		code = connect.CodePermissionDenied
+	case "404":
+		code = connect.CodeNotFound
+	case "409":
+		code = connect.CodeResourceExhausted
+	case "503":
+		code = connect.CodeUnavailable
+	}
+
+	return connect.NewError(code, err)
+}
+
+func invalidArgument(msg string) error {
+	return connect.NewError(connect.CodeInvalidArgument, errors.New(msg))
+}
+
+func (s *connectServer) ListStreams(ctx context.Context, req *connect.Request[gamev1.ListStreamsRequest]) (*connect.Response[gamev1.ListStreamsResponse], error) {
+	manifests, err := s.svc.DescribeStreams(ctx)
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	res := new(gamev1.ListStreamsResponse)
+	for _, m := range manifests {
+		stream := &gamev1.StreamManifest{
+			Name:                m.Name,
+			Transport:           string(m.Transport),
+			Live:                m.Live,
+			Status:              string(m.Status),
+			Inputs:              m.Inputs,
+			Snapshots:           m.Snapshots,
+			MaxPeers:            int32(m.MaxPeers),
+			Peers:               int32(m.Peers),
+			ExclusiveController: m.ExclusiveController,
+		}
+
+		if video := m.Video; video != nil {
+			stream.Video = &gamev1.VideoManifest{
+				Codec:      string(video.Codec),
+				Renditions: video.Renditions,
+				Width:      int32(video.Width),
+				Height:     int32(video.Height),
+				Fps:        video.FPS,
+			}
+
+			for _, codec := range video.Codecs {
+				stream.Video.Codecs = append(stream.Video.Codecs, string(codec))
+			}
+		}
+
+		if audio := m.Audio; audio != nil {
+			stream.Audio = &gamev1.AudioManifest{
+				Codec:    string(audio.Codec),
+				Channels: int32(audio.Channels),
+			}
+		}
+
+		res.Streams = append(res.Streams, stream)
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+func (s *connectServer) GetICEServers(ctx context.Context, req *connect.Request[gamev1.GetICEServersRequest]) (*connect.Response[gamev1.GetICEServersResponse], error) {
+	provider, err := ParseICEProvider(req.Msg.Provider)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	ctx = WithRequestID(ctx, req.Header().Get(RequestIDHeader))
+
+	creds, err := s.svc.ICEServers(ctx, provider)
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	res := &gamev1.GetICEServersResponse{
+		RelayOnly: creds.RelayOnly,
+	}
+
+	if !creds.ExpiresAt.IsZero() {
+		res.ExpiresAt = timestamppb.New(creds.ExpiresAt)
+	}
+
+	for _, server := range creds.Servers {
+		ice := &gamev1.ICEServer{
+			Urls:     server.URLs,
+			Username: server.Username,
+		}
+
+		if server.Credential != nil {
+			ice.Credential = fmt.Sprint(server.Credential)
+		}
+
+		res.Servers = append(res.Servers, ice)
+	}
+
+	resp := connect.NewResponse(res)
+	resp.Header().Set(RequestIDHeader, RequestIDFrom(ctx))
+
+	return resp, nil
+}
+
+// Negotiate accepts the peer as the negotiation endpoint does. The API only
+// carries the offer and answer: signaling after the answer, such as
+// trickled candidates and ICE restarts, still goes over NATS on the inbox.
+func (s *connectServer) Negotiate(ctx context.Context, req *connect.Request[gamev1.NegotiateRequest]) (*connect.Response[gamev1.NegotiateResponse], error) {
+	msg := req.Msg
+
+	if msg.Sdp == "" {
+		return nil, invalidArgument("offer not specified")
+	}
+
+	// What access grants, unless the client asks for less.
+	perms := s.perms
+	if msg.Permissions != "" {
+		parsed, err := ParsePermissions(msg.Permissions)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
+		perms &= parsed
+	}
+
+	mode, err := ParsePeerMode(msg.Mode)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	if msg.StillsIntervalMs < 0 {
+		return nil, invalidArgument("invalid stills interval")
+	}
+
+	inbox := msg.Inbox
+	if inbox == "" {
+		inbox = nuid.Next()
+	}
+
+	offer := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  msg.Sdp,
+	}
+
+	ctx = WithRequestID(ctx, req.Header().Get(RequestIDHeader))
+
+	peer, err := s.svc.AcceptPeer(ctx, offer, "peers.negotiation."+inbox, PeerOptions{
+		Stream:         msg.Stream,
+		Permissions:    perms,
+		GuestToken:     msg.GuestToken,
+		Mode:           mode,
+		StillsInterval: time.Duration(msg.StillsIntervalMs) * time.Millisecond,
+	})
+
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	res := &gamev1.NegotiateResponse{
+		Sdp:  peer.LocalDescription().SDP,
+		Peer: peer.ID(),
+	}
+
+	for _, d := range peer.Downgrades() {
+		res.Downgrades = append(res.Downgrades, &gamev1.Downgrade{
+			Track:     d.Track,
+			Property:  d.Property,
+			Preferred: d.Preferred,
+			Applied:   d.Applied,
+			Reason:    d.Reason,
+		})
+	}
+
+	if target := peer.stream.TargetLatency; target != nil {
+		res.TargetLatencyMs = target.Milliseconds()
+	}
+
+	resp := connect.NewResponse(res)
+	resp.Header().Set(RequestIDHeader, peer.RequestID())
+
+	return resp, nil
+}
+
+func (s *connectServer) Pair(ctx context.Context, req *connect.Request[gamev1.PairRequest], stream *connect.ServerStream[gamev1.PairResponse]) error {
+	name := req.Msg.Stream
+	if name == "" {
+		name = DefaultStream
+	}
+
+	reply := nats.NewInbox()
+
+	progress := make(chan *nats.Msg, 16)
+	sub, err := s.nc.ChanSubscribe(reply+".progress", progress)
+	if err != nil {
+		return connectError(err)
+	}
+	defer sub.Unsubscribe()
+
+	type outcome struct {
+		result *PairResult
+		err    error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := s.svc.Pair(ctx, name, reply)
+		done <- outcome{result, err}
+	}()
+
+	sendProgress := func(msg *nats.Msg) error {
+		var p PairProgress
+		if err := json.Unmarshal(msg.Data, &p); err != nil {
+			return nil
+		}
+
+		return stream.Send(&gamev1.PairResponse{
+			Event: &gamev1.PairResponse_Progress{
+				Progress: &gamev1.PairProgress{Stage: p.Stage, Pin: p.PIN},
+			},
+		})
+	}
+
+	for {
+		select {
+		case msg := <-progress:
+			if err := sendProgress(msg); err != nil {
+				return err
+			}
+
+		case o := <-done:
+			// Progress published before the result may still be queued.
+			s.nc.Flush()
+			for len(progress) > 0 {
+				if err := sendProgress(<-progress); err != nil {
+					return err
+				}
+			}
+
+			if o.err != nil {
+				return connectError(o.err)
+			}
+
+			return stream.Send(&gamev1.PairResponse{
+				Event: &gamev1.PairResponse_Result{
+					Result: &gamev1.PairResult{
+						State:  o.result.State.String(),
+						Reason: o.result.Reason,
+					},
+				},
+			})
+
+		case <-ctx.Done():
+			// The pairing goes on until PairTimeout.
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *connectServer) ListPeers(ctx context.Context, req *connect.Request[gamev1.ListPeersRequest]) (*connect.Response[gamev1.ListPeersResponse], error) {
+	peers, err := s.svc.ListPeers(ctx)
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	structs, err := toStructs(peers)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&gamev1.ListPeersResponse{Peers: structs}), nil
+}
+
+func (s *connectServer) GetStats(ctx context.Context, req *connect.Request[gamev1.GetStatsRequest]) (*connect.Response[gamev1.GetStatsResponse], error) {
+	input, err := s.svc.InputStats(ctx)
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	channels, err := s.svc.ChannelStats(ctx)
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	peers, err := s.svc.PeerLatency(ctx)
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	nv, err := s.svc.NVStreamStats(ctx)
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	res := new(gamev1.GetStatsResponse)
+
+	if res.Input, err = toStructs(input); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	if res.Channels, err = toStructs(channels); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	if res.Peers, err = toStructs(peers); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	if res.Nvstream, err = toStructs(nv); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+func (s *connectServer) GetHealth(ctx context.Context, req *connect.Request[gamev1.GetHealthRequest]) (*connect.Response[gamev1.GetHealthResponse], error) {
+	health, err := s.svc.Health(ctx)
+	if err != nil {
+		return nil, connectError(err)
+	}
+
+	structs, err := toStructs([]*Health{health})
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&gamev1.GetHealthResponse{
+		Ready:  health.Ready,
+		Health: structs[0],
+	}), nil
+}
+
+// toStructs converts values to structs through their JSON, so the API
+// carries the same fields as the NATS endpoints.
+func toStructs[T any](values []T) ([]*structpb.Struct, error) {
+	structs := make([]*structpb.Struct, 0, len(values))
+	for _, v := range values {
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		s := new(structpb.Struct)
+		if err := s.UnmarshalJSON(bs); err != nil {
+			return nil, err
+		}
+
+		structs = append(structs, s)
+	}
+
+	return structs, nil
+}
@@ -0,0 +1,136 @@
+package game
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+
+	gamev1 "github.com/flarexio/game/api/game/v1"
+	"github.com/flarexio/game/api/game/v1/gamev1connect"
+)
+
+func TestConnectHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var accepted []PeerOptions
+
+	svc := &mockService{
+		describeStreams: func() ([]*StreamManifest, error) {
+			return []*StreamManifest{{
+				Name:      "game",
+				Transport: TransportRaw,
+				Live:      true,
+				Video:     &VideoManifest{Codec: CodecH264, Codecs: []Codec{CodecH264, CodecAV1}, FPS: 60},
+				Audio:     &AudioManifest{Codec: CodecOpus},
+				Inputs:    []string{"gamepad"},
+			}}, nil
+		},
+		acceptPeer: func(ctx context.Context, offer webrtc.SessionDescription, reply string, opts PeerOptions) (*Peer, error) {
+			accepted = append(accepted, opts)
+
+			if opts.GuestToken != "" {
+				return nil, ErrGuestTokenRevoked
+			}
+
+			return nil, ErrStreamNotFound
+		},
+		health: func() (*Health, error) {
+			return &Health{Ready: true, Subscriptions: 2}, nil
+		},
+	}
+
+	path, handler := ConnectHandler(svc, nil, &ConnectAuth{
+		Token:       "secret",
+		Permissions: PermissionGamepad | PermissionMouse,
+	})
+
+	assert.Equal("/game.v1.GameService/", path)
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	bearer := func(token string) connect.UnaryInterceptorFunc {
+		return func(next connect.UnaryFunc) connect.UnaryFunc {
+			return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+				req.Header().Set("Authorization", "Bearer "+token)
+				return next(ctx, req)
+			}
+		}
+	}
+
+	client := gamev1connect.NewGameServiceClient(srv.Client(), srv.URL,
+		connect.WithInterceptors(bearer("secret")))
+
+	ctx := context.Background()
+
+	// callers without the token are refused, but for guests negotiating
+	anonymous := gamev1connect.NewGameServiceClient(srv.Client(), srv.URL)
+
+	_, err := anonymous.ListStreams(ctx, connect.NewRequest(&gamev1.ListStreamsRequest{}))
+	assert.Equal(connect.CodeUnauthenticated, connect.CodeOf(err))
+
+	wrong := gamev1connect.NewGameServiceClient(srv.Client(), srv.URL,
+		connect.WithInterceptors(bearer("guess")))
+
+	_, err = wrong.Negotiate(ctx, connect.NewRequest(&gamev1.NegotiateRequest{Sdp: "v=0"}))
+	assert.Equal(connect.CodeUnauthenticated, connect.CodeOf(err))
+
+	pairing, err := anonymous.Pair(ctx, connect.NewRequest(&gamev1.PairRequest{}))
+	if assert.NoError(err) {
+		assert.False(pairing.Receive())
+		assert.Equal(connect.CodeUnauthenticated, connect.CodeOf(pairing.Err()))
+	}
+
+	_, err = anonymous.Negotiate(ctx, connect.NewRequest(&gamev1.NegotiateRequest{Sdp: "v=0", GuestToken: "x"}))
+	assert.Equal(connect.CodeUnauthenticated, connect.CodeOf(err))
+
+	if assert.Len(accepted, 1) {
+		assert.Equal("x", accepted[0].GuestToken)
+	}
+
+	// peers get what access grants, narrowed by what they ask for
+	_, err = client.Negotiate(ctx, connect.NewRequest(&gamev1.NegotiateRequest{Sdp: "v=0"}))
+	assert.Equal(connect.CodeNotFound, connect.CodeOf(err))
+
+	_, err = client.Negotiate(ctx, connect.NewRequest(&gamev1.NegotiateRequest{Sdp: "v=0", Permissions: "gamepad,keyboard"}))
+	assert.Equal(connect.CodeNotFound, connect.CodeOf(err))
+
+	if assert.Len(accepted, 3) {
+		assert.Equal(PermissionGamepad|PermissionMouse, accepted[1].Permissions)
+		assert.Equal(PermissionGamepad, accepted[2].Permissions)
+	}
+
+	streams, err := client.ListStreams(ctx, connect.NewRequest(&gamev1.ListStreamsRequest{}))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if assert.Len(streams.Msg.Streams, 1) {
+		stream := streams.Msg.Streams[0]
+		assert.Equal("game", stream.Name)
+		assert.Equal("raw", stream.Transport)
+		assert.Equal([]string{"h264", "av1"}, stream.Video.Codecs)
+		assert.Equal(60.0, stream.Video.Fps)
+		assert.Equal("opus", stream.Audio.Codec)
+	}
+
+	_, err = client.Negotiate(ctx, connect.NewRequest(&gamev1.NegotiateRequest{}))
+	assert.Equal(connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = client.Negotiate(ctx, connect.NewRequest(&gamev1.NegotiateRequest{Sdp: "v=0", Mode: "vr"}))
+	assert.Equal(connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	health, err := client.GetHealth(ctx, connect.NewRequest(&gamev1.GetHealthRequest{}))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(health.Msg.Ready)
+	assert.Equal(2.0, health.Msg.Health.Fields["subscriptions"].GetNumberValue())
+}
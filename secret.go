@@ -0,0 +1,126 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveSecret resolves a secret field of the config, so tokens and
+// credentials can be injected at runtime instead of written to the file:
+//
+//	${VAR}              replaced by the environment variable, also within a value
+//	file:path           the contents of the file, without a trailing newline
+//	vault:path#field    the field of a Vault KV secret, at $VAULT_ADDR with $VAULT_TOKEN
+//
+// Other values are used as they are.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "file:"):
+		bs, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(bs), "\r\n"), nil
+
+	case strings.HasPrefix(value, "vault:"):
+		return readVaultSecret(strings.TrimPrefix(value, "vault:"))
+	}
+
+	var err error
+	value = envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = errors.New("environment variable not set: " + name)
+		}
+
+		return v
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// isSecretReference reports whether resolveSecret would replace value.
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, "file:") ||
+		strings.HasPrefix(value, "vault:") ||
+		envReference.MatchString(value)
+}
+
+// resolveSecrets resolves the given secret fields in place.
+func resolveSecrets(values ...*string) error {
+	for _, value := range values {
+		v, err := resolveSecret(*value)
+		if err != nil {
+			return err
+		}
+
+		*value = v
+	}
+
+	return nil
+}
+
+// readVaultSecret reads a field of a secret from the HTTP API of Vault, as
+// path#field. Secrets of the KV version 2 engine nest their fields in data.
+func readVaultSecret(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", errors.New("invalid vault reference: " + ref)
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", errors.New("vault address not specified: set VAULT_ADDR")
+	}
+
+	client := resty.New().
+		SetBaseURL(strings.TrimSuffix(addr, "/") + "/v1").
+		SetTimeout(10 * time.Second)
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+
+	resp, err := client.R().
+		SetHeader("X-Vault-Token", os.Getenv("VAULT_TOKEN")).
+		SetResult(&secret).
+		Get("/" + strings.TrimPrefix(path, "/"))
+
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return "", fmt.Errorf("vault %s: %s", path, resp.Status())
+	}
+
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault %s: field not found: %s", path, field)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,143 @@
+package game
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestResolveSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv("GAME_TURN_TOKEN", "s3cret")
+
+	v, err := resolveSecret("${GAME_TURN_TOKEN}")
+	assert.NoError(err)
+	assert.Equal("s3cret", v)
+
+	v, err = resolveSecret("Bearer ${GAME_TURN_TOKEN}")
+	assert.NoError(err)
+	assert.Equal("Bearer s3cret", v)
+
+	// plain values and bare $ are kept
+	v, err = resolveSecret("pa$$word")
+	assert.NoError(err)
+	assert.Equal("pa$$word", v)
+
+	_, err = resolveSecret("${GAME_UNSET_TOKEN}")
+	assert.ErrorContains(err, "GAME_UNSET_TOKEN")
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	v, err = resolveSecret("file:" + path)
+	assert.NoError(err)
+	assert.Equal("from-file", v)
+
+	_, err = resolveSecret("file:" + path + ".missing")
+	assert.Error(err)
+}
+
+func TestVaultSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/v1/secret/data/game": // KV v2
+			w.Write([]byte(`{"data":{"data":{"token":"kv2"},"metadata":{"version":1}}}`))
+		case "/v1/kv/game": // KV v1
+			w.Write([]byte(`{"data":{"token":"kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+
+	v, err := resolveSecret("vault:secret/data/game#token")
+	assert.NoError(err)
+	assert.Equal("kv2", v)
+
+	v, err = resolveSecret("vault:kv/game#token")
+	assert.NoError(err)
+	assert.Equal("kv1", v)
+
+	_, err = resolveSecret("vault:kv/game#password")
+	assert.ErrorContains(err, "field not found")
+
+	_, err = resolveSecret("vault:kv/missing#token")
+	assert.ErrorContains(err, "404")
+
+	_, err = resolveSecret("vault:kv/game")
+	assert.ErrorContains(err, "invalid vault reference")
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	_, err = resolveSecret("vault:kv/game#token")
+	assert.ErrorContains(err, "403")
+}
+
+func TestConfigSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv("GAME_CF_ID", "key-id")
+	t.Setenv("GAME_CF_TOKEN", "key-token")
+	t.Setenv("GAME_SUNSHINE_PASSWORD", "hunter2")
+	t.Setenv("GAME_GUEST_SECRET", "guests")
+
+	config := `
+webrtc:
+  iceServers:
+  - provider: cloudflare
+    id: ${GAME_CF_ID}
+    token: ${GAME_CF_TOKEN}
+streams:
+- name: game
+  transport: nvstream
+  address: https://localhost:47984
+  sunshine:
+    url: https://localhost:47990
+    username: admin
+    password: ${GAME_SUNSHINE_PASSWORD}
+guests:
+  secret: ${GAME_GUEST_SECRET}
+tracing:
+  headers:
+    Authorization: Bearer ${GAME_CF_TOKEN}
+`
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(config), &cfg); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(Cloudflare, cfg.WebRTC.ICEServers[0].Provider)
+	assert.Equal("key-id", cfg.WebRTC.ICEServers[0].ID)
+	assert.Equal("key-token", cfg.WebRTC.ICEServers[0].Token)
+	assert.Equal("admin", cfg.Streams[0].Sunshine.Username)
+	assert.Equal("hunter2", cfg.Streams[0].Sunshine.Password)
+	assert.Equal("guests", cfg.Guests.Secret)
+	assert.Equal("Bearer key-token", cfg.Tracing.Headers["Authorization"])
+
+	// the stream's spec keeps the reference, not the secret
+	out, err := yaml.Marshal(cfg.Streams[0].spec)
+	assert.NoError(err)
+	assert.Contains(string(out), "${GAME_SUNSHINE_PASSWORD}")
+}
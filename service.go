@@ -2,13 +2,18 @@ package game
 
 import (
 	"context"
-	"encoding/binary"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,388 +23,1315 @@ import (
 	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media"
 	"github.com/pion/webrtc/v4/pkg/media/h264reader"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
 	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	"github.com/flarexio/core/model"
 	"github.com/flarexio/game/nvstream"
+	"github.com/flarexio/game/session"
+	"github.com/flarexio/game/telemetry"
 	"github.com/flarexio/game/thirdparty/moonlight"
 )
 
 type Service interface {
-	FindStream(name string) (*Stream, error)
+	FindStream(ctx context.Context, name string) (*Stream, error)
 
 	// TODO: migrate to a dedicated ICE Server provider
-	ICEServers(provider ICEProvider) ([]webrtc.ICEServer, error)
-	AcceptPeer(offer webrtc.SessionDescription, reply string) (*Peer, error)
+	ICEServers(ctx context.Context, provider ICEProvider) (*ICECredentials, error)
+	AcceptPeer(ctx context.Context, offer webrtc.SessionDescription, reply string, opts PeerOptions) (*Peer, error)
+	Snapshot(ctx context.Context, name string, opts SnapshotOptions) (*Snapshot, error)
+	DescribeStreams(ctx context.Context) ([]*StreamManifest, error)
+	AddStream(ctx context.Context, stream *Stream) (*StreamManifest, error)
+	RemoveStream(ctx context.Context, name string) error
+	StartStream(ctx context.Context, name string) error
+	StopStream(ctx context.Context, name string) error
+	CreateGuestToken(ctx context.Context, opts GuestOptions) (*GuestToken, error)
+	RevokeGuestToken(ctx context.Context, id string) error
+	Pair(ctx context.Context, name string, reply string) (*PairResult, error)
+	Apps(ctx context.Context, name string) ([]*App, error)
+	AppBoxArt(ctx context.Context, name string, appID int) ([]byte, error)
+	InputStats(ctx context.Context) ([]LatencyStats, error)
+	ChannelStats(ctx context.Context) ([]LabelStats, error)
+	ListPeers(ctx context.Context) ([]*PeerInfo, error)
+	PeerLatency(ctx context.Context) ([]PeerLatencyStats, error)
+	NVStreamStats(ctx context.Context) ([]NVStreamStats, error)
+	Health(ctx context.Context) (*Health, error)
 	Close() error
 }
 
+const DefaultStream = "gamestream"
+
+var ErrStreamNotFound = errors.New("stream not found")
+
+// newGamepad creates the service's virtual gamepad; tests replace it.
+var newGamepad = NewGamepad
+
+type PeerOptions struct {
+	Stream         string
+	Permissions    Permissions // asked for, narrowing what access grants
+	GuestToken     string      // overrides Stream and Permissions with the token's
+	Mode           PeerMode
+	StillsInterval time.Duration
+}
+
+// PairTimeout bounds a remote pairing, including the time the operator
+// takes to enter the PIN on the host.
+const PairTimeout = 2 * time.Minute
+
+// PairProgress is published while a remote pairing runs.
+type PairProgress struct {
+	Stage string `json:"stage"`
+	PIN   string `json:"pin,omitempty"`
+}
+
+type PairResult struct {
+	State  nvstream.PairState `json:"state"`
+	Reason string             `json:"reason,omitempty"`
+}
+
 type ServiceMiddleware func(next Service) Service
 
 func NewService(cfg *Config, nc *nats.Conn) (Service, error) {
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
+	log := zap.L().With(
+		zap.String("service", "game"),
+	)
 
 	svc := &service{
-		log: zap.L().With(
-			zap.String("service", "game"),
-		),
-		cfg:    cfg,
-		nc:     nc,
-		peers:  make([]*Peer, 0),
-		cancel: cancel,
+		log:       log,
+		cfg:       cfg,
+		nc:        nc,
+		lifecycle: NewLifecycle(context.Background(), log),
+		events:    NewEventBus(nc, log),
+		metrics:   NewChannelMetrics(),
+		signaling: session.NewSessionManager(),
 	}
 
-	err := svc.buildStreams(ctx, cfg.Streams)
-	if err != nil {
+	if err := svc.build(); err != nil {
+		svc.Close()
 		return nil, err
 	}
 
-	gamepad, err := NewGamepad()
-	if err != nil {
-		return nil, err
+	return svc, nil
+}
+
+// build starts the components in dependency order. The lifecycle stops
+// them in reverse: peers first, then outputs, stream sources and finally
+// the gamepad.
+func (svc *service) build() error {
+	cfg := svc.cfg
+
+	if tracing := cfg.Tracing; tracing != nil && tracing.Enabled {
+		shutdown, err := telemetry.Setup(tracing.Endpoint, tracing.ServiceName, tracing.Headers, tracing.Interval)
+		if err != nil {
+			return err
+		}
+
+		svc.lifecycle.Add("tracing", 0, shutdown)
 	}
 
-	if err := gamepad.Connect(); err != nil {
-		return nil, err
+	gamepadCfg := cfg.Gamepad
+	if gamepadCfg == nil {
+		gamepadCfg = defaultGamepad
 	}
 
-	svc.gamepad = gamepad
+	backend := gamepadCfg.Backend
+	create := gamepadFactory(gamepadCfg)
 
-	return svc, nil
-}
+	// Without gamepad input, no driver is needed.
+	if gamepadCfg.Disabled {
+		backend = GamepadBackendNone
+		create = NewNullGamepad
+	}
 
-type service struct {
-	log     *zap.Logger
-	cfg     *Config
-	nc      *nats.Conn
-	streams map[string]*Stream
-	peers   []*Peer
-	gamepad Gamepad
-	cancel  context.CancelFunc
-	sync.RWMutex
-}
+	gamepad, err := connectGamepad(create)
+	if err != nil {
+		if !gamepadCfg.Fallback || backend == GamepadBackendNone {
+			return err
+		}
 
-func (svc *service) buildStreams(ctx context.Context, streams []*Stream) error {
-	streamMap := make(map[string]*Stream)
-	for _, stream := range streams {
-		switch stream.Transport {
-		case TransportRaw:
-			if video := stream.Video; video != nil {
-				if video.Codec() == CodecNone {
-					return errors.New("video codec not specified")
-				}
+		svc.log.Warn("gamepad backend unavailable, falling back to none",
+			zap.String("backend", string(backend)),
+			zap.Error(err))
 
-				trackID := stream.Name + "_video"
+		backend = GamepadBackendNone
+		create = NewNullGamepad
 
-				track, err := webrtc.NewTrackLocalStaticSample(
-					webrtc.RTPCodecCapability{
-						MimeType: video.Codec().MimeType(),
-					}, trackID, stream.Name,
-				)
+		gamepad, err = connectGamepad(create)
+		if err != nil {
+			return err
+		}
+	}
 
-				if err != nil {
-					return err
-				}
+	svc.lifecycle.Add("gamepad", 0, func(ctx context.Context) error {
+		gamepad.Close()
+		return nil
+	})
 
-				video.track = track
+	svc.gamepad = gamepad
+	svc.gamepadCfg = gamepadCfg
+	svc.gamepadBackend = backend
 
-				go svc.listen(ctx, video)
-			}
+	inputCfg := cfg.Input
+	if inputCfg == nil {
+		inputCfg = defaultInput
+	}
 
-			if audio := stream.Audio; audio != nil {
-				if audio.Codec() == CodecNone {
-					return errors.New("audio codec not specified")
-				}
+	svc.gamepads = NewGamepadManager(gamepad, create, inputCfg.LatencyBudget)
+	svc.gamepads.SetControllers(gamepadCfg.Controllers)
+	svc.gamepadRate = inputCfg.MaxGamepadRate
+	svc.lifecycle.Add("gamepads", 0, func(ctx context.Context) error {
+		svc.gamepads.Close()
+		return nil
+	})
 
-				trackID := stream.Name + "_audio"
+	svc.iceRestart = cfg.WebRTC.ICERestart
+	if svc.iceRestart == nil {
+		svc.iceRestart = defaultICERestart
+	}
 
-				track, err := webrtc.NewTrackLocalStaticSample(
-					webrtc.RTPCodecCapability{
-						MimeType: audio.Codec().MimeType(),
-					}, trackID, stream.Name,
-				)
+	svc.serverICE = cfg.WebRTC.Server
+	if svc.serverICE == nil {
+		svc.serverICE = defaultServerICEPolicy
+	}
 
-				if err != nil {
-					return err
-				}
+	svc.clientICE = cfg.WebRTC.Client
+	if svc.clientICE == nil {
+		svc.clientICE = defaultClientICEPolicy
+	}
 
-				audio.track = track
+	for _, policy := range []*ICEPolicy{svc.serverICE, svc.clientICE} {
+		if err := policy.validate(cfg.WebRTC.ICEServers); err != nil {
+			return err
+		}
+	}
 
-				go svc.listen(ctx, audio)
-			}
+	iceBreakerCfg := cfg.WebRTC.ICEBreaker
+	if iceBreakerCfg == nil {
+		iceBreakerCfg = defaultICEBreaker
+	}
 
-		case TransportNV:
-			// Resolve NVStream App
-			host := stream.Address.Hostname()
+	svc.iceBreaker = newICEBreaker(iceBreakerCfg,
+		svc.log.With(zap.String("component", "ice_breaker")))
 
-			http, err := nvstream.NewHTTP("MyGameClient", host, svc.cfg.Path)
-			if err != nil {
-				return err
-			}
+	iceCheck := cfg.WebRTC.ICEHealth
+	if iceCheck == nil {
+		iceCheck = defaultICEHealthCheck
+	}
 
-			appList, err := http.AppList()
-			if err != nil {
-				return err
-			}
+	svc.iceHealth = newICEHealth(cfg.WebRTC.ICEServers,
+		func(ctx context.Context, server *ICEServer) error {
+			return checkICEProvider(ctx, server, iceCheck.Timeout)
+		},
+		svc.log.With(zap.String("component", "ice_health")),
+	)
 
-			var app nvstream.NvApp
-			for _, a := range appList {
-				if !strings.Contains(a.Name, stream.NVStream.App.Name) {
-					continue
-				}
+	if !iceCheck.Disabled && len(cfg.WebRTC.ICEServers) > 0 {
+		ctx := svc.lifecycle.Add("ice_health", 0, nil)
+		go svc.iceHealth.Run(ctx, iceCheck.Interval)
+	}
 
-				app = a
-			}
+	// Keyboard and mouse input of NVStream streams travels over the
+	// connection; other transports inject it on this host.
+	if slices.ContainsFunc(cfg.Streams, func(s *Stream) bool { return s.Transport != TransportNV }) {
+		input, err := newInput()
+		if err != nil {
+			svc.log.Warn("input injection unavailable",
+				zap.String("action", "build"),
+				zap.Error(err))
+		} else {
+			svc.lifecycle.Add("input", 0, func(ctx context.Context) error {
+				input.Close()
+				return nil
+			})
 
-			if (app == nvstream.NvApp{}) {
-				return errors.New("nvstream app not found: " + stream.NVStream.App.Name)
-			}
+			svc.input = input
+		}
+	}
 
-			stream.NVStream.App = app
+	if err := svc.buildStreams(context.Background(), cfg.Streams); err != nil {
+		return err
+	}
 
-			conn, err := nvstream.NewConnection(http, stream.NVStream)
-			if err != nil {
-				return err
-			}
+	if rec := cfg.Recordings; rec != nil && (rec.Enabled || rec.Replay > 0) {
+		if err := svc.buildRecorders(rec); err != nil {
+			return err
+		}
+	}
+
+	if err := svc.buildRepublishers(); err != nil {
+		return err
+	}
 
-			vs := nvstream.NewVideoStream()
-			as := nvstream.NewAudioStream()
+	if files := cfg.Files; files != nil && files.Enabled {
+		dir, err := fileDir(cfg.Path, files.Path)
+		if err != nil {
+			return err
+		}
 
-			moonlight.SetupCallbacks(conn, vs, as)
+		files.dir = dir
 
-			if err := conn.StartApp(ctx, app); err != nil {
+		for _, target := range files.Targets {
+			dir, err := fileDir(cfg.Path, target.Path)
+			if err != nil {
 				return err
 			}
 
-			if video := stream.Video; video != nil {
-				trackID := stream.Name + "_video"
+			target.dir = dir
+		}
 
-				track, err := webrtc.NewTrackLocalStaticSample(
-					webrtc.RTPCodecCapability{
-						MimeType: video.Codec().MimeType(),
-					}, trackID, stream.Name,
-				)
+		svc.files = files
+	}
 
-				if err != nil {
-					return err
-				}
+	if mic := cfg.Microphone; mic != nil && mic.Enabled {
+		svc.microphone = mic
+	}
 
-				video.track = track
+	if cam := cfg.Camera; cam != nil && cam.Enabled {
+		svc.camera = cam
+	}
 
-				if err := svc.trackHandler(ctx, vs, video); err != nil {
-					return err
-				}
-			}
+	if cfg.Access == nil {
+		svc.log.Warn("no access block, peers without a guest token get all permissions")
+	}
+
+	guestsCfg := cfg.Guests
+	if guestsCfg == nil {
+		guestsCfg = defaultGuests
+	}
 
-			if audio := stream.Audio; audio != nil {
-				trackID := stream.Name + "_audio"
+	guests, err := newGuestIssuer(guestsCfg)
+	if err != nil {
+		return err
+	}
 
-				track, err := webrtc.NewTrackLocalStaticSample(
-					webrtc.RTPCodecCapability{
-						MimeType: audio.Codec().MimeType(),
-					}, trackID, stream.Name,
-				)
+	svc.guests = guests
 
-				if err != nil {
-					return err
-				}
+	svc.channels = defaultDataChannelRouter()
 
-				audio.track = track
+	// Stopped after the peers, sweeping what they left behind.
+	svc.subs = newPeerSubscriptions(svc.log.With(zap.String("component", "subscriptions")))
 
-				if err := svc.trackHandler(ctx, as, audio); err != nil {
-					return err
-				}
-			}
+	subsCtx := svc.lifecycle.Add("subscriptions", 0, nil)
+	go svc.subs.Run(subsCtx, SubscriptionSweepInterval, PeerConnectTimeout)
 
-		default:
-			return errors.New("transport unsupported")
+	if sessions := cfg.Sessions; sessions != nil && sessions.Enabled {
+		ctx, cancel := context.WithTimeout(context.Background(), sessionStoreTimeout)
+		defer cancel()
+
+		store, err := newKVSessions(ctx, svc.nc, sessions)
+		if err != nil {
+			return err
 		}
 
-		streamMap[stream.Name] = stream
+		svc.sessions = store
+		svc.sessionCfg = sessions
+
+		// Stopped after the peers, which keep their sessions.
+		sessionsCtx := svc.lifecycle.Add("sessions", 0, nil)
+		go svc.refreshSessions(sessionsCtx, sessions.TTL/3)
+		go svc.resumeSessions(sessionsCtx)
 	}
 
-	svc.streams = streamMap
+	svc.lifecycle.Add("peers", 0, svc.closePeers)
 
 	return nil
 }
 
-func (svc *service) listen(ctx context.Context, track Track) {
-	url := track.Address()
+type service struct {
+	log            *zap.Logger
+	cfg            *Config
+	nc             *nats.Conn
+	streams        map[string]*Stream
+	files          *FileDrop
+	microphone     *Microphone
+	camera         *Camera
+	guests         *guestIssuer
+	gamepad        Gamepad
+	gamepads       *GamepadManager
+	gamepadRate    int
+	gamepadCfg     *GamepadConfig
+	gamepadBackend GamepadBackend
+	iceRestart     *ICERestart
+	iceHealth      *iceHealth
+	iceBreaker     *iceBreaker
+	serverICE      *ICEPolicy
+	clientICE      *ICEPolicy
+	subs           *peerSubscriptions
+	signaling      *session.SessionManager // by peer ID
+	input          Input
+	channels       *DataChannelRouter
+	events         *EventBus
+	metrics        *ChannelMetrics
+	lifecycle      *Lifecycle
+	sessions       sessionStore // nil when sessions are not persisted
+	sessionCfg     *Sessions
+	stopped        map[string]*Stream // configured but not running
+	order          []string           // of the streams in config.yaml
+	manage         sync.Mutex         // serializes changes to the streams
+	sync.RWMutex
+}
 
-	network := url.Scheme
+func (svc *service) buildStreams(ctx context.Context, streams []*Stream) error {
+	svc.streams = make(map[string]*Stream)
+	svc.stopped = make(map[string]*Stream)
 
-	address := url.Host
-	if url.Scheme == "unix" {
-		address = url.Path
-	}
+	for _, stream := range streams {
+		if svc.hasStream(stream.Name) {
+			return fmt.Errorf("%w: %s", ErrStreamExists, stream.Name)
+		}
 
-	log := svc.log.With(
-		zap.String("action", "listen"),
-		zap.String("network", network),
-		zap.String("address", address),
-	)
+		svc.order = append(svc.order, stream.Name)
 
-	if strings.HasPrefix(network, "udp") {
-		addr, err := net.ResolveUDPAddr(network, address)
-		if err != nil {
-			log.Error(err.Error())
-			return
+		if stream.Disabled {
+			svc.stopped[stream.Name] = stream
+			continue
 		}
 
-		conn, err := net.ListenUDP(network, addr)
-		if err != nil {
-			log.Error(err.Error())
-			return
+		if err := svc.buildStream(ctx, stream); err != nil {
+			return err
 		}
 
-		log.Info("socket opened")
+		svc.streams[stream.Name] = stream
+	}
 
-		ctx = context.WithValue(ctx, model.Logger, log)
+	return nil
+}
 
-		if err := svc.trackHandler(ctx, conn, track); err != nil {
-			log.Error(err.Error())
-		}
+// buildStream starts the stream's source and tracks. Its components are
+// named after the stream, so it can be stopped on its own. reqCtx bounds
+// the start of the stream, while the stream runs until it is stopped.
+func (svc *service) buildStream(reqCtx context.Context, stream *Stream) error {
+	if err := stream.checkVariants(); err != nil {
+		return fmt.Errorf("stream %s: %w", stream.Name, err)
+	}
 
-		return
+	if err := stream.checkRenditions(); err != nil {
+		return fmt.Errorf("stream %s: %w", stream.Name, err)
 	}
 
-	listener, err := net.Listen(network, address)
-	if err != nil {
-		log.Error(err.Error())
-		return
+	if stream.Hooks != nil && stream.Transport != TransportRaw {
+		return fmt.Errorf("stream %s: hooks unsupported for transport: %s", stream.Name, stream.Transport)
 	}
 
-	log.Info("socket opened")
+	if stream.Launch != nil && stream.Transport != TransportNV {
+		return fmt.Errorf("stream %s: launch unsupported for transport: %s", stream.Name, stream.Transport)
+	}
 
-	go func(ctx context.Context, listener net.Listener) {
-		<-ctx.Done()
+	ctx := svc.lifecycle.Add("stream."+stream.Name, 0, stream.stop)
 
-		listener.Close()
-		log.Info("socket closed")
-	}(ctx, listener)
+	// restart restarts the source of a stalled track.
+	var restart func(ctx context.Context, track Track) error
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Error(err.Error())
-			return
+	// source sleeps while the stream is idle.
+	var source idleSource
+
+	switch stream.Transport {
+	case TransportRaw:
+		if stream.Hooks != nil {
+			stream.source = &hookSource{stream.Name, stream.Hooks}
 		}
 
-		log := log.With(
-			zap.String("remote", conn.RemoteAddr().String()),
-		)
+		listeners := make(map[Track]chan struct{})
 
-		ctx = context.WithValue(ctx, model.Logger, log)
+		restart = func(ctx context.Context, track Track) error {
+			select {
+			case listeners[track] <- struct{}{}:
+			default:
+			}
 
-		if err := svc.trackHandler(ctx, conn, track); err != nil {
-			log.Error(err.Error())
+			return nil
 		}
-	}
-}
 
-func (svc *service) trackHandler(ctx context.Context, r io.ReadCloser, track Track) error {
-	switch track := track.(type) {
-	case *VideoTrack:
-		switch track.Codec() {
-		case CodecH264:
-			go svc.h264Handler(ctx, r, track)
+		// A peer gets one of the video tracks, so they share an ID.
+		for _, video := range append(stream.videoTracks(), stream.Renditions...) {
+			if video.Codec() == CodecNone {
+				return errors.New("video codec not specified")
+			}
 
-		default:
-			return errors.New("video codec unsupported")
+			track, err := newVideoTrack(video.Codec(), stream.Name+"_video", stream.Name)
+			if err != nil {
+				return err
+			}
+
+			video.track = track
+
+			listeners[video] = make(chan struct{}, 1)
+			go svc.serve(ctx, video, listeners[video], &stream.paused)
 		}
 
-	case *AudioTrack:
-		switch track.Codec() {
-		case CodecOpus:
-			_, ok := r.(nvstream.AudioStream)
-			if ok {
-				go svc.opusHandler(ctx, r, track)
-			} else {
-				go svc.oggHandler(ctx, r, track)
+		if audio := stream.Audio; audio != nil {
+			if audio.Codec() == CodecNone {
+				return errors.New("audio codec not specified")
 			}
 
-		default:
-			return errors.New("audio codec unsupported")
-		}
+			trackID := stream.Name + "_audio"
 
-	default:
-		return errors.New("track type unsupported")
-	}
+			track, err := webrtc.NewTrackLocalStaticSample(
+				webrtc.RTPCodecCapability{
+					MimeType:    audio.Codec().MimeType(),
+					SDPFmtpLine: audio.Fmtp(),
+				}, trackID, stream.Name,
+			)
 
-	return nil
-}
+			if err != nil {
+				return err
+			}
 
-func (svc *service) h264Handler(ctx context.Context, r io.ReadCloser, video *VideoTrack) {
-	log, ok := ctx.Value(model.Logger).(*zap.Logger)
-	if !ok {
-		log = svc.log
-	}
+			audio.track = track
 
-	log = log.With(
-		zap.String("track", "video"),
-		zap.String("container", "raw"),
-		zap.String("codec", string(video.Codec())),
-		zap.Float64("fps", video.FPS()),
-	)
+			listeners[audio] = make(chan struct{}, 1)
+			go svc.serve(ctx, audio, listeners[audio], &stream.paused)
+		}
 
-	frameDuration := time.Second / time.Duration(video.FPS())
+		idle := &rawIdleSource{paused: &stream.paused}
+		for _, listener := range listeners {
+			idle.listeners = append(idle.listeners, listener)
+		}
 
-	track, ok := video.Track().(*webrtc.TrackLocalStaticSample)
-	if !ok {
-		log.Error("invalid type")
-		return
-	}
+		source = idle
 
-	reader, err := h264reader.NewReader(r)
-	if err != nil {
-		log.Error(err.Error())
-		return
-	}
+	case TransportNV:
+		// Resolve NVStream App on the first origin that has it
+		var (
+			http nvstream.NvHTTP
+			app  nvstream.NvApp
+		)
 
-	log.Info("playing")
+		origin, err := failover(stream.Origins, func(origin *url.URL) error {
+			if err := reqCtx.Err(); err != nil {
+				return err
+			}
 
-	for {
-		select {
-		case <-ctx.Done():
-			r.Close()
-			log.Info("done")
-			return
+			h, err := svc.newNvHTTP(origin)
+			if err != nil {
+				return err
+			}
 
-		default:
-			nal, err := reader.NextNAL()
+			a, err := resolveNvApp(h, stream.NVStream.App.Name)
 			if err != nil {
-				log.Error(err.Error())
-				return
+				svc.log.Warn("origin unavailable",
+					zap.String("stream", stream.Name),
+					zap.String("origin", origin.Host),
+					zap.Error(err))
+
+				return err
 			}
 
-			track.WriteSample(media.Sample{
-				Data:     nal.Data,
-				Duration: frameDuration,
-			})
+			http, app = h, a
+			return nil
+		})
+
+		if err != nil {
+			if errors.Is(err, nvstream.ErrNotPaired) {
+				return fmt.Errorf("stream %s: %w, run game nvstream pair", stream.Name, err)
+			}
+
+			return err
 		}
-	}
-}
 
-func (svc *service) oggHandler(ctx context.Context, r io.ReadCloser, audio *AudioTrack) {
-	log, ok := ctx.Value(model.Logger).(*zap.Logger)
-	if !ok {
-		log = svc.log
-	}
+		stream.origin.Store(origin)
+		stream.app.Store(&app)
+		stream.NVStream.App = app
 
-	log = log.With(
-		zap.String("track", "audio"),
-		zap.String("container", "ogg"),
-		zap.String("codec", string(audio.Codec())),
+		conn, err := nvstream.NewConnection(http, stream.NVStream)
+		if err != nil {
+			return err
+		}
+
+		vs := nvstream.NewVideoStream()
+		as := nvstream.NewAudioStream()
+
+		conn.SetupCallbacks(vs, as)
+
+		if launch := stream.Launch; launch != nil && launch.Mode == LaunchLazy {
+			// Launched when the first peer connects.
+			stream.launcher = newAppLauncher(ctx, launch, conn,
+				func() nvstream.NvApp { return *stream.app.Load() },
+				svc.log.With(zap.String("stream", stream.Name)))
+		} else {
+			// The launch is cancelled with the request, traced as its child.
+			startCtx, cancel := context.WithCancel(trace.ContextWithSpan(ctx, trace.SpanFromContext(reqCtx)))
+			stop := context.AfterFunc(reqCtx, cancel)
+
+			startCtx, span := telemetry.Start(startCtx, "stream.start",
+				attribute.String("stream", stream.Name),
+				attribute.String("transport", string(stream.Transport)))
+
+			err = conn.StartApp(startCtx, app)
+
+			telemetry.RecordError(span, err)
+			span.End()
+
+			stop()
+			cancel()
+
+			if err != nil {
+				return err
+			}
+
+			// A lazy stream quits its app by itself.
+			source = &nvIdleSource{conn}
+		}
+
+		stream.conn = conn
+		stream.source = &nvSource{conn}
+
+		// Another origin takes over once the one in use keeps failing.
+		var next *nvstream.Failover
+		if len(stream.Origins) > 1 {
+			next = &nvstream.Failover{
+				After: OriginFailoverAfter,
+				Next: func(ctx context.Context) error {
+					origin, err := switchOrigin(ctx, stream, conn, svc.newNvHTTP)
+					if err != nil {
+						return err
+					}
+
+					svc.log.Warn("origin switched",
+						zap.String("stream", stream.Name),
+						zap.String("origin", origin.Host))
+
+					return nil
+				},
+			}
+		}
+
+		go nvstream.Supervise(ctx, conn, nvstream.DefaultBackoff, next, func(errorCode int) {
+			svc.events.Publish(EventNVStreamTerminated, &NVStreamTerminatedEvent{
+				Stream:    stream.Name,
+				ErrorCode: errorCode,
+			})
+		})
+
+		restart = func(ctx context.Context, track Track) error {
+			return conn.ResumeApp(ctx)
+		}
+
+		stream.leds = new(controllerLEDs)
+		go svc.forwardLEDs(ctx, stream, conn.ControllerLEDs())
+
+		stream.hdr = new(lastHDRMode)
+		go svc.forwardHDRModes(ctx, stream, conn.HDRModes())
+
+		if video := stream.Video; video != nil {
+			if !nvVideoCodec(video.Codec(), stream.NVStream.SupportedVideoFormats) {
+				return errors.New("video codec unsupported")
+			}
+
+			track, err := newVideoTrack(video.Codec(), stream.Name+"_video", stream.Name)
+			if err != nil {
+				return err
+			}
+
+			video.track = track
+
+			go svc.nvVideoHandler(ctx, vs, video)
+		}
+
+		if audio := stream.Audio; audio != nil {
+			trackID := stream.Name + "_audio"
+
+			track, err := webrtc.NewTrackLocalStaticSample(
+				webrtc.RTPCodecCapability{
+					MimeType:    audio.Codec().MimeType(),
+					SDPFmtpLine: audio.Fmtp(),
+				}, trackID, stream.Name,
+			)
+
+			if err != nil {
+				return err
+			}
+
+			audio.track = track
+
+			if err := svc.trackHandler(ctx, as, audio); err != nil {
+				return err
+			}
+		}
+
+	case TransportDemo:
+		if err := svc.buildDemo(ctx, stream); err != nil {
+			return err
+		}
+
+		// The generators never stall.
+		restart = func(ctx context.Context, track Track) error {
+			return nil
+		}
+
+	default:
+		return errors.New("transport unsupported")
+	}
+
+	if stream.Watchdog != nil {
+		go svc.watchdog(ctx, stream, restart)
+	}
+
+	stream.peers = NewPeerGroup(stream.MaxPeers, stream.ExclusiveController)
+
+	if stream.launcher != nil {
+		stream.peers.Watch(stream.launcher.peersChanged)
+	}
+
+	if idle := stream.Idle; idle != nil && !idle.exempt(stream, svc.cfg.Recordings) {
+		peers := stream.peers
+
+		m := &idleMonitor{
+			log:       svc.log.With(zap.String("stream", stream.Name)),
+			cfg:       idle,
+			stream:    stream.Name,
+			transport: stream.Transport,
+			source:    source,
+			lastInput: &stream.lastInput,
+			closePeers: func() {
+				for _, peer := range peers.Peers() {
+					peer.Close()
+				}
+			},
+			publish: svc.events.Publish,
+		}
+
+		stream.idle = m
+		peers.Watch(m.peersChanged)
+
+		go m.Run(ctx)
+	}
+
+	svc.events.Publish(EventStreamStarted, &StreamEvent{
+		Stream:    stream.Name,
+		Transport: stream.Transport,
+	})
+
+	if video := stream.Video; video != nil && video.Codec() == CodecH264 {
+		stream.keyframes = new(keyframeCache)
+		video.AddSink(stream.keyframes)
+	}
+
+	if stream.Preview != nil {
+		if err := svc.buildPreview(ctx, stream); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (svc *service) buildPreview(ctx context.Context, stream *Stream) error {
+	video := stream.Video
+	if video == nil || video.Codec() != CodecH264 {
+		return errors.New("preview requires an h264 video track: " + stream.Name)
+	}
+
+	snapshots := svc.cfg.Snapshots
+	if snapshots == nil {
+		snapshots = defaultSnapshots
+	}
+
+	p, err := newPreviewer(ctx, stream.Preview, snapshots.FFmpeg, stream)
+	if err != nil {
+		return err
+	}
+
+	// NVStream hosts send keyframes only on request, and so may encoders
+	// with hooks.
+	p.requestKeyframe = func() {
+		go stream.requestKeyframe(context.Background())
+	}
+
+	video.AddSink(p)
+	stream.preview = p
+
+	return nil
+}
+
+func (svc *service) newNvHTTP(origin *url.URL) (nvstream.NvHTTP, error) {
+	opts := []nvstream.HTTPOption{
+		nvstream.WithUniqueID("MyGameClient"),
+		nvstream.WithPath(svc.cfg.Path),
+	}
+
+	// Sunshine serves HTTP five ports above HTTPS
+	if port, err := strconv.Atoi(origin.Port()); err == nil {
+		opts = append(opts, nvstream.WithPorts(port, port+5))
+	}
+
+	return nvstream.NewHTTP(origin.Hostname(), opts...)
+}
+
+// resolveNvApp finds the app named name, or containing it, on the host.
+func resolveNvApp(http nvstream.NvHTTP, name string) (nvstream.NvApp, error) {
+	appList, err := http.AppList()
+	if err != nil {
+		return nvstream.NvApp{}, err
+	}
+
+	var app nvstream.NvApp
+	for _, a := range appList {
+		if !strings.Contains(a.Name, name) {
+			continue
+		}
+
+		app = a
+	}
+
+	if (app == nvstream.NvApp{}) {
+		return nvstream.NvApp{}, fmt.Errorf("%w: %s", nvstream.ErrAppNotFound, name)
+	}
+
+	return app, nil
+}
+
+// failover tries the origins in order until one succeeds and returns it, or
+// the errors of all of them.
+func failover(origins []*url.URL, try func(origin *url.URL) error) (*url.URL, error) {
+	if len(origins) == 0 {
+		return nil, errors.New("no origins")
+	}
+
+	var errs []error
+	for _, origin := range origins {
+		err := try(origin)
+		if err == nil {
+			return origin, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", origin.Host, err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// OriginFailoverAfter is the number of consecutive failures to resume an
+// NVStream connection on its origin before the next origin takes over.
+const OriginFailoverAfter = 3
+
+// switchOrigin relocates the connection of an NVStream stream to the first
+// of the origins after the one in use, wrapping around, that has the app
+// and starts, and returns it.
+func switchOrigin(ctx context.Context, stream *Stream, conn nvstream.NvConnection, newHTTP func(origin *url.URL) (nvstream.NvHTTP, error)) (*url.URL, error) {
+	current := stream.Address()
+
+	var origins []*url.URL
+	for i, origin := range stream.Origins {
+		if origin == current {
+			origins = append(origins, stream.Origins[i+1:]...)
+			origins = append(origins, stream.Origins[:i]...)
+			break
+		}
+	}
+
+	origin, err := failover(origins, func(origin *url.URL) error {
+		http, err := newHTTP(origin)
+		if err != nil {
+			return err
+		}
+
+		app, err := resolveNvApp(http, stream.NVStream.App.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := conn.Relocate(ctx, http, app); err != nil {
+			return err
+		}
+
+		stream.app.Store(&app)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	stream.origin.Store(origin)
+
+	return origin, nil
+}
+
+func (svc *service) buildRecorders(cfg *Recordings) error {
+	for _, stream := range svc.streams {
+		if err := svc.buildRecorder(cfg, stream); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (svc *service) buildRecorder(cfg *Recordings, stream *Stream) error {
+	if !cfg.Includes(stream.Name) {
+		return nil
+	}
+
+	dir := cfg.Path
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(svc.cfg.Path, dir)
+	}
+
+	rec, err := NewRecorder(cfg, dir, stream)
+	if err != nil {
+		return err
+	}
+
+	rec.requestKeyframe = func() {
+		go stream.requestKeyframe(context.Background())
+	}
+
+	stream.recorder = rec
+
+	if video := stream.Video; video != nil {
+		video.AddSink(rec.VideoWriter())
+	}
+
+	if audio := stream.Audio; audio != nil {
+		audio.AddSink(rec.AudioWriter())
+	}
+
+	svc.lifecycle.Add("recorder."+stream.Name, 0, func(ctx context.Context) error {
+		return rec.Close()
+	})
+
+	return nil
+}
+
+func (svc *service) buildRepublishers() error {
+	for _, stream := range svc.streams {
+		if err := svc.buildRepublisher(stream); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (svc *service) buildRepublisher(stream *Stream) error {
+	for _, cfg := range stream.Republish {
+		r, err := NewRepublisher(cfg, stream)
+		if err != nil {
+			return err
+		}
+
+		if video := stream.Video; video != nil {
+			video.AddSink(r.VideoWriter())
+		}
+
+		if audio := stream.Audio; audio != nil {
+			audio.AddSink(r.AudioWriter())
+		}
+
+		ctx := svc.lifecycle.Add("republish."+stream.Name, 0, func(ctx context.Context) error {
+			return r.Close()
+		})
+
+		r.Start(ctx)
+	}
+
+	return nil
+}
+
+func (svc *service) listen(ctx context.Context, track Track) {
+	url := track.Address()
+
+	network := url.Scheme
+
+	address := url.Host
+	if url.Scheme == "unix" {
+		address = url.Path
+	}
+
+	log := svc.log.With(
+		zap.String("action", "listen"),
+		zap.String("network", network),
+		zap.String("address", address),
+	)
+
+	if strings.HasPrefix(network, "udp") {
+		addr, err := net.ResolveUDPAddr(network, address)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		conn, err := net.ListenUDP(network, addr)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		log.Info("socket opened")
+
+		track.health().listening.Store(true)
+		defer track.health().listening.Store(false)
+
+		if err := svc.trackHandler(context.WithValue(ctx, model.Logger, log), conn, track); err != nil {
+			log.Error(err.Error())
+		}
+
+		<-ctx.Done()
+
+		conn.Close()
+		log.Info("socket closed")
+
+		return
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	log.Info("socket opened")
+
+	track.health().listening.Store(true)
+	defer track.health().listening.Store(false)
+
+	go func(ctx context.Context, listener net.Listener) {
+		<-ctx.Done()
+
+		listener.Close()
+		log.Info("socket closed")
+	}(ctx, listener)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		log := log.With(
+			zap.String("remote", conn.RemoteAddr().String()),
+		)
+
+		// Unblock the handler's read when the listener stops.
+		context.AfterFunc(ctx, func() { conn.Close() })
+
+		if err := svc.trackHandler(context.WithValue(ctx, model.Logger, log), conn, track); err != nil {
+			log.Error(err.Error())
+		}
+	}
+}
+
+func (svc *service) trackHandler(ctx context.Context, r io.ReadCloser, track Track) error {
+	switch track := track.(type) {
+	case *VideoTrack:
+		switch track.Codec() {
+		case CodecH264:
+			go svc.h264Handler(ctx, r, track)
+
+		case CodecH265:
+			go svc.h265Handler(ctx, r, track)
+
+		case CodecAV1, CodecVP8, CodecVP9:
+			go svc.ivfHandler(ctx, r, track)
+
+		default:
+			return errors.New("video codec unsupported")
+		}
+
+	case *AudioTrack:
+		switch track.Codec() {
+		case CodecOpus:
+			if _, ok := r.(nvstream.AudioStream); ok {
+				go svc.opusHandler(ctx, r, track)
+				break
+			}
+
+			if track.Source() == CodecAAC {
+				transcoded, err := transcodeAAC(ctx, r, track)
+				if err != nil {
+					return err
+				}
+
+				log, ok := ctx.Value(model.Logger).(*zap.Logger)
+				if !ok {
+					log = svc.log
+				}
+
+				ctx = context.WithValue(ctx, model.Logger, log.With(zap.String("source_codec", string(CodecAAC))))
+				go svc.oggHandler(ctx, transcoded, track)
+				break
+			}
+
+			switch track.Container() {
+			case ContainerRaw, ContainerRTP:
+				go svc.framedOpusHandler(ctx, r, track)
+			default:
+				go svc.oggHandler(ctx, r, track)
+			}
+
+		default:
+			return errors.New("audio codec unsupported")
+		}
+
+	default:
+		return errors.New("track type unsupported")
+	}
+
+	return nil
+}
+
+func (svc *service) h264Handler(ctx context.Context, r io.ReadCloser, video *VideoTrack) {
+	log, ok := ctx.Value(model.Logger).(*zap.Logger)
+	if !ok {
+		log = svc.log
+	}
+
+	log = log.With(
+		zap.String("track", "video"),
+		zap.String("container", "raw"),
+		zap.String("codec", string(video.Codec())),
+		zap.Float64("fps", video.FPS()),
+	)
+
+	frameDuration := time.Second / time.Duration(video.FPS())
+
+	reader, err := h264reader.NewReader(r)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	log.Info("playing")
+
+	var units accessUnits
+	for {
+		select {
+		case <-ctx.Done():
+			r.Close()
+			log.Info("done")
+			return
+
+		default:
+			nal, err := reader.NextNAL()
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+
+			unit := units.Push(nal.Data)
+			if unit == nil {
+				continue
+			}
+
+			video.WriteSample(media.Sample{
+				Data:     unit,
+				Duration: frameDuration,
+			})
+		}
+	}
+}
+
+// h265Handler writes the access units of an H265 byte stream, as
+// h264Handler does.
+func (svc *service) h265Handler(ctx context.Context, r io.ReadCloser, video *VideoTrack) {
+	log, ok := ctx.Value(model.Logger).(*zap.Logger)
+	if !ok {
+		log = svc.log
+	}
+
+	log = log.With(
+		zap.String("track", "video"),
+		zap.String("container", "raw"),
+		zap.String("codec", string(video.Codec())),
+		zap.Float64("fps", video.FPS()),
+	)
+
+	frameDuration := time.Second / time.Duration(video.FPS())
+
+	reader := newNALReader(r)
+
+	log.Info("playing")
+
+	var units hevcAccessUnits
+	for {
+		select {
+		case <-ctx.Done():
+			r.Close()
+			log.Info("done")
+			return
+
+		default:
+			nal, err := reader.NextNAL()
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+
+			unit := units.Push(nal)
+			if unit == nil {
+				continue
+			}
+
+			video.WriteSample(media.Sample{
+				Data:     unit,
+				Duration: frameDuration,
+			})
+		}
+	}
+}
+
+// ivfHandler writes the frames of an IVF stream, of AV1, VP8 or VP9, each
+// lasting as long as their timestamps tell.
+func (svc *service) ivfHandler(ctx context.Context, r io.ReadCloser, video *VideoTrack) {
+	log, ok := ctx.Value(model.Logger).(*zap.Logger)
+	if !ok {
+		log = svc.log
+	}
+
+	log = log.With(
+		zap.String("track", "video"),
+		zap.String("container", "ivf"),
+		zap.String("codec", string(video.Codec())),
+	)
+
+	reader, header, err := ivfreader.NewWith(r)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if header.TimebaseDenominator == 0 {
+		log.Error("invalid ivf timebase")
+		return
+	}
+
+	timebase := time.Second * time.Duration(header.TimebaseNumerator) / time.Duration(header.TimebaseDenominator)
+
+	// The timebase is usually the frame rate's inverse.
+	clock := frameClock{fallback: timebase}
+	if fps := video.FPS(); fps > 0 {
+		clock.fallback = time.Duration(float64(time.Second) / fps)
+	}
+
+	log.Info("playing", zap.String("fourcc", header.FourCC))
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.Close()
+			log.Info("done")
+			return
+
+		default:
+			frame, frameHeader, err := reader.ParseNextFrame()
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+
+			video.WriteSample(media.Sample{
+				Data:     frame,
+				Duration: clock.Duration(time.Duration(frameHeader.Timestamp) * timebase),
+			})
+		}
+	}
+}
+
+// frameClock tells how long each frame lasts from the host's timestamps:
+// the time since the previous frame. Without a usable timestamp, such as
+// for the first frame or after a gap, a frame lasts fallback.
+type frameClock struct {
+	fallback time.Duration
+	last     time.Duration
+	started  bool
+}
+
+// maxFrameGap bounds the duration taken from timestamps; a longer gap is
+// a discontinuity, not a frame.
+const maxFrameGap = time.Second
+
+func (c *frameClock) Duration(timestamp time.Duration) time.Duration {
+	duration := c.fallback
+	if c.started {
+		if delta := timestamp - c.last; delta > 0 && delta <= maxFrameGap {
+			duration = delta
+		}
+	}
+
+	c.started = true
+	c.last = timestamp
+
+	return duration
+}
+
+// nvVideoHandler writes the frames of an NVStream host to the track as
+// they are, each lasting as long as the host's presentation timestamps
+// tell, so a variable frame rate paces without judder.
+func (svc *service) nvVideoHandler(ctx context.Context, vs nvstream.VideoStream, video *VideoTrack) {
+	log, ok := ctx.Value(model.Logger).(*zap.Logger)
+	if !ok {
+		log = svc.log
+	}
+
+	log = log.With(
+		zap.String("track", "video"),
+		zap.String("container", "nvstream"),
+		zap.String("codec", string(video.Codec())),
 	)
 
-	track, ok := audio.Track().(*webrtc.TrackLocalStaticSample)
+	fps := video.FPS()
+	if fps <= 0 {
+		fps = float64(vs.RefreshRate())
+	}
+
+	if fps <= 0 {
+		fps = 60
+	}
+
+	clock := &frameClock{fallback: time.Duration(float64(time.Second) / fps)}
+
+	log.Info("playing", zap.Float64("fps", fps))
+
+	go func() {
+		<-ctx.Done()
+		vs.Close()
+	}()
+
+	for {
+		frame, err := vs.NextFrame()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Error(err.Error())
+			}
+
+			log.Info("done")
+			return
+		}
+
+		// Hosts that do not tell presentation times pace by arrival.
+		timestamp := frame.PresentationTime
+		if timestamp == 0 {
+			timestamp = frame.ReceiveTime
+		}
+
+		video.hostProcessing.Store(int64(frame.HostProcessingLatency))
+
+		video.WriteSample(media.Sample{
+			Data:     frame.Data(),
+			Duration: clock.Duration(timestamp),
+		})
+
+		frame.Release()
+	}
+}
+
+func (svc *service) oggHandler(ctx context.Context, r io.ReadCloser, audio *AudioTrack) {
+	log, ok := ctx.Value(model.Logger).(*zap.Logger)
 	if !ok {
-		log.Error("invalid type")
-		return
+		log = svc.log
 	}
 
+	log = log.With(
+		zap.String("track", "audio"),
+		zap.String("container", "ogg"),
+		zap.String("codec", string(audio.Codec())),
+	)
+
 	reader, _, err := oggreader.NewWith(r)
 	if err != nil {
 		log.Error(err.Error())
@@ -427,7 +1359,7 @@ func (svc *service) oggHandler(ctx context.Context, r io.ReadCloser, audio *Audi
 			lastGranule = header.GranulePosition
 			sampleDuration := time.Duration((sampleCount/48000)*1000) * time.Millisecond
 
-			track.WriteSample(media.Sample{
+			audio.WriteSample(media.Sample{
 				Data:     payload,
 				Duration: sampleDuration,
 			})
@@ -447,59 +1379,440 @@ func (svc *service) opusHandler(ctx context.Context, r io.ReadCloser, audio *Aud
 		zap.String("codec", string(audio.Codec())),
 	)
 
-	track, ok := audio.Track().(*webrtc.TrackLocalStaticSample)
+	as, ok := r.(nvstream.AudioStream)
 	if !ok {
 		log.Error("invalid type")
 		return
 	}
 
-	as, ok := r.(nvstream.AudioStream)
+	duration := as.SampleDuration()
+
+	log.Info("playing", zap.Duration("sample_duration", duration))
+
+	buf := make([]byte, 1400)
+	for {
+		select {
+		case <-ctx.Done():
+			r.Close()
+			log.Info("done")
+			return
+
+		default:
+			n, err := r.Read(buf)
+			if err != nil {
+				if err != io.EOF {
+					log.Error(err.Error())
+				}
+				return
+			}
+
+			if n > 0 {
+				audio.WriteSample(media.Sample{
+					Data:     buf[:n],
+					Duration: duration,
+				})
+			}
+		}
+	}
+}
+
+func (svc *service) FindStream(ctx context.Context, name string) (*Stream, error) {
+	svc.RLock()
+	stream, ok := svc.streams[name]
+	svc.RUnlock()
+
 	if !ok {
-		log.Error("invalid type")
+		return nil, ErrStreamNotFound
+	}
+
+	return stream, nil
+}
+
+func (svc *service) Snapshot(ctx context.Context, name string, opts SnapshotOptions) (*Snapshot, error) {
+	stream, err := svc.FindStream(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream.keyframes == nil {
+		return nil, errors.New("snapshot unsupported for stream: " + name)
+	}
+
+	keyframe, capturedAt, err := stream.keyframes.Keyframe()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Format == "" {
+		opts.Format = ImageJPEG
+	}
+
+	cfg := svc.cfg.Snapshots
+	if cfg == nil {
+		cfg = defaultSnapshots
+	}
+
+	data, err := decodeKeyframe(ctx, cfg, keyframe, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		Stream:     name,
+		Format:     opts.Format,
+		Data:       data,
+		CapturedAt: capturedAt,
+	}, nil
+}
+
+func (svc *service) DescribeStreams(ctx context.Context) ([]*StreamManifest, error) {
+	var inputs []string
+	if svc.gamepad != nil {
+		inputs = append(inputs, "gamepad")
+	}
+
+	inputs = append(inputs, "keyboard", "mouse", "text")
+
+	if svc.files != nil {
+		inputs = append(inputs, "files")
+	}
+
+	streams := svc.streamList()
+
+	manifests := make([]*StreamManifest, 0, len(streams))
+	for _, stream := range streams {
+		manifests = append(manifests, stream.Manifest(inputs))
+	}
+
+	for _, stream := range svc.stoppedList() {
+		m := stream.Manifest(inputs)
+		m.Live = false
+		m.Status = StreamStatusStopped
+		manifests = append(manifests, m)
+	}
+
+	slices.SortFunc(manifests, func(a, b *StreamManifest) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return manifests, nil
+}
+
+func (svc *service) CreateGuestToken(ctx context.Context, opts GuestOptions) (*GuestToken, error) {
+	if opts.Stream == "" {
+		opts.Stream = DefaultStream
+	}
+
+	if _, err := svc.FindStream(ctx, opts.Stream); err != nil {
+		return nil, err
+	}
+
+	if opts.Role == GuestPlayer {
+		if svc.gamepadCfg.Disabled {
+			return nil, errors.New("gamepad input disabled")
+		}
+
+		if opts.Slot > svc.gamepadCfg.Controllers {
+			return nil, errors.New("controller slot not available: " + strconv.Itoa(opts.Slot))
+		}
+	}
+
+	return svc.guests.Issue(opts)
+}
+
+// RevokeGuestToken rejects the token and disconnects its peers.
+func (svc *service) RevokeGuestToken(ctx context.Context, id string) error {
+	if id == "" {
+		return errors.New("guest token id not specified")
+	}
+
+	svc.guests.Revoke(id)
+
+	for _, stream := range svc.streamList() {
+		for _, peer := range stream.peers.Peers() {
+			if peer.guest == id {
+				peer.Close()
+			}
+		}
+	}
+
+	return nil
+}
+
+// Pair pairs the NVStream stream's host with a generated PIN. The PIN is
+// published to reply.progress, for the operator to enter on the host.
+func (svc *service) Pair(ctx context.Context, name string, reply string) (*PairResult, error) {
+	var stream *Stream
+	for _, s := range svc.cfg.Streams {
+		if s.Name == name {
+			stream = s
+			break
+		}
+	}
+
+	if stream == nil {
+		return nil, ErrStreamNotFound
+	}
+
+	if stream.Transport != TransportNV {
+		return nil, errors.New("pairing requires an nvstream stream: " + name)
+	}
+
+	http, err := svc.newNvHTTP(stream.Address())
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return nil, err
+	}
+
+	pin := fmt.Sprintf("%04d", n.Int64())
+
+	publish := func(progress *PairProgress) error {
+		bs, err := json.Marshal(progress)
+		if err != nil {
+			return err
+		}
+
+		return svc.nc.Publish(reply+".progress", bs)
+	}
+
+	if err := publish(&PairProgress{Stage: "pin", PIN: pin}); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, PairTimeout)
+	defer cancel()
+
+	state, err := nvstream.NewPairingManager(http).Pair(ctx, pin, func(stage nvstream.PairStage) {
+		publish(&PairProgress{Stage: stage.String()})
+
+		// With admin credentials, enter the PIN on the host's behalf once
+		// it waits for it.
+		if stage == nvstream.PairStageSalt && stream.Sunshine != nil {
+			go svc.submitPIN(ctx, stream.Sunshine, pin, publish)
+		}
+	})
+
+	// A failed handshake is a result, not an error of the request.
+	result := &PairResult{
+		State: state,
+	}
+
+	if err != nil {
+		result.Reason = err.Error()
+	}
+
+	svc.events.Publish(EventPairingCompleted, &PairingCompletedEvent{
+		Stream: stream.Name,
+		State:  result.State,
+		Reason: result.Reason,
+	})
+
+	return result, nil
+}
+
+func (svc *service) submitPIN(ctx context.Context, cfg *nvstream.Sunshine, pin string, publish func(*PairProgress) error) {
+	sunshine := nvstream.NewSunshineClient(cfg)
+
+	log := svc.log.With(
+		zap.String("action", "submit_pin"),
+		zap.String("sunshine", cfg.URL.String()),
+	)
+
+	err := sunshine.AutoSubmitPIN(ctx, pin, nvstream.DEFAULT_DEVICE_NAME, 500*time.Millisecond)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn(err.Error())
+		}
+
 		return
 	}
 
-	duration := as.SampleDuration()
+	log.Info("pin submitted")
+
+	publish(&PairProgress{Stage: "pin_submitted"})
+}
+
+// ChannelStats returns the data channel traffic of all peers by label.
+func (svc *service) ChannelStats(ctx context.Context) ([]LabelStats, error) {
+	return svc.metrics.Stats(), nil
+}
+
+// ListPeers returns the peers of all streams, with what their DTLS
+// handshakes negotiated.
+func (svc *service) ListPeers(ctx context.Context) ([]*PeerInfo, error) {
+	infos := make([]*PeerInfo, 0)
+	for _, stream := range svc.streamList() {
+		for _, peer := range stream.peers.Peers() {
+			infos = append(infos, peer.Info())
+		}
+	}
+
+	slices.SortFunc(infos, func(a, b *PeerInfo) int {
+		if c := strings.Compare(a.Stream, b.Stream); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return infos, nil
+}
+
+// PeerLatency returns the latency the clients of all peers measured on
+// their latency channels.
+func (svc *service) PeerLatency(ctx context.Context) ([]PeerLatencyStats, error) {
+	stats := make([]PeerLatencyStats, 0)
+	for _, stream := range svc.streamList() {
+		var host time.Duration
+		if stream.Video != nil {
+			host = time.Duration(stream.Video.hostProcessing.Load())
+		}
+
+		for _, peer := range stream.peers.Peers() {
+			peer.RLock()
+			probe := peer.latency
+			peer.RUnlock()
+
+			if probe == nil {
+				continue
+			}
+
+			s := probe.Stats()
+			s.Peer = peer.ID()
+			s.Stream = stream.Name
+			s.HostLatency = host
+
+			stats = append(stats, s)
+		}
+	}
+
+	slices.SortFunc(stats, func(a, b PeerLatencyStats) int {
+		if c := strings.Compare(a.Stream, b.Stream); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.Peer, b.Peer)
+	})
+
+	return stats, nil
+}
+
+// NVStreamStats are the moonlight statistics of an NVStream stream's
+// connection.
+type NVStreamStats struct {
+	Stream string `json:"stream"`
+	moonlight.ConnectionStats
+}
+
+// NVStreamStats returns the statistics of the NVStream streams connected.
+func (svc *service) NVStreamStats(ctx context.Context) ([]NVStreamStats, error) {
+	stats := make([]NVStreamStats, 0)
+	for _, stream := range svc.streamList() {
+		if stream.conn == nil {
+			continue
+		}
+
+		s, ok := stream.conn.Stats()
+		if !ok {
+			continue
+		}
+
+		stats = append(stats, NVStreamStats{
+			Stream:          stream.Name,
+			ConnectionStats: s,
+		})
+	}
+
+	slices.SortFunc(stats, func(a, b NVStreamStats) int {
+		return strings.Compare(a.Stream, b.Stream)
+	})
+
+	return stats, nil
+}
+
+// InputStats returns the input latency statistics of each controller.
+func (svc *service) InputStats(ctx context.Context) ([]LatencyStats, error) {
+	return svc.gamepads.LatencyStats(), nil
+}
+
+func (svc *service) Health(ctx context.Context) (*Health, error) {
+	now := time.Now()
+	streams := svc.streamList()
+
+	health := &Health{
+		Ready:   true,
+		Streams: make([]*StreamHealth, 0, len(streams)),
+	}
+
+	for _, stream := range streams {
+		h := streamHealth(stream, now)
+		if !h.Ready {
+			health.Ready = false
+		}
+
+		health.Streams = append(health.Streams, h)
+	}
+
+	slices.SortFunc(health.Streams, func(a, b *StreamHealth) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	health.Gamepad = &GamepadHealth{
+		Disabled:    svc.gamepadCfg.Disabled,
+		Backend:     svc.gamepadBackend,
+		Controllers: svc.gamepads.Controllers(),
+	}
+
+	if driver, err := GamepadDriverInfo(); err == nil {
+		health.Gamepad.Driver = driver
+	}
+
+	health.ICE = svc.iceHealth.Statuses()
+	health.Subscriptions = svc.subs.Active()
 
-	log.Info("playing", zap.Duration("sample_duration", duration))
+	return health, nil
+}
 
-	buf := make([]byte, 1400)
-	for {
-		select {
-		case <-ctx.Done():
-			r.Close()
-			log.Info("done")
-			return
+// ICEServers returns the servers of the provider for clients, as allowed
+// by the client ICE policy.
+func (svc *service) ICEServers(ctx context.Context, provider ICEProvider) (*ICECredentials, error) {
+	policy := svc.clientICE
+	if policy == nil {
+		policy = defaultClientICEPolicy
+	}
 
-		default:
-			n, err := r.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Error(err.Error())
-				}
-				return
-			}
+	return svc.policyICEServers(ctx, policy, provider)
+}
 
-			if n > 0 {
-				track.WriteSample(media.Sample{
-					Data:     buf[:n],
-					Duration: duration,
-				})
-			}
-		}
+func (svc *service) policyICEServers(ctx context.Context, policy *ICEPolicy, provider ICEProvider) (*ICECredentials, error) {
+	if policy.Candidates == ICECandidatesHost {
+		return policy.apply(nil)
 	}
-}
 
-func (svc *service) FindStream(name string) (*Stream, error) {
-	stream, ok := svc.streams[name]
-	if !ok {
-		return nil, errors.New("stream not found")
+	if provider != AnyProvider && !policy.Allows(provider) {
+		return nil, errors.New("provider not allowed: " + provider.String())
 	}
 
-	return stream, nil
+	creds, err := svc.providerICEServers(ctx, policy, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy.apply(creds)
 }
 
-func (svc *service) ICEServers(provider ICEProvider) ([]webrtc.ICEServer, error) {
+// providerICEServers returns the servers of the provider. When the
+// provider is failing, the servers of the healthy ones the policy allows
+// are returned instead.
+func (svc *service) providerICEServers(ctx context.Context, policy *ICEPolicy, provider ICEProvider) (*ICECredentials, error) {
+	if provider == AnyProvider {
+		return svc.anyICEServers(ctx, policy, AnyProvider)
+	}
+
 	var cfg *ICEServer
 	for _, server := range svc.cfg.WebRTC.ICEServers {
 		if server.Provider == provider {
@@ -513,34 +1826,100 @@ func (svc *service) ICEServers(provider ICEProvider) ([]webrtc.ICEServer, error)
 		return nil, err
 	}
 
+	if svc.iceHealth.Healthy(provider) {
+		creds, err := svc.fetchICEServers(ctx, cfg)
+		if err == nil {
+			return creds, nil
+		}
+
+		svc.iceHealth.Report(provider, err)
+	}
+
+	creds, err := svc.anyICEServers(ctx, policy, provider)
+	if err != nil {
+		// No other provider answered; the failing one may have recovered.
+		if creds, err := svc.fetchICEServers(ctx, cfg); err == nil {
+			return creds, nil
+		}
+
+		if !policy.Allows(Google) {
+			return nil, err
+		}
+
+		svc.log.Warn("ice providers failing, falling back to google stun",
+			zap.String("provider", provider.String()))
+
+		return &ICECredentials{Servers: slices.Clone(googleICEServers)}, nil
+	}
+
+	svc.log.Warn("ice provider failing, failing over",
+		zap.String("provider", provider.String()))
+
+	return creds, nil
+}
+
+// ICECredentials are the ICE servers of a provider. TURN credentials
+// expire at ExpiresAt, when clients should fetch new ones; it is zero when
+// they do not expire or the provider does not tell.
+type ICECredentials struct {
+	Servers   []webrtc.ICEServer
+	ExpiresAt time.Time
+	RelayOnly bool // clients should only gather relay candidates
+}
+
+// DefaultTURNTTL is how long requested TURN credentials last by default.
+const DefaultTURNTTL = 24 * time.Hour
+
+// FetchICEServers returns the ICE servers of a provider, requesting
+// short-lived TURN credentials where the provider issues them.
+func FetchICEServers(ctx context.Context, cfg *ICEServer) (*ICECredentials, error) {
+	return fetchICEServers(ctx, cfg, defaultICEBreaker.Timeout)
+}
+
+// googleICEServers are Google's public STUN servers, which need no
+// credentials.
+var googleICEServers = []webrtc.ICEServer{
+	{
+		URLs: []string{
+			"stun:stun.l.google.com:19302",
+			"stun:stun1.l.google.com:19302",
+			"stun:stun2.l.google.com:19302",
+			"stun:stun3.l.google.com:19302",
+			"stun:stun4.l.google.com:19302",
+		},
+	},
+}
+
+func fetchICEServers(ctx context.Context, cfg *ICEServer, timeout time.Duration) (*ICECredentials, error) {
 	switch cfg.Provider {
 	case Google:
-		return []webrtc.ICEServer{
-			{
-				URLs: []string{
-					"stun:stun.l.google.com:19302",
-					"stun:stun1.l.google.com:19302",
-					"stun:stun2.l.google.com:19302",
-					"stun:stun3.l.google.com:19302",
-					"stun:stun4.l.google.com:19302",
-				},
-			},
+		return &ICECredentials{
+			Servers: slices.Clone(googleICEServers),
 		}, nil
 
 	case Cloudflare:
 		client := resty.New().
-			SetBaseURL("https://rtc.live.cloudflare.com/v1")
+			SetBaseURL("https://rtc.live.cloudflare.com/v1").
+			SetTimeout(timeout)
 
 		path := fmt.Sprintf("/turn/keys/%s/credentials/generate", cfg.ID)
 
+		ttl := cfg.TTL
+		if ttl <= 0 {
+			ttl = DefaultTURNTTL
+		}
+
 		var config struct {
 			ICEServers webrtc.ICEServer `json:"iceServers"`
 		}
 
+		requested := time.Now()
+
 		resp, err := client.R().
+			SetContext(ctx).
 			SetHeader("Content-Type", "application/json").
 			SetAuthToken(cfg.Token).
-			SetBody(`{ "ttl": 86400 }`).
+			SetBody(map[string]int64{"ttl": int64(ttl / time.Second)}).
 			SetResult(&config).
 			Post(path)
 
@@ -561,13 +1940,22 @@ func (svc *service) ICEServers(provider ICEProvider) ([]webrtc.ICEServer, error)
 			return nil, errors.New(errMsg.Error)
 		}
 
-		return []webrtc.ICEServer{config.ICEServers}, nil
+		expiresAt, ok := credentialExpiry(config.ICEServers.Username)
+		if !ok {
+			expiresAt = requested.Add(ttl)
+		}
+
+		return &ICECredentials{
+			Servers:   []webrtc.ICEServer{config.ICEServers},
+			ExpiresAt: expiresAt.Truncate(time.Second),
+		}, nil
 
 	case Metered:
 		baseURL := fmt.Sprintf("https://%s.metered.live/api/v1", cfg.ID)
 
 		client := resty.New().
-			SetBaseURL(baseURL)
+			SetBaseURL(baseURL).
+			SetTimeout(timeout)
 
 		type ICEServer struct {
 			URLs       string `json:"urls"`
@@ -577,6 +1965,7 @@ func (svc *service) ICEServers(provider ICEProvider) ([]webrtc.ICEServer, error)
 
 		var raws []ICEServer
 		resp, err := client.R().
+			SetContext(ctx).
 			SetQueryParam("apiKey", cfg.Token).
 			SetResult(&raws).
 			Get("/turn/credentials")
@@ -598,202 +1987,518 @@ func (svc *service) ICEServers(provider ICEProvider) ([]webrtc.ICEServer, error)
 			return nil, errors.New(errMsg.Error)
 		}
 
-		servers := make([]webrtc.ICEServer, len(raws))
+		creds := &ICECredentials{
+			Servers: make([]webrtc.ICEServer, len(raws)),
+		}
+
 		for i, raw := range raws {
-			servers[i] = webrtc.ICEServer{
+			creds.Servers[i] = webrtc.ICEServer{
 				URLs:       []string{raw.URLs},
 				Username:   raw.Username,
 				Credential: raw.Credential,
 			}
+
+			if expiresAt, ok := credentialExpiry(raw.Username); ok {
+				creds.expire(expiresAt)
+			}
 		}
 
-		return servers, nil
+		return creds, nil
 
 	default:
 		return nil, errors.New("provider not supported")
 	}
 }
 
-func (svc *service) AcceptPeer(offer webrtc.SessionDescription, reply string) (*Peer, error) {
-	servers, err := svc.ICEServers(Google)
+// expire brings the expiry forward to t.
+func (creds *ICECredentials) expire(t time.Time) {
+	if !t.IsZero() && (creds.ExpiresAt.IsZero() || t.Before(creds.ExpiresAt)) {
+		creds.ExpiresAt = t
+	}
+}
+
+// credentialExpiry parses the expiry of time-limited TURN credentials,
+// whose username is the Unix time they expire at, optionally followed by
+// a colon and a user ID.
+func credentialExpiry(username string) (time.Time, bool) {
+	ts, _, _ := strings.Cut(username, ":")
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(sec, 0), true
+}
+
+func (svc *service) AcceptPeer(ctx context.Context, offer webrtc.SessionDescription, reply string, opts PeerOptions) (_ *Peer, err error) {
+	ctx, span := telemetry.StartKind(ctx, "peers.accept", trace.SpanKindServer)
+	defer func() {
+		telemetry.RecordError(span, err)
+		span.End()
+	}()
+
+	// The negotiation is correlated by the ID of the request it came with.
+	requestID := RequestIDFrom(ctx)
+	span.SetAttributes(attribute.String("request_id", requestID))
+
+	var guest string
+	var slot int
+	if opts.GuestToken != "" {
+		token, err := svc.guests.Verify(opts.GuestToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.Stream != "" && opts.Stream != token.Stream {
+			return nil, ErrPermissionDenied
+		}
+
+		opts.Stream = token.Stream
+		opts.Permissions = token.Role.Permissions()
+		guest = token.ID
+		slot = token.Slot
+	} else {
+		opts.Permissions &= svc.access().Permissions
+	}
+
+	if svc.gamepadCfg.Disabled {
+		opts.Permissions &^= PermissionGamepad
+		slot = 0
+	}
+
+	name := opts.Stream
+	if name == "" {
+		name = DefaultStream
+	}
+
+	stream, err := svc.FindStream(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.String("stream", stream.Name),
+		attribute.String("permissions", opts.Permissions.String()),
+		attribute.Bool("guest", guest != ""),
+	)
+
+	video, downgrades, err := matchCapabilities(stream, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Mode.Video() {
+		downgrades = slices.DeleteFunc(downgrades, func(d Downgrade) bool {
+			return d.Track == "video"
+		})
+	}
+
+	sess := &SessionDescriptor{
+		Inbox:          strings.TrimPrefix(reply, "peers.negotiation."),
+		Stream:         stream.Name,
+		Permissions:    opts.Permissions,
+		Mode:           opts.Mode,
+		StillsInterval: opts.StillsInterval,
+		Guest:          guest,
+		GuestToken:     opts.GuestToken,
+		Slot:           slot,
+		RequestID:      requestID,
+	}
+
+	if video != nil {
+		sess.Codec = video.Codec()
+	}
+
+	peer, err := svc.newPeer(ctx, stream, video, downgrades, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	deadlines := svc.deadlines()
+
+	if err := svc.negotiate(ctx, peer, stream, offer, reply, deadlines.Negotiation); err != nil {
+		if errors.Is(err, ErrNegotiationTimeout) {
+			peer.peerFailed(PeerFailedNegotiationTimeout, deadlines.Negotiation)
+		}
+
+		peer.Close()
+		return nil, err
+	}
+
+	peer.expectConnection(deadlines.Connection)
+
+	if svc.sessions != nil {
+		svc.saveSession(peer)
+	}
+
+	return peer, nil
+}
+
+// newPeer builds the peer of a session, added to its stream's peers.
+func (svc *service) newPeer(ctx context.Context, stream *Stream, video *VideoTrack, downgrades []Downgrade, sess *SessionDescriptor) (*Peer, error) {
+	if sess.Mode == PeerModeStills && stream.keyframes == nil {
+		return nil, errors.New("stills unsupported for stream: " + stream.Name)
+	}
+
+	if sess.Mode == PeerModePreview && stream.preview == nil {
+		return nil, errors.New("preview unsupported for stream: " + stream.Name)
+	}
+
+	serverICE := svc.serverICE
+	if serverICE == nil {
+		serverICE = defaultServerICEPolicy
+	}
+
+	creds, err := svc.policyICEServers(ctx, serverICE, serverICE.Preferred())
 	if err != nil {
 		return nil, err
 	}
 
 	configuration := webrtc.Configuration{
-		ICEServers: servers,
+		ICEServers: creds.Servers,
+	}
+
+	if creds.RelayOnly {
+		configuration.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+
+	handshake := new(dtlsHandshake)
+
+	media := peerMedia{
+		targetLatency: stream.TargetLatency,
+	}
+
+	if video != nil {
+		media.video = video.Codec()
+	}
+
+	if stream.Audio != nil {
+		media.opusFmtp = stream.Audio.Fmtp()
+	}
+
+	// The noise gate judges the levels of the peer's microphone.
+	if mic := svc.microphone; mic != nil && mic.Gate != nil && sess.Permissions.Has(PermissionMicrophone) {
+		media.audioLevel = true
+	}
+
+	api, err := newPeerAPI(svc.cfg.WebRTC.DTLSRole, handshake, media)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := api.NewPeerConnection(configuration)
+	if err != nil {
+		return nil, err
 	}
 
-	conn, err := webrtc.NewPeerConnection(configuration)
+	signaling, err := svc.signaling.Open(sess.Inbox, conn)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	conn.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+	reply := sess.reply()
+
+	signaling.OnCandidate(func(candidate *webrtc.ICECandidate) {
 		bs, err := json.Marshal(&candidate)
 		if err != nil {
 			return
 		}
 
-		svc.nc.Publish(reply+".candidates.callee", bs)
-	})
+		msg := nats.NewMsg(reply + ".candidates.callee")
+		msg.Data = bs
+
+		if sess.RequestID != "" {
+			msg.Header.Set(RequestIDHeader, sess.RequestID)
+		}
+
+		// Candidates belong to the trace of the negotiation.
+		telemetry.Inject(ctx, msg.Header)
 
-	inbox := strings.TrimPrefix(reply, "peers.negotiation.")
+		svc.nc.PublishMsg(msg)
+	})
 
 	peer := &Peer{
 		PeerConnection: conn,
+		id:             sess.Inbox,
 		log: svc.log.With(
-			zap.String("peer", inbox),
+			zap.String("peer", sess.Inbox),
+			zap.String("stream", stream.Name),
+			zap.Stringer("permissions", sess.Permissions),
+			zap.String("request_id", sess.RequestID),
 		),
-		gamepad: svc.gamepad,
+		perms:      sess.Permissions,
+		guest:      sess.Guest,
+		stream:     stream,
+		video:      video,
+		group:      stream.peers,
+		dtls:       handshake,
+		gamepad:    svc.gamepad,
+		gamepads:   svc.gamepads,
+		input:      svc.input,
+		files:      svc.files,
+		router:     svc.channels,
+		events:     svc.events,
+		metrics:    svc.metrics,
+		downgrades: downgrades,
+		mode:       sess.Mode,
+		deadzone:   svc.gamepadCfg.Deadzone,
+		sess:       sess,
+		sessions:   svc.sessions,
+		signaling:  signaling,
 	}
 
-	peer.Init()
+	peer.ctx, peer.cancel = context.WithCancel(context.Background())
 
-	sub, err := svc.nc.Subscribe(reply+".candidates.caller", peer.candidateUpdatedHandler())
-	if err != nil {
-		return nil, err
+	if sess.Guest == "" {
+		peer.hotkeys = svc.access().Hotkeys
 	}
 
-	peer.sub = sub
+	peer.reports = newReportLimiter(svc.gamepadRate, peer.submitReport)
+	peer.renegotiation = offerSignal(svc.nc, reply+".sdp.renegotiate", RenegotiationTimeout)
+	peer.findStream = func(name string) (*Stream, error) {
+		return svc.FindStream(context.Background(), name)
+	}
+	peer.addVideo = func() error {
+		return svc.addVideo(peer, stream)
+	}
 
-	stream, err := svc.FindStream("gamestream")
-	if err != nil {
-		return nil, err
+	if restart := svc.iceRestart; restart != nil && !restart.Disabled {
+		signal := restartSignal(svc.nc, reply, restart.Timeout)
+
+		peer.restarter = newICERestarter(restart,
+			func() error { return peer.restartICE(signal, restart.Timeout) },
+			func() { peer.Close() },
+			peer.log,
+		)
 	}
 
-	videoTrack := stream.Video.Track()
-	if videoTrack == nil {
-		return nil, errors.New("video track not found")
+	if sess.Mode == PeerModeStills {
+		peer.stillsInterval = max(sess.StillsInterval, MinStillsInterval)
+		if sess.StillsInterval == 0 {
+			peer.stillsInterval = DefaultStillsInterval
+		}
+
+		peer.snapshot = func(ctx context.Context, opts SnapshotOptions) (*Snapshot, error) {
+			return svc.Snapshot(ctx, stream.Name, opts)
+		}
 	}
 
-	if _, err := conn.AddTrack(videoTrack); err != nil {
-		return nil, err
+	if stream.Transport == TransportNV {
+		peer.input = NewMoonlightInput()
 	}
 
-	audioTrack := stream.Audio.Track()
-	if audioTrack == nil {
-		return nil, errors.New("audio track not found")
+	mic, cam := svc.microphone, svc.camera
+	if !sess.Permissions.Has(PermissionMicrophone) {
+		mic = nil
 	}
 
-	if _, err := conn.AddTrack(audioTrack); err != nil {
-		return nil, err
+	if !sess.Permissions.Has(PermissionCamera) {
+		cam = nil
 	}
 
-	if err := conn.SetRemoteDescription(offer); err != nil {
-		return nil, err
+	if mic != nil {
+		peer.requireTalk = mic.PushToTalk
 	}
 
-	answer, err := conn.CreateAnswer(nil)
-	if err != nil {
-		return nil, err
+	if mic != nil || cam != nil {
+		conn.OnTrack(peer.uplinkHandler(mic, cam))
 	}
 
-	gatherComplete := webrtc.GatheringCompletePromise(conn)
+	for _, d := range downgrades {
+		peer.log.Warn("downgrade applied",
+			zap.String("track", d.Track),
+			zap.String("property", d.Property),
+			zap.String("preferred", d.Preferred),
+			zap.String("applied", d.Applied),
+			zap.String("reason", d.Reason))
+	}
 
-	if err := conn.SetLocalDescription(answer); err != nil {
+	if err := stream.peers.Add(peer); err != nil {
+		signaling.Close()
+		conn.Close()
 		return nil, err
 	}
 
-	<-gatherComplete
+	if slot := sess.Slot; slot > 0 {
+		gamepad, err := svc.gamepads.Acquire(slot, peer.id)
+		if err != nil {
+			peer.Close()
+			return nil, err
+		}
+
+		peer.gamepad = gamepad
+		peer.slot = slot
+	}
 
-	svc.Lock()
-	svc.peers = append(svc.peers, peer)
-	svc.Unlock()
+	peer.Init()
 
 	return peer, nil
 }
 
-type Peer struct {
-	*webrtc.PeerConnection
-	log     *zap.Logger
-	sub     *nats.Subscription
-	gamepad Gamepad
-}
+func (svc *service) negotiate(ctx context.Context, peer *Peer, stream *Stream, offer webrtc.SessionDescription, reply string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
 
-func (peer *Peer) Init() {
-	log := peer.log
+	if err := svc.addTracks(peer, stream, reply); err != nil {
+		return err
+	}
 
-	peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-		log.Info("connection state updated",
-			zap.String("state", state.String()))
+	answerCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
 
-		if state == webrtc.PeerConnectionStateConnected {
-			moonlight.RequestIDRFrame()
-		}
-	})
+	_, span := telemetry.Start(ctx, "webrtc.ice_gathering")
+	defer span.End()
 
-	peer.OnDataChannel(func(dc *webrtc.DataChannel) {
-		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-			switch dc.Label() {
-			case "gamepad":
-				report := NewXBoxGamepadReport(
-					binary.BigEndian.Uint16(msg.Data[0:2]),
-					msg.Data[2],
-					msg.Data[3],
-					int16(binary.BigEndian.Uint16(msg.Data[4:6])),
-					int16(binary.BigEndian.Uint16(msg.Data[6:8])),
-					int16(binary.BigEndian.Uint16(msg.Data[8:10])),
-					int16(binary.BigEndian.Uint16(msg.Data[10:12])),
-				)
-
-				err := peer.gamepad.Update(report)
-				if err != nil {
-					log.Error(err.Error(),
-						zap.String("label", "gamepad"))
-				}
-			}
-		})
-	})
+	_, err := peer.signaling.Answer(answerCtx, offer)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = ErrNegotiationTimeout
+	}
+
+	telemetry.RecordError(span, err)
+
+	return err
 }
 
-func (peer *Peer) candidateUpdatedHandler() nats.MsgHandler {
-	log := peer.log.With(
-		zap.String("handler", "candidate_updated"),
-	)
+// addTracks subscribes to the client's candidates and adds the peer's
+// tracks, before either side offers.
+func (svc *service) addTracks(peer *Peer, stream *Stream, reply string) error {
+	sub, err := svc.subs.Subscribe(svc.nc, reply+".candidates.caller", peer, peer.candidateUpdatedHandler())
+	if err != nil {
+		return err
+	}
 
-	return func(msg *nats.Msg) {
-		var candidate webrtc.ICECandidateInit
-		if err := json.Unmarshal(msg.Data, &candidate); err != nil {
-			log.Error(err.Error())
-			return
+	peer.sub = sub
+
+	offers, err := svc.subs.Subscribe(svc.nc, reply+".sdp.offer", peer, peer.offerHandler())
+	if err != nil {
+		return err
+	}
+
+	peer.offers = offers
+
+	if peer.mode.Video() {
+		if err := svc.addVideo(peer, stream); err != nil {
+			return err
 		}
+	}
 
-		if err := peer.AddICECandidate(candidate); err != nil {
-			log.Error(err.Error())
-			return
+	if peer.mode == PeerModePreview {
+		if _, err := peer.AddTrack(stream.preview.Track()); err != nil {
+			return err
 		}
 
-		log.Info("candidate added",
-			zap.String("candidate", candidate.Candidate))
+		stream.preview.Acquire()
+		peer.preview = stream.preview
 	}
+
+	// Audio is optional.
+	if stream.Audio == nil {
+		return nil
+	}
+
+	audioTrack := stream.Audio.Track()
+	if audioTrack == nil {
+		return errors.New("audio track not found")
+	}
+
+	audioSender, err := peer.AddTrack(audioTrack)
+	if err != nil {
+		return err
+	}
+
+	if err := preferOpus(peer.PeerConnection, audioSender, stream.Audio.Fmtp()); err != nil {
+		return err
+	}
+
+	peer.audioSender = audioSender
+
+	return nil
 }
 
-func (peer *Peer) ICEConnectionStateChangeHandler(cancel context.CancelFunc) func(webrtc.ICEConnectionState) {
-	log := peer.log.With(
-		zap.String("handler", "ice_connection_state_change"),
-	)
+// addVideo adds the peer's video track, paced unless disabled.
+func (svc *service) addVideo(peer *Peer, stream *Stream) error {
+	video := peer.Rendition()
+	if video == nil || video.Track() == nil {
+		return errors.New("video track not found")
+	}
 
-	return func(state webrtc.ICEConnectionState) {
-		log.Info("connection state has changed",
-			zap.String("state", state.String()))
+	videoTrack := video.Track()
 
-		if state == webrtc.ICEConnectionStateConnected {
-			cancel()
+	pacer, err := svc.newPacer(peer, stream)
+	if err != nil {
+		return err
+	}
+
+	if pacer != nil {
+		videoTrack = pacer.Track()
+	}
+
+	videoSender, err := peer.AddTrack(videoTrack)
+	if err != nil {
+		if pacer != nil {
+			video.RemoveSink(pacer)
+			pacer.Close()
 		}
+
+		return err
 	}
+
+	peer.Lock()
+	peer.pacer = pacer
+	peer.videoSender = videoSender
+	peer.Unlock()
+
+	go peer.watchQuality(videoSender)
+
+	return nil
 }
 
-func (svc *service) Close() error {
-	if svc.gamepad != nil {
-		svc.gamepad.Close()
-		svc.gamepad = nil
+// newPacer gives the peer its own video track of an H264 stream, fed from
+// a bounded queue; nil when pacing is disabled.
+func (svc *service) newPacer(peer *Peer, stream *Stream) (*peerPacer, error) {
+	cfg := stream.Pacing
+	if cfg == nil {
+		cfg = defaultPacing
+	}
+
+	video := peer.Rendition()
+	if cfg.Disabled || video.Codec() != CodecH264 {
+		return nil, nil
+	}
+
+	pacer, err := newPeerPacer(cfg, video, stream.Name+"_video", stream.Name, peer.log)
+	if err != nil {
+		return nil, err
+	}
+
+	// NVStream hosts send keyframes only on request, and so may encoders
+	// with hooks.
+	if stream.source != nil {
+		pacer.requestKeyframe = func() {
+			go stream.requestKeyframe(context.Background())
+		}
 	}
 
-	if svc.cancel != nil {
-		svc.cancel()
-		svc.cancel = nil
+	video.AddSink(pacer)
+
+	go pacer.Run()
+
+	return pacer, nil
+}
+
+func (svc *service) closePeers(ctx context.Context) error {
+	for _, stream := range svc.streamList() {
+		for _, peer := range stream.peers.Peers() {
+			// Their clients resume after the restart.
+			peer.detachSession()
+			peer.Close()
+		}
 	}
 
 	return nil
 }
+
+func (svc *service) Close() error {
+	return svc.lifecycle.Stop(context.Background())
+}
@@ -1,9 +1,15 @@
 package game
 
 import (
+	"context"
+	"errors"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/nvstream"
 )
 
 func TestICEServers(t *testing.T) {
@@ -25,36 +31,191 @@ func TestICEServers(t *testing.T) {
 		return
 	}
 
+	// The service holds a controller number until closed.
+	t.Cleanup(func() { svc.Close() })
+
 	for _, cfg := range cfg.WebRTC.ICEServers {
 		switch cfg.Provider {
 		case Google:
-			servers, err := svc.ICEServers(Google)
+			creds, err := svc.ICEServers(context.Background(), Google)
 			if err != nil {
 				assert.Fail(err.Error())
 				return
 			}
 
-			assert.Len(servers, 1)
-			assert.Len(servers[0].URLs, 5)
+			assert.Len(creds.Servers, 1)
+			assert.Len(creds.Servers[0].URLs, 5)
+			assert.True(creds.ExpiresAt.IsZero())
 
 		case Cloudflare:
-			servers, err := svc.ICEServers(Cloudflare)
+			creds, err := svc.ICEServers(context.Background(), Cloudflare)
 			if err != nil {
 				assert.Fail(err.Error())
 				return
 			}
 
-			assert.Len(servers, 1)
-			assert.Len(servers[0].URLs, 4)
+			assert.Len(creds.Servers, 1)
+			assert.Len(creds.Servers[0].URLs, 4)
+			assert.False(creds.ExpiresAt.IsZero())
 
 		case Metered:
-			servers, err := svc.ICEServers(Metered)
+			creds, err := svc.ICEServers(context.Background(), Metered)
 			if err != nil {
 				assert.Fail(err.Error())
 				return
 			}
 
-			assert.Len(servers, 5)
+			assert.Len(creds.Servers, 5)
 		}
 	}
 }
+
+func TestCredentialExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	expiresAt, ok := credentialExpiry("1735689600:alice")
+	assert.True(ok)
+	assert.Equal(time.Unix(1735689600, 0), expiresAt)
+
+	_, ok = credentialExpiry("b1946ac92492d2347c6235b4d2611184")
+	assert.False(ok)
+
+	creds := new(ICECredentials)
+	creds.expire(time.Unix(200, 0))
+	creds.expire(time.Unix(100, 0))
+	creds.expire(time.Unix(300, 0))
+	creds.expire(time.Time{})
+	assert.Equal(time.Unix(100, 0), creds.ExpiresAt)
+}
+
+func TestFrameClock(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := &frameClock{fallback: 16 * time.Millisecond}
+
+	// the first frame has no previous one
+	assert.Equal(16*time.Millisecond, clock.Duration(1000*time.Millisecond))
+
+	// variable frame rate
+	assert.Equal(17*time.Millisecond, clock.Duration(1017*time.Millisecond))
+	assert.Equal(33*time.Millisecond, clock.Duration(1050*time.Millisecond))
+
+	// a timestamp going back, or a long gap, is a discontinuity
+	assert.Equal(16*time.Millisecond, clock.Duration(900*time.Millisecond))
+	assert.Equal(16*time.Millisecond, clock.Duration(5*time.Second))
+	assert.Equal(20*time.Millisecond, clock.Duration(5020*time.Millisecond))
+}
+
+func TestFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	a, _ := url.Parse("https://a:47984")
+	b, _ := url.Parse("https://b:47984")
+
+	var tried []string
+	origin, err := failover([]*url.URL{a, b}, func(origin *url.URL) error {
+		tried = append(tried, origin.Host)
+		if origin == a {
+			return nvstream.ErrNotPaired
+		}
+
+		return nil
+	})
+
+	assert.NoError(err)
+	assert.Equal(b, origin)
+	assert.Equal([]string{"a:47984", "b:47984"}, tried)
+
+	_, err = failover([]*url.URL{a, b}, func(origin *url.URL) error {
+		return nvstream.ErrNotPaired
+	})
+
+	assert.ErrorIs(err, nvstream.ErrNotPaired)
+	assert.ErrorContains(err, "b:47984")
+
+	_, err = failover(nil, func(origin *url.URL) error { return nil })
+	assert.Error(err)
+}
+
+// fakeHost is an NVStream host serving apps.
+type fakeHost struct {
+	nvstream.NvHTTP
+	name string
+	apps []nvstream.NvApp
+}
+
+func (h *fakeHost) AppList() ([]nvstream.NvApp, error) {
+	if h.apps == nil {
+		return nil, errors.New("host unreachable")
+	}
+
+	return h.apps, nil
+}
+
+// relocatedConnection records the host it is relocated to.
+type relocatedConnection struct {
+	nvstream.NvConnection
+	host *fakeHost
+	app  nvstream.NvApp
+}
+
+func (conn *relocatedConnection) Relocate(ctx context.Context, http nvstream.NvHTTP, app nvstream.NvApp) error {
+	conn.host = http.(*fakeHost)
+	conn.app = app
+	return nil
+}
+
+func TestSwitchOrigin(t *testing.T) {
+	assert := assert.New(t)
+
+	a, _ := url.Parse("https://a:47984")
+	b, _ := url.Parse("https://b:47984")
+	c, _ := url.Parse("https://c:47984")
+
+	hosts := map[*url.URL]*fakeHost{
+		a: {name: "a", apps: []nvstream.NvApp{{ID: 1, Name: "Desktop"}}},
+		b: {name: "b"},
+		c: {name: "c", apps: []nvstream.NvApp{{ID: 7, Name: "Desktop"}}},
+	}
+
+	newHTTP := func(origin *url.URL) (nvstream.NvHTTP, error) {
+		return hosts[origin], nil
+	}
+
+	stream := &Stream{
+		Name:      "game",
+		Transport: TransportNV,
+		Origins:   []*url.URL{a, b, c},
+		NVStream:  &nvstream.StreamConfiguration{App: nvstream.NvApp{ID: 1, Name: "Desktop"}},
+	}
+
+	stream.origin.Store(a)
+
+	conn := new(relocatedConnection)
+
+	// b is down, so c takes over with its own app
+	origin, err := switchOrigin(context.Background(), stream, conn, newHTTP)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(c, origin)
+	assert.Equal(c, stream.Address())
+	assert.Equal("c", conn.host.name)
+	assert.Equal(7, conn.app.ID)
+	assert.Equal(7, stream.app.Load().ID)
+
+	// from c the origins wrap around to a
+	origin, err = switchOrigin(context.Background(), stream, conn, newHTTP)
+	assert.NoError(err)
+	assert.Equal(a, origin)
+	assert.Equal("a", conn.host.name)
+
+	// with no origin to take over, the stream stays on its own
+	hosts[c].apps = nil
+
+	_, err = switchOrigin(context.Background(), stream, conn, newHTTP)
+	assert.Error(err)
+	assert.Equal(a, stream.Address())
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// SessionManager keeps the open sessions by ID. A nil manager opens
+// sessions it does not keep.
+type SessionManager struct {
+	sessions map[string]*Session
+	sync.RWMutex
+}
+
+func NewSessionManager() *SessionManager {
+	return &SessionManager{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Open opens the session of a peer connection.
+func (m *SessionManager) Open(id string, pc *webrtc.PeerConnection) (*Session, error) {
+	s := &Session{
+		id: id,
+		pc: pc,
+	}
+
+	if m == nil {
+		return s, nil
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.sessions[id]; ok {
+		return nil, ErrSessionExists
+	}
+
+	s.manager = m
+	m.sessions[id] = s
+
+	return s, nil
+}
+
+// Session returns the open session of the ID.
+func (m *SessionManager) Session(id string) (*Session, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	s, ok := m.sessions[id]
+	return s, ok
+}
+
+// IDs returns the IDs of the open sessions, sorted.
+func (m *SessionManager) IDs() []string {
+	if m == nil {
+		return nil
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	ids := make([]string, 0, len(m.sessions))
+	for id := range m.sessions {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+func (m *SessionManager) remove(s *Session) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.sessions[s.id] == s {
+		delete(m.sessions, s.id)
+	}
+}
@@ -0,0 +1,242 @@
+// Package session negotiates WebRTC sessions apart from their media: it
+// answers the first offer, lets either side offer again afterwards and
+// passes on the candidates, per session ID. How offers and answers travel
+// is up to the caller, so it serves any signaling transport.
+//
+// Offers after the first negotiation follow the perfect negotiation
+// pattern with this side as the impolite peer: when both sides offer at
+// once, the remote offer is rejected with ErrGlare and the remote side
+// rolls its own back to answer ours.
+//
+// Our offers are applied only once the remote side answers them, so one
+// that fails leaves the connection as it was. A failure past that point
+// cannot be rolled back by pion and ends in ErrStuck: the connection can
+// not negotiate again and has to be replaced.
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var (
+	ErrGlare         = errors.New("offer collision")
+	ErrSessionExists = errors.New("session already exists")
+	ErrClosed        = errors.New("session closed")
+	ErrStuck         = errors.New("signaling state cannot be restored")
+)
+
+// Signal sends an offer to the remote side and returns its answer.
+type Signal func(offer webrtc.SessionDescription) (webrtc.SessionDescription, error)
+
+// Session is the signaling state of a peer connection.
+type Session struct {
+	id      string
+	pc      *webrtc.PeerConnection
+	manager *SessionManager
+
+	// negotiation serializes the offers of either side.
+	negotiation sync.Mutex
+	closed      bool
+	sync.RWMutex
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Answer applies the remote side's first offer and returns the answer,
+// once the local candidates are gathered. It fails with the context's
+// error if gathering is not complete by then.
+func (s *Session) Answer(ctx context.Context, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	s.negotiation.Lock()
+	defer s.negotiation.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.pc.SetRemoteDescription(offer); err != nil {
+		return nil, err
+	}
+
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(s.pc)
+
+	if err := s.pc.SetLocalDescription(answer); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return s.pc.LocalDescription(), nil
+}
+
+// Offer sends the remote side an offer through signal and applies its
+// answer. The offer carries the candidates gathered within gather. It is
+// applied only along with the answer, so on failure the next one can be
+// made; if the answer cannot be applied the error wraps ErrStuck.
+func (s *Session) Offer(ctx context.Context, opts *webrtc.OfferOptions, gather time.Duration, signal Signal) error {
+	s.negotiation.Lock()
+	defer s.negotiation.Unlock()
+
+	if s.isClosed() {
+		return ErrClosed
+	}
+
+	if state := s.pc.SignalingState(); state != webrtc.SignalingStateStable {
+		return errors.New("negotiation in progress: " + state.String())
+	}
+
+	offer, err := s.pc.CreateOffer(opts)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-webrtc.GatheringCompletePromise(s.pc):
+	case <-time.After(gather):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// An ICE restart gathers anew from the offer on; the offer made again
+	// carries its candidates and credentials.
+	if opts != nil && opts.ICERestart {
+		offer, err = s.pc.CreateOffer(nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	answer, err := signal(offer)
+	if err != nil {
+		return err
+	}
+
+	if err := s.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	if err := s.pc.SetRemoteDescription(answer); err != nil {
+		return s.rollback(err)
+	}
+
+	return nil
+}
+
+// AnswerOffer applies an offer the remote side made after the first
+// negotiation and returns the answer, carrying the candidates gathered
+// within gather. It fails with ErrGlare while an offer is under way, and
+// with ErrStuck if the offer was applied but cannot be answered.
+func (s *Session) AnswerOffer(offer webrtc.SessionDescription, gather time.Duration) (*webrtc.SessionDescription, error) {
+	if offer.Type != webrtc.SDPTypeOffer {
+		return nil, errors.New("unexpected sdp type: " + offer.Type.String())
+	}
+
+	if !s.negotiation.TryLock() {
+		return nil, ErrGlare
+	}
+	defer s.negotiation.Unlock()
+
+	if s.isClosed() {
+		return nil, ErrClosed
+	}
+
+	if s.pc.SignalingState() != webrtc.SignalingStateStable {
+		return nil, ErrGlare
+	}
+
+	if err := s.pc.SetRemoteDescription(offer); err != nil {
+		return nil, err
+	}
+
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, s.rollback(err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(s.pc)
+
+	if err := s.pc.SetLocalDescription(answer); err != nil {
+		return nil, s.rollback(err)
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-time.After(gather):
+	}
+
+	return s.pc.LocalDescription(), nil
+}
+
+// AddCandidate adds a candidate of the remote side.
+func (s *Session) AddCandidate(candidate webrtc.ICECandidateInit) error {
+	if s.isClosed() {
+		return ErrClosed
+	}
+
+	return s.pc.AddICECandidate(candidate)
+}
+
+// OnCandidate calls fn with the local candidates as they are gathered,
+// and with nil once gathering is complete.
+func (s *Session) OnCandidate(fn func(candidate *webrtc.ICECandidate)) {
+	s.pc.OnICECandidate(fn)
+}
+
+// Close ends the session and removes it from its manager. The peer
+// connection is left to the caller.
+func (s *Session) Close() {
+	s.Lock()
+	closed := s.closed
+	s.closed = true
+	s.Unlock()
+
+	if !closed && s.manager != nil {
+		s.manager.remove(s)
+	}
+}
+
+func (s *Session) isClosed() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.closed
+}
+
+// rollback returns to the stable state from the offer under way, ours or
+// the remote side's, after it failed with err. Pion refuses rollbacks, so
+// the connection is usually left stuck in the offer: then the error wraps
+// ErrStuck.
+func (s *Session) rollback(err error) error {
+	rollback := webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}
+
+	var rbErr error
+	switch s.pc.SignalingState() {
+	case webrtc.SignalingStateHaveLocalOffer:
+		rbErr = s.pc.SetLocalDescription(rollback)
+
+	case webrtc.SignalingStateHaveRemoteOffer:
+		rbErr = s.pc.SetRemoteDescription(rollback)
+	}
+
+	if rbErr != nil {
+		return fmt.Errorf("%w: %w (rollback: %w)", ErrStuck, err, rbErr)
+	}
+
+	return err
+}
@@ -0,0 +1,281 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPeerConnection(t *testing.T) *webrtc.PeerConnection {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { pc.Close() })
+
+	return pc
+}
+
+// answer applies an offer to the peer at the other end.
+func answer(pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return answer, err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return answer, err
+	}
+
+	<-gatherComplete
+
+	return *pc.LocalDescription(), nil
+}
+
+// offer makes an offer of the peer at the other end.
+func offer(pc *webrtc.PeerConnection) (webrtc.SessionDescription, error) {
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return offer, err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return offer, err
+	}
+
+	<-gatherComplete
+
+	return *pc.LocalDescription(), nil
+}
+
+func TestSession(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newPeerConnection(t)
+	client := newPeerConnection(t)
+
+	if _, err := client.CreateDataChannel("control", nil); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	manager := NewSessionManager()
+
+	s, err := manager.Open("peer", server)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = manager.Open("peer", server)
+	assert.ErrorIs(err, ErrSessionExists)
+
+	// the first offer is the client's
+	o, err := offer(client)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	a, err := s.Answer(context.Background(), o)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(webrtc.SDPTypeAnswer, a.Type)
+	assert.NoError(client.SetRemoteDescription(*a))
+
+	// then either side offers
+	err = s.Offer(context.Background(), nil, time.Second, func(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		return answer(client, offer)
+	})
+
+	assert.NoError(err)
+	assert.Equal(webrtc.SignalingStateStable, server.SignalingState())
+
+	o, err = offer(client)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	a, err = s.AnswerOffer(o, time.Second)
+	if assert.NoError(err) {
+		assert.NoError(client.SetRemoteDescription(*a))
+	}
+
+	// a client offer while the server offers collides
+	offering := make(chan struct{})
+	release := make(chan struct{})
+
+	offered := make(chan error, 1)
+
+	go func() {
+		offered <- s.Offer(context.Background(), nil, time.Second, func(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+			close(offering)
+			<-release
+			return answer(client, offer)
+		})
+	}()
+
+	select {
+	case <-offering:
+	case err := <-offered:
+		assert.Fail("offer returned", err)
+		return
+	}
+
+	_, err = s.AnswerOffer(o, time.Second)
+	assert.ErrorIs(err, ErrGlare)
+	close(release)
+
+	assert.NoError(<-offered)
+
+	assert.Equal([]string{"peer"}, manager.IDs())
+
+	s.Close()
+
+	_, ok := manager.Session("peer")
+	assert.False(ok)
+	assert.Empty(manager.IDs())
+
+	assert.ErrorIs(s.AddCandidate(webrtc.ICECandidateInit{}), ErrClosed)
+}
+
+func TestAnswerCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newPeerConnection(t)
+
+	if _, err := client.CreateDataChannel("control", nil); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	o, err := offer(client)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// a nil manager opens sessions it does not keep
+	var manager *SessionManager
+
+	s, err := manager.Open("peer", newPeerConnection(t))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.Answer(ctx, o)
+	assert.ErrorIs(err, context.Canceled)
+}
+
+func TestAnswerOfferFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newPeerConnection(t)
+
+	if _, err := client.CreateDataChannel("control", nil); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// an offer to a closed connection is not applied
+	server := newPeerConnection(t)
+
+	s, err := NewSessionManager().Open("peer", server)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	server.Close()
+
+	o, err := offer(client)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = s.AnswerOffer(o, time.Second)
+	assert.Error(err)
+}
+
+func TestOfferTimedOut(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newPeerConnection(t)
+	client := newPeerConnection(t)
+
+	if _, err := client.CreateDataChannel("control", nil); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	s, err := NewSessionManager().Open("peer", server)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	o, err := offer(client)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	a, err := s.Answer(context.Background(), o)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if err := client.SetRemoteDescription(*a); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	timedOut := func(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		return webrtc.SessionDescription{}, context.DeadlineExceeded
+	}
+
+	answered := func(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		return answer(client, offer)
+	}
+
+	// an offer the client never answers leaves the connection as it was,
+	// so the next offer succeeds; so do ICE restarts
+	for _, opts := range []*webrtc.OfferOptions{nil, {ICERestart: true}} {
+		err = s.Offer(context.Background(), opts, time.Second, timedOut)
+		assert.ErrorIs(err, context.DeadlineExceeded)
+		assert.Equal(webrtc.SignalingStateStable, server.SignalingState())
+
+		err = s.Offer(context.Background(), opts, time.Second, answered)
+		assert.NoError(err)
+		assert.Equal(webrtc.SignalingStateStable, server.SignalingState())
+	}
+
+	// an answer that cannot be applied leaves it stuck
+	err = s.Offer(context.Background(), nil, time.Second, func(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+		return webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "v=0"}, nil
+	})
+
+	assert.ErrorIs(err, ErrStuck)
+}
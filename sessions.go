@@ -0,0 +1,345 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Sessions persists what the service knows of each peer in a JetStream
+// key-value bucket, so that after a restart it can offer the peers' clients
+// a new connection on their inboxes instead of leaving them to join again.
+type Sessions struct {
+	Enabled bool
+	Bucket  string
+	TTL     time.Duration // of a session not refreshed, e.g. after a crash
+	Timeout time.Duration // for a resumed client to answer
+}
+
+func (cfg *Sessions) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Enabled bool          `yaml:"enabled"`
+		Bucket  string        `yaml:"bucket"`
+		TTL     time.Duration `yaml:"ttl"`
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Bucket == "" {
+		raw.Bucket = defaultSessions.Bucket
+	}
+
+	if raw.TTL <= 0 {
+		raw.TTL = defaultSessions.TTL
+	}
+
+	if raw.Timeout <= 0 {
+		raw.Timeout = defaultSessions.Timeout
+	}
+
+	cfg.Enabled = raw.Enabled
+	cfg.Bucket = raw.Bucket
+	cfg.TTL = raw.TTL
+	cfg.Timeout = raw.Timeout
+
+	return nil
+}
+
+var defaultSessions = &Sessions{
+	Bucket:  "game_sessions",
+	TTL:     5 * time.Minute,
+	Timeout: 10 * time.Second,
+}
+
+// SessionDescriptor is a peer's session as persisted: enough to build the
+// peer again, though not its connection.
+type SessionDescriptor struct {
+	Inbox          string        `json:"inbox"`
+	Stream         string        `json:"stream"`
+	Permissions    Permissions   `json:"permissions"`
+	Mode           PeerMode      `json:"mode,omitempty"`
+	StillsInterval time.Duration `json:"stills_interval,omitempty"`
+	Codec          Codec         `json:"codec,omitempty"`
+	Rendition      string        `json:"rendition,omitempty"`
+
+	// A guest's token is verified again on resume, so that expired links
+	// and those signed with a lost secret end with the restart.
+	Guest      string `json:"guest,omitempty"`
+	GuestToken string `json:"guest_token,omitempty"`
+	Slot       int    `json:"slot,omitempty"`
+
+	RequestID string `json:"request_id,omitempty"` // of the negotiation
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// reply returns the reply subject of the session's negotiation.
+func (sess *SessionDescriptor) reply() string {
+	return "peers.negotiation." + sess.Inbox
+}
+
+// sessionStore keeps the session descriptors by inbox.
+type sessionStore interface {
+	Put(ctx context.Context, sess *SessionDescriptor) error
+	Delete(ctx context.Context, inbox string) error
+	List(ctx context.Context) ([]*SessionDescriptor, error)
+}
+
+// kvSessions stores the session descriptors in a key-value bucket whose
+// entries expire after the TTL, unless refreshed.
+type kvSessions struct {
+	kv jetstream.KeyValue
+}
+
+func newKVSessions(ctx context.Context, nc *nats.Conn, cfg *Sessions) (*kvSessions, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      cfg.Bucket,
+		Description: "game peer sessions",
+		TTL:         cfg.TTL,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &kvSessions{kv}, nil
+}
+
+func (s *kvSessions) Put(ctx context.Context, sess *SessionDescriptor) error {
+	bs, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Put(ctx, sess.Inbox, bs)
+	return err
+}
+
+func (s *kvSessions) Delete(ctx context.Context, inbox string) error {
+	err := s.kv.Delete(ctx, inbox)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *kvSessions) List(ctx context.Context) ([]*SessionDescriptor, error) {
+	watcher, err := s.kv.WatchAll(ctx, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+
+	var sessions []*SessionDescriptor
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case entry := <-watcher.Updates():
+			// nil marks the end of the stored values
+			if entry == nil {
+				return sessions, nil
+			}
+
+			var sess *SessionDescriptor
+			if err := json.Unmarshal(entry.Value(), &sess); err != nil {
+				continue
+			}
+
+			sessions = append(sessions, sess)
+		}
+	}
+}
+
+// session returns the peer's session as it is now; nil when not persisted.
+func (peer *Peer) session() *SessionDescriptor {
+	peer.RLock()
+	defer peer.RUnlock()
+
+	if peer.sessions == nil || peer.sess == nil {
+		return nil
+	}
+
+	sess := *peer.sess
+	sess.Mode = peer.mode
+
+	if peer.video != nil {
+		sess.Codec = peer.video.Codec()
+		sess.Rendition = peer.video.Name()
+	}
+
+	sess.UpdatedAt = time.Now()
+
+	return &sess
+}
+
+// detachSession keeps the peer's session once it closes, as when the
+// service stops to restart.
+func (peer *Peer) detachSession() {
+	peer.Lock()
+	defer peer.Unlock()
+
+	peer.sessions = nil
+}
+
+// dropSession removes the peer's session, which ended.
+func (peer *Peer) dropSession() {
+	peer.RLock()
+	sessions := peer.sessions
+	peer.RUnlock()
+
+	if sessions == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sessionStoreTimeout)
+	defer cancel()
+
+	if err := sessions.Delete(ctx, peer.id); err != nil {
+		peer.log.Warn("session not deleted", zap.Error(err))
+	}
+}
+
+// sessionStoreTimeout bounds each write of a session.
+const sessionStoreTimeout = 5 * time.Second
+
+// saveSession persists the peer's session.
+func (svc *service) saveSession(peer *Peer) {
+	sess := peer.session()
+	if sess == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sessionStoreTimeout)
+	defer cancel()
+
+	if err := svc.sessions.Put(ctx, sess); err != nil {
+		peer.log.Warn("session not saved", zap.Error(err))
+	}
+}
+
+// refreshSessions saves the sessions of the peers again before their TTL
+// runs out, with the renditions they switched to since.
+func (svc *service) refreshSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			for _, stream := range svc.streamList() {
+				for _, peer := range stream.peers.Peers() {
+					svc.saveSession(peer)
+				}
+			}
+		}
+	}
+}
+
+// resumeSessions offers the clients of the sessions left by the previous
+// run a new connection each.
+func (svc *service) resumeSessions(ctx context.Context) {
+	log := svc.log.With(zap.String("action", "resume_sessions"))
+
+	sessions, err := svc.sessions.List(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	for _, sess := range sessions {
+		go func() {
+			log := log.With(zap.String("peer", sess.Inbox))
+
+			if _, err := svc.resumeSession(ctx, sess); err != nil {
+				log.Warn("session not resumed", zap.Error(err))
+
+				svc.sessions.Delete(ctx, sess.Inbox)
+				return
+			}
+
+			log.Info("session resumed")
+		}()
+	}
+}
+
+// resumeSession builds the session's peer again and offers its client the
+// connection on the session's inbox, suffixed with .sdp.resume. As the
+// client's old connection shares no DTLS state with the new one, it
+// answers from a new connection.
+func (svc *service) resumeSession(ctx context.Context, sess *SessionDescriptor) (*Peer, error) {
+	if sess.GuestToken != "" {
+		token, err := svc.guests.Verify(sess.GuestToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if token.ID != sess.Guest || token.Stream != sess.Stream {
+			return nil, ErrPermissionDenied
+		}
+
+		sess.Permissions &= token.Role.Permissions()
+	} else {
+		sess.Permissions &= svc.access().Permissions
+	}
+
+	if svc.gamepadCfg.Disabled {
+		sess.Permissions &^= PermissionGamepad
+		sess.Slot = 0
+	}
+
+	stream, err := svc.FindStream(ctx, sess.Stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var video *VideoTrack
+	if sess.Mode.Video() {
+		video = stream.videoTrack(sess.Codec)
+		if video == nil {
+			return nil, errors.New("codec unavailable: " + string(sess.Codec))
+		}
+
+		if rendition, ok := stream.rendition(sess.Rendition); ok && rendition.Codec() == video.Codec() {
+			video = rendition
+		}
+	}
+
+	peer, err := svc.newPeer(ctx, stream, video, nil, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := svc.sessionCfg.Timeout
+
+	err = svc.addTracks(peer, stream, sess.reply())
+	if err == nil {
+		err = peer.signaling.Offer(ctx, nil, timeout, offerSignal(svc.nc, sess.reply()+".sdp.resume", timeout))
+	}
+
+	if err != nil {
+		peer.Close()
+		return nil, err
+	}
+
+	return peer, nil
+}
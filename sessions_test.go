@@ -0,0 +1,216 @@
+package game
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+type memorySessions struct {
+	sessions map[string]*SessionDescriptor
+	sync.Mutex
+}
+
+func (s *memorySessions) Put(ctx context.Context, sess *SessionDescriptor) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.sessions[sess.Inbox] = sess
+	return nil
+}
+
+func (s *memorySessions) Delete(ctx context.Context, inbox string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.sessions, inbox)
+	return nil
+}
+
+func (s *memorySessions) List(ctx context.Context) ([]*SessionDescriptor, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var sessions []*SessionDescriptor
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+
+	return sessions, nil
+}
+
+func TestSessionsConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var cfg Sessions
+	if err := yaml.Unmarshal([]byte(`enabled: true`), &cfg); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.True(cfg.Enabled)
+	assert.Equal("game_sessions", cfg.Bucket)
+	assert.Equal(5*time.Minute, cfg.TTL)
+	assert.Equal(10*time.Second, cfg.Timeout)
+}
+
+func TestPeerSession(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &memorySessions{sessions: make(map[string]*SessionDescriptor)}
+
+	full := &VideoTrack{codec: CodecH264}
+	low := &VideoTrack{name: "720p30", codec: CodecH264}
+
+	newPeer := func(inbox string) *Peer {
+		conn, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &Peer{
+			PeerConnection: conn,
+			id:             inbox,
+			log:            zap.NewNop(),
+			video:          full,
+			sess: &SessionDescriptor{
+				Inbox:       inbox,
+				Stream:      "game",
+				Permissions: PermissionGamepad,
+				Codec:       CodecH264,
+			},
+			sessions: store,
+		}
+	}
+
+	svc := &service{sessions: store}
+
+	ended := newPeer("ended")
+	svc.saveSession(ended)
+	assert.Contains(store.sessions, "ended")
+
+	ended.Close()
+	assert.NotContains(store.sessions, "ended")
+
+	restarted := newPeer("restarted")
+	restarted.video = low
+	svc.saveSession(restarted)
+
+	sess := store.sessions["restarted"]
+	if assert.NotNil(sess) {
+		assert.Equal("720p30", sess.Rendition)
+		assert.Equal("peers.negotiation.restarted", sess.reply())
+	}
+
+	restarted.detachSession()
+	restarted.Close()
+	assert.Contains(store.sessions, "restarted")
+}
+
+// runNATSServer starts an embedded nats-server for the test.
+func runNATSServer(t *testing.T) string {
+	return serveNATS(t, &server.Options{})
+}
+
+// runJetStreamServer starts an embedded nats-server with JetStream.
+func runJetStreamServer(t *testing.T) string {
+	return serveNATS(t, &server.Options{
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+}
+
+// serveNATS starts a nats-server of the options on a free local port,
+// shut down with the test.
+func serveNATS(t *testing.T, opts *server.Options) string {
+	opts.Host = "127.0.0.1"
+	opts.Port = -1
+	opts.NoLog = true
+	opts.NoSigs = true
+
+	srv, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go srv.Start()
+	t.Cleanup(srv.Shutdown)
+
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server not ready")
+	}
+
+	return srv.ClientURL()
+}
+
+func TestKVSessions(t *testing.T) {
+	assert := assert.New(t)
+
+	nc, err := nats.Connect(runJetStreamServer(t))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer nc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg := *defaultSessions
+
+	store, err := newKVSessions(ctx, nc, &cfg)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	sessions, err := store.List(ctx)
+	assert.NoError(err)
+	assert.Empty(sessions)
+
+	for _, inbox := range []string{"first", "second"} {
+		err := store.Put(ctx, &SessionDescriptor{
+			Inbox:       inbox,
+			Stream:      "game",
+			Permissions: PermissionGamepad,
+			Rendition:   "720p30",
+		})
+
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+	}
+
+	assert.NoError(store.Delete(ctx, "first"))
+	assert.NoError(store.Delete(ctx, "unknown"))
+
+	// a restarted service opens the same bucket
+	store, err = newKVSessions(ctx, nc, &cfg)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	sessions, err = store.List(ctx)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if !assert.Len(sessions, 1) {
+		return
+	}
+
+	assert.Equal("second", sessions[0].Inbox)
+	assert.Equal(PermissionGamepad, sessions[0].Permissions)
+	assert.Equal("720p30", sessions[0].Rendition)
+}
@@ -0,0 +1,187 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrNoKeyframe = errors.New("no keyframe received yet")
+
+type ImageFormat string
+
+const (
+	ImageJPEG ImageFormat = "jpeg"
+	ImagePNG  ImageFormat = "png"
+)
+
+func ParseImageFormat(format string) (ImageFormat, error) {
+	switch format {
+	case "", "jpeg", "jpg":
+		return ImageJPEG, nil
+	case "png":
+		return ImagePNG, nil
+	default:
+		return "", errors.New("image format not supported: " + format)
+	}
+}
+
+func (format ImageFormat) ContentType() string {
+	return "image/" + string(format)
+}
+
+// Snapshots configures how keyframes are decoded into images. Decoding is
+// done on demand by an ffmpeg process.
+type Snapshots struct {
+	FFmpeg  string
+	Timeout time.Duration
+}
+
+func (cfg *Snapshots) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		FFmpeg  string        `yaml:"ffmpeg"`
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.FFmpeg == "" {
+		raw.FFmpeg = "ffmpeg"
+	}
+
+	if raw.Timeout == 0 {
+		raw.Timeout = 5 * time.Second
+	}
+
+	cfg.FFmpeg = raw.FFmpeg
+	cfg.Timeout = raw.Timeout
+
+	return nil
+}
+
+var defaultSnapshots = &Snapshots{
+	FFmpeg:  "ffmpeg",
+	Timeout: 5 * time.Second,
+}
+
+type SnapshotOptions struct {
+	Format ImageFormat
+	Width  int // scaled keeping the aspect ratio, 0 keeps the source size
+}
+
+type Snapshot struct {
+	Stream     string
+	Format     ImageFormat
+	Data       []byte
+	CapturedAt time.Time
+}
+
+// keyframeCache keeps the most recent H264 keyframe of a stream together
+// with the parameter sets needed to decode it.
+type keyframeCache struct {
+	sps        []byte
+	pps        []byte
+	keyframe   []byte
+	capturedAt time.Time
+	sync.RWMutex
+}
+
+func (cache *keyframeCache) WriteSample(sample media.Sample) error {
+	cache.Lock()
+	defer cache.Unlock()
+
+	var idr [][]byte
+	for _, nal := range splitAnnexB(sample.Data) {
+		switch nalType(nal) {
+		case nalTypeSPS:
+			cache.sps = slices.Clone(nal)
+		case nalTypePPS:
+			cache.pps = slices.Clone(nal)
+		case nalTypeIDR:
+			idr = append(idr, nal)
+		}
+	}
+
+	if len(idr) == 0 || cache.sps == nil || cache.pps == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, nal := range append([][]byte{cache.sps, cache.pps}, idr...) {
+		buf.Write([]byte{0, 0, 0, 1})
+		buf.Write(nal)
+	}
+
+	cache.keyframe = buf.Bytes()
+	cache.capturedAt = time.Now()
+
+	return nil
+}
+
+// Keyframe returns the last keyframe as an Annex B byte stream.
+func (cache *keyframeCache) Keyframe() ([]byte, time.Time, error) {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	if cache.keyframe == nil {
+		return nil, time.Time{}, ErrNoKeyframe
+	}
+
+	return cache.keyframe, cache.capturedAt, nil
+}
+
+// decodeKeyframe converts an Annex B H264 keyframe to a single image.
+func decodeKeyframe(ctx context.Context, cfg *Snapshots, keyframe []byte, opts SnapshotOptions) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-f", "h264", "-i", "pipe:0",
+		"-frames:v", "1",
+	}
+
+	if opts.Width > 0 {
+		args = append(args, "-vf", "scale="+strconv.Itoa(opts.Width)+":-2")
+	}
+
+	switch opts.Format {
+	case ImagePNG:
+		args = append(args, "-c:v", "png")
+	default:
+		args = append(args, "-c:v", "mjpeg", "-q:v", "3")
+	}
+
+	args = append(args, "-f", "image2pipe", "pipe:1")
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, cfg.FFmpeg, args...)
+	cmd.Stdin = bytes.NewReader(keyframe)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, errors.New("decode keyframe: " + string(msg))
+		}
+
+		return nil, err
+	}
+
+	if stdout.Len() == 0 {
+		return nil, errors.New("decode keyframe: no image produced")
+	}
+
+	return stdout.Bytes(), nil
+}
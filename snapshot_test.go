@@ -0,0 +1,57 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyframeCache(t *testing.T) {
+	assert := assert.New(t)
+
+	cache := new(keyframeCache)
+
+	_, _, err := cache.Keyframe()
+	assert.ErrorIs(err, ErrNoKeyframe)
+
+	sps := []byte{0, 0, 0, 1, 0x67, 0x64, 0x00, 0x1F, 0xAC}
+	pps := []byte{0, 0, 0, 1, 0x68, 0xEE, 0x3C, 0x80}
+	idr := []byte{0, 0, 0, 1, 0x65, 0x88, 0x84}
+
+	// parameter sets and keyframe arrive as separate samples
+	assert.NoError(cache.WriteSample(media.Sample{Data: sps}))
+	assert.NoError(cache.WriteSample(media.Sample{Data: pps}))
+	assert.NoError(cache.WriteSample(media.Sample{Data: idr}))
+	assert.NoError(cache.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x41, 0x9A}}))
+
+	keyframe, capturedAt, err := cache.Keyframe()
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var expected []byte
+	expected = append(expected, sps...)
+	expected = append(expected, pps...)
+	expected = append(expected, idr...)
+
+	assert.Equal(expected, keyframe)
+	assert.False(capturedAt.IsZero())
+}
+
+func TestParseImageFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	format, err := ParseImageFormat("")
+	assert.NoError(err)
+	assert.Equal(ImageJPEG, format)
+	assert.Equal("image/jpeg", format.ContentType())
+
+	format, err = ParseImageFormat("png")
+	assert.NoError(err)
+	assert.Equal(ImagePNG, format)
+
+	_, err = ParseImageFormat("gif")
+	assert.Error(err)
+}
@@ -0,0 +1,191 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/flarexio/game/nvstream"
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// QualityRequest asks a stream's source for another video quality; zero
+// fields keep their value.
+type QualityRequest struct {
+	Bitrate int `json:"bitrate,omitempty"` // kbps
+	Width   int `json:"width,omitempty"`
+	Height  int `json:"height,omitempty"`
+	FPS     int `json:"fps,omitempty"`
+}
+
+func (req *QualityRequest) validate() error {
+	if req.Bitrate < 0 || req.Width < 0 || req.Height < 0 || req.FPS < 0 {
+		return errors.New("negative quality")
+	}
+
+	if (req.Width == 0) != (req.Height == 0) {
+		return errors.New("width and height go together")
+	}
+
+	if *req == (QualityRequest{}) {
+		return errors.New("no quality requested")
+	}
+
+	return nil
+}
+
+// sourceControl changes what a stream's source sends while it runs.
+type sourceControl interface {
+	SetQuality(ctx context.Context, req QualityRequest) error
+	RequestKeyframe(ctx context.Context) error
+	SetHDR(ctx context.Context, enabled bool) error
+}
+
+// nvSource controls an NVStream host, which reads the video settings only
+// when a connection starts.
+type nvSource struct {
+	conn nvstream.NvConnection
+}
+
+func (s *nvSource) SetQuality(ctx context.Context, req QualityRequest) error {
+	return s.conn.Reconfigure(ctx, nvstream.VideoSettings{
+		Width:       req.Width,
+		Height:      req.Height,
+		RefreshRate: req.FPS,
+		Bitrate:     req.Bitrate,
+	})
+}
+
+func (s *nvSource) RequestKeyframe(ctx context.Context) error {
+	moonlight.RequestIDRFrame()
+	return nil
+}
+
+func (s *nvSource) SetHDR(ctx context.Context, enabled bool) error {
+	err := s.conn.SetHDR(ctx, enabled)
+	if errors.Is(err, nvstream.ErrHDRUnsupported) {
+		return NewControlError(ControlErrUnsupported, err.Error())
+	}
+
+	return err
+}
+
+// SourceHooks are the commands that change what the encoder feeding a raw
+// stream sends. The quality hook gets the request in GAME_BITRATE,
+// GAME_WIDTH, GAME_HEIGHT and GAME_FPS, empty when unchanged; both get the
+// stream's name in GAME_STREAM.
+type SourceHooks struct {
+	Quality  []string
+	Keyframe []string
+	Timeout  time.Duration
+}
+
+func (cfg *SourceHooks) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Quality  []string      `yaml:"quality"`
+		Keyframe []string      `yaml:"keyframe"`
+		Timeout  time.Duration `yaml:"timeout"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Timeout <= 0 {
+		raw.Timeout = defaultSourceHooks.Timeout
+	}
+
+	cfg.Quality = raw.Quality
+	cfg.Keyframe = raw.Keyframe
+	cfg.Timeout = raw.Timeout
+
+	return nil
+}
+
+var defaultSourceHooks = &SourceHooks{Timeout: 10 * time.Second}
+
+var errNoHook = NewControlError(ControlErrUnsupported, "unsupported by the source")
+
+// hookSource controls a raw stream's encoder through its hooks.
+type hookSource struct {
+	stream string
+	hooks  *SourceHooks
+}
+
+func (s *hookSource) SetQuality(ctx context.Context, req QualityRequest) error {
+	env := []string{
+		"GAME_BITRATE=" + formatQuality(req.Bitrate),
+		"GAME_WIDTH=" + formatQuality(req.Width),
+		"GAME_HEIGHT=" + formatQuality(req.Height),
+		"GAME_FPS=" + formatQuality(req.FPS),
+	}
+
+	return s.run(ctx, s.hooks.Quality, env)
+}
+
+func (s *hookSource) RequestKeyframe(ctx context.Context) error {
+	return s.run(ctx, s.hooks.Keyframe, nil)
+}
+
+func (s *hookSource) SetHDR(ctx context.Context, enabled bool) error {
+	return errNoHook
+}
+
+func (s *hookSource) run(ctx context.Context, argv []string, env []string) error {
+	if len(argv) == 0 {
+		return errNoHook
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.hooks.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "GAME_STREAM="+s.stream)
+	cmd.Env = append(cmd.Env, env...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return errors.New(err.Error() + ": " + msg)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func formatQuality(v int) string {
+	if v == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(v)
+}
+
+// minKeyframeInterval coalesces the keyframe requests of a stream's peers,
+// as each keyframe costs all of them bandwidth.
+const minKeyframeInterval = time.Second
+
+// requestKeyframe asks the stream's source for a keyframe, unless one was
+// asked for within minKeyframeInterval.
+func (s *Stream) requestKeyframe(ctx context.Context) error {
+	if s.source == nil {
+		return errNoHook
+	}
+
+	now := time.Now().UnixNano()
+
+	last := s.keyframeRequested.Load()
+	if now-last < int64(minKeyframeInterval) || !s.keyframeRequested.CompareAndSwap(last, now) {
+		return nil
+	}
+
+	return s.source.RequestKeyframe(ctx)
+}
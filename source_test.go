@@ -0,0 +1,166 @@
+package game
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestQualityRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&QualityRequest{Bitrate: 5000}).validate())
+	assert.NoError((&QualityRequest{Width: 1280, Height: 720, FPS: 30}).validate())
+
+	assert.Error((&QualityRequest{}).validate())
+	assert.Error((&QualityRequest{Width: 1280}).validate())
+	assert.Error((&QualityRequest{Bitrate: -1}).validate())
+}
+
+type fakeSource struct {
+	quality   []QualityRequest
+	keyframes int
+	hdr       []bool
+}
+
+func (s *fakeSource) SetQuality(ctx context.Context, req QualityRequest) error {
+	s.quality = append(s.quality, req)
+	return nil
+}
+
+func (s *fakeSource) RequestKeyframe(ctx context.Context) error {
+	s.keyframes++
+	return nil
+}
+
+func (s *fakeSource) SetHDR(ctx context.Context, enabled bool) error {
+	s.hdr = append(s.hdr, enabled)
+	return nil
+}
+
+func TestStreamRequestKeyframe(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := new(Stream)
+	assert.Error(stream.requestKeyframe(context.Background()))
+
+	source := new(fakeSource)
+	stream.source = source
+
+	// requests within a second are coalesced
+	assert.NoError(stream.requestKeyframe(context.Background()))
+	assert.NoError(stream.requestKeyframe(context.Background()))
+	assert.Equal(1, source.keyframes)
+}
+
+func TestHookSource(t *testing.T) {
+	assert := assert.New(t)
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	out := filepath.Join(t.TempDir(), "quality")
+
+	source := &hookSource{
+		stream: "game",
+		hooks: &SourceHooks{
+			Quality: []string{"sh", "-c", `echo "$GAME_STREAM $GAME_BITRATE $GAME_WIDTH $GAME_FPS" > ` + out},
+			Timeout: defaultSourceHooks.Timeout,
+		},
+	}
+
+	err := source.SetQuality(context.Background(), QualityRequest{Bitrate: 8000, FPS: 30})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	bs, err := os.ReadFile(out)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("game 8000  30\n", string(bs))
+
+	// without a hook
+	assert.ErrorIs(source.RequestKeyframe(context.Background()), errNoHook)
+
+	source.hooks.Keyframe = []string{"sh", "-c", "echo no encoder >&2; exit 1"}
+	assert.ErrorContains(source.RequestKeyframe(context.Background()), "no encoder")
+}
+
+func TestPeerMediaControl(t *testing.T) {
+	assert := assert.New(t)
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer pc.Close()
+
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "stream")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "stream")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	video := &VideoTrack{codec: CodecH264, track: videoTrack}
+	audio := &AudioTrack{codec: CodecOpus, track: audioTrack}
+
+	videoSender, err := pc.AddTrack(video.Track())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	audioSender, err := pc.AddTrack(audio.Track())
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	source := new(fakeSource)
+
+	peer := &Peer{
+		PeerConnection: pc,
+		log:            zap.NewNop(),
+		stream:         &Stream{Video: video, Audio: audio, source: source},
+		video:          video,
+		videoSender:    videoSender,
+		audioSender:    audioSender,
+	}
+
+	assert.NoError(peer.pauseVideo(true))
+	assert.Nil(videoSender.Track())
+	assert.True(peer.Info().VideoPaused)
+
+	assert.NoError(peer.muteAudio(true))
+	assert.Nil(audioSender.Track())
+
+	assert.NoError(peer.pauseVideo(false))
+	assert.Equal(video.Track(), videoSender.Track())
+
+	assert.NoError(peer.muteAudio(false))
+	assert.Equal(audio.Track(), audioSender.Track())
+
+	// audio-only peers get no video to pause
+	peer.mode = PeerModeAudioOnly
+	assert.Error(peer.pauseVideo(true))
+}
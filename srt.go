@@ -0,0 +1,427 @@
+package game
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// SRT packet constants, see the SRT protocol draft (draft-sharabayko-srt).
+const (
+	srtHeaderSize  = 16
+	srtPayloadSize = 7 * tsPacketSize // 1316 bytes
+	srtMTU         = 1500
+
+	srtCtrlHandshake uint16 = 0x0000
+	srtCtrlKeepalive uint16 = 0x0001
+	srtCtrlACK       uint16 = 0x0002
+	srtCtrlNAK       uint16 = 0x0003
+	srtCtrlShutdown  uint16 = 0x0005
+	srtCtrlACKACK    uint16 = 0x0006
+
+	srtHSInduction  uint32 = 0x00000001
+	srtHSConclusion uint32 = 0xFFFFFFFF
+
+	srtMagicCode uint16 = 0x4A17
+
+	srtExtHSReq uint16 = 0x0001
+	srtExtSID   uint16 = 0x0005
+
+	srtFlagHSReq  uint16 = 0x0001
+	srtFlagConfig uint16 = 0x0004
+
+	srtVersion uint32 = 0x00010500
+
+	// TSBPDSND | TSBPDRCV | TLPKTDROP | PERIODICNAK | REXMITFLG
+	srtOptions uint32 = 0x01 | 0x02 | 0x08 | 0x10 | 0x20
+
+	srtRetransmitFlag uint32 = 0x04000000
+	srtSoloPacket     uint32 = 0xC0000000
+
+	srtSendBuffer = 8192
+)
+
+var (
+	ErrSRTRejected = errors.New("srt connection rejected")
+	ErrSRTClosed   = errors.New("srt connection closed")
+)
+
+// srtConn is a minimal SRT caller in live mode. It only sends: payloads are
+// kept for retransmission on NAK and dropped once acknowledged.
+type srtConn struct {
+	conn     *net.UDPConn
+	start    time.Time
+	socketID uint32
+	peerID   uint32
+
+	seq   uint32
+	msgNo uint32
+	sent  map[uint32][]byte
+
+	lastRecv time.Time
+	lastSend time.Time
+	err      error
+	done     chan struct{}
+	sync.Mutex
+}
+
+func dialSRT(ctx context.Context, address string, streamID string, latency time.Duration) (*srtConn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &srtConn{
+		conn:     conn,
+		start:    time.Now(),
+		socketID: binary.BigEndian.Uint32(random[:4]) & 0x7FFFFFFF,
+		seq:      binary.BigEndian.Uint32(random[4:]) & 0x7FFFFFFF,
+		msgNo:    1,
+		sent:     make(map[uint32][]byte),
+		done:     make(chan struct{}),
+	}
+
+	if err := c.handshake(ctx, streamID, latency); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c.lastRecv = time.Now()
+
+	go c.readLoop()
+	go c.keepaliveLoop()
+
+	return c, nil
+}
+
+func (c *srtConn) timestamp() uint32 {
+	return uint32(time.Since(c.start).Microseconds())
+}
+
+func (c *srtConn) control(typ uint16, info uint32, cif []byte) []byte {
+	pkt := make([]byte, srtHeaderSize, srtHeaderSize+len(cif))
+	binary.BigEndian.PutUint32(pkt[0:], 0x80000000|uint32(typ)<<16)
+	binary.BigEndian.PutUint32(pkt[4:], info)
+	binary.BigEndian.PutUint32(pkt[8:], c.timestamp())
+	binary.BigEndian.PutUint32(pkt[12:], c.peerID)
+
+	return append(pkt, cif...)
+}
+
+func (c *srtConn) handshakeCIF(version uint32, extension uint16, typ uint32, cookie uint32) []byte {
+	cif := make([]byte, 48)
+	binary.BigEndian.PutUint32(cif[0:], version)
+	binary.BigEndian.PutUint16(cif[6:], extension)
+	binary.BigEndian.PutUint32(cif[8:], c.seq)
+	binary.BigEndian.PutUint32(cif[12:], srtMTU)
+	binary.BigEndian.PutUint32(cif[16:], 8192) // flow window
+	binary.BigEndian.PutUint32(cif[20:], typ)
+	binary.BigEndian.PutUint32(cif[24:], c.socketID)
+	binary.BigEndian.PutUint32(cif[28:], cookie)
+
+	return cif
+}
+
+func (c *srtConn) handshake(ctx context.Context, streamID string, latency time.Duration) error {
+	induction := c.control(srtCtrlHandshake, 0, c.handshakeCIF(4, 2, srtHSInduction, 0))
+
+	resp, err := c.exchange(ctx, induction)
+	if err != nil {
+		return err
+	}
+
+	if binary.BigEndian.Uint32(resp[0:]) != 5 || binary.BigEndian.Uint16(resp[6:]) != srtMagicCode {
+		return errors.New("srt listener does not support handshake v5")
+	}
+
+	cookie := binary.BigEndian.Uint32(resp[28:])
+
+	flags := srtFlagHSReq
+	if streamID != "" {
+		flags |= srtFlagConfig
+	}
+
+	cif := c.handshakeCIF(5, flags, srtHSConclusion, cookie)
+
+	ms := uint32(latency.Milliseconds())
+
+	hsreq := make([]byte, 4, 16)
+	binary.BigEndian.PutUint16(hsreq[0:], srtExtHSReq)
+	binary.BigEndian.PutUint16(hsreq[2:], 3)
+	hsreq = binary.BigEndian.AppendUint32(hsreq, srtVersion)
+	hsreq = binary.BigEndian.AppendUint32(hsreq, srtOptions)
+	hsreq = binary.BigEndian.AppendUint32(hsreq, ms<<16|ms)
+	cif = append(cif, hsreq...)
+
+	if streamID != "" {
+		cif = append(cif, srtStreamIDExtension(streamID)...)
+	}
+
+	resp, err = c.exchange(ctx, c.control(srtCtrlHandshake, 0, cif))
+	if err != nil {
+		return err
+	}
+
+	if typ := binary.BigEndian.Uint32(resp[20:]); typ != srtHSConclusion {
+		return ErrSRTRejected
+	}
+
+	c.peerID = binary.BigEndian.Uint32(resp[24:])
+
+	return nil
+}
+
+// srtStreamIDExtension encodes the stream ID, which SRT transmits as
+// little-endian 32-bit words.
+func srtStreamIDExtension(streamID string) []byte {
+	sid := []byte(streamID)
+	for len(sid)%4 != 0 {
+		sid = append(sid, 0)
+	}
+
+	ext := make([]byte, 4, 4+len(sid))
+	binary.BigEndian.PutUint16(ext[0:], srtExtSID)
+	binary.BigEndian.PutUint16(ext[2:], uint16(len(sid)/4))
+
+	for i := 0; i < len(sid); i += 4 {
+		ext = append(ext, sid[i+3], sid[i+2], sid[i+1], sid[i])
+	}
+
+	return ext
+}
+
+// exchange sends a handshake packet until a handshake response arrives
+// and returns the response CIF.
+func (c *srtConn) exchange(ctx context.Context, pkt []byte) ([]byte, error) {
+	buf := make([]byte, srtMTU)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if _, err := c.conn.Write(pkt); err != nil {
+			return nil, err
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if n < srtHeaderSize+48 || buf[0]&0x80 == 0 ||
+			binary.BigEndian.Uint16(buf[0:])&0x7FFF != srtCtrlHandshake {
+			continue
+		}
+
+		c.conn.SetReadDeadline(time.Time{})
+
+		return buf[srtHeaderSize:n], nil
+	}
+}
+
+// Write sends the payload as a single data packet; callers must keep
+// payloads within srtPayloadSize.
+func (c *srtConn) Write(payload []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	pkt := make([]byte, srtHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(pkt[0:], c.seq)
+	binary.BigEndian.PutUint32(pkt[4:], srtSoloPacket|c.msgNo)
+	binary.BigEndian.PutUint32(pkt[8:], c.timestamp())
+	binary.BigEndian.PutUint32(pkt[12:], c.peerID)
+	copy(pkt[srtHeaderSize:], payload)
+
+	if len(c.sent) >= srtSendBuffer {
+		delete(c.sent, (c.seq-srtSendBuffer)&0x7FFFFFFF)
+	}
+
+	c.sent[c.seq] = pkt
+	c.seq = (c.seq + 1) & 0x7FFFFFFF
+	c.msgNo = (c.msgNo + 1) & 0x03FFFFFF
+	c.lastSend = time.Now()
+
+	if _, err := c.conn.Write(pkt); err != nil {
+		return 0, err
+	}
+
+	return len(payload), nil
+}
+
+func (c *srtConn) readLoop() {
+	buf := make([]byte, srtMTU)
+
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		if n < srtHeaderSize || buf[0]&0x80 == 0 {
+			continue
+		}
+
+		c.Lock()
+		c.lastRecv = time.Now()
+		c.Unlock()
+
+		typ := binary.BigEndian.Uint16(buf[0:]) & 0x7FFF
+		info := binary.BigEndian.Uint32(buf[4:])
+		cif := buf[srtHeaderSize:n]
+
+		switch typ {
+		case srtCtrlACK:
+			c.conn.Write(c.control(srtCtrlACKACK, info, nil))
+
+			if len(cif) >= 4 {
+				c.acknowledge(binary.BigEndian.Uint32(cif) & 0x7FFFFFFF)
+			}
+
+		case srtCtrlNAK:
+			c.retransmit(cif)
+
+		case srtCtrlShutdown:
+			c.fail(ErrSRTClosed)
+			return
+		}
+	}
+}
+
+// acknowledge drops every packet before the acknowledged sequence number.
+func (c *srtConn) acknowledge(seq uint32) {
+	c.Lock()
+	defer c.Unlock()
+
+	for s := range c.sent {
+		if (seq-s)&0x7FFFFFFF < 0x40000000 && s != seq {
+			delete(c.sent, s)
+		}
+	}
+}
+
+func (c *srtConn) retransmit(cif []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	resend := func(seq uint32) {
+		pkt, ok := c.sent[seq]
+		if !ok {
+			return
+		}
+
+		flags := binary.BigEndian.Uint32(pkt[4:]) | srtRetransmitFlag
+		binary.BigEndian.PutUint32(pkt[4:], flags)
+
+		c.conn.Write(pkt)
+	}
+
+	for i := 0; i+4 <= len(cif); i += 4 {
+		seq := binary.BigEndian.Uint32(cif[i:])
+		if seq&0x80000000 == 0 {
+			resend(seq)
+			continue
+		}
+
+		if i+8 > len(cif) {
+			return
+		}
+
+		from := seq & 0x7FFFFFFF
+		to := binary.BigEndian.Uint32(cif[i+4:]) & 0x7FFFFFFF
+		for s := from; (to-s)&0x7FFFFFFF < srtSendBuffer; s = (s + 1) & 0x7FFFFFFF {
+			resend(s)
+			if s == to {
+				break
+			}
+		}
+
+		i += 4
+	}
+}
+
+func (c *srtConn) keepaliveLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+
+		case <-ticker.C:
+			c.Lock()
+			idle := time.Since(c.lastSend) >= time.Second
+			expired := time.Since(c.lastRecv) >= 5*time.Second
+			c.Unlock()
+
+			if expired {
+				c.fail(errors.New("srt peer idle timeout"))
+				return
+			}
+
+			if idle {
+				c.conn.Write(c.control(srtCtrlKeepalive, 0, nil))
+			}
+		}
+	}
+}
+
+func (c *srtConn) fail(err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+// Done is closed once the connection fails or is closed.
+func (c *srtConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *srtConn) Err() error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.err
+}
+
+func (c *srtConn) Close() error {
+	c.conn.Write(c.control(srtCtrlShutdown, 0, make([]byte, 4)))
+	c.fail(ErrSRTClosed)
+
+	return nil
+}
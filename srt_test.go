@@ -0,0 +1,107 @@
+package game
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSRTListener answers the caller handshake and collects data payloads.
+func fakeSRTListener(t *testing.T, conn *net.UDPConn, streamIDs chan<- string, payloads chan<- []byte) {
+	buf := make([]byte, srtMTU)
+
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		pkt := buf[:n]
+
+		if pkt[0]&0x80 == 0 {
+			payloads <- append([]byte(nil), pkt[srtHeaderSize:]...)
+			continue
+		}
+
+		if binary.BigEndian.Uint16(pkt)&0x7FFF != srtCtrlHandshake {
+			continue
+		}
+
+		cif := pkt[srtHeaderSize:]
+		resp := make([]byte, srtHeaderSize+48)
+		binary.BigEndian.PutUint32(resp[0:], 0x80000000)
+		copy(resp[srtHeaderSize:], cif[:48])
+
+		switch binary.BigEndian.Uint32(cif[20:]) {
+		case srtHSInduction:
+			binary.BigEndian.PutUint32(resp[srtHeaderSize:], 5)
+			binary.BigEndian.PutUint16(resp[srtHeaderSize+6:], srtMagicCode)
+			binary.BigEndian.PutUint32(resp[srtHeaderSize+28:], 0xC0FFEE)
+
+		case srtHSConclusion:
+			if binary.BigEndian.Uint32(cif[28:]) != 0xC0FFEE {
+				t.Error("invalid cookie")
+			}
+
+			binary.BigEndian.PutUint32(resp[srtHeaderSize+24:], 42)
+
+			// extensions follow the handshake CIF
+			ext := cif[48:]
+			for len(ext) >= 4 {
+				typ := binary.BigEndian.Uint16(ext)
+				size := int(binary.BigEndian.Uint16(ext[2:])) * 4
+				body := ext[4 : 4+size]
+
+				if typ == srtExtSID {
+					var sid []byte
+					for i := 0; i < len(body); i += 4 {
+						sid = append(sid, body[i+3], body[i+2], body[i+1], body[i])
+					}
+
+					streamIDs <- string(sid[:len("live/game")])
+				}
+
+				ext = ext[4+size:]
+			}
+		}
+
+		conn.WriteToUDP(resp, addr)
+	}
+}
+
+func TestSRTConn(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer listener.Close()
+
+	streamIDs := make(chan string, 1)
+	payloads := make(chan []byte, 1)
+
+	go fakeSRTListener(t, listener, streamIDs, payloads)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := dialSRT(ctx, listener.LocalAddr().String(), "live/game", 120*time.Millisecond)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer conn.Close()
+
+	assert.Equal(uint32(42), conn.peerID)
+	assert.Equal("live/game", <-streamIDs)
+
+	_, err = conn.Write([]byte{0x47, 1, 2, 3})
+	assert.NoError(err)
+	assert.Equal([]byte{0x47, 1, 2, 3}, <-payloads)
+}
@@ -0,0 +1,129 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+)
+
+// PeerMode selects the media a peer receives. Peers on poor links can
+// monitor a session with audio only, optionally with periodic stills of
+// the video on the stills data channel. Dashboards receive the low-res
+// preview rendition instead of the video.
+type PeerMode string
+
+const (
+	PeerModeFull      PeerMode = ""
+	PeerModeAudioOnly PeerMode = "audio"
+	PeerModeStills    PeerMode = "stills"
+	PeerModePreview   PeerMode = "preview"
+)
+
+func ParsePeerMode(mode string) (PeerMode, error) {
+	switch mode {
+	case "", "full":
+		return PeerModeFull, nil
+	case "audio":
+		return PeerModeAudioOnly, nil
+	case "stills":
+		return PeerModeStills, nil
+	case "preview":
+		return PeerModePreview, nil
+	default:
+		return "", errors.New("peer mode not supported: " + mode)
+	}
+}
+
+// Video reports whether the peer receives the video track.
+func (mode PeerMode) Video() bool {
+	return mode == PeerModeFull
+}
+
+const (
+	DefaultStillsInterval = 10 * time.Second
+	MinStillsInterval     = time.Second
+
+	// StillsWidth keeps stills small enough for the links that need them.
+	StillsWidth = 640
+)
+
+const ControlStill ControlMessageType = "still"
+
+// Still follows the chunks of a still on the stills data channel.
+type Still struct {
+	Transfer   uint32      `json:"transfer"`
+	Format     ImageFormat `json:"format"`
+	CapturedAt time.Time   `json:"captured_at"`
+}
+
+var errStillsNotRequested = errors.New("stills not requested")
+
+// openStills streams stills to a peer in stills mode until the channel
+// closes.
+func openStills(peer *Peer, dc *webrtc.DataChannel) (MessageHandler, error) {
+	if peer.mode != PeerModeStills || peer.snapshot == nil {
+		return nil, errStillsNotRequested
+	}
+
+	peer.RLock()
+	mc := peer.channels[dc.Label()]
+	peer.RUnlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mc.OnClose(cancel)
+	dc.OnOpen(func() {
+		go peer.sendStills(ctx, mc)
+	})
+
+	return func(webrtc.DataChannelMessage) error {
+		return ErrDiscardedMessage
+	}, nil
+}
+
+func (peer *Peer) sendStills(ctx context.Context, mc *monitoredChannel) {
+	log := peer.log.With(
+		zap.String("handler", "stills"),
+		zap.Duration("interval", peer.stillsInterval),
+	)
+
+	sender := NewChunkSender(mc, ChannelHighWater)
+
+	ticker := time.NewTicker(peer.stillsInterval)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		// Stills are only worth sending when the picture changed and the
+		// link has room for them.
+		if snapshot, err := peer.snapshot(ctx, SnapshotOptions{Format: ImageJPEG, Width: StillsWidth}); err != nil {
+			log.Debug(err.Error())
+		} else if snapshot.CapturedAt.After(last) && !mc.Congested() {
+			transfer, err := sender.Send(ctx, snapshot.Data)
+			if err != nil {
+				log.Warn(err.Error())
+				return
+			}
+
+			last = snapshot.CapturedAt
+
+			msg, err := NewControlMessage(ControlStill, &Still{
+				Transfer:   transfer,
+				Format:     snapshot.Format,
+				CapturedAt: snapshot.CapturedAt,
+			})
+
+			if err == nil {
+				mc.SendJSON(msg)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package game
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// SubscriptionSweepInterval is how often peer subscriptions are swept.
+	SubscriptionSweepInterval = 30 * time.Second
+
+	// PeerConnectTimeout is how long a peer may take to connect before the
+	// sweeper closes it, e.g. when the client left during negotiation.
+	PeerConnectTimeout = time.Minute
+)
+
+// peerSubscriptions tracks the NATS subscriptions of peers, so none
+// outlives its peer.
+type peerSubscriptions struct {
+	log  *zap.Logger
+	subs map[*nats.Subscription]*trackedSubscription
+	sync.Mutex
+}
+
+type trackedSubscription struct {
+	peer    *Peer
+	created time.Time
+}
+
+func newPeerSubscriptions(log *zap.Logger) *peerSubscriptions {
+	return &peerSubscriptions{
+		log:  log,
+		subs: make(map[*nats.Subscription]*trackedSubscription),
+	}
+}
+
+// Subscribe subscribes the peer to subject. The subscription ends when the
+// peer closes.
+func (s *peerSubscriptions) Subscribe(nc *nats.Conn, subject string, peer *Peer, handler nats.MsgHandler) (*nats.Subscription, error) {
+	sub, err := nc.Subscribe(subject, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	s.subs[sub] = &trackedSubscription{peer, time.Now()}
+	s.Unlock()
+
+	return sub, nil
+}
+
+// Active returns how many subscriptions are active.
+func (s *peerSubscriptions) Active() int {
+	s.Lock()
+	defer s.Unlock()
+
+	var n int
+	for sub := range s.subs {
+		if sub.IsValid() {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Sweep forgets ended subscriptions, ends those of closed peers and closes
+// peers that did not connect within timeout.
+func (s *peerSubscriptions) Sweep(now time.Time, timeout time.Duration) {
+	s.Lock()
+
+	var stale []*Peer
+	for sub, tracked := range s.subs {
+		if !sub.IsValid() {
+			delete(s.subs, sub)
+			continue
+		}
+
+		switch tracked.peer.ConnectionState() {
+		case webrtc.PeerConnectionStateClosed:
+			s.log.Warn("subscription outlived its peer",
+				zap.String("peer", tracked.peer.ID()),
+				zap.String("subject", sub.Subject))
+
+			sub.Unsubscribe()
+			delete(s.subs, sub)
+
+		case webrtc.PeerConnectionStateNew,
+			webrtc.PeerConnectionStateConnecting:
+			if now.Sub(tracked.created) > timeout {
+				stale = append(stale, tracked.peer)
+			}
+		}
+	}
+
+	s.Unlock()
+
+	for _, peer := range stale {
+		peer.log.Warn("peer did not connect, closing",
+			zap.Duration("timeout", timeout))
+
+		peer.Close()
+	}
+}
+
+// Run sweeps every interval until ctx is done, then ends all
+// subscriptions.
+func (s *peerSubscriptions) Run(ctx context.Context, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Lock()
+			for sub := range s.subs {
+				sub.Unsubscribe()
+				delete(s.subs, sub)
+			}
+			s.Unlock()
+
+			return
+
+		case now := <-ticker.C:
+			s.Sweep(now, timeout)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestPeerSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	nc, err := nats.Connect(runNATSServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	subs := newPeerSubscriptions(zap.NewNop())
+
+	newPeer := func(id string) *Peer {
+		conn, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		peer := &Peer{PeerConnection: conn, id: id, log: zap.NewNop()}
+
+		sub, err := subs.Subscribe(nc, "peers.negotiation."+id+".candidates.caller", peer, func(*nats.Msg) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		peer.sub = sub
+		return peer
+	}
+
+	leaked := newPeer("leaked")
+	pending := newPeer("pending")
+	closed := newPeer("closed")
+
+	assert.Equal(3, subs.Active())
+
+	closed.Close()
+	assert.Equal(2, subs.Active())
+
+	// The connection closed without the peer.
+	leaked.PeerConnection.Close()
+
+	now := time.Now()
+	subs.Sweep(now, PeerConnectTimeout)
+
+	assert.False(leaked.sub.IsValid())
+	assert.True(pending.sub.IsValid())
+	assert.Equal(1, subs.Active())
+	assert.Len(subs.subs, 1)
+
+	// The pending peer did not connect in time.
+	subs.Sweep(now.Add(2*PeerConnectTimeout), PeerConnectTimeout)
+
+	assert.Equal(webrtc.PeerConnectionStateClosed, pending.ConnectionState())
+	assert.Equal(0, subs.Active())
+
+	// Stopping ends whatever is left.
+	other := newPeer("other")
+	defer other.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		subs.Run(ctx, time.Hour, PeerConnectTimeout)
+		close(done)
+	}()
+
+	cancel()
+	<-done
+
+	assert.False(other.sub.IsValid())
+	assert.Empty(subs.subs)
+}
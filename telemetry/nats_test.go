@@ -0,0 +1,41 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHeaderPropagation(t *testing.T) {
+	assert := assert.New(t)
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	header := nats.Header{}
+	Inject(trace.ContextWithSpanContext(context.Background(), sc), header)
+
+	assert.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", header.Get("traceparent"))
+
+	ctx := Extract(context.Background(), header)
+	extracted := trace.SpanContextFromContext(ctx)
+
+	assert.Equal(sc.TraceID(), extracted.TraceID())
+	assert.Equal(sc.SpanID(), extracted.SpanID())
+	assert.True(extracted.IsRemote())
+
+	// without a trace context, none is extracted
+	ctx = Extract(context.Background(), nats.Header{})
+	assert.False(trace.SpanContextFromContext(ctx).IsValid())
+}
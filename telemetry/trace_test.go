@@ -0,0 +1,100 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSetup(t *testing.T) {
+	assert := assert.New(t)
+
+	requests := make(chan *coltracepb.ExportTraceServiceRequest, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/v1/traces", r.URL.Path)
+		assert.Equal("secret", r.Header.Get("Authorization"))
+
+		bs, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		req := new(coltracepb.ExportTraceServiceRequest)
+		if err := proto.Unmarshal(bs, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		requests <- req
+	}))
+	defer srv.Close()
+
+	shutdown, err := Setup(srv.URL, "game", map[string]string{"Authorization": "secret"}, time.Hour)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	ctx, parent := Start(context.Background(), "peers.accept", attribute.String("stream", "default"))
+	_, child := Start(ctx, "webrtc.ice_gathering")
+
+	RecordError(child, errors.New("gathering failed"))
+	RecordError(parent, nil)
+	child.End()
+	parent.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var req *coltracepb.ExportTraceServiceRequest
+	select {
+	case req = <-requests:
+	default:
+		assert.Fail("spans not exported")
+		return
+	}
+
+	if !assert.Len(req.ResourceSpans, 1) || !assert.Len(req.ResourceSpans[0].ScopeSpans, 1) {
+		return
+	}
+
+	rs := req.ResourceSpans[0]
+	assert.Equal("service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal("game", rs.Resource.Attributes[0].Value.GetStringValue())
+
+	spans := rs.ScopeSpans[0].Spans
+	if !assert.Len(spans, 2) {
+		return
+	}
+
+	c, p := spans[0], spans[1]
+	assert.Equal("webrtc.ice_gathering", c.Name)
+	assert.Equal("peers.accept", p.Name)
+	assert.Equal(p.TraceId, c.TraceId)
+	assert.Equal(p.SpanId, c.ParentSpanId)
+	assert.Empty(p.ParentSpanId)
+
+	assert.Equal(tracepb.Status_STATUS_CODE_ERROR, c.Status.GetCode())
+	assert.Equal("gathering failed", c.Status.GetMessage())
+
+	assert.Equal(tracepb.Status_STATUS_CODE_UNSET, p.Status.GetCode())
+	assert.Equal("default", p.Attributes[0].Value.GetStringValue())
+
+	// spans after shutdown are no-ops
+	_, span := Start(context.Background(), "noop")
+	assert.False(span.IsRecording())
+}
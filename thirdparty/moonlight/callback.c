@@ -1,12 +1,42 @@
+//go:build cgo && !purego
+
 #include <stdarg.h>
 #include <stddef.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include "callback.h"
 
-extern void goClLogMessage(const char* message);
+extern void goClLogMessage(uintptr_t handle, const char* message);
+
+// moonlight-common-c runs one connection at a time; its callbacks go to
+// the handle of that connection.
+static volatile uintptr_t activeHandle;
+
+void setActiveHandle(uintptr_t handle) {
+    activeHandle = handle;
+}
+
+static void clStageStarting(int stage) {
+    goClStageStarting(activeHandle, stage);
+}
+
+static void clStageComplete(int stage) {
+    goClStageComplete(activeHandle, stage);
+}
+
+static void clStageFailed(int stage, int errorCode) {
+    goClStageFailed(activeHandle, stage, errorCode);
+}
+
+static void clConnectionStarted(void) {
+    goClConnectionStarted(activeHandle);
+}
 
-void clLogMessage(const char* format, ...) {
+static void clConnectionTerminated(int errorCode) {
+    goClConnectionTerminated(activeHandle, errorCode);
+}
+
+static void clLogMessage(const char* format, ...) {
     char buffer[2048];
     va_list args;
 
@@ -14,7 +44,71 @@ void clLogMessage(const char* format, ...) {
     vsnprintf(buffer, sizeof(buffer), format, args);
     va_end(args);
 
-    goClLogMessage(buffer);
+    goClLogMessage(activeHandle, buffer);
+}
+
+static void clRumble(unsigned short controllerNumber, unsigned short lowFreqMotor, unsigned short highFreqMotor) {
+    goClRumble(activeHandle, controllerNumber, lowFreqMotor, highFreqMotor);
+}
+
+static void clConnectionStatusUpdate(int connectionStatus) {
+    goClConnectionStatusUpdate(activeHandle, connectionStatus);
+}
+
+static void clSetHDRMode(bool hdrEnabled) {
+    goClSetHDRMode(activeHandle, hdrEnabled);
+}
+
+static void clRumbleTriggers(uint16_t controllerNumber, uint16_t leftTriggerMotor, uint16_t rightTriggerMotor) {
+    goClRumbleTriggers(activeHandle, controllerNumber, leftTriggerMotor, rightTriggerMotor);
+}
+
+static void clSetMotionEventState(uint16_t controllerNumber, uint8_t motionType, uint16_t reportRateHz) {
+    goClSetMotionEventState(activeHandle, controllerNumber, motionType, reportRateHz);
+}
+
+static void clSetControllerLED(uint16_t controllerNumber, uint8_t r, uint8_t g, uint8_t b) {
+    goClSetControllerLED(activeHandle, controllerNumber, r, g, b);
+}
+
+static int drSetup(int videoFormat, int width, int height, int redrawRate, void* context, int drFlags) {
+    return goDrSetup(activeHandle, videoFormat, width, height, redrawRate, context, drFlags);
+}
+
+static void drStart(void) {
+    goDrStart(activeHandle);
+}
+
+static void drStop(void) {
+    goDrStop(activeHandle);
+}
+
+static void drCleanup(void) {
+    goDrCleanup(activeHandle);
+}
+
+static int drSubmitDecodeUnit(PDECODE_UNIT decodeUnit) {
+    return goDrSubmitDecodeUnit(activeHandle, decodeUnit);
+}
+
+static int arInit(int audioConfiguration, POPUS_MULTISTREAM_CONFIGURATION opusConfig, void* context, int arFlags) {
+    return goArInit(activeHandle, audioConfiguration, opusConfig, context, arFlags);
+}
+
+static void arStart(void) {
+    goArStart(activeHandle);
+}
+
+static void arStop(void) {
+    goArStop(activeHandle);
+}
+
+static void arCleanup(void) {
+    goArCleanup(activeHandle);
+}
+
+static void arDecodeAndPlaySample(char* sampleData, int sampleLength) {
+    goArDecodeAndPlaySample(activeHandle, sampleData, sampleLength);
 }
 
 void setupCallbacks(
@@ -26,38 +120,38 @@ void setupCallbacks(
         return;
     }
 
-    clCallbacks->stageStarting = goClStageStarting;
-    clCallbacks->stageComplete = goClStageComplete;
-    clCallbacks->stageFailed = goClStageFailed;
-    clCallbacks->connectionStarted = goClConnectionStarted;
-    clCallbacks->connectionTerminated = goClConnectionTerminated;
+    clCallbacks->stageStarting = clStageStarting;
+    clCallbacks->stageComplete = clStageComplete;
+    clCallbacks->stageFailed = clStageFailed;
+    clCallbacks->connectionStarted = clConnectionStarted;
+    clCallbacks->connectionTerminated = clConnectionTerminated;
     clCallbacks->logMessage = clLogMessage;
-    clCallbacks->rumble = goClRumble;
-    clCallbacks->connectionStatusUpdate = goClConnectionStatusUpdate;
-    clCallbacks->setHdrMode = goClSetHDRMode;
-    clCallbacks->rumbleTriggers = goClRumbleTriggers;
-    clCallbacks->setMotionEventState = goClSetMotionEventState;
-    clCallbacks->setControllerLED = goClSetControllerLED;
+    clCallbacks->rumble = clRumble;
+    clCallbacks->connectionStatusUpdate = clConnectionStatusUpdate;
+    clCallbacks->setHdrMode = clSetHDRMode;
+    clCallbacks->rumbleTriggers = clRumbleTriggers;
+    clCallbacks->setMotionEventState = clSetMotionEventState;
+    clCallbacks->setControllerLED = clSetControllerLED;
 
     if (drCallbacks == NULL) {
         return;
     }
 
-    drCallbacks->setup = goDrSetup;
-    drCallbacks->start = goDrStart;
-    drCallbacks->stop = goDrStop;
-    drCallbacks->cleanup = goDrCleanup;
-    drCallbacks->submitDecodeUnit = goDrSubmitDecodeUnit;
+    drCallbacks->setup = drSetup;
+    drCallbacks->start = drStart;
+    drCallbacks->stop = drStop;
+    drCallbacks->cleanup = drCleanup;
+    drCallbacks->submitDecodeUnit = drSubmitDecodeUnit;
     drCallbacks->capabilities = 0;
 
     if (arCallbacks == NULL) {
         return;
     }
 
-    arCallbacks->init = goArInit;
-    arCallbacks->start = goArStart;
-    arCallbacks->stop = goArStop;
-    arCallbacks->cleanup = goArCleanup;
-    arCallbacks->decodeAndPlaySample = goArDecodeAndPlaySample;
+    arCallbacks->init = arInit;
+    arCallbacks->start = arStart;
+    arCallbacks->stop = arStop;
+    arCallbacks->cleanup = arCleanup;
+    arCallbacks->decodeAndPlaySample = arDecodeAndPlaySample;
     arCallbacks->capabilities = 0;
 }
@@ -1,166 +1,213 @@
+//go:build cgo && !purego
+
 package moonlight
 
 /*
-#cgo CFLAGS:  -I../moonlight-common-c/src -I. -Wno-dll-attribute-on-redeclaration
-#cgo LDFLAGS: -L../moonlight-common-c/build -lmoonlight-common-c -Wl,--allow-multiple-definition
 #include <stdlib.h>
 #include <Limelight.h>
+#ifdef _WIN32
 #include <Windows.h>
+#endif
 #include "callback.h"
 */
 import "C"
 import (
+	"runtime/cgo"
 	"unsafe"
 )
 
-var (
-	connectionListener ConnectionListener
-	videoRenderer      VideoDecoderRenderer
-	audioRenderer      AudioRenderer
-)
+// Callbacks route the callbacks of a connection to its listener and
+// renderers. moonlight-common-c passes no context to its callbacks, so
+// they carry the handle of the connection running.
+type Callbacks struct {
+	listener ConnectionListener
+	video    VideoDecoderRenderer
+	audio    AudioRenderer
+	stages   *stageTimer
 
-var (
+	handle      cgo.Handle
 	clCallbacks *C.CONNECTION_LISTENER_CALLBACKS
 	drCallbacks *C.DECODER_RENDERER_CALLBACKS
 	arCallbacks *C.AUDIO_RENDERER_CALLBACKS
-)
+	closed      bool // under the lock of active
+}
+
+// SetupCallbacks registers the callbacks of a connection, which Close
+// releases.
+func SetupCallbacks(cl ConnectionListener, vr VideoDecoderRenderer, ar AudioRenderer) *Callbacks {
+	stages := &stageTimer{ConnectionListener: cl}
+
+	cb := &Callbacks{
+		listener: stages,
+		video:    vr,
+		audio:    ar,
+		stages:   stages,
+	}
 
-func SetupCallbacks(cl ConnectionListener, vr VideoDecoderRenderer, ar AudioRenderer) {
-	connectionListener = cl
-	videoRenderer = vr
-	audioRenderer = ar
+	cb.handle = cgo.NewHandle(cb)
 
-	clCallbacks = (*C.CONNECTION_LISTENER_CALLBACKS)(
+	cb.clCallbacks = (*C.CONNECTION_LISTENER_CALLBACKS)(
 		C.malloc(C.size_t(unsafe.Sizeof(C.CONNECTION_LISTENER_CALLBACKS{}))),
 	)
-	C.LiInitializeConnectionCallbacks(clCallbacks)
+	C.LiInitializeConnectionCallbacks(cb.clCallbacks)
 
-	drCallbacks = (*C.DECODER_RENDERER_CALLBACKS)(
+	cb.drCallbacks = (*C.DECODER_RENDERER_CALLBACKS)(
 		C.malloc(C.size_t(unsafe.Sizeof(C.DECODER_RENDERER_CALLBACKS{}))),
 	)
-	C.LiInitializeVideoCallbacks(drCallbacks)
+	C.LiInitializeVideoCallbacks(cb.drCallbacks)
 
-	arCallbacks = (*C.AUDIO_RENDERER_CALLBACKS)(
+	cb.arCallbacks = (*C.AUDIO_RENDERER_CALLBACKS)(
 		C.malloc(C.size_t(unsafe.Sizeof(C.AUDIO_RENDERER_CALLBACKS{}))),
 	)
-	C.LiInitializeAudioCallbacks(arCallbacks)
+	C.LiInitializeAudioCallbacks(cb.arCallbacks)
+
+	C.setupCallbacks(cb.clCallbacks, cb.drCallbacks, cb.arCallbacks)
+
+	return cb
+}
+
+// Close stops the connection of the callbacks if it runs and releases
+// them.
+func (cb *Callbacks) Close() {
+	StopConnection(cb)
+
+	active.Lock()
+	defer active.Unlock()
 
-	C.setupCallbacks(clCallbacks, drCallbacks, arCallbacks)
+	if cb.closed {
+		return
+	}
+
+	cb.closed = true
+	cb.handle.Delete()
+
+	C.free(unsafe.Pointer(cb.clCallbacks))
+	C.free(unsafe.Pointer(cb.drCallbacks))
+	C.free(unsafe.Pointer(cb.arCallbacks))
 }
 
-type ConnectionListener interface {
-	StageStarting(stage int)
-	StageComplete(stage int)
-	StageFailed(stage int, errorCode int)
-	ConnectionStarted()
-	ConnectionTerminated(errorCode int)
-	LogMessage(format string, args ...any)
-	Rumble(controllerNumber, lowFreqMotor, highFreqMotor uint16)
-	ConnectionStatusUpdate(connectionStatus int)
-	SetHDRMode(hdrEnabled bool)
-	RumbleTriggers(controllerNumber, leftTriggerMotor, rightTriggerMotor uint16)
-	SetMotionEventState(controllerNumber uint16, motionType uint8, reportRateHz uint16)
-	SetControllerLED(controllerNumber uint16, r, g, b uint8)
+// callbacks returns the callbacks of a handle, nil for none. A handle is
+// only passed while its connection runs, so it is still registered.
+func callbacks(handle C.uintptr_t) *Callbacks {
+	if handle == 0 {
+		return nil
+	}
+
+	return cgo.Handle(handle).Value().(*Callbacks)
 }
 
 //export goClStageStarting
-func goClStageStarting(stage C.int) {
-	connectionListener.StageStarting(int(stage))
+func goClStageStarting(handle C.uintptr_t, stage C.int) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.StageStarting(int(stage))
+	}
 }
 
 //export goClStageComplete
-func goClStageComplete(stage C.int) {
-	connectionListener.StageComplete(int(stage))
+func goClStageComplete(handle C.uintptr_t, stage C.int) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.StageComplete(int(stage))
+	}
 }
 
 //export goClStageFailed
-func goClStageFailed(stage C.int, errorCode C.int) {
-	connectionListener.StageFailed(int(stage), int(errorCode))
+func goClStageFailed(handle C.uintptr_t, stage C.int, errorCode C.int) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.StageFailed(int(stage), int(errorCode))
+	}
 }
 
 //export goClConnectionStarted
-func goClConnectionStarted() {
-	connectionListener.ConnectionStarted()
+func goClConnectionStarted(handle C.uintptr_t) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.ConnectionStarted()
+	}
 }
 
 //export goClConnectionTerminated
-func goClConnectionTerminated(errorCode C.int) {
-	connectionListener.ConnectionTerminated(int(errorCode))
+func goClConnectionTerminated(handle C.uintptr_t, errorCode C.int) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.ConnectionTerminated(int(errorCode))
+	}
 }
 
 //export goClLogMessage
-func goClLogMessage(message *C.char) {
-	goMessage := C.GoString(message)
-	connectionListener.LogMessage("%s", goMessage)
+func goClLogMessage(handle C.uintptr_t, message *C.char) {
+	if cb := callbacks(handle); cb != nil {
+		goMessage := C.GoString(message)
+		cb.listener.LogMessage("%s", goMessage)
+	}
 }
 
 //export goClRumble
-func goClRumble(controllerNumber C.uint16_t, lowFreqMotor C.uint16_t, highFreqMotor C.uint16_t) {
-	connectionListener.Rumble(uint16(controllerNumber), uint16(lowFreqMotor), uint16(highFreqMotor))
+func goClRumble(handle C.uintptr_t, controllerNumber C.uint16_t, lowFreqMotor C.uint16_t, highFreqMotor C.uint16_t) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.Rumble(uint16(controllerNumber), uint16(lowFreqMotor), uint16(highFreqMotor))
+	}
 }
 
 //export goClConnectionStatusUpdate
-func goClConnectionStatusUpdate(connectionStatus C.int) {
-	connectionListener.ConnectionStatusUpdate(int(connectionStatus))
+func goClConnectionStatusUpdate(handle C.uintptr_t, connectionStatus C.int) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.ConnectionStatusUpdate(int(connectionStatus))
+	}
 }
 
 //export goClSetHDRMode
-func goClSetHDRMode(hdrEnabled C.bool) {
-	// TODO: if enabled && C.LiGetHdrMetadata()
-	connectionListener.SetHDRMode(bool(hdrEnabled))
+func goClSetHDRMode(handle C.uintptr_t, hdrEnabled C.bool) {
+	if cb := callbacks(handle); cb != nil {
+		var metadata *HDRMetadata
+
+		var m C.SS_HDR_METADATA
+		if hdrEnabled && C.LiGetHdrMetadata(&m) {
+			metadata = &HDRMetadata{
+				WhitePoint:                Chromaticity{uint16(m.whitePoint.x), uint16(m.whitePoint.y)},
+				MaxDisplayLuminance:       uint16(m.maxDisplayLuminance),
+				MinDisplayLuminance:       uint16(m.minDisplayLuminance),
+				MaxContentLightLevel:      uint16(m.maxContentLightLevel),
+				MaxFrameAverageLightLevel: uint16(m.maxFrameAverageLightLevel),
+				MaxFullFrameLuminance:     uint16(m.maxFullFrameLuminance),
+			}
+
+			for i := range metadata.DisplayPrimaries {
+				primary := m.displayPrimaries[i]
+				metadata.DisplayPrimaries[i] = Chromaticity{uint16(primary.x), uint16(primary.y)}
+			}
+		}
+
+		cb.listener.SetHDRMode(bool(hdrEnabled), metadata)
+	}
 }
 
 //export goClRumbleTriggers
-func goClRumbleTriggers(controllerNumber C.uint16_t, leftTriggerMotor C.uint16_t, rightTriggerMotor C.uint16_t) {
-	connectionListener.RumbleTriggers(uint16(controllerNumber), uint16(leftTriggerMotor), uint16(rightTriggerMotor))
+func goClRumbleTriggers(handle C.uintptr_t, controllerNumber C.uint16_t, leftTriggerMotor C.uint16_t, rightTriggerMotor C.uint16_t) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.RumbleTriggers(uint16(controllerNumber), uint16(leftTriggerMotor), uint16(rightTriggerMotor))
+	}
 }
 
 //export goClSetMotionEventState
-func goClSetMotionEventState(controllerNumber C.uint16_t, motionType C.uint8_t, reportRateHz C.uint16_t) {
-	connectionListener.SetMotionEventState(uint16(controllerNumber), uint8(motionType), uint16(reportRateHz))
+func goClSetMotionEventState(handle C.uintptr_t, controllerNumber C.uint16_t, motionType C.uint8_t, reportRateHz C.uint16_t) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.SetMotionEventState(uint16(controllerNumber), uint8(motionType), uint16(reportRateHz))
+	}
 }
 
 //export goClSetControllerLED
-func goClSetControllerLED(controllerNumber C.uint16_t, r C.uint8_t, g C.uint8_t, b C.uint8_t) {
-	connectionListener.SetControllerLED(uint16(controllerNumber), uint8(r), uint8(g), uint8(b))
-}
-
-type VideoDecoderRenderer interface {
-	Setup(format, width, height, redrawRate int, context unsafe.Pointer, drFlags int) int
-	Start()
-	Stop()
-	Cleanup()
-	SubmitDecodeUnit(decodeUnit *DecodeUnit) int
-	Capabilities() int
-}
-
-type DecodeUnit struct {
-	FrameNumber                int
-	FrameType                  int
-	FrameHostProcessingLatency uint16
-	ReceiveTimeMs              uint64
-	EnqueueTimeMs              uint64
-	PresentationTimeMs         uint
-	FullLength                 int
-	BufferList                 *Lentry
-	HDRActive                  bool
-	ColorSpace                 uint8
-}
-
-type Lentry struct {
-	Next       *Lentry
-	Data       []byte
-	Length     int
-	BufferType int
+func goClSetControllerLED(handle C.uintptr_t, controllerNumber C.uint16_t, r C.uint8_t, g C.uint8_t, b C.uint8_t) {
+	if cb := callbacks(handle); cb != nil {
+		cb.listener.SetControllerLED(uint16(controllerNumber), uint8(r), uint8(g), uint8(b))
+	}
 }
 
 //export goDrSetup
-func goDrSetup(videoFormat, width, height, redrawRate C.int, context unsafe.Pointer, drFlags C.int) C.int {
-	if videoRenderer == nil {
+func goDrSetup(handle C.uintptr_t, videoFormat, width, height, redrawRate C.int, context unsafe.Pointer, drFlags C.int) C.int {
+	cb := callbacks(handle)
+	if cb == nil || cb.video == nil {
 		return 0
 	}
 
-	rc := videoRenderer.Setup(
+	rc := cb.video.Setup(
 		int(videoFormat),
 		int(width), int(height), int(redrawRate),
 		context, int(drFlags),
@@ -174,37 +221,35 @@ func goDrSetup(videoFormat, width, height, redrawRate C.int, context unsafe.Poin
 }
 
 //export goDrStart
-func goDrStart() {
-	if videoRenderer != nil {
-		videoRenderer.Start()
+func goDrStart(handle C.uintptr_t) {
+	if cb := callbacks(handle); cb != nil && cb.video != nil {
+		cb.video.Start()
 	}
 }
 
 //export goDrStop
-func goDrStop() {
-	if videoRenderer != nil {
-		videoRenderer.Stop()
+func goDrStop(handle C.uintptr_t) {
+	if cb := callbacks(handle); cb != nil && cb.video != nil {
+		cb.video.Stop()
 	}
 }
 
 //export goDrCleanup
-func goDrCleanup() {
-	if videoRenderer != nil {
-		videoRenderer.Cleanup()
-		videoRenderer = nil
+func goDrCleanup(handle C.uintptr_t) {
+	// The renderer is set up again when its connection restarts.
+	if cb := callbacks(handle); cb != nil && cb.video != nil {
+		cb.video.Cleanup()
 	}
 }
 
 //export goDrSubmitDecodeUnit
-func goDrSubmitDecodeUnit(unit *C.DECODE_UNIT) C.int {
-	if videoRenderer == nil || unit == nil {
+func goDrSubmitDecodeUnit(handle C.uintptr_t, unit *C.DECODE_UNIT) C.int {
+	cb := callbacks(handle)
+	if cb == nil || cb.video == nil || unit == nil {
 		return C.DR_OK
 	}
 
-	var bufferList *Lentry
-	if unit.bufferList != nil {
-		bufferList = convertCLentryToGo(unit.bufferList)
-	}
+	bufferList := convertCLentryToGo(unit.bufferList)
 
 	decodeUnit := &DecodeUnit{
 		FrameNumber:                int(unit.frameNumber),
@@ -219,56 +264,39 @@ func goDrSubmitDecodeUnit(unit *C.DECODE_UNIT) C.int {
 		ColorSpace:                 uint8(unit.colorspace),
 	}
 
-	result := videoRenderer.SubmitDecodeUnit(decodeUnit)
+	result := cb.video.SubmitDecodeUnit(decodeUnit)
 
 	return C.int(result)
 }
 
+// convertCLentryToGo converts the buffer list without copying: the
+// entries' Data alias moonlight's buffers, which it frees once the decode
+// unit is submitted.
 func convertCLentryToGo(cEntry *C.LENTRY) *Lentry {
-	if cEntry == nil {
-		return nil
+	var head *Lentry
 
-	}
-
-	goEntry := &Lentry{
-		Length:     int(cEntry.length),
-		BufferType: int(cEntry.bufferType),
-	}
+	next := &head
+	for ; cEntry != nil; cEntry = cEntry.next {
+		goEntry := &Lentry{
+			Length:     int(cEntry.length),
+			BufferType: int(cEntry.bufferType),
+		}
 
-	if cEntry.data != nil && cEntry.length > 0 {
-		goEntry.Data = C.GoBytes(unsafe.Pointer(cEntry.data), cEntry.length)
-	}
+		if cEntry.data != nil && cEntry.length > 0 {
+			goEntry.Data = unsafe.Slice((*byte)(unsafe.Pointer(cEntry.data)), cEntry.length)
+		}
 
-	if cEntry.next != nil {
-		goEntry.Next = convertCLentryToGo(cEntry.next)
+		*next = goEntry
+		next = &goEntry.Next
 	}
 
-	return goEntry
-}
-
-type AudioRenderer interface {
-	Init(audioConfiguration AudioConfiguration, opusConfig *OpusMultiStreamConfiguration, context unsafe.Pointer, arFlags int) int
-	Start()
-	Stop()
-	Cleanup()
-	PlayEncodedSample(sampleData []byte, sampleLength int)
-	Capabilities() int
-}
-
-const AUDIO_CONFIGURATION_MAX_CHANNEL_COUNT int = 8
-
-type OpusMultiStreamConfiguration struct {
-	SampleRate      int
-	ChannelCount    int
-	Streams         int
-	CoupledStreams  int
-	SamplesPerFrame int
-	Mapping         [AUDIO_CONFIGURATION_MAX_CHANNEL_COUNT]byte
+	return head
 }
 
 //export goArInit
-func goArInit(audioConfiguration C.int, cfg *C.OPUS_MULTISTREAM_CONFIGURATION, context unsafe.Pointer, arFlags C.int) C.int {
-	if audioRenderer == nil {
+func goArInit(handle C.uintptr_t, audioConfiguration C.int, cfg *C.OPUS_MULTISTREAM_CONFIGURATION, context unsafe.Pointer, arFlags C.int) C.int {
+	cb := callbacks(handle)
+	if cb == nil || cb.audio == nil {
 		return 0
 	}
 
@@ -291,39 +319,39 @@ func goArInit(audioConfiguration C.int, cfg *C.OPUS_MULTISTREAM_CONFIGURATION, c
 		Mapping:         mapping,
 	}
 
-	rc := audioRenderer.Init(audioConfig, opusConfig, context, int(arFlags))
+	rc := cb.audio.Init(audioConfig, opusConfig, context, int(arFlags))
 
 	return C.int(rc)
 }
 
 //export goArStart
-func goArStart() {
-	if audioRenderer != nil {
-		audioRenderer.Start()
+func goArStart(handle C.uintptr_t) {
+	if cb := callbacks(handle); cb != nil && cb.audio != nil {
+		cb.audio.Start()
 	}
 }
 
 //export goArStop
-func goArStop() {
-	if audioRenderer != nil {
-		audioRenderer.Stop()
+func goArStop(handle C.uintptr_t) {
+	if cb := callbacks(handle); cb != nil && cb.audio != nil {
+		cb.audio.Stop()
 	}
 }
 
 //export goArCleanup
-func goArCleanup() {
-	if audioRenderer != nil {
-		audioRenderer.Cleanup()
-		audioRenderer = nil
+func goArCleanup(handle C.uintptr_t) {
+	if cb := callbacks(handle); cb != nil && cb.audio != nil {
+		cb.audio.Cleanup()
 	}
 }
 
 //export goArDecodeAndPlaySample
-func goArDecodeAndPlaySample(sampleData *C.char, sampleLength C.int) {
-	if audioRenderer == nil {
+func goArDecodeAndPlaySample(handle C.uintptr_t, sampleData *C.char, sampleLength C.int) {
+	cb := callbacks(handle)
+	if cb == nil || cb.audio == nil {
 		return
 	}
 
 	sampleBytes := C.GoBytes(unsafe.Pointer(sampleData), sampleLength)
-	audioRenderer.PlayEncodedSample(sampleBytes, int(sampleLength))
+	cb.audio.PlayEncodedSample(sampleBytes, int(sampleLength))
 }
@@ -1,40 +1,46 @@
 #ifndef CALLBACK_H
 #define CALLBACK_H
 
+#include <stdint.h>
 #include <Limelight.h>
 
 #ifdef __cplusplus
 extern "C" {
 #endif
 
+// Every callback gets the handle of the connection it belongs to, as
+// moonlight-common-c passes none.
+
 // Connection Listener Callbacks
-extern void goClStageStarting(int stage);
-extern void goClStageComplete(int stage);
-extern void goClStageFailed(int stage, int errorCode);
-extern void goClConnectionStarted(void);
-extern void goClConnectionTerminated(int errorCode);
-// extern void goClLogMessage(const char* format, ...);
-extern void clLogMessage(const char* format, ...);
-extern void goClRumble(unsigned short controllerNumber, unsigned short lowFreqMotor, unsigned short highFreqMotor);
-extern void goClConnectionStatusUpdate(int connectionStatus);
-extern void goClSetHDRMode(bool hdrEnabled);
-extern void goClRumbleTriggers(uint16_t controllerNumber, uint16_t leftTriggerMotor, uint16_t rightTriggerMotor);
-extern void goClSetMotionEventState(uint16_t controllerNumber, uint8_t motionType, uint16_t reportRateHz);
-extern void goClSetControllerLED(uint16_t controllerNumber, uint8_t r, uint8_t g, uint8_t b);
+extern void goClStageStarting(uintptr_t handle, int stage);
+extern void goClStageComplete(uintptr_t handle, int stage);
+extern void goClStageFailed(uintptr_t handle, int stage, int errorCode);
+extern void goClConnectionStarted(uintptr_t handle);
+extern void goClConnectionTerminated(uintptr_t handle, int errorCode);
+extern void goClRumble(uintptr_t handle, unsigned short controllerNumber, unsigned short lowFreqMotor, unsigned short highFreqMotor);
+extern void goClConnectionStatusUpdate(uintptr_t handle, int connectionStatus);
+extern void goClSetHDRMode(uintptr_t handle, bool hdrEnabled);
+extern void goClRumbleTriggers(uintptr_t handle, uint16_t controllerNumber, uint16_t leftTriggerMotor, uint16_t rightTriggerMotor);
+extern void goClSetMotionEventState(uintptr_t handle, uint16_t controllerNumber, uint8_t motionType, uint16_t reportRateHz);
+extern void goClSetControllerLED(uintptr_t handle, uint16_t controllerNumber, uint8_t r, uint8_t g, uint8_t b);
 
 // Video Decoder Callbacks
-extern int goDrSetup(int videoFormat, int width, int height, int redrawRate, void* context, int drFlags);
-extern void goDrStart(void);
-extern void goDrStop(void);
-extern void goDrCleanup(void);
-extern int goDrSubmitDecodeUnit(PDECODE_UNIT decodeUnit);
+extern int goDrSetup(uintptr_t handle, int videoFormat, int width, int height, int redrawRate, void* context, int drFlags);
+extern void goDrStart(uintptr_t handle);
+extern void goDrStop(uintptr_t handle);
+extern void goDrCleanup(uintptr_t handle);
+extern int goDrSubmitDecodeUnit(uintptr_t handle, PDECODE_UNIT decodeUnit);
 
 // Audio Renderer Callbacks
-extern int goArInit(int audioConfiguration, POPUS_MULTISTREAM_CONFIGURATION opusConfig, void* context, int arFlags);
-extern void goArStart(void);
-extern void goArStop(void);
-extern void goArCleanup(void);
-extern void goArDecodeAndPlaySample(char* sampleData, int sampleLength);
+extern int goArInit(uintptr_t handle, int audioConfiguration, POPUS_MULTISTREAM_CONFIGURATION opusConfig, void* context, int arFlags);
+extern void goArStart(uintptr_t handle);
+extern void goArStop(uintptr_t handle);
+extern void goArCleanup(uintptr_t handle);
+extern void goArDecodeAndPlaySample(uintptr_t handle, char* sampleData, int sampleLength);
+
+// Sets the handle passed to the callbacks of the running connection,
+// 0 while none runs.
+void setActiveHandle(uintptr_t handle);
 
 // Helper function to setup callbacks
 void setupCallbacks(
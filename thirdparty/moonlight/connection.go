@@ -1,16 +1,52 @@
+//go:build cgo && !purego
+
 package moonlight
 
 /*
-#cgo CFLAGS:  -I${SRCDIR}/../moonlight-common-c/src
-#cgo LDFLAGS: -L${SRCDIR}/../moonlight-common-c/build -lmoonlight-common-c -Wl,--allow-multiple-definition
 #include <stdlib.h>
 #include <Limelight.h>
+#ifdef _WIN32
 #include <Windows.h>
+#endif
+#include "callback.h"
 */
 import "C"
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// active is the connection running, whose callbacks are called.
+var active struct {
+	callbacks *Callbacks
+	sync.Mutex
+}
+
+// StartConnection starts a connection calling cb back. Stop it with
+// StopConnection before starting another.
+func StartConnection(cb *Callbacks, serverInfo ServerInformation, streamConfig StreamConfiguration) error {
+	active.Lock()
+
+	if cb.closed {
+		active.Unlock()
+		return errors.New("callbacks closed")
+	}
+
+	if active.callbacks != nil && active.callbacks != cb {
+		active.Unlock()
+		return ErrConnectionBusy
+	}
+
+	cb.stages.reset()
+
+	active.callbacks = cb
+	C.setActiveHandle(C.uintptr_t(cb.handle))
+
+	active.Unlock()
 
-func StartConnection(serverInfo ServerInformation, streamConfig StreamConfiguration) error {
 	cServerInfo, cleanupSI := serverInfo.C()
 	defer cleanupSI()
 
@@ -19,21 +55,41 @@ func StartConnection(serverInfo ServerInformation, streamConfig StreamConfigurat
 
 	rc := C.LiStartConnection(
 		cServerInfo, cStreamConfig,
-		clCallbacks, drCallbacks, arCallbacks,
+		cb.clCallbacks, cb.drCallbacks, cb.arCallbacks,
 		nil, 0,
 		nil, 0,
 	)
 
 	if rc < 0 {
+		// A failed start has stopped what it started.
+		active.Lock()
+		if active.callbacks == cb {
+			active.callbacks = nil
+			C.setActiveHandle(0)
+		}
+		active.Unlock()
+
 		return fmt.Errorf("LiStartConnection failed with code %d", int(rc))
 	}
 
 	return nil
 }
 
-func StopConnection() {
+// StopConnection stops the connection of cb, interrupting its start, and
+// leaves that of other callbacks running.
+func StopConnection(cb *Callbacks) {
+	active.Lock()
+	defer active.Unlock()
+
+	if cb == nil || active.callbacks != cb {
+		return
+	}
+
 	C.LiInterruptConnection()
 	C.LiStopConnection()
+
+	active.callbacks = nil
+	C.setActiveHandle(0)
 }
 
 func StageName(stage int) string {
@@ -41,6 +97,158 @@ func StageName(stage int) string {
 	return C.GoString(name)
 }
 
+// Stats returns the statistics of the running connection, false without
+// one. The stages are those of its start, including one that failed.
+func Stats() (ConnectionStats, bool) {
+	active.Lock()
+	defer active.Unlock()
+
+	if active.callbacks == nil {
+		return ConnectionStats{}, false
+	}
+
+	var stats ConnectionStats
+
+	stages, connected := active.callbacks.stages.timings()
+	stats.Stages = stages
+
+	// The streams are only set up once connected; stopping takes the
+	// lock, so they stay until these return.
+	if connected {
+		var rtt, variance C.uint32_t
+		if C.LiGetEstimatedRttInfo(&rtt, &variance) {
+			stats.RTT = time.Duration(rtt) * time.Millisecond
+			stats.RTTVariance = time.Duration(variance) * time.Millisecond
+		}
+
+		stats.PendingVideoFrames = int(C.LiGetPendingVideoFrames())
+		stats.PendingAudioFrames = int(C.LiGetPendingAudioFrames())
+	}
+
+	return stats, true
+}
+
+// RequestIDRFrame asks the host of the running connection for a keyframe.
+// Input, like it, goes to the running connection.
 func RequestIDRFrame() {
 	C.LiRequestIdrFrame()
 }
+
+// SendUTF8Text sends a composed UTF-8 string to the host as text input,
+// rather than as individual key events.
+func SendUTF8Text(text string) error {
+	if text == "" {
+		return nil
+	}
+
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+
+	rc := C.LiSendUtf8TextEvent(cText, C.uint(len(text)))
+	if rc < 0 {
+		return errors.New("failed to send text event")
+	}
+
+	return nil
+}
+
+// SendKeyboardEvent sends a Windows virtual-key code with the given
+// key action and modifier flags to the host.
+func SendKeyboardEvent(keyCode int16, keyAction byte, modifiers byte) error {
+	rc := C.LiSendKeyboardEvent(C.short(keyCode), C.char(keyAction), C.char(modifiers))
+	if rc < 0 {
+		return errors.New("failed to send keyboard event")
+	}
+
+	return nil
+}
+
+// SendMultiControllerEvent sends the state of controller controllerNumber
+// to the host. activeGamepadMask has a bit set for each attached controller;
+// button flags use the XInput layout.
+func SendMultiControllerEvent(controllerNumber int16, activeGamepadMask int16, buttonFlags int, leftTrigger, rightTrigger byte, leftStickX, leftStickY, rightStickX, rightStickY int16) error {
+	rc := C.LiSendMultiControllerEvent(
+		C.short(controllerNumber), C.short(activeGamepadMask), C.int(buttonFlags),
+		C.uchar(leftTrigger), C.uchar(rightTrigger),
+		C.short(leftStickX), C.short(leftStickY),
+		C.short(rightStickX), C.short(rightStickY),
+	)
+
+	if rc < 0 {
+		return errors.New("failed to send controller event")
+	}
+
+	return nil
+}
+
+// SendControllerArrivalEvent announces controller controllerNumber and
+// what it supports, so the host emulates a matching controller and asks
+// for motion events when it has sensors.
+func SendControllerArrivalEvent(controllerNumber byte, activeGamepadMask uint16, controllerType byte, supportedButtonFlags uint32, capabilities uint16) error {
+	rc := C.LiSendControllerArrivalEvent(
+		C.uint8_t(controllerNumber), C.uint16_t(activeGamepadMask),
+		C.uint8_t(controllerType), C.uint32_t(supportedButtonFlags),
+		C.uint16_t(capabilities),
+	)
+
+	if rc < 0 {
+		return errors.New("failed to send controller arrival event")
+	}
+
+	return nil
+}
+
+// SendControllerMotionEvent sends a reading of a motion sensor of
+// controller controllerNumber: acceleration in m/s² or angular velocity in
+// degrees per second.
+func SendControllerMotionEvent(controllerNumber byte, motionType byte, x, y, z float32) error {
+	rc := C.LiSendControllerMotionEvent(
+		C.uint8_t(controllerNumber), C.uint8_t(motionType),
+		C.float(x), C.float(y), C.float(z),
+	)
+
+	if rc < 0 {
+		return errors.New("failed to send controller motion event")
+	}
+
+	return nil
+}
+
+// SendMouseMoveEvent moves the host's pointer relative to its position.
+func SendMouseMoveEvent(deltaX, deltaY int16) error {
+	rc := C.LiSendMouseMoveEvent(C.short(deltaX), C.short(deltaY))
+	if rc < 0 {
+		return errors.New("failed to send mouse move event")
+	}
+
+	return nil
+}
+
+// SendMouseButtonEvent presses or releases a mouse button: 1 left,
+// 2 middle, 3 right, 4 and 5 the X buttons.
+func SendMouseButtonEvent(action byte, button int) error {
+	rc := C.LiSendMouseButtonEvent(C.char(action), C.int(button))
+	if rc < 0 {
+		return errors.New("failed to send mouse button event")
+	}
+
+	return nil
+}
+
+// SendScrollEvent scrolls vertically and horizontally in units of 120 per
+// wheel notch.
+func SendScrollEvent(scrollY, scrollX int16) error {
+	if scrollY != 0 {
+		if rc := C.LiSendHighResScrollEvent(C.short(scrollY)); rc < 0 {
+			return errors.New("failed to send scroll event")
+		}
+	}
+
+	if scrollX != 0 {
+		if rc := C.LiSendHighResHScrollEvent(C.short(scrollX)); rc < 0 {
+			return errors.New("failed to send scroll event")
+		}
+	}
+
+	return nil
+}
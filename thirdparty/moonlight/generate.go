@@ -0,0 +1,11 @@
+//go:build linux || darwin
+
+package moonlight
+
+// go generate builds moonlight-common-c as a shared library into
+// ../moonlight-common-c/build, where the cgo flags in moonlight_cgo.go link
+// it and set the rpath to load it from. On macOS set OPENSSL_ROOT_DIR to
+// Homebrew's OpenSSL. Windows builds follow the README.
+
+//go:generate cmake -S ../moonlight-common-c -B ../moonlight-common-c/build -DCMAKE_BUILD_TYPE=Release -DBUILD_SHARED_LIBS=ON
+//go:generate cmake --build ../moonlight-common-c/build --parallel
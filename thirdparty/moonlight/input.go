@@ -0,0 +1,36 @@
+package moonlight
+
+import "errors"
+
+// ErrConnectionBusy is returned when starting a connection while another
+// runs: a process holds a single connection, which input goes to.
+var ErrConnectionBusy = errors.New("another moonlight connection is running")
+
+const (
+	KEY_ACTION_DOWN byte = 0x03
+	KEY_ACTION_UP   byte = 0x04
+)
+
+// Controller types and capabilities announced by
+// SendControllerArrivalEvent.
+const (
+	CONTROLLER_TYPE_UNKNOWN byte = 0x00
+	CONTROLLER_TYPE_XBOX    byte = 0x01
+	CONTROLLER_TYPE_PS      byte = 0x02
+
+	CAPABILITY_ANALOG_TRIGGERS uint16 = 0x01
+	CAPABILITY_RUMBLE          uint16 = 0x02
+	CAPABILITY_TOUCHPAD        uint16 = 0x08
+	CAPABILITY_ACCEL           uint16 = 0x10
+	CAPABILITY_GYRO            uint16 = 0x20
+)
+
+const (
+	MOTION_TYPE_ACCEL byte = 0x01
+	MOTION_TYPE_GYRO  byte = 0x02
+)
+
+const (
+	BUTTON_ACTION_PRESS   byte = 0x07
+	BUTTON_ACTION_RELEASE byte = 0x08
+)
@@ -0,0 +1,97 @@
+package moonlight
+
+import "unsafe"
+
+type ConnectionListener interface {
+	StageStarting(stage int)
+	StageComplete(stage int)
+	StageFailed(stage int, errorCode int)
+	ConnectionStarted()
+	ConnectionTerminated(errorCode int)
+	LogMessage(format string, args ...any)
+	Rumble(controllerNumber, lowFreqMotor, highFreqMotor uint16)
+	ConnectionStatusUpdate(connectionStatus int)
+	// SetHDRMode tells whether the host sends HDR video, with its HDR
+	// metadata when it does and has them.
+	SetHDRMode(hdrEnabled bool, metadata *HDRMetadata)
+	RumbleTriggers(controllerNumber, leftTriggerMotor, rightTriggerMotor uint16)
+	SetMotionEventState(controllerNumber uint16, motionType uint8, reportRateHz uint16)
+	SetControllerLED(controllerNumber uint16, r, g, b uint8)
+}
+
+// HDRMetadata describes the mastering display and the content light levels
+// of HDR video, as SMPTE ST 2086 and CTA-861.3 do.
+type HDRMetadata struct {
+	// DisplayPrimaries are the red, green and blue primaries and
+	// WhitePoint the white point, as CIE 1931 x and y in units of
+	// 0.00002.
+	DisplayPrimaries [3]Chromaticity
+	WhitePoint       Chromaticity
+
+	MaxDisplayLuminance uint16 // nits
+	MinDisplayLuminance uint16 // 0.0001 nits
+
+	// Content values, zero when the host does not know them.
+	MaxContentLightLevel      uint16 // nits
+	MaxFrameAverageLightLevel uint16 // nits
+
+	// MaxFullFrameLuminance is of the display, zero when unknown.
+	MaxFullFrameLuminance uint16 // nits
+}
+
+type Chromaticity struct {
+	X, Y uint16
+}
+
+type VideoDecoderRenderer interface {
+	Setup(format, width, height, redrawRate int, context unsafe.Pointer, drFlags int) int
+	Start()
+	Stop()
+	Cleanup()
+	SubmitDecodeUnit(decodeUnit *DecodeUnit) int
+	Capabilities() int
+}
+
+// DecodeUnit is a frame submitted to the renderer. Its buffers are only
+// valid until SubmitDecodeUnit returns; renderers copy what they keep.
+type DecodeUnit struct {
+	FrameNumber                int
+	FrameType                  int
+	FrameHostProcessingLatency uint16
+	ReceiveTimeMs              uint64
+	EnqueueTimeMs              uint64
+	PresentationTimeMs         uint
+	FullLength                 int
+	BufferList                 *Lentry
+	HDRActive                  bool
+	ColorSpace                 uint8
+}
+
+type Lentry struct {
+	Next       *Lentry
+	Data       []byte
+	Length     int
+	BufferType int
+}
+
+type AudioRenderer interface {
+	Init(audioConfiguration AudioConfiguration, opusConfig *OpusMultiStreamConfiguration, context unsafe.Pointer, arFlags int) int
+	Start()
+	Stop()
+	Cleanup()
+	// PlayEncodedSample gets an Opus packet of sampleLength bytes, already
+	// decrypted when the audio is encrypted.
+	PlayEncodedSample(sampleData []byte, sampleLength int)
+	Capabilities() int
+}
+
+const AUDIO_CONFIGURATION_MAX_CHANNEL_COUNT int = 8
+
+type OpusMultiStreamConfiguration struct {
+	SampleRate      int
+	ChannelCount    int
+	Streams         int
+	CoupledStreams  int
+	SamplesPerFrame int
+	Mapping         [AUDIO_CONFIGURATION_MAX_CHANNEL_COUNT]byte
+}
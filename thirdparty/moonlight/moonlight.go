@@ -1,17 +1,9 @@
 package moonlight
 
-/*
-#cgo CFLAGS:  -I${SRCDIR}/../moonlight-common-c/src
-#cgo LDFLAGS: -L${SRCDIR}/../moonlight-common-c/build -lmoonlight-common-c -Wl,--allow-multiple-definition
-#include <stdlib.h>
-#include <Limelight.h>
-#include <Windows.h>
-*/
-import "C"
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
-	"unsafe"
 )
 
 // Values for the 'streamingRemotely' field below
@@ -116,6 +108,33 @@ const (
 	FRAME_TYPE_IDR FrameType = 0x01
 )
 
+// Stages of a connection, passed to the ConnectionListener.
+const (
+	STAGE_NONE int = iota
+	STAGE_PLATFORM_INIT
+	STAGE_NAME_RESOLUTION
+	STAGE_AUDIO_STREAM_INIT
+	STAGE_RTSP_HANDSHAKE
+	STAGE_CONTROL_STREAM_INIT
+	STAGE_VIDEO_STREAM_INIT
+	STAGE_INPUT_STREAM_INIT
+	STAGE_CONTROL_STREAM_START
+	STAGE_VIDEO_STREAM_START
+	STAGE_AUDIO_STREAM_START
+	STAGE_INPUT_STREAM_START
+	STAGE_MAX
+)
+
+// Error codes passed to ConnectionTerminated besides the host's own.
+const (
+	ML_ERROR_GRACEFUL_TERMINATION         int = 0
+	ML_ERROR_NO_VIDEO_TRAFFIC             int = -100
+	ML_ERROR_NO_VIDEO_FRAME               int = -101
+	ML_ERROR_UNEXPECTED_EARLY_TERMINATION int = -102
+	ML_ERROR_PROTECTED_CONTENT            int = -103
+	ML_ERROR_FRAME_CONVERSION             int = -104
+)
+
 func ParseStreamingRemotely(remote string) (StreamingRemotely, error) {
 	switch remote {
 	case "local":
@@ -233,10 +252,6 @@ func (cfg *AudioConfiguration) SurroundAudioInfo() int {
 	return cfg.ChannelMask<<16 | cfg.ChannelCount
 }
 
-func (cfg *AudioConfiguration) C() C.int {
-	return C.int(cfg.ChannelMask<<16 | cfg.ChannelCount<<8 | 0xCA)
-}
-
 type ServerInformation struct {
 	Address                string // Server host name or IP address in text form
 	AppVersion             string // Text inside 'appversion' tag in /serverinfo
@@ -245,30 +260,6 @@ type ServerInformation struct {
 	ServerCodecModeSupport int    // Specifies the 'ServerCodecModeSupport' from the /serverinfo response.
 }
 
-func (info *ServerInformation) C() (*C.SERVER_INFORMATION, func()) {
-	cAddress := C.CString(info.Address)
-	cAppVersion := C.CString(info.AppVersion)
-	cGfeVersion := C.CString(info.GfeVersion)
-	cRTSPSessionURL := C.CString(info.RTSPSessionURL)
-
-	cServerInfo := &C.SERVER_INFORMATION{
-		address:                cAddress,
-		serverInfoAppVersion:   cAppVersion,
-		serverInfoGfeVersion:   cGfeVersion,
-		rtspSessionUrl:         cRTSPSessionURL,
-		serverCodecModeSupport: C.int(info.ServerCodecModeSupport),
-	}
-
-	cleanup := func() {
-		C.free(unsafe.Pointer(cAddress))
-		C.free(unsafe.Pointer(cAppVersion))
-		C.free(unsafe.Pointer(cGfeVersion))
-		C.free(unsafe.Pointer(cRTSPSessionURL))
-	}
-
-	return cServerInfo, cleanup
-}
-
 func NewRemoteInputAES() (*RemoteInputAES, error) {
 	ri := &RemoteInputAES{
 		Key: [16]byte{},
@@ -279,7 +270,8 @@ func NewRemoteInputAES() (*RemoteInputAES, error) {
 		return nil, err
 	}
 
-	if _, err := rand.Read(ri.IV[:]); err != nil {
+	// Hosts derive the IV from the key ID alone, leaving the rest zero.
+	if _, err := rand.Read(ri.IV[:4]); err != nil {
 		return nil, err
 	}
 
@@ -291,6 +283,12 @@ type RemoteInputAES struct {
 	IV  [16]byte
 }
 
+// KeyID returns the rikeyid sent to the host: the first four bytes of the
+// IV as a big-endian integer.
+func (ri *RemoteInputAES) KeyID() int32 {
+	return int32(binary.BigEndian.Uint32(ri.IV[:4]))
+}
+
 type StreamConfiguration struct {
 	// Dimensions in pixels of the desired video stream
 	Width  int
@@ -350,38 +348,3 @@ type StreamConfiguration struct {
 	// in /launch and /resume requests.
 	RemoteInputAES *RemoteInputAES
 }
-
-func (cfg *StreamConfiguration) C() (*C.STREAM_CONFIGURATION, func()) {
-	cStreamConfig := &C.STREAM_CONFIGURATION{
-		width:                 C.int(cfg.Width),
-		height:                C.int(cfg.Height),
-		fps:                   C.int(cfg.FPS),
-		bitrate:               C.int(cfg.Bitrate),
-		packetSize:            C.int(cfg.PacketSize),
-		streamingRemotely:     C.int(cfg.StreamingRemotely),
-		audioConfiguration:    cfg.AudioConfiguration.C(),
-		supportedVideoFormats: C.int(cfg.SupportedVideoFormats),
-		clientRefreshRateX100: C.int(cfg.ClientRefreshRateX100),
-		colorSpace:            C.int(cfg.ColorSpace),
-		colorRange:            C.int(cfg.ColorRange),
-		encryptionFlags:       C.int(cfg.EncryptionFlags),
-	}
-
-	C.memcpy(
-		unsafe.Pointer(&cStreamConfig.remoteInputAesKey[0]),
-		unsafe.Pointer(&cfg.RemoteInputAES.Key[0]),
-		C.size_t(16),
-	)
-
-	C.memcpy(
-		unsafe.Pointer(&cStreamConfig.remoteInputAesIv[0]),
-		unsafe.Pointer(&cfg.RemoteInputAES.IV[0]),
-		C.size_t(16),
-	)
-
-	cleanup := func() {
-		// No-op
-	}
-
-	return cStreamConfig, cleanup
-}
@@ -0,0 +1,82 @@
+//go:build cgo && !purego
+
+package moonlight
+
+/*
+#cgo CFLAGS:  -I${SRCDIR}/../moonlight-common-c/src
+#cgo LDFLAGS: -L${SRCDIR}/../moonlight-common-c/build -lmoonlight-common-c
+#cgo windows CFLAGS:  -Wno-dll-attribute-on-redeclaration
+#cgo windows LDFLAGS: -Wl,--allow-multiple-definition
+#cgo linux LDFLAGS:   -Wl,-rpath,${SRCDIR}/../moonlight-common-c/build
+#cgo darwin LDFLAGS:  -Wl,-rpath,${SRCDIR}/../moonlight-common-c/build
+#include <stdlib.h>
+#include <Limelight.h>
+#ifdef _WIN32
+#include <Windows.h>
+#endif
+*/
+import "C"
+import "unsafe"
+
+func (cfg *AudioConfiguration) C() C.int {
+	return C.int(cfg.ChannelMask<<16 | cfg.ChannelCount<<8 | 0xCA)
+}
+
+func (info *ServerInformation) C() (*C.SERVER_INFORMATION, func()) {
+	cAddress := C.CString(info.Address)
+	cAppVersion := C.CString(info.AppVersion)
+	cGfeVersion := C.CString(info.GfeVersion)
+	cRTSPSessionURL := C.CString(info.RTSPSessionURL)
+
+	cServerInfo := &C.SERVER_INFORMATION{
+		address:                cAddress,
+		serverInfoAppVersion:   cAppVersion,
+		serverInfoGfeVersion:   cGfeVersion,
+		rtspSessionUrl:         cRTSPSessionURL,
+		serverCodecModeSupport: C.int(info.ServerCodecModeSupport),
+	}
+
+	cleanup := func() {
+		C.free(unsafe.Pointer(cAddress))
+		C.free(unsafe.Pointer(cAppVersion))
+		C.free(unsafe.Pointer(cGfeVersion))
+		C.free(unsafe.Pointer(cRTSPSessionURL))
+	}
+
+	return cServerInfo, cleanup
+}
+
+func (cfg *StreamConfiguration) C() (*C.STREAM_CONFIGURATION, func()) {
+	cStreamConfig := &C.STREAM_CONFIGURATION{
+		width:                 C.int(cfg.Width),
+		height:                C.int(cfg.Height),
+		fps:                   C.int(cfg.FPS),
+		bitrate:               C.int(cfg.Bitrate),
+		packetSize:            C.int(cfg.PacketSize),
+		streamingRemotely:     C.int(cfg.StreamingRemotely),
+		audioConfiguration:    cfg.AudioConfiguration.C(),
+		supportedVideoFormats: C.int(cfg.SupportedVideoFormats),
+		clientRefreshRateX100: C.int(cfg.ClientRefreshRateX100),
+		colorSpace:            C.int(cfg.ColorSpace),
+		colorRange:            C.int(cfg.ColorRange),
+		encryptionFlags:       C.int(cfg.EncryptionFlags),
+	}
+
+	C.memcpy(
+		unsafe.Pointer(&cStreamConfig.remoteInputAesKey[0]),
+		unsafe.Pointer(&cfg.RemoteInputAES.Key[0]),
+		C.size_t(16),
+	)
+
+	C.memcpy(
+		unsafe.Pointer(&cStreamConfig.remoteInputAesIv[0]),
+		unsafe.Pointer(&cfg.RemoteInputAES.IV[0]),
+		C.size_t(16),
+	)
+
+	cleanup := func() {
+		// No-op
+	}
+
+	return cStreamConfig, cleanup
+}
@@ -0,0 +1,430 @@
+//go:build purego || !cgo
+
+package moonlight
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"unicode/utf8"
+)
+
+// Engine runs connections in Go where moonlight-common-c is not built, in
+// builds without cgo or with the purego tag. nvstream registers its own.
+type Engine interface {
+	// Start connects to the host, calling the listener back through its
+	// stages, and returns once the streams run. Cancelling ctx interrupts
+	// it.
+	Start(ctx context.Context, cl ConnectionListener, vr VideoDecoderRenderer, ar AudioRenderer,
+		serverInfo ServerInformation, streamConfig StreamConfiguration) (Session, error)
+}
+
+// Session is a connection started by an Engine.
+type Session interface {
+	Stop()
+	RequestIDRFrame()
+
+	// Stats fills the estimated RTT and the pending frames of stats.
+	Stats(stats *ConnectionStats)
+
+	// SendInput sends an input packet, as the host expects it, over the
+	// control stream.
+	SendInput(packet []byte) error
+}
+
+var engine Engine
+
+// RegisterEngine sets the engine running connections.
+func RegisterEngine(e Engine) {
+	active.Lock()
+	defer active.Unlock()
+
+	engine = e
+}
+
+// Callbacks route the callbacks of a connection to its listener and
+// renderers.
+type Callbacks struct {
+	listener ConnectionListener
+	video    VideoDecoderRenderer
+	audio    AudioRenderer
+	stages   *stageTimer
+	closed   bool // under the lock of active
+}
+
+// SetupCallbacks registers the callbacks of a connection, which Close
+// releases.
+func SetupCallbacks(cl ConnectionListener, vr VideoDecoderRenderer, ar AudioRenderer) *Callbacks {
+	stages := &stageTimer{ConnectionListener: cl}
+
+	return &Callbacks{
+		listener: stages,
+		video:    vr,
+		audio:    ar,
+		stages:   stages,
+	}
+}
+
+// Close stops the connection of the callbacks if it runs and releases
+// them.
+func (cb *Callbacks) Close() {
+	StopConnection(cb)
+
+	active.Lock()
+	defer active.Unlock()
+
+	cb.closed = true
+}
+
+// active is the connection running, which input goes to.
+var active struct {
+	callbacks *Callbacks
+	session   Session
+	cancel    context.CancelFunc
+	sync.Mutex
+}
+
+// StartConnection starts a connection calling cb back. Stop it with
+// StopConnection before starting another.
+func StartConnection(cb *Callbacks, serverInfo ServerInformation, streamConfig StreamConfiguration) error {
+	active.Lock()
+
+	if cb.closed {
+		active.Unlock()
+		return errors.New("callbacks closed")
+	}
+
+	if engine == nil {
+		active.Unlock()
+		return errors.New("no connection engine registered")
+	}
+
+	if active.callbacks != nil && active.callbacks != cb {
+		active.Unlock()
+		return ErrConnectionBusy
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cb.stages.reset()
+
+	active.callbacks = cb
+	active.cancel = cancel
+
+	e := engine
+
+	active.Unlock()
+
+	session, err := e.Start(ctx, cb.listener, cb.video, cb.audio, serverInfo, streamConfig)
+
+	active.Lock()
+	defer active.Unlock()
+
+	// StopConnection interrupted the start.
+	if active.callbacks != cb {
+		if err == nil {
+			session.Stop()
+		}
+
+		cancel()
+		return errors.New("connection interrupted")
+	}
+
+	if err != nil {
+		active.callbacks = nil
+		active.cancel = nil
+		cancel()
+		return err
+	}
+
+	active.session = session
+
+	return nil
+}
+
+// StopConnection stops the connection of cb, interrupting its start, and
+// leaves that of other callbacks running.
+func StopConnection(cb *Callbacks) {
+	active.Lock()
+	defer active.Unlock()
+
+	if cb == nil || active.callbacks != cb {
+		return
+	}
+
+	active.cancel()
+
+	if active.session != nil {
+		active.session.Stop()
+	}
+
+	active.callbacks = nil
+	active.session = nil
+	active.cancel = nil
+}
+
+var stageNames = [STAGE_MAX]string{
+	STAGE_NONE:                 "none",
+	STAGE_PLATFORM_INIT:        "platform initialization",
+	STAGE_NAME_RESOLUTION:      "name resolution",
+	STAGE_AUDIO_STREAM_INIT:    "audio stream initialization",
+	STAGE_RTSP_HANDSHAKE:       "RTSP handshake",
+	STAGE_CONTROL_STREAM_INIT:  "control stream initialization",
+	STAGE_VIDEO_STREAM_INIT:    "video stream initialization",
+	STAGE_INPUT_STREAM_INIT:    "input stream initialization",
+	STAGE_CONTROL_STREAM_START: "control stream establishment",
+	STAGE_VIDEO_STREAM_START:   "video stream establishment",
+	STAGE_AUDIO_STREAM_START:   "audio stream establishment",
+	STAGE_INPUT_STREAM_START:   "input stream establishment",
+}
+
+func StageName(stage int) string {
+	if stage < 0 || stage >= STAGE_MAX {
+		return "unknown"
+	}
+
+	return stageNames[stage]
+}
+
+// Stats returns the statistics of the running connection, false without
+// one. The stages are those of its start, including one that failed.
+func Stats() (ConnectionStats, bool) {
+	active.Lock()
+	defer active.Unlock()
+
+	if active.callbacks == nil {
+		return ConnectionStats{}, false
+	}
+
+	var stats ConnectionStats
+
+	stages, connected := active.callbacks.stages.timings()
+	stats.Stages = stages
+
+	if connected && active.session != nil {
+		active.session.Stats(&stats)
+	}
+
+	return stats, true
+}
+
+// RequestIDRFrame asks the host of the running connection for a keyframe.
+// Input, like it, goes to the running connection.
+func RequestIDRFrame() {
+	active.Lock()
+	defer active.Unlock()
+
+	if active.session != nil {
+		active.session.RequestIDRFrame()
+	}
+}
+
+// sendInput sends an input packet to the host of the running connection.
+func sendInput(packet []byte) error {
+	active.Lock()
+	session := active.session
+	active.Unlock()
+
+	if session == nil {
+		return errors.New("no connection running")
+	}
+
+	return session.SendInput(packet)
+}
+
+// Magic numbers of the input packets Gen 7 hosts accept, from
+// moonlight-common-c's Input.h; Sunshine's extensions start with 0x55.
+const (
+	mouseMoveRelMagic      uint32 = 0x00000007
+	scrollMagic            uint32 = 0x0000000A
+	multiControllerMagic   uint32 = 0x0000000C
+	utf8TextMagic          uint32 = 0x00000017
+	hScrollMagic           uint32 = 0x55000001
+	controllerArrivalMagic uint32 = 0x55000004
+	controllerMotionMagic  uint32 = 0x55000006
+)
+
+// utf8TextMaxLength bounds the text of a packet.
+const utf8TextMaxLength = 32
+
+// inputPacket starts a packet: its size, big-endian and without the size
+// itself, then the little-endian magic.
+func inputPacket(magic uint32, size int) []byte {
+	packet := make([]byte, 8, 8+size)
+	binary.BigEndian.PutUint32(packet[0:4], uint32(4+size))
+	binary.LittleEndian.PutUint32(packet[4:8], magic)
+	return packet
+}
+
+func keyboardPacket(keyCode int16, keyAction byte, modifiers byte) []byte {
+	packet := inputPacket(uint32(keyAction), 6)
+	packet = append(packet, 0) // flags
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(keyCode))
+	packet = append(packet, modifiers, 0, 0)
+	return packet
+}
+
+// utf8TextPackets splits text into packets, not splitting characters.
+func utf8TextPackets(text string) [][]byte {
+	var packets [][]byte
+
+	for len(text) > 0 {
+		n := min(len(text), utf8TextMaxLength)
+		for n < len(text) && !utf8.RuneStart(text[n]) {
+			n--
+		}
+
+		packet := inputPacket(utf8TextMagic, n)
+		packet = append(packet, text[:n]...)
+		packets = append(packets, packet)
+
+		text = text[n:]
+	}
+
+	return packets
+}
+
+func multiControllerPacket(controllerNumber int16, activeGamepadMask int16, buttonFlags int, leftTrigger, rightTrigger byte, leftStickX, leftStickY, rightStickX, rightStickY int16) []byte {
+	packet := inputPacket(multiControllerMagic, 26)
+	packet = binary.LittleEndian.AppendUint16(packet, 0x001A) // header B
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(controllerNumber))
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(activeGamepadMask))
+	packet = binary.LittleEndian.AppendUint16(packet, 0x0014) // mid B
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(buttonFlags))
+	packet = append(packet, leftTrigger, rightTrigger)
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(leftStickX))
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(leftStickY))
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(rightStickX))
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(rightStickY))
+	packet = binary.LittleEndian.AppendUint16(packet, 0x0000) // tail A
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(buttonFlags>>16))
+	packet = binary.LittleEndian.AppendUint16(packet, 0x0055) // tail B
+	return packet
+}
+
+func controllerArrivalPacket(controllerNumber byte, controllerType byte, supportedButtonFlags uint32, capabilities uint16) []byte {
+	packet := inputPacket(controllerArrivalMagic, 8)
+	packet = append(packet, controllerNumber, controllerType)
+	packet = binary.LittleEndian.AppendUint16(packet, capabilities)
+	packet = binary.LittleEndian.AppendUint32(packet, supportedButtonFlags)
+	return packet
+}
+
+func controllerMotionPacket(controllerNumber byte, motionType byte, x, y, z float32) []byte {
+	packet := inputPacket(controllerMotionMagic, 16)
+	packet = append(packet, controllerNumber, motionType, 0, 0)
+	packet = binary.LittleEndian.AppendUint32(packet, math.Float32bits(x))
+	packet = binary.LittleEndian.AppendUint32(packet, math.Float32bits(y))
+	packet = binary.LittleEndian.AppendUint32(packet, math.Float32bits(z))
+	return packet
+}
+
+func mouseMovePacket(deltaX, deltaY int16) []byte {
+	packet := inputPacket(mouseMoveRelMagic, 4)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(deltaX))
+	packet = binary.BigEndian.AppendUint16(packet, uint16(deltaY))
+	return packet
+}
+
+// mouseButtonPacket is magic 8 for a press and 9 for a release on Gen 5
+// and later hosts, one above the button action.
+func mouseButtonPacket(action byte, button int) []byte {
+	packet := inputPacket(uint32(action)+1, 1)
+	return append(packet, byte(button))
+}
+
+func scrollPacket(amount int16) []byte {
+	packet := inputPacket(scrollMagic, 6)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(amount))
+	packet = binary.BigEndian.AppendUint16(packet, uint16(amount))
+	return append(packet, 0, 0)
+}
+
+func hScrollPacket(amount int16) []byte {
+	packet := inputPacket(hScrollMagic, 2)
+	return binary.BigEndian.AppendUint16(packet, uint16(amount))
+}
+
+// SendUTF8Text sends a composed UTF-8 string to the host as text input,
+// rather than as individual key events.
+func SendUTF8Text(text string) error {
+	for _, packet := range utf8TextPackets(text) {
+		if err := sendInput(packet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SendKeyboardEvent sends a Windows virtual-key code with the given
+// key action and modifier flags to the host.
+func SendKeyboardEvent(keyCode int16, keyAction byte, modifiers byte) error {
+	return sendInput(keyboardPacket(keyCode, keyAction, modifiers))
+}
+
+// SendMultiControllerEvent sends the state of controller controllerNumber
+// to the host. activeGamepadMask has a bit set for each attached controller;
+// button flags use the XInput layout.
+func SendMultiControllerEvent(controllerNumber int16, activeGamepadMask int16, buttonFlags int, leftTrigger, rightTrigger byte, leftStickX, leftStickY, rightStickX, rightStickY int16) error {
+	return sendInput(multiControllerPacket(
+		controllerNumber, activeGamepadMask, buttonFlags,
+		leftTrigger, rightTrigger,
+		leftStickX, leftStickY,
+		rightStickX, rightStickY,
+	))
+}
+
+// SendControllerArrivalEvent announces controller controllerNumber and
+// what it supports, so the host emulates a matching controller and asks
+// for motion events when it has sensors.
+func SendControllerArrivalEvent(controllerNumber byte, activeGamepadMask uint16, controllerType byte, supportedButtonFlags uint32, capabilities uint16) error {
+	err := sendInput(controllerArrivalPacket(controllerNumber, controllerType, supportedButtonFlags, capabilities))
+	if err != nil {
+		return err
+	}
+
+	// Hosts without arrival events learn of the controller by its state.
+	return sendInput(multiControllerPacket(
+		int16(controllerNumber), int16(activeGamepadMask), 0,
+		0, 0, 0, 0, 0, 0,
+	))
+}
+
+// SendControllerMotionEvent sends a reading of a motion sensor of
+// controller controllerNumber: acceleration in m/s² or angular velocity in
+// degrees per second.
+func SendControllerMotionEvent(controllerNumber byte, motionType byte, x, y, z float32) error {
+	return sendInput(controllerMotionPacket(controllerNumber, motionType, x, y, z))
+}
+
+// SendMouseMoveEvent moves the host's pointer relative to its position.
+func SendMouseMoveEvent(deltaX, deltaY int16) error {
+	return sendInput(mouseMovePacket(deltaX, deltaY))
+}
+
+// SendMouseButtonEvent presses or releases a mouse button: 1 left,
+// 2 middle, 3 right, 4 and 5 the X buttons.
+func SendMouseButtonEvent(action byte, button int) error {
+	return sendInput(mouseButtonPacket(action, button))
+}
+
+// SendScrollEvent scrolls vertically and horizontally in units of 120 per
+// wheel notch.
+func SendScrollEvent(scrollY, scrollX int16) error {
+	if scrollY != 0 {
+		if err := sendInput(scrollPacket(scrollY)); err != nil {
+			return err
+		}
+	}
+
+	if scrollX != 0 {
+		if err := sendInput(hScrollPacket(scrollX)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package moonlight
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// ConnectionStats are the statistics of the running connection.
+type ConnectionStats struct {
+	// RTT is the round trip time to the host the control stream
+	// estimated, zero until it has.
+	RTT         time.Duration `json:"rtt"`
+	RTTVariance time.Duration `json:"rtt_variance"`
+
+	// PendingVideoFrames and PendingAudioFrames are received, and not yet
+	// taken by the renderers.
+	PendingVideoFrames int `json:"pending_video_frames"`
+	PendingAudioFrames int `json:"pending_audio_frames"`
+
+	Stages []StageTiming `json:"stages"`
+}
+
+// StageTiming is how long a stage of the connection start took.
+type StageTiming struct {
+	Stage    int           `json:"stage"`
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+	Failed   bool          `json:"failed,omitempty"`
+}
+
+// stageTimer times the stages of a connection from its listener's
+// callbacks, and tells when it runs.
+type stageTimer struct {
+	ConnectionListener
+
+	started   time.Time // of the current stage
+	stages    []StageTiming
+	connected bool
+	sync.Mutex
+}
+
+// reset forgets the stages of the previous connection.
+func (t *stageTimer) reset() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.stages = nil
+	t.connected = false
+}
+
+func (t *stageTimer) end(stage int, failed bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.stages = append(t.stages, StageTiming{
+		Stage:    stage,
+		Name:     StageName(stage),
+		Duration: time.Since(t.started),
+		Failed:   failed,
+	})
+}
+
+func (t *stageTimer) timings() ([]StageTiming, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	return slices.Clone(t.stages), t.connected
+}
+
+func (t *stageTimer) StageStarting(stage int) {
+	t.Lock()
+	t.started = time.Now()
+	t.Unlock()
+
+	t.ConnectionListener.StageStarting(stage)
+}
+
+func (t *stageTimer) StageComplete(stage int) {
+	t.end(stage, false)
+	t.ConnectionListener.StageComplete(stage)
+}
+
+func (t *stageTimer) StageFailed(stage int, errorCode int) {
+	t.end(stage, true)
+	t.ConnectionListener.StageFailed(stage, errorCode)
+}
+
+func (t *stageTimer) ConnectionStarted() {
+	t.Lock()
+	t.connected = true
+	t.Unlock()
+
+	t.ConnectionListener.ConnectionStarted()
+}
+
+func (t *stageTimer) ConnectionTerminated(errorCode int) {
+	t.Lock()
+	t.connected = false
+	t.Unlock()
+
+	t.ConnectionListener.ConnectionTerminated(errorCode)
+}
@@ -0,0 +1,59 @@
+package game
+
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tracing exports spans of peer negotiation and stream startup to an
+// OpenTelemetry collector over OTLP/HTTP, e.g. http://localhost:4318.
+type Tracing struct {
+	Enabled     bool
+	Endpoint    string
+	ServiceName string
+	Headers     map[string]string
+	Interval    time.Duration
+}
+
+func (cfg *Tracing) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Enabled     bool              `yaml:"enabled"`
+		Endpoint    string            `yaml:"endpoint"`
+		ServiceName string            `yaml:"serviceName"`
+		Headers     map[string]string `yaml:"headers"`
+		Interval    time.Duration     `yaml:"interval"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	for name, header := range raw.Headers {
+		if err := resolveSecrets(&header); err != nil {
+			return err
+		}
+
+		raw.Headers[name] = header
+	}
+
+	if raw.Endpoint == "" {
+		raw.Endpoint = "http://localhost:4318"
+	}
+
+	if raw.ServiceName == "" {
+		raw.ServiceName = "game"
+	}
+
+	if raw.Interval == 0 {
+		raw.Interval = 5 * time.Second
+	}
+
+	cfg.Enabled = raw.Enabled
+	cfg.Endpoint = raw.Endpoint
+	cfg.ServiceName = raw.ServiceName
+	cfg.Headers = raw.Headers
+	cfg.Interval = raw.Interval
+
+	return nil
+}
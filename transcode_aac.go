@@ -0,0 +1,69 @@
+//go:build aac
+
+package game
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+)
+
+// aacTranscode tells whether this build transcodes AAC sources.
+const aacTranscode = true
+
+// transcodeAAC decodes the ADTS AAC stream of r with an ffmpeg process and
+// returns it encoded as Ogg Opus. The process stops with ctx, or when r or
+// the returned stream is closed.
+func transcodeAAC(ctx context.Context, r io.ReadCloser, audio *AudioTrack) (io.ReadCloser, error) {
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-f", "aac", "-i", "pipe:0",
+		"-vn", "-c:a", "libopus",
+		"-b:a", strconv.Itoa(audio.bitrate) + "k",
+		"-ar", "48000",
+		"-application", "lowdelay",
+		"-frame_duration", "20",
+		"-fec", boolParam(audio.fec),
+		"-dtx", boolParam(audio.dtx),
+		"-page_duration", "20000",
+		"-f", "ogg", "pipe:1",
+	}
+
+	pr, pw := io.Pipe()
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, audio.ffmpeg, args...)
+	cmd.Stdin = r
+	cmd.Stdout = pw
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		err := cmd.Wait()
+		if msg := bytes.TrimSpace(stderr.Bytes()); err != nil && len(msg) > 0 {
+			err = errors.New("transcode aac: " + string(msg))
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	return &transcodedStream{Reader: pr, source: r, pipe: pr}, nil
+}
+
+type transcodedStream struct {
+	io.Reader
+	source io.Closer
+	pipe   io.Closer
+}
+
+func (s *transcodedStream) Close() error {
+	s.pipe.Close()
+	return s.source.Close()
+}
@@ -0,0 +1,91 @@
+//go:build aac
+
+package game
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTranscodeAAC(t *testing.T) {
+	assert := assert.New(t)
+
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// one second of a sine wave, as ADTS AAC
+	aac, err := exec.CommandContext(ctx, "ffmpeg",
+		"-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=1",
+		"-c:a", "aac", "-f", "adts", "pipe:1",
+	).Output()
+	if err != nil {
+		t.Skip("ffmpeg without aac encoder: " + err.Error())
+	}
+
+	audio := &AudioTrack{
+		codec:   CodecOpus,
+		source:  CodecAAC,
+		ffmpeg:  "ffmpeg",
+		bitrate: 64,
+		fec:     true,
+	}
+
+	r, err := transcodeAAC(ctx, io.NopCloser(bytes.NewReader(aac)), audio)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+	defer r.Close()
+
+	reader, header, err := oggreader.NewWith(r)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(uint32(48000), header.SampleRate)
+
+	var pages int
+	for {
+		if _, _, err := reader.ParseNextPage(); err != nil {
+			break
+		}
+
+		pages++
+	}
+
+	assert.Greater(pages, 10)
+}
+
+func TestAudioTranscodeConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	var audio *AudioTrack
+	err := yaml.Unmarshal([]byte("codec: aac\nbitrate: 64"), &audio)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(CodecOpus, audio.Codec())
+	assert.Equal(CodecAAC, audio.Source())
+	assert.Equal("ffmpeg", audio.ffmpeg)
+	assert.Equal(64, audio.bitrate)
+	assert.Equal("minptime=10;useinbandfec=1", audio.Fmtp())
+
+	err = yaml.Unmarshal([]byte("codec: aac\ncontainer: raw"), &audio)
+	assert.EqualError(err, "container unsupported for aac: raw")
+}
@@ -0,0 +1,15 @@
+//go:build !aac
+
+package game
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+const aacTranscode = false
+
+func transcodeAAC(ctx context.Context, r io.ReadCloser, audio *AudioTrack) (io.ReadCloser, error) {
+	return nil, errors.New("aac transcode unsupported: build with -tags aac")
+}
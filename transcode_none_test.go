@@ -0,0 +1,18 @@
+//go:build !aac
+
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAudioTranscodeUnsupported(t *testing.T) {
+	assert := assert.New(t)
+
+	var audio *AudioTrack
+	err := yaml.Unmarshal([]byte("codec: aac"), &audio)
+	assert.EqualError(err, "aac transcode unsupported: build with -tags aac")
+}
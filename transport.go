@@ -1,15 +1,184 @@
 package game
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 	"github.com/pion/webrtc/v4"
+
+	"github.com/flarexio/game/nvstream"
+	"github.com/flarexio/game/session"
+	"github.com/flarexio/game/telemetry"
 )
 
-func ICEServersHandler(svc Service) micro.HandlerFunc {
+// DefaultRequestTimeout bounds the handling of a request unless configured
+// otherwise, so an upstream that hangs cannot hold its handler for good.
+const DefaultRequestTimeout = 30 * time.Second
+
+// RequestHandler handles a request of an endpoint within ctx, which ends
+// when the request times out.
+type RequestHandler func(ctx context.Context, r micro.Request)
+
+// withTimeout serves h with the requests bounded by timeout, as children of
+// the trace context in their headers and correlated by their request ID.
+func withTimeout(timeout time.Duration, h RequestHandler) micro.HandlerFunc {
 	return func(r micro.Request) {
+		headers := nats.Header(r.Headers())
+
+		ctx := telemetry.Extract(context.Background(), headers)
+		ctx = WithRequestID(ctx, headers.Get(RequestIDHeader))
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		h(ctx, r)
+	}
+}
+
+// errorCode maps errors of the service to the codes of error responses,
+// 417 for those without one.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrStreamNotFound),
+		errors.Is(err, nvstream.ErrAppNotFound):
+		return "404"
+
+	case errors.Is(err, ErrInvalidGuestToken),
+		errors.Is(err, ErrGuestTokenExpired),
+		errors.Is(err, ErrGuestTokenRevoked):
+		return "401"
+
+	case errors.Is(err, ErrPermissionDenied),
+		errors.Is(err, ErrManagementDisabled):
+		return "403"
+
+	case errors.Is(err, ErrTooManyPeers),
+		errors.Is(err, ErrSlotTaken),
+		errors.Is(err, ErrStreamExists),
+		errors.Is(err, session.ErrSessionExists):
+		return "409"
+
+	case errors.Is(err, ErrNoKeyframe):
+		return "503"
+
+	case errors.Is(err, ErrNegotiationTimeout):
+		return "504"
+
+	default:
+		return "417"
+	}
+}
+
+func respondError(r micro.Request, err error) {
+	r.Error(errorCode(err), err.Error(), nil)
+}
+
+// AddEndpoints registers the service's endpoints on a micro service, each
+// request bounded by timeout. Pairing waits PairTimeout for the PIN on top
+// of it.
+func AddEndpoints(srv micro.Service, svc Service, timeout time.Duration) error {
+	peers := srv.AddGroup("peers")
+	if err := peers.AddEndpoint("iceservers", withTimeout(timeout, ICEServersHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := peers.AddEndpoint("negotiation", withTimeout(timeout, AcceptPeerHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := peers.AddEndpoint("channels", withTimeout(timeout, ChannelStatsHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := peers.AddEndpoint("list", withTimeout(timeout, ListPeersHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := peers.AddEndpoint("latency", withTimeout(timeout, PeerLatencyHandler(svc))); err != nil {
+		return err
+	}
+
+	streams := srv.AddGroup("streams")
+	if err := streams.AddEndpoint("snapshot", withTimeout(timeout, SnapshotHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := streams.AddEndpoint("list", withTimeout(timeout, ListStreamsHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := streams.AddEndpoint("describe", withTimeout(timeout, DescribeStreamsHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := streams.AddEndpoint("add", withTimeout(timeout, AddStreamHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := streams.AddEndpoint("remove", withTimeout(timeout, RemoveStreamHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := streams.AddEndpoint("start", withTimeout(timeout, StartStreamHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := streams.AddEndpoint("stop", withTimeout(timeout, StopStreamHandler(svc))); err != nil {
+		return err
+	}
+
+	guests := srv.AddGroup("guests")
+	if err := guests.AddEndpoint("create", withTimeout(timeout, CreateGuestTokenHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := guests.AddEndpoint("revoke", withTimeout(timeout, RevokeGuestTokenHandler(svc))); err != nil {
+		return err
+	}
+
+	gamepads := srv.AddGroup("gamepads")
+	if err := gamepads.AddEndpoint("stats", withTimeout(timeout, InputStatsHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := srv.AddEndpoint("health", withTimeout(timeout, HealthHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := srv.AddEndpoint("ready", withTimeout(timeout, ReadyHandler(svc))); err != nil {
+		return err
+	}
+
+	nv := srv.AddGroup("nvstream")
+	if err := nv.AddEndpoint("pair", withTimeout(PairTimeout+timeout, PairHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := nv.AddEndpoint("apps", withTimeout(timeout, AppsHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := nv.AddEndpoint("boxart", withTimeout(timeout, AppBoxArtHandler(svc))); err != nil {
+		return err
+	}
+
+	if err := nv.AddEndpoint("stats", withTimeout(timeout, NVStreamStatsHandler(svc))); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func ICEServersHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
 		p := r.Headers().Get("provider")
 		provider, err := ParseICEProvider(p)
 		if err != nil {
@@ -17,38 +186,542 @@ func ICEServersHandler(svc Service) micro.HandlerFunc {
 			return
 		}
 
-		servers, err := svc.ICEServers(provider)
+		creds, err := svc.ICEServers(ctx, provider)
 		if err != nil {
-			r.Error("417", err.Error(), nil)
+			respondError(r, err)
 			return
 		}
 
-		r.RespondJSON(&servers)
+		// Clients refresh the credentials before they expire.
+		headers := micro.Headers{
+			RequestIDHeader: []string{RequestIDFrom(ctx)},
+		}
+
+		if !creds.ExpiresAt.IsZero() {
+			headers["Expires"] = []string{creds.ExpiresAt.UTC().Format(time.RFC3339)}
+		}
+
+		if creds.RelayOnly {
+			headers["ICE-Transport-Policy"] = []string{"relay"}
+		}
+
+		r.RespondJSON(&creds.Servers, micro.WithHeaders(headers))
 	}
 }
 
-func AcceptPeerHandler(svc Service) micro.HandlerFunc {
-	return func(r micro.Request) {
+func AcceptPeerHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
 		var offer *webrtc.SessionDescription
 		if err := json.Unmarshal(r.Data(), &offer); err != nil {
 			r.Error("400", err.Error(), nil)
 			return
 		}
 
+		if offer == nil || offer.Type != webrtc.SDPTypeOffer || offer.SDP == "" {
+			r.Error("400", "invalid offer", nil)
+			return
+		}
+
 		reply, ok := strings.CutSuffix(r.Reply(), ".sdp.answer")
 		if !ok {
 			r.Error("400", "invalid reply", nil)
 			return
 		}
 
-		peer, err := svc.AcceptPeer(*offer, reply)
+		// What access grants, unless the client asks for less.
+		perms := PermissionAll
+		if p := r.Headers().Get("permissions"); p != "" {
+			parsed, err := ParsePermissions(p)
+			if err != nil {
+				r.Error("400", err.Error(), nil)
+				return
+			}
+
+			perms = parsed
+		}
+
+		mode, err := ParsePeerMode(r.Headers().Get("mode"))
+		if err != nil {
+			r.Error("400", err.Error(), nil)
+			return
+		}
+
+		opts := PeerOptions{
+			Stream:      r.Headers().Get("stream"),
+			Permissions: perms,
+			GuestToken:  r.Headers().Get("guest-token"),
+			Mode:        mode,
+		}
+
+		if interval := r.Headers().Get("stills-interval"); interval != "" {
+			d, err := time.ParseDuration(interval)
+			if err != nil || d < 0 {
+				r.Error("400", "invalid stills interval", nil)
+				return
+			}
+
+			opts.StillsInterval = d
+		}
+
+		peer, err := svc.AcceptPeer(ctx, *offer, reply, opts)
 		if err != nil {
-			r.Error("417", err.Error(), nil)
+			respondError(r, err)
 			return
 		}
 
 		answer := peer.LocalDescription()
 
-		r.RespondJSON(&answer)
+		headers := micro.Headers{
+			RequestIDHeader: []string{peer.RequestID()},
+		}
+
+		if downgrades := peer.Downgrades(); len(downgrades) > 0 {
+			bs, err := json.Marshal(downgrades)
+			if err != nil {
+				r.Error("500", err.Error(), nil)
+				return
+			}
+
+			headers["Downgrades"] = []string{string(bs)}
+		}
+
+		// The client sets it as its receivers' jitterBufferTarget.
+		if target := peer.stream.TargetLatency; target != nil {
+			headers["Target-Latency-Ms"] = []string{strconv.FormatInt(target.Milliseconds(), 10)}
+		}
+
+		r.RespondJSON(&answer, micro.WithHeaders(headers))
 	}
 }
+
+func SnapshotHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		format, err := ParseImageFormat(r.Headers().Get("format"))
+		if err != nil {
+			r.Error("400", err.Error(), nil)
+			return
+		}
+
+		opts := SnapshotOptions{
+			Format: format,
+		}
+
+		if w := r.Headers().Get("width"); w != "" {
+			width, err := strconv.Atoi(w)
+			if err != nil || width < 0 {
+				r.Error("400", "invalid width", nil)
+				return
+			}
+
+			opts.Width = width
+		}
+
+		name := r.Headers().Get("stream")
+		if name == "" {
+			name = DefaultStream
+		}
+
+		snapshot, err := svc.Snapshot(ctx, name, opts)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.Respond(snapshot.Data, micro.WithHeaders(micro.Headers{
+			"Content-Type": []string{snapshot.Format.ContentType()},
+			"Captured-At":  []string{snapshot.CapturedAt.Format(time.RFC3339Nano)},
+		}))
+	}
+}
+
+// ListStreamsHandler returns a summary of each stream.
+func ListStreamsHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		manifests, err := svc.DescribeStreams(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		summaries := make([]*StreamSummary, 0, len(manifests))
+		for _, m := range manifests {
+			summaries = append(summaries, m.Summary())
+		}
+
+		r.RespondJSON(&summaries)
+	}
+}
+
+// DescribeStreamsHandler returns the manifests of all streams, or of the
+// stream named in the stream header.
+func DescribeStreamsHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		manifests, err := svc.DescribeStreams(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		if name := r.Headers().Get("stream"); name != "" {
+			i := slices.IndexFunc(manifests, func(m *StreamManifest) bool {
+				return m.Name == name
+			})
+
+			if i < 0 {
+				respondError(r, ErrStreamNotFound)
+				return
+			}
+
+			manifests = manifests[i : i+1]
+		}
+
+		r.RespondJSON(&manifests)
+	}
+}
+
+// AddStreamHandler adds the stream specified in the request, as YAML or
+// JSON with the keys of config.yaml a StreamSpec accepts, and returns its
+// manifest.
+func AddStreamHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		spec, err := ParseStreamSpec(r.Data())
+		if err != nil {
+			r.Error("400", err.Error(), nil)
+			return
+		}
+
+		stream, err := spec.Stream()
+		if err != nil {
+			r.Error("400", err.Error(), nil)
+			return
+		}
+
+		if stream.Name == "" {
+			r.Error("400", "stream name not specified", nil)
+			return
+		}
+
+		if stream.Transport == "" {
+			r.Error("400", "transport not specified", nil)
+			return
+		}
+
+		manifest, err := svc.AddStream(ctx, stream)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(manifest)
+	}
+}
+
+// streamHandler calls fn with the stream named in the stream header.
+func streamHandler(fn func(ctx context.Context, name string) error) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		name := r.Headers().Get("stream")
+		if name == "" {
+			r.Error("400", "stream not specified", nil)
+			return
+		}
+
+		if err := fn(ctx, name); err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.Respond(nil)
+	}
+}
+
+func RemoveStreamHandler(svc Service) RequestHandler {
+	return streamHandler(svc.RemoveStream)
+}
+
+func StartStreamHandler(svc Service) RequestHandler {
+	return streamHandler(svc.StartStream)
+}
+
+func StopStreamHandler(svc Service) RequestHandler {
+	return streamHandler(svc.StopStream)
+}
+
+func CreateGuestTokenHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		role, err := ParseGuestRole(r.Headers().Get("role"))
+		if err != nil {
+			r.Error("400", err.Error(), nil)
+			return
+		}
+
+		var ttl time.Duration
+		if s := r.Headers().Get("ttl"); s != "" {
+			ttl, err = time.ParseDuration(s)
+			if err != nil || ttl < 0 {
+				r.Error("400", "invalid ttl", nil)
+				return
+			}
+		}
+
+		var slot int
+		if s := r.Headers().Get("slot"); s != "" {
+			slot, err = strconv.Atoi(s)
+			if err != nil {
+				r.Error("400", "invalid slot", nil)
+				return
+			}
+		}
+
+		opts := GuestOptions{
+			Stream: r.Headers().Get("stream"),
+			Role:   role,
+			Slot:   slot,
+			TTL:    ttl,
+		}
+
+		token, err := svc.CreateGuestToken(ctx, opts)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&token)
+	}
+}
+
+func RevokeGuestTokenHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		id := r.Headers().Get("id")
+		if id == "" {
+			r.Error("400", "guest token id not specified", nil)
+			return
+		}
+
+		if err := svc.RevokeGuestToken(ctx, id); err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.Respond(nil)
+	}
+}
+
+// PairHandler pairs with the host of an NVStream stream. The reply subject
+// must end with .state; the PIN and progress are published to .progress
+// under the same prefix, and the final state is the response.
+func PairHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		reply, ok := strings.CutSuffix(r.Reply(), ".state")
+		if !ok {
+			r.Error("400", "invalid reply", nil)
+			return
+		}
+
+		name := r.Headers().Get("stream")
+		if name == "" {
+			name = DefaultStream
+		}
+
+		result, err := svc.Pair(ctx, name, reply)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&result)
+	}
+}
+
+// AppsHandler lists the apps of the host of the stream named in the
+// stream header.
+func AppsHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		name := r.Headers().Get("stream")
+		if name == "" {
+			name = DefaultStream
+		}
+
+		apps, err := svc.Apps(ctx, name)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&apps)
+	}
+}
+
+// AppBoxArtHandler returns the box art of the app in the app header, as
+// the host serves it.
+func AppBoxArtHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		appID, err := strconv.Atoi(r.Headers().Get("app"))
+		if err != nil || appID < 0 {
+			r.Error("400", "invalid app", nil)
+			return
+		}
+
+		name := r.Headers().Get("stream")
+		if name == "" {
+			name = DefaultStream
+		}
+
+		data, err := svc.AppBoxArt(ctx, name, appID)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.Respond(data, micro.WithHeaders(micro.Headers{
+			"Content-Type": []string{http.DetectContentType(data)},
+		}))
+	}
+}
+
+func InputStatsHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		stats, err := svc.InputStats(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&stats)
+	}
+}
+
+func ChannelStatsHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		stats, err := svc.ChannelStats(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&stats)
+	}
+}
+
+func ListPeersHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		peers, err := svc.ListPeers(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&peers)
+	}
+}
+
+func PeerLatencyHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		stats, err := svc.PeerLatency(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&stats)
+	}
+}
+
+func NVStreamStatsHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		stats, err := svc.NVStreamStats(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&stats)
+	}
+}
+
+// HealthHandler always reports the service's health; use ReadyHandler to
+// act on it.
+func HealthHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		health, err := svc.Health(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		r.RespondJSON(&health)
+	}
+}
+
+// ReadyHandler responds with the health report, as a 503 error when the
+// service is not ready.
+func ReadyHandler(svc Service) RequestHandler {
+	return func(ctx context.Context, r micro.Request) {
+		health, err := svc.Health(ctx)
+		if err != nil {
+			respondError(r, err)
+			return
+		}
+
+		if !health.Ready {
+			data, _ := json.Marshal(health)
+			r.Error("503", "not ready", data)
+			return
+		}
+
+		r.RespondJSON(&health)
+	}
+}
+
+// HealthHTTPHandler serves /health and /ready over HTTP for orchestrators
+// that probe that way, with the same status semantics as the endpoints, and
+// the data channel and subscription metrics on /metrics for Prometheus.
+func HealthHTTPHandler(svc Service, timeout time.Duration) http.Handler {
+	respond := func(w http.ResponseWriter, r *http.Request, ready bool) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		health, err := svc.Health(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusExpectationFailed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if ready && !health.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(health)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, false)
+	})
+	mux.HandleFunc("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, true)
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		stats, err := svc.ChannelStats(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusExpectationFailed)
+			return
+		}
+
+		health, err := svc.Health(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusExpectationFailed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w, stats)
+		WritePrometheusGauge(w, "game_nats_peer_subscriptions", "Active NATS subscriptions of peers.", health.Subscriptions)
+	})
+
+	return mux
+}
@@ -0,0 +1,468 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flarexio/game/nvstream"
+	"github.com/flarexio/game/thirdparty/moonlight"
+)
+
+// mockService implements the Service methods a test sets; the others
+// panic on the nil interface.
+type mockService struct {
+	Service
+	snapshot         func(name string, opts SnapshotOptions) (*Snapshot, error)
+	acceptPeer       func(ctx context.Context, offer webrtc.SessionDescription, reply string, opts PeerOptions) (*Peer, error)
+	createGuestToken func(opts GuestOptions) (*GuestToken, error)
+	describeStreams  func() ([]*StreamManifest, error)
+	health           func() (*Health, error)
+	addStream        func(stream *Stream) (*StreamManifest, error)
+	appBoxArt        func(name string, appID int) ([]byte, error)
+	nvstreamStats    func() ([]NVStreamStats, error)
+}
+
+func (m *mockService) Snapshot(ctx context.Context, name string, opts SnapshotOptions) (*Snapshot, error) {
+	return m.snapshot(name, opts)
+}
+
+func (m *mockService) AcceptPeer(ctx context.Context, offer webrtc.SessionDescription, reply string, opts PeerOptions) (*Peer, error) {
+	return m.acceptPeer(ctx, offer, reply, opts)
+}
+
+func (m *mockService) CreateGuestToken(ctx context.Context, opts GuestOptions) (*GuestToken, error) {
+	return m.createGuestToken(opts)
+}
+
+func (m *mockService) DescribeStreams(ctx context.Context) ([]*StreamManifest, error) {
+	return m.describeStreams()
+}
+
+func (m *mockService) AddStream(ctx context.Context, stream *Stream) (*StreamManifest, error) {
+	return m.addStream(stream)
+}
+
+func (m *mockService) AppBoxArt(ctx context.Context, name string, appID int) ([]byte, error) {
+	return m.appBoxArt(name, appID)
+}
+
+func (m *mockService) NVStreamStats(ctx context.Context) ([]NVStreamStats, error) {
+	return m.nvstreamStats()
+}
+
+func (m *mockService) Health(ctx context.Context) (*Health, error) {
+	return m.health()
+}
+
+// testRequest records the response to a request.
+type testRequest struct {
+	data    []byte
+	headers micro.Headers
+	reply   string
+
+	code        string
+	description string
+	response    []byte
+}
+
+func (r *testRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	r.response = data
+	return nil
+}
+
+func (r *testRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return r.Respond(bs, opts...)
+}
+
+func (r *testRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	r.code = code
+	r.description = description
+	r.response = data
+	return nil
+}
+
+func (r *testRequest) Data() []byte           { return r.data }
+func (r *testRequest) Headers() micro.Headers { return r.headers }
+func (r *testRequest) Subject() string        { return "" }
+func (r *testRequest) Reply() string          { return r.reply }
+
+func TestErrorCode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("404", errorCode(ErrStreamNotFound))
+	assert.Equal("401", errorCode(ErrGuestTokenExpired))
+	assert.Equal("403", errorCode(ErrPermissionDenied))
+	assert.Equal("409", errorCode(ErrTooManyPeers))
+	assert.Equal("503", errorCode(ErrNoKeyframe))
+	assert.Equal("417", errorCode(errors.New("unexpected")))
+}
+
+func TestAcceptPeerHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		accepted  *PeerOptions
+		requestID string
+	)
+
+	svc := &mockService{
+		acceptPeer: func(ctx context.Context, offer webrtc.SessionDescription, reply string, opts PeerOptions) (*Peer, error) {
+			accepted = &opts
+			requestID = RequestIDFrom(ctx)
+			return nil, ErrInvalidGuestToken
+		},
+	}
+
+	handler := withTimeout(DefaultRequestTimeout, AcceptPeerHandler(svc))
+
+	offer, _ := json.Marshal(&webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  "v=0\r\n",
+	})
+
+	tests := []struct {
+		name  string
+		data  []byte
+		reply string
+		hdrs  micro.Headers
+		code  string
+	}{
+		{"null offer", []byte("null"), "peers.negotiation.a.sdp.answer", nil, "400"},
+		{"answer", []byte(`{"type":"answer","sdp":"v=0"}`), "peers.negotiation.a.sdp.answer", nil, "400"},
+		{"reply", offer, "peers.negotiation.a", nil, "400"},
+		{"permissions", offer, "peers.negotiation.a.sdp.answer", micro.Headers{"permissions": {"admin"}}, "400"},
+		{"mode", offer, "peers.negotiation.a.sdp.answer", micro.Headers{"mode": {"vr"}}, "400"},
+		{"stills interval", offer, "peers.negotiation.a.sdp.answer", micro.Headers{"stills-interval": {"-1s"}}, "400"},
+		{"guest token", offer, "peers.negotiation.a.sdp.answer", micro.Headers{"guest-token": {"x"}, RequestIDHeader: {"req-1"}}, "401"},
+	}
+
+	for _, test := range tests {
+		accepted = nil
+
+		r := &testRequest{data: test.data, reply: test.reply, headers: test.hdrs}
+		if r.headers == nil {
+			r.headers = micro.Headers{}
+		}
+
+		handler(r)
+
+		assert.Equal(test.code, r.code, test.name)
+		assert.Equal(test.code == "401", accepted != nil, test.name)
+	}
+
+	if assert.NotNil(accepted) {
+		assert.Equal("x", accepted.GuestToken)
+		assert.Equal(PermissionAll, accepted.Permissions)
+		assert.Equal("req-1", requestID)
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := &mockService{
+		snapshot: func(name string, opts SnapshotOptions) (*Snapshot, error) {
+			if name != DefaultStream {
+				return nil, ErrStreamNotFound
+			}
+
+			return nil, ErrNoKeyframe
+		},
+	}
+
+	handler := SnapshotHandler(svc)
+
+	r := &testRequest{headers: micro.Headers{"width": {"-1"}}}
+	handler(context.Background(), r)
+	assert.Equal("400", r.code)
+
+	r = &testRequest{headers: micro.Headers{"stream": {"unknown"}}}
+	handler(context.Background(), r)
+	assert.Equal("404", r.code)
+
+	r = &testRequest{headers: micro.Headers{}}
+	handler(context.Background(), r)
+	assert.Equal("503", r.code)
+	assert.Equal(ErrNoKeyframe.Error(), r.description)
+}
+
+func TestCreateGuestTokenHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := &mockService{
+		createGuestToken: func(opts GuestOptions) (*GuestToken, error) {
+			return &GuestToken{ID: "guest", Stream: opts.Stream, Role: opts.Role, Slot: opts.Slot}, nil
+		},
+	}
+
+	handler := CreateGuestTokenHandler(svc)
+
+	for _, hdrs := range []micro.Headers{
+		{"role": {"admin"}},
+		{"ttl": {"soon"}},
+		{"slot": {"two"}},
+	} {
+		r := &testRequest{headers: hdrs}
+		handler(context.Background(), r)
+		assert.Equal("400", r.code)
+	}
+
+	r := &testRequest{headers: micro.Headers{"stream": {"game"}, "role": {"player"}, "slot": {"2"}}}
+	handler(context.Background(), r)
+	assert.Empty(r.code)
+
+	var token *GuestToken
+	if err := json.Unmarshal(r.response, &token); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("game", token.Stream)
+	assert.Equal(GuestPlayer, token.Role)
+	assert.Equal(2, token.Slot)
+}
+
+func TestListStreamsHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := &mockService{
+		describeStreams: func() ([]*StreamManifest, error) {
+			return []*StreamManifest{
+				{
+					Name:      "game",
+					Transport: TransportRaw,
+					Status:    StreamStatusLive,
+					Video:     &VideoManifest{Codec: CodecH264, Codecs: []Codec{CodecH265, CodecH264}},
+					Audio:     &AudioManifest{Codec: CodecOpus},
+					Peers:     2,
+					MaxPeers:  4,
+				},
+				{
+					Name:      "camera",
+					Transport: TransportNV,
+					Status:    StreamStatusConnecting,
+				},
+			}, nil
+		},
+	}
+
+	handler := ListStreamsHandler(svc)
+
+	r := &testRequest{headers: micro.Headers{}}
+	handler(context.Background(), r)
+
+	var summaries []*StreamSummary
+	if err := json.Unmarshal(r.response, &summaries); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if !assert.Len(summaries, 2) {
+		return
+	}
+
+	assert.Equal(&StreamSummary{
+		Name:      "game",
+		Transport: TransportRaw,
+		Status:    StreamStatusLive,
+		Codecs:    []Codec{CodecH265, CodecH264, CodecOpus},
+		Peers:     2,
+		MaxPeers:  4,
+	}, summaries[0])
+
+	assert.Equal(StreamStatusConnecting, summaries[1].Status)
+	assert.Empty(summaries[1].Codecs)
+}
+
+// hangingService blocks its calls until their context is done.
+type hangingService struct {
+	Service
+}
+
+func (hangingService) DescribeStreams(ctx context.Context) ([]*StreamManifest, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRequestTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := withTimeout(50*time.Millisecond, ListStreamsHandler(hangingService{}))
+
+	r := &testRequest{headers: micro.Headers{}}
+
+	done := make(chan struct{})
+	go func() {
+		handler(r)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail("request not bounded by its timeout")
+		return
+	}
+
+	assert.Equal("417", r.code)
+	assert.Equal(context.DeadlineExceeded.Error(), r.description)
+}
+
+func TestDescribeStreamsHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := &mockService{
+		describeStreams: func() ([]*StreamManifest, error) {
+			return []*StreamManifest{{Name: "game"}, {Name: "camera"}}, nil
+		},
+	}
+
+	handler := DescribeStreamsHandler(svc)
+
+	r := &testRequest{headers: micro.Headers{"stream": {"camera"}}}
+	handler(context.Background(), r)
+
+	var manifests []*StreamManifest
+	if err := json.Unmarshal(r.response, &manifests); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if assert.Len(manifests, 1) {
+		assert.Equal("camera", manifests[0].Name)
+	}
+
+	r = &testRequest{headers: micro.Headers{"stream": {"unknown"}}}
+	handler(context.Background(), r)
+	assert.Equal("404", r.code)
+}
+
+func TestAddStreamHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := &mockService{
+		addStream: func(stream *Stream) (*StreamManifest, error) {
+			if stream.Name == "game" {
+				return nil, ErrStreamExists
+			}
+
+			return &StreamManifest{Name: stream.Name, Transport: stream.Transport}, nil
+		},
+	}
+
+	handler := AddStreamHandler(svc)
+
+	for _, data := range []string{
+		"",
+		"name: [",
+		`{"transport": "demo"}`,
+		`{"name": "camera"}`,
+		`{"name": "camera", "transport": "raw", "hooks": {"keyframe": ["touch", "/tmp/pwned"]}}`,
+	} {
+		r := &testRequest{data: []byte(data)}
+		handler(context.Background(), r)
+		assert.Equal("400", r.code, data)
+	}
+
+	r := &testRequest{data: []byte("name: game\ntransport: demo\n")}
+	handler(context.Background(), r)
+	assert.Equal("409", r.code)
+
+	r = &testRequest{data: []byte(`{"name": "camera", "transport": "demo"}`)}
+	handler(context.Background(), r)
+	assert.Empty(r.code)
+
+	var manifest *StreamManifest
+	if err := json.Unmarshal(r.response, &manifest); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("camera", manifest.Name)
+	assert.Equal(TransportDemo, manifest.Transport)
+}
+
+func TestAppBoxArtHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+
+	svc := &mockService{
+		appBoxArt: func(name string, appID int) ([]byte, error) {
+			if appID != 42 {
+				return nil, nvstream.ErrAppNotFound
+			}
+
+			return png, nil
+		},
+	}
+
+	handler := AppBoxArtHandler(svc)
+
+	r := &testRequest{headers: micro.Headers{"app": {"steam"}}}
+	handler(context.Background(), r)
+	assert.Equal("400", r.code)
+
+	r = &testRequest{headers: micro.Headers{"app": {"7"}}}
+	handler(context.Background(), r)
+	assert.Equal("404", r.code)
+
+	r = &testRequest{headers: micro.Headers{"app": {"42"}}}
+	handler(context.Background(), r)
+	assert.Empty(r.code)
+	assert.Equal(png, r.response)
+}
+
+func TestNVStreamStatsHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := &mockService{
+		nvstreamStats: func() ([]NVStreamStats, error) {
+			return []NVStreamStats{
+				{
+					Stream: "gamestream",
+					ConnectionStats: moonlight.ConnectionStats{
+						RTT:                12 * time.Millisecond,
+						PendingVideoFrames: 1,
+						Stages: []moonlight.StageTiming{
+							{Stage: moonlight.STAGE_RTSP_HANDSHAKE, Name: "RTSP handshake", Duration: 40 * time.Millisecond},
+						},
+					},
+				},
+			}, nil
+		},
+	}
+
+	handler := NVStreamStatsHandler(svc)
+
+	r := &testRequest{headers: micro.Headers{}}
+	handler(context.Background(), r)
+
+	var stats []map[string]any
+	if err := json.Unmarshal(r.response, &stats); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if !assert.Len(stats, 1) {
+		return
+	}
+
+	// The connection statistics are inlined.
+	assert.Equal("gamestream", stats[0]["stream"])
+	assert.Equal(float64(12*time.Millisecond), stats[0]["rtt"])
+	assert.Equal(float64(1), stats[0]["pending_video_frames"])
+	assert.Len(stats[0]["stages"], 1)
+}
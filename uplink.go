@@ -0,0 +1,167 @@
+package game
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+)
+
+// rtpReader is the remote track played on a device.
+type rtpReader interface {
+	ReadRTP() (*rtp.Packet, interceptor.Attributes, error)
+}
+
+// rtpWriter depacketizes a track into the container ffmpeg reads.
+type rtpWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+}
+
+// playTrack feeds the packets of track, in the container of the writer
+// newWriter returns, to an ffmpeg process reading them from its stdin,
+// until the track ends or the process exits.
+func playTrack(ffmpeg string, args []string, track rtpReader, newWriter func(io.Writer) (rtpWriter, error)) error {
+	pr, pw := io.Pipe()
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(ffmpeg, args...)
+	cmd.Stdin = pr
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+
+		// Fails the writes of a process gone.
+		pr.Close()
+		exited <- err
+	}()
+
+	err := writeTrack(pw, track, newWriter)
+	pw.Close()
+
+	if waitErr := <-exited; waitErr != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return errors.New("ffmpeg: " + string(msg))
+		}
+
+		return waitErr
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+		return nil
+	}
+
+	return err
+}
+
+func writeTrack(w io.Writer, track rtpReader, newWriter func(io.Writer) (rtpWriter, error)) error {
+	writer, err := newWriter(w)
+	if err != nil {
+		return err
+	}
+
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return err
+		}
+
+		// Packets that do not depacketize are skipped.
+		if err := writer.WriteRTP(packet); errors.Is(err, io.ErrClosedPipe) {
+			return err
+		}
+	}
+}
+
+// uplinkHandler plays the tracks the peer sends: Opus audio on the
+// microphone and video on the camera, each nil unless enabled and granted.
+func (peer *Peer) uplinkHandler(mic *Microphone, cam *Camera) func(*webrtc.TrackRemote, *webrtc.RTPReceiver) {
+	return func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		mimeType := track.Codec().MimeType
+
+		var (
+			device string
+			play   func() error
+		)
+
+		switch {
+		case track.Kind() == webrtc.RTPCodecTypeAudio && mic != nil:
+			if !strings.EqualFold(mimeType, webrtc.MimeTypeOpus) {
+				return
+			}
+
+			device = "microphone"
+			play = func() error {
+				return mic.play(peer.gateTrack(mic, track, receiver), track.Codec().Channels)
+			}
+
+		case track.Kind() == webrtc.RTPCodecTypeVideo && cam != nil:
+			device = "camera"
+			play = func() error {
+				// Decoding starts from a keyframe.
+				peer.WriteRTCP([]rtcp.Packet{
+					&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())},
+				})
+
+				return cam.play(track, mimeType)
+			}
+
+		default:
+			return
+		}
+
+		log := peer.log.With(
+			zap.String("track", device),
+			zap.String("mime", mimeType),
+		)
+
+		log.Info(device + " started")
+
+		if err := play(); err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		log.Info(device + " stopped")
+	}
+}
+
+// gateTrack returns the microphone track as the host should hear it, held
+// back by push-to-talk and the noise gate when configured.
+func (peer *Peer) gateTrack(mic *Microphone, track rtpReader, receiver *webrtc.RTPReceiver) rtpReader {
+	if !mic.PushToTalk && mic.Gate == nil {
+		return track
+	}
+
+	gated := &gatedTrack{
+		track: track,
+		gate:  mic.Gate,
+		now:   time.Now,
+	}
+
+	if mic.PushToTalk {
+		gated.talking = &peer.talking
+	}
+
+	for _, ext := range receiver.GetParameters().HeaderExtensions {
+		if ext.URI == audioLevelURI {
+			gated.level = uint8(ext.ID)
+		}
+	}
+
+	return gated
+}
@@ -0,0 +1,152 @@
+package game
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Watchdog restarts the source of a stream when one of its tracks receives
+// no samples for Timeout, e.g. after the source crashed or the encoder hung.
+// Raw streams restart the track's listener; NVStream streams resume the
+// connection.
+type Watchdog struct {
+	Timeout time.Duration
+}
+
+func (cfg *Watchdog) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	if raw.Timeout == 0 {
+		raw.Timeout = defaultWatchdogTimeout
+	}
+
+	cfg.Timeout = raw.Timeout
+
+	return nil
+}
+
+const defaultWatchdogTimeout = 10 * time.Second
+
+// stalledTrack returns the first track of the stream without a sample for
+// longer than timeout. Tracks count from since if their last sample is
+// older, so a restarted source gets a full timeout to deliver again.
+func stalledTrack(stream *Stream, since, now time.Time, timeout time.Duration) (Track, time.Duration) {
+	for _, track := range stream.tracks() {
+		last := track.health().LastSample()
+		if last.Before(since) {
+			last = since
+		}
+
+		if stalled := now.Sub(last); stalled > timeout {
+			return track, stalled
+		}
+	}
+
+	return nil, 0
+}
+
+// watchdog checks the stream's tracks until ctx ends, calling restart with
+// the stalled track.
+func (svc *service) watchdog(ctx context.Context, stream *Stream, restart func(ctx context.Context, track Track) error) {
+	timeout := stream.Watchdog.Timeout
+
+	log := svc.log.With(
+		zap.String("action", "watchdog"),
+		zap.String("stream", stream.Name),
+		zap.Duration("timeout", timeout),
+	)
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	since := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			// A lazy or idle stream without peers sends nothing.
+			if stream.standby() {
+				since = now
+				continue
+			}
+
+			track, stalled := stalledTrack(stream, since, now, timeout)
+			if track == nil {
+				continue
+			}
+
+			event := &StreamStalledEvent{
+				Stream:  stream.Name,
+				Track:   trackKind(track),
+				Stalled: stalled,
+			}
+
+			log.Warn("stream stalled",
+				zap.String("track", event.Track),
+				zap.Duration("stalled", stalled))
+
+			if err := restart(ctx, track); err != nil {
+				log.Error(err.Error())
+				event.Error = err.Error()
+			}
+
+			since = time.Now()
+
+			svc.events.Publish(EventStreamStalled, event)
+		}
+	}
+}
+
+// serve runs the track's listener, restarting it on every signal of
+// restart until ctx ends. It does not listen while paused.
+func (svc *service) serve(ctx context.Context, track Track, restart <-chan struct{}, paused *atomic.Bool) {
+	for {
+		// An idle stream listens again once restarted.
+		if paused.Load() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-restart:
+				continue
+			}
+		}
+
+		listenCtx, cancel := context.WithCancel(ctx)
+
+		done := make(chan struct{})
+		go func() {
+			svc.listen(listenCtx, track)
+			close(done)
+		}()
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			return
+
+		case <-restart:
+			cancel()
+			<-done
+		}
+	}
+}
+
+func trackKind(track Track) string {
+	if _, ok := track.(*AudioTrack); ok {
+		return "audio"
+	}
+
+	return "video"
+}
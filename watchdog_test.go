@@ -0,0 +1,36 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStalledTrack(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := &Stream{
+		Video: new(VideoTrack),
+		Audio: new(AudioTrack),
+	}
+
+	now := time.Now()
+	since := now.Add(-5 * time.Second)
+
+	track, _ := stalledTrack(stream, since, now, 10*time.Second)
+	assert.Nil(track)
+
+	stream.Video.lastSample.Store(now.Add(-time.Second).UnixNano())
+
+	track, stalled := stalledTrack(stream, since, now.Add(12*time.Second), 10*time.Second)
+	assert.Equal(stream.Video, track)
+	assert.Equal(13*time.Second, stalled)
+
+	stream.Video.lastSample.Store(now.Add(10 * time.Second).UnixNano())
+
+	track, stalled = stalledTrack(stream, since, now.Add(12*time.Second), 10*time.Second)
+	assert.Equal(stream.Audio, track)
+	assert.Equal(17*time.Second, stalled)
+	assert.Equal("audio", trackKind(track))
+}